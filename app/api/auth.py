@@ -0,0 +1,31 @@
+import hmac
+from typing import Optional
+from fastapi import Header, HTTPException
+
+from app.core.config import config, APIKeyPolicy
+
+
+def find_api_key(x_api_key: Optional[str]) -> Optional[APIKeyPolicy]:
+    """Get the policy of a configured API key, or None if the key is missing or not configured"""
+    if x_api_key:
+        for key, policy in config.api_keys.items():
+            if hmac.compare_digest(key.encode(), x_api_key.encode()):
+                return policy
+    return None
+
+
+async def require_api_key(x_api_key: Optional[str] = Header(None, description="API key")) -> Optional[APIKeyPolicy]:
+    """
+    Authenticate a request by its X-API-Key header
+
+    Returns:
+        The key's policy, or None when API key authentication is disabled
+    """
+    if not config.api_keys:
+        return None
+
+    policy = find_api_key(x_api_key)
+    if policy:
+        return policy
+
+    raise HTTPException(status_code=401, detail="Missing or invalid API key")
@@ -1,15 +1,65 @@
-from fastapi import FastAPI, HTTPException, Depends, Query, Body
+from fastapi import FastAPI, HTTPException, Depends, Query, Body, Header, Request
+from fastapi.encoders import jsonable_encoder
+from fastapi.exception_handlers import http_exception_handler, request_validation_exception_handler
+from fastapi.exceptions import RequestValidationError
 from fastapi.middleware.cors import CORSMiddleware
-from fastapi.responses import JSONResponse
+from fastapi.responses import JSONResponse, Response, StreamingResponse
+from starlette.background import BackgroundTask
+from starlette.exceptions import HTTPException as StarletteHTTPException
+from datetime import datetime, timezone
+import asyncio
+import functools
+import json
+import math
+import os
+import time
 from loguru import logger
 from typing import List, Dict, Any, Optional
 
-from app.core.config import config
-from app.services.openai_service import OpenAIService
-from app.services.snmp_service import SNMPService
-from app.services.mib_service import MIBService
-from app.models.query import SNMPQuery, SNMPResponse
-from app.utils.cache import get_cache, set_cache, clear_cache, get_cache_stats
+from app.core.config import config, APIKeyPolicy, ConfigError
+from app.api.auth import find_api_key, require_api_key
+from app.services.openai_service import (
+    OpenAIService, ClarificationNeeded, LLMRateLimited, LLMResponseError, LLM_RATE_LIMITED, LLM_BAD_RESPONSE,
+    LLM_CACHE_LOOKUPS
+)
+from app.services.snmp_service import (
+    SNMPService, MAINTENANCE_MODES, INVALID_QUERY, empty_reason, used_fallback, fast_fail
+)
+from app.services.mib_service import (
+    MIBService, MIBConflictError, UnresolvedNameError, OID_STYLES, DEFAULT_OID_STYLE, UNKNOWN_OID_NAME, normalize_oid
+)
+from app.services.mib_repository import MIBRepository, DownloadProgress, default_source, invalid_modules
+from app.services.poller_service import PollerService
+from app.services.device_service import DeviceService
+from app.services.interface_service import InterfaceService
+from app.services.cost_service import CostService
+from app.services.macro_service import MacroService, MacroError
+from app.services.subscription_service import SubscriptionService, SubscriptionError
+from app.services.operation_service import (
+    OperationRegistry, OperationCancelled, DuplicateOperationError, cancel_on_disconnect
+)
+from app.services.page_service import PageStore, paginate, parse_cursor
+from app.services.plan_service import PlanStore
+from app.services.session_service import SessionStore, context_prompt
+from app.services.query_transforms import QueryRejectedError, apply_query_transforms, register_query_transform
+from app.simulator import SNMPSimulator, DEMO_TARGET, demo_target_transform, load_snmprec
+from app.models.query import (
+    SNMPQuery, SNMPResponse, MultiTargetQuery, MultiTargetResponse, PlanResponse, ExecutePlanRequest, EMPTY_ALL_FILTERED
+)
+from app.utils.cache import get_cache, get_cache_entry, set_cache, clear_cache, get_cache_stats
+from app.utils.etag import compute_etag, etag_matches
+from app.utils.export import EXPORT_MEDIA_TYPES, export_filename, iter_export, iter_json
+from app.utils.expressions import ExpressionError, parse_computed_fields, compute_fields
+from app.utils.metrics import registry as metrics_registry
+from app.utils.openmetrics import to_openmetrics, OPENMETRICS_MEDIA_TYPE
+from app.utils.problems import PROBLEM_MEDIA_TYPE, PROBLEM_TYPES, build_problem, problem_status
+from app.utils.rate_limit import RateLimiter, client_ip, endpoint_class, parse_networks
+from app.utils.redaction import scrub_secrets, scrub_detail, scrub_log_record
+from app.utils.msgpack_codec import encode_msgpack, prefers_msgpack, MSGPACK_MEDIA_TYPE
+from app.utils.targets import TargetError, format_target
+from app.utils.timestamps import parse_timezone, reformat_timestamp
+from app.utils.timing import StageTimer
+from app.utils.value_filters import FilterError, parse_value_filters, passes_filters
 
 # Initialize application
 app = FastAPI(
@@ -27,48 +77,699 @@ app.add_middleware(
     allow_headers=["*"],
 )
 
+# Scrub registered secrets (community strings, passphrases, keys) from every log message
+logger.configure(patcher=scrub_log_record)
+
+
 # Initialize services
-openai_service = OpenAIService()
 mib_service = MIBService()
+openai_service = OpenAIService(mib_service=mib_service)
 snmp_service = SNMPService(mib_service=mib_service)
+poller_service = PollerService(snmp_service=snmp_service)
+device_service = DeviceService(snmp_service=snmp_service)
+interface_service = InterfaceService(snmp_service=snmp_service)
+cost_service = CostService(snmp_service=snmp_service)
+macro_service = MacroService(snmp_service=snmp_service)
+subscription_service = SubscriptionService(snmp_service=snmp_service)
+operation_registry = OperationRegistry()
+plan_store = PlanStore(config.plan_ttl, config.plan_max)
+page_store = PageStore(config.page_ttl, config.page_max)
+session_store = SessionStore(config.session_ttl, config.session_max, config.session_max_results)
+demo_simulator: Optional[SNMPSimulator] = None
+mib_download: Optional[DownloadProgress] = None  # The running or last MIB repository update
+mib_download_task: Optional[asyncio.Task] = None
+ip_rate_limiter = RateLimiter(config.rate_limit.per_ip, config.rate_limit.ip_burst, config.rate_limit.max_clients)
+# Per client limits of each endpoint class (see ENDPOINT_CLASSES)
+endpoint_rate_limiters = {
+    "query": RateLimiter(config.rate_limit.query_per_minute, config.rate_limit.query_burst,
+                         config.rate_limit.max_clients),
+    "mib": RateLimiter(config.rate_limit.mib_per_minute, config.rate_limit.mib_burst, config.rate_limit.max_clients),
+}
+trusted_proxies = parse_networks(config.rate_limit.trusted_proxies)
+
+# Resolve the target of a streamed interpretation while the rest of it arrives
+if config.snmp.preflight_dns:
+    openai_service.on_target = snmp_service.resolve_target
+
+REQUEST_TIMEOUT = "REQUEST_TIMEOUT"
+NEEDS_CLARIFICATION = "NEEDS_CLARIFICATION"
+INVALID_PARAMETERS = "INVALID_PARAMETERS"
+# The query couldn't be interpreted at all, or a query transform rejected it
+QUERY_NOT_UNDERSTOOD = "QUERY_NOT_UNDERSTOOD"
+QUERY_REJECTED = "QUERY_REJECTED"
+OID_NOT_FOUND = "OID_NOT_FOUND"
+# An unexpected failure, with the error it raised as the message
+INTERNAL_ERROR = "INTERNAL_ERROR"
+MIB_NOT_FOUND = "MIB_NOT_FOUND"
+
+# Why a response was not cached
+CACHE_ENTRY_TOO_LARGE = "entry-too-large"
+
+# Commands GET /query/stream runs; a GET request must never write
+STREAM_COMMANDS = ("WALK", "BULK", "WALKTABLE", "GET", "GETNEXT")
+
+
+def render(content: Dict[str, Any], accept: Optional[str], status_code: int = 200,
+           headers: Optional[Dict[str, str]] = None) -> Response:
+    """
+    Render a response body as JSON, or as MessagePack when the Accept header prefers it
+
+    JSON bodies with more than STREAM_JSON_THRESHOLD results are encoded and sent a
+    chunk at a time, so the encoded body is never held in memory as a whole (the
+    content itself still is).
+    """
+    if prefers_msgpack(accept):
+        return Response(content=encode_msgpack(content), status_code=status_code,
+                        headers=headers, media_type=MSGPACK_MEDIA_TYPE)
+    if len(content.get("results") or []) > config.stream_json_threshold:
+        return StreamingResponse(iter_json(content), status_code=status_code,
+                                 headers=headers, media_type="application/json")
+    return JSONResponse(content=content, status_code=status_code, headers=headers)
+
+
+def problem_response(status_code: int, detail: Optional[str], error_code: Optional[str] = None,
+                     headers: Optional[Dict[str, str]] = None, **extensions: Any) -> Response:
+    """
+    Render an error as RFC 7807 problem details
+
+    The problem's instance is logged with the error, to correlate client reports with the logs.
+    """
+    problem = build_problem(status_code, detail, config.problem_type_base, error_code=error_code, **extensions)
+    logger.info(f"Problem {problem['instance']}: {status_code} {problem['title']}: {detail}")
+    return JSONResponse(content=jsonable_encoder(problem), status_code=status_code,
+                        headers=headers, media_type=PROBLEM_MEDIA_TYPE)
+
+
+def render_error(content: Dict[str, Any], accept: Optional[str], legacy_status_code: int = 200,
+                 headers: Optional[Dict[str, str]] = None) -> Response:
+    """
+    Render a failed query response as problem details, with the status of its error code (502 without one)
+
+    The query, any debug output and the details of a refused SET are kept as
+    extension members. With API_ERROR_FORMAT=legacy the response body itself is
+    rendered, with legacy_status_code.
+    """
+    if config.error_format != "problem":
+        return render(content, accept, status_code=legacy_status_code, headers=headers)
+    return problem_response(problem_status(content.get("error_code")), content["error"],
+                            error_code=content.get("error_code"), headers=headers,
+                            query=content.get("query"), debug=content.get("debug"),
+                            set_error=(content.get("raw_data") or {}).get("set_error"))
+
+
+class APIError(HTTPException):
+    """
+    An HTTP error with a machine-readable code, for clients to tell failures apart
+
+    Rendered as problem details with the code in error_code and each of the details
+    as an extension member. Errors raised as a plain HTTPException get the code of
+    their status instead (see STATUS_ERROR_CODES).
+    """
+
+    def __init__(self, status_code: int, error_code: str, message: str,
+                 headers: Optional[Dict[str, str]] = None, **details: Any):
+        super().__init__(status_code=status_code, detail={"message": message, "error_code": error_code, **details},
+                         headers=headers)
+        self.error_code = error_code
+        self.message = message
+        self.details = details
+
+
+@app.exception_handler(StarletteHTTPException)
+async def handle_http_exception(request: Request, exc: StarletteHTTPException):
+    """
+    Return HTTP errors as problem details, with registered secrets scrubbed from their detail
+
+    A dict detail gives the problem's detail in its "message" and its error code in
+    "error_code"; its other entries become extension members. In the legacy format,
+    an APIError without details keeps the plain {"detail": message} body.
+    """
+    if isinstance(exc, APIError) and not exc.details and config.error_format != "problem":
+        exc.detail = exc.message
+    exc.detail = scrub_detail(exc.detail)
+    if config.error_format != "problem":
+        return await http_exception_handler(request, exc)
+
+    detail, extensions = exc.detail, {}
+    if isinstance(detail, dict):
+        extensions = dict(detail)
+        detail = extensions.pop("message", None)
+    return problem_response(exc.status_code, detail, headers=getattr(exc, "headers", None), **extensions)
+
+
+def request_client_ip(request: Request) -> str:
+    """The address of a request's client; behind TRUSTED_PROXIES, the one they report in X-Forwarded-For"""
+    return client_ip(request.client.host if request.client else None,
+                     ",".join(request.headers.getlist("x-forwarded-for")), trusted_proxies)
+
+
+def too_many_requests(detail: str, retry_after: float) -> Response:
+    """Answer 429 with a Retry-After header in whole seconds"""
+    headers = {"Retry-After": str(math.ceil(retry_after))}
+    detail = f"{detail}; retry after {headers['Retry-After']}s"
+    if config.error_format != "problem":
+        return JSONResponse(content={"detail": detail}, status_code=429, headers=headers)
+    return problem_response(429, detail, headers=headers)
+
+
+# Registered before the per-IP limit, so it runs after it
+@app.middleware("http")
+async def limit_requests_per_endpoint(request: Request, call_next):
+    """
+    Answer 429 with Retry-After to clients over the limit of the endpoint's class: RATE_LIMIT_QUERY
+    for the endpoints interpreting queries with the LLM, RATE_LIMIT_MIB for the MIB and OID lookups
+
+    Clients sending a valid API key are limited per key, wherever they connect from; others by address.
+    """
+    endpoint = endpoint_class(request.url.path)
+    limiter = endpoint_rate_limiters.get(endpoint) if endpoint else None
+    if limiter and limiter.enabled:
+        policy = find_api_key(request.headers.get("x-api-key"))
+        client = f"API key {policy.name}" if policy else request_client_ip(request)
+        # Keys are told apart by their digest, as several may share a name
+        retry_after = limiter.acquire(f"API key {policy.identity()}" if policy else client)
+        if retry_after is not None:
+            logger.warning(f"Rate limited {endpoint} requests from {client}")
+            return too_many_requests(f"Too many {endpoint} requests from {client}", retry_after)
+    return await call_next(request)
+
+
+@app.middleware("http")
+async def limit_requests_per_ip(request: Request, call_next):
+    """
+    Answer 429 with Retry-After to clients over RATE_LIMIT_PER_IP, before anything else runs
+
+    Clients are told apart by address; behind TRUSTED_PROXIES, by the address those
+    proxies report in X-Forwarded-For.
+    """
+    if ip_rate_limiter.enabled:
+        ip = request_client_ip(request)
+        retry_after = ip_rate_limiter.acquire(ip)
+        if retry_after is not None:
+            logger.warning(f"Rate limited requests from {ip}")
+            return too_many_requests(f"Too many requests from {ip}", retry_after)
+    return await call_next(request)
+
+
+@app.exception_handler(RequestValidationError)
+async def handle_validation_error(request: Request, exc: RequestValidationError):
+    """Return request validation errors as problem details listing the invalid parameters"""
+    if config.error_format != "problem":
+        return await request_validation_exception_handler(request, exc)
+    return problem_response(422, "Request parameters failed validation", error_code=INVALID_PARAMETERS,
+                            errors=exc.errors())
+
+
+def llm_error(error: Exception) -> APIError:
+    """The HTTP error of an interpretation the LLM failed: 429 when rate limited, with Retry-After if known, else 502"""
+    if isinstance(error, LLMRateLimited):
+        headers = {"Retry-After": str(math.ceil(error.retry_after))} if error.retry_after else None
+        return APIError(429, LLM_RATE_LIMITED, str(error), headers=headers)
+    return APIError(502, LLM_BAD_RESPONSE, f"Could not interpret the query: {error}")
+
+
+def unresolved_name(error: UnresolvedNameError) -> APIError:
+    """The 404 of an OID name no loaded MIB defines, with the name and the MIB to load, if known"""
+    return APIError(404, UNKNOWN_OID_NAME, str(error), name=error.name, suggested_mib=error.mib)
+
+
+# Errors of interpreting a query, answered as interpretation_error says
+INTERPRETATION_ERRORS = (ClarificationNeeded, QueryRejectedError, LLMRateLimited, LLMResponseError, UnresolvedNameError)
+
+
+def interpretation_error(error: Exception) -> Optional[APIError]:
+    """The HTTP error the query endpoints answer a failed interpretation with; None for unexpected errors"""
+    if isinstance(error, APIError):
+        return error
+    if isinstance(error, ClarificationNeeded):
+        return APIError(422, NEEDS_CLARIFICATION, "Query needs clarification",
+                        clarification=error.clarification.dict())
+    if isinstance(error, QueryRejectedError):
+        return APIError(400, QUERY_REJECTED, f"Query rejected: {str(error)}")
+    if isinstance(error, (LLMRateLimited, LLMResponseError)):
+        return llm_error(error)
+    if isinstance(error, UnresolvedNameError):
+        return unresolved_name(error)
+    return None
+
+
+def render_download(content: Dict[str, Any], export_format: str, target: Optional[str],
+                    headers: Optional[Dict[str, str]] = None) -> Response:
+    """
+    Render a response body as a file download (CSV of the results, or the JSON body), streamed in chunks
+    """
+    filename = export_filename(target, export_format)
+    return StreamingResponse(
+        iter_export(content, export_format),
+        media_type=EXPORT_MEDIA_TYPES[export_format],
+        headers={**(headers or {}), "Content-Disposition": f'attachment; filename="{filename}"'}
+    )
+
+
+def enforce_request_timeout(handler):
+    """
+    Bound a query handler by API_REQUEST_TIMEOUT, cancelling its in-flight SNMP and LLM work when exceeded
+
+    The handler's signature is kept, so FastAPI still resolves its parameters.
+    """
+    @functools.wraps(handler)
+    async def with_timeout(*args, **kwargs):
+        if config.request_timeout <= 0:
+            return await handler(*args, **kwargs)
+        try:
+            return await asyncio.wait_for(handler(*args, **kwargs), timeout=config.request_timeout)
+        except asyncio.TimeoutError:
+            error = f"Request exceeded the {config.request_timeout:g}s request timeout"
+            logger.warning(f"{error}: {kwargs.get('query')}")
+            timeout_response = SNMPResponse(
+                raw_data={},
+                summary=f"Error: {error}",
+                query=kwargs.get("query") or "",
+                error=error,
+                error_code=REQUEST_TIMEOUT
+            )
+            return render_error(timeout_response.dict(), kwargs.get("accept"), legacy_status_code=504)
+
+    return with_timeout
+
+
+@app.on_event("startup")
+async def check_config():
+    """Stop at startup on settings that would only fail later, when uvicorn runs the app directly"""
+    try:
+        config.validate_settings()
+    except ConfigError as e:
+        logger.critical(f"Invalid configuration: {e}")
+        raise
+
+
+@app.on_event("startup")
+async def start_poller():
+    """Start the background poller"""
+    poller_service.start()
+
+
+@app.on_event("shutdown")
+async def stop_poller():
+    """Stop the background poller"""
+    await poller_service.stop()
+
+
+@app.on_event("startup")
+async def start_demo_simulator():
+    """In demo mode, start the SNMP simulator and point queries for the "demo" target at it"""
+    global demo_simulator
+    if not config.demo.enabled:
+        return
+
+    data = load_snmprec(config.demo.data_file) if config.demo.data_file else None
+    demo_simulator = SNMPSimulator(data, community=config.snmp.default_community)
+    host, port = await demo_simulator.start(config.demo.host, config.demo.port)
+    register_query_transform(demo_target_transform(host, port))
+    logger.info(f"Demo mode: queries for target '{DEMO_TARGET}' go to the simulator on {host}:{port}")
+
+
+@app.on_event("shutdown")
+async def close_snmp_connections():
+    """Close the sockets kept open to SNMP targets"""
+    if snmp_service.pool:
+        snmp_service.pool.close()
+
+
+@app.on_event("shutdown")
+async def stop_demo_simulator():
+    """Stop the SNMP simulator started in demo mode"""
+    if demo_simulator:
+        demo_simulator.close()
+
+
+@app.on_event("startup")
+async def warm_up_interpretations():
+    """Reload the LLM interpretations saved by the last run, dropping the stale ones from the file"""
+    if openai_service.interpretation_cache is not None:
+        openai_service.warm_up_interpretations()
+        openai_service.interpretation_cache.save()
+
+
+@app.on_event("shutdown")
+async def save_interpretations():
+    """Save the most recently used LLM interpretations for the next run"""
+    if openai_service.interpretation_cache is not None:
+        openai_service.interpretation_cache.save()
 
 
 @app.get("/")
 async def root():
     """Health check endpoint"""
-    return {"status": "online", "app_name": config.app_name}
+    return {"status": "online", "app_name": config.app_name, "maintenance_mode": snmp_service.maintenance_mode}
+
+
+@app.get("/problems")
+async def list_problem_types():
+    """List the problem types of error responses"""
+    return {"problems": [
+        {"type": f"{config.problem_type_base}{name}", **problem_type._asdict()}
+        for name, problem_type in PROBLEM_TYPES.items()
+    ]}
+
+
+@app.get("/problems/{name}")
+async def get_problem_type(name: str):
+    """Describe a problem type, the target of the type URI of error responses"""
+    problem_type = PROBLEM_TYPES.get(name)
+    if not problem_type:
+        raise HTTPException(status_code=404, detail=f"Unknown problem type: {name}")
+    return {"type": f"{config.problem_type_base}{name}", **problem_type._asdict()}
+
+
+@app.get("/check/{host}")
+async def check_device(
+    host: str,
+    port: Optional[int] = Query(None, description="SNMP port"),
+    community: Optional[str] = Query(None, description="Community string"),
+    version: Optional[str] = Query(None, description="SNMP version (1, 2c)"),
+    fast: bool = Query(False, description="Fail fast: one attempt with a short timeout"),
+    api_key: Optional[APIKeyPolicy] = Depends(require_api_key)
+):
+    """
+    Check that a device answers SNMP and identify its vendor and model from sysObjectID
+    """
+    try:
+        # Asks the device every time: a cached identification would report a device that went down as reachable
+        return await device_service.identify(host, port=port, community=community, version=version,
+                                             api_key=api_key, fast=fast, use_cache=False)
+    except TargetError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+    except Exception as e:
+        logger.error(f"Error checking device: {e}")
+        raise APIError(500, INTERNAL_ERROR, f"Error checking device: {str(e)}")
 
 
 @app.post("/query")
+@enforce_request_timeout
 async def process_query(
+    request: Request,
     query: str = Body(..., description="Natural language SNMP query"),
-    skip_cache: bool = Query(False, description="Skip cache lookup")
+    skip_cache: bool = Query(False, description="Skip cache lookup"),
+    max_age: Optional[int] = Query(None, ge=0, description="Maximum age in seconds of a cached response"),
+    debug: bool = Query(False, description="Include the SNMP request details (requires DEBUG mode)"),
+    include_device: bool = Query(False, description="Include the target's vendor and model"),
+    resolve_if_names: bool = Query(False, description="Label interface table results with ifName and ifAlias"),
+    include_plan: bool = Query(True, description="Include the interpreted query, with secrets omitted"),
+    group_by_oid: bool = Query(False, description="Include the result names under each requested OID"),
+    tz: Optional[str] = Query(
+        None, alias="timezone", description="Timezone for timestamps: UTC, an offset like +05:30 or a name like Europe/Berlin"
+    ),
+    time_format: Optional[str] = Query(None, description="Timestamp format: rfc3339 (default), unix or a strftime pattern"),
+    oid_style: str = Query(
+        DEFAULT_OID_STYLE, description="Result names: numeric, short (ifInOctets.3), module (IF-MIB::ifInOctets.3) or full path"
+    ),
+    compute: Optional[List[str]] = Query(
+        None, description="Computed field as name=expression, e.g. utilization=ifInOctets*8/ifSpeed"
+    ),
+    where: Optional[List[str]] = Query(
+        None, description="Value filter as field<operator>number, e.g. ifInErrors>0 (>, <, >=, <=, == or !=)"
+    ),
+    stale_if_error: bool = Query(False, description="Return the last cached result, flagged stale, if the device fails"),
+    fast: bool = Query(False, description="Fail fast: send each SNMP request once, with a short timeout"),
+    dry_run: bool = Query(False, description="Interpret the query and estimate its cost without running it"),
+    download: bool = Query(False, description="Return the results as a file to save"),
+    export_format: str = Query("json", alias="format", description="Download format: csv or json"),
+    limit: Optional[int] = Query(None, ge=1, description="Return at most this many results, as one page"),
+    offset: int = Query(0, ge=0, description="Number of results to skip before the page"),
+    cursor: Optional[str] = Query(None, description="next_cursor of the previous page, to fetch the next one"),
+    if_none_match: Optional[str] = Header(None, description="ETag of the client's current copy"),
+    accept: Optional[str] = Header(None, description="application/msgpack for a MessagePack response"),
+    x_operation_id: Optional[str] = Header(None, description="ID to cancel the query by (assigned if not given)"),
+    x_session_id: Optional[str] = Header(None, description="Conversation the query belongs to, chosen by the client"),
+    api_key: Optional[APIKeyPolicy] = Depends(require_api_key)
 ):
     """
     Process a natural language SNMP query
+
+    Responses carry an ETag derived from the interpreted query and the SNMP data;
+    a matching If-None-Match returns 304 Not Modified. Keys restricted to OID
+    subtrees are never served from the cache, which is shared between keys.
+    The body is JSON unless the Accept header prefers application/msgpack.
+    With stale_if_error, a failed live query returns the last cached result
+    (even if expired) flagged as stale with its age, and the failing host is
+    not retried for NEGATIVE_CACHE_TTL seconds while that result exists.
+    An ambiguous query returns a clarification (what is missing and questions
+    to ask) instead of results; resubmit a more specific query to run it.
+    With download, successful results are returned as an attachment named after
+    the target and time, in the CSV or JSON format.
+    With fast, each SNMP request is sent once with the short SNMP_FAST_TIMEOUT,
+    for a quick failure when the device doesn't answer.
+    A dry run interprets and validates the query and returns its plan with an
+    estimate of its cost (SNMP round trips, LLM tokens, latency) instead of results.
+    A request still running after API_REQUEST_TIMEOUT seconds is abandoned and
+    returns 504 with the REQUEST_TIMEOUT error code.
+    Each where filter keeps only the results of its field whose value compares
+    true to its number; values that aren't numbers are left out.
+    Queries sent with the same X-Session-ID form a conversation: the results of
+    the previous query are given to the interpreter, so a follow-up like "get the
+    third one" refers to them. Queries in a conversation bypass the cache.
+    With limit, only a page of the results is returned, from offset on, with the
+    total and the cursor of the next page. The whole results are kept for PAGE_TTL
+    seconds, so a cursor fetches its page without running the query again.
     """
     try:
         logger.info(f"Received query: {query}")
+        # The device details and interface labels are cached with the response
+        cache_key = openai_service.cache_key(query, include_device=include_device, label_interfaces=resolve_if_names)
+
+        if debug and not config.debug:
+            raise HTTPException(status_code=403, detail="Debug output is disabled on this server")
+
+        if download and export_format not in EXPORT_MEDIA_TYPES:
+            raise HTTPException(
+                status_code=400,
+                detail=f"Unsupported download format: {export_format} (use {' or '.join(EXPORT_MEDIA_TYPES)})"
+            )
+
+        try:
+            computed_fields = parse_computed_fields(compute or [])
+        except ExpressionError as e:
+            raise HTTPException(status_code=400, detail=f"Invalid computed field: {str(e)}")
+
+        try:
+            value_filters = parse_value_filters(where or [])
+        except FilterError as e:
+            raise HTTPException(status_code=400, detail=f"Invalid filter: {str(e)}")
+
+        try:
+            output_tz = parse_timezone(tz) if tz else None
+        except ValueError as e:
+            raise HTTPException(status_code=400, detail=str(e))
+
+        if oid_style not in OID_STYLES:
+            raise HTTPException(status_code=400, detail=f"Unsupported OID style: {oid_style} (use {', '.join(OID_STYLES)})")
+
+        if download and (limit or cursor):
+            raise HTTPException(status_code=400, detail="Downloads can't be paginated")
+
+        # Later pages are cut from the stored results, as rendered for the first one
+        if cursor:
+            try:
+                token, page_offset = parse_cursor(cursor)
+            except ValueError as e:
+                raise HTTPException(status_code=400, detail=str(e))
+            stored = page_store.get(token, api_key)
+            if stored is None:
+                raise HTTPException(status_code=404, detail="Unknown or expired cursor")
+            if stored.query != query:
+                raise HTTPException(status_code=400, detail="The cursor belongs to another query")
+            return render(paginate(stored.content, page_offset, limit or stored.limit, token), accept)
+
+        def filter_values(content: Dict[str, Any]) -> Dict[str, Any]:
+            # Leave out the results the value filters reject, and their raw values and group entries
+            if not value_filters or not content.get("results"):
+                return content
+            kept, dropped = [], set()
+            for result in content["results"]:
+                if passes_filters(result, value_filters):
+                    kept.append(result)
+                else:
+                    dropped.update(key for key in (result.get("name"), result.get("oid")) if key)
+            # Copies, as a cached response shares these with the cache
+            content["results"] = kept
+            content["raw_data"] = {key: value for key, value in content["raw_data"].items() if key not in dropped}
+            if content.get("groups"):
+                content["groups"] = {
+                    oid: [name for name in names if name not in dropped] for oid, names in content["groups"].items()
+                }
+            if not kept:
+                content["empty_reason"] = EMPTY_ALL_FILTERED
+            return content
+
+        def format_times(content: Dict[str, Any]) -> Dict[str, Any]:
+            # Timestamps are stored as RFC 3339; convert the cache time and DateAndTime values
+            if not (output_tz or time_format):
+                return content
+            content["cached_at"] = reformat_timestamp(content.get("cached_at"), output_tz, time_format)
+            # Copies, as a cached response shares these with the cache
+            content["raw_data"] = dict(content["raw_data"])
+            content["results"] = [dict(result) for result in content.get("results") or []]
+            for result in content["results"]:
+                if result.get("oid") and mib_service.is_date_and_time(result["oid"]):
+                    result["value"] = reformat_timestamp(result["value"], output_tz, time_format)
+                    for key in (result.get("name"), result["oid"]):
+                        if key in content["raw_data"]:
+                            content["raw_data"][key] = result["value"]
+            return content
+
+        def style_names(content: Dict[str, Any]) -> Dict[str, Any]:
+            # Names are stored module-qualified; rewrite them, and the groups listing them, in the requested style
+            if oid_style == "module":
+                return content
+            renamed = {}
+            results = []
+            for result in content.get("results") or []:
+                name = mib_service.format_name(result.get("oid"), result.get("name"), oid_style)
+                renamed[result.get("name") or result.get("oid")] = name or result.get("oid")
+                # Copies, as a cached response shares these with the cache
+                results.append({**result, "name": name})
+            content["results"] = results
+            if content.get("groups"):
+                content["groups"] = {
+                    oid: [renamed.get(name, name) for name in names] for oid, names in content["groups"].items()
+                }
+            return content
+
+        def representation_etag(data_etag: str) -> str:
+            # Every option that changes the body, down to its media type, is part of its ETag
+            media_type = EXPORT_MEDIA_TYPES[export_format] if download else (
+                MSGPACK_MEDIA_TYPE if prefers_msgpack(accept) else "application/json"
+            )
+            etag = compute_etag(
+                data_etag, compute, oid_style, where, tz, time_format, include_plan, group_by_oid, media_type
+            )
+            # Each page is a representation of its own
+            return compute_etag(etag, offset, limit) if limit else etag
+
+        def render_results(content: Dict[str, Any], target: Optional[str], headers: Dict[str, str]) -> Response:
+            if download:
+                return render_download(content, export_format, target, headers=headers)
+            if limit:
+                token = page_store.store(content, query, limit, api_key)
+                content = paginate(content, offset, limit, token)
+            return render(content, accept, headers=headers)
+
+        def render_cached(cached: Dict[str, Any], cached_at: float, stale: bool = False) -> Response:
+            return render_results(
+                style_names(format_times(filter_values({
+                    **cached["response"],
+                    "plan": cached["response"].get("plan") if include_plan else None,
+                    "groups": cached["response"].get("groups") if group_by_oid else None,
+                    "computed": compute_fields(cached["response"]["raw_data"], computed_fields)
+                    if computed_fields else None,
+                    "cached": True,
+                    "cached_at": datetime.fromtimestamp(cached_at, timezone.utc).isoformat(),
+                    "stale": stale,
+                    "age": int(time.time() - cached_at) if stale else None
+                }))),
+                ((cached["response"].get("plan") or {}).get("target") or {}).get("host"),
+                headers={"ETag": representation_etag(cached["etag"]), "Vary": "Accept"}
+            )
+
+        # The cache is shared between keys, so restricted keys never fall back to it
+        use_stale = stale_if_error and not (api_key and api_key.oid_prefixes)
+
+        # Debug responses describe a live request and dry runs don't run one, so they bypass the cache;
+        # in a conversation, the same query text can mean something else
+        if debug or dry_run or x_session_id or (api_key and api_key.oid_prefixes):
+            skip_cache = True
 
         # Check cache
         if not skip_cache:
-            cache_key = f"query_{hash(query)}"
-            cached_response = get_cache(cache_key)
-            if cached_response:
+            cached_entry = get_cache_entry(cache_key, max_age=max_age)
+            LLM_CACHE_LOOKUPS.inc(cache="response", result="hit" if cached_entry else "miss")
+            if cached_entry:
+                cached, cached_at = cached_entry
+                etag = representation_etag(cached["etag"])
+                if etag_matches(if_none_match, etag):
+                    return Response(status_code=304, headers={"ETag": etag, "Vary": "Accept"})
+
                 logger.info(f"Returning cached response for query: {query}")
-                return cached_response
+                return render_cached(cached, cached_at)
+
+        # Stage timings are only collected for debug responses
+        timer = StageTimer() if debug else None
 
-        # Process query with OpenAI
-        snmp_query = await openai_service.process_query(query)
+        # Process query with OpenAI, letting it correct an interpretation that fails validation
+        session = session_store.get(x_session_id, api_key) if x_session_id else None
+        snmp_query, correction = await openai_service.interpret(
+            query, validate=snmp_service.correctable_error, context=context_prompt(session) if session else None,
+            api_key=api_key
+        )
 
         if not snmp_query:
-            raise HTTPException(status_code=400, detail="Failed to parse query")
+            raise APIError(400, QUERY_NOT_UNDERSTOOD, "Failed to parse query")
 
         # Store original query
         snmp_query.raw_query = query
+        if fast:
+            fast_fail(snmp_query)
+
+        # Symbolic OIDs must name objects of loaded MIBs before anything is sent
+        snmp_service.resolve_names(snmp_query.operation)
+
+        if dry_run:
+            # Rejected like the dry run of /query/multi
+            validation_error = snmp_service.validate_query(snmp_query, api_key=api_key)
+            if validation_error:
+                raise APIError(400, INVALID_QUERY, validation_error)
+            estimate = cost_service.estimate(snmp_query)
+            dry_run_response = SNMPResponse(
+                raw_data={},
+                summary=f"Dry run: about {estimate.snmp_round_trips} SNMP requests for {estimate.varbinds} values, "
+                        f"taking about {estimate.latency_seconds:g}s",
+                query=query,
+                plan=snmp_query.plan() if include_plan else None,
+                correction=correction,
+                estimate=estimate
+            )
+            return render(dry_run_response.dict(), accept)
+        if timer:
+            timer.mark("interpretation")
 
-        # Execute SNMP query
-        snmp_response_data = await snmp_service.execute_query(snmp_query)
+        request_debug = None
+        effective = {} if debug else None
+        if debug:
+            request_debug = snmp_service.describe_request(snmp_query)
+            logger.debug(f"SNMP request: {request_debug}")
+
+        # Don't retry a host that just failed while a last-known-good result exists
+        down_key = f"down_{format_target(snmp_query.target.host, snmp_query.target.port)}"
+        stale_entry = get_cache_entry(cache_key, allow_stale=True) if use_stale else None
+        if stale_entry and get_cache(down_key):
+            logger.info(f"Returning stale response for query, {snmp_query.target.host} recently failed")
+            return render_cached(*stale_entry, stale=True)
+
+        # Execute SNMP query, cancellable with DELETE /operations/{id} and stopped if the client disconnects
+        operation = operation_registry.start("query", query, operation_id=x_operation_id, api_key=api_key)
+        watcher = None
+        if config.disconnect_check_interval > 0:
+            watcher = asyncio.create_task(
+                cancel_on_disconnect(operation, request.is_disconnected, config.disconnect_check_interval)
+            )
+        try:
+            snmp_response_data = await operation.run(
+                snmp_service.execute_query(snmp_query, api_key=api_key, timer=timer, effective=effective)
+            )
+        finally:
+            if watcher:
+                watcher.cancel()
+            operation_registry.finish(operation)
+        operation_headers = {"X-Operation-ID": operation.id}
+        if x_session_id:
+            operation_headers["X-Session-ID"] = x_session_id
+
+        if "error" in snmp_response_data and use_stale:
+            set_cache(down_key, snmp_response_data["error"], ttl=config.negative_cache_ttl)
+            if stale_entry:
+                logger.warning(f"Returning stale response for query: {snmp_response_data['error']}")
+                return render_cached(*stale_entry, stale=True)
 
         # Format response
         if "error" in snmp_response_data:
@@ -76,43 +777,618 @@ async def process_query(
                 raw_data=snmp_response_data,
                 summary=f"Error: {snmp_response_data['error']}",
                 query=query,
-                error=snmp_response_data["error"]
+                error=snmp_response_data["error"],
+                error_code=snmp_response_data.get("error_code")
             )
         else:
+            # Unchanged data: skip the summary entirely
+            data_etag = compute_etag(snmp_query.operation.dict(), snmp_query.target.dict(), snmp_response_data)
+            etag = representation_etag(data_etag)
+            if etag_matches(if_none_match, etag):
+                return Response(status_code=304, headers={"ETag": etag, "Vary": "Accept"})
+
             # Use OpenAI to generate a summary
-            formatted_response = await openai_service.format_response(snmp_response_data, query)
+            formatted_response = await openai_service.format_response(
+                snmp_response_data, query, empty_reason=empty_reason(snmp_response_data)
+            )
+            formatted_response.results = snmp_service.enrich_results(snmp_response_data)
+            if resolve_if_names:
+                await interface_service.label_results(snmp_query, formatted_response.results, api_key=api_key)
+            formatted_response.warnings = snmp_service.collect_warnings(formatted_response.results, snmp_response_data)
+            formatted_response.fallback = used_fallback(snmp_response_data)
+            formatted_response.groups = snmp_service.group_results(snmp_query, formatted_response.results)
+            if snmp_query.operation.command.upper() == "WALKTABLE":
+                formatted_response.rows = snmp_service.table_rows(snmp_query, formatted_response.results)
+            if computed_fields:
+                formatted_response.computed = compute_fields(snmp_response_data, computed_fields)
+
+            if include_device:
+                formatted_response.device = await device_service.identify(
+                    snmp_query.target.host,
+                    port=snmp_query.target.port,
+                    community=snmp_query.credentials.community,
+                    version=snmp_query.credentials.version,
+                    api_key=api_key
+                )
+
+        if x_session_id and not formatted_response.error:
+            # Numbered as the client sees them, after the value filters
+            shown = [result for result in formatted_response.results if passes_filters(result.dict(), value_filters)]
+            if not session_store.record(x_session_id, snmp_query, shown, formatted_response.summary, api_key):
+                logger.warning(f"Session {x_session_id} belongs to another API key; not recording the query")
+
+        if timer:
+            timer.mark("enrichment")
+
+        if debug:
+            formatted_response.debug = {"request": request_debug}
+            if effective:
+                formatted_response.debug["effective"] = effective
 
-        # Cache response
-        if not skip_cache and not formatted_response.error:
-            cache_key = f"query_{hash(query)}"
-            set_cache(cache_key, formatted_response.dict())
+        # Not only shown with debug, as what runs isn't quite what the model first made of the query
+        formatted_response.correction = correction
+        formatted_response.plan = snmp_query.plan()
+        response_content = formatted_response.dict()
+        if not include_plan:
+            response_content["plan"] = None
+        if not group_by_oid:
+            response_content["groups"] = None
 
-        return formatted_response.dict()
+        # Cache response; writes are never cached, so repeating a SET query sends it again
+        if not formatted_response.error and not skip_cache and snmp_query.operation.command.upper() != "SET":
+            if not set_cache(cache_key, {"response": formatted_response.dict(), "etag": data_etag}, stale_ok=True):
+                response_content["cache_skipped"] = CACHE_ENTRY_TOO_LARGE
 
+        if timer:
+            timer.mark("caching")
+            response_content["debug"]["timings"] = timer.timings()
+
+        style_names(format_times(filter_values(response_content)))
+
+        if debug and formatted_response.results:
+            # Each result beside the raw value it was enriched from, to tell SNMP problems from enrichment ones
+            raw_values = [
+                item for item, result in zip(snmp_response_data.items(), formatted_response.results)
+                if passes_filters(result.dict(), value_filters)
+            ]
+            response_content["debug"]["enrichment"] = [
+                {"raw": {"key": key, "value": value}, "enriched": result}
+                for (key, value), result in zip(raw_values, response_content["results"])
+            ]
+
+        if formatted_response.error:
+            return render_error(response_content, accept, headers=operation_headers)
+
+        return render_results(response_content, snmp_query.target.host, headers={"ETag": etag, "Vary": "Accept", **operation_headers})
+
+    except ClarificationNeeded as e:
+        clarification_response = SNMPResponse(
+            raw_data={},
+            summary=f"Clarification needed: {str(e)}",
+            query=query,
+            clarification=e.clarification
+        )
+        return render(clarification_response.dict(), accept)
+    except INTERPRETATION_ERRORS as e:
+        raise interpretation_error(e)
+    except OperationCancelled as e:
+        raise HTTPException(status_code=499, detail=str(e))
+    except DuplicateOperationError as e:
+        raise HTTPException(status_code=409, detail=str(e))
+    except HTTPException:
+        raise
     except Exception as e:
         logger.error(f"Error processing query: {e}")
-        raise HTTPException(status_code=500, detail=f"Error processing query: {str(e)}")
+        raise APIError(500, INTERNAL_ERROR, f"Error processing query: {str(e)}")
+
+
+@app.post("/query/multi")
+async def process_multi_target_query(
+    request: MultiTargetQuery,
+    accept: Optional[str] = Header(None, description="application/msgpack for a MessagePack response"),
+    api_key: Optional[APIKeyPolicy] = Depends(require_api_key)
+):
+    """
+    Process a natural language SNMP query against several targets
+
+    Returns 200 if every target succeeded, 207 Multi-Status on partial failure
+    and 502 if every target failed, with the per-target outcome in the body.
+    The body is JSON unless the Accept header prefers application/msgpack.
+    A dry run returns the estimated cost of the fan-out without running it.
+    """
+    try:
+        logger.info(f"Received multi-target query for {len(request.targets)} targets: {request.query}")
+
+        # Interpret the query once and fan it out to every target
+        snmp_query, correction = await openai_service.interpret(request.query, validate=snmp_service.correctable_error,
+                                                                 api_key=api_key)
+
+        if not snmp_query:
+            raise APIError(400, QUERY_NOT_UNDERSTOOD, "Failed to parse query")
+
+        snmp_query.raw_query = request.query
+        snmp_service.resolve_names(snmp_query.operation)
+
+        if request.dry_run:
+            validation_error = snmp_service.validate_query(snmp_query, api_key=api_key)
+            if validation_error:
+                raise APIError(400, INVALID_QUERY, validation_error)
+            response = MultiTargetResponse(
+                query=request.query,
+                correction=correction,
+                estimate=cost_service.estimate(snmp_query, targets=len(request.targets), summarize=False)
+            )
+            return render(response.dict(), accept)
+
+        results = await snmp_service.execute_multi(
+            snmp_query, request.targets, api_key=api_key, retry_budget=request.retry_budget
+        )
+        response = MultiTargetResponse.from_results(request.query, results)
+        response.correction = correction
+
+        return render(response.dict(), accept, status_code=response.status_code)
+
+    except INTERPRETATION_ERRORS as e:
+        raise interpretation_error(e)
+    except HTTPException:
+        raise
+    except Exception as e:
+        logger.error(f"Error processing multi-target query: {e}")
+        raise APIError(500, INTERNAL_ERROR, f"Error processing query: {str(e)}")
+
+
+@app.post("/plan")
+async def plan_query(
+    query: str = Body(..., description="Natural language SNMP query"),
+    api_key: Optional[APIKeyPolicy] = Depends(require_api_key)
+):
+    """
+    Interpret a query without running it, for review before /execute
+
+    Returns the interpreted query (with secrets omitted) and an edit token. The
+    client may edit the plan, e.g. fix the target or narrow the OIDs, and send it
+    to /execute with the token. A plan that wouldn't run as interpreted is still
+    returned, with the reason in error, so it can be fixed.
+    """
+    try:
+        snmp_query, correction = await openai_service.interpret(query, validate=snmp_service.correctable_error,
+                                                                 api_key=api_key)
+
+        if not snmp_query:
+            raise APIError(400, QUERY_NOT_UNDERSTOOD, "Failed to parse query")
+
+        snmp_query.raw_query = query
+        response = PlanResponse(
+            query=query,
+            plan=snmp_query.plan(),
+            plan_token=plan_store.issue(snmp_query, api_key),
+            expires_in=plan_store.ttl,
+            error=snmp_service.validate_query(snmp_query, api_key=api_key),
+            correction=correction
+        )
+        return response.dict()
+
+    except INTERPRETATION_ERRORS as e:
+        raise interpretation_error(e)
+    except HTTPException:
+        raise
+    except Exception as e:
+        logger.error(f"Error planning query: {e}")
+        raise APIError(500, INTERNAL_ERROR, f"Error planning query: {str(e)}")
+
+
+@app.post("/execute")
+async def execute_plan(
+    request: ExecutePlanRequest,
+    accept: Optional[str] = Header(None, description="application/msgpack for a MessagePack response"),
+    api_key: Optional[APIKeyPolicy] = Depends(require_api_key)
+):
+    """
+    Run a plan from /plan, as edited by the client
+
+    The edited plan goes through the same query transforms and validation as an
+    interpreted query. Secrets omitted from the plan are reused for the host it
+    was interpreted for. Responses are never cached, and the token can be used
+    again until it expires.
+    """
+    try:
+        snmp_query = plan_store.apply_edits(request.plan_token, request.plan, api_key)
+        if snmp_query is None:
+            raise HTTPException(status_code=404, detail="Unknown or expired plan token")
+
+        snmp_query = apply_query_transforms(snmp_query)
+        snmp_service.resolve_names(snmp_query.operation)
+        validation_error = snmp_service.validate_query(snmp_query, api_key=api_key)
+        if validation_error:
+            raise APIError(400, INVALID_QUERY, f"Invalid plan: {validation_error}")
+
+        query = snmp_query.raw_query or ""
+        snmp_response_data = await snmp_service.execute_query(snmp_query, api_key=api_key)
+
+        if "error" in snmp_response_data:
+            formatted_response = SNMPResponse(
+                raw_data=snmp_response_data,
+                summary=f"Error: {snmp_response_data['error']}",
+                query=query,
+                error=snmp_response_data["error"],
+                error_code=snmp_response_data.get("error_code"),
+                plan=snmp_query.plan()
+            )
+            return render_error(formatted_response.dict(), accept)
+
+        formatted_response = await openai_service.format_response(
+            snmp_response_data, query, empty_reason=empty_reason(snmp_response_data)
+        )
+        formatted_response.results = snmp_service.enrich_results(snmp_response_data)
+        formatted_response.warnings = snmp_service.collect_warnings(formatted_response.results, snmp_response_data)
+        formatted_response.fallback = used_fallback(snmp_response_data)
+        formatted_response.plan = snmp_query.plan()
+        return render(formatted_response.dict(), accept)
+
+    except INTERPRETATION_ERRORS as e:
+        raise interpretation_error(e)
+    except HTTPException:
+        raise
+    except Exception as e:
+        logger.error(f"Error executing plan: {e}")
+        raise APIError(500, INTERNAL_ERROR, f"Error executing plan: {str(e)}")
+
+
+@app.get("/query/metrics")
+async def export_query_metrics(
+    query: str = Query(..., description="Natural language SNMP query"),
+    api_key: Optional[APIKeyPolicy] = Depends(require_api_key)
+):
+    """
+    Run a natural language query and export its results as OpenMetrics
+
+    Meant to be scraped by Prometheus, with the query given as a scrape parameter.
+    Numeric results become metrics named from their symbolic OID, labelled with
+    index and target; other values are exported as info metrics.
+    """
+    try:
+        snmp_query, _ = await openai_service.interpret(query, validate=snmp_service.correctable_error, api_key=api_key)
+
+        if not snmp_query:
+            raise APIError(400, QUERY_NOT_UNDERSTOOD, "Failed to parse query")
+
+        snmp_query.raw_query = query
+        snmp_service.resolve_names(snmp_query.operation)
+
+        snmp_response_data = await snmp_service.execute_query(snmp_query, api_key=api_key)
+        if "error" in snmp_response_data:
+            raise HTTPException(status_code=502, detail=snmp_response_data["error"])
+
+        return Response(
+            content=to_openmetrics([(snmp_query.target.host, snmp_response_data)]),
+            media_type=OPENMETRICS_MEDIA_TYPE
+        )
+
+    except INTERPRETATION_ERRORS as e:
+        raise interpretation_error(e)
+    except HTTPException:
+        raise
+    except Exception as e:
+        logger.error(f"Error exporting query metrics: {e}")
+        raise APIError(500, INTERNAL_ERROR, f"Error exporting query metrics: {str(e)}")
+
+
+@app.get("/query/subscribe")
+async def subscribe_query(
+    query: str = Query(..., description="Natural language SNMP query"),
+    interval: Optional[int] = Query(None, description="Seconds between polls"),
+    lifetime: Optional[int] = Query(None, description="Seconds before the subscription ends"),
+    x_operation_id: Optional[str] = Header(None, description="ID to cancel the subscription by (assigned if not given)"),
+    api_key: Optional[APIKeyPolicy] = Depends(require_api_key)
+):
+    """
+    Subscribe to a natural language query as a stream of Server-Sent Events
+
+    The query is re-run every interval seconds. The stream starts with a snapshot
+    event holding the full result, then pushes a change event with only the
+    changed, added and removed values whenever the result changes, and an error
+    event when the query fails. It closes with an end event after its lifetime,
+    or when it is cancelled with DELETE /operations/{id}. The first event names
+    the operation ID, which can also be chosen with an X-Operation-ID header,
+    and the estimated cost of each poll.
+    """
+    try:
+        bounds = subscription_service.check_bounds(interval, lifetime)
+
+        snmp_query, _ = await openai_service.interpret(query, validate=snmp_service.correctable_error, api_key=api_key)
+
+        if not snmp_query:
+            raise APIError(400, QUERY_NOT_UNDERSTOOD, "Failed to parse query")
+
+        snmp_query.raw_query = query
+        snmp_service.resolve_names(snmp_query.operation)
+
+        validation_error = snmp_service.validate_query(snmp_query, api_key=api_key)
+        if validation_error:
+            raise APIError(400, INVALID_QUERY, validation_error)
+
+        # Cost of each poll, for the client to check before the stream gets going
+        estimate = cost_service.estimate(snmp_query, summarize=False)
+        operation = operation_registry.start("subscription", query, operation_id=x_operation_id, api_key=api_key)
+
+        async def events():
+            try:
+                yield f"event: operation\ndata: {json.dumps({'id': operation.id, 'estimate': estimate.dict()})}\n\n"
+                async for event in subscription_service.subscribe(
+                    snmp_query, api_key=api_key, operation=operation, **bounds
+                ):
+                    yield f"event: {event['event']}\ndata: {json.dumps(event['data'], default=str)}\n\n"
+            finally:
+                operation_registry.finish(operation)
+
+        return StreamingResponse(
+            events(),
+            media_type="text/event-stream",
+            headers={"Cache-Control": "no-cache", "X-Operation-ID": operation.id}
+        )
+
+    except SubscriptionError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+    except DuplicateOperationError as e:
+        raise HTTPException(status_code=409, detail=str(e))
+    except INTERPRETATION_ERRORS as e:
+        raise interpretation_error(e)
+    except HTTPException:
+        raise
+    except Exception as e:
+        logger.error(f"Error subscribing to query: {e}")
+        raise APIError(500, INTERNAL_ERROR, f"Error subscribing to query: {str(e)}")
+
+
+@app.get("/query/stream")
+async def stream_query(
+    request: Request,
+    query: str = Query(..., description="Natural language SNMP query"),
+    interpretation: bool = Query(
+        False, description="Interpret the query in the stream, pushing the model's answer as it is written"
+    ),
+    x_operation_id: Optional[str] = Header(None, description="ID to cancel the query by (assigned if not given)"),
+    api_key: Optional[APIKeyPolicy] = Depends(require_api_key)
+):
+    """
+    Run a natural language query, streaming its results as Server-Sent Events
+
+    Each result of a WALK or BULK is pushed as a result event as soon as the
+    response holding it arrives, instead of after the whole walk; other commands
+    push their results once they complete. A failed query pushes an error event.
+    The stream closes with an end event carrying the number of results and the
+    total duration. The first event names the operation ID, which can also be
+    chosen with an X-Operation-ID header; the walk stops when the operation is
+    cancelled or the client disconnects.
+
+    With interpretation=true the query is interpreted after the operation event,
+    and each piece of the model's answer is pushed as an interpretation event
+    while it is written, followed by a plan event with the interpreted query.
+    Interpretation failures are then error events instead of HTTP errors.
+
+    Only reads are streamed: a query interpreted as anything but a WALK, BULK,
+    WALKTABLE, GET or GETNEXT is rejected.
+    """
+    async def interpret(on_delta=None) -> SNMPQuery:
+        snmp_query, _ = await openai_service.interpret(query, validate=snmp_service.correctable_error, on_delta=on_delta,
+                                                       api_key=api_key)
+
+        if not snmp_query:
+            raise APIError(400, QUERY_NOT_UNDERSTOOD, "Failed to parse query")
+
+        snmp_query.raw_query = query
+        if snmp_query.operation.command.upper() not in STREAM_COMMANDS or snmp_query.operation.set_values:
+            raise APIError(400, INVALID_QUERY,
+                           f"{snmp_query.operation.command.upper()} can't be streamed; use POST /query for it")
+        snmp_service.resolve_names(snmp_query.operation)
+
+        validation_error = snmp_service.validate_query(snmp_query, api_key=api_key)
+        if validation_error:
+            raise APIError(400, INVALID_QUERY, validation_error)
+        return snmp_query
+
+    try:
+        interpreted = None if interpretation else await interpret()
+
+        operation = operation_registry.start("query", query, operation_id=x_operation_id, api_key=api_key)
+
+        async def events():
+            started = time.monotonic()
+            rows: asyncio.Queue = asyncio.Queue()
+            streamed = set()
+            watcher = walk = thinking = None
+            if config.disconnect_check_interval > 0:
+                watcher = asyncio.create_task(
+                    cancel_on_disconnect(operation, request.is_disconnected, config.disconnect_check_interval)
+                )
+            try:
+                yield f"event: operation\ndata: {json.dumps({'id': operation.id})}\n\n"
+
+                snmp_query = interpreted
+                if snmp_query is None:
+                    deltas: asyncio.Queue = asyncio.Queue()
+                    thinking = asyncio.ensure_future(operation.run(interpret(on_delta=deltas.put_nowait)))
+                    thinking.add_done_callback(lambda _: deltas.put_nowait(None))
+                    while True:
+                        delta = await deltas.get()
+                        if delta is None:
+                            break
+                        yield f"event: interpretation\ndata: {json.dumps({'delta': delta})}\n\n"
+                    try:
+                        snmp_query = thinking.result()
+                    except Exception as e:
+                        api_error = interpretation_error(e)
+                        if api_error:
+                            error = {"error": api_error.message, "error_code": api_error.error_code,
+                                     **api_error.details}
+                        elif isinstance(e, OperationCancelled):
+                            error = {"error": str(e)}
+                        else:
+                            logger.error(f"Error interpreting streamed query: {e}")
+                            error = {"error": scrub_secrets(f"Error interpreting query: {str(e)}")}
+                        yield f"event: error\ndata: {json.dumps(error, default=str)}\n\n"
+                        end = {"count": 0, "duration_ms": round((time.monotonic() - started) * 1000)}
+                        yield f"event: end\ndata: {json.dumps(end)}\n\n"
+                        return
+                    yield f"event: plan\ndata: {json.dumps(snmp_query.plan(), default=str)}\n\n"
+
+                walk = asyncio.ensure_future(operation.run(
+                    snmp_service.execute_query(snmp_query, api_key=api_key, on_rows=rows.put_nowait)
+                ))
+                # Queued after the last rows, so the loop below ends once the query is done
+                walk.add_done_callback(lambda _: rows.put_nowait(None))
+                while True:
+                    varbinds = await rows.get()
+                    if varbinds is None:
+                        break
+                    for result in snmp_service.enrich_results(varbinds):
+                        streamed.add(result.oid or result.name)
+                        yield f"event: result\ndata: {json.dumps(result.dict(), default=str)}\n\n"
+
+                try:
+                    snmp_response_data = walk.result()
+                except OperationCancelled as e:
+                    snmp_response_data = {"error": str(e)}
+
+                end = {}
+                if "error" in snmp_response_data:
+                    error = {"error": snmp_response_data["error"], "error_code": snmp_response_data.get("error_code")}
+                    yield f"event: error\ndata: {json.dumps(error)}\n\n"
+                else:
+                    # Results no response was streamed for, e.g. those of a GET or a failed subtree
+                    for result in snmp_service.enrich_results(snmp_response_data):
+                        if (result.oid or result.name) not in streamed:
+                            streamed.add(result.oid or result.name)
+                            yield f"event: result\ndata: {json.dumps(result.dict(), default=str)}\n\n"
+                    if not streamed and empty_reason(snmp_response_data):
+                        end["empty_reason"] = empty_reason(snmp_response_data)
+
+                end.update(count=len(streamed), duration_ms=round((time.monotonic() - started) * 1000))
+                yield f"event: end\ndata: {json.dumps(end)}\n\n"
+            finally:
+                for task in (thinking, walk, watcher):
+                    if task:
+                        task.cancel()
+                operation_registry.finish(operation)
+
+        return StreamingResponse(
+            events(),
+            media_type="text/event-stream",
+            headers={"Cache-Control": "no-cache", "X-Operation-ID": operation.id},
+            # Finished by the stream, or here if it never starts, e.g. when the client is gone first
+            background=BackgroundTask(operation_registry.finish, operation)
+        )
+
+    except DuplicateOperationError as e:
+        raise HTTPException(status_code=409, detail=str(e))
+    except INTERPRETATION_ERRORS as e:
+        raise interpretation_error(e)
+    except HTTPException:
+        raise
+    except Exception as e:
+        logger.error(f"Error streaming query: {e}")
+        raise APIError(500, INTERNAL_ERROR, f"Error streaming query: {str(e)}")
+
+
+@app.get("/operations")
+async def list_operations(api_key: Optional[APIKeyPolicy] = Depends(require_api_key)):
+    """
+    List the running queries and subscriptions started with this API key, that can be cancelled
+    """
+    return {"operations": operation_registry.list(api_key)}
+
+
+@app.delete("/operations/{operation_id}")
+async def cancel_operation(operation_id: str, api_key: Optional[APIKeyPolicy] = Depends(require_api_key)):
+    """
+    Cancel a running query or subscription started with this API key
+
+    A cancelled query fails with status 499; a cancelled subscription ends its
+    stream with an end event. Outstanding SNMP requests are abandoned.
+    """
+    if not operation_registry.cancel(operation_id, api_key):
+        raise HTTPException(status_code=404, detail=f"No running operation {operation_id}")
+    return {"id": operation_id, "cancelled": True}
+
+
+@app.delete("/sessions/{session_id}")
+async def end_session(session_id: str, api_key: Optional[APIKeyPolicy] = Depends(require_api_key)):
+    """
+    End a conversation, forgetting the results its follow-up queries could refer to
+    """
+    if not session_store.forget(session_id, api_key):
+        raise HTTPException(status_code=404, detail=f"No session {session_id}")
+    return {"id": session_id, "ended": True}
+
+
+@app.get("/macros", dependencies=[Depends(require_api_key)])
+async def get_macros():
+    """
+    List the configured macros with their parameters and steps
+    """
+    try:
+        macros = macro_service.list_macros()
+        return {"macros": macros, "count": len(macros)}
+    except Exception as e:
+        logger.error(f"Error getting macros: {e}")
+        raise APIError(500, INTERNAL_ERROR, f"Error getting macros: {str(e)}")
+
+
+@app.post("/macros/{name}")
+async def run_macro(
+    name: str,
+    parameters: Dict[str, Any] = Body({}, description="Macro parameter values"),
+    api_key: Optional[APIKeyPolicy] = Depends(require_api_key)
+):
+    """
+    Run a macro's steps and return the labeled result of each
+    """
+    try:
+        response = await macro_service.run(name, parameters, api_key=api_key)
+        return response.dict()
+    except MacroError as e:
+        status_code = 404 if name not in config.macros else 400
+        raise HTTPException(status_code=status_code, detail=str(e))
+    except Exception as e:
+        logger.error(f"Error running macro: {e}")
+        raise APIError(500, INTERNAL_ERROR, f"Error running macro: {str(e)}")
 
 
-@app.get("/mibs")
+@app.get("/mibs", dependencies=[Depends(require_api_key)])
 async def get_mibs():
     """
-    Get a list of loaded MIBs
+    List the MIBs in the MIB directory and those loaded, with their files and object counts
     """
     try:
-        mibs = mib_service.get_loaded_mibs()
-        return {"mibs": mibs, "count": len(mibs)}
+        # Parsing changed MIB files can take a while, so it doesn't hold up the event loop
+        mibs = await asyncio.to_thread(mib_service.list_mibs)
+        return {"mibs": mibs, "count": len(mibs), "loaded": sum(1 for mib in mibs if mib["loaded"])}
     except Exception as e:
         logger.error(f"Error getting MIBs: {e}")
-        raise HTTPException(status_code=500, detail=f"Error getting MIBs: {str(e)}")
+        raise APIError(500, INTERNAL_ERROR, f"Error getting MIBs: {str(e)}")
+
+
+@app.get("/mibs/health", dependencies=[Depends(require_api_key)])
+async def get_mib_health():
+    """
+    Summarize the MIB index: modules, objects, unresolved imports and objects, and duplicate OIDs
+    """
+    try:
+        # Files added since startup are parsed, which mustn't hold up other requests
+        return await asyncio.to_thread(mib_service.health)
+    except Exception as e:
+        logger.error(f"Error checking MIB health: {e}")
+        raise APIError(500, INTERNAL_ERROR, f"Error checking MIB health: {str(e)}")
 
 
-@app.post("/mibs/upload")
+@app.post("/mibs/upload", dependencies=[Depends(require_api_key)])
 async def upload_mib(file_path: str = Body(..., description="Path to MIB file")):
     """
     Upload a new MIB file
     """
     try:
+        if not os.path.isfile(file_path):
+            raise APIError(404, MIB_NOT_FOUND, f"MIB file not found: {file_path}")
         success = mib_service.add_mib_file(file_path)
         if success:
             # Clear MIB-related cache
@@ -120,12 +1396,115 @@ async def upload_mib(file_path: str = Body(..., description="Path to MIB file"))
             return {"status": "success", "message": f"MIB file added successfully"}
         else:
             raise HTTPException(status_code=400, detail="Failed to add MIB file")
+    except HTTPException:
+        raise
     except Exception as e:
         logger.error(f"Error uploading MIB: {e}")
-        raise HTTPException(status_code=500, detail=f"Error uploading MIB: {str(e)}")
+        raise APIError(500, INTERNAL_ERROR, f"Error uploading MIB: {str(e)}")
+
+
+@app.post("/mibs/rebuild-index", dependencies=[Depends(require_api_key)])
+async def rebuild_mib_index():
+    """
+    Rebuild the OID -> name index from the loaded MIB definitions
+
+    The rebuild runs in a worker thread; queries meanwhile keep using the old index.
+    """
+    try:
+        entries = await asyncio.to_thread(mib_service.rebuild_index)
+        return {"status": "success", "entries": entries}
+    except MIBConflictError as e:
+        raise HTTPException(status_code=409, detail=str(e))
+    except Exception as e:
+        logger.error(f"Error rebuilding MIB index: {e}")
+        raise APIError(500, INTERNAL_ERROR, f"Error rebuilding MIB index: {str(e)}")
+
+
+async def run_mib_download(repository: MIBRepository, progress: DownloadProgress, operation) -> None:
+    """Run a MIB repository update in the background, as a cancellable operation"""
+    try:
+        await repository.update(progress.modules, progress=progress, operation=operation)
+    except Exception as e:
+        logger.error(f"Error downloading MIBs: {e}")
+    finally:
+        operation_registry.finish(operation)
+
+
+@app.post("/mibs/download", status_code=202)
+async def start_mib_download(
+    modules: List[str] = Body(..., embed=True, description="Names of the MIB modules to download"),
+    api_key: Optional[APIKeyPolicy] = Depends(require_api_key)
+):
+    """
+    Download MIB modules from MIB_REPOSITORY_URL in the background
+
+    Modules already downloaded and unchanged are skipped, so starting again after an
+    interruption or cancellation (DELETE /operations/{id}) resumes the download.
+    Progress is reported by GET /mibs/download. Keys restricted to OID subtrees can't
+    start a download.
+    """
+    global mib_download, mib_download_task
+    if api_key and api_key.oid_prefixes:
+        raise HTTPException(status_code=403, detail=f"API key '{api_key.name}' may not download MIBs")
+    source = default_source()
+    if source is None:
+        raise HTTPException(status_code=503, detail="No MIB repository is configured (MIB_REPOSITORY_URL)")
+    if mib_download and mib_download.status == "running":
+        raise HTTPException(status_code=409, detail="A MIB download is already running")
+    if not modules:
+        raise HTTPException(status_code=400, detail="No MIB modules to download")
 
+    invalid = invalid_modules(modules)
+    if invalid:
+        raise HTTPException(status_code=400, detail=f"Invalid MIB module names: {', '.join(invalid)}")
 
-@app.post("/oid/resolve")
+    repository = MIBRepository(mib_service, source)
+    progress = DownloadProgress(list(dict.fromkeys(modules)))
+    operation = operation_registry.start("mib-download", f"Download {len(progress.modules)} MIB modules",
+                                          api_key=api_key)
+    mib_download = progress
+    mib_download_task = asyncio.create_task(run_mib_download(repository, progress, operation))
+    return {"operation_id": operation.id, **progress.describe()}
+
+
+@app.get("/mibs/download", dependencies=[Depends(require_api_key)])
+async def get_mib_download():
+    """
+    Progress of the running or last MIB repository download
+    """
+    if mib_download is None:
+        raise HTTPException(status_code=404, detail="No MIB download has been started")
+    return mib_download.describe()
+
+
+@app.get("/aliases", dependencies=[Depends(require_api_key)])
+async def get_aliases():
+    """
+    Get the OID alias table
+    """
+    try:
+        aliases = mib_service.get_aliases()
+        return {"aliases": aliases, "count": len(aliases)}
+    except Exception as e:
+        logger.error(f"Error getting aliases: {e}")
+        raise APIError(500, INTERNAL_ERROR, f"Error getting aliases: {str(e)}")
+
+
+@app.get("/oids/{name}", dependencies=[Depends(require_api_key)])
+async def get_oid_by_name(name: str):
+    """
+    Resolve an OID name from the loaded MIBs to its numeric OID
+
+    Returns 404 with the name, and the MIB to load if it can be guessed from the name,
+    when no loaded MIB defines it.
+    """
+    try:
+        return {"name": name, "oid": mib_service.resolve_name(name)}
+    except UnresolvedNameError as e:
+        raise unresolved_name(e)
+
+
+@app.post("/oid/resolve", dependencies=[Depends(require_api_key)])
 async def resolve_oid(name: str = Body(..., description="OID name to resolve")):
     """
     Resolve an OID name to a numeric OID
@@ -135,42 +1514,149 @@ async def resolve_oid(name: str = Body(..., description="OID name to resolve")):
         if oid:
             return {"name": name, "oid": oid}
         else:
-            raise HTTPException(status_code=404, detail=f"OID not found: {name}")
+            raise APIError(404, OID_NOT_FOUND, f"OID not found: {name}")
+    except HTTPException:
+        raise
     except Exception as e:
         logger.error(f"Error resolving OID: {e}")
-        raise HTTPException(status_code=500, detail=f"Error resolving OID: {str(e)}")
+        raise APIError(500, INTERNAL_ERROR, f"Error resolving OID: {str(e)}")
 
 
-@app.post("/oid/translate")
+@app.post("/oid/translate", dependencies=[Depends(require_api_key)])
 async def translate_oid(oid: str = Body(..., description="Numeric OID to translate")):
     """
     Translate a numeric OID to a symbolic name
     """
     try:
-        name = mib_service.translate_oid(oid)
+        name = mib_service.translate_oid(normalize_oid(oid))
         if name:
             return {"oid": oid, "name": name}
         else:
-            raise HTTPException(status_code=404, detail=f"OID not found: {oid}")
+            raise APIError(404, OID_NOT_FOUND, f"OID not found: {oid}")
+    except HTTPException:
+        raise
     except Exception as e:
         logger.error(f"Error translating OID: {e}")
-        raise HTTPException(status_code=500, detail=f"Error translating OID: {str(e)}")
+        raise APIError(500, INTERNAL_ERROR, f"Error translating OID: {str(e)}")
+
+
+@app.post("/oid/info", dependencies=[Depends(require_api_key)])
+async def oid_info(oid: str = Body(..., description="Numeric OID to describe")):
+    """
+    Describe the MIB object of a numeric OID: name, module, SYNTAX, MAX-ACCESS and DESCRIPTION
+    """
+    info = mib_service.get_oid_info(oid)
+    if info is None:
+        raise APIError(404, OID_NOT_FOUND, f"OID not found: {oid}")
+    return info
+
+
+@app.post("/poller/targets")
+async def add_poll_target(
+    query: SNMPQuery,
+    interval: Optional[int] = Query(None, ge=1, description="Polling interval in seconds"),
+    api_key: Optional[APIKeyPolicy] = Depends(require_api_key)
+):
+    """
+    Start polling a target in the background
+    """
+    validation_error = snmp_service.validate_query(query, api_key=api_key)
+    if validation_error:
+        raise APIError(400, INVALID_QUERY, validation_error)
+
+    try:
+        poller_service.add_target(query, interval)
+        return {"status": "success", "message": f"Polling {query.target.host}"}
+    except Exception as e:
+        logger.error(f"Error adding poll target: {e}")
+        raise APIError(500, INTERNAL_ERROR, f"Error adding poll target: {str(e)}")
+
+
+@app.delete("/poller/targets/{host}")
+async def remove_poll_target(host: str, api_key: Optional[APIKeyPolicy] = Depends(require_api_key)):
+    """
+    Stop polling a target
+
+    Keys restricted to OID subtrees can't stop polling, since the target's polled OIDs
+    may lie outside them.
+    """
+    if api_key and api_key.oid_prefixes:
+        raise HTTPException(status_code=403, detail=f"API key '{api_key.name}' may not stop polling targets")
+    if not poller_service.remove_target(host):
+        raise HTTPException(status_code=404, detail=f"Target not polled: {host}")
+    return {"status": "success", "message": f"Stopped polling {host}"}
+
+
+@app.get("/poller/targets/{host}")
+async def get_poll_result(host: str, api_key: Optional[APIKeyPolicy] = Depends(require_api_key)):
+    """
+    Get the most recent poll result for a target
+
+    Keys restricted to OID subtrees can't read poll results, which may hold OIDs outside them.
+    """
+    if api_key and api_key.oid_prefixes:
+        raise HTTPException(status_code=403, detail=f"API key '{api_key.name}' may not read poll results")
+    result = poller_service.get_result(host)
+    if result is None:
+        raise HTTPException(status_code=404, detail=f"No poll result for: {host}")
+    return {"host": host, "result": result}
+
+
+@app.get("/poller/intervals", dependencies=[Depends(require_api_key)])
+async def get_poll_intervals():
+    """
+    Get the current adaptive polling interval for each target
+    """
+    try:
+        intervals = poller_service.get_intervals()
+        return {"targets": intervals, "count": len(intervals)}
+    except Exception as e:
+        logger.error(f"Error getting poll intervals: {e}")
+        raise APIError(500, INTERNAL_ERROR, f"Error getting poll intervals: {str(e)}")
+
+
+@app.get("/targets/stats", dependencies=[Depends(require_api_key)])
+async def get_target_stats():
+    """
+    Get the reliability of each target queried recently: success rate, latency and last error
+    """
+    try:
+        targets = snmp_service.target_stats.stats()
+        return {"window": config.snmp.stats_window, "targets": targets, "count": len(targets)}
+    except Exception as e:
+        logger.error(f"Error getting target stats: {e}")
+        raise APIError(500, INTERNAL_ERROR, f"Error getting target stats: {str(e)}")
+
+
+@app.get("/metrics", dependencies=[Depends(require_api_key)])
+async def get_service_metrics():
+    """
+    Export the service's own metrics in the OpenMetrics text format, for Prometheus
+    """
+    return Response(content=metrics_registry.render(), media_type=OPENMETRICS_MEDIA_TYPE)
 
 
 @app.post("/clear-cache")
-async def clear_application_cache(prefix: Optional[str] = Query(None, description="Cache key prefix")):
+async def clear_application_cache(
+    prefix: Optional[str] = Query(None, description="Cache key prefix"),
+    api_key: Optional[APIKeyPolicy] = Depends(require_api_key)
+):
     """
     Clear application cache
+
+    Keys restricted to OID subtrees can't clear it.
     """
+    if api_key and api_key.oid_prefixes:
+        raise HTTPException(status_code=403, detail=f"API key '{api_key.name}' may not clear the cache")
     try:
         clear_cache(key_prefix=prefix)
         return {"status": "success", "message": "Cache cleared successfully"}
     except Exception as e:
         logger.error(f"Error clearing cache: {e}")
-        raise HTTPException(status_code=500, detail=f"Error clearing cache: {str(e)}")
+        raise APIError(500, INTERNAL_ERROR, f"Error clearing cache: {str(e)}")
 
 
-@app.get("/cache/stats")
+@app.get("/cache/stats", dependencies=[Depends(require_api_key)])
 async def get_cache_statistics():
     """
     Get cache statistics
@@ -185,4 +1671,32 @@ async def get_cache_statistics():
         }
     except Exception as e:
         logger.error(f"Error getting cache statistics: {e}")
-        raise HTTPException(status_code=500, detail=f"Error getting cache statistics: {str(e)}")
+        raise APIError(500, INTERNAL_ERROR, f"Error getting cache statistics: {str(e)}")
+
+
+@app.get("/maintenance", dependencies=[Depends(require_api_key)])
+async def get_maintenance_mode():
+    """
+    Get the maintenance mode: off, read-only (SETs are rejected) or all (every SNMP request is)
+    """
+    return {"mode": snmp_service.maintenance_mode, "modes": list(MAINTENANCE_MODES)}
+
+
+@app.put("/maintenance")
+async def set_maintenance_mode(
+    mode: str = Body(..., embed=True, description="off, read-only or all"),
+    api_key: Optional[APIKeyPolicy] = Depends(require_api_key)
+):
+    """
+    Switch the maintenance mode, until the next switch or restart
+
+    Rejected requests fail with the SERVICE_READ_ONLY error code. Keys restricted to
+    OID subtrees can't switch it.
+    """
+    if api_key and api_key.oid_prefixes:
+        raise HTTPException(status_code=403, detail=f"API key '{api_key.name}' may not switch the maintenance mode")
+    try:
+        snmp_service.set_maintenance_mode(mode)
+    except ValueError as e:
+        raise HTTPException(status_code=400, detail=str(e))
+    return {"status": "success", "mode": snmp_service.maintenance_mode}
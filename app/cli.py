@@ -8,8 +8,8 @@ from typing import Dict, Any, Optional
 from loguru import logger
 
 from app.core.config import config
-from app.services.openai_service import OpenAIService
-from app.services.snmp_service import SNMPService
+from app.services.openai_service import OpenAIService, ClarificationNeeded
+from app.services.snmp_service import SNMPService, empty_reason, used_fallback
 from app.services.mib_service import MIBService
 from app.models.query import SNMPQuery
 
@@ -63,7 +63,12 @@ async def process_query(query: str, verbose: bool = False) -> Dict[str, Any]:
         if verbose:
             print("\nGenerating summary...")
 
-        formatted_response = await openai_service.format_response(snmp_response_data, query)
+        formatted_response = await openai_service.format_response(
+            snmp_response_data, query, empty_reason=empty_reason(snmp_response_data)
+        )
+        formatted_response.results = snmp_service.enrich_results(snmp_response_data)
+        formatted_response.warnings = snmp_service.collect_warnings(formatted_response.results, snmp_response_data)
+        formatted_response.fallback = used_fallback(snmp_response_data)
 
         if verbose:
             print("\nSummary:")
@@ -71,6 +76,11 @@ async def process_query(query: str, verbose: bool = False) -> Dict[str, Any]:
 
         return formatted_response.dict()
 
+    except ClarificationNeeded as e:
+        print(f"Clarification needed: {e}")
+        for suggestion in e.clarification.suggestions:
+            print(f"  e.g. {suggestion}")
+        return {"needs_clarification": True, "clarification": e.clarification.dict()}
     except Exception as e:
         logger.error(f"Error processing query: {e}")
         return {"error": f"Error processing query: {str(e)}"}
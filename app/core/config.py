@@ -1,24 +1,438 @@
 import os
+import json
+import hashlib
+import ipaddress
 from pydantic import BaseModel
 from typing import Optional, Dict, Any, List
 from dotenv import load_dotenv
 
+from app.utils.redaction import DEFAULT_REDACT_PATTERNS, register_secret
+from app.utils.targets import DEFAULT_TARGET, split_target, format_target
+
 # Load environment variables
 load_dotenv()
 
+# Built-in shorthand names for common OIDs, consulted before the MIB index
+DEFAULT_OID_ALIASES: Dict[str, str] = {
+    "uptime": "1.3.6.1.2.1.1.3.0",
+    "sysdescr": "1.3.6.1.2.1.1.1.0",
+    "sysname": "1.3.6.1.2.1.1.5.0",
+    "location": "1.3.6.1.2.1.1.6.0",
+    "contact": "1.3.6.1.2.1.1.4.0",
+    "ifdescr": "1.3.6.1.2.1.2.2.1.2",
+    "ifspeed": "1.3.6.1.2.1.2.2.1.5",
+    "ifstatus": "1.3.6.1.2.1.2.2.1.8",
+}
+
+
+# Known sysObjectID values (or prefixes) and the device they identify
+DEFAULT_DEVICE_MODELS: Dict[str, str] = {
+    "1.3.6.1.4.1.8072.3.2.10": "Net-SNMP agent on Linux",
+    "1.3.6.1.4.1.311.1.1.3.1.1": "Microsoft Windows Workstation",
+    "1.3.6.1.4.1.311.1.1.3.1.2": "Microsoft Windows Server",
+    "1.3.6.1.4.1.311.1.1.3.1.3": "Microsoft Windows Domain Controller",
+    "1.3.6.1.4.1.674.10892.5": "Dell iDRAC",
+}
+
+# Maximum number of OIDs a single request may resolve to, per SNMP command
+DEFAULT_MAX_OIDS: Dict[str, int] = {
+    "GET": 100,
+    "GETNEXT": 100,
+    "WALK": 10,
+    "BULK": 20,
+}
+
+# OID patterns accepted by query validation without a loaded MIB defining them, per
+# SNMP command ("*" applies to every command). A pattern is a subtree root in which
+# "*" matches any one sub-identifier. Enterprise subtrees of known vendors are always accepted.
+DEFAULT_KNOWN_OIDS: Dict[str, List[str]] = {
+    "*": [
+        "1.3.6.1.2.1",  # mib-2
+        "1.3.6.1.6.3",  # SNMPv2 framework (snmpModules)
+        "1.0.8802.1.1.2",  # LLDP-MIB
+    ],
+}
+
+
+# Named multi-step query plans. Each step is a structured query with a label; "{param}"
+# placeholders are filled in when the macro runs, and a parameter defaulting to None is required
+DEFAULT_MACROS: Dict[str, Dict[str, Any]] = {
+    "interface-health": {
+        "description": "Interface names, operational status and error counters",
+        "parameters": {"host": None},
+        "steps": [
+            {"label": "interfaces", "target": {"host": "{host}"},
+             "operation": {"command": "WALK", "oids": ["IF-MIB::ifDescr"]}},
+            {"label": "status", "target": {"host": "{host}"},
+             "operation": {"command": "WALK", "oids": ["IF-MIB::ifOperStatus"]}},
+            {"label": "errors", "target": {"host": "{host}"},
+             "operation": {"command": "WALK", "oids": ["1.3.6.1.2.1.2.2.1.14", "1.3.6.1.2.1.2.2.1.20"]}},
+        ],
+    },
+}
+
+
+# SNMP versions a query may default to; "auto" negotiates it per target
+SNMP_VERSIONS = ("1", "2c", "3", "auto")
+
+# LLM providers that need an API key, and the setting and environment variable holding it
+LLM_API_KEY_SETTINGS = {
+    "openai": ("api_key", "OPENAI_API_KEY"),
+    "anthropic": ("anthropic_api_key", "ANTHROPIC_API_KEY"),
+}
+
+
+class ConfigError(ValueError):
+    """Raised for a setting that would only fail once it is used, naming the setting and what is wrong"""
+
+
+def _load_json_env(name: str) -> Dict[str, Any]:
+    """Load a JSON object from an environment variable, or an empty dict if unset"""
+    raw_value = os.getenv(name)
+    if not raw_value:
+        return {}
+
+    try:
+        value = json.loads(raw_value)
+    except json.JSONDecodeError as e:
+        raise ValueError(f"{name} is not valid JSON: {e}")
+    if not isinstance(value, dict):
+        raise ValueError(f"{name} must be a JSON object")
+
+    return value
+
+
+def _load_oid_aliases() -> Dict[str, str]:
+    """
+    Load the OID alias table.
+
+    Aliases from the OID_ALIASES environment variable (a JSON object of
+    name -> OID) override the built-in defaults.
+    """
+    aliases = dict(DEFAULT_OID_ALIASES)
+    aliases.update({str(name): str(oid) for name, oid in _load_json_env("OID_ALIASES").items()})
+    return aliases
+
+
+def _load_device_models() -> Dict[str, str]:
+    """
+    Load the sysObjectID registry.
+
+    Entries from the DEVICE_MODELS environment variable (a JSON object of
+    sysObjectID -> device description) override the built-in defaults.
+    """
+    models = dict(DEFAULT_DEVICE_MODELS)
+    models.update({str(oid).lstrip("."): str(model) for oid, model in _load_json_env("DEVICE_MODELS").items()})
+    return models
+
+
+def _load_macros() -> Dict[str, Dict[str, Any]]:
+    """
+    Load the macro definitions.
+
+    Entries from the MACROS environment variable (a JSON object of name ->
+    {"description", "parameters", "steps"}) override the built-in macros.
+    """
+    macros = dict(DEFAULT_MACROS)
+    macros.update(_load_json_env("MACROS"))
+    return macros
+
+
+class APIKeyPolicy(BaseModel):
+    name: str
+    oid_prefixes: List[str] = []  # OID subtrees the key may query, empty for no restriction
+    digest: Optional[str] = None  # Digest of the key itself, set when loaded from API_KEYS
+
+    def identity(self) -> str:
+        """What tells the key apart from others; names may repeat, e.g. keys sharing their first 4 characters"""
+        return self.digest or self.name
+
+
+def _load_api_keys() -> Dict[str, APIKeyPolicy]:
+    """
+    Load API keys and their policies.
+
+    API_KEYS is a JSON object of key -> policy, e.g.
+    {"k1": {"name": "netops", "oid_prefixes": ["1.3.6.1.2.1.2"]}}.
+    API key authentication is disabled when no keys are configured.
+    """
+    return {
+        str(key): APIKeyPolicy(**{
+            "name": f"{str(key)[:4]}****", **(policy or {}),
+            "digest": hashlib.sha256(str(key).encode()).hexdigest()[:16],
+        })
+        for key, policy in _load_json_env("API_KEYS").items()
+    }
+
+
+def _load_target_communities() -> Dict[str, List[str]]:
+    """
+    Load the community strings configured per target.
+
+    SNMP_TARGET_COMMUNITIES is a JSON object of host -> list of communities,
+    tried in order, e.g. {"10.0.0.1": ["new-community", "old-community"]}. A key
+    may name a port ("10.0.0.1:1161", "[::1]:1161") to apply to that port only.
+    Keys are normalized as targets are, so "[::1]" and "0:0::1" are the same host.
+    """
+    communities = {}
+    for target, values in _load_json_env("SNMP_TARGET_COMMUNITIES").items():
+        if isinstance(values, str):
+            values = [values]
+        host, port = split_target(str(target))
+        key = host if port is None else format_target(host, port)
+        communities[key] = [str(value) for value in values]
+    return communities
+
+
+class TargetCredentials(BaseModel):
+    """Credentials configured for the targets of a host, network or the default entry"""
+    version: Optional[str] = None  # 1, 2c, 3 or auto; the query's version if not set
+    communities: List[str] = []  # v1/v2c communities, tried in order like SNMP_TARGET_COMMUNITIES
+    username: Optional[str] = None
+    security_level: Optional[str] = None
+    auth_protocol: Optional[str] = None
+    auth_password: Optional[str] = None
+    priv_protocol: Optional[str] = None
+    priv_password: Optional[str] = None
+
+
+def _load_target_credentials() -> Dict[str, TargetCredentials]:
+    """
+    Load the credentials configured per target, network or as the default.
+
+    SNMP_TARGET_CREDENTIALS is a JSON object of host, host:port, CIDR network or
+    "default" -> credentials, e.g. {"10.1.0.0/16": {"communities": ["tenant-a"]},
+    "10.2.0.0/16": {"version": "3", "username": "monitor", "auth_password": "..."},
+    "default": {"communities": ["public"]}}. A single "community" is accepted
+    for "communities". Keys are normalized as targets are.
+    """
+    credentials = {}
+    for target, value in _load_json_env("SNMP_TARGET_CREDENTIALS").items():
+        if not isinstance(value, dict):
+            raise ValueError(f"SNMP_TARGET_CREDENTIALS entry for {target} must be an object of credentials")
+        value = dict(value)
+        if "community" in value:
+            value["communities"] = [value.pop("community")] + list(value.get("communities") or [])
+        target = str(target).strip().lower()
+        if target == DEFAULT_TARGET:
+            key = target
+        elif "/" in target:
+            try:
+                key = str(ipaddress.ip_network(target, strict=False))
+            except ValueError:
+                raise ValueError(f"Invalid network in SNMP_TARGET_CREDENTIALS: {target}")
+        else:
+            host, port = split_target(target)
+            key = host if port is None else format_target(host, port)
+        credentials[key] = TargetCredentials(**value)
+    return credentials
+
+
+def _load_target_classes() -> Dict[str, List[str]]:
+    """
+    Load the target classes SNMP metrics are labeled with.
+
+    SNMP_TARGET_CLASSES is a JSON object of class -> list of hosts and CIDR
+    networks, e.g. {"core": ["10.0.0.0/24"], "access": ["10.1.0.0/16", "sw1.example.net"]}.
+    Targets in no class are labeled "unclassified".
+    """
+    classes = {}
+    for name, members in _load_json_env("SNMP_TARGET_CLASSES").items():
+        if isinstance(members, str):
+            members = [members]
+        if not isinstance(members, list):
+            raise ValueError(f"SNMP_TARGET_CLASSES entry for {name} must be a list of hosts and networks")
+        classes[str(name)] = [str(member).strip().lower() for member in members]
+    return classes
+
+
+def _load_llm_log_redact_patterns() -> List[str]:
+    """
+    Load the patterns redacted from logged LLM prompts and completions.
+
+    Patterns from the LLM_LOG_REDACT_PATTERNS environment variable (a JSON list of
+    regular expressions) are added to the built-in credential patterns, which always apply.
+    """
+    patterns = list(DEFAULT_REDACT_PATTERNS)
+    raw_value = os.getenv("LLM_LOG_REDACT_PATTERNS")
+    if raw_value:
+        try:
+            extra = json.loads(raw_value)
+        except json.JSONDecodeError as e:
+            raise ValueError(f"LLM_LOG_REDACT_PATTERNS is not valid JSON: {e}")
+        if not isinstance(extra, list):
+            raise ValueError("LLM_LOG_REDACT_PATTERNS must be a JSON list")
+        patterns.extend(str(pattern) for pattern in extra)
+    return patterns
+
+
+def _load_max_oids() -> Dict[str, int]:
+    """
+    Load the per-command OID limits.
+
+    Limits from the SNMP_MAX_OIDS environment variable (a JSON object of
+    command -> count) override the built-in defaults.
+    """
+    max_oids = dict(DEFAULT_MAX_OIDS)
+    max_oids.update({str(command).upper(): int(count) for command, count in _load_json_env("SNMP_MAX_OIDS").items()})
+    return max_oids
+
+
+def _load_known_oids() -> Dict[str, List[str]]:
+    """
+    Load the OID patterns that query validation accepts, per SNMP command.
+
+    Patterns from the SNMP_KNOWN_OIDS environment variable (a JSON object of
+    command or "*" -> list of patterns) are added to the built-in defaults.
+    """
+    known_oids = {command: list(patterns) for command, patterns in DEFAULT_KNOWN_OIDS.items()}
+    for command, patterns in _load_json_env("SNMP_KNOWN_OIDS").items():
+        if not isinstance(patterns, list):
+            raise ValueError(f"SNMP_KNOWN_OIDS entry for {command} must be a list of OID patterns")
+        for pattern in patterns:
+            parts = str(pattern).strip(".").split(".")
+            if not all(part == "*" or part.isdigit() for part in parts):
+                raise ValueError(f"Invalid OID pattern in SNMP_KNOWN_OIDS: {pattern}")
+        known_oids.setdefault(str(command).upper(), []).extend(str(pattern).strip(".") for pattern in patterns)
+    return known_oids
+
+
 class SNMPConfig(BaseModel):
     default_community: str = "public"
-    default_version: str = "2c"
+    default_version: str = os.getenv("SNMP_DEFAULT_VERSION", "2c")  # "auto" negotiates it per target
     default_port: int = 161
     timeout: int = 5
     retries: int = 3
+    # Timeout of a fast-fail request, which is sent once with no retries
+    fast_timeout: int = int(os.getenv("SNMP_FAST_TIMEOUT", "1"))  # seconds
+    walk_deadline: int = int(os.getenv("SNMP_WALK_DEADLINE", "60"))  # overall seconds for a WALK
+    # Cache WALK progress as it arrives, so an interrupted walk resumes where it stopped
+    walk_checkpoints: bool = os.getenv("SNMP_WALK_CHECKPOINTS", "false").lower() == "true"
+    walk_checkpoint_ttl: int = int(os.getenv("SNMP_WALK_CHECKPOINT_TTL", "300"))  # seconds
+    max_connections_per_target: int = int(os.getenv("SNMP_MAX_CONNECTIONS_PER_TARGET", "4"))
+    # Seconds an unused socket to a target is kept open for the next request (0 opens one per request)
+    pool_idle_timeout: int = int(os.getenv("SNMP_POOL_IDLE_TIMEOUT", "60"))
+    max_pdu_varbinds: int = int(os.getenv("SNMP_MAX_PDU_VARBINDS", "50"))  # per GetBulk response
+    # Column chunks of a BULK fetched at once, within max_connections_per_target (1 walks them in turn)
+    column_walk_concurrency: int = int(os.getenv("SNMP_COLUMN_WALK_CONCURRENCY", "4"))
+    multi_retry_budget: int = int(os.getenv("SNMP_MULTI_RETRY_BUDGET", "10"))  # retries shared by a fan-out
+    # Pre-flight checks before a query: the target must resolve, and optionally answer SNMP quickly
+    preflight_dns: bool = os.getenv("SNMP_PREFLIGHT_DNS", "true").lower() == "true"
+    preflight_reachability: bool = os.getenv("SNMP_PREFLIGHT_REACHABILITY", "false").lower() == "true"
+    preflight_timeout: float = float(os.getenv("SNMP_PREFLIGHT_TIMEOUT", "1"))  # seconds
+    preflight_cache_ttl: int = int(os.getenv("SNMP_PREFLIGHT_CACHE_TTL", "30"))  # seconds
+    interface_cache_ttl: int = int(os.getenv("SNMP_INTERFACE_CACHE_TTL", "60"))  # seconds, ifName/ifAlias per target
+    # Version "auto": versions probed in order (v3 first when the query has a username), one send each
+    negotiate_versions: List[str] = [
+        version.strip() for version in os.getenv("SNMP_NEGOTIATE_VERSIONS", "2c,1").split(",") if version.strip()
+    ]
+    version_probe_timeout: int = int(os.getenv("SNMP_VERSION_PROBE_TIMEOUT", "2"))  # seconds per probe
+    version_cache_ttl: int = int(os.getenv("SNMP_VERSION_CACHE_TTL", "86400"))  # seconds, negotiated version per target
+    # SNMPv3 user of queries that ask for v3 without a username of their own
+    v3_security_name: str = os.getenv("SNMP_V3_SECURITY_NAME", "")
+    # noAuthNoPriv, authNoPriv or authPriv; empty derives it from the passphrases that are set
+    v3_security_level: str = os.getenv("SNMP_V3_SECURITY_LEVEL", "")
+    v3_auth_protocol: str = os.getenv("SNMP_V3_AUTH_PROTOCOL", "SHA").upper()  # MD5 or SHA
+    v3_auth_passphrase: str = os.getenv("SNMP_V3_AUTH_PASSPHRASE", "")
+    v3_priv_protocol: str = os.getenv("SNMP_V3_PRIV_PROTOCOL", "AES").upper()  # DES or AES
+    v3_priv_passphrase: str = os.getenv("SNMP_V3_PRIV_PASSPHRASE", "")
+    # Allow SET queries, which write to devices; values are checked against the objects' SYNTAX first
+    allow_set: bool = os.getenv("SNMP_ALLOW_SET", "false").lower() == "true"
+    # Subtrees SET may write to, as numeric OIDs or names (e.g. IF-MIB::ifAdminStatus); empty allows none
+    set_allowed_oids: List[str] = [
+        oid.strip() for oid in os.getenv("SNMP_SET_ALLOWED_OIDS", "").split(",") if oid.strip()
+    ]
+    # A SET identical to one from the same API key within this many seconds gets its result instead of
+    # being sent again, e.g. after a double-click (0 disables it)
+    set_dedup_window: float = float(os.getenv("SNMP_SET_DEDUP_WINDOW", "5"))
+    # Per-target reliability stats: outcomes of the last window seconds, for at most max_targets targets
+    stats_window: int = int(os.getenv("SNMP_STATS_WINDOW", "3600"))  # seconds
+    stats_max_targets: int = int(os.getenv("SNMP_STATS_MAX_TARGETS", "1000"))
+    stats_max_samples: int = int(os.getenv("SNMP_STATS_MAX_SAMPLES", "500"))  # per target
+    # Cost estimates: rows assumed per table when nothing better is known, and seconds per SNMP round trip
+    estimate_table_rows: int = int(os.getenv("SNMP_ESTIMATE_TABLE_ROWS", "100"))
+    estimate_round_trip: float = float(os.getenv("SNMP_ESTIMATE_ROUND_TRIP", "0.05"))
+    target_communities: Dict[str, List[str]] = _load_target_communities()
+    # Communities and v3 users per host, network or default, for queries without credentials of their own
+    target_credentials: Dict[str, TargetCredentials] = _load_target_credentials()
+    # Device roles labeling SNMP metrics, by host or network, so they don't carry raw addresses
+    target_classes: Dict[str, List[str]] = _load_target_classes()
+    max_oids: Dict[str, int] = _load_max_oids()
+    # OIDs with more sub-identifiers are rejected before anything else (SNMP itself allows 128)
+    max_oid_arcs: int = int(os.getenv("SNMP_MAX_OID_ARCS", "128"))
+    # Reject OIDs that no loaded MIB defines and no known pattern matches, e.g. invented by the LLM
+    validate_oids: bool = os.getenv("SNMP_VALIDATE_OIDS", "true").lower() == "true"
+    known_oids: Dict[str, List[str]] = _load_known_oids()
+
+
+class PollerConfig(BaseModel):
+    interval: int = int(os.getenv("POLL_INTERVAL", "60"))  # seconds
+    max_interval: int = int(os.getenv("POLL_MAX_INTERVAL", "900"))  # seconds
+    backoff_factor: float = 2.0
+
+
+class SubscriptionConfig(BaseModel):
+    default_interval: int = int(os.getenv("SUBSCRIPTION_INTERVAL", "30"))  # seconds
+    min_interval: int = int(os.getenv("SUBSCRIPTION_MIN_INTERVAL", "5"))  # seconds
+    max_interval: int = int(os.getenv("SUBSCRIPTION_MAX_INTERVAL", "3600"))  # seconds
+    max_lifetime: int = int(os.getenv("SUBSCRIPTION_MAX_LIFETIME", "3600"))  # seconds
+
+
+class RateLimitConfig(BaseModel):
+    # Requests a minute each client IP may send, in bursts of up to ip_burst (0 disables the limit)
+    per_ip: float = float(os.getenv("RATE_LIMIT_PER_IP", "0"))
+    ip_burst: int = int(os.getenv("RATE_LIMIT_IP_BURST", "20"))
+    max_clients: int = int(os.getenv("RATE_LIMIT_MAX_CLIENTS", "10000"))  # client IPs tracked at once
+    # Requests a minute each client (API key, or IP without one) may send to the endpoints interpreting
+    # or running queries, and to the MIB and OID lookups, in bursts of up to the burst (0 disables a limit)
+    query_per_minute: float = float(os.getenv("RATE_LIMIT_QUERY", "0"))
+    query_burst: int = int(os.getenv("RATE_LIMIT_QUERY_BURST", "5"))
+    mib_per_minute: float = float(os.getenv("RATE_LIMIT_MIB", "0"))
+    mib_burst: int = int(os.getenv("RATE_LIMIT_MIB_BURST", "30"))
+    # Addresses or networks of the proxies in front of the server, whose X-Forwarded-For is honored
+    trusted_proxies: List[str] = [
+        proxy.strip() for proxy in os.getenv("TRUSTED_PROXIES", "").split(",") if proxy.strip()
+    ]
+
+
+class DemoConfig(BaseModel):
+    # Start the built-in SNMP simulator and point queries for the "demo" target at it
+    enabled: bool = os.getenv("DEMO_MODE", "false").lower() == "true"
+    host: str = os.getenv("DEMO_SNMP_HOST", "127.0.0.1")
+    port: int = int(os.getenv("DEMO_SNMP_PORT", "1161"))
+    # snmpsim-style .snmprec file with the objects to serve (built-in demo data if unset)
+    data_file: Optional[str] = os.getenv("DEMO_SNMPREC") or None
 
 
 class OpenAIConfig(BaseModel):
+    # Backend interpreting queries: openai, anthropic or ollama
+    provider: str = os.getenv("LLM_PROVIDER", "openai").lower()
     api_key: str = os.getenv("OPENAI_API_KEY", "")
-    model: str = os.getenv("OPENAI_MODEL", "gpt-4")
+    # Model of the provider, e.g. gpt-4, claude-sonnet-4-5 or llama3.1 (OPENAI_MODEL is still read)
+    model: str = os.getenv("LLM_MODEL", os.getenv("OPENAI_MODEL", "gpt-4"))
+    anthropic_api_key: str = os.getenv("ANTHROPIC_API_KEY", "")
+    anthropic_url: str = os.getenv("ANTHROPIC_URL", "https://api.anthropic.com")
+    ollama_url: str = os.getenv("OLLAMA_URL", "http://localhost:11434")
+    timeout: float = float(os.getenv("LLM_TIMEOUT", "60"))  # seconds per Anthropic or Ollama request
     temperature: float = 0.1
     max_tokens: int = 2000
+    # Debug logging of prompts and completions, redacted and capped at log_max_chars per message
+    log_io: bool = os.getenv("LLM_LOG_IO", "false").lower() == "true"
+    log_max_chars: int = int(os.getenv("LLM_LOG_MAX_CHARS", "4000"))
+    log_redact_patterns: List[str] = _load_llm_log_redact_patterns()
+    # Interpretations requested within batch_max_wait seconds share one LLM call, up to batch_max_size
+    batch_interpretations: bool = os.getenv("LLM_BATCH_INTERPRETATIONS", "false").lower() == "true"
+    batch_max_size: int = int(os.getenv("LLM_BATCH_MAX_SIZE", "8"))
+    batch_max_wait: float = float(os.getenv("LLM_BATCH_MAX_WAIT", "0.05"))  # seconds
+    # Re-prompt the model once with the validation error when an interpreted query is invalid
+    correct_interpretations: bool = os.getenv("LLM_CORRECT_INTERPRETATIONS", "false").lower() == "true"
+    # Most recently used interpretations kept per query, model and prompt (0 disables), saved to
+    # interpretation_cache_file at shutdown (if set) and the interpretation_warmup latest reloaded at startup
+    interpretation_cache_size: int = int(os.getenv("LLM_INTERPRETATION_CACHE_SIZE", "0"))
+    interpretation_cache_file: str = os.getenv("LLM_INTERPRETATION_CACHE_FILE", "")
+    interpretation_warmup: int = int(os.getenv("LLM_INTERPRETATION_WARMUP", "100"))
+    # Stream single interpretations, checking each member as it completes to reject invalid ones early
+    stream_interpretations: bool = os.getenv("LLM_STREAM_INTERPRETATIONS", "false").lower() == "true"
     system_prompt: str = """
 You are a specialized AI assistant for SNMP queries. Your role is to convert natural language
 SNMP queries into structured JSON requests that can be processed by an SNMP scanner.
@@ -47,13 +461,32 @@ Where:
 - "target.port" is the SNMP port (default: 161)
 - "target.timeout" is the timeout in seconds (default: 5)
 - "target.retries" is the number of retries (default: 3)
-- "credentials.version" is the SNMP version: "1", "2c", or "3" (default: "2c")
+- "credentials.version" is the SNMP version: "1", "2c", "3", or "auto" to detect it (default: "2c")
 - "credentials.community" is the community string for v1/v2c (default: "public")
-- "operation.command" is one of: "GET", "GETNEXT", "WALK", "BULK" (REQUIRED)
-- "operation.oids" is an array of OID strings (REQUIRED)
+- "operation.command" is one of: "GET", "GETNEXT", "WALK", "BULK", "SET", or "WALKTABLE" to walk
+  tables and get their rows back, e.g. for "show the interface table" (REQUIRED)
+- "operation.oids" is an array of OID strings (REQUIRED, empty for SET)
 - "operation.mib_names" is an array of MIB names (optional)
+- "operation.indexes" is an array of table row indexes to GET from each column OID, e.g. ["3"]
+  for "ifInOctets for interface 3" (optional)
+- "operation.index_from" and "operation.index_to" limit a WALK of column OIDs to a range of row
+  indexes, e.g. 1 and 4 for "interfaces 1 through 4" (optional)
+- "operation.set_values" is an array of the values a SET writes, each an object instance with
+  the ASN.1 type and value of its SYNTAX: {"oid": "IF-MIB::ifAdminStatus.3", "type": "Integer",
+  "value": 2} for "set ifAdminStatus of interface 3 to down". Types are "Integer", "OctetString",
+  "ObjectIdentifier", "IpAddress", "Counter32", "Gauge32", "TimeTicks" or "Unsigned32";
+  enumerated values may be given by label, e.g. "down" (only for SET)
 
 Don't deviate from this exact structure. Every field must appear exactly as shown.
+
+If the query is ambiguous (for example it names no device, or could mean several different
+objects), don't guess. Respond instead with:
+{
+  "needs_clarification": true,
+  "missing": ["target.host"],
+  "questions": ["Which device should be queried?"],
+  "suggestions": ["get interface statistics for 10.0.0.1"]
+}
 """
 
 
@@ -61,12 +494,94 @@ class AppConfig(BaseModel):
     app_name: str = "SNMP-AI"
     debug: bool = os.getenv("DEBUG", "False").lower() == "true"
     mib_directory: str = os.getenv("MIB_DIRECTORY", "./mibs")
+    # Which definition names an OID several modules define: first-wins, last-wins or error (refuse to load it)
+    mib_duplicate_policy: str = os.getenv("MIB_DUPLICATE_POLICY", "first-wins").lower()
+    # Whether objects named in MIB descriptions ("see ifTable") are linked, as related on /oid/info
+    mib_cross_references: bool = os.getenv("MIB_CROSS_REFERENCES", "true").lower() == "true"
+    # Processes parsing the MIB directory at startup, at most one per CPU; 0 for one per CPU, 1 for none
+    mib_parse_workers: int = int(os.getenv("MIB_PARSE_WORKERS", "0"))
+    # Where POST /mibs/download fetches modules from, with {module} for the module name
+    mib_repository_url: str = os.getenv("MIB_REPOSITORY_URL", "")
+    # SHA-256 of each module at the source, with {module}; unchanged modules are then not downloaded again
+    mib_repository_checksum_url: str = os.getenv("MIB_REPOSITORY_CHECKSUM_URL", "")
+    mib_download_timeout: float = float(os.getenv("MIB_DOWNLOAD_TIMEOUT", "30"))  # seconds per file
+    oid_aliases: Dict[str, str] = _load_oid_aliases()
+    device_models: Dict[str, str] = _load_device_models()
+    macros: Dict[str, Dict[str, Any]] = _load_macros()
+    api_keys: Dict[str, APIKeyPolicy] = _load_api_keys()
     cache_enabled: bool = True
     cache_ttl: int = 3600  # seconds
+    # How long expired query responses are kept for stale_if_error, and how long a failing host is not retried
+    cache_stale_ttl: int = int(os.getenv("CACHE_STALE_TTL", "86400"))
+    negative_cache_ttl: int = int(os.getenv("NEGATIVE_CACHE_TTL", "30"))
+    # Values larger than this many bytes (as JSON) are not cached (0 disables the limit)
+    cache_max_entry_size: int = int(os.getenv("CACHE_MAX_ENTRY_SIZE", "1048576"))
+    # Responses with more results than this are streamed as JSON a chunk at a time
+    stream_json_threshold: int = int(os.getenv("STREAM_JSON_THRESHOLD", "1000"))
+    # How long a /plan edit token can be executed, and how many plans are kept at most
+    plan_ttl: int = int(os.getenv("PLAN_TTL", "900"))
+    plan_max: int = int(os.getenv("PLAN_MAX", "1000"))
+    # How long the whole results of a paginated /query are kept for its later pages, and how many are kept at most
+    page_ttl: int = int(os.getenv("PAGE_TTL", "600"))
+    page_max: int = int(os.getenv("PAGE_MAX", "100"))
+    # How long an idle X-Session-ID conversation is remembered, how many are kept, and how many results each keeps
+    session_ttl: int = int(os.getenv("SESSION_TTL", "1800"))
+    session_max: int = int(os.getenv("SESSION_MAX", "1000"))
+    session_max_results: int = int(os.getenv("SESSION_MAX_RESULTS", "50"))
+    # Overall deadline of a /query request, from interpretation to summary (0 disables it)
+    request_timeout: float = float(os.getenv("API_REQUEST_TIMEOUT", "120"))
+    # How often, in seconds, a running query checks whether its client disconnected, to stop its SNMP requests
+    # (0 disables the check)
+    disconnect_check_interval: float = float(os.getenv("API_DISCONNECT_CHECK_INTERVAL", "0.5"))
+    # "problem" returns errors as RFC 7807 application/problem+json, "legacy" as {"detail": ...} bodies
+    error_format: str = os.getenv("API_ERROR_FORMAT", "problem").lower()
+    problem_type_base: str = os.getenv("API_PROBLEM_TYPE_BASE", "/problems/")  # prefix of problem type URIs
     log_level: str = os.getenv("LOG_LEVEL", "INFO")
+    # Maintenance mode at startup: off, read-only (SET queries are rejected) or all (every SNMP request is);
+    # switched at runtime with PUT /maintenance
+    maintenance_mode: str = os.getenv("MAINTENANCE_MODE", "off").lower()
+    # "hybrid" tries keyword rules before the LLM, "rules" never calls the LLM, "llm" always does
+    interpreter_mode: str = os.getenv("INTERPRETER_MODE", "hybrid").lower()
     snmp: SNMPConfig = SNMPConfig()
+    poller: PollerConfig = PollerConfig()
+    subscription: SubscriptionConfig = SubscriptionConfig()
+    rate_limit: RateLimitConfig = RateLimitConfig()
+    demo: DemoConfig = DemoConfig()
     openai: OpenAIConfig = OpenAIConfig()
 
+    def validate_settings(self):
+        """
+        Check the settings that would otherwise only fail at runtime, e.g. on the first query
+
+        Raises:
+            ConfigError: For the first invalid setting, with its environment variable and what is wrong
+        """
+        if self.snmp.default_version not in SNMP_VERSIONS:
+            raise ConfigError(f"SNMP_DEFAULT_VERSION must be one of {', '.join(SNMP_VERSIONS)}, "
+                              f"not {self.snmp.default_version!r}")
+        for name, value in (("SNMP timeout", self.snmp.timeout), ("SNMP retries", self.snmp.retries),
+                            ("SNMP_FAST_TIMEOUT", self.snmp.fast_timeout),
+                            ("SNMP_WALK_DEADLINE", self.snmp.walk_deadline)):
+            if value < 0:
+                raise ConfigError(f"{name} must not be negative, not {value}")
+        if not 0 <= self.openai.temperature <= 2:
+            raise ConfigError(f"LLM temperature must be between 0 and 2, not {self.openai.temperature}")
+        # Without the LLM, no API key is needed
+        key_setting = LLM_API_KEY_SETTINGS.get(self.openai.provider)
+        if key_setting and self.interpreter_mode != "rules" and not getattr(self.openai, key_setting[0]):
+            raise ConfigError(f"LLM_PROVIDER={self.openai.provider} needs {key_setting[1]} "
+                              f"(or INTERPRETER_MODE=rules to run without the LLM)")
+        if not self.mib_directory.strip():
+            raise ConfigError("MIB_DIRECTORY must not be empty")
+
 
 # Create a singleton config instance
 config = AppConfig()
+
+# Configured credentials are scrubbed from every error and log message
+for _secret in [config.snmp.default_community, config.openai.api_key, config.openai.anthropic_api_key,
+                *config.api_keys, config.snmp.v3_auth_passphrase, config.snmp.v3_priv_passphrase,
+                *(community for communities in config.snmp.target_communities.values() for community in communities),
+                *(secret for credentials in config.snmp.target_credentials.values()
+                  for secret in [*credentials.communities, credentials.auth_password, credentials.priv_password])]:
+    register_secret(_secret, configured=True)
@@ -1,14 +1,19 @@
-from typing import Dict, Any, List, Optional, Union
-from pydantic import BaseModel, Field
+from typing import Dict, Any, List, Optional, Tuple, Union
+from pydantic import BaseModel, Field, field_validator, model_validator
+
+from app.utils.targets import split_target
 
 
 class SNMPCredentials(BaseModel):
     """SNMP authentication credentials"""
-    version: str = Field("2c", description="SNMP version (1, 2c, 3)")
+    version: str = Field("2c", description="SNMP version (1, 2c, 3, or auto to negotiate it)")
     community: Optional[str] = Field(None, description="Community string for SNMP v1/v2c")
 
     # SNMPv3 specific fields
     username: Optional[str] = Field(None, description="Username for SNMPv3")
+    security_level: Optional[str] = Field(
+        None, description="Security level for SNMPv3 (noAuthNoPriv, authNoPriv, authPriv); derived from the passwords if not given"
+    )
     auth_protocol: Optional[str] = Field(None, description="Authentication protocol for SNMPv3 (MD5, SHA)")
     auth_password: Optional[str] = Field(None, description="Authentication password for SNMPv3")
     priv_protocol: Optional[str] = Field(None, description="Privacy protocol for SNMPv3 (DES, AES)")
@@ -18,18 +23,64 @@ class SNMPCredentials(BaseModel):
 class SNMPTarget(BaseModel):
     """Target information for SNMP query"""
     host: str = Field(..., description="Target IP address or hostname")
-    port: int = Field(161, description="SNMP port")
-    timeout: int = Field(5, description="Timeout in seconds")
-    retries: int = Field(3, description="Number of retries")
+    port: int = Field(161, ge=1, le=65535, description="SNMP port")
+    timeout: int = Field(5, ge=1, description="Timeout in seconds")
+    retries: int = Field(3, ge=0, description="Number of retries (0 sends each request once)")
+
+    @model_validator(mode="before")
+    @classmethod
+    def _split_host_port(cls, data: Any) -> Any:
+        """Normalize the host, taking the port from it when given as host:port or [IPv6]:port"""
+        # A blank host is left for the interpreter to ask about
+        if isinstance(data, dict) and isinstance(data.get("host"), str) and data["host"].strip():
+            host, port = split_target(data["host"])
+            data = {**data, "host": host}
+            if port is not None:
+                data["port"] = port
+        return data
+
+
+# ASN.1 types a SET may write, as named in SMIv2
+SET_VALUE_TYPES = (
+    "Integer", "OctetString", "ObjectIdentifier", "IpAddress", "Counter32", "Gauge32", "TimeTicks", "Unsigned32"
+)
+
+
+class SNMPSetValue(BaseModel):
+    """A typed value to write to an object instance with SET"""
+    oid: str = Field(..., description="Object instance to write, e.g. IF-MIB::ifAdminStatus.3")
+    type: Optional[str] = Field(None, description="ASN.1 type of the value (one of SET_VALUE_TYPES); "
+                                                  "taken from the object's SYNTAX when omitted")
+    value: Union[int, str] = Field(..., description="Value to write; enumerated objects also accept the label, e.g. down")
 
 
 class SNMPOperation(BaseModel):
     """SNMP operation details"""
-    command: str = Field(..., description="SNMP command (GET, GETNEXT, WALK, BULK, etc.)")
+    command: str = Field(..., description="SNMP command (GET, GETNEXT, WALK, BULK, SET, etc.), or WALKTABLE")
     oids: List[str] = Field([], description="List of OIDs to query")
+    set_values: List[SNMPSetValue] = Field([], description="Typed values to write, for SET operations")
     mib_names: List[str] = Field([], description="List of MIB names to query")
     max_repetitions: Optional[int] = Field(None, description="Max repetitions for BULK operations")
     non_repeaters: Optional[int] = Field(None, description="Non-repeaters for BULK operations")
+    deadline: Optional[int] = Field(None, description="Overall deadline in seconds for WALK operations")
+    indexes: List[str] = Field([], description="Table row indexes to GET from each column OID, e.g. [\"3\"] or [\"1\", \"4\"]")
+    index_from: Optional[int] = Field(None, ge=0, description="First row index to keep when walking columns (inclusive)")
+    index_to: Optional[int] = Field(None, ge=0, description="Last row index to keep when walking columns (inclusive)")
+
+    def effective_command(self) -> str:
+        """
+        The SNMP command actually sent: explicit row indexes are fetched as exact cells with GET,
+        and a WALKTABLE is a WALK whose results are put back into rows
+        """
+        if self.indexes:
+            return "GET"
+        return "WALK" if self.command.upper() == "WALKTABLE" else self.command.upper()
+
+    def index_range(self) -> Optional[Tuple[int, Optional[int]]]:
+        """The (first, last) row index range to walk, or None for the whole table"""
+        if self.index_from is None and self.index_to is None:
+            return None
+        return self.index_from or 0, self.index_to
 
 
 class SNMPQuery(BaseModel):
@@ -39,10 +90,183 @@ class SNMPQuery(BaseModel):
     operation: SNMPOperation
     raw_query: Optional[str] = Field(None, description="Original natural language query")
 
+    def plan(self) -> Dict[str, Any]:
+        """The interpreted target, credentials and operation, with secrets omitted"""
+        return self.dict(exclude={
+            "credentials": {"community", "auth_password", "priv_password"},
+            "raw_query": True,
+        })
+
+
+class Clarification(BaseModel):
+    """What the interpreter needs to know before it can run an ambiguous query"""
+    missing: List[str] = Field([], description="Missing or ambiguous parts of the query, e.g. target.host")
+    questions: List[str] = Field([], description="Questions to put to the user")
+    suggestions: List[str] = Field([], description="Suggested answers or rephrased queries")
+
+
+class SNMPResult(BaseModel):
+    """A single SNMP value with its MIB information"""
+    oid: Optional[str] = Field(None, description="Numeric OID")
+    name: Optional[str] = Field(None, description="Symbolic name")
+    value: Any = Field(None, description="Formatted value")
+    mib: Optional[str] = Field(None, description="MIB module that defines the object")
+    description: Optional[str] = Field(None, description="DESCRIPTION of the object, from its loaded MIB")
+    index: Optional[Dict[str, Any]] = Field(None, description="Values of the table row's index objects, e.g. {\"ifIndex\": 5}")
+    enterprise_number: Optional[int] = Field(None, description="IANA enterprise number, for enterprise OIDs no loaded MIB defines")
+    vendor: Optional[str] = Field(None, description="Vendor owning the enterprise number, if known")
+    if_name: Optional[str] = Field(None, description="ifName of the interface, for interface table results")
+    if_alias: Optional[str] = Field(None, description="Operator-assigned ifAlias of the interface, for interface table results")
+    decoded: Any = Field(None, description="Readable form of an opaque value, e.g. the layers of sysServices")
+    warnings: List[str] = Field([], description="Non-fatal issues enriching this result, e.g. missing MIB information")
+
+
+class TableRow(BaseModel):
+    """A row of a walked table, its cells by column name"""
+    table: str = Field(..., description="Requested table OID the row belongs to")
+    entry: Optional[str] = Field(None, description="OID of the table's row object, e.g. 1.3.6.1.2.1.2.2.1 for ifEntry")
+    instance: str = Field(..., description="Instance sub-identifiers shared by the row's cells, e.g. \"5\"")
+    index: Optional[Dict[str, Any]] = Field(None, description="Values of the row's index objects, e.g. {\"ifIndex\": 5}")
+    columns: Dict[str, Any] = Field({}, description="Column name -> value, e.g. {\"ifDescr\": \"eth0\"}")
+
+
+# Why a query returned no data, and how it is explained to users
+EMPTY_NO_OBJECTS = "no-objects"
+EMPTY_ALL_FILTERED = "all-filtered"
+EMPTY_END_OF_MIB_VIEW = "endOfMibView-immediately"
+EMPTY_REASONS = {
+    EMPTY_NO_OBJECTS: "the device has no objects under the requested OIDs (e.g. it doesn't implement that table)",
+    EMPTY_ALL_FILTERED: "the device returned objects, but none of them matched the requested rows",
+    EMPTY_END_OF_MIB_VIEW: "the requested OIDs are past the end of the device's MIB view, so it has nothing there",
+}
+
+
+class CostEstimate(BaseModel):
+    """Rough cost of running a query, estimated from its interpreted plan"""
+    command: str = Field(..., description="SNMP command that would be sent")
+    targets: int = Field(1, description="Number of targets the query runs against")
+    snmp_round_trips: int = Field(..., description="Approximate SNMP requests across all targets, without retries")
+    varbinds: int = Field(..., description="Approximate values returned across all targets")
+    llm_tokens: int = Field(0, description="Approximate LLM tokens to summarize the result")
+    latency_seconds: float = Field(..., description="Rough time to complete, assuming responsive targets")
+    assumptions: List[str] = Field([], description="Table sizes and other guesses the estimate rests on")
+
 
 class SNMPResponse(BaseModel):
     """SNMP response model"""
     raw_data: Dict[str, Any] = Field(..., description="Raw SNMP response data")
+    results: List[SNMPResult] = Field([], description="Per-OID results with MIB information")
     summary: str = Field(..., description="Human-readable summary of the response")
     query: str = Field(..., description="Original natural language query")
     error: Optional[str] = Field(None, description="Error message if the query failed")
+    error_code: Optional[str] = Field(None, description="Machine-readable error code, e.g. SNMP_AUTH_FAILED")
+    cached: bool = Field(False, description="Whether the response was served from the cache")
+    cached_at: Optional[str] = Field(None, description="When the cached response was produced (ISO 8601)")
+    stale: bool = Field(False, description="Whether this is an expired cached result returned because the live query failed")
+    cache_skipped: Optional[str] = Field(None, description="Why the response was not cached, e.g. entry-too-large")
+    age: Optional[int] = Field(None, description="Age in seconds of a stale result")
+    device: Optional[Dict[str, Any]] = Field(None, description="Vendor and model of the target, only present when requested")
+    plan: Optional[Dict[str, Any]] = Field(None, description="Interpreted query that was executed, with secrets omitted")
+    clarification: Optional[Clarification] = Field(None, description="Set instead of results when the query is ambiguous")
+    computed: Optional[Dict[str, Any]] = Field(None, description="Computed fields requested with the query, keyed by field and index")
+    warnings: List[str] = Field([], description="Non-fatal issues across all results")
+    empty_reason: Optional[str] = Field(
+        None, description="Why the query returned no values: no-objects, all-filtered or endOfMibView-immediately"
+    )
+    fallback: Optional[str] = Field(
+        None, description="Command the data was collected with instead of the requested one, e.g. WALK when GETBULK failed"
+    )
+    groups: Optional[Dict[str, List[str]]] = Field(None, description="Result names per requested OID, only present when requested")
+    rows: Optional[List[TableRow]] = Field(None, description="Results put back into table rows, only present for WALKTABLE")
+    page: Optional[Dict[str, Any]] = Field(
+        None, description="Total number of results and the cursor of the next page, only present when paginated"
+    )
+    estimate: Optional[CostEstimate] = Field(None, description="Estimated cost of the query, only present for dry runs")
+    correction: Optional[Dict[str, Any]] = Field(
+        None, description="The LLM's correction of an interpretation that failed validation, if one was attempted"
+    )
+    debug: Optional[Dict[str, Any]] = Field(None, description="Debug details, only present when requested")
+
+
+class PlanResponse(BaseModel):
+    """Interpreted query to review and edit before running it with /execute"""
+    query: str = Field(..., description="Original natural language query")
+    plan: Dict[str, Any] = Field(..., description="Interpreted query, with secrets omitted")
+    plan_token: str = Field(..., description="Edit token to send to /execute with the (edited) plan")
+    expires_in: int = Field(..., description="Seconds until the edit token expires")
+    error: Optional[str] = Field(None, description="Why the plan wouldn't run as interpreted, if it wouldn't")
+    correction: Optional[Dict[str, Any]] = Field(
+        None, description="The LLM's correction of an interpretation that failed validation, if one was attempted"
+    )
+
+
+class ExecutePlanRequest(BaseModel):
+    """A plan from /plan, possibly edited, to run"""
+    plan_token: str = Field(..., description="Edit token returned by /plan")
+    plan: SNMPQuery = Field(..., description="The plan, as returned by /plan or edited")
+
+
+class MultiTargetQuery(BaseModel):
+    """Natural language query to run against several targets"""
+    query: str = Field(..., description="Natural language SNMP query")
+    targets: List[str] = Field(..., min_length=1, description="Target IP addresses or hostnames")
+    retry_budget: Optional[int] = Field(None, ge=0, description="Total retries across all targets (server default if not given)")
+    dry_run: bool = Field(False, description="Interpret the query and estimate its cost without running it")
+
+    @field_validator("targets")
+    @classmethod
+    def _check_targets(cls, targets: List[str]) -> List[str]:
+        """Reject targets that aren't a valid host or host:port"""
+        for target in targets:
+            split_target(target)
+        return targets
+
+
+class TargetResult(BaseModel):
+    """Outcome of a query against a single target"""
+    host: str = Field(..., description="Target IP address or hostname")
+    status: int = Field(..., description="HTTP-style status code for this target")
+    raw_data: Dict[str, Any] = Field({}, description="Raw SNMP response data")
+    error: Optional[str] = Field(None, description="Error message if the query failed for this target")
+    error_code: Optional[str] = Field(None, description="Machine-readable error code, e.g. SNMP_AUTH_FAILED")
+
+
+class MultiTargetResponse(BaseModel):
+    """Per-target outcomes of a multi-target query"""
+    query: str = Field(..., description="Original natural language query")
+    results: List[TargetResult] = Field([], description="Outcome for each target")
+    succeeded: int = Field(0, description="Number of targets that succeeded")
+    failed: int = Field(0, description="Number of targets that failed")
+    estimate: Optional[CostEstimate] = Field(None, description="Estimated cost of the query, only present for dry runs")
+    correction: Optional[Dict[str, Any]] = Field(
+        None, description="The LLM's correction of an interpretation that failed validation, if one was attempted"
+    )
+
+    @classmethod
+    def from_results(cls, query: str, results: List[TargetResult]) -> "MultiTargetResponse":
+        succeeded = sum(1 for result in results if result.status < 400)
+        return cls(query=query, results=results, succeeded=succeeded, failed=len(results) - succeeded)
+
+    @property
+    def status_code(self) -> int:
+        """200 if every target succeeded, 502 if all failed, 207 Multi-Status otherwise"""
+        if self.failed == 0:
+            return 200
+        if self.succeeded == 0:
+            return 502
+        return 207
+
+
+class MacroStepResult(BaseModel):
+    """Outcome of one step of a macro"""
+    label: str = Field(..., description="Step label from the macro definition")
+    plan: Dict[str, Any] = Field(..., description="Query the step ran, with secrets omitted")
+    raw_data: Dict[str, Any] = Field({}, description="Raw SNMP response data")
+    results: List[SNMPResult] = Field([], description="Per-OID results with MIB information")
+    error: Optional[str] = Field(None, description="Error message if the step failed")
+
+
+class MacroResponse(BaseModel):
+    """Labeled results of running a macro"""
+    macro: str = Field(..., description="Macro name")
+    steps: List[MacroStepResult] = Field([], description="Result of each step, in order")
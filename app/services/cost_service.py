@@ -0,0 +1,132 @@
+import math
+from typing import List, Optional, Tuple
+
+from app.core.config import config
+from app.models.query import SNMPQuery, CostEstimate
+from app.services.interface_service import INTERFACE_TABLE_ENTRIES
+from app.services.snmp_service import SNMPService
+from app.utils.cache import get_cache
+from app.utils.targets import format_target
+
+# Interface tables, whose row count is known once a target's interface labels are cached
+INTERFACE_TABLES = tuple(entry.rsplit(".", 1)[0] for entry in INTERFACE_TABLE_ENTRIES)
+
+# LLM tokens of the summary: the prompt around the data, each value in it, and the answer
+SUMMARY_PROMPT_TOKENS = 250
+TOKENS_PER_VARBIND = 20
+SUMMARY_ANSWER_TOKENS = 200
+
+# Rough time for the LLM to write a summary
+SUMMARY_SECONDS = 3.0
+
+
+class CostService:
+    def __init__(self, snmp_service: Optional[SNMPService] = None):
+        """Initialize the query cost estimation service"""
+        self.snmp_service = snmp_service or SNMPService()
+
+    def estimate(self, query: SNMPQuery, targets: int = 1, summarize: bool = True) -> CostEstimate:
+        """
+        Estimate the cost of running an interpreted query, without sending anything
+
+        GET and GETNEXT send one request per OID. A WALK fetches each row of each
+        column with a request of its own, plus one past the end of every subtree, and
+        a BULK packs the rows of its columns into requests of at most
+        SNMP_MAX_PDU_VARBINDS values. Table sizes come from the query's index range,
+        the target's cached interface count, or SNMP_ESTIMATE_TABLE_ROWS.
+
+        Args:
+            query: Interpreted query
+            targets: Number of targets the query fans out to
+            summarize: Whether the result will be summarized by the LLM
+
+        Returns:
+            Approximate SNMP round trips, values, LLM tokens and latency, with the assumptions made
+        """
+        operation = query.operation
+        command = operation.effective_command()
+        oids = self.snmp_service.describe_request(query)["oids"]
+        assumptions = []
+        parallel = 1
+
+        if command in ("GET", "GETNEXT", "SET"):
+            varbinds = round_trips = len(oids)
+        else:
+            rows, assumption = self.table_rows(query, oids)
+            assumptions.append(assumption)
+            if command == "BULK":
+                non_repeaters = min(operation.non_repeaters or 0, len(oids))
+                scalars, columns = non_repeaters, sum(self._count_objects(oids[non_repeaters:]))
+                repetitions = operation.max_repetitions or 10
+                # Rows of as many columns as fit in SNMP_MAX_PDU_VARBINDS per request
+                columns_per_request = max(1, config.snmp.max_pdu_varbinds // repetitions)
+                chunks = math.ceil(columns / columns_per_request)
+                round_trips = (1 if scalars else 0) + chunks * math.ceil((rows + 1) / repetitions)
+                parallel = max(1, min(chunks, config.snmp.column_walk_concurrency,
+                                      config.snmp.max_connections_per_target))
+                varbinds = scalars + columns * rows
+            else:
+                scalars, columns = self._count_objects(oids)
+                varbinds = scalars + columns * rows
+                round_trips = varbinds + len(oids)
+                parallel = max(1, min(len(oids), config.snmp.max_connections_per_target))
+
+        # Targets are queried concurrently, so they add requests but not time
+        latency = math.ceil(round_trips / parallel) * config.snmp.estimate_round_trip
+        llm_tokens = 0
+        if summarize:
+            llm_tokens = SUMMARY_PROMPT_TOKENS + varbinds * TOKENS_PER_VARBIND + SUMMARY_ANSWER_TOKENS
+            latency += SUMMARY_SECONDS
+
+        return CostEstimate(
+            command=command,
+            targets=targets,
+            snmp_round_trips=round_trips * targets,
+            varbinds=varbinds * targets,
+            llm_tokens=llm_tokens,
+            latency_seconds=round(latency, 2),
+            assumptions=assumptions
+        )
+
+    def table_rows(self, query: SNMPQuery, oids: List[str]) -> Tuple[int, str]:
+        """
+        Guess the number of rows in the tables a query reads
+
+        Returns:
+            The row count, and the assumption it rests on
+        """
+        first, last = query.operation.index_range() or (None, None)
+        if last is not None:
+            rows = max(0, last - first + 1)
+            return rows, f"{rows} rows per table, from the index range"
+
+        labels = get_cache(f"interfaces_{format_target(query.target.host, query.target.port)}")
+        if labels and oids and all(_in_interface_table(oid) for oid in oids):
+            return len(labels), f"{len(labels)} rows per table, the target's interface count"
+
+        rows = config.snmp.estimate_table_rows
+        return rows, f"{rows} rows per table (SNMP_ESTIMATE_TABLE_ROWS)"
+
+    def _count_objects(self, oids: List[str]) -> Tuple[int, int]:
+        """
+        Count the scalars and the columns under the walked OIDs
+
+        The leaf objects the MIBs define under an OID are counted one by one; an OID
+        with none is taken as a scalar if it ends in .0 and as a single column otherwise.
+        """
+        scalars = columns = 0
+        for oid in oids:
+            # Sorted, an object's descendants directly follow it, so it is a leaf unless the next one is under it
+            objects = sorted(set(self.snmp_service.mib_service.objects_under(oid) or [oid]))
+            leaves = [obj for obj, following in zip(objects, objects[1:] + [""])
+                      if not following.startswith(obj + ".")]
+            for obj in leaves:
+                if obj.endswith(".0"):
+                    scalars += 1
+                else:
+                    columns += 1
+        return scalars, columns
+
+
+def _in_interface_table(oid: str) -> bool:
+    return any(oid == table or oid.startswith(table + ".") for table in INTERFACE_TABLES)
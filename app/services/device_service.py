@@ -0,0 +1,108 @@
+from typing import Dict, Any, Optional
+from loguru import logger
+
+from app.core.config import config, APIKeyPolicy
+from app.models.query import SNMPQuery, SNMPTarget, SNMPCredentials, SNMPOperation
+from app.services.mib_service import is_numeric_oid
+from app.services.snmp_pool import connection_key
+from app.services.snmp_service import SNMPService, fast_fail
+from app.utils.cache import get_cache, set_cache
+from app.utils.enterprises import get_enterprise
+from app.utils.targets import parse_target
+
+SYS_OBJECT_ID = "1.3.6.1.2.1.1.2.0"
+
+
+def identify_sys_object_id(sys_object_id: str) -> Dict[str, Any]:
+    """
+    Map a sysObjectID to a vendor and model.
+
+    The configured device registry is matched first (longest prefix wins), then the
+    enterprise number is used to name the vendor.
+
+    Args:
+        sys_object_id: Numeric sysObjectID value
+
+    Returns:
+        Dictionary with sys_object_id, enterprise_number, vendor and model
+    """
+    sys_object_id = sys_object_id.lstrip(".")
+    enterprise = get_enterprise(sys_object_id)
+
+    model = None
+    matches = [
+        oid for oid in config.device_models
+        if sys_object_id == oid or sys_object_id.startswith(oid + ".")
+    ]
+    if matches:
+        model = config.device_models[max(matches, key=len)]
+
+    return {
+        "sys_object_id": sys_object_id,
+        "enterprise_number": enterprise[0] if enterprise else None,
+        "vendor": enterprise[1] if enterprise else None,
+        "model": model,
+    }
+
+
+class DeviceService:
+    def __init__(self, snmp_service: Optional[SNMPService] = None):
+        """Initialize the device identification service"""
+        self.snmp_service = snmp_service or SNMPService()
+
+    async def identify(self, host: str, port: Optional[int] = None, community: Optional[str] = None,
+                       version: Optional[str] = None, api_key: Optional[APIKeyPolicy] = None,
+                       fast: bool = False, use_cache: bool = True) -> Dict[str, Any]:
+        """
+        Read a device's sysObjectID and identify its vendor and model
+
+        Successful identifications are cached per target and credentials, and only served
+        from the cache to API keys allowed to read sysObjectID.
+
+        Args:
+            host: Target IP address or hostname, optionally with its port (host:port)
+            port: SNMP port, if the host doesn't name one
+            community: Community string for v1/v2c
+            version: SNMP version
+            api_key: Policy of the API key making the request, if any
+            fast: Send the request once with the short fast-fail timeout
+            use_cache: Whether a cached identification will do, rather than asking the device
+
+        Returns:
+            Dictionary with host, reachable, sys_object_id, enterprise_number, vendor
+            and model, or host, reachable and error if the device couldn't be queried
+        """
+        host, port = parse_target(host, port or config.snmp.default_port)
+        query = SNMPQuery(
+            target=SNMPTarget(host=host, port=port),
+            credentials=SNMPCredentials(
+                version=version or config.snmp.default_version,
+                community=community or config.snmp.default_community
+            ),
+            operation=SNMPOperation(command="GET", oids=[SYS_OBJECT_ID])
+        )
+        if fast:
+            fast_fail(query)
+
+        # Checked before the cache, which holds identifications other keys were allowed to make
+        validation_error = self.snmp_service.validate_query(query, api_key=api_key)
+        if validation_error:
+            return {"host": host, "reachable": False, "error": validation_error}
+        cache_key = f"device_{connection_key(host, port, query.credentials.version, query.credentials.community)}"
+        cached_identity = get_cache(cache_key) if use_cache else None
+        if cached_identity:
+            return cached_identity
+
+        result = await self.snmp_service.execute_query(query, api_key=api_key)
+        if "error" in result:
+            return {"host": host, "reachable": False, "error": result["error"]}
+
+        sys_object_id = str(next(iter(result.values()), ""))
+        if not is_numeric_oid(sys_object_id):
+            return {"host": host, "reachable": True, "error": f"Unexpected sysObjectID value: {sys_object_id}"}
+
+        identity = {"host": host, "reachable": True, **identify_sys_object_id(sys_object_id)}
+        logger.info(f"Identified {host} as {identity['vendor'] or 'unknown vendor'} {identity['model'] or ''}".strip())
+
+        set_cache(cache_key, identity)
+        return identity
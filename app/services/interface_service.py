@@ -0,0 +1,112 @@
+from typing import Dict, List, Optional
+from loguru import logger
+
+from app.core.config import config, APIKeyPolicy
+from app.models.query import SNMPQuery, SNMPOperation, SNMPResult
+from app.services.mib_service import is_numeric_oid
+from app.services.snmp_pool import connection_key
+from app.services.snmp_service import SNMPService
+from app.utils.cache import get_cache, set_cache
+
+IF_NAME = "1.3.6.1.2.1.31.1.1.1.1"
+IF_ALIAS = "1.3.6.1.2.1.31.1.1.1.18"
+
+# Tables indexed by ifIndex alone: ifTable and ifXTable entries
+INTERFACE_TABLE_ENTRIES = ("1.3.6.1.2.1.2.2.1", "1.3.6.1.2.1.31.1.1.1")
+
+
+def interface_index(oid: str) -> Optional[str]:
+    """
+    Get the ifIndex of an ifTable or ifXTable cell
+
+    Args:
+        oid: Numeric OID of a result, e.g. 1.3.6.1.2.1.2.2.1.10.3
+
+    Returns:
+        The interface index ("3"), or None if the OID isn't an interface table cell
+    """
+    oid = oid.lstrip(".")
+    for entry in INTERFACE_TABLE_ENTRIES:
+        if oid.startswith(entry + "."):
+            parts = oid[len(entry) + 1:].split(".")
+            if len(parts) == 2 and all(part.isdigit() for part in parts):
+                return parts[1]
+    return None
+
+
+class InterfaceService:
+    def __init__(self, snmp_service: Optional[SNMPService] = None):
+        """Initialize the interface labeling service"""
+        self.snmp_service = snmp_service or SNMPService()
+
+    async def get_labels(self, query: SNMPQuery,
+                         api_key: Optional[APIKeyPolicy] = None) -> Dict[str, Dict[str, Optional[str]]]:
+        """
+        Read the ifName and ifAlias of every interface on a query's target
+
+        Operators relabel interfaces, so the map is only cached per target and credentials
+        for SNMP_INTERFACE_CACHE_TTL seconds. Failures aren't cached, and keys not allowed
+        to read ifName and ifAlias get no labels, cached or not.
+
+        Args:
+            query: Query whose target and credentials are used
+            api_key: Policy of the API key making the request, if any
+
+        Returns:
+            Mapping of ifIndex to {"name", "alias"}, empty if the target couldn't be queried
+        """
+        labels_query = SNMPQuery(
+            target=query.target,
+            credentials=query.credentials,
+            operation=SNMPOperation(command="WALK", oids=[IF_NAME, IF_ALIAS])
+        )
+        # Checked before the cache, which holds labels other keys were allowed to read
+        validation_error = self.snmp_service.validate_query(labels_query, api_key=api_key)
+        if validation_error:
+            logger.debug(f"Not labeling interfaces of {query.target.host}: {validation_error}")
+            return {}
+
+        credentials = sorted(query.credentials.model_dump().items())
+        cache_key = f"interfaces_{connection_key(query.target.host, query.target.port, credentials)}"
+        cached_labels = get_cache(cache_key)
+        if cached_labels is not None:
+            return cached_labels
+
+        result = await self.snmp_service.execute_query(labels_query, api_key=api_key)
+        if "error" in result:
+            logger.warning(f"Could not read interface names of {query.target.host}: {result['error']}")
+            return {}
+
+        labels: Dict[str, Dict[str, Optional[str]]] = {}
+        for key, value in result.items():
+            oid = key.lstrip(".") if is_numeric_oid(key) else self.snmp_service.mib_service.resolve_oid(key)
+            if not oid:
+                continue
+            for column, field in ((IF_NAME, "name"), (IF_ALIAS, "alias")):
+                if oid.startswith(column + "."):
+                    index = oid[len(column) + 1:]
+                    labels.setdefault(index, {"name": None, "alias": None})[field] = str(value)
+
+        set_cache(cache_key, labels, ttl=config.snmp.interface_cache_ttl)
+        return labels
+
+    async def label_results(self, query: SNMPQuery, results: List[SNMPResult],
+                            api_key: Optional[APIKeyPolicy] = None) -> None:
+        """
+        Attach the interface name and alias to results from interface tables
+
+        Args:
+            query: Query the results came from
+            results: Enriched results, labeled in place
+            api_key: Policy of the API key making the request, if any
+        """
+        indexes = {result.oid: interface_index(result.oid) for result in results if result.oid}
+        if not any(indexes.values()):
+            return
+
+        labels = await self.get_labels(query, api_key=api_key)
+        for result in results:
+            label = labels.get(indexes.get(result.oid) or "")
+            if label:
+                result.if_name = label["name"]
+                result.if_alias = label["alias"]
@@ -0,0 +1,138 @@
+import json
+import os
+import time
+from collections import OrderedDict
+from typing import Any, Callable, Dict, List, NamedTuple, Optional, Tuple
+from loguru import logger
+
+from app.core.config import config
+
+
+class CachedInterpretation(NamedTuple):
+    query: str
+    model: str
+    prompt_version: str
+    interpretation: Dict[str, Any]  # The model's JSON structure for the query
+    accessed: float  # time.time() of the last use
+
+
+def _has_credentials(interpretation: Dict[str, Any]) -> bool:
+    """Check whether an interpretation carries a community string or passphrases from the query"""
+    credentials = interpretation.get("credentials") or {}
+    community = credentials.get("community") or interpretation.get("community_string")
+    return bool(
+        (community and community != config.snmp.default_community)
+        or credentials.get("auth_password") or credentials.get("priv_password")
+    )
+
+
+class InterpretationCache:
+    """
+    Most recently used LLM interpretations, keyed by query, model and prompt version
+
+    Entries are kept in order of use, and the least recently used is dropped beyond
+    max_entries. With a path, the cache is saved to that file (most recent first) and
+    warmed up from it on the next start, so interpretations survive restarts.
+    """
+
+    def __init__(self, max_entries: int, path: Optional[str] = None):
+        self.max_entries = max(1, max_entries)
+        self.path = path or None
+        self._entries: "OrderedDict[Tuple[str, str, str], CachedInterpretation]" = OrderedDict()
+
+    def __len__(self) -> int:
+        return len(self._entries)
+
+    def get(self, query: str, model: str, prompt_version: str, now: Optional[float] = None) -> Optional[Dict[str, Any]]:
+        """Get the interpretation of a query by a model and prompt, marking it as just used"""
+        entry = self._entries.pop((model, prompt_version, query), None)
+        if entry is None:
+            return None
+        self._entries[(model, prompt_version, query)] = entry._replace(accessed=time.time() if now is None else now)
+        return entry.interpretation
+
+    def put(self, query: str, model: str, prompt_version: str, interpretation: Dict[str, Any],
+            now: Optional[float] = None) -> None:
+        """Store the interpretation of a query by a model and prompt"""
+        key = (model, prompt_version, query)
+        self._entries.pop(key, None)
+        self._entries[key] = CachedInterpretation(
+            query, model, prompt_version, interpretation, time.time() if now is None else now
+        )
+        while len(self._entries) > self.max_entries:
+            self._entries.popitem(last=False)
+
+    def most_recent(self, count: Optional[int] = None) -> List[CachedInterpretation]:
+        """The most recently used entries, most recent first"""
+        entries = list(reversed(self._entries.values()))
+        return entries if count is None else entries[:count]
+
+    def save(self) -> int:
+        """
+        Write the entries to the cache file, if there is one
+
+        Interpretations carrying credentials from their query are kept in memory only.
+
+        Returns:
+            Number of entries written, 0 if the file couldn't be written
+        """
+        if not self.path:
+            return 0
+        entries = [entry._asdict() for entry in self.most_recent() if not _has_credentials(entry.interpretation)]
+        temporary = f"{self.path}.tmp"
+        try:
+            with open(temporary, "w") as cache_file:
+                json.dump({"entries": entries}, cache_file)
+            os.replace(temporary, self.path)
+        except OSError as e:
+            logger.warning(f"Could not save the LLM interpretation cache to {self.path}: {e}")
+            return 0
+        logger.info(f"Saved {len(entries)} LLM interpretations to {self.path}")
+        return len(entries)
+
+    def load(self, model: str, prompt_version: str, warmup: int,
+             validate: Callable[[Dict[str, Any]], Any]) -> Tuple[int, int]:
+        """
+        Warm up the cache with the most recently used interpretations in the cache file
+
+        Entries by another model or prompt version, and entries the validator rejects
+        (e.g. because the query model changed), are purged. Only the warmup most
+        recently used of the rest are loaded.
+
+        Args:
+            model: Current LLM model
+            prompt_version: Version of the current system prompt
+            warmup: Number of entries to load at most
+            validate: Raises for an interpretation that is no longer valid
+
+        Returns:
+            Number of entries loaded and number purged
+        """
+        if not self.path or not os.path.exists(self.path):
+            return 0, 0
+        try:
+            with open(self.path) as cache_file:
+                stored = [CachedInterpretation(**entry) for entry in json.load(cache_file).get("entries", [])]
+        except (OSError, ValueError, TypeError) as e:
+            logger.warning(f"Ignoring unreadable LLM interpretation cache {self.path}: {e}")
+            return 0, 0
+
+        loaded, purged = [], 0
+        for entry in sorted(stored, key=lambda entry: entry.accessed, reverse=True):
+            if entry.model != model or entry.prompt_version != prompt_version:
+                purged += 1
+                continue
+            if len(loaded) >= min(warmup, self.max_entries):
+                continue
+            try:
+                validate(entry.interpretation)
+            except Exception as e:
+                logger.debug(f"Purging cached interpretation of '{entry.query}': {e}")
+                purged += 1
+                continue
+            loaded.append(entry)
+
+        for entry in reversed(loaded):
+            self._entries[(entry.model, entry.prompt_version, entry.query)] = entry
+        logger.info(f"Warmed up {len(loaded)} LLM interpretations from {self.path}, purged {purged}")
+        return len(loaded), purged
@@ -0,0 +1,191 @@
+import json
+from typing import Any, Callable, Dict, List, Optional
+from pydantic import ValidationError
+
+from app.models.query import SNMPTarget, SNMPCredentials, SNMPOperation
+from app.services.snmp_service import SUPPORTED_COMMANDS
+
+_WHITESPACE = " \t\r\n"
+
+
+class StreamRejected(ValueError):
+    """Raised when a streamed interpretation is malformed or invalid before it is complete"""
+
+
+def _skip_whitespace(text: str, position: int) -> int:
+    while position < len(text) and text[position] in _WHITESPACE:
+        position += 1
+    return position
+
+
+def _string_end(text: str, start: int) -> Optional[int]:
+    """End of the JSON string starting at start, or None if it isn't complete yet"""
+    position = start + 1
+    while position < len(text):
+        if text[position] == "\\":
+            position += 2
+            continue
+        if text[position] == '"':
+            return position + 1
+        position += 1
+    return None
+
+
+def _value_end(text: str, start: int) -> Optional[int]:
+    """End of the JSON value starting at start, or None if it isn't complete yet"""
+    if text[start] == '"':
+        return _string_end(text, start)
+
+    if text[start] in "{[":
+        depth, in_string, position = 0, False, start
+        while position < len(text):
+            char = text[position]
+            if in_string:
+                if char == "\\":
+                    position += 1
+                elif char == '"':
+                    in_string = False
+            elif char == '"':
+                in_string = True
+            elif char in "{[":
+                depth += 1
+            elif char in "}]":
+                depth -= 1
+                if depth == 0:
+                    return position + 1
+            position += 1
+        return None
+
+    # A number or literal is only complete once the delimiter after it arrives
+    position = start
+    while position < len(text) and text[position] not in ",}]" + _WHITESPACE:
+        position += 1
+    return position if position < len(text) else None
+
+
+def check_member(name: str, value: Any) -> None:
+    """
+    Check a top-level member of an interpretation as soon as it is complete
+
+    Raises:
+        StreamRejected: If the member can't be part of a valid query
+    """
+    try:
+        if name == "target" and isinstance(value, dict):
+            SNMPTarget.model_validate(value)
+        elif name == "credentials" and isinstance(value, dict):
+            SNMPCredentials.model_validate(value)
+        elif name == "operation":
+            command = value.get("command") if isinstance(value, dict) else value
+            if isinstance(value, dict):
+                SNMPOperation.model_validate(value)
+            if not isinstance(command, str) or command.upper() not in SUPPORTED_COMMANDS:
+                raise StreamRejected(f"Unsupported SNMP command: {command}")
+    except ValidationError as e:
+        raise StreamRejected(f"Invalid {name}: {e.errors()[0]['msg']}")
+
+
+class StreamingInterpretation:
+    """
+    Incremental parse of an interpretation streamed by the LLM
+
+    The JSON object is scanned as text arrives, and each top-level member is
+    checked (check_member) as soon as its value is complete, so a malformed or
+    invalid interpretation is rejected without waiting for the rest of it. The
+    interpretation itself is only available once the whole object has arrived.
+    """
+
+    def __init__(self, check: Callable[[str, Any], None] = check_member):
+        self.text = ""
+        self.members: Dict[str, Any] = {}
+        self.complete = False
+        self._check = check
+        self._position: Optional[int] = None  # Where scanning resumes, None before the opening brace
+        self._after_member = False  # Whether a "," or "}" is expected next
+
+    def feed(self, delta: str) -> List[str]:
+        """
+        Add streamed text
+
+        Returns:
+            Names of the top-level members the text completed
+
+        Raises:
+            StreamRejected: If the text so far can't be the start of a valid interpretation
+        """
+        self.text += delta
+        completed = []
+        while True:
+            name = self._scan_member()
+            if name is None:
+                return completed
+            self._check(name, self.members[name])
+            completed.append(name)
+
+    def _scan_member(self) -> Optional[str]:
+        """Scan up to the end of the next complete member and return its name, or None if there is none yet"""
+        text = self.text
+        if self._position is None:
+            start = _skip_whitespace(text, 0)
+            if start >= len(text):
+                return None
+            if text[start] != "{":
+                raise StreamRejected("The interpretation is not a JSON object")
+            self._position = start + 1
+
+        while not self.complete:
+            position = _skip_whitespace(text, self._position)
+            if position >= len(text):
+                return None
+
+            if self._after_member or (text[position] == "}" and not self.members):
+                if text[position] == "}":
+                    self.complete = True
+                    self._position = position + 1
+                elif text[position] == ",":
+                    self._after_member = False
+                    self._position = position + 1
+                else:
+                    raise StreamRejected(f"Unexpected {text[position]!r} after a member of the interpretation")
+                continue
+
+            if text[position] != '"':
+                raise StreamRejected(f"Unexpected {text[position]!r} where a member name belongs")
+            key_end = _string_end(text, position)
+            if key_end is None:
+                return None
+            colon = _skip_whitespace(text, key_end)
+            if colon >= len(text):
+                return None
+            if text[colon] != ":":
+                raise StreamRejected(f"Expected ':' after member {text[position:key_end]}")
+            value_start = _skip_whitespace(text, colon + 1)
+            if value_start >= len(text):
+                return None
+            value_end = _value_end(text, value_start)
+            if value_end is None:
+                return None
+
+            try:
+                name = json.loads(text[position:key_end])
+                self.members[name] = json.loads(text[value_start:value_end])
+            except ValueError as e:
+                raise StreamRejected(f"Malformed value of member {text[position:key_end]}: {e}")
+            self._position = value_end
+            self._after_member = True
+            return name
+
+        if text[self._position:].strip():
+            raise StreamRejected("Unexpected text after the interpretation")
+        return None
+
+    def result(self) -> Dict[str, Any]:
+        """
+        The complete interpretation
+
+        Raises:
+            StreamRejected: If the stream ended before the object was complete
+        """
+        if not self.complete:
+            raise StreamRejected("The interpretation stream ended before the JSON object was complete")
+        return dict(self.members)
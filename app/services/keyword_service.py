@@ -0,0 +1,106 @@
+import re
+from typing import Optional
+from loguru import logger
+
+from app.core.config import config
+from app.models.query import SNMPQuery, SNMPTarget, SNMPCredentials, SNMPOperation
+from app.services.mib_service import MIBService
+
+# Commands accepted by the rule-based interpreter and the SNMP command they map to
+COMMANDS = {
+    "get": "GET",
+    "getnext": "GETNEXT",
+    "get-next": "GETNEXT",
+    "walk": "WALK",
+    "bulk": "BULK",
+    "bulkget": "BULK",
+    "bulkwalk": "BULK",
+    "walktable": "WALKTABLE",
+}
+
+# A numeric OID (1.3.6.1.2.1.1.1.0), a name (sysDescr.0) or a MIB-qualified name (IF-MIB::ifDescr)
+OID_PATTERN = r"(?:\.?\d+(?:\.\d+)*|[A-Za-z][\w-]*(?:::[A-Za-z]\w*)?(?:\.\d+)*)"
+HOST_PATTERN = r"(?:\d{1,3}(?:\.\d{1,3}){3}|\[?[0-9A-Fa-f]*:[0-9A-Fa-f:]+\]?|[A-Za-z0-9][\w.-]*)"
+OID_LIST_PATTERN = rf"{OID_PATTERN}(?:\s*,\s*{OID_PATTERN})*"
+COMMAND_PATTERN = "|".join(sorted(COMMANDS, key=len, reverse=True))
+
+# "walk ifTable on 10.0.0.1", "get sysDescr.0, sysName.0 from router1 community private"
+COMMAND_FIRST = re.compile(
+    rf"^(?P<command>{COMMAND_PATTERN})\s+(?P<oids>{OID_LIST_PATTERN})\s+(?:from|on|at)\s+(?P<host>{HOST_PATTERN})(?P<options>.*)$",
+    re.IGNORECASE
+)
+
+# "walk 10.0.0.1 1.3.6.1.2.1.2", snmpwalk-style
+HOST_FIRST = re.compile(
+    rf"^(?P<command>{COMMAND_PATTERN})\s+(?P<host>{HOST_PATTERN})\s+(?P<oids>{OID_LIST_PATTERN})(?P<options>.*)$",
+    re.IGNORECASE
+)
+
+COMMUNITY_OPTION = re.compile(r"(?:\bcommunity(?:\s+string)?|(?<!\S)-c)\s+['\"]?([^\s'\"]+)['\"]?", re.IGNORECASE)
+VERSION_OPTION = re.compile(r"(?:\bversion\s+v?|(?<!\S)-v\s*|\bv)(1|2c|3)\b", re.IGNORECASE)
+PORT_OPTION = re.compile(r"\bport\s+(\d{1,5})\b", re.IGNORECASE)
+OPTION_WORDS = re.compile(r"\b(?:using|with|and|snmp)\b", re.IGNORECASE)
+
+
+class KeywordService:
+    """Rule-based interpreter for clearly structured queries"""
+
+    def __init__(self, mib_service: Optional[MIBService] = None):
+        self.mib_service = mib_service or MIBService()
+
+    def process_query(self, query: str) -> Optional[SNMPQuery]:
+        """
+        Parse a structured query such as "walk ifTable on 10.0.0.1" without an LLM call.
+
+        Args:
+            query: The query from the user
+
+        Returns:
+            SNMPQuery object, or None if the query doesn't match a known pattern, or names
+            an OID the MIB index doesn't resolve
+        """
+        text = " ".join(query.strip().rstrip("?.!").split())
+
+        match = COMMAND_FIRST.match(text) or HOST_FIRST.match(text)
+        if not match:
+            return None
+
+        options = match.group("options")
+        community = COMMUNITY_OPTION.search(options)
+        version = VERSION_OPTION.search(options)
+        port = PORT_OPTION.search(options)
+
+        # Anything left over that isn't a recognised option makes the query ambiguous
+        leftover = options
+        for option in (community, version, port):
+            if option:
+                leftover = leftover.replace(option.group(0), " ")
+        if OPTION_WORDS.sub(" ", leftover).strip(" ,"):
+            return None
+
+        host = match.group("host").strip("[]")
+        oids = [oid.strip() for oid in match.group("oids").split(",")]
+        # Any word fits the OID pattern; one the index doesn't know ("get uptime from core1")
+        # is more likely meant for the LLM than a name to look up
+        if not all(self.mib_service.resolve_oid(oid) for oid in oids):
+            return None
+
+        snmp_query = SNMPQuery(
+            target=SNMPTarget(
+                host=host,
+                port=int(port.group(1)) if port else config.snmp.default_port,
+                timeout=config.snmp.timeout,
+                retries=config.snmp.retries
+            ),
+            credentials=SNMPCredentials(
+                version=version.group(1).lower() if version else config.snmp.default_version,
+                community=community.group(1) if community else config.snmp.default_community
+            ),
+            operation=SNMPOperation(
+                command=COMMANDS[match.group("command").lower()],
+                oids=oids
+            )
+        )
+
+        logger.info(f"Interpreted query with keyword rules: {snmp_query.operation.command} {oids} on {host}")
+        return snmp_query
@@ -0,0 +1,93 @@
+import asyncio
+from typing import Awaitable, Callable, Dict, Generic, Hashable, List, Optional, Tuple, TypeVar
+from loguru import logger
+
+T = TypeVar("T")
+R = TypeVar("R")
+
+
+class MicroBatcher(Generic[T, R]):
+    """
+    Collect requests for a short window and complete them together
+
+    Requests submitted within max_wait seconds of the first one, up to max_size,
+    are passed to the complete function in one call, which returns one result per
+    request in the same order. Each result (or the call's exception) goes back to
+    the caller that submitted the request. Requests of different groups, e.g. of
+    different API keys, never share a batch.
+    """
+
+    def __init__(self, complete: Callable[[List[T]], Awaitable[List[R]]], max_size: int, max_wait: float):
+        self.complete = complete
+        self.max_size = max(1, max_size)
+        self.max_wait = max(0.0, max_wait)
+        self._pending: Dict[Hashable, List[Tuple[T, asyncio.Future]]] = {}
+        self._timers: Dict[Hashable, asyncio.TimerHandle] = {}
+        self._batches: List[asyncio.Task] = []
+
+    async def submit(self, item: T, group: Hashable = None) -> R:
+        """
+        Add a request to the current batch of its group and wait for its result
+
+        A caller cancelled while waiting (e.g. by its request timeout) is dropped
+        from a batch not yet sent, and its result is discarded otherwise; the call
+        itself is cancelled once no caller of its batch is waiting any more.
+
+        Args:
+            item: The request
+            group: Only requests of the same group are batched together
+
+        Raises:
+            Exception: Whatever the complete function raised for the batch
+        """
+        loop = asyncio.get_event_loop()
+        future = loop.create_future()
+        pending = self._pending.setdefault(group, [])
+        pending.append((item, future))
+
+        if len(pending) >= self.max_size:
+            self.flush(group)
+        elif group not in self._timers:
+            self._timers[group] = loop.call_later(self.max_wait, self.flush, group)
+
+        return await future
+
+    def flush(self, group: Hashable = None) -> None:
+        """Send a group's pending requests now, without waiting for the window to close"""
+        timer = self._timers.pop(group, None)
+        if timer is not None:
+            timer.cancel()
+
+        batch = [(item, future) for item, future in self._pending.pop(group, []) if not future.done()]
+        if not batch:
+            return
+
+        task = asyncio.ensure_future(self._run(batch))
+        self._batches.append(task)
+        task.add_done_callback(self._batches.remove)
+        for _, future in batch:
+            future.add_done_callback(lambda _, batch=batch, task=task: self._abandon_if_unwanted(batch, task))
+
+    @staticmethod
+    def _abandon_if_unwanted(batch: List[Tuple[T, asyncio.Future]], task: asyncio.Task) -> None:
+        """Cancel a batch's call once every caller waiting on it was cancelled"""
+        if not task.done() and all(future.cancelled() for _, future in batch):
+            task.cancel()
+
+    async def _run(self, batch: List[Tuple[T, asyncio.Future]]) -> None:
+        logger.debug(f"Completing a batch of {len(batch)} requests")
+        try:
+            results = await self.complete([item for item, _ in batch])
+            if len(results) != len(batch):
+                raise ValueError(f"Batch of {len(batch)} requests returned {len(results)} results")
+        except asyncio.CancelledError:
+            raise
+        except Exception as e:
+            for _, future in batch:
+                if not future.done():
+                    future.set_exception(e)
+            return
+
+        for (_, future), result in zip(batch, results):
+            if not future.done():
+                future.set_result(result)
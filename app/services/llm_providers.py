@@ -0,0 +1,220 @@
+import json
+from types import SimpleNamespace
+from typing import Any, Dict, Iterator, List, Optional, Tuple
+from openai import OpenAI, APIConnectionError, APIStatusError, InternalServerError, RateLimitError
+
+# Backends interpretation can use, selected with LLM_PROVIDER
+LLM_PROVIDERS = ("openai", "anthropic", "ollama")
+
+ANTHROPIC_API_VERSION = "2023-06-01"
+
+
+def _completion(content: str) -> SimpleNamespace:
+    """A response shaped like the OpenAI client's ChatCompletion, as far as it is read"""
+    return SimpleNamespace(choices=[SimpleNamespace(message=SimpleNamespace(content=content))])
+
+
+def _chunk(content: str) -> SimpleNamespace:
+    """A stream chunk shaped like the OpenAI client's ChatCompletionChunk"""
+    return SimpleNamespace(choices=[SimpleNamespace(delta=SimpleNamespace(content=content))])
+
+
+def strip_code_fence(text: str) -> str:
+    """The JSON inside a ```json fenced block, for models without a JSON output mode that add one anyway"""
+    stripped = text.strip()
+    if stripped.startswith("```"):
+        stripped = stripped.split("\n", 1)[1] if "\n" in stripped else ""
+        stripped = stripped.rsplit("```", 1)[0]
+    return stripped.strip()
+
+
+class HTTPChatClient:
+    """
+    Chat backend reached over HTTP, with the OpenAI client's chat.completions.create interface
+
+    Each backend builds its own request and authentication; the responses, stream
+    chunks and errors are those of the OpenAI client, so retries, batching and
+    streaming work the same whatever the provider. Like the OpenAI client, it is
+    synchronous.
+    """
+
+    def __init__(self, base_url: str, timeout: float, http_client=None):
+        self.base_url = base_url.rstrip("/")
+        self.timeout = timeout
+        self._http = http_client
+        self.chat = SimpleNamespace(completions=SimpleNamespace(create=self.create))
+
+    @property
+    def http(self):
+        if self._http is None:
+            import httpx
+
+            self._http = httpx.Client(timeout=self.timeout)
+        return self._http
+
+    def _request(self, messages: List[Dict[str, str]], model: str, temperature: float, max_tokens: int,
+                 json_output: bool, stream: bool) -> Tuple[str, Dict[str, str], Dict[str, Any]]:
+        """The URL, headers and body of a chat request"""
+        raise NotImplementedError
+
+    def _content(self, body: Dict[str, Any]) -> str:
+        """The answer's text in a response body"""
+        raise NotImplementedError
+
+    def _stream_content(self, line: str) -> Optional[str]:
+        """The text added by a line of a streamed response, if any"""
+        raise NotImplementedError
+
+    def _raise_for_status(self, response) -> None:
+        if response.status_code < 400:
+            return
+        response.read()
+        message = f"{type(self).__name__} request failed with HTTP {response.status_code}: {response.text[:500]}"
+        if response.status_code == 429:
+            raise RateLimitError(message, response=response, body=None)
+        if response.status_code >= 500:
+            raise InternalServerError(message, response=response, body=None)
+        raise APIStatusError(message, response=response, body=None)
+
+    def create(self, model: str, messages: List[Dict[str, str]], temperature: float = 0.1,
+               max_tokens: int = 1000, response_format: Optional[Dict[str, str]] = None,
+               stream: bool = False, **kwargs):
+        """
+        Send a chat request
+
+        Raises:
+            RateLimitError: If the backend answers 429
+            InternalServerError: If it answers with a server error
+            APIStatusError: If it rejects the request
+            APIConnectionError: If it can't be reached
+        """
+        import httpx
+
+        json_output = (response_format or {}).get("type") == "json_object"
+        url, headers, body = self._request(messages, model, temperature, max_tokens, json_output, stream)
+        if stream:
+            return self._stream(url, headers, body, json_output)
+        try:
+            response = self.http.post(url, headers=headers, json=body)
+        except httpx.TransportError as e:
+            raise APIConnectionError(message=f"Could not reach {url}: {e}", request=httpx.Request("POST", url))
+        self._raise_for_status(response)
+        content = self._content(response.json())
+        return _completion(strip_code_fence(content) if json_output else content)
+
+    def _stream(self, url: str, headers: Dict[str, str], body: Dict[str, Any], json_output: bool
+                ) -> Iterator[SimpleNamespace]:
+        """Start a streamed request, raising its errors before any chunk is read, as the OpenAI client does"""
+        import httpx
+
+        request = self.http.stream("POST", url, headers=headers, json=body)
+        try:
+            response = request.__enter__()
+        except httpx.TransportError as e:
+            raise APIConnectionError(message=f"Could not reach {url}: {e}", request=httpx.Request("POST", url))
+        try:
+            self._raise_for_status(response)
+        except Exception:
+            request.__exit__(None, None, None)
+            raise
+        return self._chunks(request, response, url, json_output)
+
+    def _chunks(self, request, response, url: str, json_output: bool) -> Iterator[SimpleNamespace]:
+        import httpx
+
+        started = not json_output
+        try:
+            for line in response.iter_lines():
+                content = self._stream_content(line) if line else None
+                if not content:
+                    continue
+                if not started:
+                    # Drop an opening code fence before the JSON object
+                    if "{" not in content:
+                        continue
+                    content = content[content.index("{"):]
+                    started = True
+                yield _chunk(content)
+        except httpx.TransportError as e:
+            raise APIConnectionError(message=f"Connection to {url} lost: {e}", request=httpx.Request("POST", url))
+        finally:
+            request.__exit__(None, None, None)
+
+
+class AnthropicChatClient(HTTPChatClient):
+    """Claude models through the Anthropic Messages API"""
+
+    def __init__(self, api_key: str, base_url: str = "https://api.anthropic.com", timeout: float = 60,
+                 http_client=None):
+        super().__init__(base_url, timeout, http_client)
+        self.api_key = api_key
+
+    def _request(self, messages, model, temperature, max_tokens, json_output, stream):
+        # The system prompt is a parameter of its own; the conversation holds only user and assistant turns
+        system = "\n\n".join(message["content"] for message in messages if message["role"] == "system")
+        if json_output:
+            system += "\n\nAnswer with the JSON object only."
+        body = {
+            "model": model,
+            "system": system.strip(),
+            "messages": [message for message in messages if message["role"] != "system"],
+            "temperature": temperature,
+            "max_tokens": max_tokens,
+            "stream": stream,
+        }
+        headers = {"x-api-key": self.api_key, "anthropic-version": ANTHROPIC_API_VERSION}
+        return f"{self.base_url}/v1/messages", headers, body
+
+    def _content(self, body):
+        return "".join(block.get("text", "") for block in body.get("content", []) if block.get("type") == "text")
+
+    def _stream_content(self, line):
+        # Server-sent events; the text arrives in content_block_delta events
+        if not line.startswith("data:"):
+            return None
+        event = json.loads(line[len("data:"):])
+        delta = event.get("delta") or {}
+        return delta.get("text") if event.get("type") == "content_block_delta" else None
+
+
+class OllamaChatClient(HTTPChatClient):
+    """Models served by a local Ollama server, through its chat API"""
+
+    def _request(self, messages, model, temperature, max_tokens, json_output, stream):
+        body = {
+            "model": model,
+            "messages": messages,
+            "stream": stream,
+            "options": {"temperature": temperature, "num_predict": max_tokens},
+        }
+        if json_output:
+            body["format"] = "json"
+        return f"{self.base_url}/api/chat", {}, body
+
+    def _content(self, body):
+        return (body.get("message") or {}).get("content", "")
+
+    def _stream_content(self, line):
+        # One JSON object per line
+        return (json.loads(line).get("message") or {}).get("content")
+
+
+def create_chat_client(settings) -> Any:
+    """
+    Create the chat client of the configured provider
+
+    Args:
+        settings: The LLM settings (config.openai)
+
+    Raises:
+        ValueError: If the provider is unknown, or its API key is missing
+    """
+    if settings.provider == "openai":
+        return OpenAI(api_key=settings.api_key)
+    if settings.provider == "anthropic":
+        if not settings.anthropic_api_key:
+            raise ValueError("LLM_PROVIDER=anthropic needs ANTHROPIC_API_KEY")
+        return AnthropicChatClient(settings.anthropic_api_key, settings.anthropic_url, timeout=settings.timeout)
+    if settings.provider == "ollama":
+        return OllamaChatClient(settings.ollama_url, timeout=settings.timeout)
+    raise ValueError(f"Unknown LLM provider {settings.provider}, use one of {', '.join(LLM_PROVIDERS)}")
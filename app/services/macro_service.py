@@ -0,0 +1,127 @@
+import re
+from typing import Dict, Any, List, Optional
+from loguru import logger
+
+from app.core.config import config, APIKeyPolicy
+from app.models.query import SNMPQuery, MacroStepResult, MacroResponse
+from app.services.snmp_service import SNMPService
+
+_PLACEHOLDER = re.compile(r"\{(\w+)\}")
+
+
+class MacroError(ValueError):
+    """Raised for an unknown macro, missing parameters or an invalid step"""
+
+
+def _substitute(value: Any, parameters: Dict[str, Any]) -> Any:
+    """Fill "{param}" placeholders in every string of a step definition"""
+    if isinstance(value, str):
+        return _PLACEHOLDER.sub(
+            lambda match: str(parameters[match.group(1)]) if match.group(1) in parameters else match.group(0),
+            value
+        )
+    if isinstance(value, list):
+        return [_substitute(item, parameters) for item in value]
+    if isinstance(value, dict):
+        return {key: _substitute(item, parameters) for key, item in value.items()}
+    return value
+
+
+class MacroService:
+    def __init__(self, snmp_service: Optional[SNMPService] = None):
+        self.snmp_service = snmp_service or SNMPService()
+
+    def list_macros(self) -> List[Dict[str, Any]]:
+        """Describe the configured macros: name, description, parameters and step labels"""
+        return [
+            {
+                "name": name,
+                "description": macro.get("description", ""),
+                "parameters": {
+                    parameter: {"required": default is None, "default": default}
+                    for parameter, default in macro.get("parameters", {}).items()
+                },
+                "steps": [step.get("label", f"step{index + 1}") for index, step in enumerate(macro.get("steps", []))],
+            }
+            for name, macro in config.macros.items()
+        ]
+
+    def build_steps(self, name: str, parameters: Dict[str, Any]) -> List[Dict[str, Any]]:
+        """
+        Build the queries for a macro's steps
+
+        Args:
+            name: Macro name
+            parameters: Parameter values; defaults from the definition fill in the rest
+
+        Returns:
+            List of {"label", "query"} for each step
+
+        Raises:
+            MacroError: If the macro is unknown, a required parameter is missing or a step is invalid
+        """
+        macro = config.macros.get(name)
+        if macro is None:
+            raise MacroError(f"Unknown macro: {name}")
+
+        declared = macro.get("parameters", {})
+        unknown = sorted(set(parameters) - set(declared))
+        if unknown:
+            raise MacroError(f"Unknown parameters for macro {name}: {', '.join(unknown)}")
+
+        values = {**{key: default for key, default in declared.items() if default is not None}, **parameters}
+        missing = sorted(key for key in declared if values.get(key) is None)
+        if missing:
+            raise MacroError(f"Missing parameters for macro {name}: {', '.join(missing)}")
+
+        steps = []
+        for index, step in enumerate(macro.get("steps", [])):
+            step = _substitute(step, values)
+            label = step.pop("label", f"step{index + 1}")
+            try:
+                query = SNMPQuery.model_validate(step)
+            except Exception as e:
+                raise MacroError(f"Invalid step {label} in macro {name}: {e}")
+            query.raw_query = f"macro {name}: {label}"
+            steps.append({"label": label, "query": query})
+
+        return steps
+
+    async def run(self, name: str, parameters: Dict[str, Any],
+                  api_key: Optional[APIKeyPolicy] = None) -> MacroResponse:
+        """
+        Run a macro's steps in order
+
+        A failing step is reported in its result and doesn't stop the others.
+
+        Args:
+            name: Macro name
+            parameters: Parameter values
+            api_key: Policy of the API key making the request, if any
+
+        Returns:
+            Labeled result of each step
+
+        Raises:
+            MacroError: If the macro can't be built
+        """
+        steps = self.build_steps(name, parameters)
+        logger.info(f"Running macro {name} with {len(steps)} steps")
+
+        response = MacroResponse(macro=name)
+        for step in steps:
+            query = step["query"]
+            raw_data = await self.snmp_service.execute_query(query, api_key=api_key)
+
+            if "error" in raw_data:
+                logger.warning(f"Macro {name} step {step['label']} failed: {raw_data['error']}")
+                response.steps.append(MacroStepResult(
+                    label=step["label"], plan=query.plan(), raw_data=raw_data, error=raw_data["error"]
+                ))
+            else:
+                response.steps.append(MacroStepResult(
+                    label=step["label"], plan=query.plan(), raw_data=raw_data,
+                    results=self.snmp_service.enrich_results(raw_data)
+                ))
+
+        return response
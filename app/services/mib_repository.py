@@ -0,0 +1,252 @@
+import hashlib
+import json
+import os
+import re
+from datetime import datetime, timezone
+from typing import Any, Dict, List, Optional
+from loguru import logger
+
+from app.core.config import config
+from app.services.mib_service import MIBConflictError
+from app.services.operation_service import Operation, OperationCancelled
+from app.utils.cache import clear_cache
+
+# Saved in the MIB directory; dot files aren't read as MIB files
+STATE_FILE = ".repository.json"
+
+_MODULE_NAME = re.compile(r"^[A-Za-z][A-Za-z0-9-]*$")
+
+
+class MIBSourceError(Exception):
+    """Raised when a MIB module can't be fetched from its source"""
+
+
+def invalid_modules(modules: List[str]) -> List[str]:
+    """Names that aren't valid MIB module names, and so can't be used as file names either"""
+    return [module for module in modules if not _MODULE_NAME.match(module)]
+
+
+def checksum(content: bytes) -> str:
+    return hashlib.sha256(content).hexdigest()
+
+
+class MIBSource:
+    """Where MIB modules are downloaded from"""
+
+    async def fetch(self, module: str) -> bytes:
+        """
+        Download a module's MIB file
+
+        Raises:
+            MIBSourceError: If the module can't be downloaded
+        """
+        raise NotImplementedError
+
+    async def checksum(self, module: str) -> Optional[str]:
+        """SHA-256 of the module's current file at the source, or None if the source doesn't publish one"""
+        return None
+
+
+class HTTPMIBSource(MIBSource):
+    """
+    MIB modules served over HTTP(S), e.g. MIB_REPOSITORY_URL=https://mibs.example.com/asn1/{module}
+
+    With a checksum URL (e.g. .../{module}.sha256) an unchanged module that was
+    already downloaded is skipped without downloading it again.
+    """
+
+    def __init__(self, url: str, checksum_url: str = "", timeout: float = 30.0):
+        self.url = url
+        self.checksum_url = checksum_url
+        self.timeout = timeout
+
+    async def _get(self, url: str) -> bytes:
+        import httpx
+
+        try:
+            async with httpx.AsyncClient(timeout=self.timeout, follow_redirects=True) as client:
+                response = await client.get(url)
+        except httpx.HTTPError as e:
+            raise MIBSourceError(f"Could not download {url}: {e}")
+        if response.status_code != 200:
+            raise MIBSourceError(f"Could not download {url}: HTTP {response.status_code}")
+        return response.content
+
+    async def fetch(self, module: str) -> bytes:
+        return await self._get(self.url.format(module=module))
+
+    async def checksum(self, module: str) -> Optional[str]:
+        if not self.checksum_url:
+            return None
+        content = await self._get(self.checksum_url.format(module=module))
+        # sha256sum format: the digest, optionally followed by the file name
+        return content.decode("ascii", errors="replace").split()[0].lower() if content.strip() else None
+
+
+class DownloadProgress:
+    """Progress of a repository update, updated as modules complete"""
+
+    def __init__(self, modules: List[str]):
+        self.modules = modules
+        self.status = "running"  # running, completed, cancelled or failed
+        self.current: Optional[str] = None
+        self.downloaded: List[str] = []
+        self.skipped: List[str] = []  # Already downloaded and unchanged
+        self.failed: Dict[str, str] = {}  # Module -> error
+        self.started_at = datetime.now(timezone.utc)
+        self.finished_at: Optional[datetime] = None
+
+    @property
+    def done(self) -> int:
+        return len(self.downloaded) + len(self.skipped) + len(self.failed)
+
+    def finish(self, status: str) -> None:
+        self.status = status
+        self.current = None
+        self.finished_at = datetime.now(timezone.utc)
+
+    def describe(self) -> Dict[str, Any]:
+        return {
+            "status": self.status,
+            "total": len(self.modules),
+            "done": self.done,
+            "current": self.current,
+            "downloaded": self.downloaded,
+            "skipped": self.skipped,
+            "failed": self.failed,
+            "started_at": self.started_at.isoformat(),
+            "finished_at": self.finished_at.isoformat() if self.finished_at else None,
+        }
+
+
+class MIBRepository:
+    """
+    Bulk download of MIB modules from a source into the MIB directory
+
+    Each module is written to <module>.mib once it is completely downloaded, and
+    recorded with its checksum in the state file, so an interrupted or cancelled
+    update resumes where it stopped: modules whose file still matches the recorded
+    checksum are skipped, unless the source publishes a different checksum.
+    """
+
+    def __init__(self, mib_service, source: MIBSource):
+        self.mib_service = mib_service
+        self.source = source
+        self.state_path = os.path.join(mib_service.mib_dir, STATE_FILE)
+
+    def load_state(self) -> Dict[str, Dict[str, str]]:
+        """Modules downloaded so far -> their checksum and download time"""
+        if not os.path.exists(self.state_path):
+            return {}
+        try:
+            with open(self.state_path) as state_file:
+                return json.load(state_file).get("modules", {})
+        except (OSError, ValueError, AttributeError) as e:
+            logger.warning(f"Ignoring unreadable MIB repository state {self.state_path}: {e}")
+            return {}
+
+    def _save_state(self, state: Dict[str, Dict[str, str]]) -> None:
+        temporary = f"{self.state_path}.tmp"
+        with open(temporary, "w") as state_file:
+            json.dump({"modules": state}, state_file, indent=2, sort_keys=True)
+        os.replace(temporary, self.state_path)
+
+    def _module_path(self, module: str) -> str:
+        return os.path.join(self.mib_service.mib_dir, f"{module}.mib")
+
+    def _local_checksum(self, module: str) -> Optional[str]:
+        try:
+            with open(self._module_path(module), "rb") as mib_file:
+                return checksum(mib_file.read())
+        except OSError:
+            return None
+
+    async def update(self, modules: List[str], progress: Optional[DownloadProgress] = None,
+                     operation: Optional[Operation] = None) -> DownloadProgress:
+        """
+        Download the modules that are missing or changed
+
+        A module that fails to download is reported in the progress and the update
+        moves on to the next one; running the update again retries it.
+
+        Args:
+            modules: Names of the MIB modules to download
+            progress: Progress to update as modules complete (a new one if not given)
+            operation: Operation to run the downloads in, so that cancelling it stops the update
+
+        Returns:
+            The progress, finished as completed, cancelled or failed
+
+        Raises:
+            ValueError: If a module name isn't a valid MIB module name
+        """
+        invalid = invalid_modules(modules)
+        if invalid:
+            raise ValueError(f"Invalid MIB module names: {', '.join(invalid)}")
+
+        progress = progress or DownloadProgress(modules)
+        state = self.load_state()
+
+        async def step(awaitable):
+            return await operation.run(awaitable) if operation else await awaitable
+
+        try:
+            for module in modules:
+                progress.current = module
+                try:
+                    recorded = state.get(module, {}).get("sha256")
+                    remote = await step(self.source.checksum(module))
+                    if recorded and self._local_checksum(module) == recorded and remote in (None, recorded):
+                        progress.skipped.append(module)
+                        continue
+
+                    content = await step(self.source.fetch(module))
+                    digest = checksum(content)
+                    if remote and digest != remote:
+                        raise MIBSourceError(f"Checksum mismatch: expected {remote}, got {digest}")
+                except MIBSourceError as e:
+                    logger.warning(f"Could not download MIB module {module}: {e}")
+                    progress.failed[module] = str(e)
+                    continue
+
+                # Loaded first, so that a file the index refuses isn't kept
+                try:
+                    loaded = self.mib_service.load_mib(content.decode("utf-8", errors="replace"))
+                    if not loaded:
+                        raise MIBSourceError("No MIB module definition found")
+                except (MIBSourceError, MIBConflictError) as e:
+                    logger.warning(f"Could not load MIB module {module}: {e}")
+                    progress.failed[module] = str(e)
+                    continue
+
+                # Write the whole file at once so an interruption never leaves half a module behind
+                temporary = f"{self._module_path(module)}.tmp"
+                with open(temporary, "wb") as mib_file:
+                    mib_file.write(content)
+                os.replace(temporary, self._module_path(module))
+                state[module] = {"sha256": digest, "downloaded_at": datetime.now(timezone.utc).isoformat()}
+                self._save_state(state)
+                progress.downloaded.append(module)
+        except OperationCancelled:
+            logger.info(f"MIB repository update cancelled after {progress.done} of {len(modules)} modules")
+            progress.finish("cancelled")
+            return progress
+        except Exception:
+            progress.finish("failed")
+            raise
+        finally:
+            if progress.downloaded:
+                clear_cache(key_prefix="mib_")
+
+        progress.finish("failed" if progress.failed else "completed")
+        logger.info(f"MIB repository update {progress.status}: {len(progress.downloaded)} downloaded, "
+                    f"{len(progress.skipped)} unchanged, {len(progress.failed)} failed")
+        return progress
+
+
+def default_source() -> Optional[MIBSource]:
+    """The source configured with MIB_REPOSITORY_URL, or None if there is none"""
+    if not config.mib_repository_url:
+        return None
+    return HTTPMIBSource(config.mib_repository_url, config.mib_repository_checksum_url,
+                         timeout=config.mib_download_timeout)
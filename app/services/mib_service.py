@@ -1,20 +1,452 @@
 import os
+import functools
 import glob
+import ipaddress
 import re
-from typing import Dict, List, Optional, Set
+import threading
+from concurrent.futures import ProcessPoolExecutor
+from concurrent.futures.process import BrokenProcessPool
+from typing import Any, Dict, List, NamedTuple, Optional, Set, Tuple, Union
 from loguru import logger
 
 from app.core.config import config
-from app.utils.cache import get_cache, set_cache
+from app.models.query import SET_VALUE_TYPES
+from app.utils.cache import get_cache, set_cache, clear_cache
+from app.utils.mib_parser import SMI_MODULES, MIBModule, MIBObject, parse_mib
+
+
+def is_numeric_oid(oid: str) -> bool:
+    """Check whether an OID is numeric (e.g. 1.3.6.1.2.1.1.1.0) rather than symbolic"""
+    return bool(oid) and oid.lstrip(".").replace(".", "").isdigit()
+
+
+# SNMP allows at most 128 sub-identifiers in an OID (RFC 2578); SNMP_MAX_OID_ARCS may lower it
+MAX_OID_ARCS = 128
+
+
+def oid_length_error(oid: str) -> Optional[str]:
+    """
+    Check that an OID has no more sub-identifiers than allowed (SNMP_MAX_OID_ARCS)
+
+    Returns:
+        What is wrong with the OID, or None if it is short enough
+    """
+    limit = min(config.snmp.max_oid_arcs, MAX_OID_ARCS)
+    arcs = oid.strip(".").count(".") + 1
+    if arcs > limit:
+        return f"it has {arcs} sub-identifiers, more than the maximum of {limit}"
+    return None
+
+
+def oid_syntax_error(oid: str) -> Optional[str]:
+    """
+    Check that an OID is well-formed (BER-encodable, per X.690) and not too long
+
+    Returns:
+        What is wrong with the OID, or None if it is well-formed
+    """
+    # Checked first, so an absurdly long OID isn't parsed any further
+    length_error = oid_length_error(oid)
+    if length_error:
+        return length_error
+    parts = oid.strip(".").split(".")
+    if not all(part.isdigit() and part.isascii() for part in parts):
+        if any(char.isalpha() for char in oid):
+            return "it is not a numeric OID and does not resolve to one"
+        return "it is not a dotted list of numbers"
+    if len(parts) < 2:
+        return "it needs at least two sub-identifiers"
+
+    numbers = [int(part) for part in parts]
+    if numbers[0] > 2:
+        return "the first sub-identifier must be 0, 1 or 2"
+    if numbers[0] < 2 and numbers[1] > 39:
+        return "the second sub-identifier must be below 40"
+    if any(number > 0xFFFFFFFF for number in numbers):
+        return "a sub-identifier exceeds 2^32-1"
+    return None
+
+
+# Ways of writing a result's OID name: the numeric OID, the object name (ifInOctets.3), the
+# module-qualified name (IF-MIB::ifInOctets.3) or the full path of node names from the root
+OID_STYLES = ("numeric", "short", "module", "full")
+DEFAULT_OID_STYLE = "short"
+
+# Names of the registration tree nodes above the objects in the MIB index, for full paths
+OID_TREE_NODES = {
+    "1": "iso",
+    "1.3": "org",
+    "1.3.6": "dod",
+    "1.3.6.1": "internet",
+    "1.3.6.1.1": "directory",
+    "1.3.6.1.2": "mgmt",
+    "1.3.6.1.2.1": "mib-2",
+    "1.3.6.1.2.1.1": "system",
+    "1.3.6.1.2.1.2": "interfaces",
+    "1.3.6.1.2.1.2.2": "ifTable",
+    "1.3.6.1.2.1.2.2.1": "ifEntry",
+    "1.3.6.1.2.1.4": "ip",
+    "1.3.6.1.2.1.11": "snmp",
+    "1.3.6.1.2.1.25": "host",
+    "1.3.6.1.2.1.31": "ifMIB",
+    "1.3.6.1.2.1.31.1": "ifMIBObjects",
+    "1.3.6.1.2.1.31.1.1": "ifXTable",
+    "1.3.6.1.2.1.31.1.1.1": "ifXEntry",
+    "1.3.6.1.3": "experimental",
+    "1.3.6.1.4": "private",
+    "1.3.6.1.4.1": "enterprises",
+    "1.3.6.1.5": "security",
+    "1.3.6.1.6": "snmpV2",
+    "1.3.6.1.6.3": "snmpModules",
+}
+_TREE_NODE_OIDS = {name: oid for oid, name in OID_TREE_NODES.items()}
+
+# A sub-identifier written with its name, e.g. org(3)
+_NAMED_NUMBER = re.compile(r"[A-Za-z][\w-]*\((\d+)\)")
+
+
+def normalize_oid(oid: str) -> str:
+    """
+    Write an OID from a user or tool the canonical way: without a leading dot, and with
+    an iso prefix expanded (.1.3.6.1.2.1 and iso.3.6.1.2.1 both become 1.3.6.1.2.1)
+
+    Registration tree names after iso are expanded too (iso.org.dod.internet.2.1 and
+    iso(1).org(3).dod(6).internet(1).2.1 become 1.3.6.1.2.1), as long as the whole OID
+    becomes numeric. Anything else, like ifDescr.1 or IF-MIB::ifDescr, is only trimmed.
+    """
+    oid = oid.strip().lstrip(".")
+    labels = oid.split(".")
+    if labels[0].lower() not in ("iso", "iso(1)"):
+        return oid
+
+    parts = ["1"]
+    for label in labels[1:]:
+        named = _NAMED_NUMBER.fullmatch(label)
+        if named:
+            label = named.group(1)
+        elif not label.isdigit():
+            node = _TREE_NODE_OIDS.get(label, "")
+            parent, _, number = node.rpartition(".")
+            if parent != ".".join(parts):
+                return oid
+            label = number
+        parts.append(label)
+    return ".".join(parts)
+
+
+# Value range of each integer type, per SMIv2
+INTEGER_TYPE_RANGES = {
+    "Integer": (-2 ** 31, 2 ** 31 - 1),
+    "Counter32": (0, 2 ** 32 - 1),
+    "Gauge32": (0, 2 ** 32 - 1),
+    "TimeTicks": (0, 2 ** 32 - 1),
+    "Unsigned32": (0, 2 ** 32 - 1),
+}
+
+
+class ObjectSyntax(NamedTuple):
+    """SYNTAX and MAX-ACCESS of a MIB object, as far as needed to check values written to it"""
+    type: Optional[str]  # One of SET_VALUE_TYPES, None for a textual convention that isn't known here
+    enums: Optional[Dict[str, int]] = None  # Enumerated labels and their values
+    range: Optional[Tuple[int, int]] = None  # Value range, or SIZE range of an OctetString
+    writable: bool = False
+
+
+# SET value type of the base types and common textual conventions a parsed SYNTAX starts with
+_SYNTAX_TYPES = {
+    "INTEGER": "Integer", "Integer32": "Integer", "Unsigned32": "Unsigned32", "Gauge32": "Gauge32",
+    "Gauge": "Gauge32", "Counter32": "Counter32", "Counter": "Counter32", "TimeTicks": "TimeTicks",
+    "TimeStamp": "TimeTicks", "TimeInterval": "Integer", "IpAddress": "IpAddress", "OCTET STRING": "OctetString",
+    "DisplayString": "OctetString", "SnmpAdminString": "OctetString", "PhysAddress": "OctetString",
+    "MacAddress": "OctetString", "OBJECT IDENTIFIER": "ObjectIdentifier", "AutonomousType": "ObjectIdentifier",
+    "InterfaceIndex": "Integer", "InterfaceIndexOrZero": "Integer", "TruthValue": "Integer",
+    "RowStatus": "Integer", "StorageType": "Integer", "TestAndIncr": "Integer",
+}
+# Enumerations and ranges the textual conventions above imply, unless the SYNTAX narrows them
+_SYNTAX_ENUMS = {
+    "TruthValue": {"true": 1, "false": 2},
+    "RowStatus": {"active": 1, "notInService": 2, "notReady": 3, "createAndGo": 4, "createAndWait": 5, "destroy": 6},
+    "StorageType": {"other": 1, "volatile": 2, "nonVolatile": 3, "permanent": 4, "readOnly": 5},
+}
+_SYNTAX_RANGES = {
+    "DisplayString": (0, 255), "SnmpAdminString": (0, 255),
+    "InterfaceIndex": (1, 2 ** 31 - 1), "InterfaceIndexOrZero": (0, 2 ** 31 - 1), "TestAndIncr": (0, 2 ** 31 - 1),
+}
+# Objects of loaded MIBs with this MAX-ACCESS can be written
+WRITABLE_ACCESS = ("read-write", "read-create")
+
+
+def _object_syntax(details: MIBObject) -> ObjectSyntax:
+    """
+    The ObjectSyntax of a parsed OBJECT-TYPE, from its SYNTAX and MAX-ACCESS clauses
+
+    Enumerations ({ up(1), down(2) }) and the bounds of range and SIZE constraints are
+    read; a constraint with several ranges is taken as the one from its lowest to its
+    highest bound.
+    """
+    syntax = details.syntax or ""
+    base = next((name for name in _SYNTAX_TYPES if re.match(rf"{name}\b", syntax)), None)
+    constraint = syntax[len(base):] if base else ""
+    enums = dict(
+        (label, int(number)) for label, number in re.findall(r"([A-Za-z][\w-]*)\s*\(\s*(-?\d+)\s*\)", constraint)
+    ) if constraint.lstrip().startswith("{") else None
+    bounds = [int(number) for number in re.findall(r"-?\d+", constraint)] if constraint.lstrip().startswith("(") else []
+    return ObjectSyntax(
+        _SYNTAX_TYPES.get(base) if base else None,
+        enums or _SYNTAX_ENUMS.get(base),
+        (min(bounds), max(bounds)) if bounds else _SYNTAX_RANGES.get(base),
+        details.access in WRITABLE_ACCESS,
+    )
+
+
+def _enum_labels(enums: Dict[str, int]) -> str:
+    return ", ".join(f"{label}({number})" for label, number in enums.items())
+
+
+def short_name(name: str) -> str:
+    """Drop the module from a symbolic name, e.g. IF-MIB::ifInOctets.3 -> ifInOctets.3"""
+    return name.split("::", 1)[-1]
+
+
+def _index_object(name: str, oid: str) -> Tuple[str, str]:
+    """
+    Get the object OID and name of a MIB index entry
+
+    Scalars are indexed with their instance (SNMPv2-MIB::sysName.0); this gives the
+    object itself (1.3.6.1.2.1.1.5 and sysName).
+    """
+    object_name, _, instance = short_name(name).partition(".")
+    return (oid.rsplit(".", instance.count(".") + 1)[0] if instance else oid), object_name
+
+
+def _longest_match(oid: str, oid_names: Dict[str, str]) -> Optional[Tuple[str, str]]:
+    """The longest OID of the index that is the OID itself or above it, with its name"""
+    parts = oid.split(".")
+    for length in range(len(parts), 0, -1):
+        prefix = ".".join(parts[:length])
+        if prefix in oid_names:
+            return prefix, oid_names[prefix]
+    return None
+
+
+class TableIndex(NamedTuple):
+    """The INDEX clause of a table's rows, as needed to split an instance into its index values"""
+    objects: List[Tuple[str, Optional[str]]]  # Index object names and their SYNTAX, if known, in order
+    implied: bool  # Whether the last index object is IMPLIED
+
+
+class TableStructure(NamedTuple):
+    """What a table is made of, as needed to put the cells of a walk back into rows"""
+    entry: str  # OID of the table's row (entry) object
+    columns: Dict[str, str]  # Column OID -> column name, in column order
+    index: Optional[TableIndex]  # INDEX of the rows; None if no loaded MIB defines it
+
+
+# SYNTAX of index objects encoded with their length (unless of fixed size or IMPLIED), per RFC 2578 7.7;
+# other index objects are integers, encoded as one sub-identifier, or IpAddress, as four
+_STRING_INDEX_TYPES = (
+    "OCTET STRING", "DisplayString", "SnmpAdminString", "PhysAddress", "MacAddress", "InetAddress",
+    "OwnerString", "TAddress",
+)
+_OID_INDEX_TYPES = ("OBJECT IDENTIFIER", "AutonomousType", "RowPointer", "VariablePointer")
+_FIXED_SIZE = re.compile(r"SIZE\s*\(\s*(\d+)\s*\)")
+
+
+def _index_values(index: TableIndex, numbers: List[int]) -> Optional[Dict[str, Any]]:
+    """
+    Split the instance of a table column into the values of the row's index objects
+
+    Strings are given as text when printable, otherwise as colon-separated hex bytes.
+
+    Returns:
+        Index object name -> value, in INDEX order; None if the instance doesn't fit
+        the INDEX, or the SYNTAX of an index object is unknown
+    """
+    values: Dict[str, Any] = {}
+    position = 0
+    for number, (name, syntax) in enumerate(index.objects):
+        if syntax is None:
+            return None
+        if syntax.startswith("IpAddress"):
+            kind, length = "ip", 4
+        elif syntax.startswith(_STRING_INDEX_TYPES + _OID_INDEX_TYPES):
+            kind = "string" if syntax.startswith(_STRING_INDEX_TYPES) else "oid"
+            fixed = _FIXED_SIZE.search(syntax)
+            if fixed:
+                length = int(fixed.group(1))
+            elif index.implied and number == len(index.objects) - 1:
+                length = len(numbers) - position
+            elif position < len(numbers):
+                length = numbers[position]
+                position += 1
+            else:
+                return None
+        else:
+            kind, length = "integer", 1
+
+        value = numbers[position:position + length]
+        if len(value) < length:
+            return None
+        position += length
+        if kind == "integer":
+            values[name] = value[0]
+        elif kind == "string":
+            if any(part > 255 for part in value):
+                return None
+            printable = all(32 <= part < 127 for part in value)
+            values[name] = bytes(value).decode("ascii") if printable else ":".join(f"{part:02x}" for part in value)
+        else:
+            values[name] = ".".join(str(part) for part in value)
+    return values if position == len(numbers) else None
+
+
+# Object name prefixes of common MIB modules, to tell which MIB to load for a name no loaded MIB defines;
+# a prefix matches when the name goes on with a capital letter, e.g. ifHCInOctets
+MIB_NAME_PREFIXES = {
+    "sys": "SNMPv2-MIB",
+    "snmp": "SNMPv2-MIB",
+    "if": "IF-MIB",
+    "ip": "IP-MIB",
+    "icmp": "IP-MIB",
+    "ipCidrRoute": "IP-FORWARD-MIB",
+    "inetCidrRoute": "IP-FORWARD-MIB",
+    "tcp": "TCP-MIB",
+    "udp": "UDP-MIB",
+    "hr": "HOST-RESOURCES-MIB",
+    "ent": "ENTITY-MIB",
+    "entPhySensor": "ENTITY-SENSOR-MIB",
+    "dot1d": "BRIDGE-MIB",
+    "dot1q": "Q-BRIDGE-MIB",
+    "dot3": "EtherLike-MIB",
+    "lldp": "LLDP-MIB",
+    "bgp": "BGP4-MIB",
+    "ospf": "OSPF-MIB",
+    "ping": "DISMAN-PING-MIB",
+    "traceRoute": "DISMAN-TRACEROUTE-MIB",
+    "etherStats": "RMON-MIB",
+    "usm": "SNMP-USER-BASED-SM-MIB",
+    "vacm": "SNMP-VIEW-BASED-ACM-MIB",
+    "laLoad": "UCD-SNMP-MIB",
+    "mem": "UCD-SNMP-MIB",
+    "ssCpu": "UCD-SNMP-MIB",
+    "dsk": "UCD-SNMP-MIB",
+    "nsExtend": "NET-SNMP-EXTEND-MIB",
+}
+
+# Error code of a query naming an object no loaded MIB defines
+UNKNOWN_OID_NAME = "UNKNOWN_OID_NAME"
+
+
+class UnresolvedNameError(LookupError):
+    """Raised when an OID name is defined by no loaded MIB, with the MIB that probably defines it, if known"""
+
+    def __init__(self, name: str, mib: Optional[str] = None):
+        message = f"{name} is not defined by any loaded MIB"
+        if mib:
+            message += f"; load {mib} to resolve it"
+        super().__init__(message)
+        self.name = name
+        self.mib = mib
+
+
+# How the index settles an OID defined by more than one module: the first or last loaded
+# definition names it, or loading a conflicting definition fails
+DUPLICATE_POLICIES = ("first-wins", "last-wins", "error")
+
+
+class MIBConflictError(ValueError):
+    """Raised when modules define the same OID under the "error" duplicate policy"""
+
+    def __init__(self, conflicts: Dict[str, List[str]]):
+        super().__init__("OIDs defined by more than one module: " + "; ".join(
+            f"{oid} ({', '.join(names)})" for oid, names in conflicts.items()
+        ))
+        self.conflicts = conflicts
+
+
+class ReverseIndex(NamedTuple):
+    """
+    The name -> OID definitions of the MIB index, and the OID -> name side derived from them
+
+    A rebuild makes a new one and swaps it in with a single assignment, so readers
+    always see either the old or the new index in full, definitions included, and
+    never wait for a rebuild.
+    """
+    definitions: Dict[str, str]  # Qualified name (with the instance, for scalars) -> OID
+    oid_name: Dict[str, str]  # OID -> qualified name
+    oid_mib: Dict[str, str]  # OID -> name of the MIB module that defines it
+    object_names: Dict[str, str]  # Object OID (without instance) -> object name
+    short_names: Dict[str, str]  # Name without module (as indexed, e.g. sysName.0) -> first qualified name defining it
+    conflicts: Dict[str, List[str]]  # OID defined by more than one module -> the names defining it, in load order
+
+
+def _dependency_order(modules: List[MIBModule]) -> List[MIBModule]:
+    """Order modules so that each comes after the modules it imports from, where those are among them"""
+    by_name = {module.name: module for module in modules}
+    ordered: List[MIBModule] = []
+    visited: Set[str] = set()
+
+    def visit(module: MIBModule):
+        # Marked before its imports, so import cycles end here
+        if module.name in visited:
+            return
+        visited.add(module.name)
+        for source in module.imports:
+            if source in by_name:
+                visit(by_name[source])
+        ordered.append(module)
+
+    for module in modules:
+        visit(module)
+    return ordered
+
+
+# Less MIB source than this is parsed quicker in-process than by starting a pool of processes
+_PARALLEL_PARSE_MIN_BYTES = 256 * 1024
+
+
+def _parse_mib_texts(texts: List[str]) -> List[List[MIBModule]]:
+    """
+    Parse MIB sources, by a pool of processes when there is enough to parse
+
+    Parsing is CPU-bound, so threads wouldn't run it in parallel; up to MIB_PARSE_WORKERS
+    processes parse, at most one per CPU. Only parsing is spread out: the index is built
+    from the parsed modules afterwards, in this process.
+
+    Returns:
+        The modules of each source, in the order of the sources
+    """
+    parse = functools.partial(parse_mib, references=config.mib_cross_references)
+    cpus = os.cpu_count() or 1
+    workers = min(config.mib_parse_workers or cpus, cpus, len(texts))
+    if workers > 1 and sum(len(text) for text in texts) >= _PARALLEL_PARSE_MIN_BYTES:
+        try:
+            with ProcessPoolExecutor(max_workers=workers) as pool:
+                return list(pool.map(parse, texts, chunksize=max(1, len(texts) // (workers * 4))))
+        except (OSError, BrokenProcessPool) as e:
+            logger.warning(f"Could not parse MIB files in parallel, parsing them one by one: {e}")
+    return [parse(text) for text in texts]
 
 
 class MIBService:
     def __init__(self):
         """Initialize the MIB service with simplified functionality"""
         self.mib_dir = config.mib_directory
-        self.oid_name_cache: Dict[str, str] = {}  # Cache for OID to name translation
-        self.name_oid_cache: Dict[str, str] = {}  # Cache for name to OID translation
+        self.reverse_index = ReverseIndex({}, {}, {}, {}, {}, {})  # Replaced as a whole, see ReverseIndex
+        self._rebuild_lock = threading.Lock()  # One rebuild at a time; readers don't take it
         self.loaded_mibs: Set[str] = set()  # Names of loaded MIBs
+        self.aliases: Dict[str, str] = {}  # Operator-defined shorthand names
+        self.inet_address_columns: Dict[str, str] = {}  # InetAddress column -> sibling InetAddressType column
+        self.date_and_time_objects: Set[str] = set()  # Objects with DateAndTime syntax
+        self.object_syntax: Dict[str, ObjectSyntax] = {}  # Object OID (without instance) -> SYNTAX
+        self.object_details: Dict[str, MIBObject] = {}  # Object OID -> SYNTAX, DESCRIPTION etc. of loaded MIBs
+        self.table_indexes: Dict[str, TableIndex] = {}  # Table row (entry) OID -> its INDEX, or that of the row it AUGMENTS
+        # MIB file path -> (modification time and size, its modules), so unchanged files aren't parsed again
+        self.parsed_files: Dict[str, Tuple[Tuple[int, int], List[MIBModule]]] = {}
+
+        if config.mib_duplicate_policy not in DUPLICATE_POLICIES:
+            logger.warning(f"Unknown MIB_DUPLICATE_POLICY {config.mib_duplicate_policy}, "
+                           f"use one of {', '.join(DUPLICATE_POLICIES)}; first-wins applies")
 
         # Create MIB directory if it doesn't exist
         os.makedirs(self.mib_dir, exist_ok=True)
@@ -22,71 +454,761 @@ class MIBService:
         # Basic MIB mapping for common OIDs
         self._init_basic_mibs()
 
+        # MIB files added before a restart
+        self.load_mib_directory()
+
+        # Alias table from config
+        self._init_aliases()
+
     def _init_basic_mibs(self):
         """Initialize with basic MIB data for common OIDs"""
+        definitions: Dict[str, str] = {}
+
         # System MIB
-        self.name_oid_cache["SNMPv2-MIB::sysDescr.0"] = "1.3.6.1.2.1.1.1.0"
-        self.name_oid_cache["SNMPv2-MIB::sysObjectID.0"] = "1.3.6.1.2.1.1.2.0"
-        self.name_oid_cache["SNMPv2-MIB::sysUpTime.0"] = "1.3.6.1.2.1.1.3.0"
-        self.name_oid_cache["SNMPv2-MIB::sysContact.0"] = "1.3.6.1.2.1.1.4.0"
-        self.name_oid_cache["SNMPv2-MIB::sysName.0"] = "1.3.6.1.2.1.1.5.0"
-        self.name_oid_cache["SNMPv2-MIB::sysLocation.0"] = "1.3.6.1.2.1.1.6.0"
-        self.name_oid_cache["SNMPv2-MIB::sysServices.0"] = "1.3.6.1.2.1.1.7.0"
+        definitions["SNMPv2-MIB::sysDescr.0"] = "1.3.6.1.2.1.1.1.0"
+        definitions["SNMPv2-MIB::sysObjectID.0"] = "1.3.6.1.2.1.1.2.0"
+        definitions["SNMPv2-MIB::sysUpTime.0"] = "1.3.6.1.2.1.1.3.0"
+        definitions["SNMPv2-MIB::sysContact.0"] = "1.3.6.1.2.1.1.4.0"
+        definitions["SNMPv2-MIB::sysName.0"] = "1.3.6.1.2.1.1.5.0"
+        definitions["SNMPv2-MIB::sysLocation.0"] = "1.3.6.1.2.1.1.6.0"
+        definitions["SNMPv2-MIB::sysServices.0"] = "1.3.6.1.2.1.1.7.0"
 
         # Interface MIB
-        self.name_oid_cache["IF-MIB::ifNumber.0"] = "1.3.6.1.2.1.2.1.0"
-        self.name_oid_cache["IF-MIB::ifIndex"] = "1.3.6.1.2.1.2.2.1.1"
-        self.name_oid_cache["IF-MIB::ifDescr"] = "1.3.6.1.2.1.2.2.1.2"
-        self.name_oid_cache["IF-MIB::ifType"] = "1.3.6.1.2.1.2.2.1.3"
-        self.name_oid_cache["IF-MIB::ifMtu"] = "1.3.6.1.2.1.2.2.1.4"
-        self.name_oid_cache["IF-MIB::ifSpeed"] = "1.3.6.1.2.1.2.2.1.5"
-        self.name_oid_cache["IF-MIB::ifPhysAddress"] = "1.3.6.1.2.1.2.2.1.6"
-        self.name_oid_cache["IF-MIB::ifAdminStatus"] = "1.3.6.1.2.1.2.2.1.7"
-        self.name_oid_cache["IF-MIB::ifOperStatus"] = "1.3.6.1.2.1.2.2.1.8"
-        self.name_oid_cache["IF-MIB::ifInOctets"] = "1.3.6.1.2.1.2.2.1.10"
-        self.name_oid_cache["IF-MIB::ifOutOctets"] = "1.3.6.1.2.1.2.2.1.16"
-
-        # Build reverse mapping
-        for name, oid in self.name_oid_cache.items():
-            self.oid_name_cache[oid] = name
+        definitions["IF-MIB::ifNumber.0"] = "1.3.6.1.2.1.2.1.0"
+        definitions["IF-MIB::ifIndex"] = "1.3.6.1.2.1.2.2.1.1"
+        definitions["IF-MIB::ifDescr"] = "1.3.6.1.2.1.2.2.1.2"
+        definitions["IF-MIB::ifType"] = "1.3.6.1.2.1.2.2.1.3"
+        definitions["IF-MIB::ifMtu"] = "1.3.6.1.2.1.2.2.1.4"
+        definitions["IF-MIB::ifSpeed"] = "1.3.6.1.2.1.2.2.1.5"
+        definitions["IF-MIB::ifPhysAddress"] = "1.3.6.1.2.1.2.2.1.6"
+        definitions["IF-MIB::ifAdminStatus"] = "1.3.6.1.2.1.2.2.1.7"
+        definitions["IF-MIB::ifOperStatus"] = "1.3.6.1.2.1.2.2.1.8"
+        definitions["IF-MIB::ifInOctets"] = "1.3.6.1.2.1.2.2.1.10"
+        definitions["IF-MIB::ifOutOctets"] = "1.3.6.1.2.1.2.2.1.16"
+
+        # InetAddress columns and their sibling InetAddressType columns
+        self.inet_address_columns["1.3.6.1.2.1.4.24.7.1.6"] = "1.3.6.1.2.1.4.24.7.1.5"  # inetCidrRouteNextHop
+        self.inet_address_columns["1.3.6.1.2.1.80.1.2.1.4"] = "1.3.6.1.2.1.80.1.2.1.3"  # pingCtlTargetAddress
+        self.inet_address_columns["1.3.6.1.2.1.81.1.2.1.4"] = "1.3.6.1.2.1.81.1.2.1.3"  # traceRouteCtlTargetAddress
+
+        # Objects with DateAndTime syntax (SNMPv2-TC)
+        self.date_and_time_objects.add("1.3.6.1.2.1.25.1.2")  # hrSystemDate
+        self.date_and_time_objects.add("1.3.6.1.2.1.25.3.8.1.8")  # hrFSLastFullBackupDate
+        self.date_and_time_objects.add("1.3.6.1.2.1.25.3.8.1.9")  # hrFSLastPartialBackupDate
+        self.date_and_time_objects.add("1.3.6.1.2.1.25.6.3.1.5")  # hrSWInstalledDate
+
+        # SYNTAX and MAX-ACCESS of the objects above, for checking SET values
+        display_string = ObjectSyntax("OctetString", range=(0, 255))
+        if_status = {"up": 1, "down": 2, "testing": 3}
+        self.object_syntax["1.3.6.1.2.1.1.1"] = display_string  # sysDescr
+        self.object_syntax["1.3.6.1.2.1.1.2"] = ObjectSyntax("ObjectIdentifier")  # sysObjectID
+        self.object_syntax["1.3.6.1.2.1.1.3"] = ObjectSyntax("TimeTicks")  # sysUpTime
+        self.object_syntax["1.3.6.1.2.1.1.4"] = display_string._replace(writable=True)  # sysContact
+        self.object_syntax["1.3.6.1.2.1.1.5"] = display_string._replace(writable=True)  # sysName
+        self.object_syntax["1.3.6.1.2.1.1.6"] = display_string._replace(writable=True)  # sysLocation
+        self.object_syntax["1.3.6.1.2.1.1.7"] = ObjectSyntax("Integer", range=(0, 127))  # sysServices
+        self.object_syntax["1.3.6.1.2.1.2.1"] = ObjectSyntax("Integer")  # ifNumber
+        self.object_syntax["1.3.6.1.2.1.2.2.1.1"] = ObjectSyntax("Integer", range=(1, 2 ** 31 - 1))  # ifIndex
+        self.object_syntax["1.3.6.1.2.1.2.2.1.2"] = display_string  # ifDescr
+        self.object_syntax["1.3.6.1.2.1.2.2.1.3"] = ObjectSyntax("Integer")  # ifType
+        self.object_syntax["1.3.6.1.2.1.2.2.1.4"] = ObjectSyntax("Integer")  # ifMtu
+        self.object_syntax["1.3.6.1.2.1.2.2.1.5"] = ObjectSyntax("Gauge32")  # ifSpeed
+        self.object_syntax["1.3.6.1.2.1.2.2.1.6"] = ObjectSyntax("OctetString")  # ifPhysAddress
+        self.object_syntax["1.3.6.1.2.1.2.2.1.7"] = ObjectSyntax("Integer", enums=if_status, writable=True)  # ifAdminStatus
+        self.object_syntax["1.3.6.1.2.1.2.2.1.8"] = ObjectSyntax("Integer", enums={
+            **if_status, "unknown": 4, "dormant": 5, "notPresent": 6, "lowerLayerDown": 7
+        })  # ifOperStatus
+        self.object_syntax["1.3.6.1.2.1.2.2.1.10"] = ObjectSyntax("Counter32")  # ifInOctets
+        self.object_syntax["1.3.6.1.2.1.2.2.1.16"] = ObjectSyntax("Counter32")  # ifOutOctets
+
+        # INDEX of the table rows above
+        self.table_indexes["1.3.6.1.2.1.2.2.1"] = TableIndex([("ifIndex", "InterfaceIndex")], False)  # ifEntry
+
+        self._build_reverse_index(definitions)
 
         # Add standard MIBs to loaded list
         self.loaded_mibs.add("SNMPv2-MIB")
         self.loaded_mibs.add("IF-MIB")
 
+    @property
+    def name_oid_cache(self) -> Dict[str, str]:
+        """Name -> OID definitions of the current index"""
+        return self.reverse_index.definitions
+
+    @property
+    def oid_name_cache(self) -> Dict[str, str]:
+        """OID -> name translations of the current reverse index"""
+        return self.reverse_index.oid_name
+
+    @property
+    def oid_mib_cache(self) -> Dict[str, str]:
+        """OID -> defining MIB module of the current reverse index"""
+        return self.reverse_index.oid_mib
+
+    @property
+    def object_names(self) -> Dict[str, str]:
+        """Object OID -> object name of the current reverse index"""
+        return self.reverse_index.object_names
+
+    def _build_reverse_index(self, definitions: Optional[Dict[str, str]] = None):
+        """
+        Build the OID -> name mapping and record which module defines each OID, then swap it in
+
+        An OID defined by more than one module is named according to MIB_DUPLICATE_POLICY,
+        and recorded in the index's conflicts.
+
+        Args:
+            definitions: New name -> OID definitions to index, instead of the current ones
+
+        Raises:
+            MIBConflictError: If the policy is "error" and modules define the same OID; nothing is swapped in
+        """
+        definitions = dict(self.name_oid_cache) if definitions is None else definitions
+        names_by_oid: Dict[str, List[str]] = {}
+        for name, oid in definitions.items():
+            names_by_oid.setdefault(oid, []).append(name)
+
+        index = ReverseIndex(definitions, {}, {}, {}, {}, {})
+        for name in definitions:
+            index.short_names.setdefault(short_name(name), name)
+        for oid, names in names_by_oid.items():
+            if len({name.split("::", 1)[0] for name in names}) > 1:
+                index.conflicts[oid] = names
+            name = names[-1] if config.mib_duplicate_policy == "last-wins" else names[0]
+            index.oid_name[oid] = name
+            index.oid_mib[oid] = name.split("::", 1)[0]
+            object_oid, object_name = _index_object(name, oid)
+            index.object_names[object_oid] = object_name
+
+        if index.conflicts:
+            if config.mib_duplicate_policy == "error":
+                raise MIBConflictError(index.conflicts)
+            for oid, names in index.conflicts.items():
+                logger.warning(f"OID {oid} is defined as {', '.join(names)}; using {index.oid_name[oid]}")
+
+        self.reverse_index = index
+
+    def add_definitions(self, module: str, objects: Dict[str, str]) -> int:
+        """
+        Add the objects a MIB module defines to the index
+
+        Args:
+            module: Module name, e.g. IF-MIB
+            objects: Object name (with the instance, for scalars) -> OID
+
+        Returns:
+            Number of index entries after adding them
+
+        Raises:
+            MIBConflictError: If the duplicate policy is "error" and another module defines
+                one of the OIDs; nothing is added then
+        """
+        with self._rebuild_lock:
+            self._build_reverse_index({
+                **self.name_oid_cache,
+                **{f"{module}::{name}": normalize_oid(oid) for name, oid in objects.items()}
+            })
+            self.loaded_mibs.add(module)
+            entries = len(self.reverse_index.oid_name)
+        clear_cache(key_prefix="mib_oids_")
+        return entries
+
+    def _place_objects(self, module: MIBModule, definitions: Dict[str, str], objects: Dict[str, str],
+                       loaded: Set[str]) -> Dict[str, str]:
+        """
+        Work out the OIDs of a module's objects
+
+        An object is placed under the node it is defined under: an object of the same
+        module, a symbol the module imports from a loaded module, or any other object of
+        the index or registration tree node of that name.
+
+        Args:
+            module: The module
+            definitions: Qualified name -> OID of the index, and of the modules loaded along with it
+            objects: Object name -> object OID of the same
+            loaded: Names of the modules loaded, and loaded along with it
+
+        Returns:
+            Object name -> OID, for the objects that could be placed
+        """
+        imported = {}
+        for source, symbols in module.imports.items():
+            if source not in SMI_MODULES and source not in loaded:
+                logger.warning(f"{module.name} imports {', '.join(symbols)} from {source}, which isn't loaded")
+            for symbol in symbols:
+                oid = definitions.get(f"{source}::{symbol}")
+                if oid:
+                    imported[symbol] = oid
+        known = {**_TREE_NODE_OIDS, **objects, **imported}
+
+        # Objects may be defined before the node they hang off
+        placed: Dict[str, str] = {}
+        progress = True
+        while progress:
+            progress = False
+            for name, (parent, numbers) in module.objects.items():
+                if name in placed:
+                    continue
+                parent_oid = parent if parent.isdigit() else placed.get(parent) or known.get(parent)
+                if parent_oid is None:
+                    continue
+                placed[name] = ".".join([parent_oid, *(str(number) for number in numbers)])
+                progress = True
+
+        unplaced = [name for name in module.objects if name not in placed]
+        if unplaced:
+            logger.warning(f"Could not work out the OIDs of {module.name} objects {', '.join(unplaced)}")
+        return placed
+
+    def load_mib(self, text: str) -> Dict[str, int]:
+        """
+        Parse MIB source and add the objects of its modules to the index, with their SYNTAX,
+        MAX-ACCESS and DESCRIPTION, and the INDEX of its table rows
+
+        Modules are loaded in the order they are defined, so a module can use the objects
+        of the modules before it. Parent nodes imported from other modules are looked up
+        among the modules loaded already; objects under nodes that can't be found are left
+        out, and logged. A row that AUGMENTS another takes the INDEX of that row, if it is
+        loaded.
+
+        Returns:
+            Module name -> number of its objects added
+
+        Raises:
+            MIBConflictError: If the duplicate policy is "error" and another module defines
+                one of the OIDs; the modules before it stay loaded
+        """
+        return self._load_modules(parse_mib(text, references=config.mib_cross_references))
+
+    def _load_modules(self, modules: List[MIBModule], skip_conflicting: bool = False) -> Dict[str, int]:
+        """
+        Add parsed modules to the index, in order, rebuilding the index once for all of them; see load_mib
+
+        With skip_conflicting, a module the "error" duplicate policy refuses is logged and
+        left out, instead of ending the load there.
+        """
+        added: List[Tuple[MIBModule, Dict[str, str]]] = []
+        refused: Optional[MIBConflictError] = None
+        with self._rebuild_lock:
+            definitions = dict(self.name_oid_cache)
+            names_by_oid: Dict[str, List[str]] = {}
+            for name, oid in definitions.items():
+                names_by_oid.setdefault(oid, []).append(name)
+            objects = {name: oid for oid, name in self.object_names.items()}
+            loaded_names = set(self.loaded_mibs)
+
+            for module in modules:
+                placed = self._place_objects(module, definitions, objects, loaded_names)
+                qualified = {f"{module.name}::{name}": normalize_oid(oid) for name, oid in placed.items()}
+                if config.mib_duplicate_policy == "error":
+                    conflicts = {
+                        oid: names_by_oid[oid] + [name] for name, oid in qualified.items()
+                        if any(other.split("::", 1)[0] != module.name for other in names_by_oid.get(oid, ()))
+                    }
+                    if conflicts:
+                        refused = MIBConflictError(conflicts)
+                        if not skip_conflicting:
+                            break
+                        logger.error(f"Not loading MIB module {module.name}: {refused}")
+                        refused = None
+                        continue
+
+                definitions.update(qualified)
+                for name, oid in qualified.items():
+                    names_by_oid.setdefault(oid, []).append(name)
+                    object_oid, object_name = _index_object(name, oid)
+                    objects[object_name] = object_oid
+                loaded_names.add(module.name)
+                added.append((module, placed))
+
+            if added:
+                self._build_reverse_index(definitions)
+                self.loaded_mibs.update(module.name for module, _ in added)
+        if added:
+            clear_cache(key_prefix="mib_oids_")
+
+        loaded = {}
+        for module, placed in added:
+            for name, oid in placed.items():
+                details = module.details[name]
+                self.object_details[oid] = details
+                if details.index:
+                    implied = details.index[-1].startswith("IMPLIED ")
+                    objects = [index_name.replace("IMPLIED ", "") for index_name in details.index]
+                    self.table_indexes[oid] = TableIndex(
+                        [(index_name, self._index_syntax(module, index_name)) for index_name in objects], implied
+                    )
+            for name, oid in placed.items():
+                augments = module.details[name].augments
+                base_oid = (placed.get(augments) or self._lookup_name(augments)) if augments else None
+                if base_oid in self.table_indexes:
+                    self.table_indexes[oid] = self.table_indexes[base_oid]
+            loaded[module.name] = len(placed)
+            logger.info(f"Loaded MIB module {module.name} with {len(placed)} objects")
+        if refused:
+            raise refused
+        return loaded
+
+    def load_mib_directory(self) -> Dict[str, int]:
+        """
+        Load the MIB files of the MIB directory into the index, e.g. those added before a restart
+
+        All files are parsed first, in parallel for large directories, then their modules
+        are added to the index, each after the modules it imports from, where those are in
+        the directory too, whatever the order of the files; the index is rebuilt once, after
+        all of them. A module the index refuses under the "error" duplicate policy is logged
+        and left out.
+
+        Returns:
+            Module name -> number of its objects added
+        """
+        modules, _ = self._read_mib_files()
+        return self._load_modules(_dependency_order(modules), skip_conflicting=True)
+
+    def _index_syntax(self, module: MIBModule, name: str) -> Optional[str]:
+        """SYNTAX of an INDEX object of a module, defined by the module itself or imported from a loaded one"""
+        if name in module.details:
+            return module.details[name].syntax
+        for source, symbols in module.imports.items():
+            oid = self.name_oid_cache.get(f"{source}::{name}") if name in symbols else None
+            if oid and oid in self.object_details:
+                return self.object_details[oid].syntax
+        return None
+
+    def get_oid_info(self, oid: str) -> Optional[Dict[str, Any]]:
+        """
+        Describe the MIB object an OID belongs to
+
+        Instance OIDs, such as a table column's 1.3.6.1.2.1.2.2.1.2.5 or a scalar's
+        1.3.6.1.2.1.1.5.0, resolve to the object with the longest OID above them; the
+        rest of the OID is the instance. For columns of tables whose INDEX is known,
+        the instance is split into the values of the index objects as well.
+
+        Args:
+            oid: Numeric OID of an object or an instance of it
+
+        Returns:
+            The OID, the object's name and defining module, the instance and index values
+            (None for the object itself, or an instance of an unknown INDEX), its SYNTAX,
+            MAX-ACCESS and DESCRIPTION (None for built-in objects whose MIB wasn't loaded),
+            and the objects its DESCRIPTION mentions; None if no object of the index is the
+            OID or above it
+        """
+        oid = normalize_oid(oid)
+        match = _longest_match(oid, self.oid_name_cache) if is_numeric_oid(oid) else None
+        if match is None:
+            return None
+        known_oid, name = match
+        object_oid, object_name = _index_object(name, known_oid)
+        instance = oid[len(object_oid) + 1:] or None
+        row = self.table_indexes.get(object_oid.rsplit(".", 1)[0])
+        details = self.object_details.get(object_oid)
+        return {
+            "oid": oid,
+            "name": object_name,
+            "mib": name.split("::", 1)[0],
+            "instance": instance,
+            "index": _index_values(row, [int(part) for part in instance.split(".")]) if row and instance else None,
+            "syntax": details.syntax if details else None,
+            "access": details.access if details else None,
+            "description": details.description if details else None,
+            "related": self._related_objects(details, name.split("::", 1)[0], object_oid),
+        }
+
+    def _related_objects(self, details: Optional[MIBObject], mib: str, object_oid: str) -> List[Dict[str, Any]]:
+        """
+        The objects of the index a DESCRIPTION mentions, e.g. "see ifTable"
+
+        A name is looked up in the object's own module first, then in any. Words that
+        don't name anything in the index are left out, as are the object itself and repeats.
+        """
+        related: List[Dict[str, Any]] = []
+        for name in (details.references or []) if details else []:
+            oid = self.name_oid_cache.get(f"{mib}::{name}") or self._lookup_name(name)
+            if oid is None or oid == object_oid or any(other["oid"] == oid for other in related):
+                continue
+            related.append({"name": name, "oid": oid, "mib": self.oid_mib_cache.get(oid)})
+        return related
+
+    def table_structure(self, table: str) -> Optional[TableStructure]:
+        """
+        Describe a table: its row object, its columns and the INDEX of its rows
+
+        Columns are the objects of the index directly under the row, so a table of a MIB
+        that isn't loaded has none; its cells can still be told apart by column number.
+
+        Args:
+            table: Name or numeric OID of the table (ifTable) or of its row (ifEntry)
+
+        Returns:
+            The table's structure; None if the name doesn't resolve
+        """
+        oid = self.resolve_oid(table)
+        if oid is None:
+            return None
+        oid = normalize_oid(oid)
+        entry = oid if oid in self.table_indexes else f"{oid}.1"
+        columns = sorted(
+            (int(object_oid.rsplit(".", 1)[1]), object_oid, name)
+            for object_oid, name in self.object_names.items() if object_oid.rsplit(".", 1)[0] == entry
+        )
+        return TableStructure(
+            entry, {object_oid: name for _, object_oid, name in columns}, self.table_indexes.get(entry)
+        )
+
+    def rebuild_index(self) -> int:
+        """
+        Rebuild the reverse (OID -> name) index from the loaded MIB definitions
+
+        Recovers from an index that is out of sync with the definitions, e.g. after
+        a partial load. Cached per-MIB OID lists are dropped as well. Queries keep
+        reading the old index until the new one is complete.
+
+        Returns:
+            Number of index entries rebuilt
+
+        Raises:
+            MIBConflictError: If the duplicate policy is "error" and modules define the same OID
+        """
+        with self._rebuild_lock:
+            self._build_reverse_index()
+            entries = len(self.reverse_index.oid_name)
+        clear_cache(key_prefix="mib_oids_")
+
+        logger.info(f"Rebuilt MIB OID index with {entries} entries")
+        return entries
+
+    def _init_aliases(self):
+        """Initialize the alias table from config"""
+        for alias, target in config.oid_aliases.items():
+            self.aliases[alias.lower()] = target
+
+    def get_aliases(self) -> Dict[str, str]:
+        """Get the alias table (alias -> OID or symbolic name)"""
+        return dict(self.aliases)
+
+    def _resolve_alias(self, alias: str) -> Optional[str]:
+        """Resolve an alias to an OID, or None if it isn't defined"""
+        target = self.aliases.get(alias.lower())
+        if target is None:
+            return None
+
+        # Aliases may point at a numeric OID or at a name in the MIB index
+        if is_numeric_oid(target):
+            return target
+
+        return self.name_oid_cache.get(target)
+
     def get_loaded_mibs(self) -> List[str]:
         """Get a list of loaded MIB names"""
         return list(self.loaded_mibs)
 
+    def list_mibs(self) -> List[Dict[str, Any]]:
+        """
+        List the MIB modules in the MIB directory and those loaded into the index
+
+        Each module comes with the file defining it and the file's size (None for
+        modules built into the index), whether it is loaded, and its number of
+        objects once loaded. Files without a module definition are listed by file
+        name. An empty MIB directory lists only the loaded modules. Only the files new
+        or changed since they were last scanned are parsed (see _read_mib_files).
+        """
+        objects: Dict[str, Set[str]] = {}
+        for name, oid in self.name_oid_cache.items():
+            if "::" in name:
+                objects.setdefault(name.split("::", 1)[0], set()).add(_index_object(name, oid)[0])
+
+        summaries: Dict[str, Dict[str, Any]] = {}
+        self._read_mib_files()
+        for path, ((mtime, size), found) in self.parsed_files.items():
+            if not mtime:
+                # Unreadable
+                continue
+            file_name = os.path.basename(path)
+            for name in [module.name for module in found] or [os.path.splitext(file_name)[0]]:
+                summaries.setdefault(name, {"name": name, "file": file_name, "size": size})
+
+        for name in self.loaded_mibs:
+            summaries.setdefault(name, {"name": name, "file": None, "size": None})
+        for name, summary in summaries.items():
+            summary["loaded"] = name in self.loaded_mibs
+            summary["objects"] = len(objects.get(name, ())) if summary["loaded"] else None
+
+        return sorted(summaries.values(), key=lambda summary: summary["name"])
+
     def resolve_oid(self, name: str) -> Optional[str]:
-        """Resolve a symbolic name to an OID"""
-        # Check cache first
-        if name in self.name_oid_cache:
-            return self.name_oid_cache[name]
+        """Resolve a symbolic name to an OID; numeric OIDs (also .1.3.6... and iso.3.6...) resolve to themselves"""
+        name = normalize_oid(name)
+        if is_numeric_oid(name):
+            return name
+
+        # Aliases take precedence over the MIB index
+        alias_oid = self._resolve_alias(name)
+        if alias_oid:
+            return alias_oid
+
+        if "." in name and not name.startswith("."):
+            base_name, index = name.split(".", 1)
+            alias_oid = self._resolve_alias(base_name)
+            if alias_oid:
+                return f"{alias_oid}.{index}"
+
+        oid = self._lookup_name(name)
+        if oid:
+            return oid
 
         # Handle index notation (e.g., ifDescr.1)
         if "." in name and not name.startswith("."):
             base_name, index = name.split(".", 1)
-            if base_name in self.name_oid_cache:
-                return f"{self.name_oid_cache[base_name]}.{index}"
+            oid = self._lookup_name(base_name)
+            if oid:
+                return f"{oid}.{index}"
 
         return None
 
+    def _lookup_name(self, name: str) -> Optional[str]:
+        """
+        OID of a name in the index, with its module (IF-MIB::ifDescr) or without (ifDescr),
+        or else of a registration tree node (ifTable)
+        """
+        index = self.reverse_index  # One index throughout, even if it is swapped meanwhile
+        name_oids = index.definitions
+        if name in name_oids:
+            return name_oids[name]
+        qualified = index.short_names.get(name)
+        if qualified and qualified in name_oids:
+            return name_oids[qualified]
+        return _TREE_NODE_OIDS.get(short_name(name))
+
+    def suggest_mib(self, name: str) -> Optional[str]:
+        """
+        Guess which MIB defines a name, to suggest loading it
+
+        Returns:
+            The module a qualified name gives, or the MIB of the longest MIB_NAME_PREFIXES
+            prefix of the name; None if there is no guess, or the MIB is loaded already
+        """
+        module, _, object_name = name.rpartition("::")
+        if not module:
+            matches = [prefix for prefix in MIB_NAME_PREFIXES
+                       if object_name.startswith(prefix) and object_name[len(prefix):][:1].isupper()]
+            module = MIB_NAME_PREFIXES[max(matches, key=len)] if matches else ""
+        return module if module and module not in self.loaded_mibs else None
+
+    def resolve_name(self, name: str) -> str:
+        """
+        Resolve a symbolic name from loaded MIBs (or an alias) to its numeric OID
+
+        Raises:
+            UnresolvedNameError: If no loaded MIB defines the name, with the MIB to load if it can be guessed
+        """
+        oid = self.resolve_oid(name)
+        if oid is None:
+            raise UnresolvedNameError(name, self.suggest_mib(name))
+        return oid
+
     def translate_oid(self, oid: str) -> Optional[str]:
         """Translate an OID to a symbolic name"""
-        # Check exact match in cache first
-        if oid in self.oid_name_cache:
-            return self.oid_name_cache[oid]
+        # The most specific object, e.g. the column of a table cell rather than its table or row
+        match = _longest_match(oid, self.oid_name_cache)
+        if match is None:
+            return None
+        known_oid, known_name = match
+        if known_oid == oid:
+            return known_name
+
+        suffix = oid[len(known_oid):]
+        base_name = known_name.split(".")[0]  # Remove any existing index
+        return f"{base_name}{suffix}"
+
+    def full_path(self, oid: str) -> str:
+        """
+        Write an OID as the path of node names from the root
+
+        Nodes without a known name, such as instance indexes and objects no loaded
+        MIB defines, keep their number.
+
+        Returns:
+            The path, e.g. iso.org.dod.internet.mgmt.mib-2.interfaces.ifTable.ifEntry.ifInOctets.3
+        """
+        parts = oid.strip(".").split(".")
+        labels = []
+        for length in range(1, len(parts) + 1):
+            prefix = ".".join(parts[:length])
+            labels.append(self.object_names.get(prefix) or OID_TREE_NODES.get(prefix) or parts[length - 1])
+        return ".".join(labels)
+
+    def format_name(self, oid: Optional[str], name: Optional[str], style: str) -> Optional[str]:
+        """
+        Write a result's name in an OID style
+
+        Args:
+            oid: Numeric OID of the result, if known
+            name: Module-qualified symbolic name of the result, if known
+            style: One of OID_STYLES
+
+        Returns:
+            The name in that style; None for the short and module styles when the OID has no symbolic name
+        """
+        if style == "numeric":
+            return oid or name
+        if style == "full":
+            return self.full_path(oid) if oid else name
+        if name and style == "short":
+            return short_name(name)
+        return name
 
-        # Try to match base OIDs
-        for known_oid, known_name in self.oid_name_cache.items():
-            if oid.startswith(known_oid + "."):
-                suffix = oid[len(known_oid):]
-                base_name = known_name.split(".")[0]  # Remove any existing index
-                return f"{base_name}{suffix}"
+    def get_oid_mib(self, oid: str) -> Optional[str]:
+        """Get the name of the MIB module that defines an OID (or the object an instance belongs to)"""
+        oid = normalize_oid(oid)
+        oid_mibs = self.oid_mib_cache  # One index throughout, even if it is swapped meanwhile
+        if oid in oid_mibs:
+            return oid_mibs[oid]
 
+        # Longest matching object OID, so instances of nested objects resolve to the most specific module
+        matches = [known_oid for known_oid in oid_mibs if oid.startswith(known_oid + ".")]
+        if matches:
+            return oid_mibs[max(matches, key=len)]
+
+        return None
+
+    def defines_oid(self, oid: str) -> bool:
+        """Check whether a loaded MIB defines an OID, an instance of it, or objects under it"""
+        oid = normalize_oid(oid)
+        if self.get_oid_mib(oid):
+            return True
+        return any(known_oid.startswith(oid + ".") for known_oid in self.oid_mib_cache)
+
+    def objects_under(self, oid: str) -> List[str]:
+        """Get the OIDs of the objects loaded MIBs define under an OID"""
+        oid = normalize_oid(oid)
+        return [known_oid for known_oid in self.oid_mib_cache if known_oid.startswith(oid + ".")]
+
+    def get_inet_address_type_oid(self, oid: str) -> Optional[str]:
+        """
+        Get the sibling InetAddressType instance OID for an InetAddress column instance,
+        or None if the OID isn't in a known InetAddress column
+        """
+        oid = normalize_oid(oid)
+        for address_column, type_column in self.inet_address_columns.items():
+            if oid.startswith(address_column + "."):
+                return type_column + oid[len(address_column):]
+        return None
+
+    def is_date_and_time(self, oid: str) -> bool:
+        """Check whether an OID is an instance of an object with DateAndTime syntax"""
+        oid = normalize_oid(oid)
+        return any(oid == obj or oid.startswith(obj + ".") for obj in self.date_and_time_objects)
+
+    def get_object_syntax(self, oid: str) -> Optional[ObjectSyntax]:
+        """
+        Get the SYNTAX and MAX-ACCESS of the object an OID (or an instance of it) belongs to, if known
+
+        The built-in objects' are known, and those of the OBJECT-TYPEs of loaded MIBs.
+        """
+        oid = normalize_oid(oid)
+        matches = [obj for obj in self.object_syntax if oid == obj or oid.startswith(obj + ".")]
+        if matches:
+            return self.object_syntax[max(matches, key=len)]
+        parts = oid.split(".")
+        for length in range(len(parts), 0, -1):
+            details = self.object_details.get(".".join(parts[:length]))
+            if details is not None:
+                return _object_syntax(details) if details.kind == "OBJECT-TYPE" else None
         return None
 
+    def resolve_set_value(self, oid: str, value_type: Optional[str],
+                          value: Union[int, str]) -> Tuple[str, Union[int, str]]:
+        """
+        Check a value to SET an object instance to against the object's SYNTAX
+
+        Enumeration labels are resolved to their numbers (ifAdminStatus "down" -> 2),
+        and numbers given as text are converted for integer types. Only objects known
+        to be writable, built-in ones or those of loaded MIBs with a MAX-ACCESS of
+        read-write or read-create, can be set. Objects whose SYNTAX is a textual
+        convention not known here need an explicit type, and the value is only checked
+        against it.
+
+        Args:
+            oid: Numeric OID of the object instance
+            value_type: ASN.1 type the value was given as (one of SET_VALUE_TYPES), if any
+            value: Value to write
+
+        Returns:
+            The type and the value to write
+
+        Raises:
+            ValueError: If the object is unknown or read-only, or the value doesn't match its type,
+                range or enumeration
+        """
+        name = self.translate_oid(oid) or oid
+        syntax = self.get_object_syntax(oid)
+        if value_type is not None and value_type not in SET_VALUE_TYPES:
+            raise ValueError(f"Unknown SET value type {value_type} for {name}; use one of {', '.join(SET_VALUE_TYPES)}")
+
+        if syntax is None:
+            raise ValueError(f"{name} is not an object of a loaded MIB, so it isn't known to be writable; "
+                             f"load its MIB to set it")
+        if not syntax.writable:
+            raise ValueError(f"{name} is read-only")
+        if syntax.type is None:
+            if value_type is None:
+                raise ValueError(f"The SYNTAX of {name} is unknown; give the type of the value to set")
+            syntax = syntax._replace(type=value_type)
+        elif value_type is not None and value_type != syntax.type:
+            raise ValueError(f"{name} is {syntax.type}, so it can't be set to an {value_type} value")
+
+        if syntax.type in INTEGER_TYPE_RANGES:
+            return syntax.type, self._resolve_integer(name, syntax, value)
+        if isinstance(value, int) and not isinstance(value, bool) and syntax.type == "OctetString":
+            value = str(value)
+        if not isinstance(value, str):
+            raise ValueError(f"{name} is {syntax.type}, so it can't be set to {value!r}")
+
+        if syntax.type == "OctetString":
+            size = len(value.encode())
+            low, high = syntax.range or (0, 65535)
+            if not low <= size <= high:
+                raise ValueError(f"{name} must be {low} to {high} bytes long, not {size}")
+        elif syntax.type == "ObjectIdentifier":
+            resolved = self.resolve_oid(value) or normalize_oid(value)
+            error = oid_syntax_error(resolved)
+            if error:
+                raise ValueError(f"{name} is ObjectIdentifier, but {value!r} is not an OID: {error}")
+            value = resolved
+        elif syntax.type == "IpAddress":
+            try:
+                value = str(ipaddress.IPv4Address(value))
+            except ValueError:
+                raise ValueError(f"{name} is IpAddress, but {value!r} is not an IPv4 address")
+        return syntax.type, value
+
+    @staticmethod
+    def _resolve_integer(name: str, syntax: ObjectSyntax, value: Union[int, str]) -> int:
+        """Resolve an enumeration label or number to an integer within an object's range"""
+        if isinstance(value, bool):
+            raise ValueError(f"{name} is {syntax.type}, so it can't be set to {value!r}")
+        if isinstance(value, str):
+            labels = {label.lower(): number for label, number in (syntax.enums or {}).items()}
+            text = value.strip()
+            if text.lower() in labels:
+                return labels[text.lower()]
+            try:
+                value = int(text)
+            except ValueError:
+                if syntax.enums:
+                    raise ValueError(f"{name} can't be set to {value!r}: expected one of {_enum_labels(syntax.enums)}")
+                raise ValueError(f"{name} is {syntax.type}, so it can't be set to {value!r}")
+
+        if syntax.enums:
+            if value not in syntax.enums.values():
+                raise ValueError(f"{name} can't be set to {value}: expected one of {_enum_labels(syntax.enums)}")
+            return value
+        low, high = syntax.range or INTEGER_TYPE_RANGES[syntax.type]
+        if not low <= value <= high:
+            raise ValueError(f"{name} must be between {low} and {high}, not {value}")
+        return value
+
     def get_mib_oids(self, mib_name: str) -> List[str]:
         """Get all OIDs defined in a specific MIB"""
         cache_key = f"mib_oids_{mib_name}"
@@ -107,8 +1229,132 @@ class MIBService:
 
         return oids
 
+    def _read_mib_files(self) -> Tuple[List[MIBModule], List[str]]:
+        """
+        Scan the MIB files in the MIB directory, returning their modules and the files without one
+
+        Only files new or changed since they were last scanned are read and parsed.
+        """
+        parsed = {}
+        changed, texts = [], []
+        for path in sorted(glob.glob(os.path.join(self.mib_dir, "*"))):
+            if not os.path.isfile(path):
+                continue
+            try:
+                stat = os.stat(path)
+                version = (stat.st_mtime_ns, stat.st_size)
+                if path in self.parsed_files and self.parsed_files[path][0] == version:
+                    parsed[path] = self.parsed_files[path]
+                    continue
+                with open(path, encoding="utf-8", errors="replace") as mib_file:
+                    texts.append(mib_file.read())
+            except OSError as e:
+                logger.warning(f"Could not read MIB file {path}: {e}")
+                version = (0, 0)
+                texts.append("")
+            parsed[path] = (version, [])
+            changed.append(path)
+
+        for path, found in zip(changed, _parse_mib_texts(texts)):
+            parsed[path] = (parsed[path][0], found)
+        self.parsed_files = parsed
+
+        modules, unparsed = [], []
+        for path, (_, found) in parsed.items():
+            if found:
+                modules.extend(found)
+            else:
+                unparsed.append(os.path.basename(path))
+        return modules, unparsed
+
+    def health(self) -> Dict[str, Any]:
+        """
+        Summarize the state of the MIB index and the MIB files in the MIB directory
+
+        Objects of the files are placed in the OID tree through the nodes they are
+        defined under, which may come from the built-in index, the registration tree
+        or other files. Problems reported:
+
+        - unresolved_imports: modules a file imports from that aren't loaded
+        - unresolved_objects: objects whose OID can't be worked out, e.g. because the
+          node they are defined under comes from a missing module
+        - duplicate_oids: OIDs defined by objects of more than one module
+        - index_conflicts: OIDs of the index defined by more than one module, and
+          the name the index uses for each (per MIB_DUPLICATE_POLICY)
+        - unparsed_files: files in the MIB directory without a module definition
+
+        Files parsed before, e.g. at startup, aren't parsed again unless they changed.
+
+        Returns:
+            Counts of modules and objects, the problems found, and whether there were none
+        """
+        modules, unparsed = self._read_mib_files()
+
+        # Object OID -> qualified names defining it; the built-in index first
+        definitions: Dict[str, Set[str]] = {}
+        for name, oid in self.name_oid_cache.items():
+            object_oid, object_name = _index_object(name, oid)
+            definitions.setdefault(object_oid, set()).add(f"{name.split('::', 1)[0]}::{object_name}")
+        known = dict(_TREE_NODE_OIDS)
+        known.update({name: oid for oid, name in self.object_names.items()})
+
+        # Resolve objects until no more can be, as files may define nodes used by other files
+        resolved: Dict[Tuple[str, str], str] = {}
+        progress = True
+        while progress:
+            progress = False
+            for module in modules:
+                local = {name: oid for (module_name, name), oid in resolved.items() if module_name == module.name}
+                for name, (parent, numbers) in module.objects.items():
+                    if (module.name, name) in resolved:
+                        continue
+                    parent_oid = parent if parent.isdigit() else local.get(parent) or known.get(parent)
+                    if parent_oid is None:
+                        continue
+                    oid = ".".join([parent_oid, *(str(number) for number in numbers)])
+                    resolved[(module.name, name)] = oid
+                    known.setdefault(name, oid)
+                    progress = True
+
+        available = SMI_MODULES | {name.split("::", 1)[0] for name in self.name_oid_cache} | \
+            {module.name for module in modules}
+        unresolved_imports = [
+            {"module": module.name, "imports_from": source, "symbols": symbols}
+            for module in modules
+            for source, symbols in module.imports.items() if source not in available
+        ]
+        unresolved_objects = [
+            {"module": module.name, "object": name, "parent": parent}
+            for module in modules
+            for name, (parent, _) in module.objects.items() if (module.name, name) not in resolved
+        ]
+        for (module_name, name), oid in resolved.items():
+            definitions.setdefault(oid, set()).add(f"{module_name}::{name}")
+        duplicate_oids = [
+            {"oid": oid, "definitions": sorted(names)}
+            for oid, names in sorted(definitions.items())
+            if len({name.split("::", 1)[0] for name in names}) > 1
+        ]
+
+        index = self.reverse_index
+        index_conflicts = [
+            {"oid": oid, "definitions": names, "resolved_to": index.oid_name[oid]}
+            for oid, names in sorted(index.conflicts.items())
+        ]
+
+        return {
+            "healthy": not (unresolved_imports or unresolved_objects or duplicate_oids or index_conflicts or unparsed),
+            "modules": len(available - SMI_MODULES),
+            "objects": len(definitions),
+            "unresolved_imports": unresolved_imports,
+            "unresolved_objects": unresolved_objects,
+            "duplicate_oids": duplicate_oids,
+            "index_conflicts": index_conflicts,
+            "unparsed_files": unparsed,
+        }
+
     def add_mib_file(self, file_path: str) -> bool:
-        """Add a new MIB file to the MIB directory (simplified handling)"""
+        """Load a MIB file into the index and copy it to the MIB directory"""
         try:
             # Copy MIB file to MIB directory
             file_name = os.path.basename(file_path)
@@ -118,13 +1364,16 @@ class MIBService:
                 with open(file_path, 'rb') as src_file:
                     mib_content = src_file.read()
 
+                # Load it first, so that a file the index refuses isn't kept
+                loaded = self.load_mib(mib_content.decode("utf-8", errors="replace"))
+                if not loaded:
+                    # Without a module definition, only the file name is known
+                    logger.warning(f"No MIB module definition found in {file_name}")
+                    self.loaded_mibs.add(os.path.splitext(file_name)[0])
+
                 with open(target_path, 'wb') as dest_file:
                     dest_file.write(mib_content)
 
-                # Add MIB name to loaded list
-                mib_name = os.path.splitext(file_name)[0]
-                self.loaded_mibs.add(mib_name)
-
                 logger.info(f"MIB file added: {file_name}")
                 return True
             else:
@@ -1,108 +1,537 @@
+import hashlib
 import json
 import time
 import asyncio
-from typing import Dict, Any, Optional
-from openai import OpenAI
+from typing import Awaitable, Callable, Dict, Any, List, Optional, Set, Tuple
 from openai.types.chat import ChatCompletion
 from openai import APIError, RateLimitError, APIConnectionError, OpenAIError
 from loguru import logger
 
-from app.core.config import config
-from app.models.query import SNMPQuery, SNMPResponse, SNMPTarget, SNMPCredentials, SNMPOperation
+from app.core.config import config, APIKeyPolicy
+from app.models.query import (
+    SNMPQuery, SNMPResponse, SNMPTarget, SNMPCredentials, SNMPOperation, Clarification, EMPTY_REASONS
+)
+from app.services.interpretation_cache import InterpretationCache
+from app.services.interpretation_stream import StreamRejected, StreamingInterpretation
+from app.services.keyword_service import KeywordService
+from app.services.llm_providers import create_chat_client
+from app.services.llm_batcher import MicroBatcher
+from app.services.mib_service import MIBService
+from app.services.query_transforms import apply_query_transforms
+from app.utils.metrics import registry, timeout_buckets
+from app.utils.redaction import compile_patterns, redact, truncate
+
+
+# Error codes of interpretations that failed because of the LLM rather than the query
+LLM_RATE_LIMITED = "LLM_RATE_LIMITED"
+LLM_BAD_RESPONSE = "LLM_BAD_RESPONSE"
+
+# LLM calls interpreting queries, by provider and outcome (success, rate-limited, bad-response or error)
+LLM_INTERPRETATION_DURATION = registry.histogram(
+    "llm_interpretation_duration_seconds", "Duration of LLM calls interpreting queries, retries included",
+    ("provider", "outcome"), buckets=timeout_buckets(config.openai.timeout)
+)
+# Lookups in the interpretation and response caches, by cache and hit or miss
+LLM_CACHE_LOOKUPS = registry.counter(
+    "llm_cache_lookups", "Lookups of cached interpretations and responses", ("cache", "result")
+)
+
+
+class ClarificationNeeded(Exception):
+    """Raised when a query is too ambiguous to run"""
+
+    def __init__(self, clarification: Clarification):
+        super().__init__("; ".join(clarification.questions) or "Query needs clarification")
+        self.clarification = clarification
+
+
+class LLMRateLimited(Exception):
+    """Raised when the LLM provider still rate limits the service after the retries"""
+
+    def __init__(self, message: str, retry_after: Optional[float] = None):
+        super().__init__(message)
+        self.retry_after = retry_after  # Seconds, if the API said
+
+
+class LLMResponseError(Exception):
+    """Raised when the model's answer can't be used, e.g. isn't JSON"""
+
+
+def _retry_after(error: RateLimitError) -> Optional[float]:
+    """The Retry-After seconds of a rate limit response, if it has a usable one"""
+    response = getattr(error, "response", None)
+    try:
+        return float(response.headers.get("retry-after")) if response is not None else None
+    except (TypeError, ValueError):
+        return None
+
+
+# User prompt asking the model to interpret one query
+INTERPRET_PROMPT = "Convert this SNMP query to a JSON structure: '{query}'"
+
+
+def interpret_prompt(query: str, context: Optional[str] = None) -> str:
+    """The prompt asking to interpret a query, after the conversation context if there is one"""
+    prompt = INTERPRET_PROMPT.format(query=query)
+    return f"{context}\n\n{prompt}" if context else prompt
+
+
+def prompt_version(prompt: str) -> str:
+    """Short hash of a prompt template, which changes whenever the template does"""
+    return hashlib.sha256(prompt.encode()).hexdigest()[:12]
+
 
 class OpenAIService:
-    def __init__(self):
-        self.client = OpenAI(api_key=config.openai.api_key)
+    """
+    Interprets queries and summarizes responses with the LLM of LLM_PROVIDER
+
+    Every provider's client has the OpenAI client's interface (see llm_providers), so
+    the caching, batching, retries and streaming here don't depend on the provider.
+    """
+
+    def __init__(self, mib_service: Optional[MIBService] = None):
+        # Raises ValueError for an unknown provider, so a misconfigured service doesn't start
+        self.client = create_chat_client(config.openai)
         self.model = config.openai.model
         self.temperature = config.openai.temperature
         self.max_tokens = config.openai.max_tokens
         self.system_prompt = config.openai.system_prompt
         self.max_retries = 3
         self.retry_base_delay = 1  # seconds
+        self.keyword_service = KeywordService(mib_service)
+        self.log_redact_patterns = compile_patterns(config.openai.log_redact_patterns)
+        # Interpretations arriving together share an LLM call when batching is enabled
+        self.batcher: Optional[MicroBatcher[str, Optional[Dict[str, Any]]]] = None
+        if config.openai.batch_interpretations:
+            self.batcher = MicroBatcher(self._complete_interpretations,
+                                        config.openai.batch_max_size, config.openai.batch_max_wait)
+        # Most recently used interpretations skip the LLM call when the cache is enabled
+        self.interpretation_cache: Optional[InterpretationCache] = None
+        if config.openai.interpretation_cache_size > 0:
+            self.interpretation_cache = InterpretationCache(config.openai.interpretation_cache_size,
+                                                            config.openai.interpretation_cache_file)
+        # Called with the target of a streamed interpretation as soon as it is known, e.g. to resolve it early
+        self.on_target: Optional[Callable[[SNMPTarget], Awaitable[Any]]] = None
+        self._target_tasks: Set[asyncio.Task] = set()
+
+    def cache_key(self, query: str, include_device: bool = False, label_interfaces: bool = False) -> str:
+        """
+        Build the cache key of a query's response
+
+        The key carries the model and the version of the system prompt, so
+        responses interpreted by another model or prompt are never served.
+
+        Args:
+            query: The natural language query from the user
+            include_device: Whether the response carries the target's vendor and model,
+                so responses with and without it are cached apart
+            label_interfaces: Whether the results carry their interface's ifName and ifAlias,
+                so labeled and unlabeled responses are cached apart
 
-    async def process_query(self, query: str) -> Optional[SNMPQuery]:
+        Returns:
+            Cache key, e.g. query_gpt-4_3f2a9c81d0e4_<hash of the query>
+        """
+        key = f"query_{self.model}_{prompt_version(self.system_prompt)}_{hash(query)}"
+        if include_device:
+            key += "_device"
+        if label_interfaces:
+            key += "_if-names"
+        return key
+
+    def warm_up_interpretations(self) -> Tuple[int, int]:
+        """
+        Load the most recently used interpretations saved by the last run
+
+        Interpretations by another model or system prompt, or that no longer make a
+        valid query, are purged.
+
+        Returns:
+            Number of interpretations loaded and number purged
+        """
+        if self.interpretation_cache is None:
+            return 0, 0
+        return self.interpretation_cache.load(self.model, prompt_version(self.system_prompt),
+                                              config.openai.interpretation_warmup, self._parse_interpretation)
+
+    def _log_llm_io(self, label: str, text: Optional[str]) -> None:
+        """Log a prompt or completion at debug level, if enabled, redacted and size-capped"""
+        if not config.openai.log_io or text is None:
+            return
+        logger.debug(f"LLM {label}: {truncate(redact(text, self.log_redact_patterns), config.openai.log_max_chars)}")
+
+    async def process_query(self, query: str, context: Optional[str] = None,
+                            on_delta: Optional[Callable[[str], None]] = None,
+                            api_key: Optional[APIKeyPolicy] = None) -> Optional[SNMPQuery]:
         """
         Process a natural language query using OpenAI API and convert it to an SNMP query.
 
+        Clearly structured queries ("walk ifTable on 10.0.0.1") are handled by the
+        keyword rules first, unless the interpreter mode is "llm". Registered query
+        transforms are applied to the interpreted query.
+
         Args:
             query: The natural language query from the user
+            context: Conversation context the query may refer to, e.g. the previous results (see context_prompt)
+            on_delta: Called with each piece of the model's answer as it streams in, for display; the
+                answer is then streamed whatever LLM_STREAM_INTERPRETATIONS says. Not called for
+                queries the keyword rules or the interpretation cache answer
+            api_key: Policy of the API key making the request, if any; only its own
+                queries share a batched LLM call
 
         Returns:
             SNMPQuery object containing structured SNMP request parameters
+
+        Raises:
+            ClarificationNeeded: If the query is ambiguous, e.g. names no device
+            QueryRejectedError: If a query transform rejects the interpreted query
+            LLMRateLimited: If the LLM provider rate limits the service
+            LLMResponseError: If the model's answer isn't JSON
         """
-        try:
-            logger.debug(f"Processing query with OpenAI: {query}")
+        snmp_query = await self._interpret_query(query, context, on_delta, api_key)
+        if snmp_query:
+            snmp_query = apply_query_transforms(snmp_query)
+        return snmp_query
+
+    async def interpret(self, query: str, validate: Optional[Callable[[SNMPQuery], Optional[str]]] = None,
+                        context: Optional[str] = None, on_delta: Optional[Callable[[str], None]] = None,
+                        api_key: Optional[APIKeyPolicy] = None
+                        ) -> Tuple[Optional[SNMPQuery], Optional[Dict[str, Any]]]:
+        """
+        Process a query, letting the LLM correct an interpretation that fails validation
 
-            # Create the messages for the OpenAI API
+        With LLM_CORRECT_INTERPRETATIONS enabled, an interpreted query the validator
+        rejects is sent back to the model once, with the validation error, and the
+        corrected query is used if it validates. There is never more than one attempt,
+        and none for SET queries: a write runs as interpreted or not at all.
+
+        Args:
+            query: The natural language query from the user
+            validate: Returns the validation error of a query, or None if it is valid
+            context: Conversation context the query may refer to, if any
+            on_delta: Called with each piece of the model's answer as it streams in (see process_query)
+            api_key: Policy of the API key making the request, if any (see process_query)
+
+        Returns:
+            The query (None if it couldn't be interpreted), and the correction attempt
+            (the validation error, whether the correction validated, and its plan) if one was made
+
+        Raises:
+            ClarificationNeeded: If the query is ambiguous, e.g. names no device
+            QueryRejectedError: If a query transform rejects the interpreted query
+            LLMRateLimited: If the LLM provider rate limits the service
+            LLMResponseError: If the model's answer isn't JSON
+        """
+        snmp_query = await self.process_query(query, context, on_delta=on_delta, api_key=api_key)
+        if not (snmp_query and validate and config.openai.correct_interpretations) or config.interpreter_mode == "rules":
+            return snmp_query, None
+        if snmp_query.operation.effective_command() == "SET" or snmp_query.operation.set_values:
+            return snmp_query, None
+
+        error = validate(snmp_query)
+        if not error:
+            return snmp_query, None
+
+        logger.info(f"Interpreted query is invalid ({error}), asking the model to correct it")
+        corrected = await self.correct_query(query, snmp_query, error, context)
+        remaining_error = validate(corrected) if corrected else "The model did not return a usable correction"
+        correction = {
+            "validation_error": error,
+            "corrected": not remaining_error,
+            "remaining_error": remaining_error,
+            "plan": corrected.plan() if corrected else None,
+        }
+        return (snmp_query if remaining_error else corrected), correction
+
+    async def correct_query(self, query: str, snmp_query: SNMPQuery, error: str,
+                            context: Optional[str] = None) -> Optional[SNMPQuery]:
+        """
+        Ask the LLM to correct its interpretation of a query, given why it was rejected
+
+        Args:
+            query: The natural language query from the user
+            snmp_query: The rejected interpretation
+            error: Why the interpretation was rejected
+            context: Conversation context the query was interpreted with, if any
+
+        Returns:
+            The corrected query with query transforms applied, or None if the model gave no usable correction
+        """
+        try:
             messages = [
                 {"role": "system", "content": self.system_prompt},
-                {"role": "user", "content": f"Convert this SNMP query to a JSON structure: '{query}'"}
+                {"role": "user", "content": interpret_prompt(query, context)},
+                {"role": "assistant", "content": snmp_query.model_dump_json(exclude={"raw_query"})},
+                {"role": "user", "content": f"That structure was rejected: {error}. "
+                                            f"Answer with a corrected JSON structure for the same query."}
             ]
-
-            # Call the OpenAI API with retry logic
-            response = await self._call_openai_with_retry(
-                messages=messages,
-                response_format={"type": "json_object"}
-            )
-
+            response = await self._call_openai_with_retry(messages=messages, response_format={"type": "json_object"})
             if not response:
-                logger.error("Failed to get a response from OpenAI API after retries")
                 return None
+            corrected = self._parse_interpretation(json.loads(response.choices[0].message.content))
+            return apply_query_transforms(corrected)
+        except Exception as e:
+            logger.warning(f"Could not correct the interpreted query: {e}")
+            return None
 
-            # Extract the JSON response
-            response_text = response.choices[0].message.content
-            logger.debug(f"OpenAI response: {response_text}")
+    async def _interpret_query(self, query: str, context: Optional[str] = None,
+                               on_delta: Optional[Callable[[str], None]] = None,
+                               api_key: Optional[APIKeyPolicy] = None) -> Optional[SNMPQuery]:
+        """
+        Interpret a query with the keyword rules and/or the LLM
 
-            # Parse the JSON response
-            try:
-                raw_data = json.loads(response_text)
+        A query with conversation context means something else in another conversation,
+        so it is interpreted on its own, bypassing the interpretation cache and batching.
+        """
+        if config.interpreter_mode in ("hybrid", "rules"):
+            snmp_query = self.keyword_service.process_query(query)
+            if snmp_query or config.interpreter_mode == "rules":
+                return snmp_query
 
-                # Check if response matches expected format
-                if "target" in raw_data and "operation" in raw_data:
-                    # Response is already in the expected format
-                    snmp_query = SNMPQuery.model_validate(raw_data)
-                else:
-                    # Adapt the response to match the expected SNMPQuery model
-                    adapted_data = {
-                        "target": {
-                            "host": raw_data.get("target_ip", ""),
-                            "port": raw_data.get("port", 161),
-                            "timeout": raw_data.get("timeout", 5),
-                            "retries": raw_data.get("retries", 3)
-                        },
-                        "credentials": {
-                            "version": raw_data.get("snmp_version", "2c"),
-                            "community": raw_data.get("community_string", "public")
-                        },
-                        "operation": {
-                            "command": raw_data.get("operation", "GET"),
-                            "oids": [raw_data.get("oid", "1.3.6.1.2.1.1.1.0")]
-                        }
-                    }
-                    logger.debug(f"Adapted data: {json.dumps(adapted_data)}")
-                    snmp_query = SNMPQuery.model_validate(adapted_data)
+        try:
+            logger.debug("Processing query with OpenAI")
+
+            version = prompt_version(self.system_prompt)
+            use_cache = self.interpretation_cache is not None and not context
+            raw_data = cached = self.interpretation_cache.get(query, self.model, version) if use_cache else None
+            if use_cache:
+                LLM_CACHE_LOOKUPS.inc(cache="interpretation", result="miss" if cached is None else "hit")
+            if cached is not None:
+                logger.debug("Using the cached interpretation of the query")
+            else:
+                raw_data = await self._request_interpretation(query, context, on_delta, api_key)
+
+            if raw_data is None:
+                return None
 
+            try:
+                snmp_query = self._parse_interpretation(raw_data)
                 logger.info(f"Successfully processed query into SNMP request")
+                if use_cache and cached is None:
+                    self.interpretation_cache.put(query, self.model, version, raw_data)
                 return snmp_query
-
-            except json.JSONDecodeError as e:
-                logger.error(f"Failed to parse OpenAI response as JSON: {e}")
-                return None
+            except ClarificationNeeded:
+                raise
             except Exception as e:
                 logger.error(f"Failed to validate SNMP query: {e}")
                 return None
 
+        except ClarificationNeeded as e:
+            logger.info(f"Query needs clarification: {e}")
+            raise
+        except (LLMRateLimited, LLMResponseError):
+            raise
         except Exception as e:
             logger.error(f"Error processing query with OpenAI: {e}")
             return None
 
-    async def format_response(self, snmp_response: Dict[str, Any], original_query: str) -> SNMPResponse:
+    async def _request_interpretation(self, query: str, context: Optional[str] = None,
+                                      on_delta: Optional[Callable[[str], None]] = None,
+                                      api_key: Optional[APIKeyPolicy] = None) -> Optional[Dict[str, Any]]:
+        """
+        Ask the LLM to interpret a query, streamed, batched or on its own, timing the call
+
+        A query whose answer is watched with on_delta is always streamed, never batched.
+        Queries are only batched with those of the same API key, so that one key's query
+        can't steer the interpretation of another's in the shared prompt.
+        """
+        started = time.monotonic()
+        outcome = "error"
+        try:
+            if config.openai.stream_interpretations or on_delta:
+                raw_data = await self._stream_interpretation(query, context, on_delta)
+            elif self.batcher and not context:
+                raw_data = await self.batcher.submit(query, group=api_key.identity() if api_key else None)
+            else:
+                raw_data = (await self._complete_interpretations([query], context))[0]
+            if raw_data is not None:
+                outcome = "success"
+            return raw_data
+        except LLMRateLimited:
+            outcome = "rate-limited"
+            raise
+        except LLMResponseError:
+            outcome = "bad-response"
+            raise
+        finally:
+            LLM_INTERPRETATION_DURATION.observe(time.monotonic() - started, provider=config.openai.provider,
+                                                outcome=outcome)
+
+    async def _complete_interpretations(self, queries: List[str], context: Optional[str] = None
+                                        ) -> List[Optional[Dict[str, Any]]]:
+        """
+        Ask the LLM to interpret one or more queries with a single call
+
+        A batch of queries is sent as a numbered list, and the model answers with
+        one JSON structure per query in the same order.
+
+        Args:
+            queries: Natural language queries
+            context: Conversation context of a single query, if any
+
+        Returns:
+            The model's JSON structure for each query, in order, or None for each if the
+            call failed or its answer can't be matched to the queries
+
+        Raises:
+            LLMRateLimited: If the LLM provider rate limits the service
+            LLMResponseError: If the answer to a single query isn't JSON
+        """
+        if len(queries) == 1:
+            prompt = interpret_prompt(queries[0], context)
+        else:
+            numbered = "\n".join(f"{number}. '{query}'" for number, query in enumerate(queries, start=1))
+            prompt = (f"Convert each of these {len(queries)} SNMP queries to a JSON structure. Answer with "
+                      f'{{"results": [...]}} holding one structure per query, in the same order:\n{numbered}')
+
+        # Create the messages for the OpenAI API
+        messages = [
+            {"role": "system", "content": self.system_prompt},
+            {"role": "user", "content": prompt}
+        ]
+
+        # Call the OpenAI API with retry logic
+        response = await self._call_openai_with_retry(
+            messages=messages,
+            response_format={"type": "json_object"},
+            max_tokens=self.max_tokens * len(queries)
+        )
+
+        if not response:
+            logger.error("Failed to get a response from OpenAI API after retries")
+            return [None] * len(queries)
+
+        # Parse the JSON response
+        try:
+            raw_data = json.loads(response.choices[0].message.content)
+        except json.JSONDecodeError as e:
+            logger.error(f"Failed to parse OpenAI response as JSON: {e}")
+            if len(queries) == 1:
+                raise LLMResponseError(f"The model returned malformed JSON ({e})")
+            return [None] * len(queries)
+
+        if len(queries) == 1:
+            return [raw_data]
+
+        results = raw_data.get("results") if isinstance(raw_data, dict) else None
+        if not isinstance(results, list) or len(results) != len(queries):
+            logger.error(f"Batched interpretation doesn't hold one result for each of {len(queries)} queries")
+            return [None] * len(queries)
+        return [result if isinstance(result, dict) else None for result in results]
+
+    async def _stream_interpretation(self, query: str, context: Optional[str] = None,
+                                     on_delta: Optional[Callable[[str], None]] = None) -> Optional[Dict[str, Any]]:
+        """
+        Ask the LLM to interpret a query, parsing its answer as it streams in
+
+        Each top-level member is checked as soon as it is complete, and the stream is
+        abandoned at the first invalid one. Once the target is known, on_target is
+        started alongside the rest of the stream. Each piece of the answer is passed
+        to on_delta, if given, before it is parsed. The interpretation is only returned
+        when the whole JSON object has arrived; a stream that is cancelled or ends
+        early gives nothing to cache.
+
+        Returns:
+            The model's JSON structure for the query, or None if the call failed or the
+            answer was rejected or incomplete
+        """
+        messages = [
+            {"role": "system", "content": self.system_prompt},
+            {"role": "user", "content": interpret_prompt(query, context)}
+        ]
+        stream = await self._call_openai_with_retry(messages=messages, response_format={"type": "json_object"},
+                                                    stream=True)
+        if not stream:
+            logger.error("Failed to get a response from OpenAI API after retries")
+            return None
+
+        interpretation = StreamingInterpretation()
+        chunks = iter(stream)
+        try:
+            while True:
+                # The client is synchronous; waiting for each chunk in a thread lets on_target run meanwhile
+                chunk = await asyncio.to_thread(next, chunks, None)
+                if chunk is None:
+                    break
+                delta = chunk.choices[0].delta.content if chunk.choices else None
+                if delta and on_delta:
+                    on_delta(delta)
+                if delta and "target" in interpretation.feed(delta):
+                    self._start_on_target(interpretation.members["target"])
+            return interpretation.result()
+        except StreamRejected as e:
+            logger.warning(f"Rejected the streamed interpretation: {e}")
+            return None
+        finally:
+            self._log_llm_io("completion", interpretation.text)
+            close = getattr(stream, "close", None)
+            if close:
+                close()
+
+    def _start_on_target(self, target: Any) -> None:
+        """Start on_target for the target of a streamed interpretation, without waiting for it"""
+        if not self.on_target or not isinstance(target, dict):
+            return
+        task = asyncio.ensure_future(self.on_target(SNMPTarget.model_validate(target)))
+        self._target_tasks.add(task)
+        task.add_done_callback(self._target_tasks.discard)
+
+    def _parse_interpretation(self, raw_data: Dict[str, Any]) -> SNMPQuery:
+        """
+        Build the SNMP query from the model's JSON structure for it
+
+        Raises:
+            ClarificationNeeded: If the model asked for clarification, or named no device
+            ValueError: If the structure isn't a valid query
+        """
+        if raw_data.get("needs_clarification"):
+            raise ClarificationNeeded(Clarification(
+                missing=raw_data.get("missing", []),
+                questions=raw_data.get("questions", []),
+                suggestions=raw_data.get("suggestions", [])
+            ))
+
+        # Check if response matches expected format
+        if "target" in raw_data and "operation" in raw_data:
+            # Response is already in the expected format
+            snmp_query = SNMPQuery.model_validate(raw_data)
+        else:
+            # Adapt the response to match the expected SNMPQuery model
+            adapted_data = {
+                "target": {
+                    "host": raw_data.get("target_ip", ""),
+                    "port": raw_data.get("port", 161),
+                    "timeout": raw_data.get("timeout", 5),
+                    "retries": raw_data.get("retries", 3)
+                },
+                "credentials": {
+                    "version": raw_data.get("snmp_version", config.snmp.default_version),
+                    "community": raw_data.get("community_string", "public")
+                },
+                "operation": {
+                    "command": raw_data.get("operation", "GET"),
+                    "oids": [raw_data.get("oid", "1.3.6.1.2.1.1.1.0")]
+                }
+            }
+            logger.debug(f"Adapted data: {json.dumps(adapted_data)}")
+            snmp_query = SNMPQuery.model_validate(adapted_data)
+
+        if not snmp_query.target.host.strip():
+            raise ClarificationNeeded(Clarification(
+                missing=["target.host"],
+                questions=["Which device should be queried?"]
+            ))
+
+        return snmp_query
+
+    async def format_response(self, snmp_response: Dict[str, Any], original_query: str,
+                              empty_reason: Optional[str] = None) -> SNMPResponse:
         """
         Format the SNMP response into a more user-friendly format using OpenAI.
 
         Args:
             snmp_response: The raw SNMP response data
             original_query: The original natural language query
+            empty_reason: Why the response holds no values, if it doesn't
 
         Returns:
             Formatted SNMPResponse object
@@ -110,10 +539,15 @@ class OpenAIService:
         try:
             logger.debug(f"Formatting SNMP response with OpenAI")
 
+            instruction = "Provide a concise summary of this SNMP data."
+            if empty_reason:
+                instruction = (f"The device returned no values because {EMPTY_REASONS.get(empty_reason, empty_reason)}. "
+                               f"Briefly explain to the user why there is nothing to show.")
+
             # Create the messages for the OpenAI API
             messages = [
                 {"role": "system", "content": "You are a helpful assistant that explains SNMP responses in plain language."},
-                {"role": "user", "content": f"Original query: '{original_query}'\nSNMP response: {json.dumps(snmp_response)}\n\nProvide a concise summary of this SNMP data."}
+                {"role": "user", "content": f"Original query: '{original_query}'\nSNMP response: {json.dumps(snmp_response)}\n\n{instruction}"}
             ]
 
             # Call the OpenAI API with retry logic
@@ -124,7 +558,8 @@ class OpenAIService:
                 return SNMPResponse(
                     raw_data=snmp_response,
                     summary="Unable to generate summary due to API error.",
-                    query=original_query
+                    query=original_query,
+                    empty_reason=empty_reason
                 )
 
             # Extract the response
@@ -133,7 +568,8 @@ class OpenAIService:
             return SNMPResponse(
                 raw_data=snmp_response,
                 summary=summary,
-                query=original_query
+                query=original_query,
+                empty_reason=empty_reason
             )
 
         except Exception as e:
@@ -141,22 +577,36 @@ class OpenAIService:
             return SNMPResponse(
                 raw_data=snmp_response,
                 summary="Unable to generate summary due to an unexpected error.",
-                query=original_query
+                query=original_query,
+                empty_reason=empty_reason
             )
 
-    async def _call_openai_with_retry(self, messages: list, response_format=None) -> Optional[ChatCompletion]:
+    async def _call_openai_with_retry(self, messages: list, response_format=None,
+                                      max_tokens: Optional[int] = None, stream: bool = False
+                                      ) -> Optional[ChatCompletion]:
         """
         Call OpenAI API with exponential backoff retry logic
 
         Args:
             messages: The messages to send to the API
             response_format: Optional format specification for the response
+            max_tokens: Completion token limit, if not the configured one
+            stream: Return the stream of completion chunks instead; only starting it is retried
+
+        The request runs in a thread, so cancelling the caller (e.g. the request
+        timeout, or the client disconnecting) stops waiting for it at once.
 
         Returns:
-            ChatCompletion response object or None if all retries fail
+            ChatCompletion response object (or chunk stream) or None if all retries fail
+
+        Raises:
+            LLMRateLimited: If the API still answers 429 after the retries
         """
         retry_count = 0
 
+        for message in messages:
+            self._log_llm_io(f"{message['role']} prompt", message["content"])
+
         while retry_count <= self.max_retries:
             try:
                 # Build kwargs based on whether response_format is provided
@@ -164,20 +614,26 @@ class OpenAIService:
                     "model": self.model,
                     "messages": messages,
                     "temperature": self.temperature,
-                    "max_tokens": self.max_tokens
+                    "max_tokens": max_tokens or self.max_tokens
                 }
 
                 if response_format:
                     kwargs["response_format"] = response_format
+                if stream:
+                    kwargs["stream"] = True
 
-                # Call the OpenAI API - this is synchronous in the new OpenAI Python client
-                return self.client.chat.completions.create(**kwargs)
+                # The OpenAI client is synchronous; waiting for it in a thread keeps the call cancellable
+                response = await asyncio.to_thread(self.client.chat.completions.create, **kwargs)
+                if not stream:
+                    self._log_llm_io("completion", response.choices[0].message.content)
+                return response
 
             except RateLimitError as e:
                 retry_count += 1
                 if retry_count > self.max_retries:
                     logger.error(f"Rate limit exceeded, max retries reached: {e}")
-                    return None
+                    raise LLMRateLimited("The LLM provider is rate limiting requests, try again later",
+                                         retry_after=_retry_after(e))
 
                 # Calculate backoff delay with jitter
                 delay = self.retry_base_delay * (2 ** (retry_count - 1)) + (time.time() % 1)
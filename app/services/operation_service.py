@@ -0,0 +1,146 @@
+import asyncio
+import uuid
+from datetime import datetime, timezone
+from typing import Any, Awaitable, Callable, Dict, List, Optional
+from loguru import logger
+
+from app.core.config import APIKeyPolicy
+
+
+class OperationCancelled(Exception):
+    """Raised when a long-running operation is cancelled through the registry"""
+
+    def __init__(self, operation_id: str):
+        super().__init__(f"Operation {operation_id} was cancelled")
+        self.operation_id = operation_id
+
+
+class DuplicateOperationError(ValueError):
+    """Raised when an operation ID chosen by the client is already in use"""
+
+
+class Operation:
+    """A long-running request (a query or subscription) that can be cancelled by ID"""
+
+    def __init__(self, operation_id: str, kind: str, description: str, owner: Optional[str] = None):
+        self.id = operation_id
+        self.kind = kind
+        self.description = description
+        self.owner = owner  # Name of the API key that started it
+        self.started_at = datetime.now(timezone.utc)
+        self.cancel_requested = False
+        self._task: Optional[asyncio.Task] = None
+
+    async def run(self, awaitable: Awaitable[Any]) -> Any:
+        """
+        Run a step of the operation so that cancelling the operation interrupts it
+
+        Raises:
+            OperationCancelled: If the operation is or gets cancelled
+        """
+        if self.cancel_requested:
+            if asyncio.iscoroutine(awaitable):
+                awaitable.close()
+            raise OperationCancelled(self.id)
+
+        self._task = asyncio.ensure_future(awaitable)
+        try:
+            return await self._task
+        except asyncio.CancelledError:
+            if self.cancel_requested:
+                raise OperationCancelled(self.id) from None
+            raise
+        finally:
+            self._task = None
+
+    def cancel(self) -> None:
+        """Cancel the operation, interrupting the step it is running"""
+        self.cancel_requested = True
+        if self._task:
+            self._task.cancel()
+
+    def describe(self) -> Dict[str, Any]:
+        """Describe the operation for listings"""
+        return {
+            "id": self.id,
+            "kind": self.kind,
+            "description": self.description,
+            "started_at": self.started_at.isoformat(),
+            "cancel_requested": self.cancel_requested,
+        }
+
+
+class OperationRegistry:
+    """Registry of the running long-running operations, by ID"""
+
+    def __init__(self):
+        self.operations: Dict[str, Operation] = {}
+
+    def start(self, kind: str, description: str, operation_id: Optional[str] = None,
+              api_key: Optional[APIKeyPolicy] = None) -> Operation:
+        """
+        Register a new operation
+
+        Args:
+            kind: Kind of operation, e.g. "query" or "subscription"
+            description: What the operation does, e.g. the query text
+            operation_id: ID chosen by the client (a new one is assigned if not given)
+            api_key: API key that started it, the only one that may list or cancel it
+
+        Returns:
+            The registered operation
+        """
+        operation_id = operation_id or uuid.uuid4().hex
+        if operation_id in self.operations:
+            raise DuplicateOperationError(f"Operation {operation_id} is already running")
+
+        operation = Operation(operation_id, kind, description, owner=api_key.name if api_key else None)
+        self.operations[operation_id] = operation
+        logger.debug(f"Started {kind} operation {operation_id}")
+        return operation
+
+    def finish(self, operation: Operation) -> None:
+        """Remove a completed operation"""
+        if self.operations.get(operation.id) is operation:
+            del self.operations[operation.id]
+
+    def cancel(self, operation_id: str, api_key: Optional[APIKeyPolicy] = None) -> bool:
+        """
+        Cancel a running operation
+
+        Returns:
+            True if the operation was running and the key's
+        """
+        operation = self.operations.get(operation_id)
+        if not operation or operation.owner != (api_key.name if api_key else None):
+            return False
+
+        logger.info(f"Cancelling {operation.kind} operation {operation_id}")
+        operation.cancel()
+        return True
+
+    def list(self, api_key: Optional[APIKeyPolicy] = None) -> List[Dict[str, Any]]:
+        """List the running operations the key started"""
+        owner = api_key.name if api_key else None
+        return [operation.describe() for operation in self.operations.values() if operation.owner == owner]
+
+
+async def cancel_on_disconnect(operation: Operation, is_disconnected: Callable[[], Awaitable[bool]],
+                               interval: float) -> None:
+    """
+    Cancel an operation once the client that requested it goes away
+
+    Run as a task alongside the operation, and cancel the task when the operation
+    finishes; the client is checked every interval seconds.
+
+    Args:
+        operation: Operation to cancel
+        is_disconnected: Whether the client has disconnected, e.g. Request.is_disconnected
+        interval: Seconds between checks
+    """
+    while not operation.cancel_requested:
+        if await is_disconnected():
+            logger.info(f"Client disconnected, cancelling {operation.kind} operation {operation.id}")
+            operation.cancel()
+            return
+        await asyncio.sleep(interval)
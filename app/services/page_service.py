@@ -0,0 +1,127 @@
+import secrets
+import time
+from collections import OrderedDict
+from typing import Any, Dict, NamedTuple, Optional, Tuple
+
+from app.core.config import APIKeyPolicy
+from app.utils.etag import compute_etag
+
+
+class StoredResults(NamedTuple):
+    content: Dict[str, Any]  # The whole response, as rendered for the first page
+    query: str  # Natural language query the results answer
+    limit: int  # Page size the results were first requested with
+    api_key: Optional[str]  # Identity (digest) of the API key the results were fetched for
+    expires: float  # time.monotonic() after which the results are dropped
+
+
+def parse_cursor(cursor: str) -> Tuple[str, int]:
+    """
+    Split a page cursor into the token of its result set and the offset of the page
+
+    Raises:
+        ValueError: If the cursor is not one handed out in a response
+    """
+    token, _, offset = cursor.rpartition(":")
+    if not token or not offset.isdigit():
+        raise ValueError(f"Invalid cursor: {cursor}")
+    return token, int(offset)
+
+
+def paginate(content: Dict[str, Any], offset: int, limit: int, token: str) -> Dict[str, Any]:
+    """
+    Cut one page out of a response
+
+    The results are sliced, and the raw data, groups and table rows are cut down to
+    those of the page's results; a row is kept when one of its cells, under its
+    table's row object and with its instance, is on the page. The page member gives the total number of results
+    and the cursor of the next page, if there is one.
+
+    Returns:
+        A copy of the response holding only the page
+    """
+    results = content.get("results") or []
+    page = results[offset:offset + limit]
+    names = {key for result in page for key in (result.get("name"), result.get("oid")) if key}
+
+    paged = {**content, "results": page}
+    paged["raw_data"] = {key: value for key, value in (content.get("raw_data") or {}).items() if key in names}
+    if content.get("groups"):
+        paged["groups"] = {
+            oid: [name for name in group if name in names] for oid, group in content["groups"].items()
+        }
+    if content.get("rows"):
+        entries = {row.get("entry") for row in content["rows"] if row.get("entry")}
+        # The (row object, instance) of each cell on the page: <entry>.<column>.<instance>
+        cells = set()
+        for result in page:
+            for entry in entries:
+                if (result.get("oid") or "").startswith(entry + "."):
+                    _, _, instance = result["oid"][len(entry) + 1:].partition(".")
+                    cells.add((entry, instance))
+        paged["rows"] = [row for row in content["rows"] if (row.get("entry"), row["instance"]) in cells]
+    end = offset + limit
+    paged["page"] = {
+        "total": len(results),
+        "offset": offset,
+        "limit": limit,
+        "next_cursor": f"{token}:{end}" if end < len(results) else None,
+    }
+    return paged
+
+
+class PageStore:
+    """
+    Whole result sets of paginated queries, by token
+
+    The first page of a query stores its whole response here, so later pages are
+    cut from it instead of running the SNMP requests again. Result sets expire
+    after ttl seconds, and at most max_sets are kept; the oldest is dropped beyond that.
+    The same response stored again for the same key (e.g. served from the response
+    cache) gets the token it already has, so repeated first pages don't fill the store.
+    """
+
+    def __init__(self, ttl: int, max_sets: int):
+        self.ttl = ttl
+        self.max_sets = max(1, max_sets)
+        self._sets: "OrderedDict[str, StoredResults]" = OrderedDict()
+        # Digest of a stored response, its query, page size and key -> its token, and back
+        self._tokens: Dict[str, str] = {}
+        self._digests: Dict[str, str] = {}
+
+    def _drop(self, token: str) -> None:
+        del self._sets[token]
+        self._tokens.pop(self._digests.pop(token, None), None)
+
+    def store(self, content: Dict[str, Any], query: str, limit: int,
+              api_key: Optional[APIKeyPolicy] = None, now: Optional[float] = None) -> str:
+        """Store the whole response of a query and return the token of its pages"""
+        now = time.monotonic() if now is None else now
+        identity = api_key.identity() if api_key else None
+        digest = compute_etag(content, query, limit, identity)
+        token = self._tokens.get(digest)
+        if token:
+            if self._sets[token].expires >= now:
+                return token
+            self._drop(token)
+
+        token = secrets.token_urlsafe(16)
+        self._sets[token] = StoredResults(content, query, limit, identity, now + self.ttl)
+        self._tokens[digest], self._digests[token] = token, digest
+        while len(self._sets) > self.max_sets:
+            self._drop(next(iter(self._sets)))
+        return token
+
+    def get(self, token: str, api_key: Optional[APIKeyPolicy] = None,
+            now: Optional[float] = None) -> Optional[StoredResults]:
+        """Get the result set stored under a token, or None if it is unknown, expired or another key's"""
+        now = time.monotonic() if now is None else now
+        stored = self._sets.get(token)
+        if stored is None:
+            return None
+        if stored.expires < now:
+            self._drop(token)
+            return None
+        if stored.api_key != (api_key.identity() if api_key else None):
+            return None
+        return stored
@@ -0,0 +1,85 @@
+import secrets
+import time
+from collections import OrderedDict
+from typing import NamedTuple, Optional
+from loguru import logger
+
+from app.core.config import APIKeyPolicy
+from app.models.query import SNMPQuery
+
+# Credentials left out of the plans handed to clients
+SECRET_FIELDS = ("community", "auth_password", "priv_password")
+
+
+class StoredPlan(NamedTuple):
+    query: SNMPQuery  # As interpreted, with its secrets
+    api_key: Optional[str]  # Identity (digest) of the API key the plan was made for
+    expires: float  # time.monotonic() after which the token is no longer accepted
+
+
+class PlanStore:
+    """
+    Interpreted queries handed out for review, by edit token
+
+    /plan stores a query here and returns it without secrets; /execute takes the
+    token back with the client's edited copy. Tokens expire after ttl seconds, and
+    at most max_plans are kept; the oldest is dropped beyond that.
+    """
+
+    def __init__(self, ttl: int, max_plans: int):
+        self.ttl = ttl
+        self.max_plans = max(1, max_plans)
+        self._plans: "OrderedDict[str, StoredPlan]" = OrderedDict()
+
+    def issue(self, query: SNMPQuery, api_key: Optional[APIKeyPolicy] = None, now: Optional[float] = None) -> str:
+        """Store an interpreted query and return its edit token"""
+        now = time.monotonic() if now is None else now
+        token = secrets.token_urlsafe(16)
+        self._plans[token] = StoredPlan(query.model_copy(deep=True), api_key.identity() if api_key else None,
+                                         now + self.ttl)
+        while len(self._plans) > self.max_plans:
+            self._plans.popitem(last=False)
+        return token
+
+    def get(self, token: str, api_key: Optional[APIKeyPolicy] = None,
+            now: Optional[float] = None) -> Optional[SNMPQuery]:
+        """Get the query stored under a token, or None if it is unknown, expired or another key's"""
+        now = time.monotonic() if now is None else now
+        plan = self._plans.get(token)
+        if plan is None:
+            return None
+        if plan.expires < now:
+            del self._plans[token]
+            return None
+        # Keys may share a name, so they are told apart by their digest
+        if plan.api_key != (api_key.identity() if api_key else None):
+            return None
+        return plan.query
+
+    def apply_edits(self, token: str, edited: SNMPQuery, api_key: Optional[APIKeyPolicy] = None,
+                    now: Optional[float] = None) -> Optional[SNMPQuery]:
+        """
+        Build the query to run from a client's edited copy of a plan
+
+        Secrets the plan was shown without are taken from the interpreted query,
+        unless the edit sets them itself. They are only kept for the interpreted
+        host and SNMP version, so a plan edited to another device doesn't send
+        them there.
+
+        Returns:
+            The edited query, or None if the token is unknown, expired or another key's
+        """
+        original = self.get(token, api_key, now=now)
+        if original is None:
+            return None
+
+        query = edited.model_copy(deep=True)
+        query.raw_query = original.raw_query
+        if (query.target.host, query.credentials.version) == (original.target.host, original.credentials.version):
+            for field in SECRET_FIELDS:
+                if getattr(query.credentials, field) is None:
+                    setattr(query.credentials, field, getattr(original.credentials, field))
+        else:
+            logger.info(f"Plan edited to {query.target.host} (SNMPv{query.credentials.version}); "
+                        f"not reusing the interpreted credentials")
+        return query
@@ -0,0 +1,134 @@
+import asyncio
+import time
+from typing import Dict, Any, Optional
+from loguru import logger
+
+from app.core.config import config
+from app.models.query import SNMPQuery
+from app.services.snmp_service import SNMPService
+from app.utils.cache import get_cache, set_cache
+
+
+class PollTarget:
+    """Polling state for a single target"""
+
+    def __init__(self, query: SNMPQuery, interval: int):
+        self.query = query
+        self.base_interval = interval
+        self.interval = interval
+        self.consecutive_failures = 0
+        self.next_poll = time.time()
+        self.last_error: Optional[str] = None
+
+
+class PollerService:
+    def __init__(self, snmp_service: Optional[SNMPService] = None):
+        """Initialize the background poller"""
+        self.snmp_service = snmp_service or SNMPService()
+        self.targets: Dict[str, PollTarget] = {}  # Polled targets keyed by host
+        self._task: Optional[asyncio.Task] = None
+
+    def add_target(self, query: SNMPQuery, interval: Optional[int] = None) -> None:
+        """Start polling a target with the given query"""
+        interval = interval or config.poller.interval
+        self.targets[query.target.host] = PollTarget(query, interval)
+        logger.info(f"Polling {query.target.host} every {interval}s")
+
+    def remove_target(self, host: str) -> bool:
+        """Stop polling a target"""
+        return self.targets.pop(host, None) is not None
+
+    def get_result(self, host: str) -> Optional[Dict[str, Any]]:
+        """Get the most recent successful poll result for a target"""
+        return get_cache(f"poll_{host}")
+
+    async def poll_target(self, host: str) -> Dict[str, Any]:
+        """
+        Poll a single target and update its adaptive interval
+
+        Args:
+            host: Target IP address or hostname
+
+        Returns:
+            Dictionary containing the SNMP response data
+        """
+        target = self.targets[host]
+        result = await self.snmp_service.execute_query(target.query)
+
+        if "error" in result:
+            self._record_failure(target, result["error"])
+        else:
+            self._record_success(target)
+            set_cache(f"poll_{host}", result)
+
+        target.next_poll = time.time() + target.interval
+        return result
+
+    def _record_failure(self, target: PollTarget, error: str) -> None:
+        """Back off exponentially on consecutive failures, up to the configured cap"""
+        target.consecutive_failures += 1
+        target.last_error = error
+        # Grown from the last interval, not the failure count, so that a target failing for
+        # days stays at the cap instead of overflowing the float power
+        target.interval = min(
+            int(target.interval * config.poller.backoff_factor),
+            max(config.poller.max_interval, target.base_interval)
+        )
+        logger.warning(
+            f"Polling {target.query.target.host} failed {target.consecutive_failures} time(s), "
+            f"next poll in {target.interval}s: {error}"
+        )
+
+    def _record_success(self, target: PollTarget) -> None:
+        """Reset the interval once a target recovers"""
+        if target.consecutive_failures:
+            logger.info(f"Polling {target.query.target.host} recovered, interval reset to {target.base_interval}s")
+        target.consecutive_failures = 0
+        target.last_error = None
+        target.interval = target.base_interval
+
+    async def poll_due(self) -> None:
+        """Poll every target whose next poll time has passed"""
+        now = time.time()
+        due = [host for host, target in self.targets.items() if target.next_poll <= now]
+        await asyncio.gather(*(self.poll_target(host) for host in due))
+
+    async def run(self) -> None:
+        """Poll targets as they become due until cancelled"""
+        while True:
+            try:
+                await self.poll_due()
+            except Exception as e:
+                logger.error(f"Error in poller loop: {e}")
+
+            # Sleep until the next target is due, checking at least once a second
+            next_poll = min((t.next_poll for t in self.targets.values()), default=time.time() + 1)
+            await asyncio.sleep(min(max(next_poll - time.time(), 0), 1))
+
+    def start(self) -> None:
+        """Start the background polling loop"""
+        if not self._task:
+            self._task = asyncio.create_task(self.run())
+
+    async def stop(self) -> None:
+        """Stop the background polling loop"""
+        if self._task:
+            self._task.cancel()
+            try:
+                await self._task
+            except asyncio.CancelledError:
+                pass
+            self._task = None
+
+    def get_intervals(self) -> Dict[str, Dict[str, Any]]:
+        """Get the current adaptive polling state for each target"""
+        return {
+            host: {
+                "interval": target.interval,
+                "base_interval": target.base_interval,
+                "consecutive_failures": target.consecutive_failures,
+                "next_poll_in": max(target.next_poll - time.time(), 0),
+                "last_error": target.last_error
+            }
+            for host, target in self.targets.items()
+        }
@@ -0,0 +1,55 @@
+from typing import Callable, List, Optional
+from loguru import logger
+
+from app.models.query import SNMPQuery
+
+# A transform receives the interpreted query and returns the (possibly modified) query
+QueryTransform = Callable[[SNMPQuery], Optional[SNMPQuery]]
+
+# Registered transforms, run in registration order
+_transforms: List[QueryTransform] = []
+
+
+class QueryRejectedError(Exception):
+    """Raised by a query transform to reject an interpreted query"""
+
+
+def register_query_transform(transform: QueryTransform) -> None:
+    """
+    Register a transform to run on every interpreted query before it is validated and executed.
+
+    Transforms can rewrite the query (e.g. force a community, map a hostname to an IP)
+    and reject it by raising QueryRejectedError or returning None.
+
+    Args:
+        transform: Function taking an SNMPQuery and returning an SNMPQuery
+    """
+    _transforms.append(transform)
+
+
+def clear_query_transforms() -> None:
+    """Remove all registered transforms"""
+    _transforms.clear()
+
+
+def apply_query_transforms(query: SNMPQuery) -> SNMPQuery:
+    """
+    Run the registered transforms over a query, in registration order.
+
+    Args:
+        query: Interpreted SNMP query
+
+    Returns:
+        Transformed query
+
+    Raises:
+        QueryRejectedError: If a transform rejects the query
+    """
+    for transform in _transforms:
+        name = getattr(transform, "__name__", repr(transform))
+        query = transform(query)
+        if query is None:
+            raise QueryRejectedError(f"Query rejected by {name}")
+        logger.debug(f"Applied query transform {name}")
+
+    return query
@@ -0,0 +1,106 @@
+import time
+from collections import OrderedDict
+from typing import List, NamedTuple, Optional, Tuple
+
+from app.core.config import APIKeyPolicy
+from app.models.query import SNMPQuery, SNMPResult
+
+
+class SessionContext(NamedTuple):
+    """What a session's last query returned, for follow-ups such as 'get the third one'"""
+    query: str  # The natural language query
+    host: str
+    port: int
+    results: List[Tuple[Optional[str], Optional[str]]]  # (name, numeric OID) of each result, in order
+    summary: str
+    api_key: Optional[str]  # Name of the API key of the session
+    expires: float  # time.monotonic() after which the session is forgotten
+
+
+def context_prompt(context: SessionContext) -> str:
+    """
+    Describe a session's last result for the interpretation prompt
+
+    Results are numbered from 1 in the order they were returned, so the model can
+    resolve positional references ("the third one", "the last one") to their OIDs.
+    Credentials are never part of the context.
+    """
+    numbered = "\n".join(
+        f"{number}. {name or oid} = {oid or name}" for number, (name, oid) in enumerate(context.results, start=1)
+    )
+    target = context.host if context.port == 161 else f"{context.host}:{context.port}"
+    return (
+        f"Context of this conversation: the previous query was '{context.query}' on {target}. "
+        f"Summary: {context.summary}\n"
+        f"It returned these results, numbered in order:\n{numbered or '(no results)'}\n"
+        f"Resolve references to them by position (e.g. 'the third one', 'the last one', '#2') to the "
+        f"numeric OID of that result, and references to 'it' or 'that device' to {context.host}."
+    )
+
+
+class SessionStore:
+    """
+    Conversational sessions, by the X-Session-ID a client sends with its queries
+
+    Each session keeps what its last query returned, so a follow-up can refer to
+    it by position. Sessions expire ttl seconds after their last query, and at most
+    max_sessions are kept; the least recently used is dropped beyond that. A session
+    belongs to the API key that started it.
+    """
+
+    def __init__(self, ttl: int, max_sessions: int, max_results: int):
+        self.ttl = ttl
+        self.max_sessions = max(1, max_sessions)
+        self.max_results = max(1, max_results)
+        self._sessions: "OrderedDict[str, SessionContext]" = OrderedDict()
+
+    def get(self, session_id: str, api_key: Optional[APIKeyPolicy] = None,
+            now: Optional[float] = None) -> Optional[SessionContext]:
+        """Get a session's context, or None if it is unknown, expired or another key's"""
+        now = time.monotonic() if now is None else now
+        context = self._sessions.get(session_id)
+        if context is None:
+            return None
+        if context.expires < now:
+            del self._sessions[session_id]
+            return None
+        if context.api_key != (api_key.name if api_key else None):
+            return None
+        return context
+
+    def record(self, session_id: str, query: SNMPQuery, results: List[SNMPResult], summary: str,
+               api_key: Optional[APIKeyPolicy] = None, now: Optional[float] = None) -> bool:
+        """
+        Remember what a session's query returned, replacing its previous context
+
+        Only the first max_results results are kept, to bound the prompt.
+
+        Returns:
+            False if the session belongs to another API key, and was left alone
+        """
+        now = time.monotonic() if now is None else now
+        key_name = api_key.name if api_key else None
+        existing = self._sessions.get(session_id)
+        if existing and existing.expires >= now and existing.api_key != key_name:
+            return False
+
+        self._sessions.pop(session_id, None)
+        self._sessions[session_id] = SessionContext(
+            query=query.raw_query or "",
+            host=query.target.host,
+            port=query.target.port,
+            results=[(result.name, result.oid) for result in results[:self.max_results]],
+            summary=summary,
+            api_key=key_name,
+            expires=now + self.ttl,
+        )
+        while len(self._sessions) > self.max_sessions:
+            self._sessions.popitem(last=False)
+        return True
+
+    def forget(self, session_id: str, api_key: Optional[APIKeyPolicy] = None) -> bool:
+        """End a session; True if it existed and was the key's"""
+        if self.get(session_id, api_key) is None:
+            return False
+        del self._sessions[session_id]
+        return True
@@ -0,0 +1,193 @@
+import asyncio
+import hashlib
+import time
+from typing import Any, Awaitable, Callable, Dict, List, Optional
+from loguru import logger
+from puresnmp.exc import Timeout
+
+from app.utils.metrics import registry
+
+# Sockets opened to targets, requests that reused one, and sockets closed, by reason
+SNMP_POOL_CONNECTIONS = registry.counter(
+    "snmp_pool_connections", "SNMP target sockets opened, reused and closed by the connection pool", ("event",)
+)
+
+
+def connection_key(host: str, port: int, *credentials: Any) -> str:
+    """Key of a target's pooled connections, with a digest of the credentials so they aren't kept in the clear"""
+    digest = hashlib.sha256(repr(credentials).encode()).hexdigest()[:16]
+    return f"{host}:{port}/{digest}"
+
+
+class _TargetProtocol(asyncio.DatagramProtocol):
+    """Queues the datagrams and socket errors of a connected UDP socket"""
+
+    def __init__(self):
+        self.received: asyncio.Queue = asyncio.Queue()
+        self.error: Optional[Exception] = None
+        self.closed = False
+
+    def datagram_received(self, data: bytes, address) -> None:
+        self.received.put_nowait(data)
+
+    def error_received(self, exc: Exception) -> None:
+        # E.g. ConnectionRefusedError from an ICMP port unreachable
+        self.error = exc
+        self.received.put_nowait(exc)
+
+    def connection_lost(self, exc: Optional[Exception]) -> None:
+        self.closed = True
+
+
+class PooledConnection:
+    """A UDP socket connected to one target, sending one request at a time"""
+
+    def __init__(self, transport: asyncio.DatagramTransport, protocol: _TargetProtocol):
+        self.transport = transport
+        self.protocol = protocol
+        self.last_used = time.monotonic()
+        # A request answered only after a resend may still get the other answer later,
+        # which the next request would take for its own
+        self.reusable = True
+
+    def healthy(self) -> bool:
+        return self.reusable and self.protocol.error is None and not self.protocol.closed \
+            and not self.transport.is_closing()
+
+    async def send(self, packet: bytes, timeout: float, retries: int) -> bytes:
+        """
+        Send a request and wait for the reply, sending it up to retries times in all
+
+        Raises:
+            Timeout: If no reply came
+            ConnectionRefusedError: If the target's port is closed
+        """
+        # Anything already received is a late reply to an earlier request
+        while not self.protocol.received.empty():
+            self.protocol.received.get_nowait()
+
+        attempts = max(1, retries)
+        for attempt in range(attempts):
+            self.transport.sendto(packet)
+            try:
+                reply = await asyncio.wait_for(self.protocol.received.get(), timeout)
+            except asyncio.TimeoutError:
+                continue
+            if isinstance(reply, Exception):
+                raise reply
+            if attempt:
+                self.reusable = False
+            return reply
+        raise Timeout(f"No response after {attempts} attempts of {timeout}s")
+
+    def close(self) -> None:
+        self.transport.close()
+
+
+class SNMPConnectionPool:
+    """
+    Connected UDP sockets to SNMP targets, kept open between requests
+
+    puresnmp opens a new socket for every request by default; clients created with
+    sender() send through this pool instead, so a walk or repeated GETs to a target
+    reuse one socket. Connections are pooled by target and credentials, and each
+    sends one request at a time. A connection is closed rather than reused when its
+    request failed, was cancelled or needed a resend, when the socket reported an
+    error, and once it has been idle for idle_timeout seconds. At most max_idle
+    connections are kept per key.
+    """
+
+    def __init__(self, idle_timeout: float, max_idle: int):
+        self.idle_timeout = idle_timeout
+        self.max_idle = max(1, max_idle)
+        self._idle: Dict[str, List[PooledConnection]] = {}
+        self.opened = 0
+        self.reused = 0
+
+    async def _open(self, host: str, port: int) -> PooledConnection:
+        loop = asyncio.get_running_loop()
+        transport, protocol = await loop.create_datagram_endpoint(_TargetProtocol, remote_addr=(host, port))
+        self.opened += 1
+        SNMP_POOL_CONNECTIONS.inc(event="opened")
+        return PooledConnection(transport, protocol)
+
+    def _discard(self, connection: PooledConnection, reason: str) -> None:
+        connection.close()
+        SNMP_POOL_CONNECTIONS.inc(event=reason)
+
+    async def acquire(self, key: str, host: str, port: int) -> PooledConnection:
+        """Take an idle healthy connection to the target, or open a new one"""
+        self.evict_idle()
+        idle = self._idle.get(key, [])
+        while idle:
+            connection = idle.pop()
+            if connection.healthy():
+                self.reused += 1
+                SNMP_POOL_CONNECTIONS.inc(event="reused")
+                return connection
+            self._discard(connection, "unhealthy")
+        return await self._open(host, port)
+
+    def release(self, key: str, connection: PooledConnection) -> None:
+        """Return a connection after its request, keeping it if it can be reused"""
+        idle = self._idle.setdefault(key, [])
+        if not connection.healthy():
+            self._discard(connection, "unhealthy")
+        elif len(idle) >= self.max_idle:
+            self._discard(connection, "surplus")
+        else:
+            connection.last_used = time.monotonic()
+            idle.append(connection)
+
+    def evict_idle(self, now: Optional[float] = None) -> int:
+        """Close the connections idle for longer than idle_timeout; returns how many were closed"""
+        now = time.monotonic() if now is None else now
+        evicted = 0
+        for key in list(self._idle):
+            kept = []
+            for connection in self._idle[key]:
+                if now - connection.last_used > self.idle_timeout:
+                    self._discard(connection, "evicted")
+                    evicted += 1
+                else:
+                    kept.append(connection)
+            if kept:
+                self._idle[key] = kept
+            else:
+                del self._idle[key]
+        if evicted:
+            logger.debug(f"Closed {evicted} idle SNMP connections")
+        return evicted
+
+    def sender(self, key: str, host: str, port: int) -> Callable[..., Awaitable[bytes]]:
+        """
+        A puresnmp sender that sends through the pool's connections to the target
+
+        puresnmp passes the endpoint too; the target it was created for is used instead.
+        """
+        async def send(endpoint: Any, packet: bytes, timeout: float = 6, retries: int = 10, **kwargs) -> bytes:
+            connection = await self.acquire(key, host, port)
+            try:
+                reply = await connection.send(packet, timeout, retries)
+            except BaseException:
+                # Also on cancellation: the reply may still come, and must not answer the next request
+                self._discard(connection, "failed")
+                raise
+            self.release(key, connection)
+            return reply
+
+        return send
+
+    def close(self) -> None:
+        """Close every idle connection"""
+        for connections in self._idle.values():
+            for connection in connections:
+                connection.close()
+        self._idle.clear()
+
+    def describe(self) -> Dict[str, int]:
+        return {
+            "idle": sum(len(connections) for connections in self._idle.values()),
+            "opened": self.opened,
+            "reused": self.reused,
+        }
@@ -1,118 +1,1241 @@
 import asyncio
-from typing import Dict, Any, List, Optional, Tuple
+import ipaddress
+import socket
+from typing import Callable, Dict, Any, List, Optional, Tuple
 from loguru import logger
-from puresnmp import Client, V1, V2C, ObjectIdentifier
-from puresnmp.exc import SnmpError, Timeout
+import time
+from puresnmp import Client, V1, V2C, V3, Auth, Priv, ObjectIdentifier
+from puresnmp.exc import ErrorResponse, SnmpError, Timeout, TooBig
+from puresnmp.types import Counter, Gauge, IpAddress, TimeTicks
+from x690.types import Integer, OctetString
 
-from app.models.query import SNMPQuery, SNMPTarget, SNMPCredentials, SNMPOperation
-from app.core.config import config
-from app.services.mib_service import MIBService
+from app.models.query import (
+    SNMPQuery, SNMPTarget, SNMPCredentials, SNMPOperation, SNMPResult, TableRow, TargetResult,
+    EMPTY_NO_OBJECTS, EMPTY_ALL_FILTERED, EMPTY_END_OF_MIB_VIEW
+)
+from app.core.config import config, APIKeyPolicy, TargetCredentials
+from app.services.mib_service import MIBService, is_numeric_oid, normalize_oid, oid_length_error, oid_syntax_error
+from app.services.snmp_pool import SNMPConnectionPool, connection_key
+from app.services.write_dedup import WriteDeduplicator, prior_write_age, write_key
+from app.utils.cache import get_cache, set_cache, delete_cache
+from app.utils.etag import compute_etag
+from app.utils.decoders import decode_value
+from app.utils.enterprises import get_enterprise
+from app.utils.inet_address import decode_inet_address
+from app.utils.metrics import registry, timeout_buckets
+from app.utils.redaction import register_secret, scrub_error_fields
+from app.utils.timestamps import decode_date_and_time, format_timestamp
+from app.utils.target_stats import TargetStats
+from app.utils.targets import format_target, is_address, match_target, target_class
+from app.utils.timing import StageTimer
+
+
+TIMEOUT_ERROR = "SNMP request timed out. Verify the device is reachable, or raise the target's timeout and retries"
+CONNECTION_REFUSED_ERROR = "Connection refused. Verify the device is reachable and SNMP is enabled"
+COMMUNITIES_FAILED_ERROR = "No response with any of the configured community strings. Verify the communities and that the device is reachable"
+
+AUTH_FAILED_ERROR = "SNMP authentication failed: {reason}. Verify the credentials configured for the target"
+
+# Error code of failures caused by the credentials rather than the device or network
+SNMP_AUTH_FAILED = "SNMP_AUTH_FAILED"
+
+# Error code of a SET the agent refused; set_error in the response tells which varbind and why
+SNMP_SET_FAILED = "SNMP_SET_FAILED"
+
+# Error codes of the other failures: the device didn't answer in time, couldn't be reached
+# (doesn't resolve, or refused the connection), or answered with an error; and of queries
+# that can't be sent as they are
+SNMP_TIMEOUT = "SNMP_TIMEOUT"
+SNMP_UNREACHABLE = "SNMP_UNREACHABLE"
+SNMP_ERROR = "SNMP_ERROR"
+INVALID_QUERY = "INVALID_QUERY"
+
+# SNMP error-status values (RFC 3416)
+ERROR_STATUS_NAMES = (
+    "noError", "tooBig", "noSuchName", "badValue", "readOnly", "genErr", "noAccess", "wrongType",
+    "wrongLength", "wrongEncoding", "wrongValue", "noCreation", "inconsistentValue", "resourceUnavailable",
+    "commitFailed", "undoFailed", "authorizationError", "notWritable", "inconsistentName",
+)
+
+# Error code of requests rejected by the maintenance mode
+SERVICE_READ_ONLY = "SERVICE_READ_ONLY"
+
+# Maintenance modes: nothing rejected, SETs rejected, or every SNMP request rejected
+MAINTENANCE_MODES = ("off", "read-only", "all")
+
+# usmStats counters an SNMPv3 agent reports a rejected request with (RFC 3414), and what they mean
+USM_STATS_FAILURES = {
+    "1.3.6.1.6.3.15.1.1.1": ("usmStatsUnsupportedSecLevels", "the security level is not supported for this user"),
+    "1.3.6.1.6.3.15.1.1.3": ("usmStatsUnknownUserNames", "unknown user name"),
+    "1.3.6.1.6.3.15.1.1.5": ("usmStatsWrongDigests", "wrong authentication password or protocol"),
+    "1.3.6.1.6.3.15.1.1.6": ("usmStatsDecryptionErrors", "wrong privacy password or protocol"),
+}
+
+# SNMPv3 protocol names as puresnmp expects them
+V3_AUTH_PROTOCOLS = {"MD5": "md5", "SHA": "sha1", "SHA1": "sha1"}
+V3_PRIV_PROTOCOLS = {"DES": "des", "AES": "aes", "AES128": "aes"}
+V3_SECURITY_LEVELS = ("noAuthNoPriv", "authNoPriv", "authPriv")
+# RFC 3414 keys can't be localized from shorter passphrases
+V3_MIN_PASSPHRASE_LENGTH = 8
+
+# WALKTABLE walks tables like WALK, and puts the results back into rows, see SNMPService.table_rows
+SUPPORTED_COMMANDS = ("GET", "GETNEXT", "WALK", "BULK", "SET", "WALKTABLE")
+
+# SNMP requests sent, and how long they took, by command, outcome and target class (SNMP_TARGET_CLASSES)
+SNMP_OPERATIONS = registry.counter(
+    "snmp_operations", "SNMP operations sent to targets", ("operation", "outcome", "target_class")
+)
+SNMP_OPERATION_DURATION = registry.histogram(
+    "snmp_operation_duration_seconds", "Duration of SNMP operations, retries included",
+    ("operation", "outcome", "target_class"),
+    # Up to a request sent with every retry and answered by none
+    buckets=timeout_buckets(config.snmp.timeout * max(1, config.snmp.retries))
+)
+# Failed SNMP operations by what went wrong, see error_type()
+SNMP_ERRORS = registry.counter(
+    "snmp_errors", "Failed SNMP operations by type of error", ("operation", "error", "target_class")
+)
+
+# Failures worth retrying: the device may answer on a later attempt
+RETRYABLE_ERRORS = (TIMEOUT_ERROR, CONNECTION_REFUSED_ERROR)
+
+
+NO_SUCH_VALUES = ("No such object", "No such instance")
+
+
+def operation_outcome(result: Dict[str, Any]) -> str:
+    """Classify the result of an SNMP operation for metrics: success, timeout, auth-fail or other"""
+    error = result.get("error")
+    if not error:
+        return "success"
+    if result.get("error_code") == SNMP_AUTH_FAILED:
+        return "auth-fail"
+    if error in (TIMEOUT_ERROR, COMMUNITIES_FAILED_ERROR) or error.startswith("Overall walk deadline"):
+        return "timeout"
+    return "other"
+
+
+def error_type(result: Dict[str, Any]) -> Optional[str]:
+    """
+    Classify the error of an SNMP operation for metrics, or None if it succeeded
+
+    One of timeout, walk-deadline, auth-fail, refused, set-failed, snmp-error or other.
+    """
+    error = result.get("error")
+    if not error:
+        return None
+    if result.get("error_code") == SNMP_AUTH_FAILED:
+        return "auth-fail"
+    if result.get("error_code") == SNMP_SET_FAILED:
+        return "set-failed"
+    if error in (TIMEOUT_ERROR, COMMUNITIES_FAILED_ERROR):
+        return "timeout"
+    if error.startswith("Overall walk deadline"):
+        return "walk-deadline"
+    if error == CONNECTION_REFUSED_ERROR:
+        return "refused"
+    if error.startswith("SNMP error:"):
+        return "snmp-error"
+    return "other"
+
+
+def fast_fail(query: SNMPQuery) -> SNMPQuery:
+    """
+    Switch a query to fast-fail mode: each request is sent once, with the short SNMP_FAST_TIMEOUT
+
+    For liveness checks and dashboards that prefer a quick failure to a reliable answer.
+    """
+    query.target.timeout = config.snmp.fast_timeout
+    query.target.retries = 0
+    return query
+
+
+class WalkDeadlineExceeded(Exception):
+    """Raised when a walk runs past its overall deadline"""
+
+
+class SNMPAuthenticationFailed(SnmpError):
+    """Raised when the agent rejects a request's credentials"""
+
+
+class SNMPSetFailed(SnmpError):
+    """Raised when the agent answers a SET with an error-status, naming the varbind it refused"""
+
+    def __init__(self, error_status: int, error_index: int, oid: Optional[str], name: Optional[str] = None):
+        self.error_status = error_status
+        self.error_index = error_index  # 1-based position of the refused varbind, 0 if the agent named none
+        self.oid = oid
+        self.name = name
+        status = ERROR_STATUS_NAMES[error_status] if 0 <= error_status < len(ERROR_STATUS_NAMES) else "unknown"
+        self.status_name = status
+        varbind = f" at varbind {error_index} ({name or oid})" if error_index else ""
+        super().__init__(f"SNMP SET failed: {status} (error-status {error_status}){varbind}")
+
+    def describe(self) -> Dict[str, Any]:
+        return {
+            "error_status": self.error_status,
+            "error_status_name": self.status_name,
+            "error_index": self.error_index,
+            "oid": self.oid,
+            "name": self.name,
+        }
+
+
+class SNMPData(dict):
+    """SNMP response data collected with another command than the one requested"""
+
+    def __init__(self, data: Optional[Dict[str, Any]] = None, fallback: Optional[str] = None):
+        super().__init__(data or {})
+        self.fallback = fallback
+
+
+class EmptyResult(dict):
+    """SNMP response data holding no values, with the reason why"""
+
+    def __init__(self, data: Optional[Dict[str, Any]] = None, reason: str = EMPTY_NO_OBJECTS):
+        super().__init__(data or {})
+        self.reason = reason
+
+
+def empty_reason(data: Dict[str, Any]) -> Optional[str]:
+    """Get why SNMP response data holds no values, or None if it has values or an error"""
+    if isinstance(data, EmptyResult):
+        return data.reason
+    return None if data else EMPTY_NO_OBJECTS
+
+
+def used_fallback(data: Dict[str, Any]) -> Optional[str]:
+    """Get the command SNMP response data was collected with instead of the requested one, if any"""
+    return data.fallback if isinstance(data, SNMPData) else None
+
+
+# Error text from agents (or the client library) that can't handle GETBULK
+_BULK_UNSUPPORTED_HINTS = ("generr", "bulk", "not supported", "unsupported", "notimplemented")
+
+
+def _bulk_unsupported(error: Exception) -> bool:
+    """Check whether a BULK error means the agent doesn't support GETBULK, so a WALK may work"""
+    if isinstance(error, (Timeout, TooBig)):
+        return False
+    text = f"{type(error).__name__} {error}".lower()
+    return any(hint in text for hint in _BULK_UNSUPPORTED_HINTS)
+
+
+def auth_failure(error: Exception) -> Optional[str]:
+    """
+    Tell whether an SNMP error means the agent rejected the credentials
+
+    SNMPv3 agents answer bad credentials with a report naming a usmStats counter;
+    an authorizationError response means the credentials aren't allowed the request.
+
+    Returns:
+        Why authentication failed, or None for other errors
+    """
+    if isinstance(error, SNMPAuthenticationFailed):
+        return str(error)
+
+    message = str(error)
+    for oid, (name, reason) in USM_STATS_FAILURES.items():
+        if oid in message or name.lower() in message.lower():
+            return reason
+    if getattr(error, "error_status", None) == 16 or "authorizationerror" in message.lower():
+        return "the agent refused access for these credentials (authorizationError)"
+    return None
+
+
+def _set_value(value_type: str, value: Any) -> Any:
+    """Wrap a checked SET value in the x690 type it is sent as"""
+    if value_type == "OctetString":
+        return OctetString(value.encode())
+    if value_type == "ObjectIdentifier":
+        return ObjectIdentifier(value)
+    if value_type == "IpAddress":
+        return IpAddress(ipaddress.IPv4Address(value))
+    if value_type == "Counter32":
+        return Counter(value)
+    if value_type in ("Gauge32", "Unsigned32"):
+        return Gauge(value)
+    if value_type == "TimeTicks":
+        return TimeTicks(value)
+    return Integer(value)
+
+
+def _raise_if_auth_failure(error: Exception) -> None:
+    """Raise an authentication failure instead of reporting it as a per-OID error"""
+    reason = auth_failure(error)
+    if reason:
+        raise SNMPAuthenticationFailed(reason) from error
+
+
+def _is_end_of_mib_view(value: Any) -> bool:
+    """Check whether a GETNEXT value or error is endOfMibView"""
+    if "endofmibview" in type(value).__name__.lower():
+        return True
+    return isinstance(value, Exception) and "end of mib" in str(value).lower()
+
+
+def _oid_key(oid: str) -> Tuple[int, ...]:
+    """Sort key placing numeric OIDs in lexicographic (MIB) order"""
+    return tuple(int(part) for part in str(oid).strip(".").split("."))
+
+
+def _in_subtree(oid: str, root: str) -> bool:
+    """Check whether an OID lies under (or is) a subtree root"""
+    oid, root = str(oid).strip("."), str(root).strip(".")
+    return oid == root or oid.startswith(root + ".")
+
+
+def _matches_oid_pattern(oid: str, pattern: str) -> bool:
+    """Check whether an OID lies under a subtree pattern, where "*" matches any one sub-identifier"""
+    parts, pattern_parts = str(oid).strip(".").split("."), str(pattern).strip(".").split(".")
+    if len(parts) < len(pattern_parts):
+        return False
+    return all(expected in ("*", part) for part, expected in zip(parts, pattern_parts))
+
+
+def _collapse_subtrees(oids: List[str]) -> List[str]:
+    """Drop OIDs that repeat or lie under another requested subtree, keeping the order of the rest"""
+    unique = list(dict.fromkeys(oids))
+    return [oid for oid in unique if not any(other != oid and _in_subtree(oid, other) for other in unique)]
+
+
+def build_oid(column: str, index: str) -> str:
+    """Build the OID of a table cell from its column OID and row index"""
+    return f"{str(column).strip('.')}.{str(index).strip('.')}"
+
+
+def _row_index(oid: str, column: str) -> Optional[int]:
+    """Get the first index sub-identifier of a cell under a column, or None if it isn't under it"""
+    oid, column = str(oid).strip("."), str(column).strip(".")
+    if not oid.startswith(column + "."):
+        return None
+    return int(oid[len(column) + 1:].split(".", 1)[0])
+
+
+def resolved_addresses(target: SNMPTarget) -> List[str]:
+    """The addresses a target's host name last resolved to (see SNMPService.resolve_target), if known"""
+    resolved = get_cache(f"resolve_{format_target(target.host, target.port)}")
+    return (resolved or {}).get("addresses") or []
+
+
+def credentials_for(target: SNMPTarget) -> Optional[TargetCredentials]:
+    """
+    Get the credentials configured for a target in SNMP_TARGET_CREDENTIALS: those of its
+    host and port, its host, the smallest network containing it, or else the default ones
+
+    A host name is placed in networks by the addresses it resolved to; SNMPService resolves
+    it before applying credentials, so it doesn't fall back to the default ones unresolved.
+
+    Returns:
+        The credentials, or None if none apply to the target
+    """
+    addresses = [] if is_address(target.host) else resolved_addresses(target)
+    return match_target(target.host, target.port, config.snmp.target_credentials, addresses)
+
+
+def _configured_communities(target: SNMPTarget) -> List[str]:
+    """
+    Communities configured for a target in SNMP_TARGET_COMMUNITIES, for its port or any port,
+    or else in its SNMP_TARGET_CREDENTIALS
+    """
+    communities = config.snmp.target_communities.get(format_target(target.host, target.port)) or \
+        config.snmp.target_communities.get(target.host)
+    if communities:
+        return communities
+    credentials = credentials_for(target)
+    return credentials.communities if credentials else []
+
+
+def with_target_credentials(query: SNMPQuery) -> SNMPQuery:
+    """
+    Apply the credentials configured for a query's target (see credentials_for) to the query
+
+    Queries naming a community other than the default one, or an SNMPv3 user, keep their
+    own credentials. Otherwise the configured version applies, if set, and so does the
+    configured SNMPv3 user; the configured communities are tried by _candidate_communities.
+    """
+    credentials = query.credentials
+    if credentials.username or (credentials.community or config.snmp.default_community) != config.snmp.default_community:
+        return query
+    configured = credentials_for(query.target)
+    if configured is None:
+        return query
+    update: Dict[str, Any] = {"version": configured.version} if configured.version else {}
+    if configured.username:
+        update.update(configured.model_dump(include={
+            "username", "security_level", "auth_protocol", "auth_password", "priv_protocol", "priv_password"
+        }))
+    if not update:
+        return query
+    return query.model_copy(update={"credentials": credentials.model_copy(update=update)})
+
+
+def _abbreviate(oid: str, arcs: int = 12) -> str:
+    """Shorten a long OID for messages, e.g. 1.3.6.1.4.1.9.9.9.9.9.9... (300 sub-identifiers)"""
+    parts = oid.strip(".").split(".")
+    if len(parts) <= arcs:
+        return oid
+    return f"{'.'.join(parts[:arcs])}... ({len(parts)} sub-identifiers)"
+
+
+def v3_defaults(credentials: SNMPCredentials) -> SNMPCredentials:
+    """
+    Fill in the SNMP_V3_* user for v3 (or version "auto") credentials without a username
+
+    Credentials with a username of their own are used as they are.
+    """
+    if credentials.version not in ("3", "auto") or credentials.username or not config.snmp.v3_security_name:
+        return credentials
+    return credentials.model_copy(update={
+        "username": config.snmp.v3_security_name,
+        "security_level": config.snmp.v3_security_level or None,
+        "auth_protocol": config.snmp.v3_auth_protocol,
+        "auth_password": config.snmp.v3_auth_passphrase or None,
+        "priv_protocol": config.snmp.v3_priv_protocol,
+        "priv_password": config.snmp.v3_priv_passphrase or None,
+    })
+
+
+def v3_security_level(credentials: SNMPCredentials) -> str:
+    """The security level of SNMPv3 credentials, as given or as their passwords allow"""
+    if credentials.security_level:
+        return credentials.security_level
+    if credentials.priv_password:
+        return "authPriv"
+    return "authNoPriv" if credentials.auth_password else "noAuthNoPriv"
+
+
+def v3_credentials_error(credentials: SNMPCredentials) -> Optional[str]:
+    """
+    Check that SNMPv3 credentials make a usable combination, before anything is sent
+
+    The security level must match the passphrases given: authNoPriv needs an
+    authentication passphrase, authPriv a privacy one too, and passphrases a level
+    doesn't use are refused rather than silently ignored.
+
+    Returns:
+        Why the credentials can't be used, or None if they can
+    """
+    if not credentials.username:
+        return "SNMPv3 requires a username"
+    level = v3_security_level(credentials)
+    if level not in V3_SECURITY_LEVELS:
+        return f"Unsupported SNMPv3 security level: {level} (use {', '.join(V3_SECURITY_LEVELS)})"
+
+    uses_auth, uses_priv = level != "noAuthNoPriv", level == "authPriv"
+    for article, name, password, used, protocol, protocols in (
+        ("an", "authentication", credentials.auth_password, uses_auth, credentials.auth_protocol or "SHA",
+         V3_AUTH_PROTOCOLS),
+        ("a", "privacy", credentials.priv_password, uses_priv, credentials.priv_protocol or "AES", V3_PRIV_PROTOCOLS),
+    ):
+        if used and not password:
+            return f"SNMPv3 security level {level} requires {article} {name} passphrase"
+        if password and not used:
+            return f"SNMPv3 security level {level} doesn't use {article} {name} passphrase, but one is set"
+        if used and protocol.upper() not in protocols:
+            return f"Unsupported SNMPv3 {name} protocol: {protocol} (use {', '.join(protocols)})"
+        if used and len(password) < V3_MIN_PASSPHRASE_LENGTH:
+            return f"SNMPv3 {name} passphrase must be at least {V3_MIN_PASSPHRASE_LENGTH} characters"
+    return None
+
+
+def _mask_secret(value: Optional[str]) -> Optional[str]:
+    """Mask a credential for display"""
+    return "****" if value else None
 
 
 class SNMPService:
     def __init__(self, mib_service: Optional[MIBService] = None):
         self.mib_service = mib_service or MIBService()
+        self._target_limits: Dict[str, asyncio.Semaphore] = {}
+        # host:port -> the configured community that last got a response
+        self._working_communities: Dict[str, str] = {}
+        self.target_stats = TargetStats(
+            config.snmp.stats_window, config.snmp.stats_max_targets, config.snmp.stats_max_samples
+        )
+        # Recent SETs, so an identical one within SNMP_SET_DEDUP_WINDOW seconds isn't sent again
+        self.write_deduplicator = WriteDeduplicator(config.snmp.set_dedup_window)
+        # Sockets to targets reused between requests, unless SNMP_POOL_IDLE_TIMEOUT is 0
+        self.pool: Optional[SNMPConnectionPool] = None
+        if config.snmp.pool_idle_timeout > 0:
+            self.pool = SNMPConnectionPool(config.snmp.pool_idle_timeout, config.snmp.max_connections_per_target)
+        self.maintenance_mode = "off"
+        try:
+            self.set_maintenance_mode(config.maintenance_mode)
+        except ValueError as e:
+            logger.warning(f"{e}; maintenance mode is off")
+
+        if config.snmp.v3_security_name:
+            error = v3_credentials_error(v3_defaults(SNMPCredentials(version="3")))
+            if error:
+                logger.warning(f"SNMPv3 queries without their own user will fail, check SNMP_V3_*: {error}")
+
+    def set_maintenance_mode(self, mode: str) -> None:
+        """Switch the maintenance mode, which applies to the next requests"""
+        if mode not in MAINTENANCE_MODES:
+            raise ValueError(f"Unknown maintenance mode {mode}, use one of {', '.join(MAINTENANCE_MODES)}")
+        if mode != self.maintenance_mode:
+            logger.warning(f"Maintenance mode switched from {self.maintenance_mode} to {mode}")
+        self.maintenance_mode = mode
 
-    async def execute_query(self, query: SNMPQuery) -> Dict[str, Any]:
+    def maintenance_error(self, command: str) -> Optional[str]:
+        """Get why the maintenance mode rejects a command, or None if it is allowed"""
+        if self.maintenance_mode == "all":
+            return "The service is in maintenance mode; SNMP requests are disabled"
+        if self.maintenance_mode == "read-only" and command == "SET":
+            return "The service is in read-only maintenance mode; SNMP SET is disabled"
+        return None
+
+    def _target_limit(self, host: str) -> asyncio.Semaphore:
+        """Get the semaphore bounding concurrent requests to a target"""
+        if host not in self._target_limits:
+            self._target_limits[host] = asyncio.Semaphore(config.snmp.max_connections_per_target)
+        return self._target_limits[host]
+
+    def _candidate_communities(self, query: SNMPQuery) -> List[str]:
+        """
+        Get the community strings to try for a query, in order
+
+        Communities configured for the target (SNMP_TARGET_COMMUNITIES) are used unless the
+        query names a non-default community, starting with the one that last worked.
+        """
+        community = query.credentials.community or config.snmp.default_community
+        if query.credentials.version == "3":
+            # v3 authenticates with the user; there is only one client to try
+            return [community]
+        configured = _configured_communities(query.target)
+        if not configured or community != config.snmp.default_community:
+            return [community]
+
+        working = self._working_communities.get(format_target(query.target.host, query.target.port))
+        if working in configured:
+            return [working] + [candidate for candidate in configured if candidate != working]
+        return list(configured)
+
+    def _create_client(self, query: SNMPQuery, community: str) -> Client:
+        """Create an SNMP client for a query's target with the given community (ignored for v3)"""
+        if query.credentials.version == "1":
+            credentials = V1(community)
+        elif query.credentials.version == "2c":
+            credentials = V2C(community)
+        elif query.credentials.version == "3":
+            credentials = self._v3_credentials(query.credentials)
+        else:
+            raise ValueError("Only SNMP versions 1, 2c and 3 are supported")
+
+        if self.pool:
+            if query.credentials.version == "3":
+                identity = v3_defaults(query.credentials).model_dump(exclude={"community"})
+            else:
+                identity = {"version": query.credentials.version, "community": community}
+            key = connection_key(query.target.host, query.target.port, sorted(identity.items()))
+            sender = self.pool.sender(key, query.target.host, query.target.port)
+            client = Client(query.target.host, credentials, port=query.target.port, sender=sender)
+        else:
+            client = Client(query.target.host, credentials, port=query.target.port)
+        # puresnmp's retries count the times a request is sent, so 0 would send nothing
+        client.config.timeout = query.target.timeout
+        client.config.retries = query.target.retries + 1
+        return client
+
+    def _v3_credentials(self, credentials: SNMPCredentials) -> V3:
+        """
+        Build SNMPv3 USM credentials, with the SNMP_V3_* user if the credentials have none
+
+        Raises:
+            ValueError: If the security level, protocols and passphrases don't make a usable combination
+        """
+        credentials = v3_defaults(credentials)
+        error = v3_credentials_error(credentials)
+        if error:
+            raise ValueError(error)
+
+        auth = priv = None
+        level = v3_security_level(credentials)
+        if level != "noAuthNoPriv":
+            auth = Auth(credentials.auth_password.encode(), V3_AUTH_PROTOCOLS[(credentials.auth_protocol or "SHA").upper()])
+        if level == "authPriv":
+            priv = Priv(credentials.priv_password.encode(), V3_PRIV_PROTOCOLS[(credentials.priv_protocol or "AES").upper()])
+        return V3(credentials.username, auth=auth, priv=priv)
+
+    async def execute_query(self, query: SNMPQuery, api_key: Optional[APIKeyPolicy] = None,
+                            timer: Optional[StageTimer] = None,
+                            effective: Optional[Dict[str, Any]] = None,
+                            on_rows: Optional[Callable[[Dict[str, Any]], None]] = None) -> Dict[str, Any]:
         """
         Execute an SNMP query based on the structured query object
 
+        The query's community string and passphrases are registered as secrets, and
+        scrubbed from the errors in the response and from log messages. A SET identical
+        to one made by the same API key within SNMP_SET_DEDUP_WINDOW seconds (to the same
+        objects, however they are named) isn't sent again; the earlier SET's result is
+        returned, see prior_write_age. A SET that failed is never repeated this way.
+
         Args:
             query: Structured SNMP query object
+            api_key: Policy of the API key making the request, if any
+            timer: Timer to record the validation, connect and snmp stages in, if any
+            effective: Dictionary to fill with the SNMP parameters actually used, if any
+            on_rows: Called with the formatted values of each response of a WALK or BULK
+                as it arrives, before the query completes, if given
 
         Returns:
             Dictionary containing the SNMP response data
         """
+        for secret in (query.credentials.community, query.credentials.auth_password, query.credentials.priv_password):
+            register_secret(secret)
+
+        def execute():
+            return self._execute_query(query, api_key=api_key, timer=timer, effective=effective, on_rows=on_rows)
+
+        if query.operation.command.upper() == "SET" and self.write_deduplicator.window > 0:
+            key = write_key(api_key.identity() if api_key else None, query, resolve=self._resolve_oid)
+            return scrub_error_fields(await self.write_deduplicator.run(key, execute))
+        return scrub_error_fields(await execute())
+
+    async def _execute_query(self, query: SNMPQuery, api_key: Optional[APIKeyPolicy] = None,
+                             timer: Optional[StageTimer] = None,
+                             effective: Optional[Dict[str, Any]] = None,
+                             on_rows: Optional[Callable[[Dict[str, Any]], None]] = None) -> Dict[str, Any]:
+        """Execute an SNMP query, with secrets not yet scrubbed from the errors"""
         try:
             logger.info(f"Executing SNMP {query.operation.command} query to {query.target.host}")
 
+            maintenance_error = self.maintenance_error(query.operation.command.upper())
+            if maintenance_error:
+                logger.warning(f"Rejected SNMP query to {query.target.host}: {maintenance_error}")
+                return {"error": maintenance_error, "error_code": SERVICE_READ_ONLY}
+
             # Prepare OIDs
             oids = self._prepare_oids(query.operation)
             if not oids:
-                return {"error": "No valid OIDs specified"}
+                return {"error": "No valid OIDs specified", "error_code": INVALID_QUERY}
+
+            if not is_address(query.target.host) and any("/" in key for key in config.snmp.target_credentials):
+                # A name matches the configured networks by its addresses
+                await self.resolve_target(query.target)
+            # The configured credentials are validated like the query's own
+            query = with_target_credentials(query)
+            validation_error = self.validate_query(query, oids, api_key=api_key)
+            if validation_error:
+                logger.warning(f"Rejected SNMP query to {query.target.host}: {validation_error}")
+                return {"error": validation_error, "error_code": INVALID_QUERY}
+
+            if timer:
+                timer.mark("validation")
 
-            # Get community string for v1/v2c
-            community = query.credentials.community or config.snmp.default_community
+            if query.operation.command.upper() not in SUPPORTED_COMMANDS:
+                return {"error": f"Unsupported SNMP command: {query.operation.command}", "error_code": INVALID_QUERY}
 
-            # Create SNMP client with proper credentials
+            negotiated = query.credentials.version == "auto"
+            if negotiated:
+                version = await self.negotiate_version(query)
+                query = query.model_copy(update={"credentials": query.credentials.model_copy(update={"version": version})})
+
+            # Create SNMP clients with proper credentials, one per community string to try
+            communities = self._candidate_communities(query)
+            if effective is not None:
+                effective.update(self.effective_parameters(query, communities, negotiated))
             try:
-                if query.credentials.version == "1":
-                    client = Client(
-                        query.target.host,
-                        V1(community),
-                        port=query.target.port
-                    )
-                elif query.credentials.version == "2c":
-                    client = Client(
-                        query.target.host,
-                        V2C(community),
-                        port=query.target.port
-                    )
-                else:
-                    return {"error": "Only SNMP versions 1 and 2c are currently supported"}
+                clients = [self._create_client(query, community) for community in communities]
+            except ValueError as e:
+                return {"error": str(e), "error_code": INVALID_QUERY}
             except Exception as e:
                 logger.error(f"Failed to create SNMP client: {str(e)}")
                 return {"error": f"Failed to create SNMP client: {str(e)}"}
 
-            # Execute SNMP command
-            try:
-                if query.operation.command.upper() == "GET":
-                    result = await self._execute_get(client, oids)
-                elif query.operation.command.upper() == "GETNEXT":
-                    result = await self._execute_getnext(client, oids)
-                elif query.operation.command.upper() == "WALK":
-                    result = await self._execute_walk(client, oids)
-                elif query.operation.command.upper() == "BULK":
-                    result = await self._execute_bulk(
-                        client, oids,
-                        non_repeaters=query.operation.non_repeaters or 0,
-                        max_repetitions=query.operation.max_repetitions or 10
-                    )
-                else:
-                    return {"error": f"Unsupported SNMP command: {query.operation.command}"}
-            except Timeout as e:
-                logger.error(f"SNMP timeout while querying {query.target.host}: {str(e)}")
-                return {"error": f"SNMP request timed out. The puresnmp library uses a default timeout."}
-            except ConnectionRefusedError as e:
-                logger.error(f"Connection refused to {query.target.host}: {str(e)}")
-                return {"error": "Connection refused. Verify the device is reachable and SNMP is enabled"}
-            except SnmpError as e:
-                logger.error(f"SNMP error while querying {query.target.host}: {str(e)}")
-                return {"error": f"SNMP error: {str(e)}"}
-            except Exception as e:
-                logger.error(f"Unexpected error during SNMP query: {str(e)}")
-                return {"error": f"Failed to execute SNMP query: {str(e)}"}
+            preflight_error = await self.preflight_target(query, clients)
+            if preflight_error:
+                logger.warning(f"Pre-flight check failed for {query.target.host}: {preflight_error}")
+                return {"error": preflight_error, "error_code": SNMP_UNREACHABLE}
 
-            logger.info(f"SNMP query completed successfully")
+            if timer:
+                timer.mark("connect")
+
+            started = time.monotonic()
+            result = await self._send_query(query, clients, communities, oids, timer, effective, on_rows)
+            elapsed = time.monotonic() - started
+            self.target_stats.record(format_target(query.target.host, query.target.port), elapsed, result.get("error"))
+            labels = {
+                "operation": query.operation.effective_command(),
+                "outcome": operation_outcome(result),
+                "target_class": target_class(query.target.host, config.snmp.target_classes),
+            }
+            SNMP_OPERATIONS.inc(**labels)
+            SNMP_OPERATION_DURATION.observe(elapsed, **labels)
+            if error_type(result):
+                SNMP_ERRORS.inc(operation=labels["operation"], error=error_type(result),
+                                target_class=labels["target_class"])
             return result
 
         except Exception as e:
             logger.error(f"Error executing SNMP query: {e}", exc_info=True)
             return {"error": f"Error executing SNMP query: {str(e)}"}
 
+    async def _send_query(self, query: SNMPQuery, clients: List[Client], communities: List[str],
+                          oids: List[str], timer: Optional[StageTimer] = None,
+                          effective: Optional[Dict[str, Any]] = None,
+                          on_rows: Optional[Callable[[Dict[str, Any]], None]] = None) -> Dict[str, Any]:
+        """Run a validated query against its target, returning the response data or an error"""
+        # Execute SNMP command. v1/v2c agents drop requests with a wrong community
+        # instead of answering, so a timeout moves on to the next community
+        try:
+            for attempt, client in enumerate(clients, start=1):
+                if effective is not None:
+                    effective["community_attempts"] = attempt
+                try:
+                    result = await self._execute_operation(query, client, oids, on_rows)
+                    break
+                except Timeout:
+                    if attempt == len(clients):
+                        raise
+                    logger.warning(
+                        f"No response from {query.target.host} with community {attempt} of {len(clients)}, "
+                        f"trying the next"
+                    )
+
+            target = format_target(query.target.host, query.target.port)
+            if len(clients) > 1:
+                self._working_communities[target] = communities[attempt - 1]
+            if timer:
+                timer.mark("snmp")
+        except WalkDeadlineExceeded as e:
+            logger.error(f"SNMP walk deadline exceeded while querying {query.target.host}: {str(e)}")
+            return {"error": str(e), "error_code": SNMP_TIMEOUT}
+        except Timeout as e:
+            # A wrong v1/v2c community is dropped silently, so a timeout is never taken for one
+            logger.error(f"SNMP timeout while querying {query.target.host}: {str(e)}")
+            return {"error": COMMUNITIES_FAILED_ERROR if len(clients) > 1 else TIMEOUT_ERROR,
+                    "error_code": SNMP_TIMEOUT}
+        except ConnectionRefusedError as e:
+            logger.error(f"Connection refused to {query.target.host}: {str(e)}")
+            return {"error": CONNECTION_REFUSED_ERROR, "error_code": SNMP_UNREACHABLE}
+        except SNMPSetFailed as e:
+            logger.error(f"SNMP SET refused by {query.target.host}: {e}")
+            return {"error": str(e), "error_code": SNMP_SET_FAILED, "set_error": e.describe()}
+        except Exception as e:
+            reason = auth_failure(e)
+            if reason:
+                logger.error(f"SNMP authentication failed for {query.target.host}: {reason}")
+                return {"error": AUTH_FAILED_ERROR.format(reason=reason), "error_code": SNMP_AUTH_FAILED}
+            if isinstance(e, SnmpError):
+                logger.error(f"SNMP error while querying {query.target.host}: {str(e)}")
+                return {"error": f"SNMP error: {str(e)}", "error_code": SNMP_ERROR}
+            logger.error(f"Unexpected error during SNMP query: {str(e)}")
+            return {"error": f"Failed to execute SNMP query: {str(e)}"}
+
+        logger.info(f"SNMP query completed successfully")
+        return result
+
+    async def _execute_operation(self, query: SNMPQuery, client: Client, oids: List[str],
+                                 on_rows: Optional[Callable[[Dict[str, Any]], None]] = None) -> Dict[str, Any]:
+        """Run a query's SNMP command with a client"""
+        command = query.operation.effective_command()
+        # Walks report raw varbinds; the caller gets them named and formatted like the result
+        emit = (lambda varbinds: on_rows(self._format_varbinds(varbinds))) if on_rows else None
+        if command == "GET":
+            return await self._execute_get(client, oids)
+        if command == "GETNEXT":
+            return await self._execute_getnext(client, oids)
+        if command == "SET":
+            return await self._execute_set(client, self._prepare_set_values(query.operation))
+        if command == "WALK":
+            return await self._execute_walk(
+                client, oids,
+                deadline=query.operation.deadline,
+                limit=self._target_limit(query.target.host),
+                index_range=query.operation.index_range(),
+                checkpoint=self._walk_checkpoint_key(query) if config.snmp.walk_checkpoints else None,
+                on_rows=emit
+            )
+        return await self._execute_bulk(
+            client, oids,
+            non_repeaters=query.operation.non_repeaters or 0,
+            max_repetitions=query.operation.max_repetitions or 10,
+            limit=self._target_limit(query.target.host),
+            on_rows=emit
+        )
+
+    def validate_query(self, query: SNMPQuery, oids: Optional[List[str]] = None,
+                       api_key: Optional[APIKeyPolicy] = None) -> Optional[str]:
+        """
+        Validate a query before it is executed
+
+        Args:
+            query: Structured SNMP query object
+            oids: OIDs prepared from the query (prepared from the operation if not given)
+            api_key: Policy of the API key making the request, if any
+
+        Returns:
+            Error message, or None if the query is valid
+        """
+        command = query.operation.effective_command()
+        if oids is None:
+            oids = self._prepare_oids(query.operation)
+
+        if command == "SET":
+            if not config.snmp.allow_set:
+                return "SNMP SET is disabled on this server; enable SNMP_ALLOW_SET to write to devices"
+            if not query.operation.set_values or query.operation.oids or query.operation.mib_names:
+                return "SET needs set_values, each with an OID and a value, and no other OIDs"
+        elif query.operation.set_values:
+            return f"Values to set are only supported for SET, not {command}"
+
+        index_range = query.operation.index_range()
+        if index_range:
+            if query.operation.indexes:
+                return "Give either row indexes or an index range, not both"
+            if command != "WALK" or query.operation.command.upper() == "WALKTABLE":
+                return f"Index ranges are only supported for WALK, not {query.operation.command.upper()}"
+            if index_range[1] is not None and index_range[1] < index_range[0]:
+                return f"Invalid index range: {index_range[0]} to {index_range[1]}"
+
+        max_oids = config.snmp.max_oids.get(command)
+        if max_oids is not None and len(oids) > max_oids:
+            return f"Too many OIDs for {command}: {len(oids)} requested, the maximum is {max_oids}"
+
+        oid_error = self._oid_error(command, oids)
+        if oid_error:
+            return oid_error
+
+        # With the credentials configured for the target, as they will be sent
+        credentials = with_target_credentials(query).credentials
+        if credentials.version == "3":
+            credentials_error = v3_credentials_error(v3_defaults(credentials))
+            if credentials_error:
+                return credentials_error
+
+        if api_key and api_key.oid_prefixes:
+            for oid in oids:
+                if not any(_in_subtree(oid, prefix) for prefix in api_key.oid_prefixes):
+                    return f"API key '{api_key.name}' is not authorized for OID {oid}"
+
+        if command == "SET":
+            try:
+                self._prepare_set_values(query.operation)
+            except ValueError as e:
+                return f"Invalid SET value: {e}"
+            # Nothing may be written unless it is listed
+            allowed = [self._resolve_oid(oid) for oid in config.snmp.set_allowed_oids]
+            for oid in oids:
+                if not any(root and _in_subtree(oid, root) for root in allowed):
+                    name = self.mib_service.translate_oid(oid) or oid
+                    return f"SET of {name} is not allowed on this server; SNMP_SET_ALLOWED_OIDS doesn't include it"
+
+        return None
+
+    def _oid_error(self, command: str, oids: List[str]) -> Optional[str]:
+        """The first OID that is too long, or with SNMP_VALIDATE_OIDS malformed or unknown, and why"""
+        # Enforced even without SNMP_VALIDATE_OIDS, as a guard against malformed or malicious input
+        for oid in oids:
+            length_error = oid_length_error(oid)
+            if length_error:
+                return f"OID {_abbreviate(oid)} is too long: {length_error}"
+
+        if config.snmp.validate_oids:
+            for oid in oids:
+                oid_error = self.check_oid(command, oid)
+                if oid_error:
+                    return oid_error
+        return None
+
+    def correctable_error(self, query: SNMPQuery) -> Optional[str]:
+        """
+        The validation error of an interpreted query that the LLM may correct, if it has one
+
+        Only an OID that is too long, malformed or unknown is the model's to correct. Queries
+        the API key or the SET policy refuse are rejected as interpreted: the model would
+        swap in an OID or SET target the policy allows, which isn't what was asked for.
+        """
+        return self._oid_error(query.operation.effective_command(), self._prepare_oids(query.operation))
+
+    def check_oid(self, command: str, oid: str) -> Optional[str]:
+        """
+        Check that an OID is well-formed and plausible for a command, to catch invented OIDs
+
+        An OID passes if a loaded MIB defines it (or objects under it), if it matches a
+        SNMP_KNOWN_OIDS pattern for the command, or if it lies in the enterprise subtree
+        of a known vendor.
+
+        Args:
+            command: SNMP command the OID is requested with
+            oid: OID prepared from the query
+
+        Returns:
+            Error message naming the OID, or None if it passed
+        """
+        syntax_error = oid_syntax_error(oid)
+        if syntax_error:
+            return f"Malformed OID {oid}: {syntax_error}"
+
+        if self.mib_service.defines_oid(oid):
+            return None
+
+        patterns = config.snmp.known_oids.get("*", []) + config.snmp.known_oids.get(command, [])
+        if any(_matches_oid_pattern(oid, pattern) for pattern in patterns):
+            return None
+
+        enterprise = get_enterprise(oid)
+        if enterprise:
+            number, vendor = enterprise
+            if vendor:
+                return None
+            return (f"Unknown OID {oid}: enterprise {number} is not a known vendor and no loaded MIB "
+                    f"defines the OID (add it to SNMP_KNOWN_OIDS if it is real)")
+
+        return (f"Unknown OID {oid}: no loaded MIB defines it and it matches no known OID pattern "
+                f"for {command}")
+
+    async def negotiate_version(self, query: SNMPQuery) -> str:
+        """
+        Find the SNMP version a target speaks, probing it on first contact
+
+        The versions in SNMP_NEGOTIATE_VERSIONS (v3 first, when the query has a username
+        or SNMP_V3_SECURITY_NAME is set) are tried in order with a sysUpTime GET, each sent once and given
+        SNMP_VERSION_PROBE_TIMEOUT seconds; any SNMP response, even an error, settles
+        it. A version answered properly is cached per target and credentials for
+        SNMP_VERSION_CACHE_TTL seconds; one answered with an error is used but not
+        cached. If no version answers, nothing is cached and the first version is used.
+
+        Args:
+            query: Structured SNMP query object, with version "auto"
+
+        Returns:
+            The version to query the target with: "1", "2c" or "3"
+        """
+        target = format_target(query.target.host, query.target.port)
+        credentials = sorted(query.credentials.model_dump().items())
+        cache_key = f"snmp_version_{connection_key(query.target.host, query.target.port, credentials)}"
+        cached_version = get_cache(cache_key)
+        if cached_version:
+            return cached_version
+
+        versions = [version for version in config.snmp.negotiate_versions if version != "3"] or ["2c"]
+        if v3_defaults(query.credentials).username:
+            versions.insert(0, "3")
+        community = self._candidate_communities(query)[0]
+        probe_target = query.target.model_copy(update={"timeout": config.snmp.version_probe_timeout, "retries": 0})
+
+        for version in versions:
+            probe = query.model_copy(update={
+                "target": probe_target,
+                "credentials": query.credentials.model_copy(update={"version": version})
+            })
+            try:
+                client = self._create_client(probe, community)
+                await asyncio.wait_for(
+                    client.get(ObjectIdentifier("1.3.6.1.2.1.1.3.0")), timeout=config.snmp.version_probe_timeout
+                )
+            except (asyncio.TimeoutError, Timeout):
+                logger.info(f"No response from {target} to SNMP version {version}")
+                continue
+            except (ValueError, OSError) as e:
+                logger.warning(f"Could not negotiate the SNMP version of {target}: {e}")
+                break
+            except Exception as e:
+                # The agent answered, if only with an error, which may be passing (e.g. a bad password)
+                logger.info(f"SNMP version {version} of {target} answered with an error, not caching it: {e}")
+                return version
+            logger.info(f"Negotiated SNMP version {version} for {target}")
+            set_cache(cache_key, version, ttl=config.snmp.version_cache_ttl)
+            return version
+
+        logger.warning(f"No SNMP version answered on {target}, using version {versions[0]}")
+        return versions[0]
+
+    async def resolve_target(self, target: SNMPTarget) -> Optional[str]:
+        """
+        Check that a target's host resolves, the DNS part of the pre-flight check
+
+        The outcome is cached briefly per target, so resolving a target as soon as it
+        is known (e.g. while the rest of its interpretation streams in) saves the
+        pre-flight check the lookup. The addresses it resolves to are kept with it,
+        to match the target against the networks of SNMP_TARGET_CREDENTIALS.
+
+        Returns:
+            Error message, or None if the host resolves
+        """
+        host, port = target.host, target.port
+        cache_key = f"resolve_{format_target(host, port)}"
+        cached = get_cache(cache_key)
+        if cached is not None:
+            return cached["error"]
+
+        error, addresses = None, []
+        try:
+            infos = await asyncio.get_running_loop().getaddrinfo(host, port, type=socket.SOCK_DGRAM)
+            addresses = sorted({info[4][0] for info in infos})
+        except socket.gaierror as e:
+            error = f"Target {host} does not resolve: {e.strerror or e}"
+        set_cache(cache_key, {"error": error, "addresses": addresses}, ttl=config.snmp.preflight_cache_ttl)
+        return error
+
+    async def preflight_target(self, query: SNMPQuery, clients: List[Client]) -> Optional[str]:
+        """
+        Check that a query's target can be reached before committing to the SNMP timeout
+
+        The target must resolve (SNMP_PREFLIGHT_DNS) and, with SNMP_PREFLIGHT_REACHABILITY,
+        answer a sysUpTime probe within SNMP_PREFLIGHT_TIMEOUT seconds with one of its
+        community strings; any SNMP response, even an error, counts as reachable. Outcomes
+        are cached briefly per target and credentials, except when the probe was answered
+        with an authentication failure, which the query itself should report.
+
+        Args:
+            query: Structured SNMP query object
+            clients: SNMP clients for the target, one per community string to try
+
+        Returns:
+            Error message, or None if the target passed
+        """
+        host, port = query.target.host, query.target.port
+        cache_key = f"preflight_{connection_key(host, port, sorted(query.credentials.model_dump().items()))}"
+        cached = get_cache(cache_key)
+        if cached is not None:
+            return cached["error"]
+
+        error = await self.resolve_target(query.target) if config.snmp.preflight_dns else None
+        cacheable = True
+
+        if error is None and config.snmp.preflight_reachability:
+            for client in clients:
+                try:
+                    await asyncio.wait_for(
+                        client.get(ObjectIdentifier("1.3.6.1.2.1.1.3.0")), timeout=config.snmp.preflight_timeout
+                    )
+                except (asyncio.TimeoutError, Timeout):
+                    error = (f"Target {host} is unreachable: no SNMP response on port {port} "
+                             f"within {config.snmp.preflight_timeout:g}s")
+                    continue
+                except ConnectionRefusedError:
+                    error = f"Target {host} is unreachable: connection refused on port {port}"
+                    break
+                except Exception as e:
+                    # The agent answered, if only with an error
+                    cacheable = not auth_failure(e)
+                error = None
+                break
+
+        if cacheable:
+            set_cache(cache_key, {"error": error}, ttl=config.snmp.preflight_cache_ttl)
+        return error
+
+    def effective_parameters(self, query: SNMPQuery, communities: List[str], negotiated: bool = False
+                             ) -> Dict[str, Any]:
+        """
+        Describe the SNMP parameters a query is sent with, after negotiation and per-target settings
+
+        Credentials are masked; for v1/v2c the community is described by where it came
+        from: the query, the target's SNMP_TARGET_COMMUNITIES or SNMP_TARGET_CREDENTIALS, or
+        the default.
+
+        Args:
+            query: Structured SNMP query object, with its version resolved
+            communities: Community strings to try, in order
+            negotiated: Whether the version was negotiated with the target
+
+        Returns:
+            Dictionary with the target, transport, version, timeout, retries and credentials
+        """
+        credentials = query.credentials
+        command = query.operation.effective_command()
+        parameters: Dict[str, Any] = {
+            "host": query.target.host,
+            "port": query.target.port,
+            "transport": "udp6" if ":" in query.target.host else "udp",
+            "version": credentials.version,
+            "version_negotiated": negotiated,
+            "timeout": query.target.timeout,
+            "retries": query.target.retries,
+        }
+
+        if credentials.version == "3":
+            credentials = v3_defaults(credentials)
+            parameters["security"] = {
+                "username": credentials.username,
+                "security_level": v3_security_level(credentials),
+                "auth_protocol": (credentials.auth_protocol or "SHA").upper() if credentials.auth_password else None,
+                "auth_password": _mask_secret(credentials.auth_password),
+                "priv_protocol": (credentials.priv_protocol or "AES").upper() if credentials.priv_password else None,
+                "priv_password": _mask_secret(credentials.priv_password),
+            }
+        else:
+            community = credentials.community or config.snmp.default_community
+            if community != config.snmp.default_community:
+                source = "query"
+            else:
+                source = "target" if _configured_communities(query.target) else "default"
+            parameters["community"] = _mask_secret(community)
+            parameters["community_source"] = source
+            parameters["community_candidates"] = len(communities)
+
+        if command == "BULK":
+            parameters["non_repeaters"] = query.operation.non_repeaters or 0
+            # Repetitions are capped so a response stays within SNMP_MAX_PDU_VARBINDS
+            parameters["max_repetitions"] = max(1, min(query.operation.max_repetitions or 10,
+                                                       config.snmp.max_pdu_varbinds))
+        if command == "WALK":
+            parameters["deadline"] = query.operation.deadline or config.snmp.walk_deadline
+        return parameters
+
+    def describe_request(self, query: SNMPQuery) -> Dict[str, Any]:
+        """
+        Describe the SNMP request that will be sent for a query, with credentials masked
+
+        Args:
+            query: Structured SNMP query object
+
+        Returns:
+            Dictionary with the target, version, masked credentials, operation and OIDs
+        """
+        credentials = with_target_credentials(query).credentials
+        command = query.operation.effective_command()
+
+        request = {
+            "host": query.target.host,
+            "port": query.target.port,
+            "version": credentials.version,
+            "operation": command,
+            "requested_oids": list(query.operation.oids),
+            "mib_names": list(query.operation.mib_names),
+            "oids": self._prepare_oids(query.operation),
+            "unresolved_oids": [
+                oid for oid in query.operation.oids
+                if "::" in oid and not self.mib_service.resolve_oid(oid)
+            ],
+        }
+
+        if credentials.version == "3":
+            request["security"] = {
+                "username": credentials.username,
+                "auth_protocol": credentials.auth_protocol,
+                "auth_password": _mask_secret(credentials.auth_password),
+                "priv_protocol": credentials.priv_protocol,
+                "priv_password": _mask_secret(credentials.priv_password),
+            }
+        else:
+            request["community"] = _mask_secret(credentials.community or config.snmp.default_community)
+
+        if command == "SET":
+            request["set_values"] = [set_value.model_dump() for set_value in query.operation.set_values]
+        if command == "BULK":
+            request["non_repeaters"] = query.operation.non_repeaters or 0
+            request["max_repetitions"] = query.operation.max_repetitions or 10
+
+        if query.operation.index_range():
+            request["index_range"] = list(query.operation.index_range())
+
+        return request
+
+    async def execute_multi(self, query: SNMPQuery, hosts: List[str],
+                            api_key: Optional[APIKeyPolicy] = None,
+                            retry_budget: Optional[int] = None) -> List[TargetResult]:
+        """
+        Execute the same SNMP query against several targets concurrently
+
+        Timeouts and refused connections are retried up to the target's retries,
+        but all targets share one retry budget so that many flaky targets can't
+        cause a retry storm. Once it is spent, failing targets are not retried.
+        Each attempt sends its requests once, so only the budget retries them.
+
+        Args:
+            query: Structured SNMP query object (its target host is replaced per target)
+            hosts: Target IP addresses or hostnames
+            api_key: Policy of the API key making the request, if any
+            retry_budget: Total retries across all targets (SNMP_MULTI_RETRY_BUDGET if not given)
+
+        Returns:
+            Outcome for each target, in the same order as hosts
+        """
+        retries_left = config.snmp.multi_retry_budget if retry_budget is None else retry_budget
+
+        async def run(host: str) -> TargetResult:
+            nonlocal retries_left
+            target_query = query.model_copy(deep=True)
+            target_query.target = SNMPTarget(**{**query.target.dict(), "host": host, "retries": 0})
+
+            retries = 0
+            while True:
+                result = await self.execute_query(target_query, api_key=api_key)
+                if "error" not in result:
+                    return TargetResult(host=host, status=200, raw_data=result)
+
+                if result["error"] not in RETRYABLE_ERRORS or retries >= query.target.retries:
+                    break
+                if retries_left <= 0:
+                    logger.warning(f"Retry budget exhausted, not retrying {host}")
+                    result = {**result, "error": f"{result['error']} (retry budget exhausted)"}
+                    break
+
+                retries_left -= 1
+                retries += 1
+                logger.info(f"Retrying {host} (attempt {retries + 1}, {retries_left} retries left in budget)")
+
+            return TargetResult(host=host, status=502, raw_data=result, error=result["error"],
+                                error_code=result.get("error_code"))
+
+        return list(await asyncio.gather(*(run(host) for host in hosts)))
+
+    def _prepare_set_values(self, operation: SNMPOperation) -> List[Tuple[str, str, Any]]:
+        """
+        Resolve a SET's values to typed varbinds, checked against each object's SYNTAX
+
+        Returns:
+            (numeric OID, type, value) of each value to write
+
+        Raises:
+            ValueError: If a value doesn't fit its object, or the object is read-only
+        """
+        varbinds = []
+        for set_value in operation.set_values:
+            oid = self._resolve_oid(set_value.oid)
+            if oid is None:
+                raise ValueError(f"Unknown object {set_value.oid}")
+            value_type, value = self.mib_service.resolve_set_value(oid, set_value.type, set_value.value)
+            varbinds.append((oid, value_type, value))
+        return varbinds
+
+    def _resolve_oid(self, oid: str) -> Optional[str]:
+        """Resolve an OID from a query to a numeric OID without the leading dot, or None if it is an unknown name"""
+        oid = normalize_oid(oid)
+
+        # Numeric OIDs resolve to themselves; names could be in format like 'IF-MIB::ifDescr'
+        resolved_oid = self.mib_service.resolve_oid(oid)
+        if resolved_oid:
+            return normalize_oid(resolved_oid)
+        if "::" in oid:
+            return None
+        # Last resort: treat as raw OID
+        return oid
+
+    def resolve_names(self, operation: SNMPOperation) -> Dict[str, str]:
+        """
+        Resolve the symbolic names among a query's OIDs, before anything is sent
+
+        Names the LLM or the user gives, like ifInOctets or IF-MIB::ifDescr.3, must be
+        defined by a loaded MIB (or be aliases); numeric OIDs are left alone.
+
+        Returns:
+            Name -> numeric OID, for each symbolic name of the query
+
+        Raises:
+            UnresolvedNameError: For the first name no loaded MIB defines
+        """
+        resolved = {}
+        for oid in operation.oids + [set_value.oid for set_value in operation.set_values]:
+            name = normalize_oid(oid)
+            if not is_numeric_oid(name):
+                resolved[name] = self.mib_service.resolve_name(name)
+        return resolved
+
     def _prepare_oids(self, operation: SNMPOperation) -> List[str]:
         """Prepare the OIDs for the SNMP query"""
         oids = []
 
-        # Process direct OIDs
-        for oid in operation.oids:
-            if oid.startswith("."):
-                oids.append(oid.lstrip('.'))
-            else:
-                # Assume it's a named OID, could be in format like 'IF-MIB::ifDescr'
-                if "::" in oid:
-                    # Try to resolve using MIB service
-                    resolved_oid = self.mib_service.resolve_oid(oid)
-                    if resolved_oid:
-                        oids.append(resolved_oid.lstrip('.'))
-                else:
-                    # Try to resolve using MIB service
-                    resolved_oid = self.mib_service.resolve_oid(oid)
-                    if resolved_oid:
-                        oids.append(resolved_oid.lstrip('.'))
-                    else:
-                        # Last resort: treat as raw OID
-                        oids.append(oid.lstrip('.'))
+        # Process direct OIDs, and the objects a SET writes
+        for oid in operation.oids + [set_value.oid for set_value in operation.set_values]:
+            resolved_oid = self._resolve_oid(oid)
+            if resolved_oid:
+                oids.append(resolved_oid)
 
         # Process MIB entries
         for mib_name in operation.mib_names:
@@ -121,11 +1244,40 @@ class SNMPService:
             for oid in mib_oids:
                 oids.append(oid.lstrip('.'))
 
-        return oids
+        # Explicit row indexes select exact cells of each column
+        if operation.indexes:
+            oids = [build_oid(oid, index) for oid in oids for index in operation.indexes]
+
+        # An OID requested twice (e.g. by name and number) is only fetched once
+        return list(dict.fromkeys(oids))
+
+    async def _execute_set(self, client: Client, varbinds: List[Tuple[str, str, Any]]) -> Dict[str, Any]:
+        """Execute SNMP SET command, writing every value in one request, and return the values the agent set"""
+        try:
+            values = await client.multiset({
+                ObjectIdentifier(oid): _set_value(value_type, value) for oid, value_type, value in varbinds
+            })
+        except Exception as e:
+            _raise_if_auth_failure(e)
+            if isinstance(e, ErrorResponse) and getattr(e, "error_status", None):
+                # puresnmp names the refused varbind by OID; its position is the error-index
+                oid = str(e.offending_oid).strip(".") if getattr(e, "offending_oid", None) else None
+                positions = [position for position, (varbind_oid, _, _) in enumerate(varbinds, start=1)
+                             if varbind_oid.strip(".") == oid]
+                raise SNMPSetFailed(e.error_status, positions[0] if positions else 0, oid,
+                                    self.mib_service.translate_oid(oid) if oid else None) from e
+            raise
+
+        result = {}
+        for oid, value in values.items():
+            name_str = self.mib_service.translate_oid(str(oid)) or str(oid)
+            result[name_str] = self._format_oid_value(str(oid), self._raw_value(value))
+        return result
 
     async def _execute_get(self, client: Client, oids: List[str]) -> Dict[str, Any]:
-        """Execute SNMP GET command"""
+        """Execute SNMP GET command, raising Timeout only if every OID timed out"""
         result = {}
+        timeouts = 0
 
         try:
             # Execute a GET for each OID
@@ -133,8 +1285,13 @@ class SNMPService:
                 try:
                     value = await client.get(ObjectIdentifier(oid))
                     name_str = self.mib_service.translate_oid(oid) or oid
-                    result[name_str] = self._format_value(value)
+                    result[name_str] = self._format_oid_value(oid, value)
+                except Timeout as e:
+                    logger.error(f"Timeout getting OID {oid}: {e}")
+                    result[oid] = f"Error: {str(e)}"
+                    timeouts += 1
                 except SnmpError as e:
+                    _raise_if_auth_failure(e)
                     # Handle all SNMP errors generically since the specific error classes don't exist
                     error_msg = str(e)
                     if "no such object" in error_msg.lower():
@@ -147,92 +1304,522 @@ class SNMPService:
                         logger.error(f"Error getting OID {oid}: {e}")
                         result[oid] = f"Error: {str(e)}"
                 except Exception as e:
+                    _raise_if_auth_failure(e)
                     logger.error(f"Error getting OID {oid}: {e}")
                     result[oid] = f"Error: {str(e)}"
 
+        except SNMPAuthenticationFailed:
+            raise
         except Exception as e:
             logger.error(f"Error in GET: {e}")
             if not result:
                 # Only set error if we haven't got any results
-                result["error"] = str(e)
+                result.update(error=str(e), error_code=SNMP_ERROR)
+
+        if oids and timeouts == len(oids):
+            raise Timeout(f"No response to GET for any of {len(oids)} OIDs")
+
+        if result and all(value in NO_SUCH_VALUES for value in result.values()):
+            return EmptyResult(result)
 
         return result
 
     async def _execute_getnext(self, client: Client, oids: List[str]) -> Dict[str, Any]:
-        """Execute SNMP GETNEXT command"""
+        """
+        Execute SNMP GETNEXT command, raising Timeout only if every OID timed out
+
+        Each value is keyed by the OID the agent answered with, the next one after the
+        requested OID, so a client can step through a table by requesting it next.
+        """
         result = {}
+        timeouts = 0
 
         try:
             # Execute a GETNEXT for each OID
             for oid in oids:
                 try:
                     next_oid, value = await client.getnext(ObjectIdentifier(oid))
-                    name_str = self.mib_service.translate_oid(f".{next_oid}") or str(next_oid)
-                    result[name_str] = self._format_value(value)
+                    next_oid = str(next_oid).strip(".")
+                    name_str = self.mib_service.translate_oid(next_oid) or next_oid
+                    result[name_str] = self._format_oid_value(next_oid, value)
+                except Timeout as e:
+                    logger.error(f"Timeout with GETNEXT for OID {oid}: {e}")
+                    result[oid] = f"Error: {str(e)}"
+                    timeouts += 1
                 except Exception as e:
+                    _raise_if_auth_failure(e)
                     logger.error(f"Error with GETNEXT for OID {oid}: {e}")
                     result[oid] = f"Error: {str(e)}"
 
+        except SNMPAuthenticationFailed:
+            raise
         except Exception as e:
             logger.error(f"Error in GETNEXT: {e}")
             if not result:
                 # Only set error if we haven't got any results
-                result["error"] = str(e)
+                result.update(error=str(e), error_code=SNMP_ERROR)
+
+        if oids and timeouts == len(oids):
+            raise Timeout(f"No response to GETNEXT for any of {len(oids)} OIDs")
 
         return result
 
-    async def _execute_walk(self, client: Client, oids: List[str]) -> Dict[str, Any]:
-        """Execute SNMP WALK command"""
-        result = {}
+    def _walk_checkpoint_key(self, query: SNMPQuery) -> str:
+        """Cache key prefix for the checkpoints of a query's walk: its target, credentials and row filter"""
+        walk_view = compute_etag(query.credentials.dict(), query.operation.index_range()).strip('"')
+        return f"walk_checkpoint_{format_target(query.target.host, query.target.port)}_{walk_view}"
+
+    async def _walk_from(self, client: Client, root: str, start: str):
+        """Walk the rest of a subtree with GETNEXT, starting after a given OID"""
+        cursor = start
+        while True:
+            try:
+                next_oid, value = await client.getnext(ObjectIdentifier(cursor))
+            except Exception as e:
+                if _is_end_of_mib_view(e):
+                    return
+                raise
+            if _is_end_of_mib_view(value) or not _in_subtree(str(next_oid), root):
+                return
+            yield next_oid, value
+            cursor = str(next_oid)
+
+    async def _execute_walk(self, client: Client, oids: List[str], deadline: Optional[int] = None,
+                            limit: Optional[asyncio.Semaphore] = None,
+                            index_range: Optional[Tuple[int, Optional[int]]] = None,
+                            checkpoint: Optional[str] = None,
+                            on_rows: Optional[Callable[[Dict[str, Any]], None]] = None) -> Dict[str, Any]:
+        """
+        Execute SNMP WALK command
+
+        Each subtree is walked concurrently over its own requests, bounded by the
+        per-target connection limit. The whole walk (all subtrees) must finish within
+        the overall deadline, which is separate from the timeout for each individual
+        SNMP request. A subtree that fails is reported as "<oid>_error" alongside the
+        results of the others. With an index range, each column is walked from just before
+        the first index in the range, only rows whose first index is in the range are kept,
+        and a column stops being walked past its end.
+        Subtrees nested in another requested subtree are covered by walking the outer one.
+
+        With a checkpoint key, each subtree's progress is cached as values arrive. A
+        later walk reuses subtrees marked complete and resumes partial ones after their
+        last OID; the checkpoints are dropped once a walk returns every subtree. Each
+        walk records into its own checkpoint, so concurrent walks never mix their rows.
+
+        With on_rows, each kept varbind is also passed on as it arrives (raw, keyed by
+        numeric OID), and the rows reused from a checkpoint are passed on at once.
+        """
+        deadline = deadline or config.snmp.walk_deadline
+        oids = _collapse_subtrees(oids)
+        limit = limit or asyncio.Semaphore(config.snmp.max_connections_per_target)
+
+        # Collect raw varbinds so sibling columns can be decoded together
+        varbinds: Dict[str, Dict[str, Any]] = {oid: {} for oid in oids}
+        # Varbinds walked per subtree, including those the index range left out
+        walked = {oid: 0 for oid in oids}
 
+        async def walk_subtree(oid: str) -> None:
+            progress = None
+            if checkpoint:
+                previous = get_cache(f"{checkpoint}_{oid}")
+                if previous and previous["complete"]:
+                    logger.debug(f"Reusing the completed walk of {oid} from its checkpoint")
+                    varbinds[oid].update(previous["varbinds"])
+                    walked[oid] = previous["walked"]
+                    if on_rows and previous["varbinds"]:
+                        on_rows(dict(previous["varbinds"]))
+                    return
+                # A fresh record, so a walk still writing the previous one can't interleave with this one
+                progress = {
+                    "varbinds": varbinds[oid],
+                    "walked": 0,
+                    "last_oid": None,
+                    "complete": False,
+                }
+                if previous:
+                    varbinds[oid].update(previous["varbinds"])
+                    progress.update(walked=previous["walked"], last_oid=previous["last_oid"])
+                    walked[oid] = previous["walked"]
+                    if on_rows and previous["varbinds"]:
+                        on_rows(dict(previous["varbinds"]))
+                set_cache(f"{checkpoint}_{oid}", progress, ttl=config.snmp.walk_checkpoint_ttl)
+
+            async with limit:
+                if progress and progress["last_oid"]:
+                    logger.info(f"Resuming the walk of {oid} after {progress['last_oid']} "
+                                f"({progress['walked']} values already walked)")
+                    varbind_iterator = self._walk_from(client, oid, progress["last_oid"])
+                elif index_range and index_range[0] > 0:
+                    # Skip the rows before the range instead of walking through them
+                    varbind_iterator = self._walk_from(client, oid, f"{oid}.{index_range[0] - 1}")
+                else:
+                    # client.walk returns an async generator that we need to iterate through
+                    varbind_iterator = client.walk(ObjectIdentifier(oid))
+
+                async for walked_oid, value in varbind_iterator:
+                    walked[oid] += 1
+                    if progress:
+                        progress["walked"] = walked[oid]
+                        progress["last_oid"] = str(walked_oid)
+                    if index_range:
+                        row = _row_index(walked_oid, oid)
+                        if row is not None and index_range[1] is not None and row > index_range[1]:
+                            # Rows come in index order, so the rest of the column is out of range
+                            break
+                        if row is None or row < index_range[0]:
+                            continue
+                    varbinds[oid][str(walked_oid)] = value
+                    if on_rows:
+                        on_rows({str(walked_oid): value})
+
+            if progress:
+                progress["complete"] = True
+
+        tasks = [asyncio.ensure_future(walk_subtree(oid)) for oid in oids]
         try:
-            # Execute walk for each OID
+            _, pending = await asyncio.wait(tasks, timeout=deadline)
+        except asyncio.CancelledError:
+            # Stop the subtree walks too when the request is cancelled
+            for task in tasks:
+                task.cancel()
+            await asyncio.gather(*tasks, return_exceptions=True)
+            raise
+
+        if pending:
+            for task in pending:
+                task.cancel()
+            await asyncio.gather(*pending, return_exceptions=True)
+            unfinished = [oid for oid, task in zip(oids, tasks) if task in pending]
+            collected = sum(len(values) for values in varbinds.values())
+            raise WalkDeadlineExceeded(
+                f"Overall walk deadline of {deadline}s exceeded while walking {', '.join(unfinished)} "
+                f"({collected} values collected)"
+            )
+
+        merged = {}
+        errors = {}
+        for oid, task in zip(oids, tasks):
+            error = task.exception()
+            if error is None:
+                merged.update(varbinds[oid])
+            else:
+                logger.error(f"Error with WALK for OID {oid}: {error}")
+                errors[oid] = error
+
+        # Every subtree is in the result, so its checkpoints are no longer needed
+        if checkpoint and not errors:
             for oid in oids:
-                try:
-                    # client.walk returns an async generator that we need to iterate through
-                    walk_gen = client.walk(ObjectIdentifier(oid))
+                delete_cache(f"{checkpoint}_{oid}")
 
-                    # Process results from the generator
-                    async for walked_oid, value in walk_gen:
-                        name_str = self.mib_service.translate_oid(f".{walked_oid}") or str(walked_oid)
-                        result[name_str] = self._format_value(value)
-                except Exception as e:
-                    logger.error(f"Error with WALK for OID {oid}: {e}")
-                    result[f"{oid}_error"] = f"Error: {str(e)}"
+        # Only a timeout or rejected credentials on every subtree fails the whole walk
+        if errors and len(errors) == len(oids) and all(isinstance(e, Timeout) for e in errors.values()):
+            raise next(iter(errors.values()))
+        if errors and len(errors) == len(oids) and all(auth_failure(e) for e in errors.values()):
+            raise next(iter(errors.values()))
 
-        except Exception as e:
-            logger.error(f"Error in WALK: {e}")
-            if not result:
-                # Only set error if we haven't got any results
-                result["error"] = str(e)
+        if not merged and not errors:
+            if any(walked.values()):
+                return EmptyResult(reason=EMPTY_ALL_FILTERED)
+            return EmptyResult(reason=await self._empty_walk_reason(client, oids))
+
+        result = self._format_varbinds(merged)
+        for oid, error in errors.items():
+            result[f"{oid}_error"] = f"Error: {str(error) or type(error).__name__}"
 
         return result
 
+    async def _empty_walk_reason(self, client: Client, oids: List[str]) -> str:
+        """
+        Tell whether empty subtrees were past the end of the agent's MIB view
+
+        The walk doesn't expose why it stopped, so each subtree is probed with a
+        GETNEXT: endOfMibView means the agent has nothing at or after the subtree,
+        an object inside it means an index range skipped past all its rows, and
+        otherwise the next object lies outside it and the subtree is just empty.
+        """
+        for oid in oids:
+            try:
+                next_oid, value = await client.getnext(ObjectIdentifier(oid))
+            except Exception as e:
+                if not _is_end_of_mib_view(e):
+                    logger.debug(f"Could not probe empty subtree {oid}: {e}")
+                    return EMPTY_NO_OBJECTS
+                continue
+            if _is_end_of_mib_view(value):
+                continue
+            if _in_subtree(str(next_oid), oid):
+                return EMPTY_ALL_FILTERED
+            return EMPTY_NO_OBJECTS
+        return EMPTY_END_OF_MIB_VIEW
+
     async def _execute_bulk(self, client: Client, oids: List[str],
-                            non_repeaters: int = 0, max_repetitions: int = 10) -> Dict[str, Any]:
-        """Execute SNMP BULK command"""
+                            non_repeaters: int = 0, max_repetitions: int = 10,
+                            max_pdu_varbinds: Optional[int] = None,
+                            limit: Optional[asyncio.Semaphore] = None,
+                            on_rows: Optional[Callable[[Dict[str, Any]], None]] = None) -> Dict[str, Any]:
+        """
+        Execute SNMP BULK command
+
+        The first non_repeaters OIDs are fetched once; the remaining column OIDs are
+        bulk-walked to the end of their subtrees, max_repetitions rows per request.
+        With on_rows, the raw varbinds of each response are passed on as it arrives.
+        """
         result = {}
+        max_pdu_varbinds = max_pdu_varbinds or config.snmp.max_pdu_varbinds
+        scalars, columns = oids[:non_repeaters], _collapse_subtrees(oids[non_repeaters:])
 
         try:
-            # Execute bulk for each OID
-            for oid in oids:
-                bulk_results = await client.bulkget(
-                    ObjectIdentifier(oid),
-                    scalar_oids=non_repeaters,
-                    repeating_oids=max_repetitions
-                )
+            varbinds = {}
+
+            for start in range(0, len(scalars), max_pdu_varbinds):
+                chunk = scalars[start:start + max_pdu_varbinds]
+                response = await client.bulkget([ObjectIdentifier(oid) for oid in chunk], [], max_list_size=1)
+                fetched = {str(scalar_oid): value for scalar_oid, value in response.scalars.items()}
+                varbinds.update(fetched)
+                if on_rows and fetched:
+                    on_rows(fetched)
+
+            if columns:
+                varbinds.update(await self._bulk_walk_columns(
+                    client, columns, max_repetitions, max_pdu_varbinds, limit=limit, on_rows=on_rows
+                ))
 
-                # Extract all results from the bulk response
-                for result_oid, value in bulk_results.items():
-                    name_str = self.mib_service.translate_oid(f".{result_oid}") or str(result_oid)
-                    result[name_str] = self._format_value(value)
+            result.update(self._format_varbinds(varbinds))
 
         except Exception as e:
+            if _bulk_unsupported(e):
+                logger.warning(f"GETBULK failed ({e}), falling back to GET and WALK")
+                return await self._bulk_fallback(client, scalars, columns, on_rows=on_rows)
             logger.error(f"Error in BULK: {e}")
-            result["error"] = str(e)
+            result.update(error=str(e), error_code=SNMP_ERROR)
+
+        return result
+
+    async def _bulk_fallback(self, client: Client, scalars: List[str], columns: List[str],
+                             on_rows: Optional[Callable[[Dict[str, Any]], None]] = None) -> SNMPData:
+        """Fetch what a failed BULK asked for with GET for the scalars and WALK for the columns"""
+        result = await self._execute_get(client, scalars) if scalars else {}
+        walked = await self._execute_walk(client, columns, on_rows=on_rows) if columns else {}
+        return SNMPData({**result, **walked}, fallback="WALK")
+
+    async def _bulk_walk_columns(self, client: Client, columns: List[str], max_repetitions: int,
+                                 max_pdu_varbinds: int,
+                                 limit: Optional[asyncio.Semaphore] = None,
+                                 on_rows: Optional[Callable[[Dict[str, Any]], None]] = None) -> Dict[str, Any]:
+        """
+        Bulk-walk table columns without exceeding the agent's PDU limit
+
+        Columns are split into chunks so that no response holds more than
+        max_pdu_varbinds varbinds (columns x repetitions), and the chunks advance their
+        walk in rounds, up to SNMP_COLUMN_WALK_CONCURRENCY requests at a time within
+        the per-target connection limit. A chunk the agent still rejects as tooBig is
+        split in half, or asks for half as many rows once it is down to one column,
+        and retried. With on_rows, the new rows of each response are passed on as it
+        arrives.
+
+        Returns:
+            Raw varbinds keyed by numeric OID
+        """
+        repetitions = max(1, min(max_repetitions, max_pdu_varbinds))
+        chunk_size = max(1, max_pdu_varbinds // repetitions)
+
+        # Each chunk is ([[column, cursor], ...], repetitions)
+        chunks = [
+            ([[column, column] for column in columns[start:start + chunk_size]], repetitions)
+            for start in range(0, len(columns), chunk_size)
+        ]
+        varbinds = {}
+
+        fetches = asyncio.Semaphore(max(1, config.snmp.column_walk_concurrency))
+        limit = limit or asyncio.Semaphore(config.snmp.max_connections_per_target)
+
+        async def advance(cursors, chunk_repetitions):
+            """Fetch the next rows of a chunk, returning the chunks still to walk"""
+            try:
+                async with fetches, limit:
+                    response = await client.bulkget(
+                        [], [ObjectIdentifier(cursor) for _, cursor in cursors], max_list_size=chunk_repetitions
+                    )
+            except TooBig:
+                logger.warning(f"tooBig from agent for {len(cursors)} columns x {chunk_repetitions} rows, retrying smaller")
+                if len(cursors) > 1:
+                    half = len(cursors) // 2
+                    return [(cursors[:half], chunk_repetitions), (cursors[half:], chunk_repetitions)]
+                if chunk_repetitions > 1:
+                    return [(cursors, chunk_repetitions // 2)]
+                raise
+
+            listing = [(str(row_oid).strip("."), value) for row_oid, value in response.listing.items()]
+            # Agents may return fewer rows than asked to stay under their own limit
+            rows = len(listing) // len(cursors)
+
+            remaining = []
+            arrived = {}
+            for column, cursor in cursors:
+                new_rows = [
+                    (row_oid, value) for row_oid, value in listing
+                    if _in_subtree(row_oid, column) and _oid_key(row_oid) > _oid_key(cursor)
+                ]
+                varbinds.update(new_rows)
+                arrived.update(new_rows)
+
+                # A column has ended once a repetition walked past its subtree
+                if new_rows and len(new_rows) >= rows:
+                    remaining.append([column, max((row_oid for row_oid, _ in new_rows), key=_oid_key)])
+
+            if on_rows and arrived:
+                on_rows(arrived)
+            return [(remaining, chunk_repetitions)] if remaining else []
+
+        while chunks:
+            tasks = [asyncio.ensure_future(advance(cursors, chunk_repetitions)) for cursors, chunk_repetitions in chunks]
+            try:
+                rounds = await asyncio.gather(*tasks)
+            except BaseException:
+                for task in tasks:
+                    task.cancel()
+                raise
+            chunks = [chunk for next_chunks in rounds for chunk in next_chunks]
+
+        # Chunks answer in any order; rows are keyed by OID, so sorting rebuilds the table
+        return dict(sorted(varbinds.items(), key=lambda item: _oid_key(item[0])))
+
+    def enrich_results(self, raw_data: Dict[str, Any]) -> List[SNMPResult]:
+        """
+        Build per-OID results with MIB information from raw SNMP response data
+
+        Args:
+            raw_data: Raw SNMP response data keyed by symbolic name or numeric OID
+
+        Returns:
+            List of results with numeric OID, symbolic name, value and source MIB (or, for
+            enterprise OIDs no MIB defines, the enterprise number and vendor), the object's
+            description and the row's index values for objects of loaded MIBs, the
+            decoded value for OIDs with a built-in decoder, and warnings for MIB
+            information that couldn't be found
+        """
+        results = []
+
+        for key, value in raw_data.items():
+            warnings = []
+            if is_numeric_oid(key):
+                oid = key.lstrip(".")
+                name = self.mib_service.translate_oid(oid)
+                if not name:
+                    warnings.append(f"No MIB information for OID {oid}")
+            else:
+                name = key
+                oid = self.mib_service.resolve_oid(key)
+                if not oid:
+                    warnings.append(f"Name {key} does not resolve to an OID")
+
+            mib = self.mib_service.get_oid_mib(oid) if oid else None
+            if name and oid and not mib:
+                warnings.append(f"No MIB module known for {name}")
+
+            result = SNMPResult(oid=oid, name=name, value=value, mib=mib, warnings=warnings)
+            info = self.mib_service.get_oid_info(oid) if oid and mib else None
+            if info:
+                result.description, result.index = info["description"], info["index"]
+            # Without a MIB, the enterprise number still tells whose object it is
+            enterprise = get_enterprise(oid) if oid and not mib else None
+            if enterprise:
+                result.enterprise_number, result.vendor = enterprise
+            if oid:
+                result.decoded = decode_value(oid, value)
+            results.append(result)
+
+        return results
+
+    def group_results(self, query: SNMPQuery, results: List[SNMPResult]) -> Dict[str, List[str]]:
+        """
+        Group result names by the requested OID whose subtree they fall under
+
+        Results are deduplicated across overlapping requested subtrees; the grouping
+        restores the per-OID view, listing a shared result under every OID that covers it.
+        """
+        return {
+            oid: [result.name or result.oid for result in results if result.oid and _in_subtree(result.oid, oid)]
+            for oid in self._prepare_oids(query.operation)
+        }
+
+    def table_rows(self, query: SNMPQuery, results: List[SNMPResult]) -> List[TableRow]:
+        """
+        Put the results of walking tables back into rows, grouping the cells by instance
+
+        Each requested OID is taken as a table (or its row object); a cell is named after its
+        column as the MIB defines it, or the column OID if no loaded MIB does, and the row
+        carries the index values of its instance when the table's INDEX is known.
+
+        Returns:
+            The rows of each requested table, in the order their first cell was returned
+        """
+        rows: Dict[Tuple[str, str], TableRow] = {}
+        for oid in self._prepare_oids(query.operation):
+            table = self.mib_service.table_structure(oid)
+            if table is None:
+                continue
+            for result in results:
+                if not result.oid or not result.oid.startswith(table.entry + "."):
+                    continue
+                column, _, instance = result.oid[len(table.entry) + 1:].partition(".")
+                if not instance:
+                    continue
+                row = rows.get((oid, instance))
+                if row is None:
+                    row = rows[(oid, instance)] = TableRow(table=oid, entry=table.entry, instance=instance,
+                                                             index=result.index)
+                column_oid = f"{table.entry}.{column}"
+                row.columns[table.columns.get(column_oid, column_oid)] = result.value
+        return list(rows.values())
+
+    def collect_warnings(self, results: List[SNMPResult], raw_data: Optional[Dict[str, Any]] = None) -> List[str]:
+        """Collect the warnings of enriched results, and of how the data was collected, for the top level of a response"""
+        warnings = [warning for result in results for warning in result.warnings]
+        fallback = used_fallback(raw_data) if raw_data is not None else None
+        if fallback:
+            warnings.append(f"The agent does not support GETBULK; the data was collected with {fallback} instead")
+        age = prior_write_age(raw_data) if raw_data is not None else None
+        if age is not None:
+            warnings.append(f"An identical SET was made {age:.1f}s ago; its result is returned instead of "
+                            f"writing again")
+        return warnings
+
+    def _format_varbinds(self, varbinds: Dict[str, Any]) -> Dict[str, Any]:
+        """
+        Translate and format a set of varbinds from one table walk
+
+        InetAddress values are decoded using the sibling InetAddressType column
+        from the same row when it is part of the walked data.
+        """
+        result = {}
+
+        for oid, value in varbinds.items():
+            formatted = self._format_oid_value(oid, value)
+
+            type_oid = self.mib_service.get_inet_address_type_oid(oid)
+            if type_oid and type_oid in varbinds:
+                decoded = decode_inet_address(self._raw_value(varbinds[type_oid]), self._raw_value(value))
+                if decoded:
+                    formatted = decoded
+
+            name_str = self.mib_service.translate_oid(f".{oid}") or str(oid)
+            result[name_str] = formatted
 
         return result
 
+    def _raw_value(self, value: Any) -> Any:
+        """Unwrap an x690 type into its Python value"""
+        return value.pythonize() if hasattr(value, "pythonize") else value
+
+    def _format_oid_value(self, oid: str, value: Any) -> Any:
+        """Format the value of an OID, rendering DateAndTime values as RFC 3339 timestamps"""
+        if self.mib_service.is_date_and_time(str(oid)):
+            timestamp = decode_date_and_time(self._raw_value(value))
+            if timestamp:
+                return format_timestamp(timestamp)
+        return self._format_value(value)
+
     def _format_value(self, value: Any) -> Any:
         """Format SNMP value into a Python-friendly format"""
         if isinstance(value, bytes):
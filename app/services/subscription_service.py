@@ -0,0 +1,139 @@
+import asyncio
+from typing import Any, AsyncIterator, Dict, Optional
+from loguru import logger
+
+from app.core.config import config, APIKeyPolicy
+from app.models.query import SNMPQuery
+from app.services.operation_service import Operation, OperationCancelled
+from app.services.snmp_service import SNMPService
+
+
+class SubscriptionError(ValueError):
+    """Raised for subscription parameters outside the configured bounds"""
+
+
+def diff_results(old: Dict[str, Any], new: Dict[str, Any]) -> Dict[str, Any]:
+    """
+    Compare two SNMP results.
+
+    Args:
+        old: Previously pushed result
+        new: Latest result
+
+    Returns:
+        {"changed": {key: {"old", "new"}}, "added": {key: value}, "removed": [key]},
+        with only the non-empty parts present; empty if nothing changed
+    """
+    changed = {
+        key: {"old": old[key], "new": value}
+        for key, value in new.items() if key in old and old[key] != value
+    }
+    added = {key: value for key, value in new.items() if key not in old}
+    removed = [key for key in old if key not in new]
+
+    delta = {}
+    if changed:
+        delta["changed"] = changed
+    if added:
+        delta["added"] = added
+    if removed:
+        delta["removed"] = removed
+    return delta
+
+
+class SubscriptionService:
+    def __init__(self, snmp_service: Optional[SNMPService] = None):
+        """Initialize the subscription service"""
+        self.snmp_service = snmp_service or SNMPService()
+
+    def check_bounds(self, interval: Optional[int], lifetime: Optional[int]) -> Dict[str, int]:
+        """
+        Apply defaults to a subscription's interval and lifetime and check their bounds
+
+        Returns:
+            {"interval", "lifetime"} in seconds
+        """
+        interval = config.subscription.default_interval if interval is None else interval
+        lifetime = config.subscription.max_lifetime if lifetime is None else lifetime
+
+        if not config.subscription.min_interval <= interval <= config.subscription.max_interval:
+            raise SubscriptionError(
+                f"Interval must be between {config.subscription.min_interval} and "
+                f"{config.subscription.max_interval} seconds"
+            )
+        if not 0 < lifetime <= config.subscription.max_lifetime:
+            raise SubscriptionError(f"Lifetime must be between 1 and {config.subscription.max_lifetime} seconds")
+
+        return {"interval": interval, "lifetime": lifetime}
+
+    async def subscribe(self, query: SNMPQuery, interval: Optional[int] = None, lifetime: Optional[int] = None,
+                        api_key: Optional[APIKeyPolicy] = None,
+                        operation: Optional[Operation] = None) -> AsyncIterator[Dict[str, Any]]:
+        """
+        Re-run a query periodically and yield an event whenever its result changes
+
+        The first event is a "snapshot" with the full result; after that a "change"
+        event carries only the delta (see diff_results), and an "error" event is
+        pushed when the query starts failing or fails differently, followed by a new
+        snapshot once it recovers. Unchanged polls push nothing. The subscription
+        ends with an "end" event after its lifetime, or when its operation is cancelled.
+
+        Args:
+            query: Structured SNMP query object
+            interval: Seconds between polls
+            lifetime: Seconds before the subscription ends
+            api_key: Policy of the API key making the request, if any
+            operation: Registered operation through which the subscription can be cancelled
+
+        Yields:
+            Events as {"event", "data"}
+        """
+        bounds = self.check_bounds(interval, lifetime)
+        loop = asyncio.get_running_loop()
+        deadline = loop.time() + bounds["lifetime"]
+
+        logger.info(f"Subscribed to {query.target.host} every {bounds['interval']}s for {bounds['lifetime']}s")
+
+        def step(awaitable):
+            return operation.run(awaitable) if operation else awaitable
+
+        try:
+            async for event in self._poll(query, bounds, deadline, api_key, step):
+                yield event
+        except OperationCancelled:
+            logger.info(f"Subscription to {query.target.host} cancelled")
+            yield {"event": "end", "data": {"reason": "Subscription cancelled"}}
+            return
+
+        yield {"event": "end", "data": {"reason": "Subscription lifetime reached"}}
+
+    async def _poll(self, query: SNMPQuery, bounds: Dict[str, int], deadline: float,
+                    api_key: Optional[APIKeyPolicy], step) -> AsyncIterator[Dict[str, Any]]:
+        """Poll a query until the deadline, yielding its snapshot, change and error events"""
+        loop = asyncio.get_running_loop()
+        last_result: Optional[Dict[str, Any]] = None
+        last_error: Optional[str] = None
+
+        while True:
+            result = await step(self.snmp_service.execute_query(query, api_key=api_key))
+
+            if "error" in result:
+                if result["error"] != last_error:
+                    last_error = result["error"]
+                    yield {"event": "error", "data": {"error": last_error}}
+            elif last_result is None or last_error is not None:
+                # First result, or the query recovered: send it in full
+                last_result, last_error = result, None
+                yield {"event": "snapshot", "data": result}
+            else:
+                delta = diff_results(last_result, result)
+                if delta:
+                    last_result = result
+                    yield {"event": "change", "data": delta}
+
+            remaining = deadline - loop.time()
+            if remaining <= 0:
+                break
+            await step(asyncio.sleep(min(bounds["interval"], remaining)))
+            if loop.time() >= deadline:
+                break
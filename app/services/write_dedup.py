@@ -0,0 +1,95 @@
+import asyncio
+import time
+from typing import Any, Awaitable, Callable, Dict, Hashable, Optional, Tuple
+
+from app.models.query import SNMPQuery
+from app.services.mib_service import normalize_oid
+
+
+class PriorWrite(dict):
+    """Result of an identical write made shortly before, returned instead of writing again"""
+
+    def __init__(self, data: Optional[Dict[str, Any]] = None, age: float = 0.0):
+        super().__init__(data or {})
+        self.age = age
+
+
+def prior_write_age(data: Dict[str, Any]) -> Optional[float]:
+    """Seconds since the identical write whose result SNMP response data repeats, if it does"""
+    return data.age if isinstance(data, PriorWrite) else None
+
+
+def write_key(identity: Optional[str], query: SNMPQuery,
+              resolve: Optional[Callable[[str], Optional[str]]] = None) -> Tuple:
+    """
+    What makes two writes identical: who makes them, their target and the values they write
+
+    OIDs are compared resolved, so sysName.0 and 1.3.6.1.2.1.1.5.0 are the same object.
+
+    Args:
+        identity: Identity of the API key making the write, if any
+        query: The SET query
+        resolve: Resolves an OID or name to a numeric OID, or None if it can't
+    """
+    values = sorted(
+        ((resolve(value.oid) if resolve else None) or normalize_oid(value.oid), value.type or "", str(value.value))
+        for value in query.operation.set_values
+    )
+    return identity, query.target.host, query.target.port, tuple(values)
+
+
+class _RecentWrite:
+    def __init__(self, started: float):
+        self.started = started
+        self.result: "asyncio.Future[Optional[Dict[str, Any]]]" = asyncio.get_running_loop().create_future()
+
+
+class WriteDeduplicator:
+    """
+    Identical writes made within a short window, sent only once
+
+    A write identical to one still in flight, or started less than window seconds
+    before, gets that write's result instead of being sent again, e.g. after a
+    double-click. Writes that fail are not remembered, so retrying them goes through;
+    a duplicate that waited for a write that failed is made itself.
+    """
+
+    def __init__(self, window: float):
+        self.window = window
+        self._writes: Dict[Hashable, _RecentWrite] = {}
+
+    async def run(self, key: Hashable, write: Callable[[], Awaitable[Dict[str, Any]]],
+                  now: Optional[float] = None) -> Dict[str, Any]:
+        """
+        Make a write, unless an identical one was made within the window
+
+        Args:
+            key: What identifies the write, see write_key
+            write: Makes the write, returning its SNMP response data
+            now: time.monotonic() of the write, if not now
+
+        Returns:
+            The write's response data, or a PriorWrite with that of the identical write
+        """
+        now = time.monotonic() if now is None else now
+        for other, recent in list(self._writes.items()):
+            if recent.result.done() and now - recent.started >= self.window:
+                del self._writes[other]
+
+        recent = self._writes.get(key)
+        if recent is not None:
+            # Shielded, so a duplicate that is cancelled doesn't cancel the write it waits for
+            result = await asyncio.shield(recent.result)
+            # None if the write was cancelled or raised; then, as after a failed write, this one is made after all
+            if result is not None and "error" not in result:
+                return PriorWrite(result, age=max(0.0, now - recent.started))
+
+        recent = self._writes[key] = _RecentWrite(now)
+        result = None
+        try:
+            result = await write()
+            return result
+        finally:
+            if (result is None or "error" in result) and self._writes.get(key) is recent:
+                del self._writes[key]
+            recent.result.set_result(result)
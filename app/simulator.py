@@ -0,0 +1,295 @@
+import asyncio
+import bisect
+import ipaddress
+from typing import Any, Dict, List, Optional, Tuple
+from loguru import logger
+
+from app.models.query import SNMPQuery
+from app.utils.ber import (
+    INTEGER, OCTET_STRING, NULL, OBJECT_IDENTIFIER, SEQUENCE, IP_ADDRESS, COUNTER32, GAUGE32, TIME_TICKS,
+    OPAQUE, COUNTER64, NO_SUCH_OBJECT, NO_SUCH_INSTANCE, END_OF_MIB_VIEW, GET_REQUEST, GET_NEXT_REQUEST,
+    GET_RESPONSE, SET_REQUEST, GET_BULK_REQUEST, INTEGER_TAGS,
+    encode_tlv, encode_integer, encode_oid, encode_sequence, decode_tlv, decode_sequence, decode_integer,
+    decode_oid,
+)
+
+# A simulated object's value: (BER tag, Python value)
+SimulatedValue = Tuple[int, Any]
+
+# SNMP versions as sent on the wire
+VERSION_1 = 0
+VERSION_2C = 1
+
+# Error statuses (RFC 3416)
+NO_SUCH_NAME = 2
+NOT_WRITABLE = 17
+
+# Target name that demo mode points at the simulator
+DEMO_TARGET = "demo"
+
+# Objects served in demo mode unless a data file is given: the system group and a two-row ifTable
+DEMO_DATA: Dict[str, SimulatedValue] = {
+    "1.3.6.1.2.1.1.1.0": (OCTET_STRING, "SNMP-AI demo agent"),
+    "1.3.6.1.2.1.1.2.0": (OBJECT_IDENTIFIER, "1.3.6.1.4.1.8072.3.2.10"),
+    "1.3.6.1.2.1.1.3.0": (TIME_TICKS, 8640000),
+    "1.3.6.1.2.1.1.4.0": (OCTET_STRING, "noc@example.com"),
+    "1.3.6.1.2.1.1.5.0": (OCTET_STRING, "demo-router"),
+    "1.3.6.1.2.1.1.6.0": (OCTET_STRING, "Lab rack 1"),
+    "1.3.6.1.2.1.2.1.0": (INTEGER, 2),
+    "1.3.6.1.2.1.2.2.1.1.1": (INTEGER, 1),
+    "1.3.6.1.2.1.2.2.1.1.2": (INTEGER, 2),
+    "1.3.6.1.2.1.2.2.1.2.1": (OCTET_STRING, "lo"),
+    "1.3.6.1.2.1.2.2.1.2.2": (OCTET_STRING, "eth0"),
+    "1.3.6.1.2.1.2.2.1.3.1": (INTEGER, 24),
+    "1.3.6.1.2.1.2.2.1.3.2": (INTEGER, 6),
+    "1.3.6.1.2.1.2.2.1.5.1": (GAUGE32, 10000000),
+    "1.3.6.1.2.1.2.2.1.5.2": (GAUGE32, 1000000000),
+    "1.3.6.1.2.1.2.2.1.7.1": (INTEGER, 1),
+    "1.3.6.1.2.1.2.2.1.7.2": (INTEGER, 1),
+    "1.3.6.1.2.1.2.2.1.8.1": (INTEGER, 1),
+    "1.3.6.1.2.1.2.2.1.8.2": (INTEGER, 1),
+    "1.3.6.1.2.1.2.2.1.10.1": (COUNTER32, 1048576),
+    "1.3.6.1.2.1.2.2.1.10.2": (COUNTER32, 987654321),
+    "1.3.6.1.2.1.2.2.1.16.1": (COUNTER32, 1048576),
+    "1.3.6.1.2.1.2.2.1.16.2": (COUNTER32, 123456789),
+}
+
+# snmprec type codes (the BER tag in decimal) the loader understands
+_SNMPREC_TYPES = (INTEGER, OCTET_STRING, NULL, OBJECT_IDENTIFIER, IP_ADDRESS, COUNTER32, GAUGE32, TIME_TICKS,
+                  OPAQUE, COUNTER64)
+
+
+def _oid_key(oid: str) -> Tuple[int, ...]:
+    return tuple(int(part) for part in oid.strip(".").split("."))
+
+
+def load_snmprec(path: str) -> Dict[str, SimulatedValue]:
+    """
+    Load simulated objects from an snmpsim-style .snmprec file.
+
+    Each line is "OID|TYPE|VALUE", with TYPE the value's BER tag in decimal
+    (2 INTEGER, 4 OCTET STRING, 5 NULL, 6 OID, 64 IpAddress, 65 Counter32,
+    66 Gauge32, 67 TimeTicks, 68 Opaque, 70 Counter64). A type suffixed with
+    "x" takes a hex-encoded value, e.g. "1.3.6.1.2.1.1.5.0|4x|726f75746572".
+    Blank lines and lines starting with "#" are ignored.
+
+    Args:
+        path: Path to the .snmprec file
+
+    Returns:
+        Simulated value for each OID
+    """
+    data = {}
+    with open(path, "r", encoding="utf-8") as f:
+        for line_number, line in enumerate(f, start=1):
+            line = line.strip()
+            if not line or line.startswith("#"):
+                continue
+            try:
+                oid, type_code, value = line.split("|", 2)
+                _oid_key(oid)
+                data[oid.strip(".")] = _parse_snmprec_value(type_code, value)
+            except ValueError as e:
+                raise ValueError(f"{path}:{line_number}: invalid record: {e}")
+    return data
+
+
+def _parse_snmprec_value(type_code: str, value: str) -> SimulatedValue:
+    hex_encoded = type_code.endswith("x")
+    tag = int(type_code.rstrip("x"))
+    if tag not in _SNMPREC_TYPES:
+        raise ValueError(f"unsupported type {type_code}")
+
+    raw: Any = bytes.fromhex(value) if hex_encoded else value
+    if tag in INTEGER_TAGS:
+        return tag, int(raw)
+    if tag == NULL:
+        return tag, None
+    if tag == IP_ADDRESS:
+        return tag, str(ipaddress.IPv4Address(raw))
+    return tag, raw
+
+
+def _encode_value(tag: int, value: Any) -> bytes:
+    if tag in INTEGER_TAGS:
+        return encode_integer(value, tag)
+    if tag == OBJECT_IDENTIFIER:
+        return encode_oid(value)
+    if tag == IP_ADDRESS:
+        return encode_tlv(tag, ipaddress.IPv4Address(value).packed)
+    if tag in (NULL, NO_SUCH_OBJECT, NO_SUCH_INSTANCE, END_OF_MIB_VIEW):
+        return encode_tlv(tag, b"")
+    return encode_tlv(tag, value.encode("utf-8") if isinstance(value, str) else bytes(value))
+
+
+class SNMPSimulator:
+    """
+    Lightweight SNMP agent serving a fixed set of objects over UDP
+
+    Answers SNMPv1 and v2c GET, GETNEXT and GETBULK requests for the configured
+    objects and rejects SETs as read-only. Like a real agent, it silently drops
+    requests with the wrong community string, and GETBULK requests over v1.
+    """
+
+    def __init__(self, data: Optional[Dict[str, SimulatedValue]] = None, community: str = "public",
+                 max_varbinds: int = 100):
+        """
+        Initialize the simulator
+
+        Args:
+            data: Simulated value for each numeric OID (DEMO_DATA if not given)
+            community: Community string requests must carry
+            max_varbinds: Most varbinds returned in one GETBULK response
+        """
+        self.data = {oid.strip("."): value for oid, value in (DEMO_DATA if data is None else data).items()}
+        self.community = community.encode("utf-8")
+        self.max_varbinds = max_varbinds
+        self._keys = sorted(_oid_key(oid) for oid in self.data)
+        self._transport: Optional[asyncio.DatagramTransport] = None
+
+    async def start(self, host: str = "127.0.0.1", port: int = 0) -> Tuple[str, int]:
+        """
+        Start answering requests on a UDP port
+
+        Args:
+            host: Address to listen on
+            port: Port to listen on (0 picks a free one)
+
+        Returns:
+            The (host, port) the simulator listens on
+        """
+        simulator = self
+
+        class Protocol(asyncio.DatagramProtocol):
+            def connection_made(self, transport: asyncio.DatagramTransport) -> None:
+                self.transport = transport
+
+            def datagram_received(self, message: bytes, address: Tuple[str, int]) -> None:
+                response = simulator.handle(message)
+                if response is not None:
+                    self.transport.sendto(response, address)
+
+        self._transport, _ = await asyncio.get_running_loop().create_datagram_endpoint(
+            Protocol, local_addr=(host, port)
+        )
+        address = self._transport.get_extra_info("sockname")[:2]
+        logger.info(f"SNMP simulator serving {len(self.data)} objects on {address[0]}:{address[1]}")
+        return address
+
+    def close(self) -> None:
+        """Stop answering requests"""
+        if self._transport:
+            self._transport.close()
+            self._transport = None
+
+    def handle(self, message: bytes) -> Optional[bytes]:
+        """
+        Answer an SNMP request message
+
+        Args:
+            message: BER-encoded request
+
+        Returns:
+            BER-encoded response, or None if the request is dropped
+        """
+        try:
+            tag, content, _ = decode_tlv(message)
+            if tag != SEQUENCE:
+                return None
+            (_, version), (_, community), (pdu_type, pdu) = decode_sequence(content)[:3]
+            version = decode_integer(version)
+            request_id, first, second, varbinds = decode_sequence(pdu)
+            request_id = decode_integer(request_id[1])
+            first, second = decode_integer(first[1]), decode_integer(second[1])
+            oids = [decode_oid(decode_sequence(varbind)[0][1]) for _, varbind in decode_sequence(varbinds[1])]
+        except (ValueError, IndexError) as e:
+            logger.debug(f"Simulator dropped a malformed request: {e}")
+            return None
+
+        if version not in (VERSION_1, VERSION_2C) or community != self.community:
+            return None
+
+        error_status, error_index = 0, 0
+        if pdu_type == GET_REQUEST:
+            results = [(oid, self._get(oid)) for oid in oids]
+        elif pdu_type == GET_NEXT_REQUEST:
+            results = [self._get_next(oid) for oid in oids]
+        elif pdu_type == GET_BULK_REQUEST and version == VERSION_2C:
+            results = self._get_bulk(oids, non_repeaters=first, max_repetitions=second)
+        elif pdu_type == SET_REQUEST:
+            results = [(oid, (NULL, None)) for oid in oids]
+            error_status, error_index = (NOT_WRITABLE if version == VERSION_2C else NO_SUCH_NAME), 1
+        else:
+            return None
+
+        if version == VERSION_1:
+            # v1 has no varbind exceptions: the first missing object fails the request
+            for index, (_, (value_tag, _)) in enumerate(results, start=1):
+                if value_tag in (NO_SUCH_OBJECT, NO_SUCH_INSTANCE, END_OF_MIB_VIEW):
+                    results = [(oid, (NULL, None)) for oid in oids]
+                    error_status, error_index = NO_SUCH_NAME, index
+                    break
+
+        response = encode_sequence([
+            encode_integer(request_id),
+            encode_integer(error_status),
+            encode_integer(error_index),
+            encode_sequence([
+                encode_sequence([encode_oid(oid), _encode_value(*value)]) for oid, value in results
+            ]),
+        ], tag=GET_RESPONSE)
+        return encode_sequence([encode_integer(version), encode_tlv(OCTET_STRING, self.community), response])
+
+    def _get(self, oid: str) -> SimulatedValue:
+        if oid in self.data:
+            return self.data[oid]
+        # An instance missing from a known object is noSuchInstance, anything else noSuchObject
+        parent = _oid_key(oid)[:-1]
+        position = bisect.bisect_left(self._keys, parent)
+        if position < len(self._keys) and self._keys[position][:len(parent)] == parent:
+            return NO_SUCH_INSTANCE, None
+        return NO_SUCH_OBJECT, None
+
+    def _get_next(self, oid: str) -> Tuple[str, SimulatedValue]:
+        position = bisect.bisect_right(self._keys, _oid_key(oid))
+        if position == len(self._keys):
+            return oid, (END_OF_MIB_VIEW, None)
+        next_oid = ".".join(str(part) for part in self._keys[position])
+        return next_oid, self.data[next_oid]
+
+    def _get_bulk(self, oids: List[str], non_repeaters: int, max_repetitions: int) -> List[Tuple[str, SimulatedValue]]:
+        non_repeaters = max(0, min(non_repeaters, len(oids)))
+        results = [self._get_next(oid) for oid in oids[:non_repeaters]]
+
+        cursors = oids[non_repeaters:]
+        for _ in range(max(0, max_repetitions)):
+            if not cursors or len(results) + len(cursors) > self.max_varbinds:
+                break
+            row = [self._get_next(cursor) for cursor in cursors]
+            results += row
+            if all(value[0] == END_OF_MIB_VIEW for _, value in row):
+                break
+            cursors = [oid for oid, _ in row]
+
+        return results
+
+
+def demo_target_transform(host: str, port: int):
+    """Build a query transform pointing queries for the "demo" target at a simulator"""
+    def demo_target(query: SNMPQuery) -> SNMPQuery:
+        if query.target.host == DEMO_TARGET:
+            query.target.host = host
+            query.target.port = port
+        return query
+
+    return demo_target
+
+
+async def run_simulator(host: str, port: int, community: str = "public", data_file: Optional[str] = None) -> None:
+    """Run the simulator until interrupted"""
+    simulator = SNMPSimulator(load_snmprec(data_file) if data_file else None, community=community)
+    await simulator.start(host, port)
+    try:
+        await asyncio.Event().wait()
+    finally:
+        simulator.close()
@@ -0,0 +1,997 @@
+import asyncio
+import json
+import pytest
+from unittest.mock import patch, AsyncMock
+from fastapi.testclient import TestClient
+
+from app.api import main
+from app.models.query import (
+    SNMPQuery, SNMPResponse, SNMPTarget, SNMPOperation, SNMPCredentials, SNMPSetValue, Clarification
+)
+from app.utils.cache import clear_cache
+from app.utils.redaction import register_secret, clear_query_secrets
+from app.utils.rate_limit import RateLimiter
+
+
+@pytest.fixture
+def client():
+    """Test client with an empty cache"""
+    clear_cache()
+    yield TestClient(main.app)
+    clear_cache()
+
+
+@pytest.fixture
+def snmp_query():
+    return SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"])
+    )
+
+
+def _summary(raw_data, query, empty_reason=None):
+    return SNMPResponse(raw_data=raw_data, summary="The device is called router1", query=query,
+                        empty_reason=empty_reason)
+
+
+def test_query_etag_not_modified(client, snmp_query):
+    """Test that an unchanged result returns 304 for a matching If-None-Match"""
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value={"SNMPv2-MIB::sysName.0": "router1"})):
+        response = client.post("/query", json="get sysName of 192.168.1.1")
+        assert response.status_code == 200
+        etag = response.headers["ETag"]
+
+        # Served from the cache
+        response = client.post("/query", json="get sysName of 192.168.1.1", headers={"If-None-Match": etag})
+        assert response.status_code == 304
+        assert response.headers["ETag"] == etag
+        assert response.content == b""
+
+        # Re-queried, data unchanged, summary not regenerated
+        main.openai_service.format_response.reset_mock()
+        response = client.post(
+            "/query?skip_cache=true", json="get sysName of 192.168.1.1", headers={"If-None-Match": etag}
+        )
+        assert response.status_code == 304
+        main.openai_service.format_response.assert_not_called()
+
+
+def test_query_cache_missed_after_model_or_prompt_change(client, snmp_query):
+    """Test that a cached response isn't served once the model or the system prompt changes"""
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value={"SNMPv2-MIB::sysName.0": "router1"})):
+        assert not client.post("/query", json="get sysName of 192.168.1.1").json()["cached"]
+        assert client.post("/query", json="get sysName of 192.168.1.1").json()["cached"]
+
+        with patch.object(main.openai_service, "model", "gpt-4o"):
+            assert not client.post("/query", json="get sysName of 192.168.1.1").json()["cached"]
+
+        with patch.object(main.openai_service, "system_prompt", main.openai_service.system_prompt + "\nBe brief."):
+            assert not client.post("/query", json="get sysName of 192.168.1.1").json()["cached"]
+
+        assert main.openai_service.process_query.call_count == 3
+
+
+def test_query_etag_changes_with_data(client, snmp_query):
+    """Test that a changed result returns 200 with a new ETag"""
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value={"SNMPv2-MIB::sysName.0": "router1"})):
+        etag = client.post("/query", json="get sysName of 192.168.1.1").headers["ETag"]
+
+        main.snmp_service.execute_query.return_value = {"SNMPv2-MIB::sysName.0": "router2"}
+        response = client.post(
+            "/query?skip_cache=true", json="get sysName of 192.168.1.1", headers={"If-None-Match": etag}
+        )
+
+        assert response.status_code == 200
+        assert response.headers["ETag"] != etag
+        assert response.json()["raw_data"] == {"SNMPv2-MIB::sysName.0": "router2"}
+
+
+def test_query_etag_differs_per_representation(client, snmp_query):
+    """Test that the same data in another media type or shape has an ETag of its own"""
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value={"SNMPv2-MIB::sysName.0": "router1"})):
+        response = client.post("/query", json="get sysName of 192.168.1.1")
+        etag = response.headers["ETag"]
+        assert response.headers["Vary"] == "Accept"
+
+        msgpack = client.post("/query", json="get sysName of 192.168.1.1", headers={"Accept": "application/msgpack", "If-None-Match": etag})
+        assert msgpack.status_code == 200
+        assert msgpack.headers["ETag"] != etag
+
+        for params in ("include_plan=false", "group_by_oid=true", "timezone=UTC", "download=true&format=csv"):
+            response = client.post(f"/query?{params}", json="get sysName of 192.168.1.1", headers={"If-None-Match": etag})
+            assert response.status_code == 200, params
+            assert response.headers["ETag"] != etag, params
+
+
+def test_query_empty_reason(client, snmp_query):
+    """Test that a query without values says why, and the summary is asked to explain it"""
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value={})):
+        response = client.post("/query", json="get the VLAN table of 192.168.1.1")
+
+    assert response.status_code == 200
+    assert response.json()["results"] == []
+    assert response.json()["empty_reason"] == "no-objects"
+    assert main.openai_service.format_response.call_args.kwargs["empty_reason"] == "no-objects"
+
+
+@pytest.mark.parametrize("oid_style,name", [
+    (None, "ifInOctets.3"),
+    ("module", "IF-MIB::ifInOctets.3"),
+    ("numeric", "1.3.6.1.2.1.2.2.1.10.3"),
+    ("full", "iso.org.dod.internet.mgmt.mib-2.interfaces.ifTable.ifEntry.ifInOctets.3"),
+])
+def test_query_oid_style(client, oid_style, name):
+    """Test that result names and groups are written in the requested OID style, cached or not"""
+    snmp_query = SNMPQuery(
+        target=SNMPTarget(host="10.0.0.1"),
+        operation=SNMPOperation(command="WALK", oids=["1.3.6.1.2.1.2.2.1.10"])
+    )
+    url = "/query?group_by_oid=true" + (f"&oid_style={oid_style}" if oid_style else "")
+
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query",
+                         new=AsyncMock(return_value={"1.3.6.1.2.1.2.2.1.10.3": 1200, "1.3.6.1.4.1.9.9.13.1.1": 7})):
+        for cached in (False, True):
+            body = client.post(url, json="walk ifInOctets on 10.0.0.1").json()
+
+            assert body["cached"] is cached
+            assert [result["oid"] for result in body["results"]] == ["1.3.6.1.2.1.2.2.1.10.3", "1.3.6.1.4.1.9.9.13.1.1"]
+            assert body["results"][0]["name"] == name
+            assert body["groups"] == {"1.3.6.1.2.1.2.2.1.10": [name]}
+
+    assert client.post("/query?oid_style=dotted", json="walk ifInOctets on 10.0.0.1").status_code == 400
+
+
+def test_query_includes_sanitized_plan(client):
+    """Test that the interpreted query is returned with secrets omitted"""
+    snmp_query = SNMPQuery(
+        target=SNMPTarget(host="10.0.0.1"),
+        credentials=SNMPCredentials(version="2c", community="s3cret"),
+        operation=SNMPOperation(command="WALK", oids=["IF-MIB::ifTable"])
+    )
+
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value={"IF-MIB::ifDescr.1": "eth0"})):
+        response = client.post("/query", json="walk ifTable on 10.0.0.1")
+        plan = response.json()["plan"]
+
+        assert response.status_code == 200
+        assert plan["target"]["host"] == "10.0.0.1"
+        assert plan["operation"]["command"] == "WALK"
+        assert plan["operation"]["oids"] == ["IF-MIB::ifTable"]
+        assert plan["credentials"] == {
+            "version": "2c", "username": None, "auth_protocol": None, "priv_protocol": None
+        }
+        assert "s3cret" not in response.text
+
+        # The cached response carries the plan too, unless it is turned off
+        assert client.post("/query", json="walk ifTable on 10.0.0.1").json()["plan"] == plan
+        response = client.post("/query?include_plan=false", json="walk ifTable on 10.0.0.1")
+        assert response.json()["plan"] is None
+
+
+def test_query_debug_timings(client, snmp_query):
+    """Test that debug responses break down the time spent in each pipeline stage"""
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch("app.services.snmp_service.Client") as mock_client, \
+            patch("app.api.main.config.debug", True):
+        mock_client.return_value.get = AsyncMock(return_value=b"router1")
+        response = client.post("/query?debug=true", json="get sysName of 192.168.1.1")
+
+    timings = response.json()["debug"]["timings"]
+    stages = ["interpretation", "validation", "connect", "snmp", "enrichment", "caching"]
+
+    assert response.status_code == 200
+    assert list(timings) == stages + ["total"]
+    assert all(timings[stage] >= 0 for stage in stages)
+    assert sum(timings[stage] for stage in stages) == pytest.approx(timings["total"], abs=0.01)
+
+    # Timings are only collected in debug responses
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query",
+                         new=AsyncMock(return_value={"SNMPv2-MIB::sysName.0": "router1"})) as execute_query:
+        response = client.post("/query?skip_cache=true", json="get sysName of 192.168.1.1")
+
+    assert response.json()["debug"] is None
+    assert execute_query.call_args.kwargs["timer"] is None
+
+
+def test_query_debug_shows_raw_and_enriched_results(client, snmp_query):
+    """Test that debug responses pair each enriched result with the raw value it was built from"""
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query",
+                         new=AsyncMock(return_value={"1.3.6.1.2.1.1.5.0": "router1"})), \
+            patch("app.api.main.config.debug", True):
+        response = client.post("/query?debug=true&skip_cache=true", json="get sysName of 192.168.1.1")
+
+    enrichment = response.json()["debug"]["enrichment"]
+    assert response.status_code == 200
+    assert enrichment == [{
+        "raw": {"key": "1.3.6.1.2.1.1.5.0", "value": "router1"},
+        "enriched": response.json()["results"][0],
+    }]
+    assert enrichment[0]["enriched"]["name"] == "sysName.0"
+
+    # Only debug responses carry it
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query",
+                         new=AsyncMock(return_value={"1.3.6.1.2.1.1.5.0": "router1"})):
+        response = client.post("/query?skip_cache=true", json="get sysName of 192.168.1.1")
+
+    assert response.json()["debug"] is None
+
+
+def test_query_shows_interpretation_correction(client, snmp_query):
+    """Test that a corrected interpretation is run and the correction attempt is shown in the response"""
+    invalid_query = snmp_query.model_copy(deep=True)
+    invalid_query.operation.oids = ["1.3.6.1.4.1.99999999.1"]
+
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=invalid_query)), \
+            patch.object(main.openai_service, "correct_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query",
+                         new=AsyncMock(return_value={"SNMPv2-MIB::sysName.0": "router1"})) as execute_query, \
+            patch("app.api.main.config.openai.correct_interpretations", True), \
+            patch("app.api.main.config.snmp.validate_oids", True):
+        response = client.post("/query?skip_cache=true", json="get sysName of 192.168.1.1")
+
+    correction = response.json()["correction"]
+    assert response.status_code == 200
+    assert correction["validation_error"].startswith("Unknown OID 1.3.6.1.4.1.99999999.1")
+    assert correction["corrected"] is True
+    assert execute_query.call_args[0][0].operation.oids == snmp_query.operation.oids
+
+
+def test_query_refused_oid_not_corrected(client, snmp_query):
+    """Test that an OID the API key may not read is refused, not swapped by the model for one it may"""
+    correct_query = AsyncMock(return_value=snmp_query)
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "correct_query", new=correct_query), \
+            patch("app.api.main.config.openai.correct_interpretations", True), \
+            patch.object(main.config, "api_keys", {"k1": main.APIKeyPolicy(name="ifs", oid_prefixes=["1.3.6.1.2.1.2"])}):
+        response = client.post("/query?skip_cache=true", json="get sysName of 192.168.1.1", headers={"X-API-Key": "k1"})
+
+    assert "not authorized" in response.text
+    correct_query.assert_not_called()
+
+
+def test_query_timezone(client, snmp_query):
+    """Test that DateAndTime values and the cache time are rendered in the requested timezone"""
+    raw_data = {"1.3.6.1.2.1.25.1.2.0": "2024-03-10T22:30:15+01:00"}
+
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value=raw_data)):
+        response = client.post("/query?timezone=UTC", json="get the system date")
+        assert response.json()["raw_data"] == {"1.3.6.1.2.1.25.1.2.0": "2024-03-10T21:30:15+00:00"}
+        assert response.json()["results"][0]["value"] == "2024-03-10T21:30:15+00:00"
+
+        # Served from the cache, which keeps the agent's own offset
+        response = client.post("/query", params={"timezone": "+05:30"}, json="get the system date")
+        assert response.json()["cached_at"].endswith("+05:30")
+        assert response.json()["raw_data"] == {"1.3.6.1.2.1.25.1.2.0": "2024-03-11T02:00:15+05:30"}
+
+        response = client.post("/query", params={"time_format": "unix"}, json="get the system date")
+        assert response.json()["raw_data"] == {"1.3.6.1.2.1.25.1.2.0": 1710106215.0}
+
+        assert client.post("/query", json="get the system date").json()["raw_data"] == raw_data
+        assert client.post("/query?timezone=Mars/Olympus", json="get the system date").status_code == 400
+
+
+def test_query_computed_fields(client, snmp_query):
+    """Test that computed fields are returned per index and unsafe expressions are rejected"""
+    raw_data = {"IF-MIB::ifInOctets.1": 1250000, "IF-MIB::ifSpeed.1": 100000000}
+
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value=raw_data)):
+        response = client.post("/query", params={"compute": "bps=ifInOctets*8"}, json="get if counters")
+        assert response.json()["computed"] == {"bps.1": 10000000}
+
+        response = client.post("/query", params={"compute": "bps=__import__('os')"}, json="get if counters")
+        assert response.status_code == 400
+
+
+def test_query_value_filters(client, snmp_query):
+    """Test that value filters drop results, from live and cached responses alike"""
+    raw_data = {"IF-MIB::ifInErrors.1": 0, "IF-MIB::ifInErrors.2": 7, "IF-MIB::ifInErrors.3": "n/a"}
+
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value=raw_data)):
+        for _ in range(2):
+            body = client.post("/query", params={"where": "ifInErrors>0"}, json="get if errors").json()
+            assert [result["name"] for result in body["results"]] == ["IF-MIB::ifInErrors.2"]
+            assert body["raw_data"] == {"IF-MIB::ifInErrors.2": 7}
+        assert body["cached"] is True
+
+        body = client.post("/query", params={"where": "ifInErrors>100"}, json="get if errors").json()
+        assert body["results"] == []
+        assert body["empty_reason"] == "all-filtered"
+
+        # The cached response itself is unfiltered
+        assert client.post("/query", json="get if errors").json()["raw_data"] == raw_data
+
+        response = client.post("/query", params={"where": "ifInErrors~0"}, json="get if errors")
+        assert response.status_code == 400
+
+
+def test_maintenance_mode(client, snmp_query):
+    """Test that the maintenance mode is switched at runtime, shown by the health check and rejects queries"""
+    try:
+        response = client.put("/maintenance", json={"mode": "all"})
+        assert response.json()["mode"] == "all"
+        assert client.get("/").json()["maintenance_mode"] == "all"
+        assert client.put("/maintenance", json={"mode": "closed"}).status_code == 400
+
+        with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)):
+            response = client.post("/query", json="get sysName of 192.168.1.1")
+        assert response.status_code == 503
+        assert response.json()["error_code"] == "SERVICE_READ_ONLY"
+
+        with patch.object(main.config, "api_keys", {"k1": main.APIKeyPolicy(name="ifs", oid_prefixes=["1.3.6.1.2.1.2"])}):
+            response = client.put("/maintenance", json={"mode": "off"}, headers={"X-API-Key": "k1"})
+        assert response.status_code == 403
+    finally:
+        main.snmp_service.set_maintenance_mode("off")
+
+    assert client.get("/maintenance").json()["mode"] == "off"
+
+
+def test_mib_download(client, tmp_path):
+    """Test that MIB downloads need a repository and valid names, and report their progress"""
+    from app.services.mib_repository import MIBSource
+
+    class Source(MIBSource):
+        async def fetch(self, module):
+            return f"{module} DEFINITIONS ::= BEGIN END".encode()
+
+    with patch.object(main, "default_source", return_value=None):
+        assert client.post("/mibs/download", json={"modules": ["IF-MIB"]}).status_code == 503
+
+    with patch.object(main, "default_source", return_value=Source()), \
+            patch.object(main.mib_service, "mib_dir", str(tmp_path)):
+        assert client.post("/mibs/download", json={"modules": ["../IF-MIB"]}).status_code == 400
+        with patch.object(main.config, "api_keys", {"k1": main.APIKeyPolicy(name="ifs", oid_prefixes=["1.3.6.1.2.1.2"])}):
+            response = client.post("/mibs/download", json={"modules": ["IF-MIB"]}, headers={"X-API-Key": "k1"})
+        assert response.status_code == 403
+
+        response = client.post("/mibs/download", json={"modules": ["IF-MIB", "IP-MIB"]})
+        assert response.status_code == 202
+        assert response.json()["operation_id"]
+        assert response.json()["total"] == 2
+
+        progress = client.get("/mibs/download").json()
+        assert progress["total"] == 2
+        assert progress["status"] in ("running", "completed")
+
+
+
+def test_restricted_key_cant_manage_shared_state(client):
+    """Test that a key restricted to OID subtrees can't read or stop poll targets, or clear the cache"""
+    with patch.object(main.config, "api_keys", {"k1": main.APIKeyPolicy(name="ifs", oid_prefixes=["1.3.6.1.2.1.2"])}), \
+            patch.object(main.poller_service, "remove_target") as remove_target, \
+            patch.object(main, "clear_cache") as clear:
+        headers = {"X-API-Key": "k1"}
+        assert client.get("/poller/targets/192.168.1.1", headers=headers).status_code == 403
+        assert client.delete("/poller/targets/192.168.1.1", headers=headers).status_code == 403
+        assert client.post("/clear-cache", headers=headers).status_code == 403
+
+    remove_target.assert_not_called()
+    clear.assert_not_called()
+
+def test_query_session_context(client, snmp_query):
+    """Test that a follow-up in the same session is interpreted with the numbered results of the previous query"""
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value={"SNMPv2-MIB::sysName.0": "router1"})):
+        response = client.post("/query", json="get sysName of 192.168.1.1", headers={"X-Session-ID": "conversation-1"})
+        assert response.headers["X-Session-ID"] == "conversation-1"
+        assert main.openai_service.process_query.call_args.args[1] is None
+
+        client.post("/query", json="get the first one again", headers={"X-Session-ID": "conversation-1"})
+        context = main.openai_service.process_query.call_args.args[1]
+        assert "1. SNMPv2-MIB::sysName.0 = 1.3.6.1.2.1.1.5.0" in context
+        assert "192.168.1.1" in context
+
+        client.post("/query", json="get the first one again")
+        assert main.openai_service.process_query.call_args.args[1] is None
+
+    assert client.delete("/sessions/conversation-1").status_code == 200
+    assert client.delete("/sessions/conversation-1").status_code == 404
+
+
+def test_query_stale_if_error(client, snmp_query):
+    """Test that a failed live query returns the last cached result flagged as stale"""
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value={"SNMPv2-MIB::sysName.0": "router1"})):
+        client.post("/query", json="get sysName of 192.168.1.1")
+
+        main.snmp_service.execute_query.return_value = {"error": "SNMP request timed out"}
+
+        # Without the option the error is returned
+        response = client.post("/query?skip_cache=true", json="get sysName of 192.168.1.1")
+        assert response.status_code == 502
+        assert response.json()["detail"] == "SNMP request timed out"
+
+        response = client.post("/query?skip_cache=true&stale_if_error=true", json="get sysName of 192.168.1.1")
+        body = response.json()
+        assert response.status_code == 200
+        assert body["error"] is None
+        assert body["stale"] is True
+        assert body["age"] >= 0
+        assert body["raw_data"] == {"SNMPv2-MIB::sysName.0": "router1"}
+
+        # The failing host is negatively cached and not retried
+        main.snmp_service.execute_query.reset_mock()
+        response = client.post("/query?skip_cache=true&stale_if_error=true", json="get sysName of 192.168.1.1")
+        assert response.json()["stale"] is True
+        main.snmp_service.execute_query.assert_not_called()
+
+        # Another agent on the same host is still queried
+        snmp_query.target.port = 1161
+        response = client.post("/query?skip_cache=true&stale_if_error=true", json="get sysName of 192.168.1.1")
+        main.snmp_service.execute_query.assert_called_once()
+
+
+def test_query_stale_if_error_without_cached_result(client, snmp_query):
+    """Test that the error is returned when there is no result to fall back to"""
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value={"error": "SNMP request timed out"})):
+        response = client.post("/query?stale_if_error=true", json="get sysName of 192.168.1.1")
+
+        assert response.status_code == 502
+        assert response.json()["detail"] == "SNMP request timed out"
+
+
+def test_query_operation_id(client, snmp_query):
+    """Test that queries report their operation ID and unknown operations can't be cancelled"""
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value={"1.3.6.1.2.1.1.5.0": "router1"})), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)):
+        response = client.post("/query", json="get sysName of 192.168.1.1", headers={"X-Operation-ID": "op-1"})
+
+    assert response.headers["X-Operation-ID"] == "op-1"
+    assert client.get("/operations").json() == {"operations": []}
+    assert client.delete("/operations/op-1").status_code == 404
+
+
+def test_query_request_timeout(client, snmp_query):
+    """Test that a query past the request timeout returns REQUEST_TIMEOUT and its slow stage is cancelled"""
+    stage = {"finished": False, "cancelled": False}
+
+    async def slow_execute(query, api_key=None, timer=None, effective=None):
+        try:
+            await asyncio.sleep(5)
+            stage["finished"] = True
+        except asyncio.CancelledError:
+            stage["cancelled"] = True
+            raise
+        return {"1.3.6.1.2.1.1.5.0": "router1"}
+
+    with patch.object(main.config, "request_timeout", 0.2), \
+            patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.snmp_service, "execute_query", new=slow_execute):
+        response = client.post("/query", json="get sysName of 192.168.1.1")
+
+    assert response.status_code == 504
+    assert response.json()["error_code"] == "REQUEST_TIMEOUT"
+    assert response.json()["type"] == "/problems/request-timeout"
+    assert stage == {"finished": False, "cancelled": True}
+    assert client.get("/operations").json() == {"operations": []}
+
+
+def test_query_large_result_streamed(client, snmp_query):
+    """Test that a response with many results is streamed as the same JSON document"""
+    walk = {f"1.3.6.1.2.1.2.2.1.10.{index}": index for index in range(1, 51)}
+    with patch.object(main.config, "stream_json_threshold", 10), \
+            patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value=walk)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)):
+        response = client.post("/query", json="walk ifInOctets on 192.168.1.1")
+
+    assert response.status_code == 200
+    assert response.headers["content-type"] == "application/json"
+    assert "content-length" not in response.headers
+    body = response.json()
+    assert len(body["results"]) == 50
+    assert body["raw_data"] == walk
+    assert body["summary"] == "The device is called router1"
+
+
+def test_query_stream_events(client, snmp_query):
+    """Test that streamed rows are pushed as result events, closed by an end event with the count and duration"""
+    walk = snmp_query.model_copy(update={"operation": SNMPOperation(command="WALK", oids=["1.3.6.1.2.1.2.2.1.10"])})
+
+    async def execute_query(query, api_key=None, on_rows=None):
+        on_rows({"1.3.6.1.2.1.2.2.1.10.1": 100})
+        on_rows({"1.3.6.1.2.1.2.2.1.10.2": 200})
+        return {"1.3.6.1.2.1.2.2.1.10.1": 100, "1.3.6.1.2.1.2.2.1.10.2": 200, "1.3.6.1.2.1.2.2.1.10.3_error": "Error: boom"}
+
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=walk)), \
+            patch.object(main.snmp_service, "execute_query", new=execute_query):
+        response = client.get("/query/stream?query=walk ifInOctets on 192.168.1.1")
+
+    assert response.status_code == 200
+    assert response.headers["content-type"].startswith("text/event-stream")
+    events = [
+        (block.split("\n")[0][len("event: "):], json.loads(block.split("\n")[1][len("data: "):]))
+        for block in response.text.strip().split("\n\n")
+    ]
+    assert [name for name, _ in events] == ["operation", "result", "result", "result", "end"]
+    assert events[0][1]["id"] == response.headers["X-Operation-ID"]
+    assert [data["value"] for _, data in events[1:3]] == [100, 200]
+    assert events[-1][1]["count"] == 3
+    assert events[-1][1]["duration_ms"] >= 0
+    assert client.get("/operations").json() == {"operations": []}
+
+
+
+def test_query_stream_never_writes(client, snmp_query):
+    """Test that a streamed query interpreted as a SET is rejected before anything is sent"""
+    write = snmp_query.model_copy(update={"operation": SNMPOperation(
+        command="SET", set_values=[SNMPSetValue(oid="SNMPv2-MIB::sysLocation.0", value="rack 4")]
+    )})
+
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=write)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock()) as execute_query, \
+            patch.object(main.config.snmp, "allow_set", True):
+        response = client.get("/query/stream?query=set sysLocation on 192.168.1.1 to rack 4")
+
+    assert response.status_code == 400
+    assert "SET can't be streamed" in response.text
+    execute_query.assert_not_called()
+    assert client.get("/operations").json() == {"operations": []}
+
+def test_query_stream_interpretation_events(client, snmp_query):
+    """Test that the model's answer is streamed before the plan and results, and its failures are error events"""
+    async def interpret(query, validate=None, context=None, on_delta=None, api_key=None):
+        on_delta('{"target": ')
+        on_delta('{"host": "192.168.1.1"}}')
+        return snmp_query, None
+
+    def parse(response):
+        return [
+            (block.split("\n")[0][len("event: "):], json.loads(block.split("\n")[1][len("data: "):]))
+            for block in response.text.strip().split("\n\n")
+        ]
+
+    with patch.object(main.openai_service, "interpret", new=interpret), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value={"1.3.6.1.2.1.1.5.0": "r1"})):
+        response = client.get("/query/stream?query=get sysName of 192.168.1.1&interpretation=true")
+    with patch.object(main.openai_service, "interpret", new=AsyncMock(return_value=(None, None))):
+        failed = client.get("/query/stream?query=make coffee&interpretation=true")
+
+    events = parse(response)
+    assert [name for name, _ in events] == ["operation", "interpretation", "interpretation", "plan", "result", "end"]
+    assert "".join(data["delta"] for _, data in events[1:3]) == '{"target": {"host": "192.168.1.1"}}'
+    assert events[3][1]["target"]["host"] == "192.168.1.1"
+    assert "community" not in events[3][1]["credentials"]
+    # Failures after the stream started can't change its status
+    assert failed.status_code == 200
+    assert [(name, data.get("error_code")) for name, data in parse(failed)] == [
+        ("operation", None), ("error", "QUERY_NOT_UNDERSTOOD"), ("end", None)
+    ]
+
+
+def test_query_dry_run(client, snmp_query):
+    """Test that a dry run returns the plan and cost estimate without running the query"""
+    execute = AsyncMock(return_value={"1.3.6.1.2.1.1.5.0": "router1"})
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.snmp_service, "execute_query", new=execute):
+        response = client.post("/query?dry_run=true", json="get sysName of 192.168.1.1")
+        multi = client.post("/query/multi", json={
+            "query": "get sysName", "targets": ["10.0.0.1", "10.0.0.2"], "dry_run": True
+        })
+
+    execute.assert_not_called()
+    body = response.json()
+    assert response.status_code == 200
+    assert body["results"] == [] and body["error"] is None
+    assert body["plan"]["operation"]["command"] == "GET"
+    assert body["estimate"]["snmp_round_trips"] == 1
+    assert multi.json()["estimate"]["snmp_round_trips"] == 2
+    assert multi.json()["estimate"]["targets"] == 2
+
+    # An invalid query is rejected the same way by both
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.snmp_service, "validate_query", return_value="Invalid OID: 1.3.x"):
+        response = client.post("/query?dry_run=true", json="get sysName of 192.168.1.1")
+        multi = client.post("/query/multi", json={
+            "query": "get sysName", "targets": ["10.0.0.1", "10.0.0.2"], "dry_run": True
+        })
+
+    assert response.status_code == multi.status_code == 400
+    assert response.json()["error_code"] == multi.json()["error_code"] == "INVALID_QUERY"
+
+
+def test_plan_edit_execute(client, snmp_query):
+    """Test that a plan is returned with an edit token, and the edited plan is validated and run"""
+    snmp_query.credentials.community = "s3cret"
+    execute = AsyncMock(return_value={"1.3.6.1.2.1.1.6.0": "lab"})
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query", new=execute):
+        planned = client.post("/plan", json="get sysName of 192.168.1.1").json()
+        execute.assert_not_called()
+        assert planned["plan"]["operation"]["oids"] == ["1.3.6.1.2.1.1.5.0"]
+        assert planned["error"] is None
+        assert "s3cret" not in str(planned)
+
+        # Edit the plan: ask for sysLocation instead
+        plan = planned["plan"]
+        plan["operation"]["oids"] = ["1.3.6.1.2.1.1.6.0"]
+        response = client.post("/execute", json={"plan_token": planned["plan_token"], "plan": plan})
+
+        assert response.status_code == 200
+        assert response.json()["results"][0]["value"] == "lab"
+        assert response.json()["plan"]["operation"]["oids"] == ["1.3.6.1.2.1.1.6.0"]
+        executed = execute.call_args[0][0]
+        assert executed.operation.oids == ["1.3.6.1.2.1.1.6.0"]
+        assert executed.credentials.community == "s3cret"
+
+        # The edited plan is validated like an interpreted one
+        plan["operation"]["index_from"] = 1
+        response = client.post("/execute", json={"plan_token": planned["plan_token"], "plan": plan})
+        assert response.status_code == 400
+        assert "Index ranges are only supported for WALK" in response.json()["detail"]
+
+        response = client.post("/execute", json={"plan_token": "unknown", "plan": planned["plan"]})
+        assert response.status_code == 404
+        assert execute.call_count == 1
+
+
+def test_error_detail_secrets_scrubbed(client):
+    """Test that registered secrets are scrubbed from error details returned to the client"""
+    register_secret("sk-live-0123456789")
+    failing = AsyncMock(side_effect=RuntimeError("OpenAI rejected key sk-live-0123456789"))
+    with patch.object(main.openai_service, "process_query", new=failing):
+        response = client.post("/query", json="get sysName of 192.168.1.1")
+    clear_query_secrets()
+
+    # The problem's members are scrubbed too, not only a plain string detail
+    assert response.status_code == 500
+    assert "sk-live-0123456789" not in response.text
+    assert "OpenAI rejected key ****" in response.json()["detail"]
+    assert response.json()["error_code"] == "INTERNAL_ERROR"
+
+
+def test_errors_are_problem_details(client, snmp_query):
+    """Test that errors are RFC 7807 problem details with a type per error code and the right status"""
+    auth_failure = {"error": "SNMP authentication failed: unknown user name", "error_code": "SNMP_AUTH_FAILED"}
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value=auth_failure)):
+        failed = client.post("/query", json="get sysName of 192.168.1.1")
+    not_found = client.delete("/operations/op-1")
+    invalid = client.get("/query/subscribe?query=uptime&interval=soon")
+
+    assert failed.status_code == 502
+    assert failed.headers["content-type"] == "application/problem+json"
+    problem = failed.json()
+    assert problem["type"] == "/problems/snmp-auth-failed"
+    assert problem["title"] == "SNMP authentication failed"
+    assert problem["status"] == 502
+    assert problem["detail"] == "SNMP authentication failed: unknown user name"
+    assert problem["instance"].startswith("urn:uuid:")
+    assert problem["error_code"] == "SNMP_AUTH_FAILED"
+    assert problem["query"] == "get sysName of 192.168.1.1"
+
+    assert not_found.status_code == 404
+    assert not_found.headers["content-type"] == "application/problem+json"
+    assert not_found.json()["type"] == "/problems/not-found"
+    assert not_found.json()["detail"] == "No running operation op-1"
+    assert not_found.json()["instance"] != problem["instance"]
+
+    assert invalid.status_code == 422
+    assert invalid.json()["type"] == "/problems/invalid-parameters"
+    assert invalid.json()["errors"][0]["loc"] == ["query", "interval"]
+
+    assert client.get("/problems/snmp-auth-failed").json()["status"] == 502
+    assert client.get("/problems/unknown").status_code == 404
+
+
+def test_error_codes_tell_failures_apart(client, snmp_query):
+    """Test that an unreachable device, an uninterpretable query and a plain HTTP error each carry their own code"""
+    unreachable = {"error": "Connection refused. Verify the device is reachable and SNMP is enabled",
+                   "error_code": "SNMP_UNREACHABLE"}
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value=unreachable)):
+        failed = client.post("/query", json="get sysName of 192.168.1.1")
+    with patch.object(main.openai_service, "interpret", new=AsyncMock(return_value=(None, None))):
+        not_understood = client.post("/query/multi", json={"query": "make coffee", "targets": ["10.0.0.1"]})
+        with patch.object(main.config, "error_format", "legacy"):
+            legacy = client.post("/query/multi", json={"query": "make coffee", "targets": ["10.0.0.1"]})
+
+    assert failed.status_code == 502
+    assert failed.json()["error_code"] == "SNMP_UNREACHABLE"
+    assert not_understood.status_code == 400
+    assert not_understood.json()["error_code"] == "QUERY_NOT_UNDERSTOOD"
+    assert legacy.json() == {"detail": "Failed to parse query"}
+    assert client.delete("/operations/op-1").json()["error_code"] == "NOT_FOUND"
+    assert client.post("/oid/info", json="1.3.6.1.99999").json()["error_code"] == "OID_NOT_FOUND"
+
+
+def test_clarification_problem_details(client):
+    """Test that a clarification is an extension member of a needs-clarification problem"""
+    clarification = main.ClarificationNeeded(Clarification(missing=["target.host"], questions=["Which device?"]))
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(side_effect=clarification)):
+        response = client.post("/query/multi", json={"query": "get stats", "targets": ["10.0.0.1"]})
+
+    assert response.status_code == 422
+    problem = response.json()
+    assert problem["type"] == "/problems/needs-clarification"
+    assert problem["detail"] == "Query needs clarification"
+    assert problem["error_code"] == "NEEDS_CLARIFICATION"
+    assert problem["clarification"]["missing"] == ["target.host"]
+
+
+@pytest.mark.parametrize("error,status,error_code", [
+    (main.QueryRejectedError("writes are not allowed"), 400, "QUERY_REJECTED"),
+    (main.UnresolvedNameError("acmeFanSpeed", "ACME-MIB"), 404, "UNKNOWN_OID_NAME"),
+    (main.LLMResponseError("The model's answer was not valid JSON"), 502, "LLM_BAD_RESPONSE"),
+])
+def test_interpretation_errors_same_on_every_endpoint(client, error, status, error_code):
+    """Test that the query endpoints answer a failed interpretation with the same problem"""
+    with patch.object(main.openai_service, "interpret", new=AsyncMock(side_effect=error)):
+        responses = [
+            client.post("/query", json="get acmeFanSpeed of 10.0.0.1"),
+            client.post("/query/multi", json={"query": "get acmeFanSpeed", "targets": ["10.0.0.1"]}),
+            client.post("/plan", json="get acmeFanSpeed of 10.0.0.1"),
+            client.get("/query/metrics?query=get acmeFanSpeed of 10.0.0.1"),
+        ]
+
+    assert {response.status_code for response in responses} == {status}
+    assert {response.json()["error_code"] for response in responses} == {error_code}
+    assert len({response.json()["detail"] for response in responses}) == 1
+
+
+def test_llm_errors_are_distinct_problems(client):
+    """Test that an LLM rate limit is a 429 with Retry-After, and a malformed answer a 502, not a parse failure"""
+    rate_limited = main.LLMRateLimited("The OpenAI API is rate limiting requests, try again later", retry_after=6.5)
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(side_effect=rate_limited)):
+        response = client.post("/query", json="get sysName of 192.168.1.1")
+
+    assert response.status_code == 429
+    assert response.headers["Retry-After"] == "7"
+    assert response.json()["type"] == "/problems/llm-rate-limited"
+    assert response.json()["error_code"] == "LLM_RATE_LIMITED"
+
+    malformed = main.LLMResponseError("The model returned malformed JSON (Expecting value: line 1 column 2)")
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(side_effect=malformed)):
+        response = client.post("/query", json="get sysName of 192.168.1.1")
+
+    assert response.status_code == 502
+    assert response.json()["error_code"] == "LLM_BAD_RESPONSE"
+    assert "malformed JSON" in response.json()["detail"]
+
+
+def test_oid_names_resolved_before_querying(client, snmp_query):
+    """Test that names resolve on GET /oids/{name}, and a query naming an unknown object is a 404 sent nowhere"""
+    assert client.get("/oids/ifInOctets").json() == {"name": "ifInOctets", "oid": "1.3.6.1.2.1.2.2.1.10"}
+
+    response = client.get("/oids/hrStorageUsed")
+    assert response.status_code == 404
+    assert response.json()["suggested_mib"] == "HOST-RESOURCES-MIB"
+
+    unknown = snmp_query.model_copy(update={"operation": SNMPOperation(command="WALK", oids=["hrStorageUsed"])})
+    execute_query = AsyncMock(return_value={})
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=unknown)), \
+            patch.object(main.snmp_service, "execute_query", new=execute_query):
+        response = client.post("/query", json="walk hrStorageUsed on 192.168.1.1")
+
+    assert response.status_code == 404
+    assert response.json()["type"] == "/problems/not-found"
+    assert response.json()["error_code"] == "UNKNOWN_OID_NAME"
+    assert response.json()["name"] == "hrStorageUsed"
+    execute_query.assert_not_called()
+
+
+def test_legacy_error_format(client, snmp_query):
+    """Test that API_ERROR_FORMAT=legacy keeps the previous error bodies"""
+    with patch.object(main.config, "error_format", "legacy"), \
+            patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value={"error": "SNMP request timed out"})):
+        failed = client.post("/query", json="get sysName of 192.168.1.1")
+        not_found = client.delete("/operations/op-1")
+
+    assert failed.status_code == 200
+    assert failed.json()["error"] == "SNMP request timed out"
+    assert not_found.status_code == 404
+    assert not_found.json() == {"detail": "No running operation op-1"}
+
+
+@pytest.mark.parametrize("export_format,media_type", [("csv", "text/csv"), ("json", "application/json")])
+def test_query_download(client, snmp_query, export_format, media_type):
+    """Test that downloads are attachments named after the target, in the requested format"""
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value={"1.3.6.1.2.1.1.5.0": "router1"})), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)):
+        response = client.post(f"/query?download=true&format={export_format}", json="get sysName of 192.168.1.1")
+
+        # Served from the cache the same way
+        cached = client.post(f"/query?download=true&format={export_format}", json="get sysName of 192.168.1.1")
+
+    for response in (response, cached):
+        assert response.status_code == 200
+        assert response.headers["content-type"].startswith(media_type)
+        disposition = response.headers["content-disposition"]
+        assert disposition.startswith('attachment; filename="snmp-192.168.1.1-')
+        assert disposition.endswith(f'.{export_format}"')
+        assert "router1" in response.text
+
+
+def test_query_download_unknown_format(client):
+    """Test that an unsupported download format is rejected"""
+    response = client.post("/query?download=true&format=xlsx", json="get sysName of 192.168.1.1")
+
+    assert response.status_code == 400
+
+
+def test_target_stats(client):
+    """Test that the recorded outcomes of each target are reported, least reliable first"""
+    stats = main.snmp_service.target_stats
+    stats.clear()
+    stats.record("10.0.0.1:161", 0.02)
+    stats.record("10.0.0.2:161", 5.0, "SNMP request timed out")
+    try:
+        response = client.get("/targets/stats")
+    finally:
+        stats.clear()
+
+    assert response.status_code == 200
+    body = response.json()
+    assert body["count"] == 2
+    assert [item["target"] for item in body["targets"]] == ["10.0.0.2:161", "10.0.0.1:161"]
+    assert body["targets"][0]["last_error"] == "SNMP request timed out"
+    assert body["targets"][1]["latency_p50_ms"] == 20.0
+
+
+def test_query_device_cached_apart(client, snmp_query):
+    """Test that responses with the device details are cached apart from those without"""
+    identify = AsyncMock(return_value={"vendor": "Cisco", "model": "C2960"})
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value={"SNMPv2-MIB::sysName.0": "router1"})), \
+            patch.object(main.device_service, "identify", new=identify):
+        with_device = client.post("/query?include_device=true", json="get sysName of 192.168.1.1").json()
+        plain = client.post("/query", json="get sysName of 192.168.1.1").json()
+        cached = client.post("/query?include_device=true", json="get sysName of 192.168.1.1").json()
+
+    assert with_device["device"] == {"vendor": "Cisco", "model": "C2960"}
+    assert not plain["cached"] and plain["device"] is None
+    assert cached["cached"] and cached["device"] == {"vendor": "Cisco", "model": "C2960"}
+    assert identify.await_count == 1
+
+
+def test_query_interface_labels_cached_apart(client, snmp_query):
+    """Test that results labeled with ifName and ifAlias are cached apart from unlabeled ones"""
+    async def label_results(query, results, api_key=None):
+        for result in results:
+            result.if_name = "Gi0/1"
+
+    label = AsyncMock(side_effect=label_results)
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query", new=AsyncMock(return_value={"IF-MIB::ifDescr.1": "eth0"})), \
+            patch.object(main.interface_service, "label_results", new=label):
+        labeled = client.post("/query?resolve_if_names=true", json="walk ifDescr of 192.168.1.1").json()
+        plain = client.post("/query", json="walk ifDescr of 192.168.1.1").json()
+        cached = client.post("/query?resolve_if_names=true", json="walk ifDescr of 192.168.1.1").json()
+
+    assert labeled["results"][0]["if_name"] == "Gi0/1"
+    assert not plain["cached"] and plain["results"][0]["if_name"] is None
+    assert cached["cached"] and cached["results"][0]["if_name"] == "Gi0/1"
+    assert label.await_count == 1
+
+
+def test_query_too_large_to_cache(client, snmp_query):
+    """Test that a response over CACHE_MAX_ENTRY_SIZE is returned, flagged and queried again next time"""
+    execute = AsyncMock(return_value={f"IF-MIB::ifDescr.{index}": "x" * 100 for index in range(50)})
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query", new=execute), \
+            patch("app.utils.cache.config.cache_max_entry_size", 1000):
+        first = client.post("/query", json="walk ifDescr of 192.168.1.1")
+        second = client.post("/query", json="walk ifDescr of 192.168.1.1")
+
+    assert first.status_code == 200
+    assert first.json()["cache_skipped"] == "entry-too-large"
+    assert not second.json()["cached"]
+    assert execute.await_count == 2
+
+
+def test_query_paginated(client, snmp_query):
+    """Test that a limited query returns pages of its results, the later ones without querying again"""
+    execute = AsyncMock(return_value={f"IF-MIB::ifDescr.{index}": f"eth{index}" for index in range(5)})
+    with patch.object(main.openai_service, "process_query", new=AsyncMock(return_value=snmp_query)), \
+            patch.object(main.openai_service, "format_response", new=AsyncMock(side_effect=_summary)), \
+            patch.object(main.snmp_service, "execute_query", new=execute):
+        first = client.post("/query?limit=2&skip_cache=true", json="walk ifDescr of 192.168.1.1").json()
+        cursor = first["page"]["next_cursor"]
+        second = client.post(f"/query?cursor={cursor}", json="walk ifDescr of 192.168.1.1").json()
+        last = client.post(f"/query?cursor={second['page']['next_cursor']}", json="walk ifDescr of 192.168.1.1")
+        other = client.post(f"/query?cursor={cursor}", json="walk ifName of 192.168.1.1")
+        unknown = client.post("/query?cursor=unknown:2", json="walk ifDescr of 192.168.1.1")
+
+    assert [result["value"] for result in first["results"]] == ["eth0", "eth1"]
+    assert list(first["raw_data"]) == ["IF-MIB::ifDescr.0", "IF-MIB::ifDescr.1"]
+    assert first["page"]["total"] == 5
+    assert [result["value"] for result in second["results"]] == ["eth2", "eth3"]
+    assert [result["value"] for result in last.json()["results"]] == ["eth4"]
+    assert last.json()["page"]["next_cursor"] is None
+    assert execute.await_count == 1
+    assert other.status_code == 400
+    assert unknown.status_code == 404
+
+
+def test_mib_health(client):
+    """Test that the MIB health summary is returned"""
+    with patch.object(main.mib_service, "health", return_value={"healthy": False, "unresolved_imports": [
+        {"module": "ACME-MIB", "imports_from": "ACME-SMI", "symbols": ["acmeProducts"]}
+    ]}):
+        response = client.get("/mibs/health")
+
+    assert response.status_code == 200
+    assert response.json()["healthy"] is False
+
+
+def test_rate_limited_per_endpoint_class(client):
+    """Test that query and MIB endpoints have limits of their own, per API key when one is sent"""
+    limiters = {
+        "query": RateLimiter(per_minute=1, burst=1, max_clients=100),
+        "mib": RateLimiter(per_minute=60, burst=2, max_clients=100),
+    }
+    with patch.object(main, "endpoint_rate_limiters", limiters), \
+            patch.object(main.config, "api_keys", {"k1": main.APIKeyPolicy(name="netops")}), \
+            patch.object(main.openai_service, "interpret", new=AsyncMock(return_value=(None, None))):
+        queries = [client.post("/query", json="make coffee", headers={"X-API-Key": "k1"})
+                   for _ in range(2)]
+        mibs = [client.get("/mibs", headers={"X-API-Key": "k1"}) for _ in range(3)]
+
+    assert [response.status_code for response in queries] == [400, 429]
+    assert queries[1].headers["Retry-After"] == "60"
+    assert "Too many query requests from API key netops" in queries[1].json()["detail"]
+    assert [response.status_code for response in mibs] == [200, 200, 429]
+
+
+def test_rate_limited_per_key_not_per_name(client):
+    """Test that keys sharing a name have buckets of their own, and /execute shares the query bucket"""
+    keys = {"k1": main.APIKeyPolicy(name="default", digest="d1"), "k2": main.APIKeyPolicy(name="default", digest="d2")}
+    with patch.object(main, "endpoint_rate_limiters", {"query": RateLimiter(per_minute=1, burst=1, max_clients=100)}), \
+            patch.object(main.config, "api_keys", keys), \
+            patch.object(main.openai_service, "interpret", new=AsyncMock(return_value=(None, None))):
+        first = client.post("/query", json="make coffee", headers={"X-API-Key": "k1"})
+        other_key = client.post("/query", json="make coffee", headers={"X-API-Key": "k2"})
+        execute = client.post("/execute", json={"plan_token": "unknown", "plan": {}}, headers={"X-API-Key": "k1"})
+
+    assert first.status_code == 400
+    assert other_key.status_code == 400
+    assert execute.status_code == 429
+
+
+def test_rate_limited_per_ip(client):
+    """Test that a client over its limit gets 429 with Retry-After, however it sets X-Forwarded-For"""
+    with patch.object(main, "ip_rate_limiter", RateLimiter(per_minute=6, burst=2, max_clients=100)):
+        responses = [client.get("/", headers={"X-Forwarded-For": f"198.51.100.{number}"}) for number in range(3)]
+
+    assert [response.status_code for response in responses] == [200, 200, 429]
+    assert responses[2].headers["Retry-After"] == "10"
+    assert responses[2].headers["content-type"] == "application/problem+json"
+    assert responses[2].json()["status"] == 429
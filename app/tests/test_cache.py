@@ -0,0 +1,85 @@
+import time
+import pytest
+from unittest.mock import patch
+
+from app.utils.cache import get_cache, get_cache_entry, get_cache_stats, set_cache, clear_cache
+
+
+@pytest.fixture(autouse=True)
+def empty_cache():
+    """Start every test with an empty cache"""
+    clear_cache()
+    yield
+    clear_cache()
+
+
+def test_cache_entry_includes_timestamp():
+    """Test that cache entries are returned with the time they were cached"""
+    before = time.time()
+    set_cache("query_1", {"summary": "ok"})
+
+    value, cached_at = get_cache_entry("query_1")
+
+    assert value == {"summary": "ok"}
+    assert before <= cached_at <= time.time()
+
+
+def test_max_age_fresh_entry():
+    """Test that an entry younger than max_age is served"""
+    set_cache("query_1", {"summary": "ok"})
+
+    assert get_cache_entry("query_1", max_age=60) is not None
+
+
+def test_max_age_stale_entry():
+    """Test that an entry older than max_age is treated as a miss but not evicted"""
+    with patch("app.utils.cache.time.time", return_value=1000.0):
+        set_cache("query_1", {"summary": "ok"}, ttl=3600)
+
+    with patch("app.utils.cache.time.time", return_value=1030.0):
+        assert get_cache_entry("query_1", max_age=10) is None
+        assert get_cache_entry("query_1", max_age=30) is not None
+
+        # Still available to callers without a freshness requirement
+        assert get_cache("query_1") == {"summary": "ok"}
+
+
+def test_allow_stale_returns_expired_entry():
+    """Test that expired entries set stale_ok are kept for stale_if_error until the stale window ends, others aren't"""
+    with patch("app.utils.cache.time.time", return_value=1000.0), \
+            patch("app.utils.cache.config.cache_stale_ttl", 600):
+        set_cache("query_1", {"summary": "ok"}, ttl=60, stale_ok=True)
+        set_cache("device_1", {"vendor": "Cisco"}, ttl=60)
+
+    with patch("app.utils.cache.time.time", return_value=1100.0), \
+            patch("app.utils.cache.config.cache_stale_ttl", 600):
+        assert get_cache_entry("query_1") is None
+        assert get_cache_entry("query_1", allow_stale=True) == ({"summary": "ok"}, 1000.0)
+        assert get_cache_entry("device_1", allow_stale=True) is None
+        assert get_cache_stats()["total_entries"] == 1
+
+    with patch("app.utils.cache.time.time", return_value=1700.0), \
+            patch("app.utils.cache.config.cache_stale_ttl", 600):
+        assert get_cache_entry("query_1", allow_stale=True) is None
+
+
+def test_entry_over_max_size_not_cached():
+    """Test that values over CACHE_MAX_ENTRY_SIZE are not cached, and replace no older value"""
+    small = {"results": ["x" * 10]}
+    large = {"results": ["x" * 2000]}
+
+    with patch("app.utils.cache.config.cache_max_entry_size", 1000):
+        assert set_cache("query_1", small) is True
+        assert get_cache("query_1") == small
+
+        assert set_cache("query_1", large) is False
+        assert get_cache("query_1") is None
+
+
+def test_entry_size_limit_disabled():
+    """Test that a max entry size of 0 caches values of any size"""
+    large = {"results": ["x" * 2000]}
+
+    with patch("app.utils.cache.config.cache_max_entry_size", 0):
+        assert set_cache("query_1", large) is True
+        assert get_cache("query_1") == large
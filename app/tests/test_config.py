@@ -0,0 +1,57 @@
+import json
+import os
+import re
+from unittest.mock import patch
+
+import pytest
+
+from app.core.config import AppConfig, ConfigError, OpenAIConfig, SNMPConfig, _load_api_keys
+
+
+def _config(**settings) -> AppConfig:
+    """Valid settings with the LLM on, changed by the given ones"""
+    return AppConfig(**{
+        "mib_directory": "./mibs",
+        "interpreter_mode": "hybrid",
+        "snmp": SNMPConfig(default_version="2c"),
+        "openai": OpenAIConfig(provider="openai", api_key="sk-test"),
+        **settings,
+    })
+
+
+def test_validate_settings_accepts_valid_config():
+    """Test that valid settings, and the LLM off without a key, pass validation"""
+    _config().validate_settings()
+    _config(snmp=SNMPConfig(default_version="auto", timeout=0, retries=0)).validate_settings()
+    _config(interpreter_mode="rules", openai=OpenAIConfig(provider="openai", api_key="")).validate_settings()
+    _config(openai=OpenAIConfig(provider="ollama", temperature=2.0)).validate_settings()
+
+
+@pytest.mark.parametrize("settings,message", [
+    ({"snmp": SNMPConfig(default_version="42")}, "SNMP_DEFAULT_VERSION must be one of 1, 2c, 3, auto, not '42'"),
+    ({"snmp": SNMPConfig(timeout=-1)}, "SNMP timeout must not be negative, not -1"),
+    ({"snmp": SNMPConfig(retries=-2)}, "SNMP retries must not be negative, not -2"),
+    ({"snmp": SNMPConfig(fast_timeout=-1)}, "SNMP_FAST_TIMEOUT must not be negative"),
+    ({"snmp": SNMPConfig(walk_deadline=-60)}, "SNMP_WALK_DEADLINE must not be negative"),
+    ({"openai": OpenAIConfig(api_key="sk-test", temperature=5.0)}, "LLM temperature must be between 0 and 2, not 5.0"),
+    ({"openai": OpenAIConfig(api_key="sk-test", temperature=-0.1)}, "LLM temperature must be between 0 and 2"),
+    ({"openai": OpenAIConfig(provider="openai", api_key="")}, "LLM_PROVIDER=openai needs OPENAI_API_KEY"),
+    ({"openai": OpenAIConfig(provider="anthropic", anthropic_api_key="")}, "LLM_PROVIDER=anthropic needs ANTHROPIC_API_KEY"),
+    ({"mib_directory": ""}, "MIB_DIRECTORY must not be empty"),
+    ({"mib_directory": "  "}, "MIB_DIRECTORY must not be empty"),
+])
+def test_validate_settings_rejects_invalid_setting(settings, message):
+    """Test that each invalid setting is refused with a message naming it"""
+    with pytest.raises(ConfigError, match=re.escape(message)):
+        _config(**settings).validate_settings()
+
+
+def test_api_keys_told_apart_by_digest():
+    """Test that keys sharing their default name still have distinct identities, without the key in the clear"""
+    with patch.dict(os.environ, {"API_KEYS": json.dumps({"abcd-one": {}, "abcd-two": {"name": "two", "digest": "x"}})}):
+        keys = _load_api_keys()
+
+    assert keys["abcd-one"].name == "abcd****"
+    assert keys["abcd-two"].digest != "x"
+    assert keys["abcd-one"].identity() != keys["abcd-two"].identity()
+    assert "abcd-one" not in keys["abcd-one"].identity()
@@ -0,0 +1,113 @@
+import time
+from unittest.mock import patch
+
+import pytest
+
+from app.services.cost_service import CostService
+from app.services.snmp_service import SNMPService
+from app.services.mib_service import MIBService
+from app.models.query import SNMPQuery, SNMPTarget, SNMPOperation
+from app.utils.cache import set_cache, clear_cache
+
+
+@pytest.fixture(autouse=True)
+def empty_cache():
+    """Start every test with an empty cache"""
+    clear_cache()
+    yield
+    clear_cache()
+
+
+@pytest.fixture
+def cost_service():
+    return CostService(snmp_service=SNMPService(mib_service=MIBService()))
+
+
+def _query(command, oids, **operation):
+    return SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command=command, oids=oids, **operation)
+    )
+
+
+def test_estimate_get(cost_service):
+    """Test that a GET costs one request per OID, and the summary's tokens grow with the values"""
+    estimate = cost_service.estimate(_query("GET", ["1.3.6.1.2.1.1.5.0", "1.3.6.1.2.1.1.1.0"]))
+
+    assert estimate.command == "GET"
+    assert (estimate.snmp_round_trips, estimate.varbinds) == (2, 2)
+    assert estimate.llm_tokens == 250 + 2 * 20 + 200
+    assert estimate.latency_seconds == pytest.approx(3.1)
+    assert estimate.assumptions == []
+
+
+def test_estimate_row_indexes_are_cells(cost_service):
+    """Test that explicit row indexes are estimated as a GET of each cell"""
+    estimate = cost_service.estimate(_query("WALK", ["IF-MIB::ifInOctets", "IF-MIB::ifOutOctets"], indexes=["1", "2", "3"]))
+
+    assert estimate.command == "GET"
+    assert (estimate.snmp_round_trips, estimate.varbinds) == (6, 6)
+
+
+def test_estimate_walk_column_and_table(cost_service):
+    """Test that a WALK costs a request per row of each column, with tables counted by their columns"""
+    column = cost_service.estimate(_query("WALK", ["IF-MIB::ifInOctets"]))
+    assert (column.snmp_round_trips, column.varbinds) == (101, 100)
+    assert column.assumptions == ["100 rows per table (SNMP_ESTIMATE_TABLE_ROWS)"]
+
+    # ifTable: the 10 columns the built-in IF-MIB defines
+    table = cost_service.estimate(_query("WALK", ["1.3.6.1.2.1.2.2"]))
+    assert (table.snmp_round_trips, table.varbinds) == (1001, 1000)
+
+    # The system group: scalars only
+    system = cost_service.estimate(_query("WALK", ["1.3.6.1.2.1.1"]))
+    assert (system.snmp_round_trips, system.varbinds) == (8, 7)
+
+
+def test_count_objects_under_a_large_subtree(cost_service):
+    """Test that only leaf objects are counted, in time that doesn't grow with the square of the subtree"""
+    objects = ["1.2.2.0", "1.2.10", "1.2.1.2", "1.2.1", "1.2.1.1"]
+    with patch.object(cost_service.snmp_service.mib_service, "objects_under", return_value=objects):
+        assert cost_service._count_objects(["1.2"]) == (1, 3)
+
+    # An enterprise subtree of 200 tables of 100 columns each
+    objects = [f"1.3.6.1.4.1.9.{table}" for table in range(200)] + \
+        [f"1.3.6.1.4.1.9.{table}.{column}" for table in range(200) for column in range(1, 101)]
+    with patch.object(cost_service.snmp_service.mib_service, "objects_under", return_value=objects):
+        started = time.monotonic()
+        assert cost_service._count_objects(["1.3.6.1.4.1.9"]) == (0, 20000)
+        assert time.monotonic() - started < 1
+
+
+def test_estimate_walk_table_size_hints(cost_service):
+    """Test that the index range or the target's cached interface count size the tables"""
+    ranged = cost_service.estimate(_query("WALK", ["IF-MIB::ifInOctets"], index_from=1, index_to=8))
+    assert ranged.varbinds == 8
+    assert ranged.assumptions == ["8 rows per table, from the index range"]
+
+    set_cache("interfaces_192.168.1.1:161", {str(index): {"name": f"Gi0/{index}", "alias": None} for index in range(24)})
+    interfaces = cost_service.estimate(_query("WALK", ["IF-MIB::ifInOctets", "IF-MIB::ifOutOctets"]))
+    assert (interfaces.snmp_round_trips, interfaces.varbinds) == (50, 48)
+    assert interfaces.assumptions == ["24 rows per table, the target's interface count"]
+    # Two subtrees walked concurrently
+    assert interfaces.latency_seconds == pytest.approx(25 * 0.05 + 3)
+
+
+def test_estimate_bulk(cost_service):
+    """Test that a BULK packs rows of several columns into each request"""
+    estimate = cost_service.estimate(_query(
+        "BULK", ["1.3.6.1.2.1.1.3.0", "IF-MIB::ifDescr", "IF-MIB::ifInOctets"], non_repeaters=1, max_repetitions=25
+    ))
+
+    # One request for the scalar, then 2 columns of 100 rows at 25 rows per request
+    assert (estimate.snmp_round_trips, estimate.varbinds) == (1 + 5, 1 + 200)
+
+
+def test_estimate_multi_target(cost_service):
+    """Test that a fan-out multiplies the requests but not the latency, and has no summary"""
+    estimate = cost_service.estimate(_query("GET", ["1.3.6.1.2.1.1.5.0"]), targets=20, summarize=False)
+
+    assert estimate.targets == 20
+    assert (estimate.snmp_round_trips, estimate.varbinds) == (20, 20)
+    assert estimate.llm_tokens == 0
+    assert estimate.latency_seconds == pytest.approx(0.05)
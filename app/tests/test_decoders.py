@@ -0,0 +1,44 @@
+import pytest
+
+from app.utils.decoders import decode_sys_services, decode_value, SYS_SERVICES
+from app.services.snmp_service import SNMPService
+from app.services.mib_service import MIBService
+
+
+@pytest.mark.parametrize("value,layers", [
+    (72, ["end-to-end", "application"]),
+    (78, ["datalink", "internet", "end-to-end", "application"]),
+    (6, ["datalink", "internet"]),
+    (2, ["datalink"]),
+    (4, ["internet"]),
+    (79, ["physical", "datalink", "internet", "end-to-end", "application"]),
+    (127, ["physical", "datalink", "internet", "end-to-end", "session", "presentation", "application"]),
+    (0, []),
+    ("76", ["internet", "end-to-end", "application"]),
+])
+def test_decode_sys_services(value, layers):
+    """Test decoding sysServices values of common device types into their layers"""
+    assert decode_sys_services(value) == layers
+
+
+@pytest.mark.parametrize("value", [128, -1, "router1", None, True])
+def test_decode_sys_services_invalid(value):
+    """Test that values outside the sysServices range aren't decoded"""
+    assert decode_sys_services(value) is None
+
+
+def test_decode_value_only_for_known_oids():
+    """Test that values are only decoded for OIDs with a built-in decoder"""
+    assert decode_value(f".{SYS_SERVICES}", 72) == ["end-to-end", "application"]
+    assert decode_value("1.3.6.1.2.1.1.5.0", 72) is None
+
+
+def test_enrich_results_decodes_sys_services():
+    """Test that enriched sysServices results carry their layers and keep the raw value"""
+    service = SNMPService(mib_service=MIBService())
+
+    services, name = service.enrich_results({SYS_SERVICES: 78, "1.3.6.1.2.1.1.5.0": "router1"})
+
+    assert services.value == 78
+    assert services.decoded == ["datalink", "internet", "end-to-end", "application"]
+    assert name.decoded is None
@@ -0,0 +1,119 @@
+import pytest
+from unittest.mock import patch, MagicMock, AsyncMock
+
+from app.core.config import APIKeyPolicy
+from app.services.device_service import DeviceService, identify_sys_object_id
+from app.services.mib_service import MIBService
+from app.services.snmp_service import SNMPService
+from app.utils.cache import clear_cache
+
+
+@pytest.fixture(autouse=True)
+def empty_cache():
+    """Start every test with an empty cache"""
+    clear_cache()
+    yield
+    clear_cache()
+
+
+@pytest.mark.parametrize("sys_object_id,enterprise_number,vendor", [
+    ("1.3.6.1.4.1.9.1.1208", 9, "Cisco Systems"),
+    (".1.3.6.1.4.1.2636.1.1.1.2.29", 2636, "Juniper Networks"),
+    ("1.3.6.1.4.1.30065.1.3011.7050", 30065, "Arista Networks"),
+    ("1.3.6.1.4.1.99999999.1", 99999999, None),
+])
+def test_identify_vendor_from_enterprise_number(sys_object_id, enterprise_number, vendor):
+    """Test mapping sysObjectIDs to vendors by enterprise number"""
+    identity = identify_sys_object_id(sys_object_id)
+
+    assert identity["sys_object_id"] == sys_object_id.lstrip(".")
+    assert identity["enterprise_number"] == enterprise_number
+    assert identity["vendor"] == vendor
+
+
+def test_identify_model_from_registry():
+    """Test that the registry names the model, most specific entry first"""
+    models = {
+        "1.3.6.1.4.1.9.1": "Cisco product",
+        "1.3.6.1.4.1.9.1.1208": "Cisco Catalyst 2960-X",
+    }
+
+    with patch("app.services.device_service.config.device_models", models):
+        assert identify_sys_object_id("1.3.6.1.4.1.9.1.1208")["model"] == "Cisco Catalyst 2960-X"
+        assert identify_sys_object_id("1.3.6.1.4.1.9.1.516")["model"] == "Cisco product"
+        assert identify_sys_object_id("1.3.6.1.4.1.2636.1.1.1.2.29")["model"] is None
+
+    assert identify_sys_object_id("1.3.6.1.4.1.8072.3.2.10")["model"] == "Net-SNMP agent on Linux"
+
+
+@pytest.mark.asyncio
+async def test_identify_caches_per_target():
+    """Test that the device is only queried once per target"""
+    mock_snmp_service = MagicMock(spec=SNMPService)
+    mock_snmp_service.execute_query = AsyncMock(return_value={"SNMPv2-MIB::sysObjectID.0": "1.3.6.1.4.1.9.1.1208"})
+    mock_snmp_service.validate_query.return_value = None
+
+    service = DeviceService(snmp_service=mock_snmp_service)
+
+    first = await service.identify("10.0.0.1")
+    second = await service.identify("10.0.0.1")
+
+    assert first == second
+    assert first["reachable"] is True
+    assert first["vendor"] == "Cisco Systems"
+    mock_snmp_service.execute_query.assert_called_once()
+
+    query = mock_snmp_service.execute_query.call_args[0][0]
+    assert query.operation.oids == ["1.3.6.1.2.1.1.2.0"]
+
+
+@pytest.mark.asyncio
+async def test_identify_unreachable_not_cached():
+    """Test that failures are reported and not cached"""
+    mock_snmp_service = MagicMock(spec=SNMPService)
+    mock_snmp_service.execute_query = AsyncMock(return_value={"error": "SNMP request timed out"})
+    mock_snmp_service.validate_query.return_value = None
+
+    service = DeviceService(snmp_service=mock_snmp_service)
+
+    identity = await service.identify("10.0.0.1")
+    await service.identify("10.0.0.1")
+
+    assert identity == {"host": "10.0.0.1", "reachable": False, "error": "SNMP request timed out"}
+    assert mock_snmp_service.execute_query.call_count == 2
+
+
+@pytest.mark.asyncio
+async def test_identify_cached_per_credentials_and_scope():
+    """Test that a cached identification isn't served to other credentials or to keys not allowed sysObjectID"""
+    service = DeviceService(snmp_service=SNMPService(mib_service=MIBService()))
+    service.snmp_service.execute_query = AsyncMock(
+        return_value={"SNMPv2-MIB::sysObjectID.0": "1.3.6.1.4.1.9.1.1208"}
+    )
+
+    await service.identify("10.0.0.1", community="secret")
+    other_community = await service.identify("10.0.0.1", community="guess")
+    restricted = await service.identify("10.0.0.1", community="secret",
+                                        api_key=APIKeyPolicy(name="ifs", oid_prefixes=["1.3.6.1.2.1.2"]))
+
+    assert other_community["vendor"] == "Cisco Systems"
+    assert service.snmp_service.execute_query.call_count == 2
+    assert restricted["reachable"] is False
+    assert "not authorized" in restricted["error"]
+
+
+@pytest.mark.asyncio
+async def test_identify_without_cache_asks_device():
+    """Test that /check's identifications don't come from the cache, so a device that went down shows as such"""
+    mock_snmp_service = MagicMock(spec=SNMPService)
+    mock_snmp_service.execute_query = AsyncMock(side_effect=[
+        {"SNMPv2-MIB::sysObjectID.0": "1.3.6.1.4.1.9.1.1208"},
+        {"error": "SNMP request timed out"},
+    ])
+    mock_snmp_service.validate_query.return_value = None
+    service = DeviceService(snmp_service=mock_snmp_service)
+
+    await service.identify("10.0.0.1")
+    identity = await service.identify("10.0.0.1", use_cache=False)
+
+    assert identity["reachable"] is False
@@ -0,0 +1,23 @@
+from app.utils.etag import compute_etag, etag_matches
+
+
+def test_compute_etag_is_stable():
+    """Test that the ETag depends only on content, not key order"""
+    etag = compute_etag({"command": "GET"}, {"a": 1, "b": 2})
+
+    assert etag.startswith('"') and etag.endswith('"')
+    assert etag == compute_etag({"command": "GET"}, {"b": 2, "a": 1})
+    assert etag != compute_etag({"command": "GET"}, {"a": 1, "b": 3})
+    assert etag != compute_etag({"command": "WALK"}, {"a": 1, "b": 2})
+
+
+def test_etag_matches():
+    """Test If-None-Match comparison"""
+    etag = compute_etag("data")
+
+    assert etag_matches(etag, etag)
+    assert etag_matches(f'"other", {etag}', etag)
+    assert etag_matches(f"W/{etag}", etag)
+    assert etag_matches("*", etag)
+    assert not etag_matches('"other"', etag)
+    assert not etag_matches(None, etag)
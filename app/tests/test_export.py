@@ -0,0 +1,96 @@
+import csv
+import io
+import json
+import tracemalloc
+from datetime import datetime, timezone
+from unittest.mock import patch
+
+from app.utils.export import export_filename, iter_export, iter_json
+
+CONTENT = {
+    "summary": "Two interfaces",
+    "query": "walk ifDescr on 10.0.0.1",
+    "raw_data": {"1.3.6.1.2.1.2.2.1.2.1": "eth0", "1.3.6.1.2.1.2.2.1.2.2": "eth1, \"uplink\""},
+    "results": [
+        {"oid": "1.3.6.1.2.1.2.2.1.2.1", "name": "IF-MIB::ifDescr.1", "value": "eth0", "mib": "IF-MIB",
+         "warnings": []},
+        {"oid": "1.3.6.1.2.1.2.2.1.2.2", "name": "IF-MIB::ifDescr.2", "value": "eth1, \"uplink\"", "mib": "IF-MIB",
+         "if_alias": "to core", "warnings": []},
+    ],
+}
+
+
+def test_export_filename():
+    """Test that download file names carry the target and the time, safe for any host"""
+    when = datetime(2024, 5, 1, 12, 30, 5, tzinfo=timezone.utc)
+
+    assert export_filename("10.0.0.1", "csv", when) == "snmp-10.0.0.1-20240501T123005Z.csv"
+    assert export_filename("fe80::1", "json", when) == "snmp-fe80_1-20240501T123005Z.json"
+    assert export_filename(None, "csv", when) == "snmp-query-20240501T123005Z.csv"
+
+
+def test_export_csv():
+    """Test that CSV downloads hold a header and one quoted row per result"""
+    rows = list(csv.DictReader(io.StringIO("".join(iter_export(CONTENT, "csv")))))
+
+    assert [row["oid"] for row in rows] == ["1.3.6.1.2.1.2.2.1.2.1", "1.3.6.1.2.1.2.2.1.2.2"]
+    assert rows[1]["value"] == "eth1, \"uplink\""
+    assert rows[1]["if_alias"] == "to core"
+    assert rows[0]["if_alias"] == ""
+    assert "warnings" not in rows[0]
+
+
+def test_export_csv_formulas_quoted():
+    """Test that text cells a spreadsheet would run as formulas are prefixed with '"""
+    results = [{"oid": "1.3.6.1.2.1.1.5.0", "name": "SNMPv2-MIB::sysName.0", "value": value, "if_alias": "@SUM(A1)"}
+               for value in ("=HYPERLINK(\"http://x\")", "+1", "-1", "\tcmd", "\rcmd", "router1", -1)]
+    rows = list(csv.DictReader(io.StringIO("".join(iter_export({"results": results}, "csv")))))
+
+    assert [row["value"] for row in rows] == [
+        "'=HYPERLINK(\"http://x\")", "'+1", "'-1", "'\tcmd", "'\rcmd", "router1", "-1"
+    ]
+    assert rows[0]["if_alias"] == "'@SUM(A1)"
+
+
+def test_export_json_matches_body():
+    """Test that JSON downloads hold the same document as the inline response"""
+    assert json.loads("".join(iter_export(CONTENT, "json"))) == CONTENT
+    assert json.loads("".join(iter_export({"results": []}, "json"))) == {"results": []}
+
+
+def test_export_streams_large_results_in_chunks():
+    """Test that large results are serialized a chunk at a time"""
+    results = [{"oid": f"1.3.6.1.2.1.2.2.1.10.{index}", "value": index} for index in range(1, 26)]
+
+    with patch("app.utils.export.EXPORT_CHUNK_SIZE", 10):
+        csv_chunks = list(iter_export({"results": results}, "csv"))
+        json_chunks = list(iter_export({"summary": "", "results": results}, "json"))
+
+    assert len(csv_chunks) == 3
+    assert len(list(csv.DictReader(io.StringIO("".join(csv_chunks))))) == 25
+    assert len(json_chunks) == 5
+    assert json.loads("".join(json_chunks))["results"] == results
+
+
+def test_stream_json_encoding_memory_stays_bounded():
+    """Test that encoding a very large response only holds a chunk of its encoding in memory at a time"""
+    count = 50000
+    content = {
+        "summary": "A large table",
+        "raw_data": {f"IF-MIB::ifInOctets.{index}": index * 1000 for index in range(count)},
+        "results": [{"oid": f"1.3.6.1.2.1.2.2.1.10.{index}", "name": f"IF-MIB::ifInOctets.{index}",
+                     "value": index * 1000, "mib": "IF-MIB", "warnings": []} for index in range(count)],
+    }
+
+    tracemalloc.start()
+    try:
+        encoded_size = 0
+        for chunk in iter_json(content):
+            encoded_size += len(chunk)
+        _, peak = tracemalloc.get_traced_memory()
+    finally:
+        tracemalloc.stop()
+
+    assert encoded_size > 5_000_000
+    assert peak < encoded_size / 20
+    assert json.loads("".join(iter_json(content))) == content
@@ -0,0 +1,67 @@
+import pytest
+
+from app.utils.expressions import ExpressionError, parse_computed_fields, compute_fields
+
+
+def test_compute_utilization_per_interface():
+    """Test a computed field evaluated for each interface index"""
+    raw_data = {
+        "IF-MIB::ifInOctets.1": 1250000,
+        "IF-MIB::ifSpeed.1": 100000000,
+        "IF-MIB::ifInOctets.2": 500,
+        "IF-MIB::ifSpeed.2": 0,
+        "IF-MIB::ifInOctets.3": 42,
+        "SNMPv2-MIB::sysName.0": "router1",
+    }
+    fields = parse_computed_fields(["utilization = round(ifInOctets * 8 / ifSpeed * 100, 2)"])
+
+    computed = compute_fields(raw_data, fields)
+
+    assert computed == {
+        "utilization.1": 10.0,
+        # Division by zero gives no value rather than failing the query
+        "utilization.2": None,
+    }
+
+
+def test_compute_non_numeric_value():
+    """Test that a non-numeric value gives no computed value"""
+    fields = parse_computed_fields(["double=sysName*2"])
+
+    assert compute_fields({"SNMPv2-MIB::sysName.0": "router1"}, fields) == {"double.0": None}
+
+
+@pytest.mark.parametrize("spec", [
+    "x=__import__('os').system('id')",
+    "x=ifSpeed.__class__",
+    "x=open('/etc/passwd')",
+    "x=[ifSpeed for _ in range(10)]",
+    "x=(lambda: 1)()",
+    "x=ifSpeed[0]",
+    "x='a' * 10",
+    "x=_secret",
+    "x=max(ifSpeed, key=abs)",
+    "x=" + "+".join(["1"] * 100),
+    "x=ifSpeed +",
+    "no expression",
+    "1x=ifSpeed",
+])
+def test_rejects_unsafe_expressions(spec):
+    """Test that anything beyond arithmetic and the allowed functions is rejected"""
+    with pytest.raises(ExpressionError):
+        parse_computed_fields([spec])
+
+
+def test_guards_against_huge_results():
+    """Test that expressions cannot exhaust memory with huge numbers"""
+    fields = parse_computed_fields(["big=((ifSpeed ** 16) ** 16) ** 16", "exp=2 ** 1000", "root=(-ifSpeed) ** 0.5"])
+
+    computed = compute_fields({"IF-MIB::ifSpeed.1": 10 ** 9}, fields)
+
+    assert computed == {"big.1": None, "exp.1": None, "root.1": None}
+
+
+def test_rejects_too_many_fields():
+    """Test that the number of computed fields per request is bounded"""
+    with pytest.raises(ExpressionError):
+        parse_computed_fields([f"f{i}=1" for i in range(11)])
@@ -0,0 +1,132 @@
+import time
+import pytest
+from unittest.mock import patch, MagicMock, AsyncMock
+
+from app.services.interface_service import InterfaceService, interface_index, IF_NAME, IF_ALIAS
+from app.services.snmp_service import SNMPService
+from app.services.mib_service import MIBService
+from app.core.config import APIKeyPolicy
+from app.models.query import SNMPQuery, SNMPTarget, SNMPCredentials, SNMPOperation
+from app.utils.cache import clear_cache
+
+# Fixture agent data: three interfaces, the third without an alias
+INTERFACE_LABELS = {
+    f"{IF_NAME}.1": "Gi0/1",
+    f"{IF_NAME}.2": "Gi0/2",
+    f"{IF_NAME}.3": "Lo0",
+    f"{IF_ALIAS}.1": "uplink to core-sw1",
+    f"{IF_ALIAS}.2": "customer ACME",
+}
+
+
+@pytest.fixture(autouse=True)
+def empty_cache():
+    """Start every test with an empty cache"""
+    clear_cache()
+    yield
+    clear_cache()
+
+
+def _labels_service(result=INTERFACE_LABELS):
+    mock_snmp_service = MagicMock(spec=SNMPService)
+    mock_snmp_service.execute_query = AsyncMock(return_value=result)
+    mock_snmp_service.validate_query.return_value = None
+    return mock_snmp_service
+
+
+def _in_octets_query(host="192.168.1.1"):
+    return SNMPQuery(
+        target=SNMPTarget(host=host),
+        operation=SNMPOperation(command="WALK", oids=["IF-MIB::ifInOctets"])
+    )
+
+
+@pytest.mark.parametrize("oid,index", [
+    ("1.3.6.1.2.1.2.2.1.10.3", "3"),
+    (".1.3.6.1.2.1.31.1.1.1.6.12", "12"),
+    ("1.3.6.1.2.1.2.2.1.10", None),
+    ("1.3.6.1.2.1.4.20.1.2.10.0.0.1", None),
+    ("1.3.6.1.2.1.1.5.0", None),
+])
+def test_interface_index(oid, index):
+    """Test finding the ifIndex of interface table cells"""
+    assert interface_index(oid) == index
+
+
+@pytest.mark.asyncio
+async def test_label_results_with_name_and_alias():
+    """Test that interface table results get the interface's ifName and ifAlias"""
+    mock_snmp_service = _labels_service()
+    service = InterfaceService(snmp_service=mock_snmp_service)
+    results = SNMPService(mib_service=MIBService()).enrich_results({
+        "1.3.6.1.2.1.2.2.1.10.1": 1200,
+        "1.3.6.1.2.1.2.2.1.10.3": 0,
+        "1.3.6.1.2.1.2.2.1.10.9": 5,
+        "1.3.6.1.2.1.1.5.0": "router1",
+    })
+
+    await service.label_results(_in_octets_query(), results)
+
+    assert [(result.if_name, result.if_alias) for result in results] == [
+        ("Gi0/1", "uplink to core-sw1"),
+        ("Lo0", None),
+        (None, None),
+        (None, None),
+    ]
+    labels_query = mock_snmp_service.execute_query.call_args[0][0]
+    assert labels_query.operation.command == "WALK"
+    assert labels_query.operation.oids == [IF_NAME, IF_ALIAS]
+    assert labels_query.target.host == "192.168.1.1"
+
+
+@pytest.mark.asyncio
+async def test_labels_cached_per_target_briefly():
+    """Test that labels are cached per target and re-read once the short TTL expires"""
+    mock_snmp_service = _labels_service()
+    service = InterfaceService(snmp_service=mock_snmp_service)
+
+    first = await service.get_labels(_in_octets_query())
+    second = await service.get_labels(_in_octets_query())
+    assert first == second
+    assert first["2"] == {"name": "Gi0/2", "alias": "customer ACME"}
+    assert mock_snmp_service.execute_query.call_count == 1
+
+    await service.get_labels(_in_octets_query(host="192.168.1.2"))
+    assert mock_snmp_service.execute_query.call_count == 2
+
+    # Past the interface TTL, well within the default cache TTL
+    with patch("app.utils.cache.time.time", return_value=time.time() + 61):
+        await service.get_labels(_in_octets_query())
+    assert mock_snmp_service.execute_query.call_count == 3
+
+
+
+@pytest.mark.asyncio
+async def test_labels_cached_per_credentials_and_scope():
+    """Test that cached labels are only reused with the same credentials, and not by keys that can't read them"""
+    snmp_service = SNMPService(mib_service=MIBService())
+    service = InterfaceService(snmp_service=snmp_service)
+    other_community = _in_octets_query().model_copy(update={"credentials": SNMPCredentials(community="private")})
+    interfaces_only = APIKeyPolicy(name="ifs", oid_prefixes=["1.3.6.1.2.1.2"])
+
+    with patch.object(snmp_service, "execute_query", new=AsyncMock(return_value=INTERFACE_LABELS)) as execute_query:
+        assert await service.get_labels(_in_octets_query())
+        assert await service.get_labels(other_community)
+        assert execute_query.call_count == 2
+
+        assert await service.get_labels(_in_octets_query(), api_key=interfaces_only) == {}
+        assert execute_query.call_count == 2
+
+@pytest.mark.asyncio
+async def test_labels_not_fetched_or_cached_when_not_needed():
+    """Test that results outside interface tables don't query labels, and failures aren't cached"""
+    mock_snmp_service = _labels_service({"error": "SNMP request timed out"})
+    service = InterfaceService(snmp_service=mock_snmp_service)
+
+    results = SNMPService(mib_service=MIBService()).enrich_results({"1.3.6.1.2.1.1.5.0": "router1"})
+    await service.label_results(_in_octets_query(), results)
+    mock_snmp_service.execute_query.assert_not_called()
+
+    assert await service.get_labels(_in_octets_query()) == {}
+    assert await service.get_labels(_in_octets_query()) == {}
+    assert mock_snmp_service.execute_query.call_count == 2
@@ -0,0 +1,99 @@
+import json
+
+import pytest
+
+from app.services.interpretation_cache import InterpretationCache
+
+SYS_NAME = {"target": {"host": "10.0.0.1"}, "operation": {"command": "GET", "oids": ["1.3.6.1.2.1.1.5.0"]}}
+
+
+def _accept(interpretation):
+    return interpretation
+
+
+def test_least_recently_used_entry_evicted():
+    """Test that a cache hit marks the entry as used and the least recently used one is evicted"""
+    cache = InterpretationCache(max_entries=2)
+    cache.put("first", "gpt-4", "v1", SYS_NAME, now=100)
+    cache.put("second", "gpt-4", "v1", SYS_NAME, now=101)
+    assert cache.get("first", "gpt-4", "v1", now=102) == SYS_NAME
+
+    cache.put("third", "gpt-4", "v1", SYS_NAME, now=103)
+
+    assert [entry.query for entry in cache.most_recent()] == ["third", "first"]
+    assert cache.most_recent()[1].accessed == 102
+    assert cache.get("second", "gpt-4", "v1") is None
+
+
+def test_entries_kept_per_model_and_prompt_version():
+    """Test that an interpretation by another model or prompt version is never returned"""
+    cache = InterpretationCache(max_entries=10)
+    cache.put("get sysName from 10.0.0.1", "gpt-4", "v1", SYS_NAME)
+
+    assert cache.get("get sysName from 10.0.0.1", "gpt-4o", "v1") is None
+    assert cache.get("get sysName from 10.0.0.1", "gpt-4", "v2") is None
+    assert cache.get("get sysName from 10.0.0.1", "gpt-4", "v1") == SYS_NAME
+
+
+def test_reload_warms_up_most_recently_used(tmp_path):
+    """Test that a reloaded cache holds only the warmup most recently used entries, in order of use"""
+    path = str(tmp_path / "interpretations.json")
+    cache = InterpretationCache(max_entries=10, path=path)
+    for number in range(5):
+        cache.put(f"query {number}", "gpt-4", "v1", SYS_NAME, now=100 + number)
+    cache.get("query 0", "gpt-4", "v1", now=200)
+    assert cache.save() == 5
+
+    reloaded = InterpretationCache(max_entries=10, path=path)
+
+    assert reloaded.load("gpt-4", "v1", warmup=3, validate=_accept) == (3, 0)
+    assert [entry.query for entry in reloaded.most_recent()] == ["query 0", "query 4", "query 3"]
+
+
+def test_reload_purges_stale_model_prompt_and_invalid_entries(tmp_path):
+    """Test that entries by another model or prompt, or rejected by the validator, are purged at startup"""
+    path = str(tmp_path / "interpretations.json")
+    cache = InterpretationCache(max_entries=10, path=path)
+    cache.put("old model", "gpt-3.5-turbo", "v1", SYS_NAME)
+    cache.put("old prompt", "gpt-4", "v0", SYS_NAME)
+    cache.put("invalid", "gpt-4", "v1", {"operation": "GET"})
+    cache.put("current", "gpt-4", "v1", SYS_NAME)
+    cache.save()
+
+    def validate(interpretation):
+        if "target" not in interpretation:
+            raise ValueError("No target")
+
+    reloaded = InterpretationCache(max_entries=10, path=path)
+    assert reloaded.load("gpt-4", "v1", warmup=10, validate=validate) == (1, 3)
+    reloaded.save()
+
+    with open(path) as cache_file:
+        assert [entry["query"] for entry in json.load(cache_file)["entries"]] == ["current"]
+
+
+def test_credentials_never_saved(tmp_path):
+    """Test that interpretations holding a community string or passphrase are kept in memory only"""
+    path = str(tmp_path / "interpretations.json")
+    cache = InterpretationCache(max_entries=10, path=path)
+    cache.put("public", "gpt-4", "v1", {**SYS_NAME, "credentials": {"community": "public"}})
+    cache.put("secret", "gpt-4", "v1", {**SYS_NAME, "credentials": {"community": "s3cret"}})
+    cache.put("v3", "gpt-4", "v1", {**SYS_NAME, "credentials": {"version": "3", "auth_password": "authpass"}})
+
+    assert cache.save() == 1
+    assert len(cache) == 3
+    with open(path) as cache_file:
+        assert "s3cret" not in cache_file.read()
+
+
+@pytest.mark.parametrize("content", ["", "not json", '{"entries": [{"query": "missing fields"}]}'])
+def test_unreadable_file_ignored(tmp_path, content):
+    """Test that a missing or corrupt cache file starts an empty cache"""
+    path = tmp_path / "interpretations.json"
+    if content:
+        path.write_text(content)
+
+    cache = InterpretationCache(max_entries=10, path=str(path))
+
+    assert cache.load("gpt-4", "v1", warmup=10, validate=_accept) == (0, 0)
+    assert len(cache) == 0
@@ -0,0 +1,64 @@
+import json
+import pytest
+
+from app.services.interpretation_stream import StreamRejected, StreamingInterpretation
+
+INTERPRETATION = {
+    "target": {"host": "10.0.0.1", "port": 161},
+    "credentials": {"version": "2c", "community": "p{a}ss\"word"},
+    "operation": {"command": "GET", "oids": ["1.3.6.1.2.1.1.5.0"], "max_repetitions": None},
+}
+
+
+def test_members_complete_as_text_arrives():
+    """Test each member is reported once its value is complete, whatever the chunking"""
+    text = json.dumps(INTERPRETATION, indent=1)
+    interpretation = StreamingInterpretation()
+    completed = []
+
+    for char in text:
+        completed.extend(interpretation.feed(char))
+        if not completed:
+            # Nothing is reported before the target's closing brace
+            assert "target" not in interpretation.members
+
+    assert completed == ["target", "credentials", "operation"]
+    assert interpretation.result() == INTERPRETATION
+
+
+def test_result_needs_the_whole_object():
+    """Test the interpretation isn't available before the object is closed"""
+    interpretation = StreamingInterpretation()
+    interpretation.feed('{"target": {"host": "10.0.0.1"}, "operation": {"command": "GET"}')
+
+    assert set(interpretation.members) == {"target", "operation"}
+    with pytest.raises(StreamRejected):
+        interpretation.result()
+
+
+def test_scalars_wait_for_their_delimiter():
+    """Test a number isn't taken as complete until the text after it arrives"""
+    interpretation = StreamingInterpretation()
+
+    assert interpretation.feed('{"retries": 1') == []
+    assert interpretation.feed('0, "port": 161}') == ["retries", "port"]
+    assert interpretation.result() == {"retries": 10, "port": 161}
+
+
+@pytest.mark.parametrize("text", [
+    "Here is the JSON: {",
+    '{"target" {"host": "10.0.0.1"}}',
+    '{target: 1}',
+    '{"target": {"host": "10.0.0.1"} "operation": {}}',
+    '{"retries": tru,',
+    '{"target": {"host": "10.0.0.1"]',
+    '{"operation": {"command": "TRAP"}',
+    '{"operation": "INFORM",',
+    '{"target": {"host": "10.0.0.1", "retries": -1}',
+    '{"a": 1,}',
+    '{"a": 1} and more',
+])
+def test_rejects_malformed_or_invalid_prefixes(text):
+    """Test malformed JSON and invalid members are rejected from the first text that shows it"""
+    with pytest.raises(StreamRejected):
+        StreamingInterpretation().feed(text)
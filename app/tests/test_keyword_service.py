@@ -0,0 +1,85 @@
+import pytest
+from unittest.mock import patch, MagicMock
+
+from app.services.keyword_service import KeywordService
+from app.services.openai_service import OpenAIService
+
+
+@pytest.mark.parametrize("query,command,oids,host", [
+    ("get sysDescr.0 from 192.168.1.1", "GET", ["sysDescr.0"], "192.168.1.1"),
+    ("Walk 1.3.6.1.2.1.2.2 on switch1.example.com", "WALK", ["1.3.6.1.2.1.2.2"], "switch1.example.com"),
+    ("getnext ifDescr, ifSpeed at 10.0.0.1", "GETNEXT", ["ifDescr", "ifSpeed"], "10.0.0.1"),
+    ("bulkwalk IF-MIB::ifTable on 10.0.0.1", "BULK", ["IF-MIB::ifTable"], "10.0.0.1"),
+    ("walktable ifTable on 10.0.0.1", "WALKTABLE", ["ifTable"], "10.0.0.1"),
+    ("walk 10.0.0.1 .1.3.6.1.2.1.1", "WALK", [".1.3.6.1.2.1.1"], "10.0.0.1"),
+    ("get uptime from fe80::1", "GET", ["uptime"], "fe80::1"),
+])
+def test_rule_patterns(query, command, oids, host):
+    """Test the structured query patterns"""
+    snmp_query = KeywordService().process_query(query)
+
+    assert snmp_query is not None
+    assert snmp_query.operation.command == command
+    assert snmp_query.operation.oids == oids
+    assert snmp_query.target.host == host
+    assert snmp_query.target.port == 161
+    assert snmp_query.credentials.version == "2c"
+    assert snmp_query.credentials.community == "public"
+
+
+def test_rule_options():
+    """Test community, version and port options"""
+    service = KeywordService()
+
+    snmp_query = service.process_query("walk ifTable on 10.0.0.1 using community 'private' version 1 port 1161")
+    assert snmp_query.credentials.community == "private"
+    assert snmp_query.credentials.version == "1"
+    assert snmp_query.target.port == 1161
+
+    snmp_query = service.process_query("walk 10.0.0.1 1.3.6.1.2.1.1 -v2c -c secret")
+    assert snmp_query.credentials.community == "secret"
+    assert snmp_query.credentials.version == "2c"
+
+
+@pytest.mark.parametrize("query", [
+    "What is the system description of the device at 192.168.1.1?",
+    "get the uptime from 10.0.0.1",
+    "walk ifTable on 10.0.0.1 and tell me which ones are down",
+    "check cpu on 10.0.0.1",
+    "get temperature from core1",
+    "walk neighbours on 10.0.0.1",
+])
+def test_ambiguous_queries_not_matched(query):
+    """Test that natural language is left to the LLM"""
+    assert KeywordService().process_query(query) is None
+
+
+@pytest.mark.asyncio
+async def test_openai_service_uses_rules_first():
+    """Test that structured queries don't call the LLM in hybrid mode"""
+    with patch("app.services.llm_providers.OpenAI") as mock_openai, \
+            patch("app.services.openai_service.config.interpreter_mode", "hybrid"):
+        mock_client = MagicMock()
+        mock_openai.return_value = mock_client
+
+        service = OpenAIService()
+        result = await service.process_query("get sysName.0 from 10.0.0.1")
+
+        assert result.operation.command == "GET"
+        assert result.target.host == "10.0.0.1"
+        mock_client.chat.completions.create.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_rules_mode_never_calls_llm():
+    """Test that unmatched queries return None in rules-only mode"""
+    with patch("app.services.llm_providers.OpenAI") as mock_openai, \
+            patch("app.services.openai_service.config.interpreter_mode", "rules"):
+        mock_client = MagicMock()
+        mock_openai.return_value = mock_client
+
+        service = OpenAIService()
+        result = await service.process_query("What is the uptime of 10.0.0.1?")
+
+        assert result is None
+        mock_client.chat.completions.create.assert_not_called()
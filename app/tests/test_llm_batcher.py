@@ -0,0 +1,109 @@
+import asyncio
+import pytest
+
+from app.services.llm_batcher import MicroBatcher
+
+
+class EchoCompleter:
+    """Fixture completion function that answers each request with its upper-cased text"""
+
+    def __init__(self, delay=0.0):
+        self.delay = delay
+        self.batches = []
+        self.cancelled = False
+
+    async def __call__(self, items):
+        self.batches.append(list(items))
+        try:
+            await asyncio.sleep(self.delay)
+        except asyncio.CancelledError:
+            self.cancelled = True
+            raise
+        return [item.upper() for item in items]
+
+
+@pytest.mark.asyncio
+async def test_batched_results_route_to_each_caller():
+    """Test that requests within the window share a call and each caller gets its own result"""
+    complete = EchoCompleter()
+    batcher = MicroBatcher(complete, max_size=10, max_wait=0.05)
+
+    results = await asyncio.gather(*(batcher.submit(f"query {number}") for number in range(5)))
+
+    assert results == [f"QUERY {number}" for number in range(5)]
+    assert complete.batches == [[f"query {number}" for number in range(5)]]
+
+
+@pytest.mark.asyncio
+async def test_batch_size_and_wait_bounded():
+    """Test that a full batch is sent at once and a partial one after the max wait"""
+    complete = EchoCompleter()
+    batcher = MicroBatcher(complete, max_size=2, max_wait=0.05)
+
+    loop = asyncio.get_event_loop()
+    started = loop.time()
+    results = await asyncio.gather(*(batcher.submit(item) for item in ("a", "b", "c")))
+
+    assert results == ["A", "B", "C"]
+    assert complete.batches == [["a", "b"], ["c"]]
+    assert 0.04 < loop.time() - started < 0.5
+
+
+
+@pytest.mark.asyncio
+async def test_groups_never_share_a_batch():
+    """Test that requests of different groups are completed in separate calls"""
+    complete = EchoCompleter()
+    batcher = MicroBatcher(complete, max_size=10, max_wait=0.05)
+
+    results = await asyncio.gather(batcher.submit("a", group="k1"), batcher.submit("b", group="k2"),
+                                   batcher.submit("c", group="k1"))
+
+    assert results == ["A", "B", "C"]
+    assert sorted(complete.batches) == [["a", "c"], ["b"]]
+
+@pytest.mark.asyncio
+async def test_cancelled_caller_dropped_from_batch():
+    """Test that a caller cancelled before the batch is sent isn't part of it, and others still get theirs"""
+    complete = EchoCompleter()
+    batcher = MicroBatcher(complete, max_size=10, max_wait=0.05)
+
+    first = asyncio.ensure_future(batcher.submit("first"))
+    gone = asyncio.ensure_future(batcher.submit("gone"))
+    await asyncio.sleep(0)
+    gone.cancel()
+
+    assert await first == "FIRST"
+    assert complete.batches == [["first"]]
+
+
+@pytest.mark.asyncio
+async def test_call_cancelled_when_no_caller_waits():
+    """Test that a batch's call is cancelled once all of its callers were cancelled"""
+    complete = EchoCompleter(delay=5)
+    batcher = MicroBatcher(complete, max_size=1, max_wait=0.05)
+
+    caller = asyncio.ensure_future(batcher.submit("slow"))
+    await asyncio.sleep(0.01)
+    caller.cancel()
+    await asyncio.sleep(0.01)
+
+    assert complete.batches == [["slow"]]
+    assert complete.cancelled
+
+
+@pytest.mark.asyncio
+async def test_batch_failure_raised_to_every_caller():
+    """Test that a failed call, or one with a result missing, fails every caller of the batch"""
+    async def failing(items):
+        raise RuntimeError("LLM unavailable")
+
+    async def short(items):
+        return items[:-1]
+
+    for complete, error in ((failing, "LLM unavailable"), (short, "returned 1 results")):
+        batcher = MicroBatcher(complete, max_size=2, max_wait=0.05)
+        results = await asyncio.gather(batcher.submit("a"), batcher.submit("b"), return_exceptions=True)
+
+        assert all(isinstance(result, (RuntimeError, ValueError)) for result in results)
+        assert all(error in str(result) for result in results)
@@ -0,0 +1,95 @@
+import json
+from types import SimpleNamespace
+from unittest.mock import patch
+
+import httpx
+import pytest
+
+from app.services.llm_providers import AnthropicChatClient, OllamaChatClient, create_chat_client
+from app.services.openai_service import OpenAIService, LLMRateLimited
+
+INTERPRETATION = {"target": {"host": "10.0.0.1"}, "operation": {"command": "GET", "oids": ["1.3.6.1.2.1.1.5.0"]}}
+
+
+def _http(handler, requests):
+    """HTTP client answering with the handler, recording the requests' URLs, headers and bodies"""
+    def record(request):
+        requests.append((request.url, request.headers, json.loads(request.content)))
+        return handler(request)
+
+    return httpx.Client(transport=httpx.MockTransport(record))
+
+
+def _service(client):
+    service = OpenAIService()
+    service.client = client
+    service.retry_base_delay = 0
+    return service
+
+
+@pytest.mark.asyncio
+async def test_anthropic_interprets_query():
+    """Test that Anthropic gets the system prompt apart with the API key, and a fenced JSON answer is unwrapped"""
+    requests = []
+    answer = f"```json\n{json.dumps(INTERPRETATION)}\n```"
+    http = _http(lambda request: httpx.Response(200, json={"content": [{"type": "text", "text": answer}]}), requests)
+    service = _service(AnthropicChatClient("sk-ant-test", http_client=http))
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"):
+        snmp_query = await service.process_query("get sysName from 10.0.0.1")
+
+    assert snmp_query.target.host == "10.0.0.1"
+    url, headers, body = requests[0]
+    assert url == "https://api.anthropic.com/v1/messages"
+    assert headers["x-api-key"] == "sk-ant-test"
+    assert body["system"].startswith(service.system_prompt.strip())
+    assert [message["role"] for message in body["messages"]] == ["user"]
+    assert body["max_tokens"] == service.max_tokens
+
+
+@pytest.mark.asyncio
+async def test_provider_rate_limit_surfaced():
+    """Test that a provider's 429 is retried and then surfaced with its Retry-After, like OpenAI's"""
+    requests = []
+    http = _http(lambda request: httpx.Response(429, text="slow down", headers={"Retry-After": "3"}), requests)
+    service = _service(AnthropicChatClient("sk-ant-test", http_client=http))
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"):
+        with pytest.raises(LLMRateLimited) as raised:
+            await service.process_query("get sysName from 10.0.0.1")
+
+    assert raised.value.retry_after == 3
+    assert len(requests) == service.max_retries + 1
+
+
+def test_ollama_streams_json():
+    """Test that Ollama is asked for JSON and its streamed lines arrive as chunks of the answer"""
+    requests = []
+    text = json.dumps(INTERPRETATION)
+    lines = "\n".join(json.dumps({"message": {"content": text[i:i + 10]}, "done": False}) for i in range(0, len(text), 10))
+    http = _http(lambda request: httpx.Response(200, text=lines + '\n{"done": true}'), requests)
+    client = OllamaChatClient("http://ollama:11434/", timeout=5, http_client=http)
+
+    stream = client.chat.completions.create(model="llama3.1", messages=[{"role": "user", "content": "get sysName"}],
+                                            response_format={"type": "json_object"}, stream=True, max_tokens=200)
+
+    assert "".join(chunk.choices[0].delta.content for chunk in stream) == text
+    url, _, body = requests[0]
+    assert url == "http://ollama:11434/api/chat"
+    assert body["format"] == "json"
+    assert body["options"]["num_predict"] == 200
+
+
+def test_create_chat_client_by_provider():
+    """Test that the configured provider's client is created, and an unknown provider is an error"""
+    settings = SimpleNamespace(provider="ollama", api_key="", anthropic_api_key="", anthropic_url="",
+                               ollama_url="http://localhost:11434", timeout=60)
+    assert isinstance(create_chat_client(settings), OllamaChatClient)
+
+    settings.provider = "anthropic"
+    with pytest.raises(ValueError, match="ANTHROPIC_API_KEY"):
+        create_chat_client(settings)
+
+    settings.provider = "gemini"
+    with pytest.raises(ValueError, match="Unknown LLM provider gemini"):
+        create_chat_client(settings)
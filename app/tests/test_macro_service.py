@@ -0,0 +1,97 @@
+import pytest
+from unittest.mock import patch, MagicMock, AsyncMock
+
+from app.services.macro_service import MacroService, MacroError
+from app.services.snmp_service import SNMPService
+
+MACROS = {
+    "uptime-check": {
+        "description": "Uptime and name of a device",
+        "parameters": {"host": None, "community": "public", "port": "161"},
+        "steps": [
+            {"label": "uptime", "target": {"host": "{host}", "port": "{port}"},
+             "credentials": {"community": "{community}"},
+             "operation": {"command": "GET", "oids": ["1.3.6.1.2.1.1.3.0"]}},
+            {"label": "name", "target": {"host": "{host}", "port": "{port}"},
+             "credentials": {"community": "{community}"},
+             "operation": {"command": "GET", "oids": ["1.3.6.1.2.1.1.5.0"]}},
+        ],
+    },
+}
+
+
+@pytest.fixture
+def snmp_service():
+    service = MagicMock(spec=SNMPService)
+    service.enrich_results.return_value = []
+    return service
+
+
+def test_build_steps_substitutes_parameters():
+    """Test that parameters and defaults are substituted into every step"""
+    with patch("app.services.macro_service.config.macros", MACROS):
+        steps = MacroService(snmp_service=MagicMock()).build_steps(
+            "uptime-check", {"host": "10.0.0.1", "community": "s3cret"}
+        )
+
+    assert [step["label"] for step in steps] == ["uptime", "name"]
+    query = steps[1]["query"]
+    assert query.target.host == "10.0.0.1"
+    assert query.target.port == 161
+    assert query.credentials.community == "s3cret"
+    assert query.operation.oids == ["1.3.6.1.2.1.1.5.0"]
+
+
+@pytest.mark.parametrize("name,parameters,message", [
+    ("no-such-macro", {}, "Unknown macro: no-such-macro"),
+    ("uptime-check", {}, "Missing parameters for macro uptime-check: host"),
+    ("uptime-check", {"host": "10.0.0.1", "hots": "x"}, "Unknown parameters for macro uptime-check: hots"),
+])
+def test_build_steps_errors(name, parameters, message):
+    """Test errors for unknown macros and missing or unexpected parameters"""
+    with patch("app.services.macro_service.config.macros", MACROS):
+        with pytest.raises(MacroError) as exc_info:
+            MacroService(snmp_service=MagicMock()).build_steps(name, parameters)
+
+    assert str(exc_info.value) == message
+
+
+@pytest.mark.asyncio
+async def test_run_macro_labels_each_step(snmp_service):
+    """Test that running a macro executes each step in order and labels the results"""
+    snmp_service.execute_query = AsyncMock(side_effect=[
+        {"SNMPv2-MIB::sysUpTime.0": 12345},
+        {"error": "SNMP request timed out"},
+    ])
+
+    with patch("app.services.macro_service.config.macros", MACROS):
+        response = await MacroService(snmp_service=snmp_service).run("uptime-check", {"host": "10.0.0.1"})
+
+    assert response.macro == "uptime-check"
+    assert [step.label for step in response.steps] == ["uptime", "name"]
+    assert response.steps[0].raw_data == {"SNMPv2-MIB::sysUpTime.0": 12345}
+    assert response.steps[0].error is None
+    assert response.steps[1].error == "SNMP request timed out"
+
+    executed = [call.args[0] for call in snmp_service.execute_query.await_args_list]
+    assert [query.operation.oids for query in executed] == [["1.3.6.1.2.1.1.3.0"], ["1.3.6.1.2.1.1.5.0"]]
+    assert all(query.target.host == "10.0.0.1" for query in executed)
+    # Plans never carry the community string
+    assert "community" not in response.steps[0].plan["credentials"]
+
+
+def test_list_macros():
+    """Test that the macro listing shows parameters and step labels"""
+    with patch("app.services.macro_service.config.macros", MACROS):
+        macros = MacroService(snmp_service=MagicMock()).list_macros()
+
+    assert macros == [{
+        "name": "uptime-check",
+        "description": "Uptime and name of a device",
+        "parameters": {
+            "host": {"required": True, "default": None},
+            "community": {"required": False, "default": "public"},
+            "port": {"required": False, "default": "161"},
+        },
+        "steps": ["uptime", "name"],
+    }]
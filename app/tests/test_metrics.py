@@ -0,0 +1,49 @@
+import pytest
+
+from app.utils.metrics import MetricsRegistry, timeout_buckets
+
+
+def test_counter_and_histogram_render():
+    """Test counters and histograms are rendered per label set in the OpenMetrics format"""
+    metrics = MetricsRegistry()
+    operations = metrics.counter("snmp_operations", "SNMP operations", ("operation", "outcome"))
+    duration = metrics.histogram("snmp_operation_duration_seconds", "Duration", ("operation",), buckets=(0.1, 1))
+
+    operations.inc(operation="GET", outcome="success")
+    operations.inc(operation="GET", outcome="success")
+    operations.inc(operation="WALK", outcome="timeout")
+    duration.observe(0.05, operation="GET")
+    duration.observe(1, operation="GET")
+    duration.observe(7, operation="GET")
+
+    assert operations.value(operation="GET", outcome="success") == 2
+    assert duration.count(operation="GET") == 3
+    assert metrics.render().splitlines() == [
+        "# TYPE snmp_operations counter",
+        "# HELP snmp_operations SNMP operations",
+        'snmp_operations_total{operation="GET",outcome="success"} 2',
+        'snmp_operations_total{operation="WALK",outcome="timeout"} 1',
+        "# TYPE snmp_operation_duration_seconds histogram",
+        "# HELP snmp_operation_duration_seconds Duration",
+        'snmp_operation_duration_seconds_bucket{operation="GET",le="0.1"} 1',
+        'snmp_operation_duration_seconds_bucket{operation="GET",le="1.0"} 2',
+        'snmp_operation_duration_seconds_bucket{operation="GET",le="+Inf"} 3',
+        'snmp_operation_duration_seconds_count{operation="GET"} 3',
+        'snmp_operation_duration_seconds_sum{operation="GET"} 8.05',
+        "# EOF",
+    ]
+
+
+def test_timeout_buckets():
+    """Test latency buckets stop at the timeout, which is a bucket of its own"""
+    assert timeout_buckets(5) == (0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0)
+    assert timeout_buckets(15) == (0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0, 15.0)
+
+
+def test_labels_must_match():
+    """Test a sample must carry exactly the metric's labels"""
+    metrics = MetricsRegistry()
+    operations = metrics.counter("snmp_operations", "SNMP operations", ("operation", "outcome"))
+
+    with pytest.raises(ValueError):
+        operations.inc(operation="GET", target="10.0.0.1")
@@ -0,0 +1,192 @@
+import asyncio
+import os
+from types import SimpleNamespace
+from unittest.mock import patch
+
+import pytest
+
+from app.services.mib_service import MIBService
+from app.services.mib_repository import MIBRepository, MIBSource, MIBSourceError, STATE_FILE, checksum
+from app.services.operation_service import OperationRegistry
+
+MODULES = ["IF-MIB", "IP-MIB", "TCP-MIB", "UDP-MIB"]
+
+
+class MockSource(MIBSource):
+    """
+    Serves MIB files from a dict, recording the modules fetched
+
+    fail maps a module to the error to raise; fetching the hang module blocks
+    until cancelled, setting the started event.
+    """
+
+    def __init__(self, files, checksums=None):
+        self.files = dict(files)
+        self.checksums = checksums
+        self.fail = {}
+        self.hang = None
+        self.started = asyncio.Event()
+        self.fetched = []
+
+    async def fetch(self, module):
+        if module == self.hang:
+            self.started.set()
+            await asyncio.sleep(60)
+        if module in self.fail:
+            raise self.fail.pop(module)
+        self.fetched.append(module)
+        return self.files[module]
+
+    async def checksum(self, module):
+        return self.checksums.get(module) if self.checksums is not None else None
+
+
+def _files():
+    return {module: f"{module} DEFINITIONS ::= BEGIN END".encode() for module in MODULES}
+
+
+def _repository(tmp_path, source):
+    loaded_mibs = set()
+
+    def load_mib(text):
+        module = text.split()[0]
+        loaded_mibs.add(module)
+        return {module: 0}
+
+    mib_service = SimpleNamespace(mib_dir=str(tmp_path), loaded_mibs=loaded_mibs, load_mib=load_mib)
+    return MIBRepository(mib_service, source)
+
+
+@pytest.mark.asyncio
+async def test_resume_after_interruption(tmp_path):
+    """Test that an update interrupted by a network error resumes with only the modules it didn't finish"""
+    source = MockSource(_files())
+    source.fail["TCP-MIB"] = ConnectionResetError("Connection reset by peer")
+    repository = _repository(tmp_path, source)
+
+    with pytest.raises(ConnectionResetError):
+        await repository.update(MODULES)
+    assert source.fetched == ["IF-MIB", "IP-MIB"]
+    assert sorted(repository.load_state()) == ["IF-MIB", "IP-MIB"]
+    assert not os.path.exists(tmp_path / "TCP-MIB.mib")
+
+    source.fetched.clear()
+    progress = await repository.update(MODULES)
+
+    assert source.fetched == ["TCP-MIB", "UDP-MIB"]
+    assert progress.status == "completed"
+    assert progress.skipped == ["IF-MIB", "IP-MIB"]
+    assert progress.downloaded == ["TCP-MIB", "UDP-MIB"]
+    assert sorted(repository.load_state()) == sorted(MODULES)
+    assert repository.mib_service.loaded_mibs == set(MODULES)
+    assert (tmp_path / "UDP-MIB.mib").read_bytes() == _files()["UDP-MIB"]
+
+
+@pytest.mark.asyncio
+async def test_failed_module_retried_on_next_run(tmp_path):
+    """Test that a module the source couldn't serve is reported, and is the only one fetched on the next run"""
+    source = MockSource(_files())
+    source.fail["IP-MIB"] = MIBSourceError("HTTP 503")
+    repository = _repository(tmp_path, source)
+
+    progress = await repository.update(MODULES)
+    assert progress.status == "failed"
+    assert progress.failed == {"IP-MIB": "HTTP 503"}
+    assert progress.downloaded == ["IF-MIB", "TCP-MIB", "UDP-MIB"]
+
+    source.fetched.clear()
+    progress = await repository.update(MODULES)
+    assert progress.status == "completed"
+    assert source.fetched == ["IP-MIB"]
+
+
+@pytest.mark.asyncio
+async def test_cancel_and_resume(tmp_path):
+    """Test that cancelling the operation stops the update, keeping the completed modules for the next run"""
+    source = MockSource(_files())
+    source.hang = "IP-MIB"
+    repository = _repository(tmp_path, source)
+    registry = OperationRegistry()
+    operation = registry.start("mib-download", "test")
+
+    update = asyncio.create_task(repository.update(MODULES, operation=operation))
+    await source.started.wait()
+    registry.cancel(operation.id)
+    progress = await update
+
+    assert progress.status == "cancelled"
+    assert progress.downloaded == ["IF-MIB"]
+    assert progress.describe()["done"] == 1
+    assert progress.finished_at is not None
+
+    source.hang = None
+    source.fetched.clear()
+    progress = await repository.update(MODULES)
+    assert source.fetched == ["IP-MIB", "TCP-MIB", "UDP-MIB"]
+
+
+@pytest.mark.asyncio
+async def test_checksums_skip_unchanged_and_fetch_changed(tmp_path):
+    """Test that published checksums skip unchanged modules, fetch changed or locally modified ones, and are verified"""
+    files = _files()
+    source = MockSource(files, checksums={module: checksum(content) for module, content in files.items()})
+    repository = _repository(tmp_path, source)
+    await repository.update(MODULES)
+
+    # Changed at the source, and modified locally
+    source.files["IF-MIB"] = b"IF-MIB DEFINITIONS ::= BEGIN ifNumber END"
+    source.checksums["IF-MIB"] = checksum(source.files["IF-MIB"])
+    (tmp_path / "TCP-MIB.mib").write_bytes(b"edited")
+    # Corrupted in transit
+    source.files["UDP-MIB"] = b"garbage"
+    os.remove(tmp_path / "UDP-MIB.mib")
+
+    source.fetched.clear()
+    progress = await repository.update(MODULES)
+
+    assert source.fetched == ["IF-MIB", "TCP-MIB", "UDP-MIB"]
+    assert progress.skipped == ["IP-MIB"]
+    assert progress.downloaded == ["IF-MIB", "TCP-MIB"]
+    assert "Checksum mismatch" in progress.failed["UDP-MIB"]
+    assert not os.path.exists(tmp_path / "UDP-MIB.mib")
+    assert (tmp_path / "IF-MIB.mib").read_bytes() == source.files["IF-MIB"]
+
+
+@pytest.mark.asyncio
+async def test_invalid_module_names_and_state(tmp_path):
+    """Test that module names that aren't file-safe are rejected, and an unreadable state file is ignored"""
+    repository = _repository(tmp_path, MockSource(_files()))
+
+    with pytest.raises(ValueError, match="../etc/passwd"):
+        await repository.update(["IF-MIB", "../etc/passwd"])
+
+    (tmp_path / STATE_FILE).write_text("{not json")
+    assert repository.load_state() == {}
+    progress = await repository.update(["IF-MIB"])
+    assert progress.downloaded == ["IF-MIB"]
+
+
+@pytest.mark.asyncio
+async def test_downloaded_modules_indexed(tmp_path):
+    """Test that downloaded modules are parsed into the index, and a file without a module is refused"""
+    source = MockSource({
+        "ACME-MIB": b"""
+        ACME-MIB DEFINITIONS ::= BEGIN
+        IMPORTS enterprises FROM SNMPv2-SMI;
+        acmeProducts OBJECT IDENTIFIER ::= { enterprises 4242 }
+        END
+        """,
+        "BROKEN-MIB": b"<html>Not Found</html>",
+    })
+    with patch("app.services.mib_service.config.mib_directory", str(tmp_path)):
+        mib_service = MIBService()
+    repository = MIBRepository(mib_service, source)
+
+    progress = await repository.update(["ACME-MIB", "BROKEN-MIB"])
+
+    assert progress.downloaded == ["ACME-MIB"]
+    assert progress.failed == {"BROKEN-MIB": "No MIB module definition found"}
+    assert mib_service.resolve_name("acmeProducts") == "1.3.6.1.4.1.4242"
+    assert {mib["name"]: mib["objects"] for mib in mib_service.list_mibs()}["ACME-MIB"] == 1
+    assert "BROKEN-MIB" not in mib_service.loaded_mibs
+    assert not os.path.exists(tmp_path / "BROKEN-MIB.mib")
@@ -1,9 +1,14 @@
 import pytest
+import re
 import os
+import sys
 import tempfile
+import threading
+import time
 from unittest.mock import patch, MagicMock
 
-from app.services.mib_service import MIBService
+from app.services.mib_service import MIBService, MIBConflictError, UnresolvedNameError, normalize_oid
+from app.utils.mib_parser import parse_mib
 
 
 @pytest.fixture
@@ -107,3 +112,885 @@ def test_add_mib_file(sample_mib_content):
     finally:
         # Clean up the temporary file
         os.unlink(tmp_file_path)
+
+
+def test_resolve_alias():
+    """Test resolving a built-in alias"""
+    service = MIBService()
+
+    assert service.resolve_oid("uptime") == "1.3.6.1.2.1.1.3.0"
+    assert service.resolve_oid("UpTime") == "1.3.6.1.2.1.1.3.0"
+    assert service.resolve_oid("ifstatus.3") == "1.3.6.1.2.1.2.2.1.8.3"
+
+
+def test_alias_precedence_over_mib():
+    """Test that aliases take precedence over MIB resolution"""
+    aliases = {"IF-MIB::ifDescr": "1.3.6.1.2.1.31.1.1.1.1", "speed": "IF-MIB::ifSpeed"}
+
+    with patch("app.services.mib_service.config.oid_aliases", aliases):
+        service = MIBService()
+
+        # Alias shadows the MIB-defined name, including with an index
+        assert service.resolve_oid("IF-MIB::ifDescr") == "1.3.6.1.2.1.31.1.1.1.1"
+        assert service.resolve_oid("IF-MIB::ifDescr.2") == "1.3.6.1.2.1.31.1.1.1.1.2"
+
+        # Aliases may point at a symbolic name in the MIB index
+        assert service.resolve_oid("speed") == "1.3.6.1.2.1.2.2.1.5"
+
+        # Names without an alias still resolve through the MIB index
+        assert service.resolve_oid("SNMPv2-MIB::sysName.0") == "1.3.6.1.2.1.1.5.0"
+
+        assert service.get_aliases() == {
+            "if-mib::ifdescr": "1.3.6.1.2.1.31.1.1.1.1",
+            "speed": "IF-MIB::ifSpeed",
+        }
+
+
+def test_get_oid_mib():
+    """Test that the defining MIB module is tracked per object"""
+    service = MIBService()
+
+    assert service.get_oid_mib("1.3.6.1.2.1.1.5.0") == "SNMPv2-MIB"
+    assert service.get_oid_mib(".1.3.6.1.2.1.2.2.1.8.3") == "IF-MIB"
+    assert service.get_oid_mib("1.3.6.1.4.1.9.1.1") is None
+
+
+def test_rebuild_index_restores_lookups():
+    """Test that rebuilding the reverse index repairs corrupted and missing entries"""
+    service = MIBService()
+    entries = len(service.oid_name_cache)
+
+    # Corrupt the index: a wrong name, a missing entry and a stray one
+    service.oid_name_cache["1.3.6.1.2.1.1.5.0"] = "SNMPv2-MIB::sysLocation.0"
+    del service.oid_name_cache["1.3.6.1.2.1.2.2.1.8"]
+    del service.oid_mib_cache["1.3.6.1.2.1.2.2.1.8"]
+    service.oid_name_cache["1.3.6.1.4.1.9.9"] = "BOGUS-MIB::bogus"
+
+    assert service.translate_oid("1.3.6.1.2.1.1.5.0") == "SNMPv2-MIB::sysLocation.0"
+    assert service.get_oid_mib("1.3.6.1.2.1.2.2.1.8.3") is None
+
+    assert service.rebuild_index() == entries
+
+    assert service.translate_oid("1.3.6.1.2.1.1.5.0") == "SNMPv2-MIB::sysName.0"
+    assert service.translate_oid("1.3.6.1.2.1.2.2.1.8.3") == "IF-MIB::ifOperStatus.3"
+    assert service.get_oid_mib("1.3.6.1.2.1.2.2.1.8.3") == "IF-MIB"
+    assert service.translate_oid("1.3.6.1.4.1.9.9") is None
+
+
+def test_rebuild_index_while_querying():
+    """Test that queries running during repeated rebuilds always see a complete index"""
+    service = MIBService()
+    stop = threading.Event()
+    failures = []
+
+    def query():
+        while not stop.is_set():
+            lookups = (
+                service.translate_oid("1.3.6.1.2.1.2.2.1.8.3"),
+                service.get_oid_mib("1.3.6.1.2.1.1.5.0"),
+                service.full_path("1.3.6.1.2.1.2.2.1.10.1"),
+                len(service.objects_under("1.3.6.1.2.1.2.2.1")),
+            )
+            if lookups != ("IF-MIB::ifOperStatus.3", "SNMPv2-MIB",
+                           "iso.org.dod.internet.mgmt.mib-2.interfaces.ifTable.ifEntry.ifInOctets.1", 10):
+                failures.append(lookups)
+
+    # Switch threads as often as possible, so readers run in the middle of rebuilds
+    switch_interval = sys.getswitchinterval()
+    sys.setswitchinterval(1e-6)
+    readers = [threading.Thread(target=query) for _ in range(4)]
+    for reader in readers:
+        reader.start()
+    try:
+        for _ in range(300):
+            service.rebuild_index()
+    finally:
+        stop.set()
+        for reader in readers:
+            reader.join()
+        sys.setswitchinterval(switch_interval)
+
+    assert failures == []
+
+
+def test_definitions_swapped_with_reverse_index():
+    """Test that definitions are swapped in together with the reverse index, as one snapshot"""
+    service = MIBService()
+    before = service.reverse_index
+
+    service.add_definitions("ACME-MIB", {"acmeProducts": "1.3.6.1.4.1.4242"})
+
+    assert "ACME-MIB::acmeProducts" not in before.definitions
+    assert service.reverse_index.definitions["ACME-MIB::acmeProducts"] == "1.3.6.1.4.1.4242"
+    assert service.reverse_index.oid_name["1.3.6.1.4.1.4242"] == "ACME-MIB::acmeProducts"
+    assert service.name_oid_cache is service.reverse_index.definitions
+
+
+@pytest.mark.parametrize("style,oid,name,expected", [
+    ("numeric", "1.3.6.1.2.1.2.2.1.10.3", "IF-MIB::ifInOctets.3", "1.3.6.1.2.1.2.2.1.10.3"),
+    ("short", "1.3.6.1.2.1.2.2.1.10.3", "IF-MIB::ifInOctets.3", "ifInOctets.3"),
+    ("module", "1.3.6.1.2.1.2.2.1.10.3", "IF-MIB::ifInOctets.3", "IF-MIB::ifInOctets.3"),
+    ("full", "1.3.6.1.2.1.2.2.1.10.3", "IF-MIB::ifInOctets.3",
+     "iso.org.dod.internet.mgmt.mib-2.interfaces.ifTable.ifEntry.ifInOctets.3"),
+    ("full", "1.3.6.1.2.1.1.5.0", "SNMPv2-MIB::sysName.0", "iso.org.dod.internet.mgmt.mib-2.system.sysName.0"),
+    # No symbolic mapping: numeric stays numeric, the full path names what it can
+    ("numeric", "1.3.6.1.4.1.9.9.13.1.3.1.3.1", None, "1.3.6.1.4.1.9.9.13.1.3.1.3.1"),
+    ("short", "1.3.6.1.4.1.9.9.13.1.3.1.3.1", None, None),
+    ("module", "1.3.6.1.4.1.9.9.13.1.3.1.3.1", None, None),
+    ("full", "1.3.6.1.4.1.9.9.13.1.3.1.3.1", None, "iso.org.dod.internet.private.enterprises.9.9.13.1.3.1.3.1"),
+    # A name that doesn't resolve to an OID is kept as it is
+    ("full", None, "bogusName.1", "bogusName.1"),
+])
+def test_format_name_styles(style, oid, name, expected):
+    """Test writing result names numerically, as short or module-qualified names, and as full paths"""
+    assert MIBService().format_name(oid, name, style) == expected
+
+
+ACME_SET_MIB = """
+ACME-SET-MIB DEFINITIONS ::= BEGIN
+IMPORTS
+    OBJECT-TYPE, Integer32, Unsigned32, Gauge32, IpAddress, enterprises FROM SNMPv2-SMI
+    TruthValue FROM SNMPv2-TC;
+
+acmeThreshold OBJECT-TYPE SYNTAX Gauge32 MAX-ACCESS read-write STATUS current
+    DESCRIPTION "Alarm threshold" ::= { enterprises 9 9 1 }
+acmeGateway OBJECT-TYPE SYNTAX IpAddress MAX-ACCESS read-write STATUS current
+    DESCRIPTION "Default gateway" ::= { enterprises 9 9 2 }
+acmeBudget OBJECT-TYPE SYNTAX Unsigned32 MAX-ACCESS read-create STATUS current
+    DESCRIPTION "Budget" ::= { enterprises 9 9 3 }
+acmeMode OBJECT-TYPE SYNTAX AcmeMode MAX-ACCESS read-write STATUS current
+    DESCRIPTION "Operating mode, of a textual convention defined elsewhere" ::= { enterprises 9 9 4 }
+acmeEnabled OBJECT-TYPE SYNTAX TruthValue MAX-ACCESS read-write STATUS current
+    DESCRIPTION "Whether the feature is on" ::= { enterprises 9 9 5 }
+acmeLevel OBJECT-TYPE SYNTAX Integer32 (0..100) MAX-ACCESS read-write STATUS current
+    DESCRIPTION "Level" ::= { enterprises 9 9 6 }
+acmeTemperature OBJECT-TYPE SYNTAX Integer32 MAX-ACCESS read-only STATUS current
+    DESCRIPTION "Temperature" ::= { enterprises 9 9 7 }
+END
+"""
+
+
+def _set_mibs():
+    mib_service = MIBService()
+    mib_service.load_mib(ACME_SET_MIB)
+    return mib_service
+
+
+@pytest.mark.parametrize("oid,value_type,value,expected", [
+    # Enumeration labels resolve to their numbers, in any case, and the type comes from the SYNTAX
+    ("1.3.6.1.2.1.2.2.1.7.3", "Integer", "down", ("Integer", 2)),
+    ("1.3.6.1.2.1.2.2.1.7.3", None, "UP", ("Integer", 1)),
+    ("1.3.6.1.2.1.2.2.1.7.3", "Integer", 3, ("Integer", 3)),
+    ("1.3.6.1.2.1.2.2.1.7.3", None, "2", ("Integer", 2)),
+    # Strings within their SIZE, and integers within the type's range
+    ("1.3.6.1.2.1.1.6.0", "OctetString", "rack 4", ("OctetString", "rack 4")),
+    # Objects of loaded MIBs, by their SYNTAX and the textual conventions known here
+    ("1.3.6.1.4.1.9.9.1.0", "Gauge32", "4000000000", ("Gauge32", 4000000000)),
+    ("1.3.6.1.4.1.9.9.2.0", None, "10.0.0.1", ("IpAddress", "10.0.0.1")),
+    ("1.3.6.1.4.1.9.9.3.0", None, 5, ("Unsigned32", 5)),
+    ("1.3.6.1.4.1.9.9.5.0", None, "false", ("Integer", 2)),
+    ("1.3.6.1.4.1.9.9.6.0", None, "100", ("Integer", 100)),
+    # A textual convention not known here takes the type given
+    ("1.3.6.1.4.1.9.9.4.0", "Integer", 3, ("Integer", 3)),
+])
+def test_resolve_set_value(oid, value_type, value, expected):
+    """Test that SET values are resolved to the type and value of the object's SYNTAX"""
+    assert _set_mibs().resolve_set_value(oid, value_type, value) == expected
+
+
+@pytest.mark.parametrize("oid,value_type,value,error", [
+    ("1.3.6.1.2.1.2.2.1.7.3", "Integer", "sideways", "expected one of up(1), down(2), testing(3)"),
+    ("1.3.6.1.2.1.2.2.1.7.3", "Integer", 7, "can't be set to 7"),
+    ("1.3.6.1.2.1.2.2.1.7.3", "OctetString", "down", "is Integer, so it can't be set to an OctetString value"),
+    ("1.3.6.1.2.1.2.2.1.8.3", "Integer", "down", "IF-MIB::ifOperStatus.3 is read-only"),
+    ("1.3.6.1.2.1.1.5.0", "OctetString", "x" * 256, "must be 0 to 255 bytes long, not 256"),
+    ("1.3.6.1.4.1.9.9.4.0", None, 1, "give the type of the value"),
+    ("1.3.6.1.4.1.9.9.3.0", "Unsigned32", -1, "must be between 0 and 4294967295"),
+    ("1.3.6.1.4.1.9.9.6.0", "Integer", "lots", "is Integer, so it can't be set to 'lots'"),
+    ("1.3.6.1.4.1.9.9.6.0", None, 101, "must be between 0 and 100, not 101"),
+    ("1.3.6.1.4.1.9.9.2.0", "IpAddress", "10.0.0.300", "is not an IPv4 address"),
+    ("1.3.6.1.4.1.9.9.1.0", "Float", 1, "Unknown SET value type Float"),
+    # Read-only objects of loaded MIBs, and objects of no loaded MIB, aren't written
+    ("1.3.6.1.4.1.9.9.7.0", "Integer", 1, "acmeTemperature.0 is read-only"),
+    ("1.3.6.1.4.1.9.9.99.0", "Integer", 1, "is not an object of a loaded MIB"),
+    ("1.3.6.1.4.1.8072.1.0", "Gauge32", 1, "is not an object of a loaded MIB"),
+])
+def test_resolve_set_value_rejects_mismatches(oid, value_type, value, error):
+    """Test that SET values not matching the object's type, range, enumeration or access are rejected"""
+    with pytest.raises(ValueError, match=re.escape(error)):
+        _set_mibs().resolve_set_value(oid, value_type, value)
+
+
+def _mib_dir_with(tmp_path, **files):
+    for file_name, content in files.items():
+        (tmp_path / file_name).write_text(content)
+    with patch("app.services.mib_service.config.mib_directory", str(tmp_path)):
+        return MIBService()
+
+
+def _bench_mib(number, modules, objects):
+    """A generated MIB module, placed under a node imported from the next module, if there is one"""
+    node = f"bench{number}"
+    if number + 1 < modules:
+        imports, parent = f"bench{number + 1} FROM BENCH-MIB-{number + 1:02d}", f"bench{number + 1} 1"
+    else:
+        imports, parent = "enterprises FROM SNMPv2-SMI", "enterprises 4242"
+    definitions = "".join(f"""
+    bench{number}Object{column} OBJECT-TYPE
+        SYNTAX      Integer32 (0..{column})
+        MAX-ACCESS  read-only
+        STATUS      current
+        DESCRIPTION "Generated object {column} of module {number}, next to bench{number}Object{column + 1}.
+                     Its values go up to {column}, like those of the other generated objects."
+        ::= {{ {node} {column + 2} }}
+    """ for column in range(objects))
+    return f"""
+    BENCH-MIB-{number:02d} DEFINITIONS ::= BEGIN
+    IMPORTS
+        OBJECT-TYPE, Integer32 FROM SNMPv2-SMI
+        {imports};
+
+    {node} OBJECT IDENTIFIER ::= {{ {parent} }}
+    {definitions}
+    END
+    """
+
+
+def test_mib_directory_parse_benchmark(tmp_path):
+    """Benchmark parsing a large MIB directory in this process against a pool of processes"""
+    for number in range(40):
+        (tmp_path / f"BENCH-MIB-{number:02d}.mib").write_text(_bench_mib(number, 40, 60))
+    workers = max(2, os.cpu_count() or 1)
+
+    def load(parse_workers):
+        with patch("app.services.mib_service.config.mib_directory", str(tmp_path)), \
+                patch("app.services.mib_service.config.mib_parse_workers", parse_workers), \
+                patch("app.services.mib_service.os.cpu_count", return_value=workers):
+            started = time.perf_counter()
+            service = MIBService()
+            load_seconds = time.perf_counter() - started
+            started = time.perf_counter()
+            service.parsed_files.clear()
+            modules, _ = service._read_mib_files()
+            return modules, service, time.perf_counter() - started, load_seconds
+
+    sequential, sequential_service, sequential_parse, sequential_load = load(1)
+    parallel, parallel_service, parallel_parse, parallel_load = load(0)
+
+    print(f"\n40 MIB files, {workers} workers: parsing sequential {sequential_parse:.2f}s, parallel "
+          f"{parallel_parse:.2f}s; loading sequential {sequential_load:.2f}s, parallel {parallel_load:.2f}s")
+    assert parallel == sequential
+    assert parallel_service.name_oid_cache == sequential_service.name_oid_cache
+    # Every module placed, though each depends on the module after it
+    assert parallel_service.resolve_oid("BENCH-MIB-00::bench0Object59") == "1.3.6.1.4.1.4242" + ".1" * 39 + ".61"
+    assert {f"BENCH-MIB-{number:02d}" for number in range(40)} <= parallel_service.loaded_mibs
+
+
+
+def test_mib_directory_indexed_once(tmp_path):
+    """Test that loading a MIB directory rebuilds the index once for all of its modules, not once per module"""
+    for number in range(5):
+        (tmp_path / f"BENCH-MIB-{number:02d}.mib").write_text(_bench_mib(number, 5, 3))
+    rebuild = MIBService._build_reverse_index
+
+    with patch.object(MIBService, "_build_reverse_index", autospec=True, side_effect=rebuild) as build:
+        service = _mib_dir_with(tmp_path)
+
+    # The built-in objects, then the directory
+    assert build.call_count == 2
+    assert service.resolve_oid("BENCH-MIB-00::bench0Object2") == "1.3.6.1.4.1.4242" + ".1" * 4 + ".4"
+
+
+def test_mib_health_parses_only_changed_files(tmp_path, sample_mib_content):
+    """Test that the MIB health check reuses the files parsed at startup, and parses those changed since"""
+    service = _mib_dir_with(tmp_path, **{"SAMPLE-MIB.mib": sample_mib_content, "IF-MIB-EXCERPT.mib": IF_MIB_EXCERPT})
+
+    with patch("app.services.mib_service.parse_mib", wraps=parse_mib) as parse:
+        assert service.health()["healthy"] is True
+        assert parse.call_count == 0
+
+        (tmp_path / "SAMPLE-MIB.mib").write_text(sample_mib_content + "\n")
+        service.health()
+        assert parse.call_count == 1
+
+
+def test_list_mibs_parses_only_changed_files(tmp_path, sample_mib_content):
+    """Test that listing the MIBs reuses the files already parsed, and parses those changed since"""
+    service = _mib_dir_with(tmp_path, **{"SAMPLE-MIB.mib": sample_mib_content})
+
+    with patch("app.services.mib_service.parse_mib", wraps=parse_mib) as parse:
+        assert {mib["name"]: mib["file"] for mib in service.list_mibs()}["SAMPLE-MIB"] == "SAMPLE-MIB.mib"
+        service.list_mibs()
+        assert parse.call_count == 0
+
+        (tmp_path / "SAMPLE-MIB.mib").write_text(sample_mib_content + "\n")
+        assert {mib["name"]: mib["size"] for mib in service.list_mibs()}["SAMPLE-MIB"] == len(sample_mib_content) + 1
+        assert parse.call_count == 1
+
+
+def test_mib_health_sample_mib_resolves(tmp_path, sample_mib_content):
+    """Test that a MIB importing only from loaded modules is healthy and its objects are counted"""
+    built_in = _mib_dir_with(tmp_path).health()
+    service = _mib_dir_with(tmp_path, **{"SAMPLE-MIB.mib": sample_mib_content})
+
+    health = service.health()
+
+    assert built_in["healthy"] is True
+    assert health["healthy"] is True
+    assert health["modules"] == built_in["modules"] + 1
+    assert health["objects"] == built_in["objects"] + 2  # sampleMIB and sampleOID
+
+
+def test_mib_health_unresolved_import(tmp_path):
+    """Test that a MIB importing from a missing module is unhealthy, with the objects it can't place"""
+    service = _mib_dir_with(tmp_path, **{"ACME-MIB.mib": """
+    ACME-MIB DEFINITIONS ::= BEGIN
+    IMPORTS
+        OBJECT-TYPE, Integer32 FROM SNMPv2-SMI
+        acmeProducts FROM ACME-SMI;  -- ACME-SMI isn't loaded
+
+    acmeFans OBJECT IDENTIFIER ::= { acmeProducts 4 }
+
+    acmeFanSpeed OBJECT-TYPE
+        SYNTAX      Integer32
+        MAX-ACCESS  read-only
+        STATUS      current
+        DESCRIPTION "Fan speed; not ::= { anything } in here"
+        ::= { acmeFans 1 }
+    END
+    """})
+
+    health = service.health()
+
+    assert health["healthy"] is False
+    assert health["unresolved_imports"] == [
+        {"module": "ACME-MIB", "imports_from": "ACME-SMI", "symbols": ["acmeProducts"]}
+    ]
+    assert health["unresolved_objects"] == [
+        {"module": "ACME-MIB", "object": "acmeFans", "parent": "acmeProducts"},
+        {"module": "ACME-MIB", "object": "acmeFanSpeed", "parent": "acmeFans"},
+    ]
+
+
+def test_mib_health_duplicate_oids_and_unparsed_files(tmp_path):
+    """Test that an OID defined by two modules, and a file without a module, are reported"""
+    service = _mib_dir_with(tmp_path, **{
+        "OTHER-MIB.mib": """
+        OTHER-MIB DEFINITIONS ::= BEGIN
+        IMPORTS mib-2 FROM SNMPv2-SMI;
+        otherName OBJECT IDENTIFIER ::= { mib-2 1 5 }
+        END
+        """,
+        "notes.txt": "not a MIB",
+    })
+
+    health = service.health()
+
+    assert health["healthy"] is False
+    assert health["duplicate_oids"] == [
+        {"oid": "1.3.6.1.2.1.1.5", "definitions": ["OTHER-MIB::otherName", "SNMPv2-MIB::sysName"]}
+    ]
+    assert health["unparsed_files"] == ["notes.txt"]
+
+
+@pytest.mark.parametrize("policy, name", [
+    ("first-wins", "ACME-MIB::acmeIfName"),
+    ("last-wins", "ACME-V2-MIB::acmeIfLabel"),
+])
+def test_colliding_oid_settled_by_policy(policy, name):
+    """Test that an OID two loaded modules define is named per the policy and reported in health"""
+    with patch("app.services.mib_service.config.mib_duplicate_policy", policy):
+        service = MIBService()
+        service.add_definitions("ACME-MIB", {"acmeIfName": "1.3.6.1.4.1.99999.1.1"})
+        service.add_definitions("ACME-V2-MIB", {"acmeIfLabel": ".1.3.6.1.4.1.99999.1.1"})
+
+        assert service.translate_oid("1.3.6.1.4.1.99999.1.1.3") == f"{name}.3"
+        assert service.get_oid_mib("1.3.6.1.4.1.99999.1.1") == name.split("::")[0]
+        assert service.health()["index_conflicts"] == [{
+            "oid": "1.3.6.1.4.1.99999.1.1",
+            "definitions": ["ACME-MIB::acmeIfName", "ACME-V2-MIB::acmeIfLabel"],
+            "resolved_to": name,
+        }]
+        assert service.health()["healthy"] is False
+
+
+def test_colliding_oid_refused_by_error_policy():
+    """Test that the error policy refuses a module redefining a loaded OID and keeps the index as it was"""
+    with patch("app.services.mib_service.config.mib_duplicate_policy", "error"):
+        service = MIBService()
+        service.add_definitions("ACME-MIB", {"acmeIfName": "1.3.6.1.4.1.99999.1.1"})
+
+        with pytest.raises(MIBConflictError) as exc_info:
+            service.add_definitions("ACME-V2-MIB", {"acmeIfLabel": "1.3.6.1.4.1.99999.1.1",
+                                                    "acmeIfSpeed": "1.3.6.1.4.1.99999.1.2"})
+
+        assert exc_info.value.conflicts == {"1.3.6.1.4.1.99999.1.1": ["ACME-MIB::acmeIfName", "ACME-V2-MIB::acmeIfLabel"]}
+        assert service.translate_oid("1.3.6.1.4.1.99999.1.1") == "ACME-MIB::acmeIfName"
+        assert service.resolve_oid("ACME-V2-MIB::acmeIfSpeed") is None
+        assert "ACME-V2-MIB" not in service.get_loaded_mibs()
+        assert service.health()["index_conflicts"] == []
+
+
+@pytest.mark.parametrize("oid", [
+    "1.3.6.1.2.1.1.5.0", ".1.3.6.1.2.1.1.5.0", " .1.3.6.1.2.1.1.5.0 ", "iso.3.6.1.2.1.1.5.0",
+    "ISO.3.6.1.2.1.1.5.0", ".iso.3.6.1.2.1.1.5.0", "iso.org.dod.internet.mgmt.mib-2.system.5.0",
+    "iso(1).org(3).dod(6).internet(1).2.1.1.5.0",
+])
+def test_normalize_oid_forms(oid):
+    """Test that leading-dot, no-dot and iso-prefixed OIDs all normalize to the same numeric OID"""
+    assert normalize_oid(oid) == "1.3.6.1.2.1.1.5.0"
+
+
+def test_normalize_oid_leaves_names_alone():
+    """Test that symbolic names, and iso paths through unknown nodes, are only trimmed"""
+    assert normalize_oid("IF-MIB::ifDescr.1") == "IF-MIB::ifDescr.1"
+    assert normalize_oid("sysName.0") == "sysName.0"
+    assert normalize_oid("isolated.1") == "isolated.1"
+    assert normalize_oid("iso.org.dod.internet.mgmt.mib-2.system.sysName.0") == \
+        "iso.org.dod.internet.mgmt.mib-2.system.sysName.0"
+    assert normalize_oid("iso.org.internet.1") == "iso.org.internet.1"
+
+
+def test_resolve_oid_accepts_every_numeric_form():
+    """Test that numeric OIDs in any form resolve to themselves, and names in the index still resolve"""
+    mib_service = MIBService()
+
+    assert mib_service.resolve_oid(".1.3.6.1.2.1.1.5.0") == "1.3.6.1.2.1.1.5.0"
+    assert mib_service.resolve_oid("iso.3.6.1.2.1.1.5.0") == "1.3.6.1.2.1.1.5.0"
+    assert mib_service.resolve_oid("SNMPv2-MIB::sysName.0") == "1.3.6.1.2.1.1.5.0"
+    assert mib_service.get_oid_mib("iso.3.6.1.2.1.2.2.1.2.1") == mib_service.get_oid_mib("1.3.6.1.2.1.2.2.1.2.1")
+
+
+IF_MIB_EXCERPT = """
+IF-MIB DEFINITIONS ::= BEGIN
+
+IMPORTS
+    MODULE-IDENTITY, OBJECT-TYPE, Counter32, Gauge32, Integer32, mib-2 FROM SNMPv2-SMI
+    DisplayString FROM SNMPv2-TC;
+
+ifMIB MODULE-IDENTITY
+    LAST-UPDATED "200006140000Z"
+    ORGANIZATION "IETF Interfaces MIB Working Group"
+    CONTACT-INFO "Keith McCloghrie"
+    DESCRIPTION  "The MIB module to describe generic objects for network interface sub-layers."
+    ::= { mib-2 31 }
+
+interfaces   OBJECT IDENTIFIER ::= { mib-2 2 }
+
+ifTable OBJECT-TYPE
+    SYNTAX      SEQUENCE OF IfEntry
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    DESCRIPTION "A list of interface entries."
+    ::= { interfaces 2 }
+
+ifEntry OBJECT-TYPE
+    SYNTAX      IfEntry
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    DESCRIPTION "An entry containing management information applicable to a particular interface."
+    INDEX   { ifIndex }
+    ::= { ifTable 1 }
+
+ifIndex OBJECT-TYPE
+    SYNTAX      InterfaceIndex
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "A unique value, greater than zero, for each interface."
+    ::= { ifEntry 1 }
+
+-- ifOperStatus OBJECT-TYPE ::= { ifEntry 99 } in a comment isn't a definition
+ifOperStatus OBJECT-TYPE
+    SYNTAX  INTEGER {
+                up(1),        -- ready to pass packets
+                down(2),
+                testing(3)
+            }
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION
+            "The current operational state of the interface.
+            The testing(3) state indicates that no operational
+            packets can be passed."
+    ::= { ifEntry 8 }
+
+END
+"""
+
+
+def test_load_mib_describes_objects():
+    """Test that loading IF-MIB gives ifOperStatus its SYNTAX, MAX-ACCESS and DESCRIPTION"""
+    mib_service = MIBService()
+
+    loaded = mib_service.load_mib(IF_MIB_EXCERPT)
+
+    assert loaded == {"IF-MIB": 6}
+    assert mib_service.get_oid_info("1.3.6.1.2.1.2.2.1.8") == {
+        "oid": "1.3.6.1.2.1.2.2.1.8",
+        "name": "ifOperStatus",
+        "mib": "IF-MIB",
+        "instance": None,
+        "index": None,
+        "syntax": "INTEGER { up(1), down(2), testing(3) }",
+        "access": "read-only",
+        "description": "The current operational state of the interface. The testing(3) state indicates "
+                       "that no operational packets can be passed.",
+        "related": [],
+    }
+    assert mib_service.resolve_oid("IF-MIB::ifEntry") == "1.3.6.1.2.1.2.2.1"
+    assert mib_service.get_oid_info("1.3.6.1.2.1.31")["name"] == "ifMIB"
+    # Built-in objects are named, but have nothing more to tell until their MIB is loaded
+    assert mib_service.get_oid_info("1.3.6.1.2.1.1.5.0")["description"] is None
+    assert mib_service.get_oid_info("1.3.6.1.4.1.9.9") is None
+
+
+def test_oid_info_links_objects_named_in_description():
+    """Test that objects a DESCRIPTION names are related, and words that name nothing are not"""
+    mib_service = MIBService()
+    mib_service.load_mib(IF_MIB_EXCERPT)
+    mib_service.load_mib("""
+    ACME-IF-MIB DEFINITIONS ::= BEGIN
+    IMPORTS
+        OBJECT-TYPE, Integer32, enterprises FROM SNMPv2-SMI;
+
+    acmeIfTemperature OBJECT-TYPE
+        SYNTAX      Integer32
+        MAX-ACCESS  read-only
+        STATUS      current
+        DESCRIPTION "Transceiver temperature of the interface ifIndex gives, see ifTable.
+                     Unlike acmeIfTemperature itself, acmeIfThreshold is an alarm limit;
+                     on an iPhone app, ifIndex is shown too."
+        ::= { enterprises 4242 1 }
+
+    acmeIfThreshold OBJECT-TYPE
+        SYNTAX      Integer32
+        MAX-ACCESS  read-write
+        STATUS      current
+        DESCRIPTION "Temperature limit"
+        ::= { enterprises 4242 2 }
+    END
+    """)
+
+    assert mib_service.get_oid_info("1.3.6.1.4.1.4242.1")["related"] == [
+        {"name": "ifIndex", "oid": "1.3.6.1.2.1.2.2.1.1", "mib": "IF-MIB"},
+        {"name": "ifTable", "oid": "1.3.6.1.2.1.2.2", "mib": "IF-MIB"},
+        {"name": "acmeIfThreshold", "oid": "1.3.6.1.4.1.4242.2", "mib": "ACME-IF-MIB"},
+    ]
+    assert mib_service.get_oid_info("1.3.6.1.4.1.4242.2")["related"] == []
+
+
+def test_oid_info_cross_references_optional():
+    """Test that with MIB_CROSS_REFERENCES off, descriptions aren't scanned for names"""
+    mib_service = MIBService()
+    with patch("app.services.mib_service.config.mib_cross_references", False):
+        mib_service.load_mib(IF_MIB_EXCERPT.replace("no operational", "no ifIndex"))
+
+    assert mib_service.get_oid_info("1.3.6.1.2.1.2.2.1.8")["related"] == []
+
+
+def test_load_mib_resolves_imports_from_loaded_modules():
+    """Test that objects under a node imported from a loaded MIB are placed, and under a missing one left out"""
+    mib_service = MIBService()
+    mib_service.load_mib(IF_MIB_EXCERPT)
+
+    loaded = mib_service.load_mib("""
+    ACME-IF-MIB DEFINITIONS ::= BEGIN
+    IMPORTS
+        OBJECT-TYPE, Integer32 FROM SNMPv2-SMI
+        ifEntry FROM IF-MIB
+        acmeProducts FROM ACME-SMI;
+
+    acmeIfTemperature OBJECT-TYPE
+        SYNTAX      Integer32 (-40..125)
+        UNITS       "degrees Celsius"
+        MAX-ACCESS  read-only
+        STATUS      current
+        DESCRIPTION "Transceiver temperature"
+        ::= { ifEntry 1000 }
+
+    acmeFans OBJECT IDENTIFIER ::= { acmeProducts 4 }
+    END
+    """)
+
+    assert loaded == {"ACME-IF-MIB": 1}
+    info = mib_service.get_oid_info("1.3.6.1.2.1.2.2.1.1000")
+    assert (info["name"], info["mib"], info["syntax"]) == ("acmeIfTemperature", "ACME-IF-MIB", "Integer32 (-40..125)")
+    assert mib_service.resolve_oid("ACME-IF-MIB::acmeFans") is None
+
+
+def test_load_mib_skips_object_identifier_sequence_members():
+    """Test that an OBJECT IDENTIFIER member of a SEQUENCE doesn't swallow the definition after it"""
+    mib_service = MIBService()
+
+    loaded = mib_service.load_mib("""
+    SNMPv2-MIB DEFINITIONS ::= BEGIN
+    IMPORTS
+        OBJECT-TYPE, Integer32, mib-2 FROM SNMPv2-SMI;
+
+    sysORTable OBJECT-TYPE
+        SYNTAX     SEQUENCE OF SysOREntry
+        MAX-ACCESS not-accessible
+        STATUS     current
+        ::= { system 9 }
+
+    sysOREntry OBJECT-TYPE
+        SYNTAX     SysOREntry
+        MAX-ACCESS not-accessible
+        STATUS     current
+        INDEX      { sysORIndex }
+        ::= { sysORTable 1 }
+
+    SysOREntry ::= SEQUENCE {
+        sysORIndex     Integer32,
+        sysORID        OBJECT IDENTIFIER,
+        sysORDescr     DisplayString
+    }
+
+    sysORIndex OBJECT-TYPE
+        SYNTAX     Integer32 (1..2147483647)
+        MAX-ACCESS not-accessible
+        STATUS     current
+        DESCRIPTION "The auxiliary variable used for identifying instances"
+        ::= { sysOREntry 1 }
+
+    sysORID OBJECT-TYPE
+        SYNTAX     OBJECT IDENTIFIER
+        MAX-ACCESS read-only
+        STATUS     current
+        ::= { sysOREntry 2 }
+    END
+    """)
+
+    assert loaded == {"SNMPv2-MIB": 4}
+    assert mib_service.resolve_oid("SNMPv2-MIB::sysORIndex") == "1.3.6.1.2.1.1.9.1.1"
+    info = mib_service.get_oid_info("1.3.6.1.2.1.1.9.1.2")
+    assert (info["name"], info["syntax"]) == ("sysORID", "OBJECT IDENTIFIER")
+
+
+def test_add_mib_file_loads_definitions(tmp_path, sample_mib_content):
+    """Test that an added MIB file is loaded into the index and kept in the MIB directory"""
+    source = tmp_path / "upload" / "SAMPLE-MIB.txt"
+    source.parent.mkdir()
+    source.write_text(sample_mib_content)
+    mib_service = _mib_dir_with(tmp_path / "mibs")
+
+    assert mib_service.add_mib_file(str(source)) is True
+
+    assert "SAMPLE-MIB" in mib_service.get_loaded_mibs()
+    assert mib_service.get_oid_info("1.3.6.1.4.1.9999.1")["description"] == "A sample OID"
+    assert (tmp_path / "mibs" / "SAMPLE-MIB.txt").exists()
+
+
+def test_mib_directory_loaded_at_startup(tmp_path, sample_mib_content):
+    """Test that a fresh service loads the MIB files in its directory, importing modules first"""
+    service = _mib_dir_with(tmp_path, **{
+        "ACME-IF-MIB.mib": """
+        ACME-IF-MIB DEFINITIONS ::= BEGIN
+        IMPORTS
+            OBJECT-TYPE, Integer32 FROM SNMPv2-SMI
+            ifMIB FROM IF-MIB;
+        acmeIfObjects OBJECT IDENTIFIER ::= { ifMIB 1000 }
+        END
+        """,
+        "IF-MIB-EXCERPT.mib": IF_MIB_EXCERPT,
+        "SAMPLE-MIB.txt": sample_mib_content,
+    })
+
+    assert {"IF-MIB", "ACME-IF-MIB", "SAMPLE-MIB"} <= set(service.get_loaded_mibs())
+    assert service.resolve_oid("SAMPLE-MIB::sampleOID") == "1.3.6.1.4.1.9999.1"
+    assert service.resolve_oid("ACME-IF-MIB::acmeIfObjects") == "1.3.6.1.2.1.31.1000"
+    assert {mib["name"]: mib["loaded"] for mib in service.list_mibs()}["SAMPLE-MIB"] is True
+
+
+def test_list_mibs_loaded_and_available(tmp_path, sample_mib_content):
+    """Test that MIB files are listed with their size, loaded or merely available, beside the built-in MIBs"""
+    upload = tmp_path / "SAMPLE-MIB.txt"
+    upload.write_text(sample_mib_content)
+    service = _mib_dir_with(tmp_path / "mibs")
+    assert [mib["name"] for mib in service.list_mibs()] == sorted(service.get_loaded_mibs())
+
+    (tmp_path / "mibs" / "IF-MIB-EXCERPT.mib").write_text(IF_MIB_EXCERPT)
+    (tmp_path / "mibs" / "notes.txt").write_text("not a MIB")
+    service.add_mib_file(str(upload))
+    mibs = {mib["name"]: mib for mib in service.list_mibs()}
+
+    assert mibs["SAMPLE-MIB"] == {"name": "SAMPLE-MIB", "file": "SAMPLE-MIB.txt", "size": len(sample_mib_content),
+                                  "loaded": True, "objects": 2}
+    assert mibs["IF-MIB"]["file"] == "IF-MIB-EXCERPT.mib"
+    assert mibs["IF-MIB"]["loaded"] is True and mibs["IF-MIB"]["objects"] > 0
+    assert mibs["notes"] == {"name": "notes", "file": "notes.txt", "size": 9, "loaded": False, "objects": None}
+
+
+ACME_PEER_MIB = """
+ACME-PEER-MIB DEFINITIONS ::= BEGIN
+IMPORTS
+    OBJECT-TYPE, Integer32, IpAddress, enterprises FROM SNMPv2-SMI
+    DisplayString FROM SNMPv2-TC
+    ifIndex FROM IF-MIB;
+
+acmePeerTable OBJECT IDENTIFIER ::= { enterprises 99999 1 }
+
+acmePeerEntry OBJECT-TYPE
+    SYNTAX      AcmePeerEntry
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    DESCRIPTION "A peer seen on an interface"
+    INDEX       { ifIndex, acmePeerAddress, acmePeerName }
+    ::= { acmePeerTable 1 }
+
+acmePeerAddress OBJECT-TYPE
+    SYNTAX      IpAddress
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    DESCRIPTION "Address of the peer"
+    ::= { acmePeerEntry 1 }
+
+acmePeerName OBJECT-TYPE
+    SYNTAX      DisplayString (SIZE (1..32))
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    DESCRIPTION "Name the peer announced"
+    ::= { acmePeerEntry 2 }
+
+acmePeerUptime OBJECT-TYPE
+    SYNTAX      Integer32
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "Seconds since the peer came up"
+    ::= { acmePeerEntry 3 }
+
+acmeKeyTable OBJECT IDENTIFIER ::= { enterprises 99999 2 }
+
+acmeKeyEntry OBJECT-TYPE
+    SYNTAX      AcmeKeyEntry
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    DESCRIPTION "A key, by its owner"
+    INDEX       { IMPLIED acmeKeyOwner }
+    ::= { acmeKeyTable 1 }
+
+acmeKeyOwner OBJECT-TYPE
+    SYNTAX      OCTET STRING (SIZE (1..32))
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    DESCRIPTION "Owner of the key"
+    ::= { acmeKeyEntry 1 }
+
+acmeKeyAge OBJECT-TYPE
+    SYNTAX      Integer32
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "Age of the key"
+    ::= { acmeKeyEntry 2 }
+END
+"""
+
+
+@pytest.fixture
+def peer_mibs():
+    """MIB service with IF-MIB and a vendor MIB of multi-index tables loaded"""
+    mib_service = MIBService()
+    mib_service.load_mib(IF_MIB_EXCERPT)
+    mib_service.load_mib(ACME_PEER_MIB)
+    return mib_service
+
+
+@pytest.mark.parametrize("oid,name,instance,index", [
+    # Scalar instance
+    ("1.3.6.1.2.1.1.5.0", "sysName", "0", None),
+    # Column of a table with one index, of a built-in and of a loaded object
+    ("1.3.6.1.2.1.2.2.1.2.5", "ifDescr", "5", {"ifIndex": 5}),
+    ("1.3.6.1.2.1.2.2.1.8.5", "ifOperStatus", "5", {"ifIndex": 5}),
+    # Integer, IpAddress and length-prefixed string indexes
+    ("1.3.6.1.4.1.99999.1.1.3.2.10.0.0.1.3.114.116.114", "acmePeerUptime", "2.10.0.0.1.3.114.116.114",
+     {"ifIndex": 2, "acmePeerAddress": "10.0.0.1", "acmePeerName": "rtr"}),
+    # IMPLIED string index, and one that isn't printable
+    ("1.3.6.1.4.1.99999.2.1.2.98.111.98", "acmeKeyAge", "98.111.98", {"acmeKeyOwner": "bob"}),
+    ("1.3.6.1.4.1.99999.2.1.2.0.255", "acmeKeyAge", "0.255", {"acmeKeyOwner": "00:ff"}),
+    # Instances that don't fit the INDEX
+    ("1.3.6.1.4.1.99999.1.1.3.2.10.0.0", "acmePeerUptime", "2.10.0.0", None),
+    ("1.3.6.1.4.1.99999.1.1.3.2.10.0.0.1.3.114", "acmePeerUptime", "2.10.0.0.1.3.114", None),
+])
+def test_get_oid_info_longest_prefix(peer_mibs, oid, name, instance, index):
+    """Test that instance OIDs resolve to their object, with the instance and the row's index values"""
+    info = peer_mibs.get_oid_info(oid)
+
+    assert (info["name"], info["instance"], info["index"]) == (name, instance, index)
+
+
+def test_table_structure_shares_index_of_augmented_row(peer_mibs):
+    """Test that a table lists the columns under its row, and a row that AUGMENTS another takes its INDEX"""
+    peer_mibs.load_mib("""
+    ACME-PEER-EXT-MIB DEFINITIONS ::= BEGIN
+    IMPORTS
+        OBJECT-TYPE, Counter32 FROM SNMPv2-SMI
+        acmePeerEntry FROM ACME-PEER-MIB;
+
+    acmePeerExtTable OBJECT IDENTIFIER ::= { enterprises 99999 3 }
+
+    acmePeerExtEntry OBJECT-TYPE
+        SYNTAX      AcmePeerExtEntry
+        MAX-ACCESS  not-accessible
+        STATUS      current
+        DESCRIPTION "More about a peer"
+        AUGMENTS    { acmePeerEntry }
+        ::= { acmePeerExtTable 1 }
+
+    acmePeerFlaps OBJECT-TYPE
+        SYNTAX      Counter32
+        MAX-ACCESS  read-only
+        STATUS      current
+        DESCRIPTION "Times the peer went down"
+        ::= { acmePeerExtEntry 1 }
+    END
+    """)
+
+    peers = peer_mibs.table_structure("acmePeerTable")
+    extension = peer_mibs.table_structure("ACME-PEER-EXT-MIB::acmePeerExtEntry")
+
+    assert peers.entry == "1.3.6.1.4.1.99999.1.1"
+    assert list(peers.columns.values()) == ["acmePeerAddress", "acmePeerName", "acmePeerUptime"]
+    assert extension.entry == "1.3.6.1.4.1.99999.3.1"
+    assert extension.columns == {"1.3.6.1.4.1.99999.3.1.1": "acmePeerFlaps"}
+    assert extension.index == peers.index
+    assert [name for name, _ in extension.index.objects] == ["ifIndex", "acmePeerAddress", "acmePeerName"]
+    # Columns sort by number, not as text
+    assert list(peer_mibs.table_structure("1.3.6.1.2.1.2.2").columns)[-2:] == [
+        "1.3.6.1.2.1.2.2.1.10", "1.3.6.1.2.1.2.2.1.16",
+    ]
+    assert peer_mibs.table_structure("noSuchTable") is None
+
+
+def test_translate_oid_names_most_specific_object(peer_mibs):
+    """Test that a table cell is named after its column, not the table or row above it"""
+    assert peer_mibs.translate_oid("1.3.6.1.4.1.99999.2.1.2.98.111.98") == "ACME-PEER-MIB::acmeKeyAge.98.111.98"
+    assert peer_mibs.translate_oid("1.3.6.1.4.1.99999.2.1") == "ACME-PEER-MIB::acmeKeyEntry"
+
+
+@pytest.mark.parametrize("name,oid", [
+    ("IF-MIB::ifInOctets", "1.3.6.1.2.1.2.2.1.10"),
+    ("ifInOctets", "1.3.6.1.2.1.2.2.1.10"),
+    ("ifInOctets.3", "1.3.6.1.2.1.2.2.1.10.3"),
+    ("sysContact.0", "1.3.6.1.2.1.1.4.0"),
+    ("IF-MIB::ifTable", "1.3.6.1.2.1.2.2"),
+])
+def test_resolve_name(name, oid):
+    """Test that names resolve with or without their module, and registration tree nodes by name"""
+    assert MIBService().resolve_name(name) == oid
+
+
+@pytest.mark.parametrize("name,mib", [
+    ("hrStorageUsed", "HOST-RESOURCES-MIB"),
+    ("entPhySensorValue", "ENTITY-SENSOR-MIB"),
+    ("ACME-MIB::acmeFanSpeed", "ACME-MIB"),
+    # IF-MIB is loaded, so loading it won't help
+    ("ifBogus", None),
+    ("ifconfig", None),
+])
+def test_resolve_name_suggests_mib(name, mib):
+    """Test that an unknown name is an error suggesting the MIB its prefix or module points to"""
+    with pytest.raises(UnresolvedNameError) as raised:
+        MIBService().resolve_name(name)
+
+    assert (raised.value.name, raised.value.mib) == (name, mib)
+    assert (f"load {mib}" in str(raised.value)) is bool(mib)
@@ -0,0 +1,76 @@
+import pytest
+
+from app.models.query import SNMPResponse, SNMPResult
+from app.utils.msgpack_codec import encode_msgpack, decode_msgpack, prefers_msgpack
+
+
+def test_round_trip_response():
+    """Test that a query response survives the MessagePack codec unchanged"""
+    response = SNMPResponse(
+        raw_data={
+            "IF-MIB::ifDescr.1": "GigabitEthernet0/1",
+            "IF-MIB::ifSpeed.1": 1000000000,
+            "IF-MIB::ifInOctets.1": 18446744073709551615,
+            "IF-MIB::ifPhysAddress.1": b"\x00\x1a\x2b\x3c\x4d\x5e",
+        },
+        results=[
+            SNMPResult(oid="1.3.6.1.2.1.2.2.1.2.1", name="IF-MIB::ifDescr.1",
+                       value="GigabitEthernet0/1", mib="IF-MIB"),
+            SNMPResult(oid="1.3.6.1.2.1.2.2.1.5.1", name="IF-MIB::ifSpeed.1", value=1000000000, mib="IF-MIB"),
+        ],
+        summary="Interface 1 " * 40,
+        query="get interface 1 details",
+        cached=True,
+    )
+    content = response.dict()
+
+    decoded = decode_msgpack(encode_msgpack(content))
+
+    assert decoded == content
+    assert SNMPResponse(**decoded) == response
+
+
+@pytest.mark.parametrize("value", [
+    None, True, False, 0, 127, 128, -1, -32, -33, -129, 65536, -2 ** 63, 2 ** 64 - 1, 1.5, -0.25,
+    "", "x" * 31, "x" * 32, "x" * 300, "x" * 70000, "ü", b"", b"\x00" * 300,
+    list(range(20)), {str(i): i for i in range(20)}, {"nested": [{"a": [1, None]}]},
+])
+def test_round_trip_values(value):
+    """Test round-tripping each MessagePack type and size class"""
+    assert decode_msgpack(encode_msgpack(value)) == value
+
+
+@pytest.mark.parametrize("value,encoded", [
+    ({"compact": True, "schema": 0}, b"\x82\xa7compact\xc3\xa6schema\x00"),
+    ([1, -1, None], b"\x93\x01\xff\xc0"),
+    (300, b"\xcd\x01\x2c"),
+    (-200, b"\xd1\xff\x38"),
+    (1.0, b"\xcb\x3f\xf0\x00\x00\x00\x00\x00\x00"),
+    (b"\x01\x02", b"\xc4\x02\x01\x02"),
+])
+def test_encoding_matches_spec(value, encoded):
+    """Test that encodings match the MessagePack specification, so standard clients can decode them"""
+    assert encode_msgpack(value) == encoded
+
+
+def test_decode_rejects_truncated_data():
+    """Test that truncated input raises instead of returning partial data"""
+    data = encode_msgpack({"oid": "1.3.6.1.2.1.1.1.0"})
+
+    with pytest.raises(ValueError):
+        decode_msgpack(data[:-3])
+
+
+@pytest.mark.parametrize("accept,expected", [
+    (None, False),
+    ("application/json", False),
+    ("*/*", False),
+    ("application/msgpack", True),
+    ("application/x-msgpack", True),
+    ("application/msgpack, application/json;q=0.5", True),
+    ("application/json, application/msgpack;q=0.5", False),
+    ("application/msgpack;q=0", False),
+])
+def test_prefers_msgpack(accept, expected):
+    """Test Accept negotiation keeps JSON as the default"""
+    assert prefers_msgpack(accept) is expected
@@ -1,9 +1,21 @@
+import asyncio
+import json
+import re
 import pytest
 import os
 from unittest.mock import patch, MagicMock
 
-from app.services.openai_service import OpenAIService
-from app.models.query import SNMPQuery, SNMPTarget, SNMPOperation, SNMPCredentials
+from openai import RateLimitError
+
+from app.services.openai_service import (
+    OpenAIService, ClarificationNeeded, LLMRateLimited, LLMResponseError, prompt_version,
+    LLM_CACHE_LOOKUPS, LLM_INTERPRETATION_DURATION
+)
+from app.core.config import config, APIKeyPolicy
+from app.models.query import SNMPQuery, SNMPTarget, SNMPOperation, SNMPCredentials, SNMPResult
+from app.services.session_service import SessionStore, context_prompt
+from app.services.snmp_service import SNMPService
+from app.services.mib_service import MIBService
 
 
 @pytest.mark.asyncio
@@ -83,3 +95,529 @@ async def test_format_response():
         assert result.raw_data == snmp_response
         assert result.query == query
         assert "Linux Ubuntu 20.04" in result.summary
+
+
+def _mock_provider(content):
+    """OpenAI service whose model always answers with the given JSON content"""
+    service = OpenAIService()
+    service.client = MagicMock()
+    service.client.chat.completions.create.return_value = MagicMock(
+        choices=[MagicMock(message=MagicMock(content=content))]
+    )
+    return service
+
+
+@pytest.mark.asyncio
+async def test_rate_limit_surfaced_after_retries():
+    """Test that a rate limit outlasting the retries is raised with the API's Retry-After, not treated as uninterpretable"""
+    service = OpenAIService()
+    service.client = MagicMock()
+    service.retry_base_delay = 0
+    service.client.chat.completions.create.side_effect = RateLimitError(
+        "Rate limit reached", response=MagicMock(status_code=429, headers={"retry-after": "7"}), body=None
+    )
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"), \
+            patch("app.services.openai_service.asyncio.sleep") as sleep:
+        with pytest.raises(LLMRateLimited) as raised:
+            await service.process_query("get sysName from 10.0.0.1")
+
+    assert raised.value.retry_after == 7
+    assert service.client.chat.completions.create.call_count == service.max_retries + 1
+    assert sleep.call_count == service.max_retries
+
+
+@pytest.mark.asyncio
+async def test_malformed_json_answer_is_described():
+    """Test that an answer that isn't JSON fails with the parse error rather than as an uninterpretable query"""
+    service = _mock_provider('{"target": {"host": "10.0.0.1"}, "operation": ')
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"):
+        with pytest.raises(LLMResponseError, match="malformed JSON"):
+            await service.process_query("get sysName from 10.0.0.1")
+
+
+@pytest.mark.asyncio
+async def test_process_query_needs_clarification():
+    """Test that an ambiguous query returns the model's clarification request"""
+    service = _mock_provider(
+        '{"needs_clarification": true, "missing": ["target.host"], '
+        '"questions": ["Which device should be queried?"], '
+        '"suggestions": ["get interface statistics for 10.0.0.1"]}'
+    )
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"):
+        with pytest.raises(ClarificationNeeded) as exc_info:
+            await service.process_query("get stats")
+
+    clarification = exc_info.value.clarification
+    assert clarification.missing == ["target.host"]
+    assert clarification.questions == ["Which device should be queried?"]
+    assert clarification.suggestions == ["get interface statistics for 10.0.0.1"]
+
+
+@pytest.mark.asyncio
+async def test_process_query_missing_host_needs_clarification():
+    """Test that a query interpreted without a device asks which device to use"""
+    service = _mock_provider('{"operation": "GET", "oid": "1.3.6.1.2.1.1.3.0"}')
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"):
+        with pytest.raises(ClarificationNeeded) as exc_info:
+            await service.process_query("get the uptime")
+
+    assert exc_info.value.clarification.missing == ["target.host"]
+
+
+@pytest.mark.asyncio
+async def test_process_query_clear_query():
+    """Test that a clear query is interpreted without asking for clarification"""
+    service = _mock_provider(
+        '{"target": {"host": "10.0.0.1"}, "operation": {"command": "WALK", "oids": ["1.3.6.1.2.1.2.2"]}}'
+    )
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"):
+        result = await service.process_query("walk the interface table of 10.0.0.1")
+
+    assert result.target.host == "10.0.0.1"
+    assert result.operation.command == "WALK"
+
+
+@pytest.mark.asyncio
+async def test_process_query_set_with_typed_values():
+    """Test that a write query is interpreted into a SET carrying typed values, resolved by the MIB"""
+    service = _mock_provider(
+        '{"target": {"host": "10.0.0.1"}, "operation": {"command": "SET", "oids": [], '
+        '"set_values": [{"oid": "IF-MIB::ifAdminStatus.3", "type": "Integer", "value": "down"}]}}'
+    )
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"):
+        result = await service.process_query("set ifAdminStatus of interface 3 on 10.0.0.1 to down")
+
+    assert result.operation.command == "SET"
+    set_value = result.operation.set_values[0]
+    assert (set_value.oid, set_value.type, set_value.value) == ("IF-MIB::ifAdminStatus.3", "Integer", "down")
+    assert MIBService().resolve_set_value("1.3.6.1.2.1.2.2.1.7.3", set_value.type, set_value.value) == ("Integer", 2)
+
+
+@pytest.mark.asyncio
+async def test_llm_io_logging_is_redacted():
+    """Test that logged prompts and completions are redacted and capped before they reach the log"""
+    service = _mock_provider(
+        '{"target": {"host": "10.0.0.1"}, "credentials": {"version": "2c", "community": "s3cret"}, '
+        '"operation": {"command": "GET", "oids": ["1.3.6.1.2.1.1.5.0"]}}'
+    )
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"), \
+            patch("app.services.openai_service.config.openai.log_io", True), \
+            patch("app.services.openai_service.config.openai.log_max_chars", 200), \
+            patch("app.services.openai_service.logger") as mock_logger:
+        result = await service.process_query("get sysName from 10.0.0.1 using community s3cret")
+
+    assert result.credentials.community == "s3cret"
+
+    logged = [call.args[0] for call in mock_logger.debug.call_args_list if call.args[0].startswith("LLM ")]
+    assert [message.split(":")[0] for message in logged] == ["LLM system prompt", "LLM user prompt", "LLM completion"]
+    assert all("s3cret" not in message for message in logged)
+    assert 'community ****' in logged[1]
+    assert '"community": "****"' in logged[2]
+    assert "more characters]" in logged[0]
+
+
+@pytest.mark.asyncio
+async def test_llm_io_logging_off_by_default():
+    """Test that prompts and completions aren't logged unless enabled"""
+    service = _mock_provider('{"target": {"host": "10.0.0.1"}, "operation": {"command": "GET", "oids": ["1.3.6.1.2.1.1.5.0"]}}')
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"), \
+            patch("app.services.openai_service.logger") as mock_logger:
+        await service.process_query("get sysName from 10.0.0.1 using community s3cret")
+
+    assert all("s3cret" not in str(call) for call in mock_logger.debug.call_args_list)
+
+
+def test_cache_key_changes_with_model_and_prompt():
+    """Test that responses are cached per model and prompt version as well as query"""
+    service = OpenAIService()
+    key = service.cache_key("get sysName from 10.0.0.1")
+
+    assert service.cache_key("get sysName from 10.0.0.1") == key
+    assert service.cache_key("get sysName from 10.0.0.2") != key
+    assert key.startswith(f"query_{service.model}_{prompt_version(service.system_prompt)}_")
+    assert service.cache_key("get sysName from 10.0.0.1", include_device=True) != key
+    assert service.cache_key("get sysName from 10.0.0.1", label_interfaces=True) not in (
+        key, service.cache_key("get sysName from 10.0.0.1", include_device=True)
+    )
+
+    service.model = "gpt-4o"
+    assert service.cache_key("get sysName from 10.0.0.1") != key
+
+    service.model = config.openai.model
+    service.system_prompt += "\nAnswer with numeric OIDs only."
+    assert service.cache_key("get sysName from 10.0.0.1") != key
+
+
+@pytest.mark.asyncio
+async def test_batched_interpretations_route_to_each_caller():
+    """Test that concurrent interpretations share one LLM call and each caller gets its own query"""
+    def interpret_numbered(**kwargs):
+        prompt = kwargs["messages"][1]["content"]
+        hosts = re.findall(r"^\d+\. 'get sysName from ([\d.]+)'$", prompt, re.MULTILINE)
+        results = [{"target": {"host": host}, "operation": {"command": "GET", "oids": ["1.3.6.1.2.1.1.5.0"]}}
+                   for host in hosts]
+        return MagicMock(choices=[MagicMock(message=MagicMock(content=json.dumps({"results": results})))])
+
+    with patch("app.services.openai_service.config.openai.batch_interpretations", True):
+        service = OpenAIService()
+    service.client = MagicMock()
+    service.client.chat.completions.create.side_effect = interpret_numbered
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"):
+        queries = await asyncio.gather(*(
+            service.process_query(f"get sysName from 10.0.0.{number}") for number in range(1, 4)
+        ))
+
+    assert [query.target.host for query in queries] == ["10.0.0.1", "10.0.0.2", "10.0.0.3"]
+    assert service.client.chat.completions.create.call_count == 1
+    assert service.client.chat.completions.create.call_args.kwargs["max_tokens"] == 3 * service.max_tokens
+
+
+
+@pytest.mark.asyncio
+async def test_interpretations_only_batched_per_api_key():
+    """Test that concurrent queries of different API keys never share an LLM call"""
+    def interpret_numbered(**kwargs):
+        hosts = re.findall(r"^\d+\. 'get sysName from ([\d.]+)'$", kwargs["messages"][1]["content"], re.MULTILINE)
+        results = [{"target": {"host": host}, "operation": {"command": "GET", "oids": ["1.3.6.1.2.1.1.5.0"]}}
+                   for host in hosts]
+        return MagicMock(choices=[MagicMock(message=MagicMock(content=json.dumps({"results": results})))])
+
+    with patch("app.services.openai_service.config.openai.batch_interpretations", True):
+        service = OpenAIService()
+    service.client = MagicMock()
+    service.client.chat.completions.create.side_effect = interpret_numbered
+    netops, other = APIKeyPolicy(name="netops", digest="d1"), APIKeyPolicy(name="netops", digest="d2")
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"):
+        queries = await asyncio.gather(*(
+            service.process_query(f"get sysName from 10.0.0.{number}", api_key=(netops, other)[number % 2])
+            for number in range(1, 5)
+        ))
+
+    assert [query.target.host for query in queries] == ["10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"]
+    batches = [call.kwargs["messages"][1]["content"] for call in service.client.chat.completions.create.call_args_list]
+    assert len(batches) == 2
+    assert any("10.0.0.1" in batch and "10.0.0.3" in batch and "10.0.0.2" not in batch for batch in batches)
+
+@pytest.mark.asyncio
+async def test_batched_interpretation_mismatch_fails_each_query():
+    """Test that an answer that can't be matched to the batched queries interprets none of them"""
+    with patch("app.services.openai_service.config.openai.batch_interpretations", True):
+        service = _mock_provider('{"results": [{"target": {"host": "10.0.0.1"}, "operation": {"command": "GET", "oids": ["1.3.6.1.2.1.1.5.0"]}}]}')
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"):
+        queries = await asyncio.gather(service.process_query("get sysName from 10.0.0.1"),
+                                       service.process_query("get sysName from 10.0.0.2"))
+
+    assert queries == [None, None]
+
+
+def _provider_answering(*contents):
+    """OpenAI service whose model gives the given JSON answers, one per call"""
+    service = OpenAIService()
+    service.client = MagicMock()
+    service.client.chat.completions.create.side_effect = [
+        MagicMock(choices=[MagicMock(message=MagicMock(content=content))]) for content in contents
+    ]
+    return service
+
+
+BAD_INTERPRETATION = ('{"target": {"host": "10.0.0.1"}, "operation": '
+                      '{"command": "GET", "oids": ["1.3.6.1.2.1.2.2.1.10"], "index_from": 1, "index_to": 4}}')
+GOOD_INTERPRETATION = ('{"target": {"host": "10.0.0.1"}, "operation": '
+                       '{"command": "WALK", "oids": ["1.3.6.1.2.1.2.2.1.10"], "index_from": 1, "index_to": 4}}')
+
+
+@pytest.mark.asyncio
+async def test_invalid_interpretation_corrected_once():
+    """Test that an invalid interpretation is sent back with its validation error and the correction used"""
+    service = _provider_answering(BAD_INTERPRETATION, GOOD_INTERPRETATION)
+    validate = SNMPService(mib_service=MIBService()).validate_query
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"), \
+            patch("app.services.openai_service.config.openai.correct_interpretations", True):
+        snmp_query, correction = await service.interpret("ifInOctets for interfaces 1 to 4 on 10.0.0.1", validate)
+
+    assert snmp_query.operation.command == "WALK"
+    assert correction["validation_error"] == "Index ranges are only supported for WALK, not GET"
+    assert correction["corrected"] is True
+    assert correction["plan"]["operation"]["command"] == "WALK"
+
+    messages = service.client.chat.completions.create.call_args.kwargs["messages"]
+    assert [message["role"] for message in messages] == ["system", "user", "assistant", "user"]
+    assert '"command":"GET"' in messages[2]["content"]
+    assert "Index ranges are only supported for WALK, not GET" in messages[3]["content"]
+
+
+@pytest.mark.asyncio
+async def test_interpretation_correction_bounded_and_optional():
+    """Test that a correction that is still invalid isn't retried, and nothing is re-prompted when disabled"""
+    validate = SNMPService(mib_service=MIBService()).validate_query
+
+    service = _provider_answering(BAD_INTERPRETATION, BAD_INTERPRETATION, GOOD_INTERPRETATION)
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"), \
+            patch("app.services.openai_service.config.openai.correct_interpretations", True):
+        snmp_query, correction = await service.interpret("ifInOctets for interfaces 1 to 4 on 10.0.0.1", validate)
+
+    assert snmp_query.operation.command == "GET"
+    assert correction["corrected"] is False
+    assert correction["remaining_error"] == "Index ranges are only supported for WALK, not GET"
+    assert service.client.chat.completions.create.call_count == 2
+
+    service = _provider_answering(BAD_INTERPRETATION, GOOD_INTERPRETATION)
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"):
+        snmp_query, correction = await service.interpret("ifInOctets for interfaces 1 to 4 on 10.0.0.1", validate)
+
+    assert snmp_query.operation.command == "GET" and correction is None
+    assert service.client.chat.completions.create.call_count == 1
+
+
+@pytest.mark.asyncio
+async def test_set_interpretation_never_corrected():
+    """Test that a SET the validator rejects isn't sent back to the model, which could pick another target"""
+    set_interpretation = ('{"target": {"host": "10.0.0.1"}, "operation": {"command": "SET", "set_values": '
+                          '[{"oid": "1.3.6.1.4.1.99999999.1", "value": 1}]}}')
+    service = _provider_answering(set_interpretation, GOOD_INTERPRETATION)
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"), \
+            patch("app.services.openai_service.config.openai.correct_interpretations", True):
+        snmp_query, correction = await service.interpret("set it to 1 on 10.0.0.1", lambda query: "Unknown OID")
+
+    assert snmp_query.operation.command == "SET" and correction is None
+    assert service.client.chat.completions.create.call_count == 1
+
+
+@pytest.mark.asyncio
+async def test_interpretation_cache_skips_llm_and_survives_restart(tmp_path):
+    """Test that a cached interpretation skips the LLM, and is reloaded until the model changes"""
+    path = str(tmp_path / "interpretations.json")
+    with patch("app.services.openai_service.config.openai.interpretation_cache_size", 10), \
+            patch("app.services.openai_service.config.openai.interpretation_cache_file", path):
+        service = _mock_provider('{"target": {"host": "10.0.0.1"}, "operation": {"command": "GET", "oids": ["1.3.6.1.2.1.1.5.0"]}}')
+        restarted = OpenAIService()
+        with patch("app.services.openai_service.config.openai.model", "gpt-4o"):
+            other_model = OpenAIService()
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"):
+        await service.process_query("get sysName from 10.0.0.1")
+        result = await service.process_query("get sysName from 10.0.0.1")
+    service.interpretation_cache.save()
+
+    assert result.target.host == "10.0.0.1"
+    assert service.client.chat.completions.create.call_count == 1
+    assert restarted.warm_up_interpretations() == (1, 0)
+    assert other_model.warm_up_interpretations() == (0, 1)
+
+
+@pytest.mark.asyncio
+async def test_interpretation_metrics():
+    """Test that LLM calls are timed by outcome, and cache lookups counted as hits and misses"""
+    provider = config.openai.provider
+    hits, misses = (LLM_CACHE_LOOKUPS.value(cache="interpretation", result=result) for result in ("hit", "miss"))
+    succeeded, malformed = (LLM_INTERPRETATION_DURATION.count(provider=provider, outcome=outcome)
+                            for outcome in ("success", "bad-response"))
+
+    with patch("app.services.openai_service.config.openai.interpretation_cache_size", 10):
+        service = _mock_provider('{"target": {"host": "10.0.0.1"}, "operation": {"command": "GET", "oids": ["1.3.6.1.2.1.1.5.0"]}}')
+        failing = _mock_provider('{"target": ')
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"):
+        await service.process_query("get sysName from 10.0.0.1")
+        await service.process_query("get sysName from 10.0.0.1")
+        with pytest.raises(LLMResponseError):
+            await failing.process_query("get sysName from 10.0.0.2")
+
+    assert LLM_CACHE_LOOKUPS.value(cache="interpretation", result="hit") == hits + 1
+    assert LLM_CACHE_LOOKUPS.value(cache="interpretation", result="miss") == misses + 2
+    # The cached interpretation made no LLM call to time
+    assert LLM_INTERPRETATION_DURATION.count(provider=provider, outcome="success") == succeeded + 1
+    assert LLM_INTERPRETATION_DURATION.count(provider=provider, outcome="bad-response") == malformed + 1
+
+
+def _positional_provider():
+    """
+    OpenAI service whose model resolves "the first/second/third/last one" against the numbered
+    results in the conversation context of the prompt, answering with a GET of that OID
+    """
+    ordinals = {"first": 0, "second": 1, "third": 2, "last": -1}
+
+    def create(**kwargs):
+        prompt = kwargs["messages"][-1]["content"]
+        oids = re.findall(r"^\d+\. \S+ = (\S+)$", prompt, re.MULTILINE)
+        host = re.search(r"'that device' to (\S+)\.", prompt).group(1)
+        ordinal = re.search(r"the (first|second|third|last) one", prompt.rsplit("Convert", 1)[1]).group(1)
+        content = json.dumps({"target": {"host": host}, "operation": {"command": "GET", "oids": [oids[ordinals[ordinal]]]}})
+        return MagicMock(choices=[MagicMock(message=MagicMock(content=content))])
+
+    service = OpenAIService()
+    service.client = MagicMock()
+    service.client.chat.completions.create.side_effect = create
+    return service
+
+
+def _session_context(host, results):
+    store = SessionStore(ttl=60, max_sessions=10, max_results=50)
+    query = SNMPQuery(target=SNMPTarget(host=host), operation=SNMPOperation(command="WALK", oids=["1.3.6.1.2.1.2.2.1.2"]),
+                      raw_query=f"walk ifDescr on {host}")
+    store.record("s1", query, [SNMPResult(name=name, oid=oid) for name, oid in results], "Interface descriptions")
+    return context_prompt(store.get("s1"))
+
+
+@pytest.mark.asyncio
+async def test_follow_up_resolves_position_from_session_context():
+    """Test that a follow-up referring to a prior result by position is resolved to that result's OID and host"""
+    first = _session_context("10.0.0.1", [(f"IF-MIB::ifDescr.{index}", f"1.3.6.1.2.1.2.2.1.2.{index}") for index in (1, 2, 3, 4)])
+    second = _session_context("10.0.0.2", [(f"IF-MIB::ifDescr.{index}", f"1.3.6.1.2.1.2.2.1.2.{index}") for index in (10, 20, 30)])
+
+    with patch("app.services.openai_service.config.openai.interpretation_cache_size", 10):
+        service = _positional_provider()
+
+    with patch("app.services.openai_service.config.interpreter_mode", "hybrid"):
+        third = await service.process_query("get the value for the third one", context=first)
+        # The same words mean another OID in another conversation, so they aren't served from the cache
+        other = await service.process_query("get the value for the third one", context=second)
+        last = await service.process_query("now get the last one", context=first)
+
+    assert (third.target.host, third.operation.oids) == ("10.0.0.1", ["1.3.6.1.2.1.2.2.1.2.3"])
+    assert (other.target.host, other.operation.oids) == ("10.0.0.2", ["1.3.6.1.2.1.2.2.1.2.30"])
+    assert last.operation.oids == ["1.3.6.1.2.1.2.2.1.2.4"]
+    assert service.client.chat.completions.create.call_count == 3
+    assert len(service.interpretation_cache) == 0
+
+    prompt = service.client.chat.completions.create.call_args.kwargs["messages"][-1]["content"]
+    assert "3. IF-MIB::ifDescr.3 = 1.3.6.1.2.1.2.2.1.2.3" in prompt
+    assert "walk ifDescr on 10.0.0.1" in prompt and "Interface descriptions" in prompt
+
+
+class _TokenStream:
+    """Streamed completion of the mock provider, one chunk per token, recording how far it was read"""
+
+    def __init__(self, tokens):
+        self.tokens = list(tokens)
+        self.consumed = 0
+        self.closed = False
+
+    def __iter__(self):
+        return self
+
+    def __next__(self):
+        if self.consumed >= len(self.tokens):
+            raise StopIteration
+        self.consumed += 1
+        return MagicMock(choices=[MagicMock(delta=MagicMock(content=self.tokens[self.consumed - 1]))])
+
+    def close(self):
+        self.closed = True
+
+
+def _streaming_provider(*tokens):
+    """OpenAI service whose model streams the given tokens"""
+    service = OpenAIService()
+    service.client = MagicMock()
+    service.client.chat.completions.create.return_value = _TokenStream(tokens)
+    return service
+
+
+STREAMED_INTERPRETATION = ['{"target": {"host": ', '"10.0.0.1", "port": 161}', ', "operation": {"command": "WALK", ',
+                           '"oids": ["1.3.6.1.2.1.2.2.1.10",', ' "1.3.6.1.2.1.2.2.1.16"]', '}}']
+
+
+@pytest.mark.asyncio
+async def test_streamed_interpretation_starts_on_target_early():
+    """Test that a streamed interpretation is parsed whole, with on_target started once the target is known"""
+    service = _streaming_provider(*STREAMED_INTERPRETATION)
+    stream = service.client.chat.completions.create.return_value
+    seen = []
+
+    async def on_target(target):
+        seen.append((target.host, stream.consumed))
+    service.on_target = on_target
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"), \
+            patch("app.services.openai_service.config.openai.stream_interpretations", True):
+        result = await service.process_query("walk in and out octets on 10.0.0.1")
+
+    assert result.operation.oids == ["1.3.6.1.2.1.2.2.1.10", "1.3.6.1.2.1.2.2.1.16"]
+    assert service.client.chat.completions.create.call_args.kwargs["stream"] is True
+    assert seen and seen[0][0] == "10.0.0.1" and seen[0][1] < len(STREAMED_INTERPRETATION)
+    assert stream.closed
+
+
+@pytest.mark.asyncio
+@pytest.mark.parametrize("tokens,read", [
+    # Prose before the JSON is rejected at the first token
+    (["Sure! ", '{"target": {"host": "10.0.0.1"}}'], 1),
+    # An unknown command is rejected as soon as the operation is complete, before the rest streams in
+    (['{"operation": {"command": "TRAP", "oids": []}', ', "target": {"host": "10.0.0.1"}', "}"], 1),
+    (['{"target": {"host": "10.0.0.1", "port": 99999}', ', "operation": {"command": "GET"', "}}"], 1),
+])
+async def test_streamed_interpretation_rejected_early(tokens, read):
+    """Test that an invalid interpretation stops the stream at the first invalid member"""
+    service = _streaming_provider(*tokens)
+    stream = service.client.chat.completions.create.return_value
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"), \
+            patch("app.services.openai_service.config.openai.stream_interpretations", True):
+        assert await service.process_query("get something from 10.0.0.1") is None
+
+    assert stream.consumed == read
+    assert stream.closed
+
+
+@pytest.mark.asyncio
+async def test_interpretation_deltas_passed_on():
+    """Test that each piece of the model's answer is passed on, streamed even without LLM_STREAM_INTERPRETATIONS"""
+    service = _streaming_provider(*STREAMED_INTERPRETATION)
+    deltas = []
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"), \
+            patch("app.services.openai_service.config.openai.stream_interpretations", False):
+        result, _ = await service.interpret("walk in and out octets on 10.0.0.1", on_delta=deltas.append)
+
+    assert deltas == STREAMED_INTERPRETATION
+    assert result.target.host == "10.0.0.1"
+    assert service.client.chat.completions.create.call_args.kwargs["stream"] is True
+
+
+@pytest.mark.asyncio
+async def test_cancelled_streamed_interpretation_not_cached():
+    """Test that an interpretation cancelled while it streams is not cached, and its stream is closed"""
+    with patch("app.services.openai_service.config.openai.interpretation_cache_size", 10):
+        service = _streaming_provider(*STREAMED_INTERPRETATION)
+    stream = service.client.chat.completions.create.return_value
+    task = None
+
+    def on_delta(delta):
+        if stream.consumed == 2:
+            task.cancel()
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"):
+        task = asyncio.ensure_future(service.process_query("walk in and out octets on 10.0.0.1", on_delta=on_delta))
+        with pytest.raises(asyncio.CancelledError):
+            await task
+
+    assert stream.closed
+    assert stream.consumed < len(STREAMED_INTERPRETATION)
+    assert len(service.interpretation_cache) == 0
+
+
+@pytest.mark.asyncio
+async def test_incomplete_streamed_interpretation_not_used_or_cached():
+    """Test that a stream ending before the JSON object is complete is never executed or cached"""
+    with patch("app.services.openai_service.config.openai.interpretation_cache_size", 10):
+        service = _streaming_provider(*STREAMED_INTERPRETATION[:-1])
+
+    with patch("app.services.openai_service.config.interpreter_mode", "llm"), \
+            patch("app.services.openai_service.config.openai.stream_interpretations", True):
+        assert await service.process_query("walk in and out octets on 10.0.0.1") is None
+
+    assert len(service.interpretation_cache) == 0
@@ -0,0 +1,50 @@
+from app.utils.openmetrics import to_openmetrics
+
+
+def test_if_table_walk_exposition():
+    """Test the exposition of a sample ifTable walk"""
+    if_table = {
+        "IF-MIB::ifIndex.1": 1,
+        "IF-MIB::ifIndex.2": 2,
+        "IF-MIB::ifDescr.1": "lo",
+        "IF-MIB::ifDescr.2": 'eth0 "uplink"',
+        "IF-MIB::ifSpeed.1": 10000000,
+        "IF-MIB::ifSpeed.2": 1000000000,
+        "IF-MIB::ifInOctets.1": 1234,
+        "IF-MIB::ifInOctets.2": 98765432100,
+    }
+
+    exposition = to_openmetrics([("10.0.0.1", if_table)])
+
+    assert exposition == (
+        "# TYPE ifIndex unknown\n"
+        'ifIndex{index="1",mib="IF-MIB",target="10.0.0.1"} 1\n'
+        'ifIndex{index="2",mib="IF-MIB",target="10.0.0.1"} 2\n'
+        "# TYPE ifDescr info\n"
+        'ifDescr_info{index="1",mib="IF-MIB",target="10.0.0.1",value="lo"} 1\n'
+        'ifDescr_info{index="2",mib="IF-MIB",target="10.0.0.1",value="eth0 \\"uplink\\""} 1\n'
+        "# TYPE ifSpeed unknown\n"
+        'ifSpeed{index="1",mib="IF-MIB",target="10.0.0.1"} 10000000\n'
+        'ifSpeed{index="2",mib="IF-MIB",target="10.0.0.1"} 1000000000\n'
+        "# TYPE ifInOctets unknown\n"
+        'ifInOctets{index="1",mib="IF-MIB",target="10.0.0.1"} 1234\n'
+        'ifInOctets{index="2",mib="IF-MIB",target="10.0.0.1"} 98765432100\n'
+        "# EOF\n"
+    )
+
+
+def test_families_grouped_across_targets():
+    """Test that samples from several targets stay grouped by family"""
+    exposition = to_openmetrics([
+        ("10.0.0.1", {"SNMPv2-MIB::sysUpTime.0": 100, "1.3.6.1.4.1.9.9.1.0": 2.5}),
+        ("10.0.0.2", {"SNMPv2-MIB::sysUpTime.0": 200, "1.3.6.1.2.1.1.1.0_error": "Error: timeout"}),
+    ])
+
+    assert exposition.splitlines() == [
+        "# TYPE sysUpTime unknown",
+        'sysUpTime{index="0",mib="SNMPv2-MIB",target="10.0.0.1"} 100',
+        'sysUpTime{index="0",mib="SNMPv2-MIB",target="10.0.0.2"} 200',
+        "# TYPE snmp_oid_value unknown",
+        'snmp_oid_value{oid="1.3.6.1.4.1.9.9.1.0",target="10.0.0.1"} 2.5',
+        "# EOF",
+    ]
@@ -0,0 +1,128 @@
+import asyncio
+import pytest
+from unittest.mock import patch
+
+from app.core.config import APIKeyPolicy
+from app.services.operation_service import (
+    OperationRegistry, OperationCancelled, DuplicateOperationError, cancel_on_disconnect
+)
+from app.services.snmp_service import SNMPService
+from app.services.mib_service import MIBService
+from app.models.query import SNMPQuery, SNMPTarget, SNMPOperation
+
+
+def _walk_query():
+    return SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="WALK", oids=["1.3.6.1.2.1.2.2"], deadline=60)
+    )
+
+
+@pytest.mark.asyncio
+async def test_cancel_long_walk():
+    """Test that cancelling a walk by ID stops it promptly and no more rows are fetched"""
+    fetched = []
+
+    async def slow_walk(oid):
+        for index in range(1, 1000):
+            await asyncio.sleep(0.05)
+            fetched.append(index)
+            yield f"1.3.6.1.2.1.2.2.1.1.{index}", index
+
+    registry = OperationRegistry()
+    service = SNMPService(mib_service=MIBService())
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.walk = slow_walk
+        operation = registry.start("query", "walk the interface table", operation_id="op-1")
+        task = asyncio.ensure_future(operation.run(service.execute_query(_walk_query())))
+
+        await asyncio.sleep(0.3)
+        assert [op["id"] for op in registry.list()] == ["op-1"]
+        assert registry.cancel("op-1")
+
+        with pytest.raises(OperationCancelled):
+            await asyncio.wait_for(task, timeout=1)
+
+        rows = len(fetched)
+        await asyncio.sleep(0.2)
+        assert len(fetched) == rows
+
+    registry.finish(operation)
+    assert registry.list() == []
+    assert not registry.cancel("op-1")
+
+
+
+def test_operations_only_visible_to_their_key():
+    """Test that an operation is only listed for, and cancelled by, the API key that started it"""
+    registry = OperationRegistry()
+    netops, other = APIKeyPolicy(name="netops"), APIKeyPolicy(name="other")
+    operation = registry.start("query", "walk the interface table", operation_id="op-1", api_key=netops)
+
+    assert [op["id"] for op in registry.list(netops)] == ["op-1"]
+    assert registry.list(other) == [] and registry.list() == []
+    assert not registry.cancel("op-1", other) and not registry.cancel("op-1")
+    assert not operation.cancel_requested
+
+    assert registry.cancel("op-1", netops)
+    assert operation.cancel_requested
+
+@pytest.mark.asyncio
+async def test_client_disconnect_stops_walk():
+    """Test that a walk stops fetching rows once its client disconnects"""
+    fetched = []
+    disconnected = False
+
+    async def slow_walk(oid):
+        for index in range(1, 1000):
+            await asyncio.sleep(0.05)
+            fetched.append(index)
+            yield f"1.3.6.1.2.1.2.2.1.1.{index}", index
+
+    async def is_disconnected():
+        return disconnected
+
+    registry = OperationRegistry()
+    service = SNMPService(mib_service=MIBService())
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.walk = slow_walk
+        operation = registry.start("query", "walk the interface table")
+        watcher = asyncio.ensure_future(cancel_on_disconnect(operation, is_disconnected, interval=0.05))
+        task = asyncio.ensure_future(operation.run(service.execute_query(_walk_query())))
+
+        await asyncio.sleep(0.3)
+        assert not operation.cancel_requested
+        disconnected = True
+
+        with pytest.raises(OperationCancelled):
+            await asyncio.wait_for(task, timeout=1)
+        await asyncio.wait_for(watcher, timeout=1)
+
+        rows = len(fetched)
+        await asyncio.sleep(0.2)
+        assert len(fetched) == rows
+
+
+@pytest.mark.asyncio
+async def test_cancelled_operation_refuses_new_steps():
+    """Test that an operation cancelled between steps doesn't run the next one"""
+    registry = OperationRegistry()
+    operation = registry.start("subscription", "poll")
+    registry.cancel(operation.id)
+
+    with pytest.raises(OperationCancelled):
+        await operation.run(asyncio.sleep(0))
+
+
+def test_duplicate_operation_id():
+    """Test that a client-chosen operation ID can't be reused while it is running"""
+    registry = OperationRegistry()
+    operation = registry.start("query", "first", operation_id="op-1")
+
+    with pytest.raises(DuplicateOperationError):
+        registry.start("query", "second", operation_id="op-1")
+
+    registry.finish(operation)
+    assert registry.start("query", "third", operation_id="op-1").description == "third"
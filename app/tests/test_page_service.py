@@ -0,0 +1,112 @@
+import pytest
+
+from app.core.config import APIKeyPolicy
+from app.services.page_service import PageStore, paginate, parse_cursor
+
+
+def _content(count=5):
+    results = [
+        {"oid": f"1.3.6.1.2.1.2.2.1.2.{i}", "name": f"IF-MIB::ifDescr.{i}", "value": f"eth{i}"}
+        for i in range(1, count + 1)
+    ]
+    return {
+        "results": results,
+        "raw_data": {result["oid"]: result["value"] for result in results},
+        "groups": {"1.3.6.1.2.1.2.2.1.2": [result["name"] for result in results]},
+        "rows": [{"table": "1.3.6.1.2.1.2.2", "entry": "1.3.6.1.2.1.2.2.1", "instance": str(i), "index": {},
+                  "columns": {}} for i in range(1, count + 1)],
+        "summary": "5 interfaces",
+    }
+
+
+def test_paginate_cuts_results_and_their_data():
+    """Test that a page holds only its results, with their raw data, groups and rows"""
+    page = paginate(_content(), 2, 2, "abc")
+
+    assert [result["value"] for result in page["results"]] == ["eth3", "eth4"]
+    assert list(page["raw_data"]) == ["1.3.6.1.2.1.2.2.1.2.3", "1.3.6.1.2.1.2.2.1.2.4"]
+    assert page["groups"] == {"1.3.6.1.2.1.2.2.1.2": ["IF-MIB::ifDescr.3", "IF-MIB::ifDescr.4"]}
+    assert [row["instance"] for row in page["rows"]] == ["3", "4"]
+    assert page["summary"] == "5 interfaces"
+    assert page["page"] == {"total": 5, "offset": 2, "limit": 2, "next_cursor": "abc:4"}
+
+
+def test_paginate_matches_rows_by_entry_and_instance():
+    """Test that a row is only on a page with a cell of its own table, not one whose OID ends like its instance"""
+    content = {
+        "results": [
+            {"oid": "1.3.6.1.2.1.2.2.1.2.3", "value": "eth3"},
+            {"oid": "1.3.6.1.2.1.4.22.1.2.2.10.0.0.3", "value": "00:11:22:33:44:55"},
+        ],
+        "rows": [
+            {"table": "1.3.6.1.2.1.2.2", "entry": "1.3.6.1.2.1.2.2.1", "instance": "3", "columns": {}},
+            {"table": "1.3.6.1.2.1.4.22", "entry": "1.3.6.1.2.1.4.22.1", "instance": "2.10.0.0.3", "columns": {}},
+        ],
+    }
+
+    assert [row["instance"] for row in paginate(content, 0, 1, "abc")["rows"]] == ["3"]
+    assert [row["instance"] for row in paginate(content, 1, 1, "abc")["rows"]] == ["2.10.0.0.3"]
+
+
+def test_last_page_has_no_next_cursor():
+    """Test that the last page, and a page past the end, have no next cursor"""
+    content = _content()
+
+    assert paginate(content, 4, 2, "abc")["page"]["next_cursor"] is None
+    assert paginate(content, 3, 2, "abc")["page"]["next_cursor"] is None
+    past_end = paginate(content, 10, 2, "abc")
+    assert past_end["results"] == [] and past_end["page"]["next_cursor"] is None
+    assert len(content["results"]) == 5
+
+
+@pytest.mark.parametrize("cursor,expected", [
+    ("abc:4", ("abc", 4)),
+    ("a:b-c:10", ("a:b-c", 10)),
+    ("abc", None),
+    (":4", None),
+    ("abc:-1", None),
+])
+def test_parse_cursor(cursor, expected):
+    """Test that cursors are split into their token and offset, and others rejected"""
+    if expected is None:
+        with pytest.raises(ValueError):
+            parse_cursor(cursor)
+    else:
+        assert parse_cursor(cursor) == expected
+
+
+def test_results_expire_and_belong_to_their_key():
+    """Test that stored results expire, only serve the key that fetched them and are bounded in number"""
+    store = PageStore(ttl=60, max_sets=2)
+    netops = APIKeyPolicy(name="netops", digest="n1")
+    token = store.store(_content(), "walk ifDescr", 2, netops, now=100)
+
+    stored = store.get(token, netops, now=150)
+    assert stored.query == "walk ifDescr" and stored.limit == 2
+    assert store.get(token, APIKeyPolicy(name="other", digest="o1"), now=150) is None
+    # Another key of the same name
+    assert store.get(token, APIKeyPolicy(name="netops", digest="n2"), now=150) is None
+    assert store.get(token, None, now=150) is None
+    assert store.get(token, netops, now=161) is None
+    assert store.get("unknown", netops, now=100) is None
+
+    first = store.store(_content(1), "walk ifDescr", 2, now=200)
+    store.store(_content(2), "walk ifDescr", 2, now=201)
+    store.store(_content(3), "walk ifDescr", 2, now=202)
+    assert store.get(first, now=203) is None
+
+
+def test_same_results_reuse_their_token():
+    """Test that storing the same response again for the same key hands out its token instead of another set"""
+    store = PageStore(ttl=60, max_sets=2)
+    netops = APIKeyPolicy(name="netops", digest="n1")
+    token = store.store(_content(), "walk ifDescr", 2, netops, now=100)
+
+    assert store.store(_content(), "walk ifDescr", 2, netops, now=110) == token
+    assert store.store(_content(), "walk ifDescr", 2, APIKeyPolicy(name="netops", digest="n2"), now=110) != token
+    assert store.store(_content(), "walk ifDescr", 3, netops, now=110) != token
+    # Dropped for the two new sets, or expired: stored again under a new token
+    assert store.get(token, netops, now=120) is None
+    again = store.store(_content(), "walk ifDescr", 2, netops, now=120)
+    assert again != token
+    assert store.store(_content(), "walk ifDescr", 2, netops, now=200) not in (token, again)
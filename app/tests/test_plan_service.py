@@ -0,0 +1,58 @@
+from app.core.config import APIKeyPolicy
+from app.models.query import SNMPQuery, SNMPTarget, SNMPOperation, SNMPCredentials
+from app.services.plan_service import PlanStore
+
+
+def _query(host="10.0.0.1", community="s3cret"):
+    return SNMPQuery(
+        target=SNMPTarget(host=host),
+        credentials=SNMPCredentials(version="2c", community=community),
+        operation=SNMPOperation(command="WALK", oids=["1.3.6.1.2.1.2.2"]),
+        raw_query="walk the interfaces of 10.0.0.1 with community s3cret"
+    )
+
+
+def test_edited_plan_keeps_interpreted_secrets():
+    """Test that an edited plan, shown without secrets, runs with the interpreted credentials"""
+    store = PlanStore(ttl=60, max_plans=10)
+    token = store.issue(_query())
+
+    edited = SNMPQuery.model_validate(_query().plan())
+    edited.operation.oids = ["1.3.6.1.2.1.2.2.1.2"]
+    query = store.apply_edits(token, edited)
+
+    assert query.operation.oids == ["1.3.6.1.2.1.2.2.1.2"]
+    assert query.credentials.community == "s3cret"
+    assert query.raw_query == "walk the interfaces of 10.0.0.1 with community s3cret"
+
+
+def test_secrets_not_reused_for_another_host():
+    """Test that a plan edited to another device doesn't take the interpreted secrets there"""
+    store = PlanStore(ttl=60, max_plans=10)
+    token = store.issue(_query())
+
+    edited = SNMPQuery.model_validate(_query().plan())
+    edited.target.host = "10.0.0.2"
+
+    assert store.apply_edits(token, edited).credentials.community is None
+    edited.credentials.community = "other"
+    assert store.apply_edits(token, edited).credentials.community == "other"
+
+
+def test_tokens_expire_and_belong_to_their_key():
+    """Test that tokens expire, only work for the key that made them and are bounded in number"""
+    store = PlanStore(ttl=60, max_plans=2)
+    netops = APIKeyPolicy(name="netops", digest="n1")
+    token = store.issue(_query(), netops, now=100)
+
+    assert store.get(token, netops, now=150) is not None
+    assert store.get(token, APIKeyPolicy(name="other", digest="o1"), now=150) is None
+    assert store.get(token, APIKeyPolicy(name="netops", digest="n2"), now=150) is None
+    assert store.get(token, None, now=150) is None
+    assert store.get(token, netops, now=161) is None
+    assert store.get("unknown", netops, now=100) is None
+
+    first = store.issue(_query(), now=200)
+    store.issue(_query(), now=201)
+    store.issue(_query(), now=202)
+    assert store.get(first, now=203) is None
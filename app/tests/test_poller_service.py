@@ -0,0 +1,85 @@
+import pytest
+from unittest.mock import patch, MagicMock, AsyncMock
+
+from app.services.poller_service import PollerService
+from app.services.snmp_service import SNMPService
+from app.models.query import SNMPQuery, SNMPTarget, SNMPOperation, SNMPCredentials
+
+
+def _poll_query(host="192.168.1.1"):
+    return SNMPQuery(
+        target=SNMPTarget(host=host, port=161),
+        credentials=SNMPCredentials(version="2c", community="public"),
+        operation=SNMPOperation(command="GET", oids=[".1.3.6.1.2.1.1.3.0"])
+    )
+
+
+@pytest.mark.asyncio
+async def test_backoff_on_failure_and_reset_on_recovery():
+    """Test that consecutive failures back off the interval and success resets it"""
+    mock_snmp_service = MagicMock(spec=SNMPService)
+    mock_snmp_service.execute_query = AsyncMock(side_effect=[
+        {"error": "SNMP request timed out"},
+        {"error": "SNMP request timed out"},
+        {"error": "SNMP request timed out"},
+        {"SNMPv2-MIB::sysUpTime.0": 12345},
+    ])
+
+    with patch("app.services.poller_service.config.poller.max_interval", 300), \
+            patch("app.services.poller_service.config.poller.backoff_factor", 2.0):
+        service = PollerService(snmp_service=mock_snmp_service)
+        service.add_target(_poll_query(), interval=60)
+
+        # Failures double the interval up to the cap
+        intervals = []
+        for _ in range(3):
+            await service.poll_target("192.168.1.1")
+            intervals.append(service.get_intervals()["192.168.1.1"]["interval"])
+        assert intervals == [120, 240, 300]
+
+        state = service.get_intervals()["192.168.1.1"]
+        assert state["consecutive_failures"] == 3
+        assert state["last_error"] == "SNMP request timed out"
+
+        # Recovery resets to the base interval
+        await service.poll_target("192.168.1.1")
+        state = service.get_intervals()["192.168.1.1"]
+        assert state["interval"] == 60
+        assert state["consecutive_failures"] == 0
+        assert state["last_error"] is None
+
+
+@pytest.mark.asyncio
+async def test_backoff_capped_after_many_failures():
+    """Test that a target failing thousands of times in a row stays at the cap and keeps a next poll time"""
+    mock_snmp_service = MagicMock(spec=SNMPService)
+    mock_snmp_service.execute_query = AsyncMock(return_value={"error": "SNMP request timed out"})
+
+    with patch("app.services.poller_service.config.poller.max_interval", 300):
+        service = PollerService(snmp_service=mock_snmp_service)
+        service.add_target(_poll_query(), interval=60)
+        service.targets["192.168.1.1"].consecutive_failures = 5000
+        service.targets["192.168.1.1"].interval = 300
+
+        await service.poll_target("192.168.1.1")
+
+    state = service.get_intervals()["192.168.1.1"]
+    assert state["interval"] == 300
+    assert state["consecutive_failures"] == 5001
+    assert state["next_poll_in"] > 200
+
+
+@pytest.mark.asyncio
+async def test_poll_due_skips_backed_off_targets():
+    """Test that a backed-off target isn't polled again until its interval passes"""
+    mock_snmp_service = MagicMock(spec=SNMPService)
+    mock_snmp_service.execute_query = AsyncMock(return_value={"error": "Connection refused"})
+
+    service = PollerService(snmp_service=mock_snmp_service)
+    service.add_target(_poll_query(), interval=60)
+
+    await service.poll_due()
+    await service.poll_due()
+
+    mock_snmp_service.execute_query.assert_called_once()
+    assert service.get_intervals()["192.168.1.1"]["next_poll_in"] > 60
@@ -0,0 +1,55 @@
+import pytest
+
+from app.utils.problems import PROBLEM_TYPES, ERROR_CODE_TYPES, STATUS_TYPES, build_problem, problem_status
+
+
+def test_build_problem_members():
+    """Test that problem details carry the RFC 7807 members, the error code and extensions"""
+    problem = build_problem(502, "SNMP authentication failed: unknown user name", "/problems/",
+                            error_code="SNMP_AUTH_FAILED", query="get sysName of 10.0.0.1", debug=None)
+
+    assert problem["type"] == "/problems/snmp-auth-failed"
+    assert problem["title"] == "SNMP authentication failed"
+    assert problem["status"] == 502
+    assert problem["detail"] == "SNMP authentication failed: unknown user name"
+    assert problem["instance"].startswith("urn:uuid:")
+    assert problem["error_code"] == "SNMP_AUTH_FAILED"
+    assert problem["query"] == "get sysName of 10.0.0.1"
+    assert "debug" not in problem
+    assert build_problem(502, "", "/problems/")["instance"] != problem["instance"]
+
+
+@pytest.mark.parametrize("status,error_code,problem_type", [
+    (400, None, "https://example.net/problems/invalid-request"),
+    (404, None, "https://example.net/problems/not-found"),
+    (422, "NEEDS_CLARIFICATION", "https://example.net/problems/needs-clarification"),
+    (502, None, "https://example.net/problems/snmp-error"),
+    (504, "REQUEST_TIMEOUT", "https://example.net/problems/request-timeout"),
+    (429, None, "about:blank"),
+])
+def test_problem_type_from_code_or_status(status, error_code, problem_type):
+    """Test that the type comes from the error code, then the status, and is about:blank otherwise"""
+    problem = build_problem(status, "failed", "https://example.net/problems/", error_code=error_code)
+
+    assert problem["type"] == problem_type
+    if problem_type == "about:blank":
+        assert problem["title"] == "Too Many Requests"
+
+
+def test_every_problem_has_an_error_code():
+    """Test that a problem without an error code gets the code of its status"""
+    assert build_problem(404, "No running operation op-1", "/problems/")["error_code"] == "NOT_FOUND"
+    assert build_problem(502, "SNMP error: genErr", "/problems/")["error_code"] == "SNMP_ERROR"
+    assert build_problem(502, "Timed out", "/problems/", error_code="SNMP_TIMEOUT")["error_code"] == "SNMP_TIMEOUT"
+    assert build_problem(418, "I'm a teapot", "/problems/").get("error_code") is None
+
+
+def test_problem_status_and_types_consistent():
+    """Test that error codes give their type's status, and every mapped type is documented"""
+    assert problem_status("REQUEST_TIMEOUT") == 504
+    assert problem_status("SNMP_AUTH_FAILED") == 502
+    assert problem_status(None) == 502
+    assert problem_status("UNKNOWN", default=500) == 500
+
+    assert set(ERROR_CODE_TYPES.values()) | set(STATUS_TYPES.values()) <= set(PROBLEM_TYPES)
+    assert all(PROBLEM_TYPES[name].status == status for status, name in STATUS_TYPES.items())
@@ -0,0 +1,77 @@
+import pytest
+from unittest.mock import patch
+
+from app.services.openai_service import OpenAIService
+from app.services.query_transforms import (
+    register_query_transform, clear_query_transforms, apply_query_transforms, QueryRejectedError
+)
+from app.models.query import SNMPQuery, SNMPTarget, SNMPOperation
+
+
+@pytest.fixture(autouse=True)
+def no_transforms():
+    """Start and end every test without registered transforms"""
+    clear_query_transforms()
+    yield
+    clear_query_transforms()
+
+
+def _query(host="core-sw1"):
+    return SNMPQuery(
+        target=SNMPTarget(host=host),
+        operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"])
+    )
+
+
+def test_transform_rewrites_target():
+    """Test a transform that rewrites a hostname to an IP"""
+    def resolve_site_hosts(query):
+        query.target.host = {"core-sw1": "10.0.0.2"}.get(query.target.host, query.target.host)
+        return query
+
+    register_query_transform(resolve_site_hosts)
+
+    assert apply_query_transforms(_query()).target.host == "10.0.0.2"
+    assert apply_query_transforms(_query("10.0.0.9")).target.host == "10.0.0.9"
+
+
+def test_transforms_run_in_registration_order():
+    """Test that each transform sees the output of the previous one"""
+    register_query_transform(lambda query: query.model_copy(update={"raw_query": "first"}))
+    register_query_transform(lambda query: query.model_copy(update={"raw_query": query.raw_query + ",second"}))
+
+    assert apply_query_transforms(_query()).raw_query == "first,second"
+
+
+def test_transform_can_reject():
+    """Test that a transform can reject a query"""
+    def deny_lab(query):
+        raise QueryRejectedError("Lab devices can't be queried")
+
+    def drop_everything(query):
+        return None
+
+    register_query_transform(deny_lab)
+    with pytest.raises(QueryRejectedError, match="Lab devices"):
+        apply_query_transforms(_query())
+
+    clear_query_transforms()
+    register_query_transform(drop_everything)
+    with pytest.raises(QueryRejectedError, match="drop_everything"):
+        apply_query_transforms(_query())
+
+
+@pytest.mark.asyncio
+async def test_interpreted_queries_are_transformed():
+    """Test that transforms are applied after interpretation"""
+    def force_community(query):
+        query.credentials.community = "site-ro"
+        return query
+
+    register_query_transform(force_community)
+
+    with patch("app.services.llm_providers.OpenAI"), \
+            patch("app.services.openai_service.config.interpreter_mode", "hybrid"):
+        result = await OpenAIService().process_query("get sysName.0 from core-sw1")
+
+    assert result.credentials.community == "site-ro"
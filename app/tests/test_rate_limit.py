@@ -0,0 +1,73 @@
+import pytest
+
+from app.utils.rate_limit import RateLimiter, client_ip, endpoint_class, parse_networks
+
+PROXIES = parse_networks(["10.0.0.0/8", "2001:db8::1"])
+
+
+@pytest.mark.parametrize("peer,forwarded_for,expected", [
+    # Direct connections: the header is the client's own claim and is ignored
+    ("203.0.113.7", "198.51.100.1", "203.0.113.7"),
+    ("203.0.113.7", None, "203.0.113.7"),
+    # Through a trusted proxy, the address it appended
+    ("10.0.0.2", "198.51.100.1", "198.51.100.1"),
+    ("2001:db8::1", "198.51.100.1", "198.51.100.1"),
+    # A spoofed entry to the left of the proxy's isn't taken
+    ("10.0.0.2", "1.2.3.4, 198.51.100.1", "198.51.100.1"),
+    # Chains of trusted proxies are followed to the first untrusted address
+    ("10.0.0.2", "1.2.3.4, 198.51.100.1, 10.1.1.1", "198.51.100.1"),
+    # A malformed entry stops at the last trusted hop; no header leaves the proxy itself
+    ("10.0.0.2", "198.51.100.1, bogus", "10.0.0.2"),
+    ("10.0.0.2", None, "10.0.0.2"),
+])
+def test_client_ip_behind_trusted_proxies(peer, forwarded_for, expected):
+    """Test that X-Forwarded-For is only honored from trusted proxies, read from the right"""
+    assert client_ip(peer, forwarded_for, PROXIES) == expected
+
+
+def test_client_ip_without_trusted_proxies():
+    """Test that X-Forwarded-For is ignored when no proxy is trusted"""
+    assert client_ip("10.0.0.2", "198.51.100.1", []) == "10.0.0.2"
+
+
+def test_limit_enforced_per_client():
+    """Test that each client gets its burst, then waits for the bucket to refill"""
+    limiter = RateLimiter(per_minute=60, burst=3, max_clients=100)
+
+    assert [limiter.acquire("198.51.100.1", now=100) for _ in range(3)] == [None, None, None]
+    assert limiter.acquire("198.51.100.1", now=100) == pytest.approx(1.0)
+    assert limiter.acquire("198.51.100.2", now=100) is None
+
+    assert limiter.acquire("198.51.100.1", now=100.5) == pytest.approx(0.5)
+    assert limiter.acquire("198.51.100.1", now=101) is None
+
+
+def test_limit_disabled_and_clients_bounded():
+    """Test that a rate of 0 allows everything, and only max_clients buckets are kept"""
+    disabled = RateLimiter(per_minute=0, burst=1, max_clients=100)
+    assert all(disabled.acquire("198.51.100.1", now=100) is None for _ in range(50))
+
+    limiter = RateLimiter(per_minute=60, burst=1, max_clients=2)
+    for client in ("198.51.100.1", "198.51.100.2", "198.51.100.3"):
+        limiter.acquire(client, now=100)
+    assert list(limiter._buckets) == ["198.51.100.2", "198.51.100.3"]
+
+
+@pytest.mark.parametrize("path,expected", [
+    ("/query", "query"),
+    ("/query/stream", "query"),
+    ("/plan", "query"),
+    ("/mibs", "mib"),
+    ("/mibs/upload", "mib"),
+    ("/oid/resolve", "mib"),
+    ("/oids/ifDescr", "mib"),
+    ("/aliases", "mib"),
+    # Executing an edited plan runs a query like /query does
+    ("/execute", "query"),
+    # Other endpoints are only limited per IP
+    ("/queryx", None),
+    ("/metrics", None),
+])
+def test_endpoint_class(path, expected):
+    """Test that the query endpoints and the MIB lookups are classed apart"""
+    assert endpoint_class(path) == expected
@@ -0,0 +1,129 @@
+import pytest
+from unittest.mock import patch
+
+from app.utils.redaction import (
+    DEFAULT_REDACT_PATTERNS, compile_patterns, redact, truncate,
+    register_secret, clear_query_secrets, scrub_secrets, scrub_detail, scrub_error_fields, scrub_log_record
+)
+from app.services.snmp_service import SNMPService
+from app.services.mib_service import MIBService
+from app.models.query import SNMPQuery, SNMPTarget, SNMPOperation, SNMPCredentials
+
+PATTERNS = compile_patterns(DEFAULT_REDACT_PATTERNS)
+
+
+def test_redact_json_credentials():
+    """Test that credential values in JSON are redacted and their keys kept"""
+    text = '{"credentials": {"version": "3", "community": "s3cret", "auth_password": "authpass"}}'
+
+    assert redact(text, PATTERNS) == '{"credentials": {"version": "3", "community": "****", "auth_password": "****"}}'
+
+
+def test_redact_free_text_credentials():
+    """Test that credentials in natural language and CLI-style options are redacted"""
+    assert redact("walk ifTable on 10.0.0.1 using community string 'private'", PATTERNS) == \
+        "walk ifTable on 10.0.0.1 using community string '****'"
+    assert redact("get sysName from router1 -c s3cret", PATTERNS) == "get sysName from router1 -c ****"
+    assert redact("the password is hunter2", PATTERNS) == "the password is ****"
+    assert redact("key sk-abcdefghijklmnop", PATTERNS) == "key ****"
+    assert redact("get sysDescr from 10.0.0.1", PATTERNS) == "get sysDescr from 10.0.0.1"
+
+
+def test_redact_custom_pattern():
+    """Test that configured patterns without a secret group redact the whole match"""
+    patterns = compile_patterns([r"\bsite-\d+\b"])
+
+    assert redact("uptime of the router at site-42", patterns) == "uptime of the router at ****"
+
+
+def test_truncate():
+    """Test that long text is capped and notes how much was cut"""
+    assert truncate("abcdef", 4) == "abcd... [2 more characters]"
+    assert truncate("abc", 4) == "abc"
+    assert truncate("abcdef", 0) == "abcdef"
+
+
+@pytest.fixture
+def query_secrets():
+    """Forget the secrets registered from queries after the test"""
+    yield
+    clear_query_secrets()
+
+
+def test_scrub_registered_secrets(query_secrets):
+    """Test that registered secrets are scrubbed wherever they appear, longest first"""
+    register_secret("s3cret")
+    register_secret("s3cret-rw")
+    register_secret("abc")
+
+    assert scrub_secrets("send failed for community s3cret-rw, retried with s3cret") == \
+        "send failed for community ****, retried with ****"
+    # Too short to be scrubbed without mangling other text
+    assert scrub_secrets("abc") == "abc"
+    # Only whole tokens are scrubbed
+    assert scrub_secrets("s3crets and pre-s3cret") == "s3crets and pre-****"
+
+    record = {"message": "Authentication with passphrase s3cret failed"}
+    scrub_log_record(record)
+    assert record["message"] == "Authentication with passphrase **** failed"
+
+
+def test_well_known_communities_not_scrubbed(query_secrets):
+    """Test that default communities and secrets inside longer tokens are left in messages"""
+    register_secret("public")
+    register_secret("Private")
+    register_secret("1234")
+
+    assert scrub_secrets("public holiday, private network") == "public holiday, private network"
+    assert scrub_secrets("port 12345 timed out, community 1234 rejected") == \
+        "port 12345 timed out, community **** rejected"
+
+
+def test_scrub_nested_detail(query_secrets):
+    """Test that secrets are scrubbed from every string of a structured error detail"""
+    register_secret("s3cret")
+
+    detail = {
+        "message": "community s3cret rejected",
+        "error_code": "SNMP_AUTH_FAILED",
+        "targets": [{"host": "10.0.0.1", "error": "timeout with s3cret"}],
+        "attempts": 2,
+    }
+    assert scrub_detail(detail) == {
+        "message": "community **** rejected",
+        "error_code": "SNMP_AUTH_FAILED",
+        "targets": [{"host": "10.0.0.1", "error": "timeout with ****"}],
+        "attempts": 2,
+    }
+
+
+def test_query_secrets_bounded(query_secrets):
+    """Test that only the most recent query secrets are kept"""
+    with patch("app.utils.redaction.MAX_QUERY_SECRETS", 2):
+        for secret in ("first-secret", "second-secret", "third-secret"):
+            register_secret(secret)
+
+    assert scrub_secrets("first-secret second-secret third-secret") == "first-secret **** ****"
+
+
+@pytest.mark.asyncio
+async def test_query_credentials_scrubbed_from_errors(query_secrets):
+    """Test that a query's community and passphrases never come back in its errors"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        credentials=SNMPCredentials(version="2c", community="n0t-for-clients"),
+        operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"])
+    )
+
+    def leaky_client(host, credentials, port=161, **kwargs):
+        raise OSError(f"socket error sending to {host} with community n0t-for-clients")
+
+    with patch("app.services.snmp_service.Client", side_effect=leaky_client):
+        result = await service.execute_query(query)
+
+    assert "n0t-for-clients" not in result["error"]
+    assert "****" in result["error"]
+
+    walk = scrub_error_fields({"1.3.6.1.2.1.2_error": "agent rejected n0t-for-clients", "ifDescr.1": "n0t-for-clients"})
+    assert walk == {"1.3.6.1.2.1.2_error": "agent rejected ****", "ifDescr.1": "n0t-for-clients"}
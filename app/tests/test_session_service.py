@@ -0,0 +1,62 @@
+from app.core.config import APIKeyPolicy
+from app.models.query import SNMPQuery, SNMPTarget, SNMPOperation, SNMPCredentials, SNMPResult
+from app.services.session_service import SessionStore, context_prompt
+
+
+def _query(host="10.0.0.1", port=161):
+    return SNMPQuery(
+        target=SNMPTarget(host=host, port=port),
+        credentials=SNMPCredentials(community="s3cret"),
+        operation=SNMPOperation(command="WALK", oids=["1.3.6.1.2.1.2.2.1.2"]),
+        raw_query="walk ifDescr"
+    )
+
+
+def _results(count):
+    return [SNMPResult(name=f"IF-MIB::ifDescr.{index}", oid=f"1.3.6.1.2.1.2.2.1.2.{index}", value=f"eth{index}")
+            for index in range(1, count + 1)]
+
+
+def test_context_prompt_numbers_results():
+    """Test that the context numbers the previous results in order, without credentials"""
+    store = SessionStore(ttl=60, max_sessions=10, max_results=50)
+    store.record("s1", _query(port=1161), _results(3), "Three interfaces")
+
+    prompt = context_prompt(store.get("s1"))
+
+    assert "the previous query was 'walk ifDescr' on 10.0.0.1:1161" in prompt
+    assert "Summary: Three interfaces" in prompt
+    assert "1. IF-MIB::ifDescr.1 = 1.3.6.1.2.1.2.2.1.2.1\n2. IF-MIB::ifDescr.2" in prompt
+    assert "3. IF-MIB::ifDescr.3 = 1.3.6.1.2.1.2.2.1.2.3" in prompt
+    assert "s3cret" not in prompt
+
+
+def test_session_replaced_capped_and_expired():
+    """Test that a session keeps only its last query's first results, and is forgotten after the TTL"""
+    store = SessionStore(ttl=60, max_sessions=10, max_results=2)
+    store.record("s1", _query("10.0.0.1"), _results(1), "One", now=0)
+    store.record("s1", _query("10.0.0.2"), _results(5), "Five", now=10)
+
+    context = store.get("s1", now=20)
+    assert context.host == "10.0.0.2"
+    assert [oid for _, oid in context.results] == ["1.3.6.1.2.1.2.2.1.2.1", "1.3.6.1.2.1.2.2.1.2.2"]
+    assert store.get("s1", now=71) is None
+    assert store.get("unknown") is None
+
+
+def test_session_belongs_to_its_api_key():
+    """Test that another API key can neither read nor overwrite a session, and the least recent one is dropped"""
+    ops, other = APIKeyPolicy(name="ops"), APIKeyPolicy(name="other")
+    store = SessionStore(ttl=60, max_sessions=2, max_results=50)
+    store.record("s1", _query(), _results(2), "Two", api_key=ops)
+
+    assert store.get("s1", api_key=other) is None
+    assert store.record("s1", _query("10.9.9.9"), _results(1), "Hijack", api_key=other) is False
+    assert store.get("s1", api_key=ops).host == "10.0.0.1"
+    assert store.forget("s1", api_key=other) is False
+
+    store.record("s2", _query(), _results(1), "One")
+    store.record("s3", _query(), _results(1), "One")
+    assert store.get("s1", api_key=ops) is None
+    assert store.forget("s3") is True
+    assert store.get("s3") is None
@@ -0,0 +1,205 @@
+import asyncio
+import socket
+import pytest
+from unittest.mock import patch
+
+from app.simulator import SNMPSimulator, DEMO_TARGET, demo_target_transform, load_snmprec
+from app.services.openai_service import OpenAIService
+from app.services.snmp_service import SNMPService
+from app.services.mib_service import MIBService
+from app.services.query_transforms import register_query_transform, clear_query_transforms
+from app.models.query import SNMPQuery, SNMPTarget, SNMPOperation
+from app.utils.ber import (
+    INTEGER, OCTET_STRING, COUNTER32, TIME_TICKS, NO_SUCH_OBJECT, NO_SUCH_INSTANCE, END_OF_MIB_VIEW,
+    GET_REQUEST, GET_NEXT_REQUEST, GET_BULK_REQUEST, SET_REQUEST, NULL,
+    encode_integer, encode_oid, encode_sequence, encode_tlv, decode_tlv, decode_sequence, decode_integer,
+    decode_unsigned, decode_oid,
+)
+
+SYS_NAME = "1.3.6.1.2.1.1.5.0"
+IF_DESCR = "1.3.6.1.2.1.2.2.1.2"
+
+
+def _request(pdu_type, oids, version=1, community="public", first=0, second=0, request_id=7):
+    """Encode an SNMP request message"""
+    pdu = encode_sequence([
+        encode_integer(request_id),
+        encode_integer(first),
+        encode_integer(second),
+        encode_sequence([encode_sequence([encode_oid(oid), encode_tlv(NULL, b"")]) for oid in oids]),
+    ], tag=pdu_type)
+    return encode_sequence([encode_integer(version), encode_tlv(OCTET_STRING, community.encode()), pdu])
+
+
+def _response(message):
+    """Decode a response message into (request id, error status, error index, [(oid, tag, value)])"""
+    _, content, _ = decode_tlv(message)
+    _, _, (_, pdu) = decode_sequence(content)
+    request_id, error_status, error_index, (_, varbinds) = decode_sequence(pdu)
+    decoded = []
+    for _, varbind in decode_sequence(varbinds):
+        (_, oid), (tag, value) = decode_sequence(varbind)
+        if tag == INTEGER:
+            value = decode_integer(value)
+        elif tag in (COUNTER32, TIME_TICKS):
+            value = decode_unsigned(value)
+        decoded.append((decode_oid(oid), tag, value))
+    return decode_integer(request_id[1]), decode_integer(error_status[1]), decode_integer(error_index[1]), decoded
+
+
+def test_simulator_get():
+    """Test that GET returns values, noSuchInstance for a missing row and noSuchObject otherwise"""
+    simulator = SNMPSimulator()
+    request_id, error_status, _, varbinds = _response(simulator.handle(_request(
+        GET_REQUEST, [SYS_NAME, "1.3.6.1.2.1.2.2.1.10.2", "1.3.6.1.2.1.2.2.1.10.9", "1.3.6.1.9.9.0"], request_id=42
+    )))
+
+    assert request_id == 42
+    assert error_status == 0
+    assert varbinds == [
+        (SYS_NAME, OCTET_STRING, b"demo-router"),
+        ("1.3.6.1.2.1.2.2.1.10.2", COUNTER32, 987654321),
+        ("1.3.6.1.2.1.2.2.1.10.9", NO_SUCH_INSTANCE, b""),
+        ("1.3.6.1.9.9.0", NO_SUCH_OBJECT, b""),
+    ]
+
+
+def test_simulator_getnext_walks_to_end_of_mib_view():
+    """Test that GETNEXT steps through the objects in OID order and ends with endOfMibView"""
+    simulator = SNMPSimulator({"1.3.6.1.2.1.1.5.0": (OCTET_STRING, "a"), "1.3.6.1.2.1.1.10.0": (INTEGER, 5)})
+
+    _, _, _, varbinds = _response(simulator.handle(_request(GET_NEXT_REQUEST, ["1.3.6.1.2.1.1"])))
+    assert varbinds == [("1.3.6.1.2.1.1.5.0", OCTET_STRING, b"a")]
+
+    # Numeric, not string, order: .10 comes after .5
+    _, _, _, varbinds = _response(simulator.handle(_request(GET_NEXT_REQUEST, ["1.3.6.1.2.1.1.5.0"])))
+    assert varbinds == [("1.3.6.1.2.1.1.10.0", INTEGER, 5)]
+
+    _, _, _, varbinds = _response(simulator.handle(_request(GET_NEXT_REQUEST, ["1.3.6.1.2.1.1.10.0"])))
+    assert varbinds == [("1.3.6.1.2.1.1.10.0", END_OF_MIB_VIEW, b"")]
+
+
+def test_simulator_getbulk():
+    """Test that GETBULK returns the non-repeaters once and then rows of the repeating columns"""
+    simulator = SNMPSimulator()
+    _, error_status, _, varbinds = _response(simulator.handle(_request(
+        GET_BULK_REQUEST, ["1.3.6.1.2.1.1.5", IF_DESCR, "1.3.6.1.2.1.2.2.1.10"], first=1, second=2
+    )))
+
+    assert error_status == 0
+    assert [oid for oid, _, _ in varbinds] == [
+        SYS_NAME,
+        "1.3.6.1.2.1.2.2.1.2.1", "1.3.6.1.2.1.2.2.1.10.1",
+        "1.3.6.1.2.1.2.2.1.2.2", "1.3.6.1.2.1.2.2.1.10.2",
+    ]
+
+
+def test_simulator_v1():
+    """Test that v1 reports missing objects as noSuchName and drops GETBULK"""
+    simulator = SNMPSimulator()
+
+    _, error_status, error_index, varbinds = _response(simulator.handle(
+        _request(GET_REQUEST, [SYS_NAME, "1.3.6.1.9.9.0"], version=0)
+    ))
+    assert (error_status, error_index) == (2, 2)
+    assert [oid for oid, _, _ in varbinds] == [SYS_NAME, "1.3.6.1.9.9.0"]
+
+    assert simulator.handle(_request(GET_BULK_REQUEST, [IF_DESCR], version=0, second=5)) is None
+
+
+def test_simulator_drops_wrong_community_and_rejects_set():
+    """Test that a wrong community gets no answer and SETs are refused as read-only"""
+    simulator = SNMPSimulator(community="s3cret")
+
+    assert simulator.handle(_request(GET_REQUEST, [SYS_NAME], community="public")) is None
+    assert simulator.handle(b"\x30\x03garbage") is None
+
+    _, error_status, error_index, _ = _response(simulator.handle(
+        _request(SET_REQUEST, [SYS_NAME], community="s3cret")
+    ))
+    assert (error_status, error_index) == (17, 1)
+
+
+def test_load_snmprec(tmp_path):
+    """Test that snmpsim-style records are loaded, including hex values, and bad records are rejected"""
+    path = tmp_path / "device.snmprec"
+    path.write_text(
+        "# a switch\n"
+        "1.3.6.1.2.1.1.5.0|4|switch1\n"
+        "1.3.6.1.2.1.1.1.0|4x|48656c6c6f\n"
+        "1.3.6.1.2.1.1.3.0|67|360000\n"
+        "1.3.6.1.2.1.4.20.1.1.10.0.0.1|64|10.0.0.1\n"
+        "\n"
+    )
+
+    assert load_snmprec(str(path)) == {
+        "1.3.6.1.2.1.1.5.0": (OCTET_STRING, "switch1"),
+        "1.3.6.1.2.1.1.1.0": (OCTET_STRING, b"Hello"),
+        "1.3.6.1.2.1.1.3.0": (TIME_TICKS, 360000),
+        "1.3.6.1.2.1.4.20.1.1.10.0.0.1": (0x40, "10.0.0.1"),
+    }
+
+    path.write_text("1.3.6.1.2.1.1.5.0|4|ok\n1.3.6.1.2.1.1.6.0|99|bad type\n")
+    with pytest.raises(ValueError, match=":2:"):
+        load_snmprec(str(path))
+
+
+@pytest.mark.asyncio
+async def test_simulator_over_udp():
+    """Test that the simulator answers requests sent over UDP"""
+    simulator = SNMPSimulator()
+    host, port = await simulator.start("127.0.0.1", 0)
+    try:
+        loop = asyncio.get_running_loop()
+        with socket.socket(socket.AF_INET, socket.SOCK_DGRAM) as sock:
+            sock.setblocking(False)
+            await loop.sock_connect(sock, (host, port))
+            await loop.sock_sendall(sock, _request(GET_REQUEST, [SYS_NAME]))
+            message = await asyncio.wait_for(loop.sock_recv(sock, 65535), timeout=2)
+    finally:
+        simulator.close()
+
+    assert _response(message)[3] == [(SYS_NAME, OCTET_STRING, b"demo-router")]
+
+
+@pytest.mark.asyncio
+async def test_pipeline_against_simulator():
+    """Test interpreting, executing and enriching queries end to end against the simulator"""
+    simulator = SNMPSimulator()
+    host, port = await simulator.start("127.0.0.1", 0)
+    service = SNMPService(mib_service=MIBService())
+
+    def query(command, oids):
+        return SNMPQuery(
+            target=SNMPTarget(host=host, port=port, timeout=2, retries=1),
+            operation=SNMPOperation(command=command, oids=oids)
+        )
+
+    try:
+        result = await service.execute_query(query("GET", [SYS_NAME]))
+        assert "error" not in result
+        assert "demo-router" in str(next(iter(result.values())))
+
+        result = await service.execute_query(query("WALK", [IF_DESCR]))
+        assert "error" not in result
+        assert len(result) == 2
+        assert any("eth0" in str(value) for value in result.values())
+
+        result = await service.execute_query(query("BULK", [IF_DESCR, "1.3.6.1.2.1.2.2.1.10"]))
+        assert "error" not in result
+        assert len(result) == 4
+
+        # The "demo" target is pointed at the simulator, and the rule-based interpreter needs no LLM
+        clear_query_transforms()
+        register_query_transform(demo_target_transform(host, port))
+        with patch("app.services.openai_service.config.interpreter_mode", "rules"):
+            snmp_query = await OpenAIService().process_query(f"walk {IF_DESCR} on {DEMO_TARGET}")
+        assert (snmp_query.target.host, snmp_query.target.port) == (host, port)
+
+        result = await service.execute_query(snmp_query)
+        results = service.enrich_results(result)
+        assert len(results) == 2
+        assert {result.oid for result in results} == {f"{IF_DESCR}.1", f"{IF_DESCR}.2"}
+    finally:
+        clear_query_transforms()
+        simulator.close()
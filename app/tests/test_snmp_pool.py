@@ -0,0 +1,111 @@
+import asyncio
+import socket
+import time
+
+import pytest
+from puresnmp.exc import Timeout
+
+from app.simulator import SNMPSimulator
+from app.services.snmp_pool import SNMPConnectionPool, connection_key
+from app.utils.ber import GET_REQUEST, NULL, OCTET_STRING, encode_integer, encode_oid, encode_sequence, encode_tlv
+
+SYS_NAME = "1.3.6.1.2.1.1.5.0"
+
+
+def _get(request_id):
+    """Encode an SNMPv2c GET of sysName.0"""
+    pdu = encode_sequence([
+        encode_integer(request_id), encode_integer(0), encode_integer(0),
+        encode_sequence([encode_sequence([encode_oid(SYS_NAME), encode_tlv(NULL, b"")])]),
+    ], tag=GET_REQUEST)
+    return encode_sequence([encode_integer(1), encode_tlv(OCTET_STRING, b"public"), pdu])
+
+
+def _closed_port():
+    """A local UDP port with nothing listening on it"""
+    with socket.socket(socket.AF_INET, socket.SOCK_DGRAM) as probe:
+        probe.bind(("127.0.0.1", 0))
+        return probe.getsockname()[1]
+
+
+@pytest.mark.asyncio
+async def test_repeated_gets_reuse_one_socket():
+    """Test that repeated GETs to a target open one socket, where sending each on its own socket opens one per GET"""
+    simulator = SNMPSimulator()
+    host, port = await simulator.start("127.0.0.1", 0)
+    loop = asyncio.get_running_loop()
+    create_datagram_endpoint = loop.create_datagram_endpoint
+    sockets = []
+
+    async def counting_endpoint(*args, **kwargs):
+        sockets.append(kwargs.get("remote_addr"))
+        return await create_datagram_endpoint(*args, **kwargs)
+
+    loop.create_datagram_endpoint = counting_endpoint
+    try:
+        key = connection_key(host, port, "2c", "public")
+        pool = SNMPConnectionPool(idle_timeout=60, max_idle=4)
+        send = pool.sender(key, host, port)
+        started = time.monotonic()
+        for request_id in range(1, 51):
+            reply = await send((host, port), _get(request_id), timeout=1, retries=1)
+            assert b"demo-router" in reply
+        pooled_time = time.monotonic() - started
+        pooled_sockets = len(sockets)
+
+        # Without the pool, as puresnmp sends by default
+        sockets.clear()
+        started = time.monotonic()
+        for request_id in range(1, 51):
+            single = SNMPConnectionPool(idle_timeout=60, max_idle=1)
+            await single.sender(key, host, port)((host, port), _get(request_id), timeout=1, retries=1)
+            single.close()
+        unpooled_time = time.monotonic() - started
+        assert pool.describe() == {"idle": 1, "opened": 1, "reused": 49}
+    finally:
+        loop.create_datagram_endpoint = create_datagram_endpoint
+        pool.close()
+        simulator.close()
+
+    assert pooled_sockets == 1
+    assert len(sockets) == 50
+    assert pooled_time < unpooled_time * 2
+
+
+@pytest.mark.asyncio
+async def test_failed_connection_is_not_reused():
+    """Test that a socket whose request failed is closed, and the next request opens a new one"""
+    port = _closed_port()
+    key = connection_key("127.0.0.1", port, "2c", "public")
+    pool = SNMPConnectionPool(idle_timeout=60, max_idle=4)
+    send = pool.sender(key, "127.0.0.1", port)
+
+    for _ in range(2):
+        with pytest.raises((ConnectionRefusedError, Timeout)):
+            await send(None, _get(1), timeout=0.2, retries=1)
+
+    assert pool.describe() == {"idle": 0, "opened": 2, "reused": 0}
+
+
+@pytest.mark.asyncio
+async def test_idle_connections_evicted_and_keyed_by_credentials():
+    """Test that connections are pooled per credentials and closed once idle for longer than the idle timeout"""
+    simulator = SNMPSimulator()
+    host, port = await simulator.start("127.0.0.1", 0)
+    pool = SNMPConnectionPool(idle_timeout=60, max_idle=4)
+    try:
+        public = pool.sender(connection_key(host, port, "2c", "public"), host, port)
+        private = pool.sender(connection_key(host, port, "2c", "private"), host, port)
+        await public(None, _get(1), timeout=1, retries=1)
+        await private(None, _get(2), timeout=1, retries=1)
+        assert pool.describe() == {"idle": 2, "opened": 2, "reused": 0}
+
+        assert pool.evict_idle(now=time.monotonic() + 30) == 0
+        assert pool.evict_idle(now=time.monotonic() + 61) == 2
+        assert pool.describe()["idle"] == 0
+
+        await public(None, _get(3), timeout=1, retries=1)
+        assert pool.describe() == {"idle": 1, "opened": 3, "reused": 0}
+    finally:
+        pool.close()
+        simulator.close()
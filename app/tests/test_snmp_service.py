@@ -1,10 +1,25 @@
 import pytest
-from unittest.mock import patch, MagicMock, AsyncMock
+from unittest.mock import patch, ANY, MagicMock, AsyncMock
 import asyncio
+import socket
+from types import SimpleNamespace
 
-from app.services.snmp_service import SNMPService
-from app.services.mib_service import MIBService
-from app.models.query import SNMPQuery, SNMPTarget, SNMPOperation, SNMPCredentials
+from app.services.snmp_service import (
+    SNMPService, TIMEOUT_ERROR, COMMUNITIES_FAILED_ERROR, CONNECTION_REFUSED_ERROR, SNMP_AUTH_FAILED,
+    SNMP_SET_FAILED, SNMP_TIMEOUT, SNMP_UNREACHABLE, SNMP_ERROR, INVALID_QUERY, SERVICE_READ_ONLY,
+    SNMP_OPERATIONS, SNMP_OPERATION_DURATION, SNMP_ERRORS, WalkDeadlineExceeded, empty_reason,
+    used_fallback, auth_failure, fast_fail
+)
+from app.services.mib_service import MIBService, UnresolvedNameError
+from app.services.write_dedup import prior_write_age
+from app.models.query import SNMPQuery, SNMPTarget, SNMPOperation, SNMPCredentials, SNMPSetValue, MultiTargetResponse
+from app.utils.inet_address import decode_inet_address
+from app.utils.cache import get_cache, clear_cache
+from app.utils.metrics import registry
+from app.core.config import APIKeyPolicy, TargetCredentials
+from puresnmp import ObjectIdentifier
+from puresnmp.exc import ErrorResponse, SnmpError, Timeout, TooBig
+from x690.types import Integer, OctetString
 
 
 @pytest.mark.asyncio
@@ -43,3 +58,2101 @@ async def test_execute_query():
 
         # Verify that the method was called
         mock_get.assert_called_once()
+
+
+def _multi_query():
+    return SNMPQuery(
+        target=SNMPTarget(host="0.0.0.0", port=161),
+        credentials=SNMPCredentials(version="2c", community="public"),
+        operation=SNMPOperation(command="GET", oids=[".1.3.6.1.2.1.1.1.0"])
+    )
+
+
+def _execute_for_down_hosts(down_hosts):
+    """Build an execute_query replacement that fails for the given hosts"""
+    async def execute(query, api_key=None):
+        if query.target.host in down_hosts:
+            return {"error": "SNMP request timed out"}
+        return {"SNMPv2-MIB::sysDescr.0": f"Device {query.target.host}"}
+    return execute
+
+
+@pytest.mark.asyncio
+async def test_execute_multi_all_success():
+    """Test a multi-target query where every target succeeds"""
+    service = SNMPService(mib_service=MagicMock(spec=MIBService))
+
+    with patch.object(service, "execute_query", side_effect=_execute_for_down_hosts(set())):
+        results = await service.execute_multi(_multi_query(), ["10.0.0.1", "10.0.0.2"])
+
+    response = MultiTargetResponse.from_results("get sysDescr", results)
+    assert [r.status for r in results] == [200, 200]
+    assert results[1].raw_data == {"SNMPv2-MIB::sysDescr.0": "Device 10.0.0.2"}
+    assert response.succeeded == 2 and response.failed == 0
+    assert response.status_code == 200
+
+
+@pytest.mark.asyncio
+async def test_execute_multi_all_fail():
+    """Test a multi-target query where every target fails"""
+    service = SNMPService(mib_service=MagicMock(spec=MIBService))
+
+    down = {"10.0.0.1", "10.0.0.2"}
+    with patch.object(service, "execute_query", side_effect=_execute_for_down_hosts(down)):
+        results = await service.execute_multi(_multi_query(), ["10.0.0.1", "10.0.0.2"])
+
+    response = MultiTargetResponse.from_results("get sysDescr", results)
+    assert [r.status for r in results] == [502, 502]
+    assert all(r.error == "SNMP request timed out" for r in results)
+    assert response.status_code == 502
+
+
+@pytest.mark.asyncio
+async def test_execute_multi_mixed():
+    """Test a multi-target query with partial failure returns 207"""
+    service = SNMPService(mib_service=MagicMock(spec=MIBService))
+
+    with patch.object(service, "execute_query", side_effect=_execute_for_down_hosts({"10.0.0.2"})):
+        results = await service.execute_multi(_multi_query(), ["10.0.0.1", "10.0.0.2", "10.0.0.3"])
+
+    response = MultiTargetResponse.from_results("get sysDescr", results)
+    assert [(r.host, r.status) for r in results] == [("10.0.0.1", 200), ("10.0.0.2", 502), ("10.0.0.3", 200)]
+    assert results[1].error == "SNMP request timed out"
+    assert response.succeeded == 2 and response.failed == 1
+    assert response.status_code == 207
+
+
+@pytest.mark.asyncio
+async def test_walk_decodes_inet_address_pairs():
+    """Test that InetAddress columns are decoded using the sibling InetAddressType"""
+    next_hop_type = "1.3.6.1.2.1.4.24.7.1.5"
+    next_hop = "1.3.6.1.2.1.4.24.7.1.6"
+    ipv4_row = "1.4.10.0.0.0.8.3.0.0.1"
+    ipv6_row = "2.16.32.1.13.184.0.0.0.0.0.0.0.0.0.0.0.0.64.3.0.0.2"
+
+    walked = [
+        (f"{next_hop_type}.{ipv4_row}", 1),
+        (f"{next_hop_type}.{ipv6_row}", 2),
+        (f"{next_hop}.{ipv4_row}", bytes([192, 168, 1, 254])),
+        (f"{next_hop}.{ipv6_row}", bytes.fromhex("fe800000000000000000000000000001")),
+    ]
+
+    async def walk(oid):
+        for varbind in walked:
+            yield varbind
+
+    client = MagicMock()
+    client.walk = walk
+
+    service = SNMPService(mib_service=MIBService())
+    result = await service._execute_walk(client, ["1.3.6.1.2.1.4.24.7"])
+
+    assert result[f"{next_hop}.{ipv4_row}"] == "192.168.1.254"
+    assert result[f"{next_hop}.{ipv6_row}"] == "fe80::1"
+    assert result[f"{next_hop_type}.{ipv4_row}"] == 1
+
+
+def test_decode_inet_address():
+    """Test decoding InetAddress values by type"""
+    assert decode_inet_address(1, bytes([10, 0, 0, 1])) == "10.0.0.1"
+    assert decode_inet_address(2, bytes.fromhex("20010db8000000000000000000000001")) == "2001:db8::1"
+    assert decode_inet_address(3, bytes([10, 0, 0, 1, 0, 0, 0, 2])) == "10.0.0.1%2"
+    assert decode_inet_address(16, b"router.example.com") == "router.example.com"
+
+    # Length that doesn't match the type, and unknown types, aren't decoded
+    assert decode_inet_address(1, bytes([10, 0, 0])) is None
+    assert decode_inet_address(0, b"") is None
+
+
+def test_describe_request_masks_community():
+    """Test that the request debug info is populated and the community is masked"""
+    service = SNMPService(mib_service=MIBService())
+
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1", port=1161),
+        credentials=SNMPCredentials(version="2c", community="s3cret"),
+        operation=SNMPOperation(
+            command="bulk",
+            oids=["SNMPv2-MIB::sysDescr.0", "NO-SUCH-MIB::thing", "1.3.6.1.2.1.2.2"]
+        )
+    )
+
+    request = service.describe_request(query)
+
+    assert request["host"] == "192.168.1.1"
+    assert request["port"] == 1161
+    assert request["version"] == "2c"
+    assert request["operation"] == "BULK"
+    assert request["oids"] == ["1.3.6.1.2.1.1.1.0", "1.3.6.1.2.1.2.2"]
+    assert request["unresolved_oids"] == ["NO-SUCH-MIB::thing"]
+    assert request["max_repetitions"] == 10
+    assert request["community"] == "****"
+    assert "s3cret" not in str(request)
+
+
+def test_oid_forms_prepare_to_one_oid():
+    """Test that leading-dot, no-dot and iso-prefixed OIDs are sent as one canonical OID and validate"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(
+            command="GET", oids=["1.3.6.1.2.1.1.5.0", ".1.3.6.1.2.1.1.5.0", "iso.3.6.1.2.1.1.5.0",
+                                 "iso.org.dod.internet.mgmt.mib-2.system.1.0"]
+        )
+    )
+
+    assert service.describe_request(query)["oids"] == ["1.3.6.1.2.1.1.5.0", "1.3.6.1.2.1.1.1.0"]
+    assert service.validate_query(query) is None
+
+
+def test_describe_request_masks_v3_passwords():
+    """Test that SNMPv3 passwords are masked in the request debug info"""
+    service = SNMPService(mib_service=MIBService())
+
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        credentials=SNMPCredentials(
+            version="3", username="monitor",
+            auth_protocol="SHA", auth_password="authpass",
+            priv_protocol="AES", priv_password="privpass"
+        ),
+        operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"])
+    )
+
+    request = service.describe_request(query)
+
+    assert request["security"]["username"] == "monitor"
+    assert request["security"]["auth_protocol"] == "SHA"
+    assert request["security"]["auth_password"] == "****"
+    assert request["security"]["priv_password"] == "****"
+    assert "authpass" not in str(request) and "privpass" not in str(request)
+
+
+@pytest.mark.asyncio
+async def test_max_oid_count_boundary():
+    """Test that the OID limit allows exactly the maximum and rejects one more"""
+    max_oids = {"GET": 3, "WALK": 1}
+
+    with patch("app.services.snmp_service.config.snmp.max_oids", max_oids), \
+            patch.object(SNMPService, "_execute_get", new_callable=AsyncMock) as mock_get:
+        mock_get.return_value = {"ok": True}
+        service = SNMPService(mib_service=MIBService())
+
+        def query(command, count):
+            return SNMPQuery(
+                target=SNMPTarget(host="192.168.1.1"),
+                operation=SNMPOperation(command=command, oids=[f"1.3.6.1.2.1.1.{i}.0" for i in range(1, count + 1)])
+            )
+
+        # At the limit
+        assert service.validate_query(query("GET", 3)) is None
+        assert await service.execute_query(query("GET", 3)) == {"ok": True}
+
+        # Over the limit, rejected before execution
+        result = await service.execute_query(query("GET", 4))
+        assert result["error"] == "Too many OIDs for GET: 4 requested, the maximum is 3"
+        mock_get.assert_called_once()
+
+        # Limits are per command
+        assert service.validate_query(query("WALK", 1)) is None
+        assert "maximum is 1" in service.validate_query(query("walk", 2))
+
+        # Commands without a configured limit are not restricted
+        assert service.validate_query(query("GETNEXT", 10)) is None
+
+
+def _oid_query(command, *oids):
+    return SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command=command, oids=list(oids))
+    )
+
+
+def test_validate_query_accepts_known_oids():
+    """Test that OIDs from loaded MIBs, standard subtrees and known vendors pass validation"""
+    service = SNMPService(mib_service=MIBService())
+
+    for oid in ["SNMPv2-MIB::sysName.0", "IF-MIB::ifDescr", "1.3.6.1.2.1.2.2", "1.3.6.1.2.1.25.1.2.0",
+                "1.3.6.1.4.1.9.9.109.1.1.1.1.5", ".1.3.6.1.4.1.2636.3.1.13", "ifspeed"]:
+        assert service.validate_query(_oid_query("GET", oid)) is None, oid
+
+    # Configured patterns, for every command or only the one they are listed under
+    known_oids = {"*": ["1.3.6.1.4.1.99.*.7"], "WALK": ["1.3.6.1.4.1.12345"]}
+    with patch("app.services.snmp_service.config.snmp.known_oids", known_oids):
+        assert service.validate_query(_oid_query("GET", "1.3.6.1.4.1.99.3.7.1")) is None
+        assert service.validate_query(_oid_query("WALK", "1.3.6.1.4.1.12345.1")) is None
+        assert "1.3.6.1.4.1.12345.1" in service.validate_query(_oid_query("GET", "1.3.6.1.4.1.12345.1"))
+        assert "1.3.6.1.4.1.99.3.8" in service.validate_query(_oid_query("GET", "1.3.6.1.4.1.99.3.8"))
+
+
+@pytest.mark.asyncio
+async def test_validate_query_rejects_hallucinated_oids():
+    """Test that OIDs under unknown enterprises or outside known subtrees are rejected, naming the OID"""
+    service = SNMPService(mib_service=MIBService())
+
+    error = service.validate_query(_oid_query("GET", "1.3.6.1.2.1.1.5.0", "1.3.6.1.4.1.48213.2.1.0"))
+    assert error.startswith("Unknown OID 1.3.6.1.4.1.48213.2.1.0: enterprise 48213 is not a known vendor")
+
+    error = service.validate_query(_oid_query("WALK", "1.3.6.1.3.94.1"))
+    assert error == ("Unknown OID 1.3.6.1.3.94.1: no loaded MIB defines it and it matches no known OID "
+                     "pattern for WALK")
+
+    # Rejected before anything is sent
+    with patch("app.services.snmp_service.Client") as mock_client:
+        result = await service.execute_query(_oid_query("GET", "1.3.6.1.4.1.48213.2.1.0"))
+    assert "1.3.6.1.4.1.48213.2.1.0" in result["error"]
+    mock_client.assert_not_called()
+
+    with patch("app.services.snmp_service.config.snmp.validate_oids", False):
+        assert service.validate_query(_oid_query("GET", "1.3.6.1.4.1.48213.2.1.0")) is None
+
+
+@pytest.mark.parametrize("oid, reason", [
+    ("1.3.6.1.2..1.1", "not a dotted list of numbers"),
+    ("1.3.6.1.2.1.1.-5", "not a dotted list of numbers"),
+    ("ifFooBar.1", "does not resolve"),
+    ("1", "at least two sub-identifiers"),
+    ("3.6.1.2.1", "first sub-identifier"),
+    ("1.40.6.1", "second sub-identifier"),
+    ("1.3.6.1.2.1.4294967296", "exceeds 2^32-1"),
+])
+def test_validate_query_rejects_malformed_oids(oid, reason):
+    """Test that malformed OIDs are rejected with the OID and what is wrong with it"""
+    service = SNMPService(mib_service=MIBService())
+
+    error = service.validate_query(_oid_query("GET", oid))
+    assert error.startswith(f"Malformed OID {oid}: ")
+    assert reason in error
+
+
+@pytest.mark.parametrize("limit,arcs,allowed", [
+    (128, 128, True),
+    (128, 129, False),
+    (128, 1000, False),
+    (20, 20, True),
+    (20, 21, False),
+    # The limit can be lowered, but not raised above SNMP's own
+    (500, 129, False),
+])
+def test_validate_query_oid_length_limit(limit, arcs, allowed):
+    """Test that OIDs longer than SNMP_MAX_OID_ARCS are rejected, even with OID validation off"""
+    service = SNMPService(mib_service=MIBService())
+    oid = "1.3.6.1.4.1.9" + ".1" * (arcs - 7)
+
+    with patch("app.services.snmp_service.config.snmp.max_oid_arcs", limit), \
+            patch("app.services.snmp_service.config.snmp.validate_oids", False):
+        error = service.validate_query(_oid_query("GET", oid))
+
+    if allowed:
+        assert error is None
+    else:
+        assert error.startswith("OID 1.3.6.1.4.1.9.1.1.1.1.1... ")
+        assert f"it has {arcs} sub-identifiers, more than the maximum of {min(limit, 128)}" in error
+
+
+def test_enrich_results_includes_mib():
+    """Test that enriched results report the MIB that provided each OID"""
+    service = SNMPService(mib_service=MIBService())
+
+    results = service.enrich_results({
+        "SNMPv2-MIB::sysDescr.0": "Linux router",
+        "1.3.6.1.2.1.2.2.1.8.2": 1,
+        "1.3.6.1.4.1.9.2.1.3.0": "unknown",
+    })
+
+    assert results[0].oid == "1.3.6.1.2.1.1.1.0"
+    assert results[0].mib == "SNMPv2-MIB"
+    assert results[1].name == "IF-MIB::ifOperStatus.2"
+    assert results[1].mib == "IF-MIB"
+    assert results[2].oid == "1.3.6.1.4.1.9.2.1.3.0"
+    assert results[2].name is None
+    assert results[2].mib is None
+
+
+def test_enrich_results_describes_walked_rows():
+    """Test that walked table cells get their column's description and the row's index values"""
+    mib_service = MIBService()
+    mib_service.load_mib("""
+    IF-MIB DEFINITIONS ::= BEGIN
+    IMPORTS OBJECT-TYPE, mib-2 FROM SNMPv2-SMI;
+    ifEntry OBJECT-TYPE
+        SYNTAX      IfEntry
+        MAX-ACCESS  not-accessible
+        STATUS      current
+        DESCRIPTION "An interface entry."
+        INDEX       { ifIndex }
+        ::= { mib-2 2 2 1 }
+    ifIndex OBJECT-TYPE
+        SYNTAX      InterfaceIndex
+        MAX-ACCESS  read-only
+        STATUS      current
+        DESCRIPTION "A unique value for each interface."
+        ::= { ifEntry 1 }
+    ifDescr OBJECT-TYPE
+        SYNTAX      DisplayString (SIZE (0..255))
+        MAX-ACCESS  read-only
+        STATUS      current
+        DESCRIPTION "A textual string containing information about the interface."
+        ::= { ifEntry 2 }
+    END
+    """)
+    service = SNMPService(mib_service=mib_service)
+
+    results = service.enrich_results({"1.3.6.1.2.1.2.2.1.2.5": "eth4", "1.3.6.1.2.1.2.2.1.2.12": "eth11"})
+
+    assert [(result.name, result.index) for result in results] == [
+        ("IF-MIB::ifDescr.5", {"ifIndex": 5}), ("IF-MIB::ifDescr.12", {"ifIndex": 12}),
+    ]
+    assert all(result.description.startswith("A textual string") for result in results)
+
+
+@pytest.mark.parametrize("oid,enterprise_number,vendor", [
+    ("1.3.6.1.4.1.9.9.109.1.1.1.1.5.1", 9, "Cisco Systems"),
+    (".1.3.6.1.4.1.2636.3.1.13.1.8.9.1.0.0", 2636, "Juniper Networks"),
+    ("1.3.6.1.4.1.30065.3.1.1.0", 30065, "Arista Networks"),
+    ("1.3.6.1.4.1.14988.1.1.3.10.0", 14988, "MikroTik"),
+    ("1.3.6.1.4.1.8072.1.3.2.3.1.2.4.116.101.115.116", 8072, "Net-SNMP"),
+    ("1.3.6.1.4.1.48213.2.1.0", 48213, None),
+])
+def test_enrich_results_names_enterprise_of_unknown_oids(oid, enterprise_number, vendor):
+    """Test that enterprise OIDs no MIB defines are attributed to their enterprise number and vendor"""
+    service = SNMPService(mib_service=MIBService())
+
+    result = service.enrich_results({oid: 42})[0]
+
+    assert result.name is None
+    assert result.mib is None
+    assert result.enterprise_number == enterprise_number
+    assert result.vendor == vendor
+
+
+def test_enrich_results_no_enterprise_for_known_or_standard_oids():
+    """Test that only enterprise OIDs without MIB information get an enterprise"""
+    service = SNMPService(mib_service=MIBService())
+
+    results = service.enrich_results({"SNMPv2-MIB::sysName.0": "router1", "1.3.6.1.2.1.25.1.1.0": 100})
+
+    assert [(result.enterprise_number, result.vendor) for result in results] == [(None, None), (None, None)]
+
+
+def test_enrich_results_warns_when_mib_info_missing():
+    """Test that results without MIB information carry warnings, collected for the response"""
+    service = SNMPService(mib_service=MIBService())
+
+    results = service.enrich_results({
+        "SNMPv2-MIB::sysDescr.0": "Linux router",
+        "1.3.6.1.4.1.9.2.1.3.0": "unknown",
+        "FOO-MIB::fooBar.0": 1,
+    })
+
+    assert results[0].warnings == []
+    assert results[1].warnings == ["No MIB information for OID 1.3.6.1.4.1.9.2.1.3.0"]
+    assert results[2].warnings == ["Name FOO-MIB::fooBar.0 does not resolve to an OID"]
+    assert service.collect_warnings(results) == [
+        "No MIB information for OID 1.3.6.1.4.1.9.2.1.3.0",
+        "Name FOO-MIB::fooBar.0 does not resolve to an OID",
+    ]
+
+
+def _walk_query(deadline=None):
+    return SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="WALK", oids=["1.3.6.1.2.1.2.2"], deadline=deadline)
+    )
+
+
+@pytest.mark.asyncio
+async def test_walk_overall_deadline_exceeded():
+    """Test that a slow walk fails with the overall deadline error"""
+    async def slow_walk(oid):
+        for index in range(1, 100):
+            await asyncio.sleep(0.3)
+            yield f"1.3.6.1.2.1.2.2.1.1.{index}", index
+
+    service = SNMPService(mib_service=MIBService())
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.walk = slow_walk
+        result = await service.execute_query(_walk_query(deadline=1))
+
+    assert "Overall walk deadline of 1s exceeded" in result["error"]
+
+
+@pytest.mark.asyncio
+async def test_walk_per_request_timeout():
+    """Test that a per-request timeout is reported distinctly from the walk deadline"""
+    async def unresponsive_walk(oid):
+        raise Timeout("No response")
+        yield
+
+    service = SNMPService(mib_service=MIBService())
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.walk = unresponsive_walk
+        result = await service.execute_query(_walk_query(deadline=30))
+
+    assert "timed out" in result["error"]
+    assert "deadline" not in result["error"]
+
+
+IF_DESCR = "1.3.6.1.2.1.2.2.1.2"
+IF_IN_OCTETS = "1.3.6.1.2.1.2.2.1.10"
+
+
+def _interface_table_agent(rows, slow_column=None):
+    """Fake agent serving ifDescr and ifInOctets, optionally too slow to finish one column"""
+    view = [f"{column}.{row}" for column in (IF_DESCR, IF_IN_OCTETS) for row in range(1, rows + 1)]
+    calls = {"walk": [], "getnext": []}
+
+    async def walk(oid):
+        calls["walk"].append(str(oid))
+        for walked_oid in view:
+            if walked_oid.startswith(f"{oid}."):
+                if str(oid) == slow_column:
+                    await asyncio.sleep(0.3)
+                yield walked_oid, f"value {walked_oid}"
+
+    async def getnext(oid):
+        calls["getnext"].append(str(oid))
+        position = view.index(str(oid)) + 1
+        next_oid = view[position] if position < len(view) else "1.3.6.1.2.1.2.2.1.22.1"
+        return next_oid, f"value {next_oid}"
+
+    return walk, getnext, calls
+
+
+@pytest.mark.asyncio
+async def test_getnext_returns_the_next_oid():
+    """Test that GETNEXT results carry the OID the agent answered with, to step through a table from"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="GETNEXT", oids=[IF_DESCR, f"{IF_DESCR}.3", f".{IF_IN_OCTETS}.1"])
+    )
+
+    walk, getnext, calls = _interface_table_agent(rows=3)
+
+    async def getnext_from_column(oid):
+        # The next OID after a column is its first cell
+        return (f"{IF_DESCR}.1", f"value {IF_DESCR}.1") if str(oid) == IF_DESCR else await getnext(oid)
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.getnext = getnext_from_column
+        result = await service.execute_query(query)
+
+    assert result == {
+        "IF-MIB::ifDescr.1": "value 1.3.6.1.2.1.2.2.1.2.1",
+        "IF-MIB::ifInOctets.1": "value 1.3.6.1.2.1.2.2.1.10.1",
+        "IF-MIB::ifInOctets.2": "value 1.3.6.1.2.1.2.2.1.10.2",
+    }
+    assert [result.oid for result in service.enrich_results(result)] == [
+        f"{IF_DESCR}.1", f"{IF_IN_OCTETS}.1", f"{IF_IN_OCTETS}.2",
+    ]
+
+
+@pytest.mark.asyncio
+async def test_walk_resumes_from_checkpoint():
+    """Test that a walk cut off by its deadline is resumed from its checkpoint instead of restarted"""
+    clear_cache()
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="WALK", oids=[IF_DESCR, IF_IN_OCTETS], deadline=1)
+    )
+    checkpoint = service._walk_checkpoint_key(query)
+
+    with patch("app.services.snmp_service.Client") as mock_client, \
+            patch("app.services.snmp_service.config.snmp.walk_checkpoints", True):
+        walk, getnext, calls = _interface_table_agent(rows=10, slow_column=IF_IN_OCTETS)
+        mock_client.return_value.walk = walk
+        result = await service.execute_query(query)
+
+        assert "Overall walk deadline of 1s exceeded" in result["error"]
+        partial = get_cache(f"{checkpoint}_{IF_IN_OCTETS}")
+        assert partial["complete"] is False
+        assert 0 < partial["walked"] < 10
+        assert partial["last_oid"] == f"{IF_IN_OCTETS}.{partial['walked']}"
+        assert get_cache(f"{checkpoint}_{IF_DESCR}")["complete"] is True
+
+        # The repeated query walks nothing it already has
+        walk, getnext, calls = _interface_table_agent(rows=10)
+        mock_client.return_value.walk = walk
+        mock_client.return_value.getnext = getnext
+        result = await service.execute_query(query)
+
+    assert "error" not in result
+    assert len(result) == 20
+    assert calls["walk"] == []
+    assert calls["getnext"][0] == partial["last_oid"]
+    assert len(calls["getnext"]) == 10 - partial["walked"] + 1
+    assert get_cache(f"{checkpoint}_{IF_IN_OCTETS}") is None
+    assert get_cache(f"{checkpoint}_{IF_DESCR}") is None
+    clear_cache()
+
+
+@pytest.mark.asyncio
+async def test_walk_checkpoints_off_by_default():
+    """Test that without checkpoints an interrupted walk leaves nothing to resume from"""
+    clear_cache()
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="WALK", oids=[IF_IN_OCTETS], deadline=1)
+    )
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        walk, _, calls = _interface_table_agent(rows=10, slow_column=IF_IN_OCTETS)
+        mock_client.return_value.walk = walk
+        assert "error" in await service.execute_query(query)
+
+        walk, _, calls = _interface_table_agent(rows=10)
+        mock_client.return_value.walk = walk
+        result = await service.execute_query(query)
+
+    assert len(result) == 10
+    assert calls["walk"] == [IF_IN_OCTETS]
+    assert get_cache(f"{service._walk_checkpoint_key(query)}_{IF_IN_OCTETS}") is None
+    clear_cache()
+
+
+NETOPS_KEY = APIKeyPolicy(name="netops", oid_prefixes=["1.3.6.1.2.1.2"])
+SYSTEM_KEY = APIKeyPolicy(name="system", oid_prefixes=[".1.3.6.1.2.1.1"])
+
+
+@pytest.mark.parametrize("api_key,oids,allowed", [
+    (NETOPS_KEY, ["1.3.6.1.2.1.2.2.1.2.1"], True),
+    (NETOPS_KEY, ["1.3.6.1.2.1.2"], True),
+    (NETOPS_KEY, ["1.3.6.1.2.1.1.1.0"], False),
+    (NETOPS_KEY, ["1.3.6.1.2.1.25"], False),
+    (SYSTEM_KEY, ["1.3.6.1.2.1.1.1.0"], True),
+    (SYSTEM_KEY, ["1.3.6.1.2.1.2.2.1.2.1"], False),
+    (APIKeyPolicy(name="admin"), ["1.3.6.1.4.1.9.1"], True),
+])
+def test_validate_query_api_key_oid_prefixes(api_key, oids, allowed):
+    """Test that each API key may only query OIDs within its allowed subtrees"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="GET", oids=oids)
+    )
+
+    error = service.validate_query(query, oids, api_key=api_key)
+
+    if allowed:
+        assert error is None
+    else:
+        assert error == f"API key '{api_key.name}' is not authorized for OID {oids[0]}"
+
+
+def test_only_oid_errors_correctable():
+    """Test that unknown OIDs are the model's to correct, but OIDs the API key or SET policy refuse are not"""
+    service = SNMPService(mib_service=MIBService())
+    unknown = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="GET", oids=["1.3.6.1.4.1.99999999.1"])
+    )
+    refused = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"])
+    )
+
+    with patch("app.services.snmp_service.config.snmp.validate_oids", True), \
+            patch("app.services.snmp_service.config.snmp.allow_set", False):
+        assert service.correctable_error(unknown).startswith("Unknown OID 1.3.6.1.4.1.99999999.1")
+        assert service.validate_query(refused, api_key=NETOPS_KEY) is not None
+        assert service.correctable_error(refused) is None
+        set_query = _set_query({"oid": "SNMPv2-MIB::sysLocation.0", "value": "rack 4"})
+        assert service.validate_query(set_query).startswith("SNMP SET is disabled")
+        assert service.correctable_error(set_query) is None
+
+
+@pytest.mark.asyncio
+async def test_execute_query_denied_oid_not_sent():
+    """Test that a query for an unauthorized OID fails without contacting the device"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.2.2.1.2.1", "1.3.6.1.2.1.1.5.0"])
+    )
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        result = await service.execute_query(query, api_key=NETOPS_KEY)
+
+    assert result["error"] == "API key 'netops' is not authorized for OID 1.3.6.1.2.1.1.5.0"
+    mock_client.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_walk_subtrees_one_fails():
+    """Test that a failing subtree is annotated while the other subtrees are returned"""
+    async def walk(oid):
+        if str(oid) == "1.3.6.1.2.1.25":
+            raise Timeout("No response")
+        for index in (1, 2):
+            await asyncio.sleep(0.01)
+            yield f"{oid}.{index}", index
+
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="WALK", oids=["1.3.6.1.2.1.1", "1.3.6.1.2.1.25", "1.3.6.1.2.1.2"])
+    )
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.walk = walk
+        result = await service.execute_query(query)
+
+    assert "error" not in result
+    assert result["1.3.6.1.2.1.25_error"] == "Error: No response"
+    for subtree in ("1.3.6.1.2.1.1", "1.3.6.1.2.1.2"):
+        assert result[f"{subtree}.1"] == 1
+        assert result[f"{subtree}.2"] == 2
+
+
+@pytest.mark.asyncio
+async def test_walk_subtrees_concurrent_within_limit():
+    """Test that subtrees are walked concurrently, bounded by the per-target connection limit"""
+    active = 0
+    peak = 0
+
+    async def walk(oid):
+        nonlocal active, peak
+        active += 1
+        peak = max(peak, active)
+        await asyncio.sleep(0.05)
+        active -= 1
+        yield f"{oid}.0", 1
+
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="WALK", oids=[f"1.3.6.1.2.1.{n}" for n in range(1, 6)])
+    )
+
+    with patch("app.services.snmp_service.Client") as mock_client, \
+            patch("app.services.snmp_service.config.snmp.max_connections_per_target", 2):
+        mock_client.return_value.walk = walk
+        result = await service.execute_query(query)
+
+    assert len(result) == 5
+    assert peak == 2
+
+
+class SmallPDUAgent:
+    """Fixture agent serving a wide table that answers tooBig above a varbind limit"""
+
+    def __init__(self, columns, rows, pdu_limit):
+        self.pdu_limit = pdu_limit
+        self.requests = []
+        self.view = sorted(
+            (f"1.3.6.1.4.1.8072.9999.1.1.{column}.{row}" for column in range(1, columns + 1) for row in range(1, rows + 1)),
+            key=lambda oid: tuple(int(part) for part in oid.split("."))
+        )
+        self.view.append("1.3.6.1.4.1.8072.9999.2.0")
+
+    def _next(self, oid):
+        key = tuple(int(part) for part in str(oid).split("."))
+        for candidate in self.view:
+            if tuple(int(part) for part in candidate.split(".")) > key:
+                return candidate
+        return None
+
+    async def bulkget(self, scalar_oids, repeating_oids, max_list_size=1):
+        self.requests.append((len(repeating_oids), max_list_size))
+        if len(scalar_oids) + len(repeating_oids) * max_list_size > self.pdu_limit:
+            raise TooBig()
+
+        listing = {}
+        cursors = [str(oid) for oid in repeating_oids]
+        for _ in range(max_list_size):
+            for index, cursor in enumerate(cursors):
+                next_oid = self._next(cursor)
+                if next_oid is None:
+                    continue
+                listing[ObjectIdentifier(next_oid)] = f"value {next_oid}"
+                cursors[index] = next_oid
+        return MagicMock(scalars={}, listing=listing)
+
+
+def _bulk_table_query(columns):
+    return SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(
+            command="BULK",
+            oids=[f"1.3.6.1.4.1.8072.9999.1.1.{column}" for column in range(1, columns + 1)],
+            max_repetitions=5
+        )
+    )
+
+
+@pytest.mark.asyncio
+@pytest.mark.parametrize("max_pdu_varbinds", [12, 40])
+async def test_bulk_many_columns_chunked_under_pdu_limit(max_pdu_varbinds):
+    """Test that a wide table is bulk-walked in chunks, splitting further on tooBig"""
+    agent = SmallPDUAgent(columns=16, rows=7, pdu_limit=12)
+    service = SNMPService(mib_service=MIBService())
+
+    with patch("app.services.snmp_service.Client") as mock_client, \
+            patch("app.services.snmp_service.config.snmp.max_pdu_varbinds", max_pdu_varbinds):
+        mock_client.return_value.bulkget = agent.bulkget
+        result = await service.execute_query(_bulk_table_query(columns=16))
+
+    assert "error" not in result
+    assert len(result) == 16 * 7
+    assert result["1.3.6.1.4.1.8072.9999.1.1.16.7"] == "value 1.3.6.1.4.1.8072.9999.1.1.16.7"
+    assert "1.3.6.1.4.1.8072.9999.2.0" not in result
+
+    answered = [(columns, rows) for columns, rows in agent.requests if columns * rows <= agent.pdu_limit]
+    assert all(columns * rows <= max_pdu_varbinds for columns, rows in answered)
+    if max_pdu_varbinds <= agent.pdu_limit:
+        assert answered == agent.requests
+
+
+@pytest.mark.asyncio
+async def test_bulk_streams_rows_per_response():
+    """Test that the rows of each bulk response are passed on as it arrives, covering the whole table once"""
+    agent = SmallPDUAgent(columns=4, rows=7, pdu_limit=12)
+    service = SNMPService(mib_service=MIBService())
+    streamed = []
+
+    with patch("app.services.snmp_service.Client") as mock_client, \
+            patch("app.services.snmp_service.config.snmp.max_pdu_varbinds", 12):
+        mock_client.return_value.bulkget = agent.bulkget
+        result = await service.execute_query(_bulk_table_query(columns=4), on_rows=streamed.append)
+
+    assert len(streamed) > 1
+    assert all(0 < len(rows) <= 12 for rows in streamed)
+    assert sum(len(rows) for rows in streamed) == len(result) == 4 * 7
+    assert {name: value for rows in streamed for name, value in rows.items()} == result
+
+
+@pytest.mark.asyncio
+async def test_bulk_too_big_single_column_single_row():
+    """Test that tooBig is reported once a request cannot be made any smaller"""
+    agent = SmallPDUAgent(columns=2, rows=3, pdu_limit=0)
+    service = SNMPService(mib_service=MIBService())
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.bulkget = agent.bulkget
+        result = await service.execute_query(_bulk_table_query(columns=2))
+
+    assert "error" in result
+    assert agent.requests[-1] == (1, 1)
+
+
+class SlowColumnsAgent(SmallPDUAgent):
+    """Fixture agent whose later columns answer first, each request taking a while"""
+
+    def __init__(self, columns, rows, delay):
+        super().__init__(columns, rows, pdu_limit=columns * rows)
+        self.columns = columns
+        self.delay = delay
+        self.in_flight = 0
+        self.peak = 0
+
+    async def bulkget(self, scalar_oids, repeating_oids, max_list_size=1):
+        column = int(str(repeating_oids[0]).split(".")[10])
+        self.in_flight += 1
+        self.peak = max(self.peak, self.in_flight)
+        try:
+            await asyncio.sleep(self.delay * (1 + (self.columns - column) / self.columns))
+            return await super().bulkget(scalar_oids, repeating_oids, max_list_size)
+        finally:
+            self.in_flight -= 1
+
+
+async def _walk_slow_columns(agent, concurrency, max_connections=4):
+    service = SNMPService(mib_service=MIBService())
+    with patch("app.services.snmp_service.Client") as mock_client, \
+            patch("app.services.snmp_service.config.snmp.max_pdu_varbinds", 5), \
+            patch("app.services.snmp_service.config.snmp.column_walk_concurrency", concurrency), \
+            patch("app.services.snmp_service.config.snmp.max_connections_per_target", max_connections):
+        mock_client.return_value.bulkget = agent.bulkget
+        started = asyncio.get_event_loop().time()
+        result = await service.execute_query(_bulk_table_query(columns=agent.columns))
+        return result, asyncio.get_event_loop().time() - started
+
+
+@pytest.mark.asyncio
+async def test_bulk_columns_out_of_order_build_ordered_table():
+    """Test that concurrent column chunks answering out of order still give every row, in OID order"""
+    agent = SlowColumnsAgent(columns=6, rows=7, delay=0.01)
+
+    result, _ = await _walk_slow_columns(agent, concurrency=8, max_connections=3)
+
+    assert "error" not in result
+    assert list(result) == agent.view[:-1]
+    assert all(value == f"value {oid}" for oid, value in result.items())
+    # Bounded by the per-target connection cap, below the configured concurrency
+    assert agent.peak == 3
+
+
+@pytest.mark.asyncio
+async def test_bulk_column_concurrency_benchmark():
+    """Benchmark a wide table walked one column chunk at a time against concurrently"""
+    sequential_agent = SlowColumnsAgent(columns=8, rows=10, delay=0.02)
+    concurrent_agent = SlowColumnsAgent(columns=8, rows=10, delay=0.02)
+
+    sequential, sequential_seconds = await _walk_slow_columns(sequential_agent, concurrency=1)
+    concurrent, concurrent_seconds = await _walk_slow_columns(concurrent_agent, concurrency=4)
+
+    print(f"\n8 columns x 10 rows: sequential {sequential_seconds:.2f}s, concurrent {concurrent_seconds:.2f}s")
+    assert sequential == concurrent
+    assert len(concurrent) == 8 * 10
+    assert (sequential_agent.peak, concurrent_agent.peak) == (1, 4)
+    assert concurrent_seconds < sequential_seconds / 2
+
+
+@pytest.mark.asyncio
+async def test_bulk_falls_back_to_walk():
+    """Test that a BULK the agent doesn't support is retried as a WALK and flagged as a fallback"""
+    service = SNMPService(mib_service=MIBService())
+
+    async def walk(oid):
+        for row in range(1, 4):
+            yield f"{oid}.{row}", f"value {row}"
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.bulkget = AsyncMock(side_effect=SnmpError("genErr: GetBulk not supported"))
+        mock_client.return_value.walk = walk
+        result = await service.execute_query(_bulk_table_query(columns=2))
+
+    assert "error" not in result
+    assert len(result) == 2 * 3
+    assert result["1.3.6.1.4.1.8072.9999.1.1.2.3"] == "value 3"
+    assert used_fallback(result) == "WALK"
+    assert any("GETBULK" in warning for warning in service.collect_warnings([], result))
+
+
+@pytest.mark.asyncio
+async def test_bulk_timeout_does_not_fall_back():
+    """Test that a BULK timeout isn't retried as a WALK"""
+    service = SNMPService(mib_service=MIBService())
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.bulkget = AsyncMock(side_effect=Timeout("No response"))
+        mock_client.return_value.walk = MagicMock()
+        result = await service.execute_query(_bulk_table_query(columns=2))
+
+    assert "error" in result
+    mock_client.return_value.walk.assert_not_called()
+    assert used_fallback(result) is None
+
+
+def _flaky_targets(failures_per_host):
+    """Build an execute_query replacement that times out a number of times per host, counting attempts"""
+    attempts = {}
+
+    async def execute(query, api_key=None):
+        host = query.target.host
+        attempts[host] = attempts.get(host, 0) + 1
+        if attempts[host] <= failures_per_host:
+            return {"error": TIMEOUT_ERROR}
+        return {"SNMPv2-MIB::sysDescr.0": f"Device {host}"}
+
+    return execute, attempts
+
+
+@pytest.mark.asyncio
+async def test_execute_multi_retry_budget_caps_attempts():
+    """Test that the retry budget bounds total attempts when many targets are flaky"""
+    service = SNMPService(mib_service=MagicMock(spec=MIBService))
+    hosts = [f"10.0.0.{n}" for n in range(1, 21)]
+    execute, attempts = _flaky_targets(failures_per_host=2)
+
+    with patch.object(service, "execute_query", side_effect=execute):
+        results = await service.execute_multi(_multi_query(), hosts, retry_budget=5)
+
+    assert sum(attempts.values()) == len(hosts) + 5
+    assert all(count <= 3 for count in attempts.values())
+    failed = [r for r in results if r.status == 502]
+    assert failed
+    assert all(r.error == f"{TIMEOUT_ERROR} (retry budget exhausted)" for r in failed)
+
+
+class _SendCountingClient:
+    """Client that never gets an answer, sending like puresnmp: config.retries is the number of sends"""
+    sends = 0
+
+    def __init__(self, ip, credentials, port=161, **kwargs):
+        self.config = SimpleNamespace(timeout=6, retries=10)
+
+    async def get(self, oid):
+        for _ in range(self.config.retries):
+            _SendCountingClient.sends += 1
+        raise Timeout("no response")
+
+
+@pytest.mark.asyncio
+@pytest.mark.parametrize("retries,sends", [(0, 1), (2, 3)])
+async def test_execute_query_retries_count_sends(retries, sends):
+    """Test that the target's retries reach the client, so zero retries means exactly one attempt"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="10.2.0.1", retries=retries),
+        operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"])
+    )
+    _SendCountingClient.sends = 0
+
+    with patch("app.services.snmp_service.Client", _SendCountingClient):
+        result = await service.execute_query(query)
+
+    assert result == {"error": TIMEOUT_ERROR, "error_code": SNMP_TIMEOUT}
+    assert _SendCountingClient.sends == sends
+
+
+
+@pytest.mark.asyncio
+async def test_execute_multi_retries_not_multiplied():
+    """Test that a multi-target query sends each request at most 1 + retries times, not once per retry per attempt"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="10.2.0.1", retries=2),
+        operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"])
+    )
+    _SendCountingClient.sends = 0
+
+    with patch("app.services.snmp_service.Client", _SendCountingClient):
+        results = await service.execute_multi(query, ["10.2.0.1"], retry_budget=10)
+
+    assert results[0].error == TIMEOUT_ERROR
+    assert _SendCountingClient.sends == 3
+
+@pytest.mark.asyncio
+async def test_fast_fail_sends_once_with_short_timeout():
+    """Test that fast-fail mode configures one send with the fast timeout, and multi-target queries don't retry"""
+    query = fast_fail(_multi_query())
+    assert (query.target.timeout, query.target.retries) == (1, 0)
+
+    client = SNMPService(mib_service=MIBService())._create_client(query, "public")
+    assert (client.config.timeout, client.config.retries) == (1, 1)
+
+    service = SNMPService(mib_service=MagicMock(spec=MIBService))
+    execute, attempts = _flaky_targets(failures_per_host=1)
+    with patch.object(service, "execute_query", side_effect=execute):
+        results = await service.execute_multi(query, ["10.0.0.1", "10.0.0.2"], retry_budget=5)
+
+    assert attempts == {"10.0.0.1": 1, "10.0.0.2": 1}
+    assert all(r.error == TIMEOUT_ERROR for r in results)
+
+
+@pytest.mark.asyncio
+async def test_execute_multi_retries_within_budget():
+    """Test that flaky targets succeed when the budget covers their retries"""
+    service = SNMPService(mib_service=MagicMock(spec=MIBService))
+    hosts = ["10.0.0.1", "10.0.0.2", "10.0.0.3"]
+    execute, attempts = _flaky_targets(failures_per_host=1)
+
+    with patch.object(service, "execute_query", side_effect=execute), \
+            patch("app.services.snmp_service.config.snmp.multi_retry_budget", 3):
+        results = await service.execute_multi(_multi_query(), hosts)
+
+    assert [r.status for r in results] == [200, 200, 200]
+    assert sum(attempts.values()) == 6
+
+
+@pytest.mark.asyncio
+async def test_execute_multi_no_retry_for_non_transient_errors():
+    """Test that only timeouts and refused connections are retried"""
+    service = SNMPService(mib_service=MagicMock(spec=MIBService))
+    execute_query = AsyncMock(return_value={"error": "SNMP error: noSuchName"})
+
+    with patch.object(service, "execute_query", execute_query):
+        results = await service.execute_multi(_multi_query(), ["10.0.0.1", "10.0.0.2"], retry_budget=10)
+
+    assert execute_query.await_count == 2
+    assert [r.error for r in results] == ["SNMP error: noSuchName"] * 2
+
+
+@pytest.mark.asyncio
+async def test_preflight_unresolvable_target():
+    """Test that a target that doesn't resolve fails fast without sending SNMP"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="no-such-device.invalid"),
+        operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"])
+    )
+
+    with patch("socket.getaddrinfo", side_effect=socket.gaierror(socket.EAI_NONAME, "Name or service not known")), \
+            patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.get = AsyncMock()
+        result = await service.execute_query(query)
+
+    assert result["error"] == "Target no-such-device.invalid does not resolve: Name or service not known"
+    assert result["error_code"] == SNMP_UNREACHABLE
+    mock_client.return_value.get.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_preflight_resolvable_but_down_target():
+    """Test that a down target is reported unreachable within the pre-flight timeout, and cached"""
+    async def no_response(oid):
+        await asyncio.sleep(10)
+
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.0.2.10"),
+        operation=SNMPOperation(command="WALK", oids=["1.3.6.1.2.1.2.2"])
+    )
+
+    with patch("app.services.snmp_service.Client") as mock_client, \
+            patch("app.services.snmp_service.config.snmp.preflight_reachability", True), \
+            patch("app.services.snmp_service.config.snmp.preflight_timeout", 0.1):
+        mock_client.return_value.get = MagicMock(side_effect=no_response)
+        mock_client.return_value.walk = MagicMock()
+
+        result = await asyncio.wait_for(service.execute_query(query), timeout=2)
+        assert result["error"] == "Target 192.0.2.10 is unreachable: no SNMP response on port 161 within 0.1s"
+        mock_client.return_value.walk.assert_not_called()
+
+        # The outcome is cached, so the next query doesn't probe again
+        result = await service.execute_query(query)
+        assert "unreachable" in result["error"]
+        assert mock_client.return_value.get.call_count == 1
+
+
+
+@pytest.mark.asyncio
+async def test_preflight_cached_per_credentials_not_for_auth_failures():
+    """Test that a pre-flight outcome is only reused with the same credentials, and an authentication failure isn't cached"""
+    service = SNMPService(mib_service=MIBService())
+    probe = SNMPQuery(
+        target=SNMPTarget(host="192.0.2.11"),
+        operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"])
+    )
+    other_community = probe.model_copy(update={"credentials": SNMPCredentials(community="private")})
+    client = MagicMock()
+
+    with patch("app.services.snmp_service.config.snmp.preflight_dns", False), \
+            patch("app.services.snmp_service.config.snmp.preflight_reachability", True):
+        client.get = AsyncMock(side_effect=Timeout("no response"))
+        assert "unreachable" in await service.preflight_target(probe, [client])
+        assert await service.preflight_target(other_community, [client])
+        assert client.get.await_count == 2
+
+        client.get = AsyncMock(side_effect=Exception("Report: usmStatsUnknownUserNames"))
+        v3 = probe.model_copy(update={"credentials": SNMPCredentials(version="3", username="monitor")})
+        assert await service.preflight_target(v3, [client]) is None
+        assert await service.preflight_target(v3, [client]) is None
+        assert client.get.await_count == 2
+
+def _community_clients(valid_community):
+    """Fake clients that time out unless created with the valid community, recording the communities used"""
+    used = []
+
+    def create_client(host, community, port=161, **kwargs):
+        client = MagicMock()
+
+        async def get(oid):
+            used.append(community)
+            if community != valid_community:
+                raise Timeout("No response")
+            return b"router1"
+
+        client.get = get
+        return client
+
+    return create_client, used
+
+
+@pytest.mark.asyncio
+async def test_execute_query_falls_back_to_next_community():
+    """Test that the next configured community is tried on timeout, and the working one is tried first afterwards"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="10.1.1.1"),
+        operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"])
+    )
+    create_client, used = _community_clients("new-secret")
+
+    with patch("app.services.snmp_service.Client", side_effect=create_client), \
+            patch("app.services.snmp_service.V2C", side_effect=lambda community: community), \
+            patch("app.services.snmp_service.config.snmp.target_communities",
+                  {"10.1.1.1": ["old-secret", "new-secret"]}):
+        result = await service.execute_query(query)
+        assert result == {"SNMPv2-MIB::sysName.0": "router1"}
+        assert used == ["old-secret", "new-secret"]
+
+        used.clear()
+        result = await service.execute_query(query)
+        assert result == {"SNMPv2-MIB::sysName.0": "router1"}
+        assert used == ["new-secret"]
+
+
+@pytest.mark.asyncio
+async def test_execute_query_uses_target_credentials():
+    """Test that a target's subnet communities are tried, and a v3 subnet's user is used, unless the query has its own"""
+    service = SNMPService(mib_service=MIBService())
+    create_client, used = _community_clients("tenant-a")
+    v3_users = []
+
+    def query(host, **credentials):
+        return SNMPQuery(
+            target=SNMPTarget(host=host),
+            credentials=SNMPCredentials(**credentials),
+            operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"])
+        )
+
+    with patch("app.services.snmp_service.Client", side_effect=create_client), \
+            patch("app.services.snmp_service.V2C", side_effect=lambda community: community), \
+            patch("app.services.snmp_service.V3", side_effect=lambda user, **kwargs: v3_users.append(user) or "v3"), \
+            patch("app.services.snmp_service.config.snmp.target_credentials", {
+                "10.20.0.0/16": TargetCredentials(communities=["stale", "tenant-a"]),
+                "10.30.0.0/16": TargetCredentials(version="3", username="monitor", auth_password="auth-secret"),
+                "default": TargetCredentials(communities=["fallback"]),
+            }):
+        assert await service.execute_query(query("10.20.1.1")) == {"SNMPv2-MIB::sysName.0": "router1"}
+        assert used == ["stale", "tenant-a"]
+
+        used.clear()
+        await service.execute_query(query("10.20.1.1", community="tenant-a"))
+        assert used == ["tenant-a"]
+
+        used.clear()
+        await service.execute_query(query("192.168.1.1"))
+        assert used == ["fallback"]
+
+        used.clear()
+        await service.execute_query(query("10.30.1.1"))
+        assert v3_users == ["monitor"]
+        assert service.describe_request(query("10.30.1.1"))["security"]["username"] == "monitor"
+
+
+@pytest.mark.asyncio
+async def test_target_credentials_for_host_names_and_validated():
+    """Test that a host name gets the credentials of the network it resolves into, validated before anything is sent"""
+    clear_cache()
+    service = SNMPService(mib_service=MIBService())
+    create_client, used = _community_clients("tenant-a")
+    addresses = {"switch1.example.net": "10.20.1.1", "switch2.example.net": "10.30.1.1"}
+
+    def query(host):
+        return SNMPQuery(target=SNMPTarget(host=host), operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"]))
+
+    def getaddrinfo(host, port, *args, **kwargs):
+        return [(socket.AF_INET, socket.SOCK_DGRAM, 17, "", (addresses[host], port))]
+
+    with patch("socket.getaddrinfo", side_effect=getaddrinfo), \
+            patch("app.services.snmp_service.Client", side_effect=create_client) as client, \
+            patch("app.services.snmp_service.V2C", side_effect=lambda community: community), \
+            patch("app.services.snmp_service.config.snmp.target_credentials", {
+                "10.20.0.0/16": TargetCredentials(communities=["tenant-a"]),
+                "10.30.0.0/16": TargetCredentials(version="3", username="monitor", auth_password="short"),
+                "default": TargetCredentials(communities=["fallback"]),
+            }):
+        assert await service.execute_query(query("switch1.example.net")) == {"SNMPv2-MIB::sysName.0": "router1"}
+        assert used == ["tenant-a"]
+
+        client.reset_mock()
+        result = await service.execute_query(query("switch2.example.net"))
+        assert result["error_code"] == INVALID_QUERY
+        assert "at least 8 characters" in result["error"]
+        client.assert_not_called()
+        assert service.validate_query(query("switch2.example.net")) == result["error"]
+    clear_cache()
+
+
+@pytest.mark.asyncio
+async def test_execute_query_all_communities_fail():
+    """Test that a distinct error is returned when every configured community fails"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="10.1.1.2"),
+        operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"])
+    )
+    create_client, used = _community_clients("other")
+
+    with patch("app.services.snmp_service.Client", side_effect=create_client), \
+            patch("app.services.snmp_service.V2C", side_effect=lambda community: community), \
+            patch("app.services.snmp_service.config.snmp.target_communities",
+                  {"10.1.1.2": ["first", "second"]}):
+        result = await service.execute_query(query)
+
+    assert result == {"error": COMMUNITIES_FAILED_ERROR, "error_code": SNMP_TIMEOUT}
+    assert used == ["first", "second"]
+    assert "first" not in result["error"] and "second" not in result["error"]
+
+
+@pytest.mark.asyncio
+async def test_execute_query_reports_effective_parameters():
+    """Test that the reported parameters match the target's community profile and negotiated version"""
+    clear_cache()
+    service = SNMPService(mib_service=MIBService())
+    query = _auto_version_query("10.1.1.3")
+    fast_fail(query)
+    create_client, used = _community_clients("new-secret")
+    effective = {}
+
+    with patch("app.services.snmp_service.Client", side_effect=create_client), \
+            patch("app.services.snmp_service.V2C", side_effect=lambda community: community), \
+            patch("app.services.snmp_service.config.snmp.fast_timeout", 1), \
+            patch("app.services.snmp_service.config.snmp.target_communities",
+                  {"10.1.1.3": ["old-secret", "new-secret"]}):
+        result = await service.execute_query(query, effective=effective)
+
+    assert result == {"SNMPv2-MIB::sysName.0": "router1"}
+    assert effective == {
+        "host": "10.1.1.3", "port": 161, "transport": "udp",
+        "version": "2c", "version_negotiated": True,
+        "timeout": 1, "retries": 0,
+        "community": "****", "community_source": "target",
+        "community_candidates": 2, "community_attempts": 2,
+    }
+    assert "secret" not in str(effective)
+    clear_cache()
+
+
+def test_effective_parameters_bulk_and_v3():
+    """Test that effective parameters cap max-repetitions, detect IPv6 and mask v3 passwords"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="2001:db8::1", timeout=3, retries=2),
+        credentials=SNMPCredentials(
+            version="3", username="monitor", auth_password="authpass", priv_password="privpass"
+        ),
+        operation=SNMPOperation(command="BULK", oids=["1.3.6.1.2.1.2.2"], max_repetitions=500)
+    )
+
+    with patch("app.services.snmp_service.config.snmp.max_pdu_varbinds", 50):
+        parameters = service.effective_parameters(query, ["public"])
+
+    assert parameters["transport"] == "udp6"
+    assert (parameters["version"], parameters["version_negotiated"]) == ("3", False)
+    assert (parameters["timeout"], parameters["retries"]) == (3, 2)
+    assert (parameters["non_repeaters"], parameters["max_repetitions"]) == (0, 50)
+    assert parameters["security"] == {
+        "username": "monitor", "security_level": "authPriv", "auth_protocol": "SHA", "auth_password": "****",
+        "priv_protocol": "AES", "priv_password": "****",
+    }
+    assert "community" not in parameters
+    assert "authpass" not in str(parameters) and "privpass" not in str(parameters)
+
+
+def _versioned_clients(supported_versions):
+    """Fake clients for a device that only answers the given SNMP versions, recording the versions used"""
+    used = []
+
+    def create_client(host, credentials, port=161, **kwargs):
+        version, _ = credentials
+        client = MagicMock()
+
+        async def get(oid):
+            used.append(version)
+            if version not in supported_versions:
+                raise Timeout("No response")
+            return b"router1"
+
+        client.get = get
+        return client
+
+    return create_client, used
+
+
+def _auto_version_query(host):
+    return SNMPQuery(
+        target=SNMPTarget(host=host),
+        credentials=SNMPCredentials(version="auto", community="public"),
+        operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"])
+    )
+
+
+@pytest.mark.asyncio
+async def test_negotiate_version_settles_on_v1():
+    """Test that a v1-only device is probed with v2c then v1, and later queries with the same credentials use v1 directly"""
+    clear_cache()
+    service = SNMPService(mib_service=MIBService())
+    create_client, used = _versioned_clients({"1"})
+
+    with patch("app.services.snmp_service.Client", side_effect=create_client), \
+            patch("app.services.snmp_service.V1", side_effect=lambda community: ("1", community)), \
+            patch("app.services.snmp_service.V2C", side_effect=lambda community: ("2c", community)):
+        result = await service.execute_query(_auto_version_query("10.3.0.1"))
+        assert result == {"SNMPv2-MIB::sysName.0": "router1"}
+        assert used == ["2c", "1", "1"]
+
+        used.clear()
+        result = await service.execute_query(_auto_version_query("10.3.0.1"))
+        assert result == {"SNMPv2-MIB::sysName.0": "router1"}
+        assert used == ["1"]
+
+        used.clear()
+        other_community = _auto_version_query("10.3.0.1")
+        other_community.credentials.community = "private"
+        await service.execute_query(other_community)
+        assert used == ["2c", "1", "1"]
+
+    clear_cache()
+
+
+@pytest.mark.asyncio
+async def test_negotiate_version_bounded_and_not_cached_without_answer():
+    """Test that each version is probed once, and a silent device isn't cached as any version"""
+    clear_cache()
+    service = SNMPService(mib_service=MIBService())
+    create_client, used = _versioned_clients(set())
+    query = _auto_version_query("10.3.0.2")
+    query.target.retries = 0
+
+    with patch("app.services.snmp_service.Client", side_effect=create_client), \
+            patch("app.services.snmp_service.V1", side_effect=lambda community: ("1", community)), \
+            patch("app.services.snmp_service.V2C", side_effect=lambda community: ("2c", community)):
+        result = await service.execute_query(query)
+        assert result == {"error": TIMEOUT_ERROR, "error_code": SNMP_TIMEOUT}
+        assert used == ["2c", "1", "2c"]
+
+        used.clear()
+        await service.execute_query(query)
+        assert used == ["2c", "1", "2c"]
+
+
+@pytest.mark.asyncio
+async def test_negotiate_version_not_cached_when_answered_with_error():
+    """Test that a version the device answered with an error is used, but probed again next time"""
+    clear_cache()
+    service = SNMPService(mib_service=MIBService())
+    client = MagicMock()
+    client.get = AsyncMock(side_effect=Exception("authorizationError"))
+
+    with patch.object(service, "_create_client", return_value=client):
+        assert await service.negotiate_version(_auto_version_query("10.3.0.3")) == "2c"
+        assert await service.negotiate_version(_auto_version_query("10.3.0.3")) == "2c"
+
+    assert client.get.await_count == 2
+
+
+@pytest.mark.asyncio
+async def test_execute_query_single_index_get():
+    """Test that a row index turns a column query into a GET of the exact cell"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="WALK", oids=["IF-MIB::ifInOctets"], indexes=["3"])
+    )
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.get = AsyncMock(return_value=123456)
+        mock_client.return_value.walk = MagicMock()
+        result = await service.execute_query(query)
+
+    assert result == {"IF-MIB::ifInOctets.3": 123456}
+    mock_client.return_value.get.assert_called_once_with(ObjectIdentifier("1.3.6.1.2.1.2.2.1.10.3"))
+    mock_client.return_value.walk.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_execute_query_index_range_walk():
+    """Test that an index range walks from just before its start and stops past its end"""
+    walked = []
+
+    async def getnext(oid):
+        index = int(str(oid).rsplit(".", 1)[1]) + 1
+        walked.append(index)
+        return f"1.3.6.1.2.1.2.2.1.10.{index}", index * 100
+
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="WALK", oids=["IF-MIB::ifInOctets"], index_from=2, index_to=4)
+    )
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.getnext = getnext
+        mock_client.return_value.walk = MagicMock()
+        result = await service.execute_query(query)
+
+    assert result == {
+        "1.3.6.1.2.1.2.2.1.10.2": 200, "1.3.6.1.2.1.2.2.1.10.3": 300, "1.3.6.1.2.1.2.2.1.10.4": 400
+    }
+    assert walked == [2, 3, 4, 5]
+    mock_client.return_value.walk.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_execute_query_streams_walked_rows():
+    """Test that each walked row is passed on, named and formatted, before the walk goes on to the next"""
+    streamed = []
+
+    async def walk(oid):
+        for index in range(1, 4):
+            # Every earlier row has been passed on before the agent answers with the next
+            assert len(streamed) == index - 1
+            yield f"1.3.6.1.2.1.2.2.1.10.{index}", index * 100
+
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="WALK", oids=["IF-MIB::ifInOctets"])
+    )
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.walk = walk
+        result = await service.execute_query(query, on_rows=streamed.append)
+
+    assert streamed == [
+        {"1.3.6.1.2.1.2.2.1.10.1": 100}, {"1.3.6.1.2.1.2.2.1.10.2": 200}, {"1.3.6.1.2.1.2.2.1.10.3": 300}
+    ]
+    assert result == {name: value for rows in streamed for name, value in rows.items()}
+
+
+@pytest.mark.asyncio
+async def test_walk_table_groups_iftable_rows():
+    """Test that WALKTABLE walks ifTable and puts its cells back into rows by column name and ifIndex"""
+    walked = {
+        "1.3.6.1.2.1.2.2.1.1.1": 1, "1.3.6.1.2.1.2.2.1.1.2": 2,
+        "1.3.6.1.2.1.2.2.1.2.1": "lo", "1.3.6.1.2.1.2.2.1.2.2": "eth0",
+        "1.3.6.1.2.1.2.2.1.8.1": 1, "1.3.6.1.2.1.2.2.1.8.2": 2,
+        # A column no loaded MIB defines
+        "1.3.6.1.2.1.2.2.1.21.1": 0, "1.3.6.1.2.1.2.2.1.21.2": 5,
+    }
+
+    async def walk(oid):
+        for walked_oid, value in walked.items():
+            yield walked_oid, value
+
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="WALKTABLE", oids=["ifTable"])
+    )
+
+    assert query.operation.effective_command() == "WALK"
+    assert service.validate_query(query) is None
+    with patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.walk = walk
+        result = await service.execute_query(query)
+
+    rows = service.table_rows(query, service.enrich_results(result))
+
+    assert [(row.table, row.instance, row.index) for row in rows] == [
+        ("1.3.6.1.2.1.2.2", "1", {"ifIndex": 1}), ("1.3.6.1.2.1.2.2", "2", {"ifIndex": 2}),
+    ]
+    assert {row.entry for row in rows} == {"1.3.6.1.2.1.2.2.1"}
+    assert rows[0].columns == {"ifIndex": 1, "ifDescr": "lo", "ifOperStatus": 1, "1.3.6.1.2.1.2.2.1.21": 0}
+    assert rows[1].columns == {"ifIndex": 2, "ifDescr": "eth0", "ifOperStatus": 2, "1.3.6.1.2.1.2.2.1.21": 5}
+
+
+def test_validate_query_index_range():
+    """Test that index ranges are only accepted for WALK and must be ordered"""
+    service = SNMPService(mib_service=MIBService())
+
+    def query(command, **operation):
+        return SNMPQuery(
+            target=SNMPTarget(host="192.168.1.1"),
+            operation=SNMPOperation(command=command, oids=["1.3.6.1.2.1.2.2.1.10"], **operation)
+        )
+
+    assert service.validate_query(query("WALK", index_from=1, index_to=4)) is None
+    assert service.validate_query(query("WALK", index_from=5)) is None
+    assert "only supported for WALK" in service.validate_query(query("GET", index_from=1, index_to=4))
+    assert "not WALKTABLE" in service.validate_query(query("WALKTABLE", index_from=1, index_to=4))
+    assert "Invalid index range" in service.validate_query(query("WALK", index_from=4, index_to=1))
+    assert "not both" in service.validate_query(query("WALK", indexes=["1"], index_to=4))
+
+
+@pytest.mark.asyncio
+async def test_overlapping_subtrees_are_deduplicated():
+    """Test that overlapping subtrees are walked once and grouping lists shared results under each OID"""
+    walked_roots = []
+
+    async def walk(oid):
+        walked_roots.append(str(oid))
+        for column, value in (("2", "eth0"), ("8", 1)):
+            yield f"1.3.6.1.2.1.2.2.1.{column}.1", value
+
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(
+            command="WALK", oids=["1.3.6.1.2.1.2.2.1.2", "1.3.6.1.2.1.2.2", "IF-MIB::ifDescr", "1.3.6.1.2.1.2.2"]
+        )
+    )
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.walk = walk
+        result = await service.execute_query(query)
+
+    assert walked_roots == [str(ObjectIdentifier("1.3.6.1.2.1.2.2"))]
+    assert result == {"1.3.6.1.2.1.2.2.1.2.1": "eth0", "1.3.6.1.2.1.2.2.1.8.1": 1}
+
+    results = service.enrich_results(result)
+    assert len(results) == 2
+    assert service.group_results(query, results) == {
+        "1.3.6.1.2.1.2.2.1.2": ["IF-MIB::ifDescr.1"],
+        "1.3.6.1.2.1.2.2": ["IF-MIB::ifDescr.1", "IF-MIB::ifOperStatus.1"],
+    }
+
+
+@pytest.mark.asyncio
+async def test_date_and_time_values_are_rendered_as_rfc3339():
+    """Test that DateAndTime values are decoded with the agent's own UTC offset"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.25.1.2.0"])
+    )
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.get = AsyncMock(return_value=bytes([0x07, 0xE8, 3, 10, 22, 30, 15, 0, ord("+"), 1, 0]))
+        result = await service.execute_query(query)
+
+    assert result == {"1.3.6.1.2.1.25.1.2.0": "2024-03-10T22:30:15+01:00"}
+
+
+@pytest.mark.asyncio
+async def test_execute_query_target_with_port():
+    """Test that the client and community lookup agree on the host and port of a host:port target"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="[2001:DB8::5]:1161"),
+        operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"])
+    )
+    create_client, used = _community_clients("port-secret")
+
+    with patch("app.services.snmp_service.Client", side_effect=create_client) as mock_client, \
+            patch("app.services.snmp_service.V2C", side_effect=lambda community: community), \
+            patch("app.services.snmp_service.config.snmp.target_communities",
+                  {"2001:db8::5": ["host-secret"], "[2001:db8::5]:1161": ["port-secret"]}):
+        result = await service.execute_query(query)
+
+    assert result == {"SNMPv2-MIB::sysName.0": "router1"}
+    assert used == ["port-secret"]
+    mock_client.assert_called_once_with("2001:db8::5", "port-secret", port=1161, sender=ANY)
+
+
+class EndOfMibView:
+    """Stand-in for the endOfMibView varbind value"""
+
+
+def _table_walk(rows):
+    """Fake walk yielding the given number of rows of ifInOctets"""
+    async def walk(oid):
+        for index in range(1, rows + 1):
+            yield f"1.3.6.1.2.1.2.2.1.10.{index}", index * 100
+    return walk
+
+
+@pytest.mark.asyncio
+@pytest.mark.parametrize("rows,next_value,operation,expected", [
+    # The agent has objects after the subtree but none in it
+    (0, 42, {}, "no-objects"),
+    # The agent has nothing at or after the subtree
+    (0, EndOfMibView(), {}, "endOfMibView-immediately"),
+    # Rows were walked, but none were in the requested index range
+    (3, 42, {"index_from": 10, "index_to": 20}, "all-filtered"),
+])
+async def test_empty_walk_reason(rows, next_value, operation, expected):
+    """Test that an empty walk says why it has no values"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="WALK", oids=["IF-MIB::ifInOctets"], **operation)
+    )
+
+    async def getnext(oid):
+        # The first row, when asked from the start of the column, or the next column
+        if rows and str(oid) == "1.3.6.1.2.1.2.2.1.10":
+            return "1.3.6.1.2.1.2.2.1.10.1", 100
+        return "1.3.6.1.2.1.2.2.1.11.1", next_value
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.walk = _table_walk(rows)
+        mock_client.return_value.getnext = getnext
+        result = await service.execute_query(query)
+
+    assert result == {}
+    assert empty_reason(result) == expected
+
+
+@pytest.mark.asyncio
+async def test_empty_reason_no_such_instance():
+    """Test that a GET where every OID is noSuchInstance counts as having no objects"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="WALK", oids=["IF-MIB::ifInOctets"], indexes=["98", "99"])
+    )
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.get = AsyncMock(side_effect=SnmpError("No such instance"))
+        result = await service.execute_query(query)
+
+    assert set(result.values()) == {"No such instance"}
+    assert empty_reason(result) == "no-objects"
+
+
+def test_empty_reason_with_values():
+    """Test that data with values or an error has no empty reason"""
+    assert empty_reason({"IF-MIB::ifInOctets.1": 100}) is None
+    assert empty_reason({"IF-MIB::ifInOctets.1": 100, "IF-MIB::ifInOctets.2": "No such instance"}) is None
+    assert empty_reason({"error": TIMEOUT_ERROR}) is None
+    assert empty_reason({}) == "no-objects"
+
+
+class UsmReport(SnmpError):
+    """Fixture error for an SNMPv3 report PDU carrying a usmStats counter"""
+
+    def __init__(self, oid, name):
+        super().__init__(f"Received report PDU: {name} ({oid}) = 1")
+
+
+class AuthorizationError(SnmpError):
+    """Fixture error for an authorizationError response"""
+    error_status = 16
+
+
+def _v3_query(command="GET", oids=("1.3.6.1.2.1.1.5.0",)):
+    return SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        credentials=SNMPCredentials(version="3", username="monitor", auth_protocol="SHA",
+                                    auth_password="wrong-pass", priv_protocol="AES", priv_password="priv-pass"),
+        operation=SNMPOperation(command=command, oids=list(oids))
+    )
+
+
+@pytest.mark.parametrize("error,reason", [
+    (UsmReport("1.3.6.1.6.3.15.1.1.5.0", "usmStatsWrongDigests"), "wrong authentication password"),
+    (UsmReport("1.3.6.1.6.3.15.1.1.3.0", "usmStatsUnknownUserNames"), "unknown user name"),
+    (UsmReport("1.3.6.1.6.3.15.1.1.6.0", "usmStatsDecryptionErrors"), "wrong privacy password"),
+    (UsmReport("1.3.6.1.6.3.15.1.1.1.0", "usmStatsUnsupportedSecLevels"), "security level"),
+    (AuthorizationError("access denied"), "authorizationError"),
+])
+def test_auth_failure_detected(error, reason):
+    """Test that usmStats reports and authorizationError are recognized as authentication failures"""
+    assert reason in auth_failure(error)
+
+
+@pytest.mark.asyncio
+@pytest.mark.parametrize("error,error_code", [
+    (Timeout("No response"), SNMP_TIMEOUT),
+    (WalkDeadlineExceeded("Overall walk deadline of 60s exceeded"), SNMP_TIMEOUT),
+    (ConnectionRefusedError(), SNMP_UNREACHABLE),
+    (SnmpError("genErr"), SNMP_ERROR),
+])
+async def test_failures_have_distinct_error_codes(error, error_code):
+    """Test that a device that didn't answer, couldn't be reached or answered with an error fail with their own codes"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(target=SNMPTarget(host="192.168.1.1"),
+                      operation=SNMPOperation(command="WALK", oids=["1.3.6.1.2.1.2.2"]))
+
+    with patch("app.services.snmp_service.Client"), \
+            patch.object(SNMPService, "_execute_operation", new=AsyncMock(side_effect=error)):
+        assert (await service.execute_query(query))["error_code"] == error_code
+
+    invalid = query.model_copy(update={"operation": SNMPOperation(command="WALK", oids=["1.3.6.1.2.1.2.2"], index_from=4,
+                                                                  index_to=1)})
+    assert (await service.execute_query(invalid))["error_code"] == INVALID_QUERY
+
+
+@pytest.mark.parametrize("error", [
+    Timeout("No response"),
+    SnmpError("genErr"),
+    UsmReport("1.3.6.1.6.3.15.1.1.2.0", "usmStatsNotInTimeWindows"),
+])
+def test_auth_failure_not_detected(error):
+    """Test that timeouts and other errors aren't reported as authentication failures"""
+    assert auth_failure(error) is None
+
+
+@pytest.mark.asyncio
+@pytest.mark.parametrize("command", ["GET", "GETNEXT", "WALK"])
+async def test_v3_wrong_credentials_reported_as_auth_failure(command):
+    """Test that a usmStats report fails the query with SNMP_AUTH_FAILED instead of a generic error"""
+    service = SNMPService(mib_service=MIBService())
+    report = UsmReport("1.3.6.1.6.3.15.1.1.5.0", "usmStatsWrongDigests")
+
+    async def walk(oid):
+        raise report
+        yield
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.get = AsyncMock(side_effect=report)
+        mock_client.return_value.getnext = AsyncMock(side_effect=report)
+        mock_client.return_value.walk = walk
+        result = await service.execute_query(_v3_query(command, oids=["1.3.6.1.2.1.1.5.0", "1.3.6.1.2.1.1.6.0"]))
+
+    assert result["error_code"] == SNMP_AUTH_FAILED
+    assert result["error"].startswith("SNMP authentication failed: wrong authentication password or protocol")
+    credentials = mock_client.call_args[0][1]
+    assert credentials.username == "monitor"
+    assert credentials.auth.method == "sha1"
+    assert credentials.priv.method == "aes"
+
+
+@pytest.mark.asyncio
+async def test_v3_requires_username():
+    """Test that SNMPv3 queries without a user are rejected before anything is sent"""
+    query = _v3_query()
+    query.credentials.username = None
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        result = await SNMPService(mib_service=MIBService()).execute_query(query)
+
+    assert result["error"] == "SNMPv3 requires a username"
+    mock_client.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_v3_user_from_config():
+    """Test that v3 queries without a user get the SNMP_V3_* user, and a query's own user takes precedence"""
+    query = _v3_query()
+    query.credentials = SNMPCredentials(version="3")
+    v3_config = {"v3_security_name": "poller", "v3_auth_protocol": "MD5", "v3_auth_passphrase": "config-auth",
+                 "v3_priv_protocol": "DES", "v3_priv_passphrase": "config-priv"}
+
+    with patch.multiple("app.services.snmp_service.config.snmp", **v3_config), \
+            patch("app.services.snmp_service.Client") as mock_client:
+        mock_client.return_value.get = AsyncMock(return_value=b"router1")
+        service = SNMPService(mib_service=MIBService())
+        effective = {}
+        assert await service.execute_query(query, effective=effective) == {"SNMPv2-MIB::sysName.0": "router1"}
+        credentials = mock_client.call_args[0][1]
+        assert (credentials.username, credentials.auth.method, credentials.priv.method) == ("poller", "md5", "des")
+        assert credentials.auth.key == b"config-auth"
+        assert effective["security"]["security_level"] == "authPriv"
+        assert "config-auth" not in str(effective)
+
+        await service.execute_query(_v3_query())
+        credentials = mock_client.call_args[0][1]
+        assert (credentials.username, credentials.auth.method) == ("monitor", "sha1")
+
+
+@pytest.mark.asyncio
+@pytest.mark.parametrize("credentials,error", [
+    ({"security_level": "authPriv", "auth_password": "auth-pass"}, "authPriv requires a privacy passphrase"),
+    ({"security_level": "authNoPriv"}, "authNoPriv requires an authentication passphrase"),
+    ({"security_level": "authNoPriv", "auth_password": "auth-pass", "priv_password": "priv-pass"},
+     "authNoPriv doesn't use a privacy passphrase"),
+    ({"security_level": "noAuthNoPriv", "auth_password": "auth-pass"}, "noAuthNoPriv doesn't use an authentication"),
+    ({"priv_password": "priv-pass"}, "authPriv requires an authentication passphrase"),
+    ({"security_level": "authOnly"}, "Unsupported SNMPv3 security level: authOnly"),
+    ({"auth_protocol": "SHA256", "auth_password": "auth-pass"}, "Unsupported SNMPv3 authentication protocol: SHA256"),
+    ({"auth_password": "auth-pass", "priv_protocol": "3DES", "priv_password": "priv-pass"},
+     "Unsupported SNMPv3 privacy protocol: 3DES"),
+    ({"auth_password": "short"}, "authentication passphrase must be at least 8 characters"),
+])
+async def test_v3_invalid_combination_rejected_before_sending(credentials, error):
+    """Test that a security level, protocol or passphrase combination that can't work fails with a clear error"""
+    query = _v3_query()
+    query.credentials = SNMPCredentials(version="3", username="monitor", **credentials)
+
+    with patch("app.services.snmp_service.Client") as mock_client:
+        result = await SNMPService(mib_service=MIBService()).execute_query(query)
+
+    assert error in result["error"]
+    mock_client.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_v2c_timeout_never_reported_as_auth_failure():
+    """Test that a v1/v2c timeout stays a timeout, even right after another community got an answer"""
+    service = SNMPService(mib_service=MIBService())
+
+    def query(community):
+        return SNMPQuery(
+            target=SNMPTarget(host="192.168.1.1"),
+            credentials=SNMPCredentials(version="2c", community=community),
+            operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"])
+        )
+
+    def create_client(host, credentials, port=161, **kwargs):
+        client = MagicMock()
+        if credentials.community == "good":
+            client.get = AsyncMock(return_value=b"router1")
+        else:
+            client.get = AsyncMock(side_effect=Timeout("No response"))
+        return client
+
+    with patch("app.services.snmp_service.Client", side_effect=create_client), \
+            patch("app.services.snmp_service.V2C", side_effect=lambda community: MagicMock(community=community)):
+        # Nothing known about the target yet: a plain timeout
+        result = await service.execute_query(query("bad"))
+        assert result["error"] == TIMEOUT_ERROR
+        assert result["error_code"] == SNMP_TIMEOUT
+
+        assert "error" not in await service.execute_query(query("good"))
+
+        # The device may just have gone down since
+        result = await service.execute_query(query("bad"))
+        assert result["error"] == TIMEOUT_ERROR
+        assert result["error_code"] == SNMP_TIMEOUT
+
+
+@pytest.mark.asyncio
+async def test_execute_query_records_target_outcomes():
+    """Test that SNMP exchanges are recorded per target, and queries rejected before sending are not"""
+    service = SNMPService(mib_service=MIBService())
+
+    def query(host):
+        return SNMPQuery(
+            target=SNMPTarget(host=host),
+            operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"])
+        )
+
+    def create_client(host, credentials, port=161, **kwargs):
+        client = MagicMock()
+        if host == "192.168.1.1":
+            client.get = AsyncMock(return_value=b"router1")
+        else:
+            client.get = AsyncMock(side_effect=Timeout("No response"))
+        return client
+
+    with patch("app.services.snmp_service.Client", side_effect=create_client):
+        await service.execute_query(query("192.168.1.1"))
+        await service.execute_query(query("192.168.1.2"))
+    with patch("socket.getaddrinfo", side_effect=socket.gaierror(socket.EAI_NONAME, "Name or service not known")):
+        await service.execute_query(query("no-such-device.invalid"))
+
+    down, up = service.target_stats.stats()
+    assert (down["target"], down["success_rate"], down["last_error"]) == ("192.168.1.2:161", 0.0, TIMEOUT_ERROR)
+    assert (up["target"], up["success_rate"], up["last_error"]) == ("192.168.1.1:161", 1.0, None)
+    assert up["latency_p50_ms"] is not None
+
+
+def _set_query(*set_values):
+    return SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="SET", set_values=[SNMPSetValue(**value) for value in set_values])
+    )
+
+
+# What the SET tests may write to, since nothing may be written unless it is listed
+WRITABLE = ["IF-MIB::ifAdminStatus", "1.3.6.1.2.1.1.6"]
+
+
+@pytest.mark.asyncio
+async def test_set_resolves_enum_label_to_typed_value():
+    """Test that a SET writes its values with the type of the object's SYNTAX, enum labels resolved"""
+    service = SNMPService(mib_service=MIBService())
+    query = _set_query({"oid": "IF-MIB::ifAdminStatus.3", "type": "Integer", "value": "down"},
+                       {"oid": "SNMPv2-MIB::sysLocation.0", "value": "rack 4"})
+
+    with patch("app.services.snmp_service.Client") as mock_client, \
+            patch("app.services.snmp_service.config.snmp.allow_set", True), \
+            patch("app.services.snmp_service.config.snmp.set_allowed_oids", WRITABLE):
+        mock_client.return_value.multiset = AsyncMock(side_effect=lambda mappings: mappings)
+        result = await service.execute_query(query)
+
+    mock_client.return_value.multiset.assert_called_once_with({
+        ObjectIdentifier("1.3.6.1.2.1.2.2.1.7.3"): Integer(2),
+        ObjectIdentifier("1.3.6.1.2.1.1.6.0"): OctetString(b"rack 4"),
+    })
+    assert result == {"IF-MIB::ifAdminStatus.3": 2, "SNMPv2-MIB::sysLocation.0": "rack 4"}
+
+
+@pytest.mark.asyncio
+async def test_duplicate_set_not_sent_again():
+    """Test that an identical SET by the same key within the window returns the first one's result"""
+    service = SNMPService(mib_service=MIBService())
+    query = _set_query({"oid": "IF-MIB::ifAdminStatus.3", "value": "down"})
+
+    with patch("app.services.snmp_service.Client") as mock_client, \
+            patch("app.services.snmp_service.config.snmp.allow_set", True), \
+            patch("app.services.snmp_service.config.snmp.set_allowed_oids", WRITABLE):
+        mock_client.return_value.multiset = AsyncMock(side_effect=lambda mappings: mappings)
+        first = await service.execute_query(query)
+        second = await service.execute_query(query)
+        # The same object by its number
+        numbered = await service.execute_query(_set_query({"oid": "1.3.6.1.2.1.2.2.1.7.3", "value": "down"}))
+        other_key = await service.execute_query(query, api_key=APIKeyPolicy(name="netops"))
+
+    assert mock_client.return_value.multiset.await_count == 2
+    assert prior_write_age(numbered) is not None
+    assert second == first == other_key
+    assert prior_write_age(second) is not None and prior_write_age(other_key) is None
+    assert "An identical SET was made" in service.collect_warnings([], second)[0]
+
+
+@pytest.mark.asyncio
+@pytest.mark.parametrize("allow_set,set_value,error", [
+    (False, {"oid": "IF-MIB::ifAdminStatus.3", "value": "down"}, "SNMP SET is disabled"),
+    (True, {"oid": "IF-MIB::ifAdminStatus.3", "value": "sideways"},
+     "Invalid SET value: IF-MIB::ifAdminStatus.3 can't be set to 'sideways'"),
+    (True, {"oid": "IF-MIB::ifAdminStatus.3", "type": "OctetString", "value": "down"},
+     "Invalid SET value: IF-MIB::ifAdminStatus.3 is Integer"),
+    (True, {"oid": "IF-MIB::ifInOctets.3", "value": 0}, "Invalid SET value: IF-MIB::ifInOctets.3 is read-only"),
+])
+async def test_set_rejected_before_sending(allow_set, set_value, error):
+    """Test that disabled SETs and values not matching the object's SYNTAX are never sent"""
+    service = SNMPService(mib_service=MIBService())
+
+    with patch("app.services.snmp_service.Client") as mock_client, \
+            patch("app.services.snmp_service.config.snmp.allow_set", allow_set):
+        mock_client.return_value.multiset = AsyncMock()
+        result = await service.execute_query(_set_query(set_value))
+
+    assert result["error"].startswith(error)
+    mock_client.return_value.multiset.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_set_allowlist():
+    """Test that with SNMP_SET_ALLOWED_OIDS, a SET may only write under the listed names or subtrees"""
+    service = SNMPService(mib_service=MIBService())
+    allowed = ["IF-MIB::ifAdminStatus", "1.3.6.1.2.1.1.6"]
+
+    with patch("app.services.snmp_service.Client") as mock_client, \
+            patch("app.services.snmp_service.config.snmp.allow_set", True), \
+            patch("app.services.snmp_service.config.snmp.set_allowed_oids", allowed):
+        mock_client.return_value.multiset = AsyncMock(side_effect=lambda mappings: mappings)
+        result = await service.execute_query(_set_query({"oid": "IF-MIB::ifAdminStatus.3", "value": "down"},
+                                                         {"oid": "SNMPv2-MIB::sysLocation.0", "value": "rack 4"}))
+        assert "error" not in result
+
+        mock_client.return_value.multiset.reset_mock()
+        result = await service.execute_query(_set_query({"oid": "IF-MIB::ifAdminStatus.3", "value": "down"},
+                                                        {"oid": "SNMPv2-MIB::sysContact.0", "value": "noc"}))
+
+    assert result["error"] == ("SET of SNMPv2-MIB::sysContact.0 is not allowed on this server; "
+                               "SNMP_SET_ALLOWED_OIDS doesn't include it")
+    mock_client.return_value.multiset.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_set_denied_without_allowlist():
+    """Test that with SNMP_SET_ALLOWED_OIDS empty, no SET is sent even though SETs are enabled"""
+    service = SNMPService(mib_service=MIBService())
+
+    with patch("app.services.snmp_service.Client") as mock_client, \
+            patch("app.services.snmp_service.config.snmp.allow_set", True), \
+            patch("app.services.snmp_service.config.snmp.set_allowed_oids", []):
+        mock_client.return_value.multiset = AsyncMock()
+        result = await service.execute_query(_set_query({"oid": "SNMPv2-MIB::sysLocation.0", "value": "rack 4"}))
+
+    assert result["error"] == ("SET of SNMPv2-MIB::sysLocation.0 is not allowed on this server; "
+                               "SNMP_SET_ALLOWED_OIDS doesn't include it")
+    mock_client.return_value.multiset.assert_not_called()
+
+
+@pytest.mark.asyncio
+async def test_set_refused_reports_error_status_and_index():
+    """Test that a SET the agent refuses returns its error-status and the position of the refused varbind"""
+    service = SNMPService(mib_service=MIBService())
+    query = _set_query({"oid": "SNMPv2-MIB::sysLocation.0", "value": "rack 4"},
+                       {"oid": "IF-MIB::ifAdminStatus.3", "value": "down"})
+    refused = ErrorResponse(17, ObjectIdentifier("1.3.6.1.2.1.2.2.1.7.3"), "notWritable")
+
+    with patch("app.services.snmp_service.Client") as mock_client, \
+            patch("app.services.snmp_service.config.snmp.allow_set", True), \
+            patch("app.services.snmp_service.config.snmp.set_allowed_oids", WRITABLE):
+        mock_client.return_value.multiset = AsyncMock(side_effect=refused)
+        result = await service.execute_query(query)
+
+    assert result["error_code"] == SNMP_SET_FAILED
+    assert result["error"] == "SNMP SET failed: notWritable (error-status 17) at varbind 2 (IF-MIB::ifAdminStatus.3)"
+    assert result["set_error"] == {
+        "error_status": 17, "error_status_name": "notWritable", "error_index": 2,
+        "oid": "1.3.6.1.2.1.2.2.1.7.3", "name": "IF-MIB::ifAdminStatus.3",
+    }
+
+
+@pytest.mark.asyncio
+async def test_maintenance_mode_blocks_writes():
+    """Test that read-only maintenance rejects SETs but not reads, and "all" rejects reads too"""
+    service = SNMPService(mib_service=MIBService())
+    read = SNMPQuery(target=SNMPTarget(host="192.168.1.1"), operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"]))
+
+    with patch("app.services.snmp_service.Client") as mock_client, \
+            patch("app.services.snmp_service.config.snmp.allow_set", True), \
+            patch.object(SNMPService, "_execute_get", new=AsyncMock(return_value={"SNMPv2-MIB::sysName.0": "router1"})):
+        mock_client.return_value.multiset = AsyncMock()
+
+        service.set_maintenance_mode("read-only")
+        result = await service.execute_query(_set_query({"oid": "IF-MIB::ifAdminStatus.3", "value": "down"}))
+        assert result["error_code"] == SERVICE_READ_ONLY
+        mock_client.return_value.multiset.assert_not_called()
+        assert await service.execute_query(read) == {"SNMPv2-MIB::sysName.0": "router1"}
+
+        service.set_maintenance_mode("all")
+        assert (await service.execute_query(read))["error_code"] == SERVICE_READ_ONLY
+        SNMPService._execute_get.assert_called_once()
+
+        service.set_maintenance_mode("off")
+        assert await service.execute_query(read) == {"SNMPv2-MIB::sysName.0": "router1"}
+
+    with pytest.raises(ValueError):
+        service.set_maintenance_mode("closed")
+    assert service.maintenance_mode == "off"
+
+
+@pytest.mark.asyncio
+@pytest.mark.parametrize("host,result,outcome,target_class", [
+    ("10.0.0.1", {"SNMPv2-MIB::sysName.0": "core1"}, "success", "core"),
+    ("10.0.0.2", {"error": TIMEOUT_ERROR}, "timeout", "core"),
+    ("10.0.0.2", {"error": COMMUNITIES_FAILED_ERROR}, "timeout", "core"),
+    ("sw1.example.net", {"error": "SNMP authentication failed: unknown user name", "error_code": SNMP_AUTH_FAILED},
+     "auth-fail", "access"),
+    ("192.168.1.1", {"error": "SNMP error: tooBig"}, "other", "unclassified"),
+])
+async def test_operation_metrics_labels(host, result, outcome, target_class):
+    """Test that each SNMP operation is counted and timed by operation, outcome and target class"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(target=SNMPTarget(host=host), operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"]))
+    labels = {"operation": "GET", "outcome": outcome, "target_class": target_class}
+    count, observed = SNMP_OPERATIONS.value(**labels), SNMP_OPERATION_DURATION.count(**labels)
+
+    with patch("app.services.snmp_service.Client"), \
+            patch("app.services.snmp_service.config.snmp.preflight_dns", False), \
+            patch("app.services.snmp_service.config.snmp.target_classes",
+                  {"core": ["10.0.0.0/24"], "access": ["sw1.example.net"]}), \
+            patch.object(SNMPService, "_send_query", new=AsyncMock(return_value=result)):
+        await service.execute_query(query)
+
+    assert SNMP_OPERATIONS.value(**labels) == count + 1
+    assert SNMP_OPERATION_DURATION.count(**labels) == observed + 1
+    # Targets are labeled by class, never by address
+    assert host not in registry.render()
+
+
+@pytest.mark.asyncio
+@pytest.mark.parametrize("result,error", [
+    ({"error": TIMEOUT_ERROR}, "timeout"),
+    ({"error": "Overall walk deadline of 60s exceeded while walking 1.3.6.1.2.1.2.2 (10 values collected)"},
+     "walk-deadline"),
+    ({"error": CONNECTION_REFUSED_ERROR}, "refused"),
+    ({"error": "SNMP authentication failed: unknown user name", "error_code": SNMP_AUTH_FAILED}, "auth-fail"),
+    ({"error": "SNMP error: tooBig"}, "snmp-error"),
+    ({"SNMPv2-MIB::sysName.0": "core1"}, None),
+])
+async def test_operation_errors_counted_by_type(result, error):
+    """Test that failed SNMP operations are counted by type of error, and successful ones not at all"""
+    service = SNMPService(mib_service=MIBService())
+    query = SNMPQuery(target=SNMPTarget(host="192.168.1.1"),
+                      operation=SNMPOperation(command="GET", oids=["1.3.6.1.2.1.1.5.0"]))
+    errors = registry.render().count("snmp_errors_total")
+    labels = {"operation": "GET", "error": error, "target_class": "unclassified"}
+    count = SNMP_ERRORS.value(**labels) if error else 0
+
+    with patch("app.services.snmp_service.Client"), \
+            patch("app.services.snmp_service.config.snmp.preflight_dns", False), \
+            patch.object(SNMPService, "_send_query", new=AsyncMock(return_value=result)):
+        await service.execute_query(query)
+
+    if error:
+        assert SNMP_ERRORS.value(**labels) == count + 1
+    else:
+        assert registry.render().count("snmp_errors_total") == errors
+
+
+def test_set_values_only_for_set():
+    """Test that values to set are rejected on read commands, and a SET needs them"""
+    service = SNMPService(mib_service=MIBService())
+    read = SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="GET", set_values=[SNMPSetValue(oid="IF-MIB::ifAdminStatus.3", value=2)])
+    )
+    empty = SNMPQuery(target=SNMPTarget(host="192.168.1.1"), operation=SNMPOperation(command="SET"))
+
+    with patch("app.services.snmp_service.config.snmp.allow_set", True):
+        assert service.validate_query(read) == "Values to set are only supported for SET, not GET"
+        assert "SET needs set_values" in service.validate_query(empty)
+
+
+def test_resolve_names_before_sending():
+    """Test that a query's symbolic names resolve to numeric OIDs, and an unknown one fails naming it"""
+    service = SNMPService(mib_service=MIBService())
+
+    operation = SNMPOperation(command="GET", oids=["ifInOctets.3", "1.3.6.1.2.1.1.3.0", ".iso.3.6.1.2.1.1.5.0"])
+    assert service.resolve_names(operation) == {"ifInOctets.3": "1.3.6.1.2.1.2.2.1.10.3"}
+
+    with pytest.raises(UnresolvedNameError, match="load BGP4-MIB"):
+        service.resolve_names(SNMPOperation(command="WALK", oids=["ifDescr", "bgpPeerState"]))
@@ -0,0 +1,111 @@
+import pytest
+from unittest.mock import patch, MagicMock, AsyncMock
+
+from app.services.subscription_service import SubscriptionService, SubscriptionError, diff_results
+from app.services.snmp_service import SNMPService
+from app.services.operation_service import OperationRegistry
+from app.models.query import SNMPQuery, SNMPTarget, SNMPOperation
+
+UP = {"IF-MIB::ifOperStatus.1": 1, "IF-MIB::ifOperStatus.2": 1}
+DOWN = {"IF-MIB::ifOperStatus.1": 1, "IF-MIB::ifOperStatus.2": 2}
+
+
+def _status_query():
+    return SNMPQuery(
+        target=SNMPTarget(host="192.168.1.1"),
+        operation=SNMPOperation(command="WALK", oids=["IF-MIB::ifOperStatus"])
+    )
+
+
+def _results(*results):
+    """execute_query fake returning the given results in turn, then repeating the last"""
+    remaining = list(results)
+
+    async def execute_query(query, api_key=None):
+        return remaining.pop(0) if len(remaining) > 1 else remaining[0]
+
+    service = MagicMock(spec=SNMPService)
+    service.execute_query = AsyncMock(side_effect=execute_query)
+    return service
+
+
+async def _collect(service, **bounds):
+    with patch("app.services.subscription_service.config.subscription.min_interval", 0):
+        return [event async for event in service.subscribe(_status_query(), **bounds)]
+
+
+@pytest.mark.asyncio
+async def test_subscription_pushes_only_on_change():
+    """Test that a change is pushed once as a delta and unchanged polls push nothing"""
+    snmp_service = _results(UP, UP, UP, DOWN)
+    events = await _collect(SubscriptionService(snmp_service=snmp_service), interval=0.01, lifetime=0.2)
+
+    assert snmp_service.execute_query.call_count > 4
+    assert events == [
+        {"event": "snapshot", "data": UP},
+        {"event": "change", "data": {"changed": {"IF-MIB::ifOperStatus.2": {"old": 1, "new": 2}}}},
+        {"event": "end", "data": {"reason": "Subscription lifetime reached"}},
+    ]
+
+
+@pytest.mark.asyncio
+async def test_subscription_without_changes_pushes_snapshot_only():
+    """Test that a result that never changes is only pushed once"""
+    events = await _collect(SubscriptionService(snmp_service=_results(UP)), interval=0.01, lifetime=0.1)
+
+    assert [event["event"] for event in events] == ["snapshot", "end"]
+
+
+@pytest.mark.asyncio
+async def test_subscription_errors_and_recovery():
+    """Test that an error is pushed once and recovery sends a new snapshot"""
+    error = {"error": "SNMP request timed out"}
+    events = await _collect(
+        SubscriptionService(snmp_service=_results(UP, error, error, UP)), interval=0.01, lifetime=0.2
+    )
+
+    assert [event["event"] for event in events] == ["snapshot", "error", "snapshot", "end"]
+
+
+def test_subscription_bounds():
+    """Test that the interval and lifetime must be within the configured bounds"""
+    service = SubscriptionService(snmp_service=MagicMock(spec=SNMPService))
+
+    with patch("app.services.subscription_service.config.subscription.min_interval", 5), \
+            patch("app.services.subscription_service.config.subscription.max_interval", 600), \
+            patch("app.services.subscription_service.config.subscription.max_lifetime", 3600):
+        assert service.check_bounds(10, 60) == {"interval": 10, "lifetime": 60}
+        assert service.check_bounds(None, None)["lifetime"] == 3600
+        for interval, lifetime in ((1, 60), (601, 60), (10, 0), (10, 3601)):
+            with pytest.raises(SubscriptionError):
+                service.check_bounds(interval, lifetime)
+
+
+def test_diff_results():
+    """Test that changed, added and removed values are reported"""
+    assert diff_results(UP, UP) == {}
+    assert diff_results(UP, {"IF-MIB::ifOperStatus.1": 2, "IF-MIB::ifOperStatus.3": 1}) == {
+        "changed": {"IF-MIB::ifOperStatus.1": {"old": 1, "new": 2}},
+        "added": {"IF-MIB::ifOperStatus.3": 1},
+        "removed": ["IF-MIB::ifOperStatus.2"],
+    }
+
+
+@pytest.mark.asyncio
+async def test_subscription_cancelled():
+    """Test that cancelling the subscription's operation ends the stream early"""
+    registry = OperationRegistry()
+    operation = registry.start("subscription", "ifOperStatus")
+    service = SubscriptionService(snmp_service=_results(UP))
+
+    events = []
+    with patch("app.services.subscription_service.config.subscription.min_interval", 0):
+        async for event in service.subscribe(_status_query(), interval=0.05, lifetime=60, operation=operation):
+            events.append(event)
+            if event["event"] == "snapshot":
+                registry.cancel(operation.id)
+
+    assert events == [
+        {"event": "snapshot", "data": UP},
+        {"event": "end", "data": {"reason": "Subscription cancelled"}},
+    ]
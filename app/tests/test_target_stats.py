@@ -0,0 +1,74 @@
+from app.utils.target_stats import TargetStats, percentile
+
+
+def test_aggregate_stats_from_outcomes():
+    """Test that recorded outcomes give the success rate, latency percentiles and last error"""
+    stats = TargetStats(window=3600, max_targets=10, max_samples=100)
+    for number in range(1, 21):
+        stats.record("10.0.0.1:161", number / 100, now=1000 + number)
+    stats.record("10.0.0.1:161", 5.0, "SNMP request timed out", now=1030)
+    stats.record("10.0.0.1:161", 5.0, "SNMP request timed out", now=1040)
+    stats.record("10.0.0.2:161", 0.02, now=1050)
+
+    first, second = stats.stats(now=1060)
+
+    assert first["target"] == "10.0.0.1:161"
+    assert first["requests"] == 22
+    assert first["successes"] == 20
+    assert first["failures"] == 2
+    assert first["success_rate"] == 0.9091
+    assert first["latency_p50_ms"] == 100.0
+    assert first["latency_p95_ms"] == 190.0
+    assert first["last_error"] == "SNMP request timed out"
+    assert first["last_error_at"] == "1970-01-01T00:17:20+00:00"
+    assert first["last_seen_at"] == "1970-01-01T00:17:20+00:00"
+
+    assert second == {
+        "target": "10.0.0.2:161", "requests": 1, "successes": 1, "failures": 0, "success_rate": 1.0,
+        "latency_p50_ms": 20.0, "latency_p95_ms": 20.0, "last_error": None, "last_error_at": None,
+        "last_seen_at": "1970-01-01T00:17:30+00:00",
+    }
+
+
+def test_only_failures_have_no_latency():
+    """Test that a target that never answered has no latency percentiles"""
+    stats = TargetStats(window=60, max_targets=10, max_samples=10)
+    stats.record("10.0.0.1:161", 5.0, "Connection refused", now=100)
+
+    assert stats.stats(now=100)[0]["success_rate"] == 0.0
+    assert stats.stats(now=100)[0]["latency_p50_ms"] is None
+
+
+def test_rolling_window_and_sample_limit():
+    """Test that outcomes older than the window, or beyond the sample limit, no longer count"""
+    stats = TargetStats(window=60, max_targets=10, max_samples=3)
+    stats.record("10.0.0.1:161", 0.5, "SNMP request timed out", now=100)
+    stats.record("10.0.0.1:161", 0.01, now=150)
+
+    assert stats.stats(now=155)[0]["failures"] == 1
+    assert stats.stats(now=170)[0]["failures"] == 0
+    assert stats.stats(now=170)[0]["requests"] == 1
+
+    for at in range(171, 176):
+        stats.record("10.0.0.1:161", 0.01, now=at)
+    assert stats.stats(now=176)[0]["requests"] == 3
+
+
+def test_stale_targets_expire_and_cardinality_bounded():
+    """Test that idle targets expire and the least recently queried one is evicted past the limit"""
+    stats = TargetStats(window=60, max_targets=2, max_samples=10)
+    stats.record("10.0.0.1:161", 0.01, now=100)
+    stats.record("10.0.0.2:161", 0.01, now=101)
+    stats.record("10.0.0.1:161", 0.01, now=102)
+    stats.record("10.0.0.3:161", 0.01, now=103)
+
+    assert {item["target"] for item in stats.stats(now=104)} == {"10.0.0.1:161", "10.0.0.3:161"}
+    assert stats.stats(now=200) == []
+
+
+def test_percentile_nearest_rank():
+    """Test nearest-rank percentiles of sorted values"""
+    assert percentile([], 0.5) is None
+    assert percentile([3.0], 0.95) == 3.0
+    assert percentile([1.0, 2.0, 3.0, 4.0], 0.5) == 2.0
+    assert percentile([1.0, 2.0, 3.0, 4.0], 0.95) == 4.0
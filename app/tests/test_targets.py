@@ -0,0 +1,178 @@
+import os
+import pytest
+from unittest.mock import patch
+from pydantic import ValidationError
+
+from app.core.config import TargetCredentials, _load_target_communities, _load_target_credentials
+from app.models.query import SNMPTarget, MultiTargetQuery
+from app.utils.targets import (
+    TargetError, UNCLASSIFIED, split_target, parse_target, format_target, match_target, target_class
+)
+
+
+@pytest.mark.parametrize("target,expected", [
+    ("10.0.0.1", ("10.0.0.1", 161)),
+    ("10.0.0.1:1161", ("10.0.0.1", 1161)),
+    (" 10.0.0.1:1161 ", ("10.0.0.1", 1161)),
+    ("Switch1.Example.COM", ("switch1.example.com", 161)),
+    ("switch1.example.com.:162", ("switch1.example.com", 162)),
+    ("core_sw-01", ("core_sw-01", 161)),
+    ("2001:db8::1", ("2001:db8::1", 161)),
+    ("2001:0db8:0:0:0:0:0:1", ("2001:db8::1", 161)),
+    ("::1", ("::1", 161)),
+    ("[2001:db8::1]", ("2001:db8::1", 161)),
+    ("[2001:DB8:0::1]:1161", ("2001:db8::1", 1161)),
+    # Without brackets, a trailing group is part of the address rather than a port
+    ("2001:db8::1:161", ("2001:db8::1:161", 161)),
+    ("host:65535", ("host", 65535)),
+    ("host:1", ("host", 1)),
+])
+def test_parse_target(target, expected):
+    """Test that hosts are normalized and ports taken from the target or defaulted"""
+    assert parse_target(target) == expected
+
+
+def test_parse_target_default_port():
+    """Test that the default port only applies when the target doesn't name one"""
+    assert parse_target("10.0.0.1", default_port=1161) == ("10.0.0.1", 1161)
+    assert parse_target("10.0.0.1:162", default_port=1161) == ("10.0.0.1", 162)
+    assert split_target("10.0.0.1") == ("10.0.0.1", None)
+    assert split_target("[::1]") == ("::1", None)
+
+
+@pytest.mark.parametrize("target", [
+    "",
+    "   ",
+    ":161",
+    "10.0.0.1:",
+    "10.0.0.1:0",
+    "10.0.0.1:65536",
+    "10.0.0.1:-1",
+    "10.0.0.1:+161",
+    "10.0.0.1:16a",
+    "10.0.0.1:١٦١",
+    "10.0.0.1:161:162",
+    "10.0.0.256",
+    "1.2.3",
+    "2001:db8::zz",
+    "[2001:db8::1",
+    "[2001:db8::1]161",
+    "[2001:db8::1]:",
+    "[2001:db8::1]:99999",
+    "[10.0.0.1]:161",
+    "[]:161",
+    "host name",
+    "host/24",
+    "-host",
+    "host-",
+    "a..b",
+    "http://10.0.0.1",
+    "a" * 64,
+    ".".join(["a" * 60] * 5),
+])
+def test_parse_target_rejects_malformed(target):
+    """Test that malformed hosts and invalid ports are rejected"""
+    with pytest.raises(TargetError):
+        parse_target(target)
+
+
+def test_parse_target_rejects_non_string():
+    """Test that a missing target is rejected"""
+    with pytest.raises(TargetError):
+        parse_target(None)
+
+
+def test_format_target():
+    """Test that formatted targets parse back to the same host and port"""
+    assert format_target("10.0.0.1", 161) == "10.0.0.1:161"
+    assert format_target("2001:db8::1", 1161) == "[2001:db8::1]:1161"
+    for target in ("10.0.0.1:161", "[2001:db8::1]:1161", "switch1:162"):
+        assert parse_target(format_target(*parse_target(target))) == parse_target(target)
+
+
+def test_snmp_target_takes_port_from_host():
+    """Test that the query target model splits host:port and normalizes the host"""
+    target = SNMPTarget(host="[2001:DB8::1]:1161")
+    assert (target.host, target.port) == ("2001:db8::1", 1161)
+
+    # A port in the host is more specific than the port field
+    target = SNMPTarget(host="10.0.0.1:1161", port=161)
+    assert (target.host, target.port) == ("10.0.0.1", 1161)
+
+    target = SNMPTarget(host="Switch1", port=162)
+    assert (target.host, target.port) == ("switch1", 162)
+
+    for invalid in ({"host": "10.0.0.1:70000"}, {"host": "10.0.0.1", "port": 0}, {"host": "bad host"}):
+        with pytest.raises(ValidationError):
+            SNMPTarget(**invalid)
+
+
+def test_multi_target_query_rejects_malformed_targets():
+    """Test that every target of a multi-target query must parse"""
+    assert MultiTargetQuery(query="uptime", targets=["10.0.0.1", "[::1]:1161"]).targets == ["10.0.0.1", "[::1]:1161"]
+    with pytest.raises(ValidationError):
+        MultiTargetQuery(query="uptime", targets=["10.0.0.1", "10.0.0.2:0"])
+
+
+def test_target_communities_keys_are_normalized():
+    """Test that configured community keys use the same host parsing as queries"""
+    value = '{"Switch1": ["a"], "[2001:DB8:0::1]:1161": "b", "10.0.0.1:162": ["c"]}'
+    with patch.dict(os.environ, {"SNMP_TARGET_COMMUNITIES": value}):
+        assert _load_target_communities() == {
+            "switch1": ["a"],
+            "[2001:db8::1]:1161": ["b"],
+            "10.0.0.1:162": ["c"],
+        }
+
+    with patch.dict(os.environ, {"SNMP_TARGET_COMMUNITIES": '{"10.0.0.1:0": ["a"]}'}):
+        with pytest.raises(TargetError):
+            _load_target_communities()
+
+
+def test_target_credentials_keys_are_normalized():
+    """Test that credential keys may be hosts, targets, networks or the default, normalized as queries are"""
+    value = ('{"Switch1": {"community": "a"}, "10.0.0.1:162": {"community": "b", "communities": ["c"]}, '
+             '"10.1.2.3/16": {"version": "3", "username": "monitor"}, "DEFAULT": {"communities": ["d"]}}')
+    with patch.dict(os.environ, {"SNMP_TARGET_CREDENTIALS": value}):
+        assert _load_target_credentials() == {
+            "switch1": TargetCredentials(communities=["a"]),
+            "10.0.0.1:162": TargetCredentials(communities=["b", "c"]),
+            "10.1.0.0/16": TargetCredentials(version="3", username="monitor"),
+            "default": TargetCredentials(communities=["d"]),
+        }
+
+    for value in ('{"10.0.0.0/33": {}}', '{"10.0.0.1": "public"}'):
+        with patch.dict(os.environ, {"SNMP_TARGET_CREDENTIALS": value}):
+            with pytest.raises(ValueError):
+                _load_target_credentials()
+
+
+def test_match_target_most_specific_entry():
+    """Test that a target gets the entry of its port, host, smallest network, or else the default"""
+    entries = {
+        "10.0.0.1:1161": "port", "10.0.0.1": "host", "10.0.0.0/24": "subnet", "10.0.0.0/8": "network",
+        "2001:db8::/32": "v6", "default": "default",
+    }
+
+    assert match_target("10.0.0.1", 1161, entries) == "port"
+    assert match_target("10.0.0.1", 161, entries) == "host"
+    assert match_target("10.0.0.7", 161, entries) == "subnet"
+    assert match_target("10.9.0.7", 161, entries) == "network"
+    assert match_target("2001:db8::1", 161, entries) == "v6"
+    assert match_target("192.168.1.1", 161, entries) == "default"
+    assert match_target("switch1.example.net", 161, entries) == "default"
+    # A name is placed in networks by the addresses it resolves to
+    assert match_target("switch1.example.net", 161, entries, ["192.168.1.1", "10.0.0.7"]) == "subnet"
+    assert match_target("192.168.1.1", 161, {"10.0.0.0/8": "network"}) is None
+
+
+def test_target_class():
+    """Test targets are classed by host or network, first class first, and unclassified otherwise"""
+    classes = {"core": ["10.0.0.0/24", "core1.example.net"], "lab": ["10.0.0.0/8", "2001:db8::/32"]}
+
+    assert target_class("10.0.0.7", classes) == "core"
+    assert target_class("10.9.0.7", classes) == "lab"
+    assert target_class("CORE1.example.net", classes) == "core"
+    assert target_class("[2001:db8::1]", classes) == "lab"
+    assert target_class("192.168.1.1", classes) == UNCLASSIFIED
+    assert target_class("access1.example.net", classes) == UNCLASSIFIED
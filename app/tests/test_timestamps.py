@@ -0,0 +1,60 @@
+import pytest
+from datetime import datetime, timezone, timedelta
+
+from app.utils.timestamps import decode_date_and_time, parse_timezone, format_timestamp, reformat_timestamp
+
+# 2024-03-10 22:30:15.5 at UTC-05:00
+DATE_AND_TIME_WITH_OFFSET = bytes([0x07, 0xE8, 3, 10, 22, 30, 15, 5, ord("-"), 5, 0])
+
+
+def test_decode_date_and_time_with_offset():
+    """Test that a DateAndTime with its own UTC offset keeps that offset"""
+    value = decode_date_and_time(DATE_AND_TIME_WITH_OFFSET)
+
+    assert value.isoformat() == "2024-03-10T22:30:15.500000-05:00"
+    assert value.astimezone(timezone.utc).isoformat() == "2024-03-11T03:30:15.500000+00:00"
+
+
+def test_decode_date_and_time_without_offset():
+    """Test that the 8-octet form is taken as UTC and invalid values aren't decoded"""
+    assert decode_date_and_time(bytes([0x07, 0xE8, 1, 2, 3, 4, 5, 0])).isoformat() == "2024-01-02T03:04:05+00:00"
+    assert decode_date_and_time(bytes([0x07, 0xE8, 13, 2, 3, 4, 5, 0])) is None
+    assert decode_date_and_time(b"router1") is None
+    assert decode_date_and_time(12345) is None
+
+
+@pytest.mark.parametrize("tz,expected", [
+    ("UTC", "2024-03-11T03:30:15.500000+00:00"),
+    ("+05:30", "2024-03-11T09:00:15.500000+05:30"),
+    ("-0800", "2024-03-10T19:30:15.500000-08:00"),
+    ("Asia/Tokyo", "2024-03-11T12:30:15.500000+09:00"),
+    ("Europe/Berlin", "2024-03-11T04:30:15.500000+01:00"),
+])
+def test_format_timestamp_across_timezones(tz, expected):
+    """Test that timestamps are converted to the requested timezone"""
+    value = decode_date_and_time(DATE_AND_TIME_WITH_OFFSET)
+
+    assert format_timestamp(value, parse_timezone(tz)) == expected
+
+
+def test_format_timestamp_formats():
+    """Test the RFC 3339 default, epoch seconds and strftime patterns"""
+    value = datetime(2024, 1, 2, 3, 4, 5, tzinfo=timezone(timedelta(hours=2)))
+
+    assert format_timestamp(value) == "2024-01-02T03:04:05+02:00"
+    assert format_timestamp(value, time_format="unix") == 1704157445.0
+    assert format_timestamp(value, parse_timezone("UTC"), "%d/%m/%Y %H:%M %Z") == "02/01/2024 01:04 UTC"
+
+
+def test_reformat_timestamp():
+    """Test that only timestamp strings are reformatted"""
+    assert reformat_timestamp("2024-01-02T03:04:05+00:00", parse_timezone("+01:00")) == "2024-01-02T04:04:05+01:00"
+    assert reformat_timestamp("router1", parse_timezone("UTC")) == "router1"
+    assert reformat_timestamp(None, parse_timezone("UTC")) is None
+
+
+def test_parse_timezone_invalid():
+    """Test that unknown timezones and bad offsets are rejected"""
+    for name in ("Mars/Olympus", "+25:00", "+5"):
+        with pytest.raises(ValueError):
+            parse_timezone(name)
@@ -0,0 +1,30 @@
+from unittest.mock import patch
+
+from app.utils.timing import StageTimer
+
+
+def test_stage_timer_attributes_time_between_marks():
+    """Test that each stage gets the time since the previous mark and the stages add up to the total"""
+    clock = iter([10.0, 10.5, 10.75, 12.0])
+    with patch("app.utils.timing.time.perf_counter", side_effect=lambda: next(clock)):
+        timer = StageTimer()
+        timer.mark("interpretation")
+        timer.mark("snmp")
+        timer.mark("enrichment")
+
+    assert timer.timings() == {"interpretation": 500.0, "snmp": 250.0, "enrichment": 1250.0, "total": 2000.0}
+
+
+def test_stage_timer_accumulates_repeated_stages():
+    """Test that marking a stage again adds to its time"""
+    clock = iter([0.0, 0.1, 0.3, 0.6])
+    with patch("app.utils.timing.time.perf_counter", side_effect=lambda: next(clock)):
+        timer = StageTimer()
+        timer.mark("snmp")
+        timer.mark("enrichment")
+        timer.mark("snmp")
+
+    timings = timer.timings()
+    assert timings["snmp"] == 400.0
+    assert timings["enrichment"] == 200.0
+    assert timings["total"] == 600.0
@@ -0,0 +1,73 @@
+import pytest
+
+from app.utils.value_filters import FilterError, MAX_VALUE_FILTERS, numeric_value, parse_value_filters, passes_filters
+
+RESULTS = [
+    {"oid": "1.3.6.1.2.1.2.2.1.14.1", "name": "IF-MIB::ifInErrors.1", "value": 0},
+    {"oid": "1.3.6.1.2.1.2.2.1.14.2", "name": "IF-MIB::ifInErrors.2", "value": 5},
+    {"oid": "1.3.6.1.2.1.2.2.1.14.3", "name": "IF-MIB::ifInErrors.3", "value": "12"},
+    {"oid": "1.3.6.1.2.1.2.2.1.14.4", "name": "IF-MIB::ifInErrors.4", "value": "n/a"},
+    {"oid": "1.3.6.1.2.1.2.2.1.14.5", "name": "IF-MIB::ifInErrors.5", "value": None},
+    {"oid": "1.3.6.1.2.1.2.2.1.14.6", "name": "IF-MIB::ifInErrors.6", "value": True},
+    {"oid": "1.3.6.1.2.1.1.5.0", "name": "SNMPv2-MIB::sysName.0", "value": "router1"},
+]
+
+
+def kept(spec):
+    filters = parse_value_filters([spec])
+    return [result["name"] for result in RESULTS if passes_filters(result, filters)]
+
+
+@pytest.mark.parametrize("spec,indexes", [
+    ("ifInErrors>0", [2, 3]),
+    ("ifInErrors<5", [1]),
+    ("ifInErrors>=5", [2, 3]),
+    ("ifInErrors<=5", [1, 2]),
+    ("ifInErrors==12", [3]),
+    ("ifInErrors!=0", [2, 3]),
+])
+def test_each_operator_on_mixed_results(spec, indexes):
+    """Test each operator keeps the comparable values it matches, drops the rest of its field and keeps other fields"""
+    assert kept(spec) == [f"IF-MIB::ifInErrors.{index}" for index in indexes] + ["SNMPv2-MIB::sysName.0"]
+
+
+def test_field_forms():
+    """Test filters naming a field by qualified name, name with index and numeric OID"""
+    assert kept("IF-MIB::ifInErrors > 0") == kept("ifInErrors>0")
+    assert kept("ifInErrors.2>10") == [result["name"] for result in RESULTS if result["name"] != "IF-MIB::ifInErrors.2"]
+    assert kept(".1.3.6.1.2.1.2.2.1.14>0") == kept("ifInErrors>0")
+    # A prefix of another object's name doesn't match it
+    assert kept("ifIn>0") == [result["name"] for result in RESULTS]
+
+
+def test_filters_combine():
+    """Test several filters of a field must all match"""
+    filters = parse_value_filters(["ifInErrors>0", "ifInErrors<10"])
+
+    assert [result["name"] for result in RESULTS if passes_filters(result, filters)] == [
+        "IF-MIB::ifInErrors.2", "SNMPv2-MIB::sysName.0"
+    ]
+
+
+def test_numeric_value():
+    """Test coercion of result values to numbers"""
+    assert numeric_value(3) == 3.0
+    assert numeric_value(" 2.5 ") == 2.5
+    assert numeric_value("-1") == -1.0
+    assert numeric_value(True) is None
+    assert numeric_value("nan") is None
+    assert numeric_value("up") is None
+    assert numeric_value(b"\x01") is None
+
+
+@pytest.mark.parametrize("spec", ["ifInErrors", "ifInErrors>", ">0", "ifInErrors=>0", "ifInErrors>up", "ifInErrors>inf"])
+def test_rejects_malformed_filters(spec):
+    """Test filters that aren't field<operator>number are rejected"""
+    with pytest.raises(FilterError):
+        parse_value_filters([spec])
+
+
+def test_rejects_too_many_filters():
+    """Test the number of filters is capped"""
+    with pytest.raises(FilterError):
+        parse_value_filters(["ifInErrors>0"] * (MAX_VALUE_FILTERS + 1))
@@ -0,0 +1,108 @@
+import asyncio
+
+import pytest
+
+from app.models.query import SNMPQuery, SNMPTarget, SNMPOperation, SNMPSetValue
+from app.services.write_dedup import WriteDeduplicator, prior_write_age, write_key
+
+
+def _key(identity="netops", value="down", host="10.0.0.1", oid="IF-MIB::ifAdminStatus.3", resolve=None):
+    return write_key(identity, SNMPQuery(
+        target=SNMPTarget(host=host),
+        operation=SNMPOperation(command="SET", set_values=[SNMPSetValue(oid=oid, value=value)])
+    ), resolve=resolve)
+
+
+class Writes:
+    """Counts the writes made, answering each with result"""
+
+    def __init__(self, result=None):
+        self.result = result or {"IF-MIB::ifAdminStatus.3": 2}
+        self.made = 0
+
+    async def __call__(self):
+        self.made += 1
+        await asyncio.sleep(0)
+        return dict(self.result)
+
+
+@pytest.mark.asyncio
+async def test_duplicate_within_window_returns_prior_result():
+    """Test that an identical write within the window gets the first one's result without writing"""
+    dedup = WriteDeduplicator(window=5)
+    write = Writes()
+
+    first = await dedup.run(_key(), write, now=100)
+    second = await dedup.run(_key(), write, now=102)
+
+    assert write.made == 1
+    assert second == first
+    assert prior_write_age(first) is None
+    assert prior_write_age(second) == 2
+
+
+@pytest.mark.asyncio
+async def test_duplicate_after_window_is_written():
+    """Test that the same write after the window, or a different one within it, is made"""
+    dedup = WriteDeduplicator(window=5)
+    write = Writes()
+
+    await dedup.run(_key(), write, now=100)
+    await dedup.run(_key(value="up"), write, now=101)
+    await dedup.run(_key(identity="other"), write, now=101)
+    await dedup.run(_key(host="10.0.0.2"), write, now=101)
+    again = await dedup.run(_key(), write, now=105)
+
+    assert write.made == 5
+    assert prior_write_age(again) is None
+
+
+@pytest.mark.asyncio
+async def test_duplicate_of_write_in_flight_shares_it():
+    """Test that a double-click while the first write is in flight waits for it instead of writing"""
+    dedup = WriteDeduplicator(window=5)
+    write = Writes()
+
+    first, second = await asyncio.gather(dedup.run(_key(), write, now=100), dedup.run(_key(), write, now=100))
+
+    assert write.made == 1
+    assert first == second
+    assert prior_write_age(second) == 0
+
+
+@pytest.mark.asyncio
+async def test_failed_write_not_remembered():
+    """Test that a write that failed is made again when retried"""
+    dedup = WriteDeduplicator(window=5)
+    write = Writes({"error": "SNMP request timed out", "error_code": "SNMP_TIMEOUT"})
+
+    await dedup.run(_key(), write, now=100)
+    retried = await dedup.run(_key(), write, now=101)
+
+    assert write.made == 2
+    assert prior_write_age(retried) is None
+
+
+@pytest.mark.asyncio
+async def test_duplicate_of_failed_write_in_flight_is_made():
+    """Test that a double-click waiting for a write that fails makes its own write, not returning the failure"""
+    dedup = WriteDeduplicator(window=5)
+    write = Writes({"error": "SNMP request timed out", "error_code": "SNMP_TIMEOUT"})
+
+    first, second = await asyncio.gather(dedup.run(_key(), write, now=100), dedup.run(_key(), write, now=100))
+
+    assert write.made == 2
+    assert prior_write_age(first) is None and prior_write_age(second) is None
+
+
+def test_write_key_compares_resolved_oids():
+    """Test that the same object named or numbered, with or without a leading dot, makes the same write"""
+    names = {"sysName.0": "1.3.6.1.2.1.1.5.0", "SNMPv2-MIB::sysName.0": "1.3.6.1.2.1.1.5.0"}
+
+    def resolve(oid):
+        return names.get(oid, oid.lstrip("."))
+
+    keys = {_key(oid=oid, resolve=resolve) for oid in ("sysName.0", "SNMPv2-MIB::sysName.0", "1.3.6.1.2.1.1.5.0",
+                                                        ".1.3.6.1.2.1.1.5.0")}
+    assert len(keys) == 1
+    assert _key(oid=".1.3.6.1.2.1.1.5.0") == _key(oid="1.3.6.1.2.1.1.5.0")
@@ -0,0 +1,141 @@
+from typing import List, Tuple
+
+# Universal tags
+INTEGER = 0x02
+OCTET_STRING = 0x04
+NULL = 0x05
+OBJECT_IDENTIFIER = 0x06
+SEQUENCE = 0x30
+
+# SNMP application tags (RFC 2578)
+IP_ADDRESS = 0x40
+COUNTER32 = 0x41
+GAUGE32 = 0x42
+TIME_TICKS = 0x43
+OPAQUE = 0x44
+COUNTER64 = 0x46
+
+# Varbind exceptions (RFC 3416)
+NO_SUCH_OBJECT = 0x80
+NO_SUCH_INSTANCE = 0x81
+END_OF_MIB_VIEW = 0x82
+
+# PDU types
+GET_REQUEST = 0xA0
+GET_NEXT_REQUEST = 0xA1
+GET_RESPONSE = 0xA2
+SET_REQUEST = 0xA3
+GET_BULK_REQUEST = 0xA5
+
+# Tags whose value is an integer
+INTEGER_TAGS = (INTEGER, COUNTER32, GAUGE32, TIME_TICKS, COUNTER64)
+
+
+def encode_length(length: int) -> bytes:
+    """Encode a BER length, in the short form when it fits"""
+    if length < 0x80:
+        return bytes([length])
+    data = length.to_bytes((length.bit_length() + 7) // 8, "big")
+    return bytes([0x80 | len(data)]) + data
+
+
+def encode_tlv(tag: int, content: bytes) -> bytes:
+    """Encode a tag-length-value element"""
+    return bytes([tag]) + encode_length(len(content)) + content
+
+
+def encode_integer(value: int, tag: int = INTEGER) -> bytes:
+    """Encode an integer as a minimal two's complement value (unsigned types never need the sign)"""
+    size = max(1, (value.bit_length() + 8) // 8)
+    return encode_tlv(tag, value.to_bytes(size, "big", signed=True))
+
+
+def encode_oid(oid: str) -> bytes:
+    """Encode a dotted numeric OID"""
+    parts = [int(part) for part in oid.strip(".").split(".")]
+    if len(parts) < 2:
+        raise ValueError(f"OID needs at least two sub-identifiers: {oid}")
+
+    content = bytearray()
+    for number in [parts[0] * 40 + parts[1]] + parts[2:]:
+        chunk = [number & 0x7F]
+        number >>= 7
+        while number:
+            chunk.append(0x80 | (number & 0x7F))
+            number >>= 7
+        content += bytes(reversed(chunk))
+    return encode_tlv(OBJECT_IDENTIFIER, bytes(content))
+
+
+def encode_sequence(items: List[bytes], tag: int = SEQUENCE) -> bytes:
+    """Encode already encoded elements as a sequence (or a PDU, given its tag)"""
+    return encode_tlv(tag, b"".join(items))
+
+
+def decode_tlv(data: bytes, offset: int = 0) -> Tuple[int, bytes, int]:
+    """
+    Decode the tag-length-value element at an offset.
+
+    Returns:
+        (tag, content, offset just past the element)
+    """
+    if offset + 2 > len(data):
+        raise ValueError("Truncated BER element")
+
+    tag = data[offset]
+    length = data[offset + 1]
+    offset += 2
+    if length & 0x80:
+        size = length & 0x7F
+        if not size or offset + size > len(data):
+            raise ValueError("Invalid BER length")
+        length = int.from_bytes(data[offset:offset + size], "big")
+        offset += size
+
+    if offset + length > len(data):
+        raise ValueError("Truncated BER element")
+    return tag, data[offset:offset + length], offset + length
+
+
+def decode_sequence(content: bytes) -> List[Tuple[int, bytes]]:
+    """Decode the elements of a sequence's content as (tag, content) pairs"""
+    items = []
+    offset = 0
+    while offset < len(content):
+        tag, item, offset = decode_tlv(content, offset)
+        items.append((tag, item))
+    return items
+
+
+def decode_integer(content: bytes) -> int:
+    """Decode a two's complement integer"""
+    if not content:
+        raise ValueError("Empty BER integer")
+    return int.from_bytes(content, "big", signed=True)
+
+
+def decode_unsigned(content: bytes) -> int:
+    """Decode an unsigned integer (Counter32, Gauge32, TimeTicks, Counter64)"""
+    if not content:
+        raise ValueError("Empty BER integer")
+    return int.from_bytes(content, "big")
+
+
+def decode_oid(content: bytes) -> str:
+    """Decode an OID into its dotted numeric form"""
+    if not content:
+        raise ValueError("Empty BER OID")
+
+    numbers = []
+    number = 0
+    for byte in content:
+        number = (number << 7) | (byte & 0x7F)
+        if not byte & 0x80:
+            numbers.append(number)
+            number = 0
+    if content[-1] & 0x80:
+        raise ValueError("Truncated BER OID")
+
+    first = numbers[0]
+    head = [min(first // 40, 2), first - 40 * min(first // 40, 2)]
+    return ".".join(str(part) for part in head + numbers[1:])
@@ -1,11 +1,13 @@
+import json
 import time
 from typing import Dict, Any, Optional, Tuple
+from loguru import logger
 
 from app.core.config import config
 
 # In-memory cache storage
-# Structure: {key: (value, timestamp, ttl)}
-_cache: Dict[str, Tuple[Any, float, int]] = {}
+# Structure: {key: (value, timestamp, ttl, seconds kept past the ttl as a last-known-good value)}
+_cache: Dict[str, Tuple[Any, float, int, int]] = {}
 
 # Last time the cache was cleaned up
 _last_cleanup = time.time()
@@ -21,40 +23,102 @@ def get_cache(key: str) -> Optional[Any]:
     Returns:
         Cached value or None if not found or expired
     """
+    entry = get_cache_entry(key)
+    return entry[0] if entry else None
+
+
+def get_cache_entry(key: str, max_age: Optional[float] = None,
+                    allow_stale: bool = False) -> Optional[Tuple[Any, float]]:
+    """
+    Get a value from the cache along with the time it was cached.
+
+    Args:
+        key: Cache key
+        max_age: If provided, entries cached more than this many seconds ago are treated as missing
+        allow_stale: Also return expired entries still within their stale window (ignores max_age)
+
+    Returns:
+        Tuple of (value, timestamp) or None if not found, expired or older than max_age
+    """
     if not config.cache_enabled:
         return None
 
     if key not in _cache:
         return None
 
-    value, timestamp, ttl = _cache[key]
+    value, timestamp, ttl, stale_ttl = _cache[key]
 
     # Check if cache entry has expired
-    if time.time() - timestamp > ttl:
-        # Expired, remove from cache
+    age = time.time() - timestamp
+    if age > ttl + stale_ttl:
+        # Past the stale window too, remove from cache
         del _cache[key]
         return None
 
+    if allow_stale:
+        return value, timestamp
+
+    if age > ttl:
+        # Expired, kept only as a last-known-good value
+        return None
+
     # Periodically clean up expired entries
     _maybe_cleanup_cache()
 
-    return value
+    # Too old for this caller, but still valid for others
+    if max_age is not None and age > max_age:
+        return None
+
+    return value, timestamp
 
 
-def set_cache(key: str, value: Any, ttl: Optional[int] = None) -> None:
+def entry_size(value: Any) -> int:
+    """Approximate size of a cache value in bytes, as encoded to JSON"""
+    return len(json.dumps(value, default=str).encode())
+
+
+def set_cache(key: str, value: Any, ttl: Optional[int] = None, stale_ok: bool = False) -> bool:
     """
     Set a value in the cache.
 
+    Values larger than CACHE_MAX_ENTRY_SIZE bytes are not cached, so one outlier
+    (e.g. a huge walk) can't take up the cache's memory.
+
     Args:
         key: Cache key
         value: Value to cache
         ttl: Time to live in seconds (overrides global config)
+        stale_ok: Keep the value for CACHE_STALE_TTL seconds after it expires, for
+            get_cache_entry(allow_stale=True); other values are dropped once expired
+
+    Returns:
+        False if the value was too large to cache, True otherwise
     """
     if not config.cache_enabled:
-        return
+        return True
+
+    if config.cache_max_entry_size:
+        size = entry_size(value)
+        if size > config.cache_max_entry_size:
+            logger.warning(f"Not caching {key}: {size} bytes is over the "
+                           f"{config.cache_max_entry_size} byte limit (CACHE_MAX_ENTRY_SIZE)")
+            # Don't keep serving an older value of the key
+            _cache.pop(key, None)
+            return False
 
     # Use provided TTL or default from config
-    _cache[key] = (value, time.time(), ttl or config.cache_ttl)
+    _cache[key] = (value, time.time(), ttl or config.cache_ttl, config.cache_stale_ttl if stale_ok else 0)
+    return True
+
+
+def delete_cache(key: str) -> None:
+    """
+    Remove a single cache entry, if present.
+
+    Args:
+        key: Cache key
+    """
+    _cache.pop(key, None)
 
 
 def clear_cache(key_prefix: Optional[str] = None) -> None:
@@ -88,10 +152,10 @@ def _maybe_cleanup_cache() -> None:
 
     now = time.time()
     if now - _last_cleanup > CLEANUP_INTERVAL:
-        # Find all expired keys
+        # Find all keys expired beyond the stale window
         expired_keys = []
-        for key, (_, timestamp, ttl) in _cache.items():
-            if now - timestamp > ttl:
+        for key, (_, timestamp, ttl, stale_ttl) in _cache.items():
+            if now - timestamp > ttl + stale_ttl:
                 expired_keys.append(key)
 
         # Remove expired keys
@@ -117,12 +181,12 @@ def get_cache_stats() -> Dict[str, Any]:
         }
 
     now = time.time()
-    expired_count = sum(1 for _, timestamp, ttl in _cache.values() if now - timestamp > ttl)
+    expired_count = sum(1 for _, timestamp, ttl, _ in _cache.values() if now - timestamp > ttl)
 
     # Rough estimate of memory usage (key size + value size)
     memory_usage = sum(
         len(key) + len(str(value))
-        for key, (value, _, _) in _cache.items()
+        for key, (value, _, _, _) in _cache.items()
     )
 
     return {
@@ -0,0 +1,56 @@
+from typing import Any, Callable, Dict, List, Optional
+
+SYS_SERVICES = "1.3.6.1.2.1.1.7.0"
+
+# sysServices bit values (RFC 1213, RFC 3418): layer L sets bit 2^(L-1)
+SYS_SERVICES_LAYERS = {
+    1: "physical",
+    2: "datalink",
+    4: "internet",
+    8: "end-to-end",
+    16: "session",
+    32: "presentation",
+    64: "application",
+}
+
+
+def decode_sys_services(value: Any) -> Optional[List[str]]:
+    """
+    Decode a sysServices value into the layers the device offers services at.
+
+    Args:
+        value: sysServices value, e.g. 72 for a host (end-to-end and application)
+
+    Returns:
+        Layer names from the lowest layer up, or None if the value isn't a valid sysServices
+    """
+    if isinstance(value, bool):
+        return None
+    try:
+        services = int(value)
+    except (TypeError, ValueError):
+        return None
+    if not 0 <= services <= 127:
+        return None
+    return [layer for bit, layer in SYS_SERVICES_LAYERS.items() if services & bit]
+
+
+# Built-in decoders of opaque values, by numeric OID
+BUILTIN_DECODERS: Dict[str, Callable[[Any], Any]] = {
+    SYS_SERVICES: decode_sys_services,
+}
+
+
+def decode_value(oid: str, value: Any) -> Any:
+    """
+    Decode a value with the built-in decoder for its OID.
+
+    Args:
+        oid: Numeric OID of the value
+        value: Formatted value
+
+    Returns:
+        Readable form of the value, or None if the OID has no decoder or the value can't be decoded
+    """
+    decoder = BUILTIN_DECODERS.get(oid.lstrip("."))
+    return decoder(value) if decoder else None
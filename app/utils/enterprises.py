@@ -0,0 +1,64 @@
+from typing import Optional, Tuple
+
+ENTERPRISES_OID = "1.3.6.1.4.1"
+
+# IANA Private Enterprise Numbers for common network equipment vendors
+ENTERPRISE_NUMBERS = {
+    2: "IBM",
+    9: "Cisco Systems",
+    11: "Hewlett-Packard",
+    43: "3Com",
+    171: "D-Link",
+    311: "Microsoft",
+    318: "APC",
+    674: "Dell",
+    1588: "Brocade",
+    1916: "Extreme Networks",
+    1991: "Foundry Networks",
+    2011: "Huawei",
+    2021: "UC Davis",
+    2620: "Check Point Software",
+    2636: "Juniper Networks",
+    3224: "NetScreen Technologies",
+    3375: "F5 Networks",
+    4526: "Netgear",
+    5624: "Enterasys Networks",
+    5951: "Citrix (NetScaler)",
+    6486: "Alcatel-Lucent",
+    6527: "Nokia (TiMetra)",
+    6876: "VMware",
+    6889: "Avaya",
+    8072: "Net-SNMP",
+    8741: "SonicWall",
+    11863: "TP-Link",
+    12356: "Fortinet",
+    14179: "Cisco Wireless (Airespace)",
+    14988: "MikroTik",
+    25461: "Palo Alto Networks",
+    25506: "H3C",
+    30065: "Arista Networks",
+    41112: "Ubiquiti Networks",
+    1004849: "Dahua Technology",
+}
+
+
+def get_enterprise(oid: str) -> Optional[Tuple[int, Optional[str]]]:
+    """
+    Get the enterprise number and vendor name for an OID under the enterprises subtree.
+
+    Args:
+        oid: Numeric OID (e.g. 1.3.6.1.4.1.9.1.1208)
+
+    Returns:
+        Tuple of (enterprise number, vendor name or None if unknown), or None if the
+        OID isn't under 1.3.6.1.4.1
+    """
+    oid = oid.lstrip(".")
+    if not oid.startswith(ENTERPRISES_OID + "."):
+        return None
+
+    number = oid[len(ENTERPRISES_OID) + 1:].split(".", 1)[0]
+    if not number.isdigit():
+        return None
+
+    return int(number), ENTERPRISE_NUMBERS.get(int(number))
@@ -0,0 +1,39 @@
+import hashlib
+import json
+from typing import Any, Optional
+
+
+def compute_etag(*parts: Any) -> str:
+    """
+    Compute a strong ETag from JSON-serializable parts.
+
+    Args:
+        parts: Values the ETag should reflect (e.g. the interpreted query and the result data)
+
+    Returns:
+        Quoted ETag header value
+    """
+    payload = json.dumps(parts, sort_keys=True, default=str)
+    return f'"{hashlib.sha256(payload.encode()).hexdigest()[:32]}"'
+
+
+def etag_matches(if_none_match: Optional[str], etag: str) -> bool:
+    """
+    Check an If-None-Match header against an ETag.
+
+    Args:
+        if_none_match: If-None-Match header value (may list several ETags, or be "*")
+        etag: Current ETag
+
+    Returns:
+        True if the client's copy is still current
+    """
+    if not if_none_match:
+        return False
+
+    candidates = [candidate.strip() for candidate in if_none_match.split(",")]
+    if "*" in candidates:
+        return True
+
+    # Weak comparison, as required for If-None-Match
+    return any(candidate.removeprefix("W/") == etag.removeprefix("W/") for candidate in candidates)
@@ -0,0 +1,126 @@
+import csv
+import io
+import json
+import re
+from datetime import datetime, timezone
+from itertools import islice
+from typing import Any, Dict, Iterator, Optional
+
+# Media type of each download format
+EXPORT_MEDIA_TYPES = {
+    "csv": "text/csv; charset=utf-8",
+    "json": "application/json",
+}
+
+# Result fields written as CSV columns, in order
+EXPORT_COLUMNS = ("oid", "name", "value", "mib", "if_name", "if_alias", "enterprise_number", "vendor")
+
+# Results serialized per chunk of a download or streamed response
+EXPORT_CHUNK_SIZE = 500
+
+# Response fields that grow with the number of results, encoded a chunk at a time
+STREAMED_FIELDS = ("raw_data", "results")
+
+# Leading characters that make a spreadsheet read a CSV cell as a formula
+FORMULA_PREFIXES = ("=", "+", "-", "@", "\t", "\r")
+
+_UNSAFE_FILENAME_CHARS = re.compile(r"[^A-Za-z0-9._-]+")
+
+
+def export_filename(target: Optional[str], export_format: str, when: Optional[datetime] = None) -> str:
+    """
+    Build the file name of a download from the target and the time of the export
+
+    Args:
+        target: Queried host, if known
+        export_format: Download format (csv or json)
+        when: Time of the export (now if not given)
+
+    Returns:
+        File name, e.g. snmp-10.0.0.1-20240501T120000Z.csv
+    """
+    when = (when or datetime.now(timezone.utc)).astimezone(timezone.utc)
+    target = _UNSAFE_FILENAME_CHARS.sub("_", target or "").strip("_") or "query"
+    return f"snmp-{target}-{when.strftime('%Y%m%dT%H%M%SZ')}.{export_format}"
+
+
+def iter_export(content: Dict[str, Any], export_format: str) -> Iterator[str]:
+    """
+    Serialize a query response for download, a chunk of results at a time
+
+    CSV holds one row per result; JSON holds the whole response body.
+
+    Args:
+        content: Response body, with its enriched results
+        export_format: Download format (csv or json)
+
+    Yields:
+        Chunks of the file
+    """
+    if export_format == "csv":
+        return _iter_csv(content.get("results") or [])
+    return iter_json(content)
+
+
+def iter_json(content: Dict[str, Any]) -> Iterator[str]:
+    """
+    Serialize a response body as JSON, a chunk of results at a time
+
+    The results and raw data, which grow with the size of the walk, are encoded
+    EXPORT_CHUNK_SIZE entries at a time, so the encoded body is never held in
+    memory as a whole. The body itself is already built: this bounds only what
+    encoding adds to it, not the memory the results take.
+
+    Args:
+        content: Response body
+
+    Yields:
+        Chunks of the JSON document
+    """
+    streamed = [key for key in STREAMED_FIELDS if isinstance(content.get(key), (list, dict))]
+    body = {key: value for key, value in content.items() if key not in streamed}
+    # The body without its streamed fields, reopened to stream them into it
+    pending = json.dumps(body, default=str)[:-1]
+    for number, key in enumerate(streamed):
+        value = content[key]
+        separator = ", " if body or number else ""
+        if isinstance(value, dict):
+            opening, closing = "{", "}"
+            entries = (f"{json.dumps(str(name))}: {json.dumps(item, default=str)}" for name, item in value.items())
+        else:
+            opening, closing = "[", "]"
+            entries = (json.dumps(item, default=str) for item in value)
+        yield f"{pending}{separator}{json.dumps(key)}: {opening}"
+
+        first = True
+        while True:
+            chunk = list(islice(entries, EXPORT_CHUNK_SIZE))
+            if not chunk:
+                break
+            yield ("" if first else ",") + ",".join(chunk)
+            first = False
+        pending = closing
+    yield pending + "}"
+
+
+def _csv_cell(value: Any) -> Any:
+    """Quote a text cell with ' when a spreadsheet would run it as a formula, e.g. a device-set sysName"""
+    if isinstance(value, str) and value.startswith(FORMULA_PREFIXES):
+        return "'" + value
+    return value
+
+
+def _iter_csv(results) -> Iterator[str]:
+    buffer = io.StringIO()
+    writer = csv.DictWriter(buffer, fieldnames=EXPORT_COLUMNS, extrasaction="ignore")
+    writer.writeheader()
+    for number, result in enumerate(results, start=1):
+        row = {column: result.get(column) for column in EXPORT_COLUMNS}
+        if isinstance(row["value"], (dict, list)):
+            row["value"] = json.dumps(row["value"], default=str)
+        writer.writerow({column: _csv_cell(value) for column, value in row.items()})
+        if number % EXPORT_CHUNK_SIZE == 0:
+            yield buffer.getvalue()
+            buffer.seek(0)
+            buffer.truncate()
+    yield buffer.getvalue()
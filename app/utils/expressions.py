@@ -0,0 +1,205 @@
+import ast
+import math
+import operator
+from typing import Any, Dict, List, Set
+
+MAX_EXPRESSION_LENGTH = 256
+MAX_EXPRESSION_NODES = 64
+MAX_EXPONENT = 16
+MAX_INTEGER_BITS = 4096
+MAX_COMPUTED_FIELDS = 10
+
+_BINARY_OPERATORS = {
+    ast.Add: operator.add,
+    ast.Sub: operator.sub,
+    ast.Mult: operator.mul,
+    ast.Div: operator.truediv,
+    ast.FloorDiv: operator.floordiv,
+    ast.Mod: operator.mod,
+    ast.Pow: operator.pow,
+}
+
+_UNARY_OPERATORS = {
+    ast.UAdd: operator.pos,
+    ast.USub: operator.neg,
+}
+
+_FUNCTIONS = {
+    "abs": abs,
+    "min": min,
+    "max": max,
+    "round": round,
+}
+
+
+class ExpressionError(ValueError):
+    """Raised for an expression that is invalid, unsafe or fails to evaluate"""
+
+
+def parse_expression(expression: str) -> ast.Expression:
+    """
+    Parse and check an expression.
+
+    Only arithmetic on numbers and variables and calls to abs, min, max and round
+    are allowed; attribute access, subscripts, lambdas, comprehensions and other
+    functions are rejected, and the expression's size is bounded.
+
+    Args:
+        expression: Expression text, e.g. "ifInOctets * 8 / ifSpeed"
+
+    Returns:
+        Parsed expression tree
+    """
+    if len(expression) > MAX_EXPRESSION_LENGTH:
+        raise ExpressionError(f"Expression is longer than {MAX_EXPRESSION_LENGTH} characters")
+
+    try:
+        tree = ast.parse(expression, mode="eval")
+    except SyntaxError as e:
+        raise ExpressionError(f"Invalid expression: {e.msg}")
+
+    nodes = list(ast.walk(tree))
+    if len(nodes) > MAX_EXPRESSION_NODES:
+        raise ExpressionError(f"Expression has more than {MAX_EXPRESSION_NODES} elements")
+
+    for node in nodes:
+        if isinstance(node, (ast.Expression, ast.Load)) or type(node) in _BINARY_OPERATORS \
+                or type(node) in _UNARY_OPERATORS:
+            continue
+        if isinstance(node, ast.BinOp) and type(node.op) in _BINARY_OPERATORS:
+            continue
+        if isinstance(node, ast.UnaryOp) and type(node.op) in _UNARY_OPERATORS:
+            continue
+        if isinstance(node, ast.Constant) and type(node.value) in (int, float):
+            continue
+        if isinstance(node, ast.Name):
+            if node.id.startswith("_"):
+                raise ExpressionError(f"Name not allowed: {node.id}")
+            continue
+        if isinstance(node, ast.Call) and isinstance(node.func, ast.Name) and node.func.id in _FUNCTIONS \
+                and not node.keywords:
+            continue
+        raise ExpressionError(f"Not allowed in expressions: {type(node).__name__}")
+
+    return tree
+
+
+def expression_names(tree: ast.Expression) -> Set[str]:
+    """Get the variable names an expression refers to"""
+    calls = {id(node.func) for node in ast.walk(tree) if isinstance(node, ast.Call)}
+    return {node.id for node in ast.walk(tree) if isinstance(node, ast.Name) and id(node) not in calls}
+
+
+def evaluate_expression(tree: ast.Expression, variables: Dict[str, Any]) -> Any:
+    """
+    Evaluate an expression parsed by parse_expression.
+
+    Args:
+        tree: Parsed expression
+        variables: Numeric values for the expression's variables
+
+    Returns:
+        Computed value
+    """
+    return _evaluate(tree.body, variables)
+
+
+def _evaluate(node: ast.AST, variables: Dict[str, Any]) -> Any:
+    if isinstance(node, ast.Constant):
+        return node.value
+
+    if isinstance(node, ast.Name):
+        if node.id not in variables:
+            raise ExpressionError(f"Unknown name: {node.id}")
+        value = variables[node.id]
+        if isinstance(value, bool) or not isinstance(value, (int, float)):
+            raise ExpressionError(f"Not a number: {node.id}")
+        return value
+
+    if isinstance(node, ast.UnaryOp):
+        return _UNARY_OPERATORS[type(node.op)](_evaluate(node.operand, variables))
+
+    if isinstance(node, ast.BinOp):
+        left = _evaluate(node.left, variables)
+        right = _evaluate(node.right, variables)
+        if isinstance(node.op, ast.Pow):
+            if abs(right) > MAX_EXPONENT:
+                raise ExpressionError(f"Exponent larger than {MAX_EXPONENT}")
+            if isinstance(left, int) and isinstance(right, int) and left.bit_length() * right > MAX_INTEGER_BITS:
+                raise ExpressionError("Result too large")
+        try:
+            result = _BINARY_OPERATORS[type(node.op)](left, right)
+        except (ArithmeticError, ValueError) as e:
+            raise ExpressionError(str(e))
+        if isinstance(result, complex) or (isinstance(result, float) and not math.isfinite(result)):
+            raise ExpressionError("Result is not a finite real number")
+        return result
+
+    if isinstance(node, ast.Call):
+        args = [_evaluate(arg, variables) for arg in node.args]
+        try:
+            return _FUNCTIONS[node.func.id](*args)
+        except (TypeError, ValueError) as e:
+            raise ExpressionError(str(e))
+
+    raise ExpressionError(f"Not allowed in expressions: {type(node).__name__}")
+
+
+def parse_computed_fields(specs: List[str]) -> Dict[str, ast.Expression]:
+    """
+    Parse computed field definitions of the form "name=expression".
+
+    Args:
+        specs: Field definitions, e.g. ["utilization=ifInOctets * 8 / ifSpeed"]
+
+    Returns:
+        Parsed expression for each field name
+    """
+    if len(specs) > MAX_COMPUTED_FIELDS:
+        raise ExpressionError(f"More than {MAX_COMPUTED_FIELDS} computed fields")
+
+    fields = {}
+    for spec in specs:
+        name, separator, expression = spec.partition("=")
+        name = name.strip()
+        if not separator or not name.isidentifier():
+            raise ExpressionError(f"Computed fields must be given as name=expression: {spec}")
+        fields[name] = parse_expression(expression.strip())
+
+    return fields
+
+
+def compute_fields(raw_data: Dict[str, Any], fields: Dict[str, ast.Expression]) -> Dict[str, Any]:
+    """
+    Compute fields from SNMP results.
+
+    Results are grouped by index, so "ifInOctets * 8 / ifSpeed" is evaluated for
+    each interface from IF-MIB::ifInOctets.<n> and IF-MIB::ifSpeed.<n> and returned
+    as "<field>.<n>". Indexes missing a referenced object are skipped, and
+    evaluation errors (e.g. division by zero) give None.
+
+    Args:
+        raw_data: SNMP results keyed by symbolic name
+        fields: Parsed expressions from parse_computed_fields
+
+    Returns:
+        Computed values keyed by "<field>.<index>"
+    """
+    rows: Dict[str, Dict[str, Any]] = {}
+    for key, value in raw_data.items():
+        obj, _, index = key.split("::")[-1].partition(".")
+        if obj.isidentifier() and index:
+            rows.setdefault(index, {})[obj] = value
+
+    computed = {}
+    for field, tree in fields.items():
+        names = expression_names(tree)
+        for index, variables in rows.items():
+            if not names <= variables.keys():
+                continue
+            try:
+                computed[f"{field}.{index}"] = evaluate_expression(tree, variables)
+            except ExpressionError:
+                computed[f"{field}.{index}"] = None
+
+    return computed
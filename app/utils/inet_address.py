@@ -0,0 +1,50 @@
+import ipaddress
+from typing import Any, Optional
+
+# InetAddressType values from INET-ADDRESS-MIB (RFC 4001)
+INET_ADDRESS_TYPES = {
+    0: "unknown",
+    1: "ipv4",
+    2: "ipv6",
+    3: "ipv4z",
+    4: "ipv6z",
+    16: "dns",
+}
+
+
+def decode_inet_address(address_type: Any, value: Any) -> Optional[str]:
+    """
+    Decode an InetAddress value according to its InetAddressType.
+
+    Args:
+        address_type: InetAddressType value (e.g. 1 for ipv4, 2 for ipv6)
+        value: Raw InetAddress octets
+
+    Returns:
+        Readable address string, or None if the value can't be decoded
+    """
+    if isinstance(value, str):
+        value = value.encode("latin-1")
+    if not isinstance(value, bytes):
+        return None
+
+    try:
+        type_name = INET_ADDRESS_TYPES.get(int(address_type))
+    except (TypeError, ValueError):
+        return None
+
+    if type_name == "ipv4" and len(value) == 4:
+        return str(ipaddress.IPv4Address(value))
+    if type_name == "ipv6" and len(value) == 16:
+        return str(ipaddress.IPv6Address(value))
+    if type_name == "ipv4z" and len(value) == 8:
+        return f"{ipaddress.IPv4Address(value[:4])}%{int.from_bytes(value[4:], 'big')}"
+    if type_name == "ipv6z" and len(value) == 20:
+        return f"{ipaddress.IPv6Address(value[:16])}%{int.from_bytes(value[16:], 'big')}"
+    if type_name == "dns":
+        try:
+            return value.decode("ascii")
+        except UnicodeDecodeError:
+            return None
+
+    return None
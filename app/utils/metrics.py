@@ -0,0 +1,133 @@
+import bisect
+import math
+import threading
+from typing import Dict, List, Optional, Sequence, Tuple
+
+from app.utils.openmetrics import _labels, _number
+
+# Latency buckets in seconds, from a fast LAN answer to a request retried until it timed out
+DEFAULT_BUCKETS = (0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0, 30.0)
+
+
+def timeout_buckets(timeout: float) -> Tuple[float, ...]:
+    """Latency buckets up to a timeout, ending on the timeout itself so calls that ran into it stand apart"""
+    return tuple(bound for bound in DEFAULT_BUCKETS if bound < timeout) + (float(timeout),)
+
+
+class _Metric:
+    type = ""
+
+    def __init__(self, name: str, description: str, label_names: Sequence[str]):
+        self.name = name
+        self.description = description
+        self.label_names = tuple(label_names)
+        self._lock = threading.Lock()
+
+    def _key(self, labels: Dict[str, str]) -> Tuple[str, ...]:
+        if set(labels) != set(self.label_names):
+            raise ValueError(f"{self.name} is labeled by {', '.join(self.label_names)}, not {', '.join(labels)}")
+        return tuple(str(labels[name]) for name in self.label_names)
+
+    def _label_values(self, key: Tuple[str, ...], **extra: str) -> Dict[str, str]:
+        return {**dict(zip(self.label_names, key)), **extra}
+
+    def samples(self) -> List[str]:
+        raise NotImplementedError
+
+
+class Counter(_Metric):
+    """Count of events per label set, exported as <name>_total"""
+    type = "counter"
+
+    def __init__(self, name: str, description: str, label_names: Sequence[str]):
+        super().__init__(name, description, label_names)
+        self._values: Dict[Tuple[str, ...], float] = {}
+
+    def inc(self, amount: float = 1, **labels: str) -> None:
+        key = self._key(labels)
+        with self._lock:
+            self._values[key] = self._values.get(key, 0) + amount
+
+    def value(self, **labels: str) -> float:
+        return self._values.get(self._key(labels), 0)
+
+    def samples(self) -> List[str]:
+        with self._lock:
+            values = sorted(self._values.items())
+        return [f"{self.name}_total{_labels(self._label_values(key))} {_number(value)}" for key, value in values]
+
+
+class Histogram(_Metric):
+    """Distribution of observed values per label set, in cumulative buckets"""
+    type = "histogram"
+
+    def __init__(self, name: str, description: str, label_names: Sequence[str],
+                 buckets: Sequence[float] = DEFAULT_BUCKETS):
+        super().__init__(name, description, label_names)
+        self.buckets = tuple(sorted(buckets))
+        # label set -> (count per bucket, not cumulative, with +Inf last; sum)
+        self._values: Dict[Tuple[str, ...], Tuple[List[int], float]] = {}
+
+    def observe(self, value: float, **labels: str) -> None:
+        key = self._key(labels)
+        with self._lock:
+            counts, total = self._values.get(key) or ([0] * (len(self.buckets) + 1), 0.0)
+            counts[bisect.bisect_left(self.buckets, value)] += 1
+            self._values[key] = (counts, total + value)
+
+    def count(self, **labels: str) -> int:
+        counts, _ = self._values.get(self._key(labels)) or ([], 0.0)
+        return sum(counts)
+
+    def samples(self) -> List[str]:
+        with self._lock:
+            values = sorted((key, (list(counts), total)) for key, (counts, total) in self._values.items())
+        samples = []
+        for key, (counts, total) in values:
+            cumulative = 0
+            for bound, count in zip([*self.buckets, math.inf], counts):
+                cumulative += count
+                le = "+Inf" if bound == math.inf else _number(float(bound))
+                samples.append(f"{self.name}_bucket{_labels(self._label_values(key, le=le))} {cumulative}")
+            samples.append(f"{self.name}_count{_labels(self._label_values(key))} {cumulative}")
+            samples.append(f"{self.name}_sum{_labels(self._label_values(key))} {_number(float(total))}")
+        return samples
+
+
+class MetricsRegistry:
+    """
+    The service's own metrics, exported by GET /metrics
+
+    Label values must come from small fixed sets (operation, outcome, target
+    class), never from raw addresses or queries, to keep the series bounded.
+    """
+
+    def __init__(self):
+        self._metrics: Dict[str, _Metric] = {}
+
+    def _register(self, metric: _Metric) -> _Metric:
+        if metric.name in self._metrics:
+            raise ValueError(f"Metric {metric.name} is already registered")
+        self._metrics[metric.name] = metric
+        return metric
+
+    def counter(self, name: str, description: str, label_names: Sequence[str]) -> Counter:
+        return self._register(Counter(name, description, label_names))
+
+    def histogram(self, name: str, description: str, label_names: Sequence[str],
+                  buckets: Optional[Sequence[float]] = None) -> Histogram:
+        return self._register(Histogram(name, description, label_names, buckets or DEFAULT_BUCKETS))
+
+    def render(self) -> str:
+        """Render every metric in the OpenMetrics text format"""
+        lines = []
+        for metric in self._metrics.values():
+            lines.append(f"# TYPE {metric.name} {metric.type}")
+            lines.append(f"# HELP {metric.name} {metric.description}")
+            lines.extend(metric.samples())
+        lines.append("# EOF")
+        return "\n".join(lines) + "\n"
+
+
+# Registry of the metrics of this process
+registry = MetricsRegistry()
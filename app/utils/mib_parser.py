@@ -0,0 +1,127 @@
+import re
+from typing import Dict, List, NamedTuple, Optional, Tuple
+
+# Modules defining the SMI itself (macros, base types, the registration tree), always available
+SMI_MODULES = {
+    "SNMPv2-SMI", "SNMPv2-TC", "SNMPv2-CONF", "RFC1155-SMI", "RFC1065-SMI", "RFC-1212", "RFC-1215",
+}
+
+_STRING_OR_COMMENT = re.compile(r'"[^"]*"|--[^\n]*')
+_MODULE = re.compile(r"\b([A-Za-z][\w-]*)\s+DEFINITIONS\s*(?:IMPLICIT\s+TAGS\s*)?::=\s*BEGIN\b")
+_IMPORTS = re.compile(r"\bIMPORTS\b(.*?);", re.DOTALL)
+_IMPORT_GROUP = re.compile(r"(.*?)\bFROM\s+([A-Za-z][\w-]*)", re.DOTALL)
+# An OBJECT IDENTIFIER assignment is followed by ::= straight away; otherwise it is e.g. a
+# SEQUENCE member (sysORID OBJECT IDENTIFIER,), and the match would run into the next definition
+_ASSIGNMENT = re.compile(
+    r"\b([a-z][\w-]*)\s+(?:(OBJECT-TYPE|OBJECT-IDENTITY|MODULE-IDENTITY|NOTIFICATION-TYPE|OBJECT-GROUP|"
+    r"NOTIFICATION-GROUP|MODULE-COMPLIANCE|AGENT-CAPABILITIES)\b(?:(?!::=).)*?|(OBJECT\s+IDENTIFIER)\s*)"
+    r"::=\s*\{([^}]*)\}",
+    re.DOTALL
+)
+_OID_COMPONENT = re.compile(r"[A-Za-z][\w-]*\((\d+)\)|([A-Za-z][\w-]*)|(\d+)")
+_CLAUSE_END = r"(?=\b(?:UNITS|MAX-ACCESS|ACCESS|STATUS|DESCRIPTION|REFERENCE|INDEX|AUGMENTS|DEFVAL)\b|::=)"
+_SYNTAX = re.compile(r"\bSYNTAX\s+(.*?)\s*" + _CLAUSE_END, re.DOTALL)
+_ACCESS = re.compile(r"(?<![\w-])(?:MAX-)?ACCESS\s+([\w-]+)")
+# Strings are swapped for their number in the module text while scanning it, see parse_mib
+_DESCRIPTION = re.compile(r'\bDESCRIPTION\s+"(\d+)"')
+_INDEX = re.compile(r"\bINDEX\s*\{([^}]*)\}")
+_AUGMENTS = re.compile(r"\bAUGMENTS\s*\{\s*([A-Za-z][\w-]*)\s*\}")
+# Words of a DESCRIPTION that look like object names: lowerCamelCase, with at least one
+# capital, so that plain words ("interface") aren't taken for references
+_REFERENCE = re.compile(r"\b[a-z][a-z0-9]*[A-Z][A-Za-z0-9]*\b")
+
+
+class MIBObject(NamedTuple):
+    """What a MIB module says about one of its objects, besides where it is in the OID tree"""
+    kind: str  # The macro defining it, e.g. OBJECT-TYPE or OBJECT IDENTIFIER
+    syntax: Optional[str] = None  # SYNTAX clause, e.g. INTEGER { up(1), down(2) }
+    access: Optional[str] = None  # MAX-ACCESS (or SMIv1 ACCESS) clause, e.g. read-only
+    description: Optional[str] = None  # DESCRIPTION, with the MIB's line breaks and indentation folded
+    index: Optional[List[str]] = None  # INDEX objects of a table row, the last one prefixed "IMPLIED " if so
+    augments: Optional[str] = None  # The table row this row extends (AUGMENTS), sharing its INDEX
+    references: Optional[List[str]] = None  # Names the DESCRIPTION mentions, e.g. ifTable, if they were extracted
+
+
+class MIBModule(NamedTuple):
+    """What a MIB module defines, as far as needed to place its objects in the OID tree"""
+    name: str
+    imports: Dict[str, List[str]]  # Module -> symbols imported from it
+    # Object name -> the node it is defined under (a name, or a number for absolute OIDs) and
+    # the sub-identifiers below that node
+    objects: Dict[str, Tuple[str, List[int]]]
+    details: Dict[str, MIBObject]  # Object name -> its kind, SYNTAX, MAX-ACCESS and DESCRIPTION
+
+
+def parse_mib(text: str, references: bool = False) -> List[MIBModule]:
+    """
+    Scan MIB source for its modules, their IMPORTS and the OID assignments of their objects
+
+    Besides the OID assignment, the SYNTAX, MAX-ACCESS, DESCRIPTION, INDEX and AUGMENTS
+    clauses of each object are read; anything else in the module, such as textual conventions,
+    is skipped.
+
+    Args:
+        text: MIB source
+        references: Whether to extract the names each DESCRIPTION mentions, other than the
+            object's own; whether they name objects is left to whoever resolves them
+
+    Returns:
+        The modules defined in the text, in order; none if it holds no module definition
+    """
+    # Strings may hold anything, ::= and comment markers included; they are set aside and
+    # replaced by their number, so that only MIB syntax is left to scan
+    strings: List[str] = []
+
+    def set_aside(match) -> str:
+        if not match.group().startswith('"'):
+            return ""
+        strings.append(" ".join(match.group()[1:-1].split()))
+        return f'"{len(strings) - 1}"'
+
+    text = _STRING_OR_COMMENT.sub(set_aside, text)
+    headers = list(_MODULE.finditer(text))
+    modules = []
+    for position, header in enumerate(headers):
+        end = headers[position + 1].start() if position + 1 < len(headers) else len(text)
+        body = text[header.end():end]
+
+        imports: Dict[str, List[str]] = {}
+        imports_clause = _IMPORTS.search(body)
+        if imports_clause:
+            for symbols, module in _IMPORT_GROUP.findall(imports_clause.group(1)):
+                imports.setdefault(module, []).extend(re.findall(r"[A-Za-z][\w-]*", symbols))
+            body = body[:imports_clause.start()] + body[imports_clause.end():]
+
+        objects, details = {}, {}
+        for assignment in _ASSIGNMENT.finditer(body):
+            name, macro, object_identifier, value = assignment.groups()
+            kind = macro or object_identifier
+            components = _OID_COMPONENT.findall(value)
+            if not components:
+                continue
+            numbered, named, number = components[0]
+            parent = named or number or numbered
+            objects[name] = (parent, [int(numbered or number) for numbered, named, number in components[1:]
+                                      if not named])
+
+            clauses = assignment.group()
+            syntax = _SYNTAX.search(clauses)
+            access = _ACCESS.search(clauses)
+            description = _DESCRIPTION.search(clauses)
+            index = _INDEX.search(clauses)
+            augments = _AUGMENTS.search(clauses)
+            mentioned = None
+            if references and description:
+                mentioned = [word for word in dict.fromkeys(_REFERENCE.findall(strings[int(description.group(1))]))
+                             if word != name]
+            details[name] = MIBObject(
+                " ".join(kind.split()),
+                " ".join(syntax.group(1).split()) if syntax else None,
+                access.group(1) if access else None,
+                strings[int(description.group(1))] if description else None,
+                [" ".join(part.split()) for part in index.group(1).split(",")] if index else None,
+                augments.group(1) if augments else None,
+                mentioned,
+            )
+        modules.append(MIBModule(header.group(1), imports, objects, details))
+    return modules
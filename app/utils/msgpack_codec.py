@@ -0,0 +1,238 @@
+import struct
+from typing import Any, List, Optional, Tuple
+
+MSGPACK_MEDIA_TYPE = "application/msgpack"
+MSGPACK_MEDIA_TYPES = (MSGPACK_MEDIA_TYPE, "application/x-msgpack")
+
+
+def encode_msgpack(value: Any) -> bytes:
+    """
+    Encode a value as MessagePack.
+
+    Supports None, bool, int, float, str, bytes, lists/tuples and dicts; any
+    other value is encoded as its string form, as in JSON responses.
+
+    Args:
+        value: Value to encode (e.g. an SNMPResponse dict)
+
+    Returns:
+        Encoded bytes
+    """
+    out = bytearray()
+    _encode(value, out)
+    return bytes(out)
+
+
+def decode_msgpack(data: bytes) -> Any:
+    """
+    Decode a MessagePack document produced by encode_msgpack.
+
+    Args:
+        data: Encoded bytes
+
+    Returns:
+        Decoded value
+    """
+    value, offset = _decode(data, 0)
+    if offset != len(data):
+        raise ValueError(f"Trailing data after MessagePack value at offset {offset}")
+    return value
+
+
+def prefers_msgpack(accept: Optional[str]) -> bool:
+    """
+    Check whether an Accept header asks for MessagePack over JSON.
+
+    Args:
+        accept: Accept header value
+
+    Returns:
+        True if MessagePack should be returned; JSON is the default
+    """
+    if not accept:
+        return False
+
+    msgpack_q = 0.0
+    json_q = 0.0
+    for media_range in accept.split(","):
+        media_type, *params = [part.strip() for part in media_range.split(";")]
+        q = 1.0
+        for param in params:
+            name, _, param_value = param.partition("=")
+            if name.strip() == "q":
+                try:
+                    q = float(param_value)
+                except ValueError:
+                    q = 0.0
+
+        media_type = media_type.lower()
+        if media_type in MSGPACK_MEDIA_TYPES:
+            msgpack_q = max(msgpack_q, q)
+        elif media_type in ("application/json", "application/*", "*/*"):
+            json_q = max(json_q, q)
+
+    return msgpack_q > 0 and msgpack_q >= json_q
+
+
+def _encode(value: Any, out: bytearray) -> None:
+    if value is None:
+        out.append(0xc0)
+    elif value is True:
+        out.append(0xc3)
+    elif value is False:
+        out.append(0xc2)
+    elif isinstance(value, int):
+        _encode_int(value, out)
+    elif isinstance(value, float):
+        out.append(0xcb)
+        out += struct.pack(">d", value)
+    elif isinstance(value, str):
+        data = value.encode("utf-8")
+        size = len(data)
+        if size < 32:
+            out.append(0xa0 | size)
+        elif size < 0x100:
+            out += struct.pack(">BB", 0xd9, size)
+        elif size < 0x10000:
+            out += struct.pack(">BH", 0xda, size)
+        else:
+            out += struct.pack(">BI", 0xdb, size)
+        out += data
+    elif isinstance(value, (bytes, bytearray)):
+        size = len(value)
+        if size < 0x100:
+            out += struct.pack(">BB", 0xc4, size)
+        elif size < 0x10000:
+            out += struct.pack(">BH", 0xc5, size)
+        else:
+            out += struct.pack(">BI", 0xc6, size)
+        out += value
+    elif isinstance(value, (list, tuple)):
+        _encode_header(len(value), 0x90, 0xdc, 0xdd, out)
+        for item in value:
+            _encode(item, out)
+    elif isinstance(value, dict):
+        _encode_header(len(value), 0x80, 0xde, 0xdf, out)
+        for key, item in value.items():
+            _encode(key, out)
+            _encode(item, out)
+    else:
+        _encode(str(value), out)
+
+
+def _encode_int(value: int, out: bytearray) -> None:
+    if 0 <= value < 0x80:
+        out.append(value)
+    elif -32 <= value < 0:
+        out += struct.pack(">b", value)
+    elif 0 <= value < 0x10000000000000000:
+        for code, fmt, limit in ((0xcc, ">B", 0x100), (0xcd, ">H", 0x10000),
+                                 (0xce, ">I", 0x100000000), (0xcf, ">Q", 0x10000000000000000)):
+            if value < limit:
+                out.append(code)
+                out += struct.pack(fmt, value)
+                return
+    elif -0x8000000000000000 <= value < 0:
+        for code, fmt, limit in ((0xd0, ">b", 0x80), (0xd1, ">h", 0x8000),
+                                 (0xd2, ">i", 0x80000000), (0xd3, ">q", 0x8000000000000000)):
+            if value >= -limit:
+                out.append(code)
+                out += struct.pack(fmt, value)
+                return
+    else:
+        # Beyond 64 bits (not produced by SNMP): fall back to the string form
+        _encode(str(value), out)
+
+
+def _encode_header(size: int, fix: int, code16: int, code32: int, out: bytearray) -> None:
+    if size < 16:
+        out.append(fix | size)
+    elif size < 0x10000:
+        out += struct.pack(">BH", code16, size)
+    else:
+        out += struct.pack(">BI", code32, size)
+
+
+_FIXED = {
+    0xcc: ">B", 0xcd: ">H", 0xce: ">I", 0xcf: ">Q",
+    0xd0: ">b", 0xd1: ">h", 0xd2: ">i", 0xd3: ">q",
+    0xca: ">f", 0xcb: ">d",
+}
+
+
+def _decode(data: bytes, offset: int) -> Tuple[Any, int]:
+    if offset >= len(data):
+        raise ValueError("Truncated MessagePack data")
+
+    code = data[offset]
+    offset += 1
+
+    if code <= 0x7f:
+        return code, offset
+    if code >= 0xe0:
+        return code - 0x100, offset
+    if 0xa0 <= code <= 0xbf:
+        return _read_str(data, offset, code & 0x1f)
+    if 0x90 <= code <= 0x9f:
+        return _read_array(data, offset, code & 0x0f)
+    if 0x80 <= code <= 0x8f:
+        return _read_map(data, offset, code & 0x0f)
+    if code == 0xc0:
+        return None, offset
+    if code == 0xc2:
+        return False, offset
+    if code == 0xc3:
+        return True, offset
+    if code in _FIXED:
+        return _unpack(_FIXED[code], data, offset)
+
+    length_formats = {
+        0xd9: (">B", _read_str), 0xda: (">H", _read_str), 0xdb: (">I", _read_str),
+        0xc4: (">B", _read_bin), 0xc5: (">H", _read_bin), 0xc6: (">I", _read_bin),
+        0xdc: (">H", _read_array), 0xdd: (">I", _read_array),
+        0xde: (">H", _read_map), 0xdf: (">I", _read_map),
+    }
+    if code in length_formats:
+        fmt, reader = length_formats[code]
+        size, offset = _unpack(fmt, data, offset)
+        return reader(data, offset, size)
+
+    raise ValueError(f"Unsupported MessagePack type 0x{code:02x}")
+
+
+def _unpack(fmt: str, data: bytes, offset: int) -> Tuple[Any, int]:
+    size = struct.calcsize(fmt)
+    if offset + size > len(data):
+        raise ValueError("Truncated MessagePack data")
+    return struct.unpack_from(fmt, data, offset)[0], offset + size
+
+
+def _read_bytes(data: bytes, offset: int, size: int) -> Tuple[bytes, int]:
+    if offset + size > len(data):
+        raise ValueError("Truncated MessagePack data")
+    return bytes(data[offset:offset + size]), offset + size
+
+
+def _read_str(data: bytes, offset: int, size: int) -> Tuple[str, int]:
+    raw, offset = _read_bytes(data, offset, size)
+    return raw.decode("utf-8"), offset
+
+
+def _read_bin(data: bytes, offset: int, size: int) -> Tuple[bytes, int]:
+    return _read_bytes(data, offset, size)
+
+
+def _read_array(data: bytes, offset: int, size: int) -> Tuple[List[Any], int]:
+    items = []
+    for _ in range(size):
+        item, offset = _decode(data, offset)
+        items.append(item)
+    return items, offset
+
+
+def _read_map(data: bytes, offset: int, size: int) -> Tuple[dict, int]:
+    items = {}
+    for _ in range(size):
+        key, offset = _decode(data, offset)
+        items[key], offset = _decode(data, offset)
+    return items, offset
@@ -0,0 +1,85 @@
+import re
+from typing import Any, Dict, List, Tuple
+
+from app.services.mib_service import is_numeric_oid
+
+OPENMETRICS_MEDIA_TYPE = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+_INVALID_NAME_CHARS = re.compile(r"[^a-zA-Z0-9_:]")
+
+
+def _metric_name(name: str) -> str:
+    """Make an object name a valid metric name"""
+    name = _INVALID_NAME_CHARS.sub("_", name)
+    return name if re.match(r"[a-zA-Z_:]", name) else f"_{name}"
+
+
+def _escape_label(value: Any) -> str:
+    return str(value).replace("\\", "\\\\").replace('"', '\\"').replace("\n", "\\n")
+
+
+def _labels(labels: Dict[str, Any]) -> str:
+    return "{" + ",".join(f'{name}="{_escape_label(value)}"' for name, value in labels.items()) + "}"
+
+
+def _number(value: Any) -> str:
+    if isinstance(value, bool):
+        return "1" if value else "0"
+    return repr(float(value)) if isinstance(value, float) else str(value)
+
+
+def to_openmetrics(results: List[Tuple[str, Dict[str, Any]]]) -> str:
+    """
+    Render SNMP query results in the OpenMetrics text format.
+
+    Each object becomes a metric family named from its symbolic name (e.g.
+    IF-MIB::ifInOctets.3 -> ifInOctets{index="3",mib="IF-MIB",target=...}).
+    Numeric values are exported with type unknown, as SNMP counters and gauges
+    are not distinguished in the results; other values become info metrics
+    carrying the value as a label. OIDs without a symbolic name are exported
+    as snmp_oid_value with an oid label.
+
+    Args:
+        results: (target host, raw SNMP data) pairs
+
+    Returns:
+        OpenMetrics exposition, ending with "# EOF"
+    """
+    # family name -> (type, samples)
+    families: Dict[str, Tuple[str, List[str]]] = {}
+
+    for target, raw_data in results:
+        for key, value in raw_data.items():
+            if key == "error" or key.endswith("_error"):
+                continue
+
+            if is_numeric_oid(key):
+                family = "snmp_oid_value"
+                labels = {"oid": key.lstrip("."), "target": target}
+            else:
+                mib, _, name = key.rpartition("::")
+                obj, _, index = name.partition(".")
+                family = _metric_name(obj)
+                labels = {"index": index, "mib": mib, "target": target}
+                labels = {label: label_value for label, label_value in labels.items() if label_value}
+
+            numeric = isinstance(value, (int, float)) and not isinstance(value, bool)
+            metric_type = "unknown" if numeric else "info"
+
+            # A family has one type; values of the other kind are left out
+            family_type, samples = families.setdefault(family, (metric_type, []))
+            if family_type != metric_type:
+                continue
+
+            if numeric:
+                samples.append(f"{family}{_labels(labels)} {_number(value)}")
+            else:
+                samples.append(f"{family}_info{_labels({**labels, 'value': value})} 1")
+
+    lines = []
+    for family, (metric_type, samples) in families.items():
+        lines.append(f"# TYPE {family} {metric_type}")
+        lines.extend(samples)
+    lines.append("# EOF")
+
+    return "\n".join(lines) + "\n"
@@ -0,0 +1,155 @@
+import uuid
+from typing import Any, Dict, NamedTuple, Optional
+
+# Media type of RFC 7807 problem details
+PROBLEM_MEDIA_TYPE = "application/problem+json"
+
+# Problem type of errors whose status has no more specific type
+DEFAULT_PROBLEM_TYPE = "about:blank"
+
+
+class ProblemType(NamedTuple):
+    title: str
+    status: int
+    description: str
+
+
+# Problem types by name; their type URI is the configured base followed by the name
+PROBLEM_TYPES: Dict[str, ProblemType] = {
+    "invalid-request": ProblemType(
+        "Invalid request", 400,
+        "The query could not be interpreted, or a parameter or the interpreted query is invalid."),
+    "unauthorized": ProblemType(
+        "Unauthorized", 401, "The API key is missing or invalid."),
+    "forbidden": ProblemType(
+        "Forbidden", 403, "The request is not allowed on this server or for this API key."),
+    "not-found": ProblemType(
+        "Not found", 404, "The named object, macro, operation or poll target does not exist."),
+    "conflict": ProblemType(
+        "Conflict", 409, "The request conflicts with a running operation, e.g. a reused operation ID."),
+    "needs-clarification": ProblemType(
+        "Query needs clarification", 422,
+        "The query is too ambiguous to run; the clarification member lists what is missing."),
+    "invalid-parameters": ProblemType(
+        "Invalid parameters", 422, "Request parameters failed validation; the errors member lists them."),
+    "request-cancelled": ProblemType(
+        "Request cancelled", 499, "The operation was cancelled before it finished."),
+    "llm-rate-limited": ProblemType(
+        "LLM rate limited", 429,
+        "The LLM provider is rate limiting the service; retry after the Retry-After seconds if given."),
+    "internal-error": ProblemType(
+        "Internal error", 500, "The server failed to process the request."),
+    "snmp-error": ProblemType(
+        "SNMP request failed", 502, "The device did not answer, refused the request or returned an error."),
+    "snmp-auth-failed": ProblemType(
+        "SNMP authentication failed", 502, "The device rejected the community string or SNMPv3 credentials."),
+    "llm-error": ProblemType(
+        "LLM answer unusable", 502, "The LLM's interpretation of the query could not be used, e.g. malformed JSON."),
+    "service-read-only": ProblemType(
+        "Service read-only", 503,
+        "The service is in maintenance mode and rejects writes (SET), or all SNMP requests, for now."),
+    "request-timeout": ProblemType(
+        "Request timed out", 504, "The request did not finish within API_REQUEST_TIMEOUT seconds."),
+}
+
+# Problem type of each machine-readable error code
+ERROR_CODE_TYPES = {
+    "NEEDS_CLARIFICATION": "needs-clarification",
+    "INVALID_PARAMETERS": "invalid-parameters",
+    "REQUEST_TIMEOUT": "request-timeout",
+    "SNMP_AUTH_FAILED": "snmp-auth-failed",
+    "SNMP_SET_FAILED": "snmp-error",
+    "SERVICE_READ_ONLY": "service-read-only",
+    "LLM_RATE_LIMITED": "llm-rate-limited",
+    "LLM_BAD_RESPONSE": "llm-error",
+    "UNKNOWN_OID_NAME": "not-found",
+    "OID_NOT_FOUND": "not-found",
+    "SNMP_TIMEOUT": "snmp-error",
+    "SNMP_UNREACHABLE": "snmp-error",
+    "SNMP_ERROR": "snmp-error",
+    "INVALID_QUERY": "invalid-request",
+    "QUERY_NOT_UNDERSTOOD": "invalid-request",
+    "QUERY_REJECTED": "invalid-request",
+    "INTERNAL_ERROR": "internal-error",
+}
+
+# Error code of errors raised with only an HTTP status, so that every problem has one
+STATUS_ERROR_CODES = {
+    400: "INVALID_REQUEST",
+    401: "UNAUTHORIZED",
+    403: "FORBIDDEN",
+    404: "NOT_FOUND",
+    405: "METHOD_NOT_ALLOWED",
+    409: "CONFLICT",
+    413: "PAYLOAD_TOO_LARGE",
+    429: "TOO_MANY_REQUESTS",
+    499: "REQUEST_CANCELLED",
+    500: "INTERNAL_ERROR",
+    502: "SNMP_ERROR",
+    503: "SERVICE_UNAVAILABLE",
+    504: "REQUEST_TIMEOUT",
+}
+
+# Problem type of errors that only have an HTTP status
+STATUS_TYPES = {
+    400: "invalid-request",
+    401: "unauthorized",
+    403: "forbidden",
+    404: "not-found",
+    409: "conflict",
+    499: "request-cancelled",
+    500: "internal-error",
+    502: "snmp-error",
+    504: "request-timeout",
+}
+
+# Reason phrases of the statuses without a problem type, for about:blank problems
+STATUS_TITLES = {
+    405: "Method Not Allowed",
+    406: "Not Acceptable",
+    413: "Payload Too Large",
+    415: "Unsupported Media Type",
+    429: "Too Many Requests",
+    503: "Service Unavailable",
+}
+
+
+def problem_type_name(status: int, error_code: Optional[str] = None) -> Optional[str]:
+    """Name of the problem type of an error code, or else of an HTTP status, if it has one"""
+    return ERROR_CODE_TYPES.get(error_code or "") or STATUS_TYPES.get(status)
+
+
+def problem_status(error_code: Optional[str], default: int = 502) -> int:
+    """HTTP status of an error code, or the default for errors without a known code"""
+    name = ERROR_CODE_TYPES.get(error_code or "")
+    return PROBLEM_TYPES[name].status if name else default
+
+
+def build_problem(status: int, detail: Optional[str], type_base: str,
+                  error_code: Optional[str] = None, **extensions: Any) -> Dict[str, Any]:
+    """
+    Build the RFC 7807 problem details of an error
+
+    Args:
+        status: HTTP status of the response
+        detail: Explanation of this occurrence of the problem
+        type_base: Prefix of problem type URIs, e.g. /problems/
+        error_code: Machine-readable error code, kept as an extension member; the status's
+            code from STATUS_ERROR_CODES if not given
+        **extensions: Further members, e.g. the query or the clarification
+
+    Returns:
+        Problem details with type, title, status, detail and instance, a URN unique to
+        this occurrence that also identifies it in the logs
+    """
+    name = problem_type_name(status, error_code)
+    if name:
+        problem = {"type": f"{type_base}{name}", "title": PROBLEM_TYPES[name].title}
+    else:
+        problem = {"type": DEFAULT_PROBLEM_TYPE, "title": STATUS_TITLES.get(status, "Error")}
+    problem.update(status=status, detail=detail, instance=f"urn:uuid:{uuid.uuid4()}")
+    error_code = error_code or STATUS_ERROR_CODES.get(status)
+    if error_code:
+        problem["error_code"] = error_code
+    problem.update({key: value for key, value in extensions.items() if value is not None})
+    return problem
@@ -0,0 +1,108 @@
+import ipaddress
+import time
+from collections import OrderedDict
+from typing import List, Optional, Tuple, Union
+
+IPNetwork = Union[ipaddress.IPv4Network, ipaddress.IPv6Network]
+
+
+def parse_networks(items: List[str]) -> List[IPNetwork]:
+    """Parse addresses and CIDR networks, e.g. ["10.0.0.0/8", "::1"]"""
+    return [ipaddress.ip_network(item, strict=False) for item in items]
+
+
+def _is_trusted(address: Optional[str], trusted_proxies: List[IPNetwork]) -> bool:
+    try:
+        ip = ipaddress.ip_address(address or "")
+    except ValueError:
+        return False
+    return any(ip in network for network in trusted_proxies)
+
+
+def client_ip(peer: Optional[str], forwarded_for: Optional[str], trusted_proxies: List[IPNetwork]) -> str:
+    """
+    Find the address of the client behind any trusted proxies
+
+    X-Forwarded-For is only honored when the connection comes from a trusted proxy.
+    Its entries are then read from the right, each appended by the proxy before it,
+    and the first address that isn't a trusted proxy is the client. Entries further
+    left were sent by the client itself and can't be trusted.
+
+    Args:
+        peer: Address of the connection
+        forwarded_for: X-Forwarded-For header, all of its values joined with commas
+        trusted_proxies: Networks of the proxies in front of the server
+
+    Returns:
+        The client's address, or the peer when the header doesn't apply
+    """
+    client = peer or "unknown"
+    for hop in reversed((forwarded_for or "").split(",")):
+        if not _is_trusted(client, trusted_proxies):
+            break
+        try:
+            client = str(ipaddress.ip_address(hop.strip()))
+        except ValueError:
+            # A malformed entry ends the chain; the last trusted hop is as far as it can be followed
+            break
+    return client
+
+
+# Endpoints limited by class, as path prefixes: those interpreting queries with the LLM, which
+# costs money, and the cheaper MIB and OID lookups
+ENDPOINT_CLASSES = {
+    "query": ("/query", "/plan", "/execute"),
+    "mib": ("/mibs", "/oid", "/oids", "/aliases"),
+}
+
+
+def endpoint_class(path: str) -> Optional[str]:
+    """Get the ENDPOINT_CLASSES class of a request path, or None if it is in no class"""
+    for name, prefixes in ENDPOINT_CLASSES.items():
+        if any(path == prefix or path.startswith(prefix + "/") for prefix in prefixes):
+            return name
+    return None
+
+
+class RateLimiter:
+    """
+    Token bucket rate limit per client
+
+    Each client may send burst requests at once, refilled at per_minute requests a
+    minute. At most max_clients buckets are kept; the least recently seen client's
+    is dropped beyond that, which forgives it its past requests.
+    """
+
+    def __init__(self, per_minute: float, burst: int, max_clients: int):
+        self.rate = per_minute / 60
+        self.burst = max(1, burst)
+        self.max_clients = max(1, max_clients)
+        self._buckets: "OrderedDict[str, Tuple[float, float]]" = OrderedDict()
+
+    @property
+    def enabled(self) -> bool:
+        return self.rate > 0
+
+    def acquire(self, client: str, now: Optional[float] = None) -> Optional[float]:
+        """
+        Take a request from a client's bucket
+
+        Returns:
+            None if the request is allowed, or else the seconds until it would be
+        """
+        if not self.enabled:
+            return None
+        now = time.monotonic() if now is None else now
+
+        tokens, updated = self._buckets.pop(client, (float(self.burst), now))
+        tokens = min(float(self.burst), tokens + (now - updated) * self.rate)
+        retry_after = None
+        if tokens >= 1:
+            tokens -= 1
+        else:
+            retry_after = (1 - tokens) / self.rate
+
+        self._buckets[client] = (tokens, now)
+        while len(self._buckets) > self.max_clients:
+            self._buckets.popitem(last=False)
+        return retry_after
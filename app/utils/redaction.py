@@ -0,0 +1,141 @@
+import re
+from collections import OrderedDict
+from typing import Any, Dict, Iterable, List, Optional, Pattern
+
+REDACTED = "****"
+
+# Shorter values would scrub ordinary words and numbers out of every message
+MIN_SECRET_LENGTH = 4
+
+# Default community strings everybody knows; scrubbing them would only mangle ordinary words
+WELL_KNOWN_SECRETS = frozenset({"public", "private"})
+
+# Secrets seen in queries are only remembered for the most recent ones
+MAX_QUERY_SECRETS = 1000
+
+# Secret values in use (community strings, SNMPv3 passphrases, API keys), scrubbed from errors and logs
+_configured_secrets: set = set()
+_query_secrets: "OrderedDict[str, None]" = OrderedDict()
+_secrets_pattern: Optional[Pattern] = None
+
+# Credentials in JSON ("community": "public") and in free text ("community private", "password s3cret")
+DEFAULT_REDACT_PATTERNS: List[str] = [
+    r'"(?:community|community_string|auth_password|priv_password|password|api_key)"\s*:\s*"(?P<secret>[^"]*)"',
+    r"\b(?:community(?:\s+string)?|password|passphrase)\s*(?:is\s+|=\s*|:\s*)?['\"]?(?P<secret>[^\s'\",}:*][^\s'\",}]*)",
+    r"(?<!\S)-[cAX]\s+['\"]?(?P<secret>[^\s'\"]+)",
+    r"\bsk-[A-Za-z0-9_-]{8,}",
+]
+
+
+def compile_patterns(patterns: Iterable[str]) -> List[Pattern]:
+    """Compile redaction patterns, matching case-insensitively"""
+    return [re.compile(pattern, re.IGNORECASE) for pattern in patterns]
+
+
+def redact(text: str, patterns: Iterable[Pattern]) -> str:
+    """
+    Redact sensitive substrings from text.
+
+    A pattern with a "secret" group has only that group replaced, so the
+    surrounding context (e.g. the "community" key) stays readable; otherwise
+    the whole match is replaced.
+
+    Args:
+        text: Text to redact
+        patterns: Compiled redaction patterns
+
+    Returns:
+        Text with every match replaced by ****
+    """
+    def replace(match):
+        if "secret" not in match.re.groupindex or match.group("secret") is None:
+            return REDACTED
+        start, end = match.span("secret")
+        return match.group(0)[:start - match.start()] + REDACTED + match.group(0)[end - match.start():]
+
+    for pattern in patterns:
+        text = pattern.sub(replace, text)
+    return text
+
+
+def register_secret(value: Optional[str], configured: bool = False) -> None:
+    """
+    Add a secret value to the registry scrubbed from error and log messages.
+
+    Args:
+        value: Secret value, e.g. a community string; empty, very short and well-known
+            default values are ignored
+        configured: Whether the secret comes from the configuration (always kept) or a query
+    """
+    global _secrets_pattern
+    if not value or len(value) < MIN_SECRET_LENGTH or value.lower() in WELL_KNOWN_SECRETS:
+        return
+    if configured:
+        _configured_secrets.add(value)
+    else:
+        _query_secrets[value] = None
+        _query_secrets.move_to_end(value)
+        while len(_query_secrets) > MAX_QUERY_SECRETS:
+            _query_secrets.popitem(last=False)
+    _secrets_pattern = None
+
+
+def clear_query_secrets() -> None:
+    """Forget the secrets registered from queries"""
+    global _secrets_pattern
+    _query_secrets.clear()
+    _secrets_pattern = None
+
+
+def scrub_secrets(text: str) -> str:
+    """
+    Replace every registered secret value in text with ****.
+
+    A secret is only replaced as a whole token, not where it is part of a longer
+    word or number, so a secret like "1234" leaves "12345" alone.
+
+    Args:
+        text: Error or log message
+
+    Returns:
+        Text with the secrets scrubbed
+    """
+    global _secrets_pattern
+    if _secrets_pattern is None:
+        # Longest first, so a secret containing another is scrubbed whole
+        secrets = sorted(_configured_secrets | set(_query_secrets), key=len, reverse=True)
+        alternatives = "|".join(re.escape(secret) for secret in secrets)
+        # (?!) never matches, for when no secret is registered
+        _secrets_pattern = re.compile(rf"(?<![A-Za-z0-9])(?:{alternatives})(?![A-Za-z0-9])" if secrets else "(?!)")
+    return _secrets_pattern.sub(REDACTED, text)
+
+
+def scrub_detail(detail: Any) -> Any:
+    """Scrub secrets from an error detail: a string, or the strings nested in a dict or list"""
+    if isinstance(detail, str):
+        return scrub_secrets(detail)
+    if isinstance(detail, dict):
+        return {key: scrub_detail(value) for key, value in detail.items()}
+    if isinstance(detail, (list, tuple)):
+        return [scrub_detail(value) for value in detail]
+    return detail
+
+
+def scrub_error_fields(data: Dict[str, Any]) -> Dict[str, Any]:
+    """Scrub secrets from the "error" and "<oid>_error" values of SNMP response data, in place"""
+    for key, value in data.items():
+        if isinstance(value, str) and (key == "error" or key.endswith("_error")):
+            data[key] = scrub_secrets(value)
+    return data
+
+
+def scrub_log_record(record: Dict[str, Any]) -> None:
+    """Loguru patcher scrubbing secrets from every log message"""
+    record["message"] = scrub_secrets(record["message"])
+
+
+def truncate(text: str, max_chars: int) -> str:
+    """Cap text at max_chars characters, noting how much was cut"""
+    if max_chars <= 0 or len(text) <= max_chars:
+        return text
+    return f"{text[:max_chars]}... [{len(text) - max_chars} more characters]"
@@ -0,0 +1,113 @@
+import math
+import time
+from collections import OrderedDict, deque
+from datetime import datetime, timezone
+from typing import Any, Deque, Dict, List, NamedTuple, Optional
+
+from app.utils.timestamps import format_timestamp
+
+
+class Outcome(NamedTuple):
+    at: float  # time.time() when the request finished
+    latency: float  # seconds
+    error: Optional[str]
+
+
+class _Target:
+    def __init__(self, max_samples: int):
+        self.outcomes: Deque[Outcome] = deque(maxlen=max_samples)
+        self.last_error: Optional[Outcome] = None
+
+
+def percentile(values: List[float], fraction: float) -> Optional[float]:
+    """Nearest-rank percentile of sorted values, or None if there are none"""
+    if not values:
+        return None
+    return values[max(0, math.ceil(fraction * len(values)) - 1)]
+
+
+class TargetStats:
+    """
+    Rolling record of SNMP request outcomes per target
+
+    Each target keeps its outcomes of the last window seconds, at most max_samples
+    of them. At most max_targets targets are tracked: recording a new one evicts
+    the target recorded least recently, and targets with no outcome in the window
+    are dropped, so a scan of many addresses can't grow the record without bound.
+    """
+
+    def __init__(self, window: float, max_targets: int, max_samples: int):
+        self.window = window
+        self.max_targets = max(1, max_targets)
+        self.max_samples = max(1, max_samples)
+        self._targets: "OrderedDict[str, _Target]" = OrderedDict()
+
+    def record(self, target: str, latency: float, error: Optional[str] = None,
+               now: Optional[float] = None) -> None:
+        """Record how a request to a target went: its latency in seconds and its error, if it failed"""
+        now = time.time() if now is None else now
+        entry = self._targets.pop(target, None) or _Target(self.max_samples)
+        outcome = Outcome(now, latency, error)
+        entry.outcomes.append(outcome)
+        if error:
+            entry.last_error = outcome
+        self._targets[target] = entry
+
+        self._expire(now)
+        while len(self._targets) > self.max_targets:
+            self._targets.popitem(last=False)
+
+    def _expire(self, now: float) -> None:
+        """Drop outcomes older than the window, and targets left without any"""
+        cutoff = now - self.window
+        for target in list(self._targets):
+            outcomes = self._targets[target].outcomes
+            while outcomes and outcomes[0].at < cutoff:
+                outcomes.popleft()
+            if not outcomes:
+                del self._targets[target]
+
+    def stats(self, now: Optional[float] = None) -> List[Dict[str, Any]]:
+        """
+        Reliability metrics of each target over the window
+
+        Latency percentiles are of successful requests only, since failures mostly
+        take as long as the timeout. The last error is kept while the target has
+        outcomes in the window, even if later requests succeeded.
+
+        Returns:
+            One dictionary per target, least reliable first
+        """
+        now = time.time() if now is None else now
+        self._expire(now)
+
+        stats = []
+        for target, entry in self._targets.items():
+            failures = sum(1 for outcome in entry.outcomes if outcome.error)
+            latencies = sorted(outcome.latency for outcome in entry.outcomes if not outcome.error)
+            last_error = entry.last_error
+            stats.append({
+                "target": target,
+                "requests": len(entry.outcomes),
+                "successes": len(entry.outcomes) - failures,
+                "failures": failures,
+                "success_rate": round((len(entry.outcomes) - failures) / len(entry.outcomes), 4),
+                "latency_p50_ms": _milliseconds(percentile(latencies, 0.5)),
+                "latency_p95_ms": _milliseconds(percentile(latencies, 0.95)),
+                "last_error": last_error.error if last_error else None,
+                "last_error_at": _timestamp(last_error.at) if last_error else None,
+                "last_seen_at": _timestamp(entry.outcomes[-1].at),
+            })
+        return sorted(stats, key=lambda item: (item["success_rate"], item["target"]))
+
+    def clear(self) -> None:
+        """Forget every recorded outcome"""
+        self._targets.clear()
+
+
+def _milliseconds(seconds: Optional[float]) -> Optional[float]:
+    return None if seconds is None else round(seconds * 1000, 3)
+
+
+def _timestamp(at: float) -> str:
+    return format_timestamp(datetime.fromtimestamp(at, timezone.utc))
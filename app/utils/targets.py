@@ -0,0 +1,185 @@
+import ipaddress
+import re
+from typing import Dict, List, Optional, Sequence, Tuple, TypeVar
+
+DEFAULT_SNMP_PORT = 161
+
+T = TypeVar("T")
+
+_HOSTNAME_LABEL = re.compile(r"^[a-z0-9_]([a-z0-9_-]{0,61}[a-z0-9_])?$")
+
+
+class TargetError(ValueError):
+    """Raised for a target that isn't a valid host or host:port"""
+
+
+def split_target(target: str) -> Tuple[str, Optional[int]]:
+    """
+    Split a target into its normalized host and the port it names, if any.
+
+    Accepts "host", "host:port", a bare IPv6 address ("fe80::1") and a bracketed
+    IPv6 address with or without a port ("[fe80::1]:1161"). IP addresses are
+    normalized (IPv6 compressed, brackets removed) and hostnames lowercased, so
+    every spelling of a target gives the same host.
+
+    Args:
+        target: Target as given, e.g. "10.0.0.1:1161"
+
+    Returns:
+        (host, port), with port None when the target doesn't name one
+    """
+    target = target.strip() if isinstance(target, str) else ""
+    if not target:
+        raise TargetError("Target host is empty")
+
+    if target.startswith("["):
+        address, bracket, rest = target[1:].partition("]")
+        if not bracket:
+            raise TargetError(f"Missing ']' in target: {target}")
+        if rest and not rest.startswith(":"):
+            raise TargetError(f"Unexpected text after ']' in target: {target}")
+        try:
+            host = str(ipaddress.IPv6Address(address))
+        except ValueError:
+            raise TargetError(f"Invalid IPv6 address in target: {target}")
+        return host, _parse_port(rest[1:], target) if rest else None
+
+    if target.count(":") > 1:
+        try:
+            return str(ipaddress.IPv6Address(target)), None
+        except ValueError:
+            raise TargetError(f"Invalid target: {target} (give IPv6 addresses with a port as [address]:port)")
+
+    host, separator, port = target.partition(":")
+    return _normalize_host(host, target), _parse_port(port, target) if separator else None
+
+
+def parse_target(target: str, default_port: int = DEFAULT_SNMP_PORT) -> Tuple[str, int]:
+    """
+    Parse a target into its normalized host and port.
+
+    Args:
+        target: Target as given, e.g. "switch1", "10.0.0.1:1161" or "[::1]:1161"
+        default_port: Port to use when the target doesn't name one
+
+    Returns:
+        (host, port)
+    """
+    host, port = split_target(target)
+    return host, default_port if port is None else port
+
+
+def format_target(host: str, port: int) -> str:
+    """Format a host and port as host:port, bracketing IPv6 addresses"""
+    return f"[{host}]:{port}" if ":" in host else f"{host}:{port}"
+
+
+def _parse_port(port: str, target: str) -> int:
+    if not port.isdigit() or not port.isascii():
+        raise TargetError(f"Invalid port in target: {target}")
+    value = int(port)
+    if not 1 <= value <= 65535:
+        raise TargetError(f"Port out of range in target: {target} (must be 1-65535)")
+    return value
+
+
+def _normalize_host(host: str, target: str) -> str:
+    try:
+        return str(ipaddress.IPv4Address(host))
+    except ValueError:
+        pass
+
+    hostname = host.lower().rstrip(".")
+    # All-numeric names that aren't IPv4 addresses, e.g. 10.0.0.256
+    if hostname.replace(".", "").isdigit() or len(hostname) > 253 \
+            or not all(_HOSTNAME_LABEL.match(label) for label in hostname.split(".")):
+        raise TargetError(f"Invalid host in target: {target}")
+    return hostname
+
+
+# Key of the entry applying to targets no other entry matches, see match_target
+DEFAULT_TARGET = "default"
+
+
+def match_target(host: str, port: int, entries: Dict[str, T], addresses: Sequence[str] = ()) -> Optional[T]:
+    """
+    Get the entry for a target, from entries keyed by target, network or "default"
+
+    The most specific key wins: the host and port ("10.0.0.1:1161"), the host alone, the
+    smallest CIDR network containing the host's address ("10.0.0.0/24" before "10.0.0.0/8"),
+    and last DEFAULT_TARGET. Keys are expected normalized, as split_target gives them. A host
+    name only matches a network by the addresses it resolves to, which the caller looks up.
+
+    Args:
+        host: Normalized host of the target, an address or a name
+        port: Port of the target
+        entries: Target, network or "default" -> entry
+        addresses: Addresses a host name resolves to
+
+    Returns:
+        The entry, or None if no key matches and there is no default
+    """
+    for key in (format_target(host, port), host):
+        if key in entries:
+            return entries[key]
+    parsed = []
+    for address in (host, *addresses):
+        try:
+            parsed.append(ipaddress.ip_address(address))
+        except ValueError:
+            continue
+    networks = []
+    for key in entries:
+        if "/" not in key:
+            continue
+        try:
+            network = ipaddress.ip_network(key, strict=False)
+        except ValueError:
+            continue
+        if any(network.version == address.version and address in network for address in parsed):
+            networks.append((network.prefixlen, key))
+    if networks:
+        return entries[max(networks)[1]]
+    return entries.get(DEFAULT_TARGET)
+
+
+def is_address(host: str) -> bool:
+    """Check whether a host is an IP address rather than a name"""
+    try:
+        ipaddress.ip_address(host)
+    except ValueError:
+        return False
+    return True
+
+
+# Class of targets in no configured class
+UNCLASSIFIED = "unclassified"
+
+
+def target_class(host: str, classes: Dict[str, List[str]]) -> str:
+    """
+    Get the class of a target host: the first class listing the host or a network containing it
+
+    Args:
+        host: Host of the target, an address or a name
+        classes: Class -> hosts and CIDR networks, as in SNMP_TARGET_CLASSES
+
+    Returns:
+        The class, or UNCLASSIFIED
+    """
+    host = host.strip("[]").lower()
+    try:
+        address = ipaddress.ip_address(host)
+    except ValueError:
+        address = None
+    for name, members in classes.items():
+        for member in members:
+            if member == host:
+                return name
+            if address is not None and "/" in member:
+                try:
+                    if address in ipaddress.ip_network(member, strict=False):
+                        return name
+                except ValueError:
+                    continue
+    return UNCLASSIFIED
@@ -0,0 +1,103 @@
+import struct
+from datetime import datetime, timedelta, timezone, tzinfo
+from typing import Any, Optional
+from zoneinfo import ZoneInfo, ZoneInfoNotFoundError
+
+# Output formats besides a strftime pattern
+RFC3339 = "rfc3339"
+UNIX = "unix"
+
+
+def decode_date_and_time(value: Any) -> Optional[datetime]:
+    """
+    Decode a DateAndTime value (SNMPv2-TC).
+
+    The 11-octet form carries the agent's UTC offset; the 8-octet form has none
+    and is taken to be UTC.
+
+    Args:
+        value: Raw DateAndTime octets
+
+    Returns:
+        Timezone-aware datetime, or None if the value isn't a valid DateAndTime
+    """
+    if isinstance(value, str):
+        value = value.encode("latin-1")
+    if not isinstance(value, bytes) or len(value) not in (8, 11):
+        return None
+
+    year, month, day, hour, minute, second, deciseconds = struct.unpack(">HBBBBBB", value[:8])
+    offset = timezone.utc
+    if len(value) == 11:
+        direction, offset_hours, offset_minutes = chr(value[8]), value[9], value[10]
+        if direction not in "+-" or offset_hours > 14 or offset_minutes > 59:
+            return None
+        delta = timedelta(hours=offset_hours, minutes=offset_minutes)
+        offset = timezone(-delta if direction == "-" else delta)
+
+    try:
+        # Second 60 is a leap second, which datetime can't represent
+        return datetime(year, month, day, hour, minute, min(second, 59), deciseconds * 100000, tzinfo=offset)
+    except ValueError:
+        return None
+
+
+def parse_timezone(name: str) -> tzinfo:
+    """
+    Parse a timezone given as "UTC", a UTC offset ("+05:30", "-0800") or an IANA name ("Europe/Berlin").
+
+    Raises:
+        ValueError: If the timezone isn't known
+    """
+    name = name.strip()
+    if name.upper() in ("UTC", "Z"):
+        return timezone.utc
+
+    if name[:1] in "+-" and name[1:].replace(":", "").isdigit():
+        digits = name[1:].replace(":", "")
+        if len(digits) in (2, 4):
+            hours, minutes = int(digits[:2]), int(digits[2:] or 0)
+            if hours <= 14 and minutes <= 59:
+                delta = timedelta(hours=hours, minutes=minutes)
+                return timezone(-delta if name[0] == "-" else delta)
+        raise ValueError(f"Invalid UTC offset: {name}")
+
+    try:
+        return ZoneInfo(name)
+    except (ZoneInfoNotFoundError, ValueError):
+        raise ValueError(f"Unknown timezone: {name}")
+
+
+def format_timestamp(value: datetime, tz: Optional[tzinfo] = None, time_format: Optional[str] = None) -> Any:
+    """
+    Format a timestamp for a response.
+
+    Args:
+        value: Timezone-aware datetime
+        tz: Timezone to convert to, or None to keep the value's own offset
+        time_format: "rfc3339" (default), "unix" for epoch seconds, or a strftime pattern
+
+    Returns:
+        Formatted timestamp (a number for "unix")
+    """
+    if tz is not None:
+        value = value.astimezone(tz)
+
+    if not time_format or time_format.lower() == RFC3339:
+        return value.isoformat()
+    if time_format.lower() == UNIX:
+        return value.timestamp()
+    return value.strftime(time_format)
+
+
+def reformat_timestamp(value: Any, tz: Optional[tzinfo] = None, time_format: Optional[str] = None) -> Any:
+    """Reformat an RFC 3339 timestamp string, leaving anything else unchanged"""
+    if not isinstance(value, str):
+        return value
+    try:
+        parsed = datetime.fromisoformat(value)
+    except ValueError:
+        return value
+    if parsed.tzinfo is None:
+        parsed = parsed.replace(tzinfo=timezone.utc)
+    return format_timestamp(parsed, tz, time_format)
@@ -0,0 +1,30 @@
+import time
+from typing import Dict
+
+
+class StageTimer:
+    """
+    Record how long each stage of a pipeline takes.
+
+    Each mark() attributes the time since the previous mark (or since the
+    timer was created) to the named stage, so the stages add up to the total.
+    Callers create a timer only when timings are wanted and pass None
+    otherwise, so disabled timing costs a None check per stage.
+    """
+
+    def __init__(self):
+        self._started = time.perf_counter()
+        self._last = self._started
+        self._stages: Dict[str, float] = {}
+
+    def mark(self, stage: str) -> None:
+        """End a stage, adding to its time if it was already marked"""
+        now = time.perf_counter()
+        self._stages[stage] = self._stages.get(stage, 0.0) + (now - self._last)
+        self._last = now
+
+    def timings(self) -> Dict[str, float]:
+        """Milliseconds spent in each stage in the order they ran, plus the total"""
+        timings = {stage: round(seconds * 1000, 3) for stage, seconds in self._stages.items()}
+        timings["total"] = round((self._last - self._started) * 1000, 3)
+        return timings
@@ -0,0 +1,100 @@
+import math
+import operator
+import re
+from typing import Any, Dict, List, NamedTuple, Optional
+
+MAX_VALUE_FILTERS = 10
+
+COMPARISONS = {
+    ">": operator.gt,
+    "<": operator.lt,
+    ">=": operator.ge,
+    "<=": operator.le,
+    "==": operator.eq,
+    "!=": operator.ne,
+}
+
+# field, operator, value; the operator alternatives are ordered so ">=" isn't read as ">"
+_FILTER = re.compile(r"^\s*([^\s<>=!]+)\s*(>=|<=|==|!=|>|<)\s*(\S+)\s*$")
+
+
+class FilterError(ValueError):
+    """Raised for a value filter that can't be parsed"""
+
+
+class ValueFilter(NamedTuple):
+    """Keep results of a field only when their value compares to a number, e.g. ifInErrors > 0"""
+    field: str  # Object name (ifInErrors), name with index (ifInErrors.3), qualified name or numeric OID
+    operator: str  # One of COMPARISONS
+    value: float
+
+    def applies_to(self, oid: Optional[str], name: Optional[str]) -> bool:
+        """Check whether a result (by its numeric OID and qualified name) is of the filter's field"""
+        if self.field[0].isdigit() or self.field.startswith("."):
+            field = self.field.lstrip(".")
+            return bool(oid) and (oid == field or oid.startswith(field + "."))
+        if not name:
+            return False
+        short = name.split("::")[-1]
+        return any(candidate == self.field or candidate.startswith(self.field + ".") for candidate in (name, short))
+
+    def matches(self, value: Any) -> bool:
+        """Compare a value; values that aren't numbers never match"""
+        number = numeric_value(value)
+        return number is not None and COMPARISONS[self.operator](number, self.value)
+
+
+def numeric_value(value: Any) -> Optional[float]:
+    """The number a result value stands for: ints, floats and numeric strings; None for anything else"""
+    if isinstance(value, bool):
+        return None
+    if isinstance(value, (int, float)):
+        number = float(value)
+    elif isinstance(value, str):
+        try:
+            number = float(value.strip())
+        except ValueError:
+            return None
+    else:
+        return None
+    return number if math.isfinite(number) else None
+
+
+def parse_value_filters(specs: List[str]) -> List[ValueFilter]:
+    """
+    Parse value filters of the form "field<operator>number"
+
+    Args:
+        specs: Filters, e.g. ["ifInErrors>0", "ifSpeed>=1000000000"]
+
+    Returns:
+        Parsed filters, in order
+    """
+    if len(specs) > MAX_VALUE_FILTERS:
+        raise FilterError(f"More than {MAX_VALUE_FILTERS} value filters")
+
+    filters = []
+    for spec in specs:
+        match = _FILTER.match(spec)
+        if not match:
+            raise FilterError(f"Value filters must be given as field<operator>number, with one of "
+                              f"{' '.join(COMPARISONS)}: {spec}")
+        field, comparison, value = match.groups()
+        number = numeric_value(value)
+        if number is None:
+            raise FilterError(f"Value filters compare with a number, not {value}: {spec}")
+        filters.append(ValueFilter(field, comparison, number))
+    return filters
+
+
+def passes_filters(result: Dict[str, Any], filters: List[ValueFilter]) -> bool:
+    """
+    Check a result against value filters
+
+    A result passes when every filter of its field matches its value; results of
+    fields no filter names always pass.
+    """
+    return all(
+        value_filter.matches(result.get("value"))
+        for value_filter in filters if value_filter.applies_to(result.get("oid"), result.get("name"))
+    )
@@ -1,4 +1,5 @@
 #!/usr/bin/env python3
+import os
 import sys
 import argparse
 import uvicorn
@@ -15,6 +16,16 @@ def main():
     api_parser.add_argument("--host", default="127.0.0.1", help="Host to bind the server to")
     api_parser.add_argument("--port", type=int, default=8000, help="Port to bind the server to")
     api_parser.add_argument("--reload", action="store_true", help="Enable auto-reload")
+    api_parser.add_argument("--demo", action="store_true",
+                            help="Also start the SNMP simulator and point the 'demo' target at it")
+    api_parser.add_argument("--demo-data", help="snmpsim-style .snmprec file for the simulator (implies --demo)")
+
+    # SNMP simulator command
+    simulator_parser = subparsers.add_parser("simulator", help="Run the SNMP simulator on its own")
+    simulator_parser.add_argument("--host", default="127.0.0.1", help="Host to bind the simulator to")
+    simulator_parser.add_argument("--port", type=int, default=1161, help="UDP port to bind the simulator to")
+    simulator_parser.add_argument("--community", default="public", help="Community string to answer")
+    simulator_parser.add_argument("--data", help="snmpsim-style .snmprec file (built-in demo data if not given)")
 
     # CLI command
     cli_parser = subparsers.add_parser("cli", help="Run CLI commands")
@@ -23,6 +34,19 @@ def main():
     args = parser.parse_args()
 
     if args.command == "api":
+        if args.demo or args.demo_data:
+            # Read by the app's config when uvicorn imports it
+            os.environ["DEMO_MODE"] = "true"
+            if args.demo_data:
+                os.environ["DEMO_SNMPREC"] = args.demo_data
+
+        # Refuse to start with settings that would only fail on the first query
+        from app.core.config import config, ConfigError
+        try:
+            config.validate_settings()
+        except ConfigError as e:
+            sys.exit(f"Invalid configuration: {e}")
+
         # Start FastAPI server
         uvicorn.run(
             "app.api.main:app",
@@ -30,6 +54,11 @@ def main():
             port=args.port,
             reload=args.reload
         )
+    elif args.command == "simulator":
+        from app.simulator import run_simulator
+        import asyncio
+
+        asyncio.run(run_simulator(args.host, args.port, community=args.community, data_file=args.data))
     elif args.command == "cli":
         # Run CLI command
         from app.cli import main as cli_main